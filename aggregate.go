@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// ChainStats are statistics over the values of all blocks of a chain.
+// Without any values, Mean, Min, Max and StdDev are NaN.
+type ChainStats struct {
+	// Blocks counts the blocks that hold values
+	Blocks int
+	Values int
+	Mean   float64
+	Min    float64
+	Max    float64
+	// StdDev is the population standard deviation, as in the block stats
+	StdDev   float64
+	Outliers int
+}
+
+func (s ChainStats) String() string {
+	return fmt.Sprintf("%d Werte in %d Blöcken, Mittelwert %.2f, Min %.2f, Max %.2f, Standardabweichung %.2f, %d Ausreißer",
+		s.Values, s.Blocks, s.Mean, s.Min, s.Max, s.StdDev, s.Outliers)
+}
+
+// chainTotals accumulates ChainStats block by block. Blocks are merged by
+// count, mean and sum of squared deviations, which stays accurate where
+// plain sums of squares would cancel.
+type chainTotals struct {
+	blocks   int
+	values   int
+	mean     float64
+	m2       float64
+	min      float64
+	max      float64
+	outliers int
+	// outlierBlocks counts the blocks with outliers
+	outlierBlocks int
+}
+
+// add merges the values of block into the totals
+func (t *chainTotals) add(block *Block) {
+	t.outliers += block.OutlierCount()
+	if block.OutlierCount() > 0 {
+		t.outlierBlocks++
+	}
+	n, mean, m2, lo, hi := blockMoments(block)
+	if n == 0 {
+		return
+	}
+	t.mergeValues(n, mean, m2, lo, hi)
+	t.blocks++
+}
+
+// merge adds the totals of other, such as those of the blocks Compact
+// deleted
+func (t *chainTotals) merge(other chainTotals) {
+	t.outliers += other.outliers
+	t.outlierBlocks += other.outlierBlocks
+	t.blocks += other.blocks
+	if other.values > 0 {
+		t.mergeValues(other.values, other.mean, other.m2, other.min, other.max)
+	}
+}
+
+// mergeValues merges n values with the given mean, sum of squared
+// deviations, minimum and maximum into the totals
+func (t *chainTotals) mergeValues(n int, mean, m2, lo, hi float64) {
+	if t.values == 0 {
+		t.min, t.max = lo, hi
+	} else {
+		t.min, t.max = math.Min(t.min, lo), math.Max(t.max, hi)
+	}
+	total := t.values + n
+	delta := mean - t.mean
+	t.mean += delta * float64(n) / float64(total)
+	t.m2 += m2 + delta*delta*float64(t.values)*float64(n)/float64(total)
+	t.values = total
+}
+
+// totalsJSON is how chainTotals are saved
+type totalsJSON struct {
+	Blocks        int     `json:"blocks"`
+	Values        int     `json:"values"`
+	Mean          float64 `json:"mean"`
+	M2            float64 `json:"m2"`
+	Min           float64 `json:"min"`
+	Max           float64 `json:"max"`
+	Outliers      int     `json:"outliers"`
+	OutlierBlocks int     `json:"outlier_blocks"`
+}
+
+func (t chainTotals) MarshalJSON() ([]byte, error) {
+	return json.Marshal(totalsJSON{t.blocks, t.values, t.mean, t.m2, t.min, t.max, t.outliers, t.outlierBlocks})
+}
+
+func (t *chainTotals) UnmarshalJSON(data []byte) error {
+	var j totalsJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*t = chainTotals{j.Blocks, j.Values, j.Mean, j.M2, j.Min, j.Max, j.Outliers, j.OutlierBlocks}
+	return nil
+}
+
+// stats returns the totals as ChainStats
+func (t *chainTotals) stats() ChainStats {
+	stats := ChainStats{Blocks: t.blocks, Values: t.values, Outliers: t.outliers}
+	if t.values == 0 {
+		stats.Mean, stats.Min, stats.Max, stats.StdDev = math.NaN(), math.NaN(), math.NaN(), math.NaN()
+		return stats
+	}
+	stats.Mean, stats.Min, stats.Max = t.mean, t.min, t.max
+	stats.StdDev = math.Sqrt(t.m2 / float64(t.values))
+	return stats
+}
+
+// blockMoments returns the number of values of block, their mean, their
+// sum of squared deviations from it, and their minimum and maximum. A
+// sampled block no longer holds all values, so its figures come from its
+// exact stats instead, and a block whose values were dropped from those
+// stored with it.
+func blockMoments(block *Block) (n int, mean, m2, lo, hi float64) {
+	if block.ValuesDropped && block.Dropped != nil {
+		d := block.Dropped
+		return d.Count, d.Mean, d.M2, d.Min, d.Max
+	}
+	if block.Sampled {
+		sd := (block.TwoSDUpper - block.Mean) / 2
+		n = block.OriginalCount
+		return n, block.Mean, float64(n) * sd * sd, block.SampleMin, block.SampleMax
+	}
+
+	values := block.GetValues()
+	if block.Kind == KindInt {
+		values = make([]float64, len(block.IntValues))
+		for i, v := range block.IntValues {
+			values[i] = float64(v)
+		}
+	}
+	if len(values) == 0 {
+		return 0, 0, 0, 0, 0
+	}
+	lo, hi = values[0], values[0]
+	for i, v := range values {
+		delta := v - mean
+		mean += delta / float64(i+1)
+		m2 += delta * (v - mean)
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	return len(values), mean, m2, lo, hi
+}
+
+// AggregateStats returns statistics over the values of all blocks,
+// including those Prune archived and Compact deleted. They are kept up to
+// date as blocks are added, so this does not walk the chain.
+func (bc *Blockchain) AggregateStats() ChainStats {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	totals := bc.aggregateTotals()
+	return totals.stats()
+}
+
+// aggregateTotals returns the totals of the blocks held merged with those
+// Prune archived and Compact deleted. Called with bc.mu held.
+func (bc *Blockchain) aggregateTotals() chainTotals {
+	totals := bc.totals
+	if bc.archive != nil {
+		totals.merge(bc.archive.Totals)
+	}
+	if bc.expired != nil {
+		totals.merge(bc.expired.Totals)
+	}
+	return totals
+}
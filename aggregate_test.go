@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// directStats returns the ChainStats of blocks computed over all their
+// values at once
+func directStats(blocks [][]float64) ChainStats {
+	var all []float64
+	stats := ChainStats{Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, values := range blocks {
+		if len(values) > 0 {
+			stats.Blocks++
+		}
+		all = append(all, values...)
+	}
+	sum := 0.0
+	for _, v := range all {
+		sum += v
+		stats.Min, stats.Max = math.Min(stats.Min, v), math.Max(stats.Max, v)
+	}
+	stats.Values = len(all)
+	stats.Mean = sum / float64(len(all))
+	for _, v := range all {
+		stats.StdDev += (v - stats.Mean) * (v - stats.Mean)
+	}
+	stats.StdDev = math.Sqrt(stats.StdDev / float64(len(all)))
+	return stats
+}
+
+func TestAggregateStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample int
+		blocks [][]float64
+	}{
+		{"one block", 0, [][]float64{{1, 2, 3, 4}}},
+		{"blocks of different sizes", 0, [][]float64{{1}, {2, 3, 4, 5, 6}, {-10, 10}}},
+		{"large offset", 0, [][]float64{{1e9 + 1, 1e9 + 2}, {1e9 + 3, 1e9 + 4, 1e9 + 5}}},
+		{"sampled blocks", 3, [][]float64{{1, 2, 3, 4, 5, 6, 7, 8}, {10, 20}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, err := NewBlockchain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := bc.SetSampleSize(tt.sample); err != nil {
+				t.Fatal(err)
+			}
+			for _, values := range tt.blocks {
+				if _, err := bc.AddBlock(values); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, want := bc.AggregateStats(), directStats(tt.blocks)
+			if got.Blocks != want.Blocks || got.Values != want.Values || !near(got.Mean, want.Mean) ||
+				got.Min != want.Min || got.Max != want.Max || math.Abs(got.StdDev-want.StdDev) > 1e-6*math.Max(1, want.StdDev) {
+				t.Fatalf("AggregateStats are %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestAggregateStatsEmpty(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := bc.AggregateStats()
+	if stats.Blocks != 0 || stats.Values != 0 || !math.IsNaN(stats.Mean) || !math.IsNaN(stats.StdDev) {
+		t.Fatalf("stats of an empty chain are %+v, want NaN", stats)
+	}
+	if _, err := bc.AddBlock(chainTestValues[1]); err != nil {
+		t.Fatal(err)
+	}
+	if stats := bc.AggregateStats(); stats.Outliers != 1 || stats.Blocks != 1 {
+		t.Fatalf("stats after a block with an outlier are %+v", stats)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Defaults of the anomaly check of a new Blockchain, see
+// SetAnomalyDetection
+const (
+	defaultAnomalyWindow    = 20
+	defaultAnomalyThreshold = 3.0
+)
+
+// minAnomalyBaseline is the number of blocks the baseline needs before
+// blocks are scored
+const minAnomalyBaseline = 3
+
+// SetAnomalyDetection sets how new blocks are compared with the chain's
+// history, which catches a block whose values all shift together, as its
+// own SD and therefore its outliers follow them. The baseline is the
+// values of the blocks among the last window that hold values and are not
+// Anomalous themselves, so an anomaly does not drag the baseline along.
+// A lasting shift leaves too few baseline blocks in the window before
+// window blocks have passed, and the shifted blocks become the new
+// baseline from then on. A new block gets the AnomalyScore of its mean,
+// in baseline SDs from the baseline mean, and is Anomalous above
+// threshold. With fewer than minAnomalyBaseline baseline blocks nothing
+// is scored. window 0 turns the check off.
+func (bc *Blockchain) SetAnomalyDetection(window int, threshold float64) error {
+	if window < 0 {
+		return fmt.Errorf("Ungültiges Fenster für Anomalien: %d", window)
+	}
+	if !(threshold > 0) || math.IsInf(threshold, 0) {
+		return fmt.Errorf("Ungültige Schwelle für Anomalien: %v", threshold)
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.anomalyWindow = window
+	bc.anomalyThreshold = threshold
+	return nil
+}
+
+// AnomalousBlocks returns copies of the blocks marked Anomalous, in order
+func (bc *Blockchain) AnomalousBlocks() []*Block {
+	return bc.FilterBlocks(func(b *Block) bool { return b.Anomalous })
+}
+
+// scoreAnomaly sets the AnomalyScore and Anomalous of a new block against
+// the baseline of the blocks before it; the error is that of reading them.
+// Called with bc.mu held.
+func (bc *Blockchain) scoreAnomaly(block *Block) error {
+	if bc.anomalyWindow == 0 || math.IsNaN(block.Mean) {
+		return nil
+	}
+	window, err := bc.blocks(bc.held-bc.anomalyWindow, bc.held)
+	if err != nil {
+		return err
+	}
+	// the baseline weighs every block the same: the variance of its values
+	// is the mean variance within the blocks plus that of their means
+	var means, variances []float64
+	for pos := len(window) - 1; pos >= 0; pos-- {
+		prev := window[pos]
+		sd := (prev.TwoSDUpper - prev.TwoSDLower) / 4
+		if prev.Anomalous || prev.ValueCount() == 0 || math.IsNaN(prev.Mean) || math.IsNaN(sd) {
+			continue
+		}
+		means = append(means, prev.Mean)
+		variances = append(variances, sd*sd)
+	}
+	if len(means) < minAnomalyBaseline {
+		return nil
+	}
+	mean := calculateMean(means)
+	sd := math.Sqrt(calculateMean(variances) + calculateVariance(means, mean))
+
+	distance := math.Abs(block.Mean - mean)
+	switch {
+	case distance == 0:
+		block.AnomalyScore = 0
+	case sd == 0:
+		block.AnomalyScore = math.Inf(1)
+	default:
+		block.AnomalyScore = distance / sd
+	}
+	block.Anomalous = block.AnomalyScore > bc.anomalyThreshold
+	return nil
+}
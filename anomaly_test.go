@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestAnomalyDetection(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetAnomalyDetection(4, 3); err != nil {
+		t.Fatal(err)
+	}
+	normal := []float64{9, 10, 11}
+	shifted := []float64{29, 30, 31}
+	add := func(values []float64) *Block {
+		t.Helper()
+		block, err := bc.AddBlock(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return block
+	}
+
+	// too short a baseline scores nothing
+	for i := 0; i < minAnomalyBaseline; i++ {
+		if block := add(normal); block.AnomalyScore != 0 || block.Anomalous {
+			t.Fatalf("block %d without baseline has score %v", block.Index, block.AnomalyScore)
+		}
+	}
+	add(normal)
+	if block := add([]float64{10, 11, 12}); block.Anomalous || !(block.AnomalyScore > 0 && block.AnomalyScore < 3) {
+		t.Fatalf("small shift has score %v", block.AnomalyScore)
+	}
+
+	// a lasting shift is anomalous until too few baseline blocks are left
+	// in the window, and then becomes the baseline
+	var anomalous []int
+	for i := 0; i < 6; i++ {
+		if block := add(shifted); block.Anomalous {
+			anomalous = append(anomalous, i)
+		}
+	}
+	if !slices.Equal(anomalous, []int{0, 1}) {
+		t.Fatalf("shifted blocks %v are anomalous, want the first two", anomalous)
+	}
+	if block := add(shifted); block.Anomalous || block.AnomalyScore != 0 {
+		t.Fatalf("block of the new baseline has score %v", block.AnomalyScore)
+	}
+	var indexes []int
+	for _, block := range bc.AnomalousBlocks() {
+		indexes = append(indexes, block.Index)
+	}
+	if !slices.Equal(indexes, []int{6, 7}) {
+		t.Fatalf("anomalous blocks are %v, want 6 and 7", indexes)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnomalyWithoutSpread(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < minAnomalyBaseline; i++ {
+		if _, err := bc.AddBlock([]float64{5, 5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	block, err := bc.AddBlock([]float64{5.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsInf(block.AnomalyScore, 1) || !block.Anomalous {
+		t.Fatalf("shift from a baseline without spread has score %v", block.AnomalyScore)
+	}
+
+	if err := bc.SetAnomalyDetection(0, 3); err != nil {
+		t.Fatal(err)
+	}
+	if block, err = bc.AddBlock([]float64{50}); err != nil || block.Anomalous {
+		t.Fatalf("block with detection off is %+v, %v", block, err)
+	}
+	for _, threshold := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if err := bc.SetAnomalyDetection(4, threshold); err == nil {
+			t.Fatalf("threshold %v accepted", threshold)
+		}
+	}
+	if err := bc.SetAnomalyDetection(-1, 3); err == nil {
+		t.Fatal("negative window accepted")
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultExportTTL is how long GET /export keeps a snapshot for resumed
+// downloads unless WithExportTTL sets another
+const defaultExportTTL = time.Hour
+
+// exportTokenHeader carries the token of the snapshot a GET /export
+// response is taken from
+const exportTokenHeader = "X-Export-Token"
+
+// errRangeDone stops the encoding of an export once a range is written
+var errRangeDone = errors.New("Bereich geschrieben")
+
+// exportSnapshot is the state of the chain a download of GET /export reads.
+// Blocks are immutable, so encoding them again gives the same bytes.
+type exportSnapshot struct {
+	blocks  []*Block
+	expires time.Time
+
+	sizeOnce sync.Once
+	size     int64
+	sizeErr  error
+}
+
+// totalSize returns the length of the export in bytes, encoding it once
+func (s *exportSnapshot) totalSize() (int64, error) {
+	s.sizeOnce.Do(func() {
+		counter := &countingWriter{}
+		s.sizeErr = writeNDJSON(counter, s.blocks, nil)
+		s.size = counter.n
+	})
+	return s.size, s.sizeErr
+}
+
+// exportStore keeps the snapshots of GET /export by token until they expire
+type exportStore struct {
+	ttl   time.Duration
+	clock Clock
+
+	mu        sync.Mutex
+	snapshots map[string]*exportSnapshot
+}
+
+func newExportStore(ttl time.Duration, clock Clock) *exportStore {
+	return &exportStore{ttl: ttl, clock: clock, snapshots: make(map[string]*exportSnapshot)}
+}
+
+// create keeps blocks as a new snapshot and returns its token. Expired
+// snapshots are dropped on the way.
+func (s *exportStore) create(blocks []*Block) (string, *exportSnapshot, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(id[:])
+	now := s.clock.Now()
+	snapshot := &exportSnapshot{blocks: blocks, expires: now.Add(s.ttl)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, snapshot := range s.snapshots {
+		if !now.Before(snapshot.expires) {
+			delete(s.snapshots, token)
+		}
+	}
+	s.snapshots[token] = snapshot
+	return token, snapshot, nil
+}
+
+// lookup returns the snapshot of token, or nil once it expired
+func (s *exportStore) lookup(token string) *exportSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := s.snapshots[token]
+	if snapshot != nil && !s.clock.Now().Before(snapshot.expires) {
+		delete(s.snapshots, token)
+		return nil
+	}
+	return snapshot
+}
+
+// serveExport implements GET /export?token=…: the chain as ExportNDJSON
+// writes it, streamed. A request without a token takes a new snapshot;
+// the response names it in exportTokenHeader, and requests with the token
+// and a Range header get byte ranges of the very same content until it
+// expires, after which they get 410.
+func (s *exportStore) serveExport(bc *Blockchain, w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	var snapshot *exportSnapshot
+	if token == "" {
+		var err error
+		if token, snapshot, err = s.create(bc.exportSnapshot()); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+	} else if snapshot = s.lookup(token); snapshot == nil {
+		writeAPIError(w, http.StatusGone, fmt.Errorf("Export %s ist abgelaufen oder unbekannt; starten Sie den Download ohne token neu", token))
+		return
+	}
+
+	header := w.Header()
+	header.Set(exportTokenHeader, token)
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Expires", snapshot.expires.UTC().Format(http.TimeFormat))
+	spec := r.Header.Get("Range")
+	if spec == "" {
+		header.Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		// without a length the response is sent chunked as it is encoded
+		if err := writeNDJSON(w, snapshot.blocks, nil); err != nil {
+			log.Println("Export abgebrochen:", err)
+		}
+		return
+	}
+
+	size, err := snapshot.totalSize()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	start, end, err := parseByteRange(spec, size)
+	if err != nil {
+		header.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		writeAPIError(w, http.StatusRequestedRangeNotSatisfiable, err)
+		return
+	}
+	header.Set("Content-Type", "application/x-ndjson")
+	header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	header.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	ranged := &rangeWriter{w: w, skip: start, remaining: end - start + 1}
+	if err := writeNDJSON(ranged, snapshot.blocks, nil); err != nil && !errors.Is(err, errRangeDone) {
+		log.Println("Export abgebrochen:", err)
+	}
+}
+
+// parseByteRange parses a Range header of a single byte range, such as
+// bytes=0-499, bytes=500- or bytes=-500, into the first and last byte it
+// covers of size bytes
+func parseByteRange(spec string, size int64) (start, end int64, err error) {
+	invalid := fmt.Errorf("Ungültiger Bereich: %s (erlaubt ist ein Bereich in bytes bis %d)", spec, size)
+	first, last, ok := strings.Cut(strings.TrimPrefix(spec, "bytes="), "-")
+	if !ok || !strings.HasPrefix(spec, "bytes=") || strings.Contains(last, ",") {
+		return 0, 0, invalid
+	}
+	if first == "" {
+		// a suffix of the last bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, invalid
+		}
+		return max(size-n, 0), size - 1, nil
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 || start >= size {
+		return 0, 0, invalid
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, invalid
+		}
+		end = min(end, size-1)
+	}
+	return start, end, nil
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// rangeWriter passes on the bytes after the first skip, up to remaining,
+// and then fails with errRangeDone
+type rangeWriter struct {
+	w         io.Writer
+	skip      int64
+	remaining int64
+}
+
+func (r *rangeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if r.skip >= int64(len(p)) {
+		r.skip -= int64(len(p))
+		return n, nil
+	}
+	p = p[r.skip:]
+	r.skip = 0
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	if _, err := r.w.Write(p); err != nil {
+		return 0, err
+	}
+	if r.remaining -= int64(len(p)); r.remaining == 0 {
+		return n, errRangeDone
+	}
+	return n, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// getExport sends GET target to server with a Range header, if not
+// empty, and returns the response with its body read
+func getExport(t *testing.T, server *httptest.Server, target, byteRange string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest("GET", server.URL+target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestAPIExportResumes(t *testing.T) {
+	bc, err := LoadDemoChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	if err := bc.ExportNDJSON(&want, nil); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewAPIHandler(bc, NewDefaultPipeline(bc)))
+	defer server.Close()
+
+	resp, full := getExport(t, server, "/export", "")
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(full, want.Bytes()) {
+		t.Fatalf("full download has status %d and %d bytes, want 200 and the %d of ExportNDJSON", resp.StatusCode, len(full), want.Len())
+	}
+	if !slices.Contains(resp.TransferEncoding, "chunked") || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("full download was sent with %v and Accept-Ranges %q, want chunked with bytes", resp.TransferEncoding, resp.Header.Get("Accept-Ranges"))
+	}
+	token := resp.Header.Get(exportTokenHeader)
+	if token == "" {
+		t.Fatal("full download names no export token")
+	}
+
+	// the chain grows before the download resumes, the snapshot does not
+	if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	cut := len(full) / 3
+	first, head := getExport(t, server, "/export?token="+token, "bytes=0-"+strconv.Itoa(cut-1))
+	second, tail := getExport(t, server, "/export?token="+token, "bytes="+strconv.Itoa(cut)+"-")
+	for _, resp := range []*http.Response{first, second} {
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Fatalf("ranged download has status %d, want 206", resp.StatusCode)
+		}
+	}
+	if got, want := second.Header.Get("Content-Range"), "bytes "+strconv.Itoa(cut)+"-"+strconv.Itoa(len(full)-1)+"/"+strconv.Itoa(len(full)); got != want {
+		t.Fatalf("Content-Range is %q, want %q", got, want)
+	}
+	if resumed := append(head, tail...); !bytes.Equal(resumed, full) {
+		t.Fatalf("two ranges give %d bytes, differing from the %d of the full download", len(resumed), len(full))
+	}
+
+	// a suffix range ends where the full download does
+	if resp, last := getExport(t, server, "/export?token="+token, "bytes=-100"); resp.StatusCode != http.StatusPartialContent || !bytes.Equal(last, full[len(full)-100:]) {
+		t.Fatalf("suffix range has status %d and differs from the end of the full download", resp.StatusCode)
+	}
+}
+
+func TestAPIExportTokenExpires(t *testing.T) {
+	bc := newFilledChain(t)
+	clock := &testClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	handler := NewAPIHandler(bc, NewDefaultPipeline(bc), WithExportTTL(time.Minute), WithAPIClock(clock))
+
+	rec := serve(handler, "GET", "/export", "")
+	token := rec.Header().Get(exportTokenHeader)
+	clock.now = clock.now.Add(59 * time.Second)
+	if rec := serve(handler, "GET", "/export?token="+token, ""); rec.Code != http.StatusOK {
+		t.Fatalf("export within its TTL has status %d, want 200", rec.Code)
+	}
+	clock.now = clock.now.Add(time.Second)
+	expectAPIError(t, serve(handler, "GET", "/export?token="+token, ""), http.StatusGone)
+	expectAPIError(t, serve(handler, "GET", "/export?token=unbekannt", ""), http.StatusGone)
+}
+
+func TestAPIExportInvalidRange(t *testing.T) {
+	_, handler := newTestAPI(t)
+	rec := serve(handler, "GET", "/export", "")
+	size, token := rec.Body.Len(), rec.Header().Get(exportTokenHeader)
+	for _, spec := range []string{"bytes=x-", "bytes=5-2", "bytes=0-1,4-5", "items=0-1", "bytes=" + strconv.Itoa(size) + "-", "bytes=-0"} {
+		t.Run(spec, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/export?token="+token, nil)
+			req.Header.Set("Range", spec)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			expectAPIError(t, rec, http.StatusRequestedRangeNotSatisfiable)
+			if got, want := rec.Header().Get("Content-Range"), "bytes */"+strconv.Itoa(size); got != want {
+				t.Fatalf("Content-Range is %q, want %q", got, want)
+			}
+		})
+	}
+}
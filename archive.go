@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrBlockArchived is returned for blocks that Prune moved to the archive.
+// LoadArchivedBlock reads them back.
+var ErrBlockArchived = errors.New("Block wurde archiviert")
+
+// archiveRef locates the blocks a chain pruned. LastHash is the hash of
+// the newest archived block, which the oldest block held links to, and
+// Totals are the totals of all archived blocks, which AggregateStats still
+// covers.
+type archiveRef struct {
+	Path      string      `json:"path"`
+	LastIndex int         `json:"last_index"`
+	LastHash  string      `json:"last_hash"`
+	Totals    chainTotals `json:"totals"`
+}
+
+// Prune moves all but the newest keepLast blocks to the archive at
+// archivePath and deletes them from the chain, its storage and its block
+// log, if any. The archive is a chain file as written by SaveToFile;
+// blocks pruned later are added to it, so a chain keeps a single archive.
+// Validate checks that the oldest block held links to the newest archived
+// one. Checkpoint and AggregateStats still cover the pruned blocks, while
+// the memory estimate covers the blocks held only. Once Compact deleted
+// blocks after the archive, it cannot be continued.
+func (bc *Blockchain) Prune(keepLast int, archivePath string) (pruned int, err error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.prune(keepLast, archivePath)
+}
+
+// pruneCut returns the number of blocks Prune would move to the archive
+// at archivePath, or why it cannot. Called with bc.mu held.
+func (bc *Blockchain) pruneCut(keepLast int, archivePath string) (int, error) {
+	if keepLast < 1 {
+		return 0, fmt.Errorf("Mindestens ein Block muss erhalten bleiben, nicht %d", keepLast)
+	}
+	if bc.stub {
+		return 0, fmt.Errorf("%w: vor dem Kürzen muss der Verlauf nachgereicht werden", ErrHistoryUnavailable)
+	}
+	if bc.archive != nil && bc.archive.Path != archivePath {
+		return 0, fmt.Errorf("Blockchain wird bereits in %s archiviert", bc.archive.Path)
+	}
+	if bc.archive != nil && bc.expired != nil && bc.expired.LastIndex > bc.archive.LastIndex {
+		return 0, fmt.Errorf("%w: Blöcke nach dem Archiv %s wurden bereits gelöscht", ErrBlockExpired, bc.archive.Path)
+	}
+	return max(bc.held-keepLast, 0), nil
+}
+
+// prune implements Prune. Called with bc.mu held.
+func (bc *Blockchain) prune(keepLast int, archivePath string) (int, error) {
+	cut, err := bc.pruneCut(keepLast, archivePath)
+	if err != nil || cut == 0 {
+		return 0, err
+	}
+	cutOff, err := bc.blocks(0, cut)
+	if err != nil {
+		return 0, err
+	}
+	last := cutOff[cut-1]
+	checkpoint := bc.checkpoint
+	if checkpoint == nil || checkpoint.Index < last.Index {
+		if checkpoint, err = bc.checkpointThrough(cut - 1); err != nil {
+			return 0, err
+		}
+	}
+
+	var archived []*Block
+	if bc.archive != nil {
+		if archived, err = readArchive(archivePath); err != nil {
+			return 0, err
+		}
+		if last := archived[len(archived)-1]; last.Index != bc.archive.LastIndex || last.Hash != bc.archive.LastHash {
+			return 0, fmt.Errorf("%w: Archiv %s endet nicht bei Block %d", ErrChainInvalid, archivePath, bc.archive.LastIndex)
+		}
+	}
+	archived = append(archived, cutOff...)
+	data, err := json.Marshal(chainFile{
+		Version:   chainFileVersion,
+		Name:      bc.name,
+		Genesis:   bc.genesis,
+		ValueKind: bc.valueKind,
+		IDScheme:  bc.idScheme,
+		Blocks:    archived,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Archiv konnte nicht geschrieben werden: %w", err)
+	}
+	if err := writeCodecFile(archivePath, data, bc.codec); err != nil {
+		return 0, err
+	}
+
+	state := bc.state()
+	state.Checkpoint = checkpoint
+	// the archived blocks still count in AggregateStats
+	ref := &archiveRef{Path: archivePath, LastIndex: last.Index, LastHash: last.Hash}
+	if bc.archive != nil {
+		ref.Totals = bc.archive.Totals
+	}
+	for _, block := range cutOff {
+		ref.Totals.add(block)
+	}
+	state.Archive = ref
+	if err := bc.rewriteChain(bc.base, last.Index+1, nil, state); err != nil {
+		return 0, err
+	}
+	return cut, nil
+}
+
+// LoadArchivedBlock reads the block with the given index back from the
+// archive of a pruned chain. The archive is checked from that block up to
+// the oldest block held, so the block is known to belong to the chain.
+func (bc *Blockchain) LoadArchivedBlock(index int) (*Block, error) {
+	bc.mu.RLock()
+	ref := bc.archive
+	bc.mu.RUnlock()
+	if ref == nil || index < 0 || index > ref.LastIndex {
+		return nil, fmt.Errorf("%w: Block %d ist nicht archiviert", ErrBlockNotFound, index)
+	}
+
+	blocks, err := readArchive(ref.Path)
+	if err != nil {
+		return nil, err
+	}
+	pos := index - blocks[0].Index
+	if pos < 0 || pos >= len(blocks) {
+		return nil, fmt.Errorf("%w: Block %d fehlt im Archiv %s", ErrBlockNotFound, index, ref.Path)
+	}
+	if last := blocks[len(blocks)-1]; last.Index != ref.LastIndex {
+		return nil, fmt.Errorf("%w: Archiv %s endet bei Block %d statt %d", ErrChainInvalid, ref.Path, last.Index, ref.LastIndex)
+	}
+	for i := pos; i < len(blocks); i++ {
+		next := ref.LastHash
+		if i+1 < len(blocks) {
+			next = blocks[i+1].PrevHash
+		}
+		if calculateHash(blocks[i]) != next {
+			return nil, &ValidationError{Index: blocks[i].Index, Reason: fmt.Sprintf("Archivierter Block passt nicht zur Blockchain (%s)", ref.Path)}
+		}
+	}
+	return blocks[pos], nil
+}
+
+// readArchive reads the blocks of an archive written by Prune, with any
+// codec or none
+func readArchive(path string) ([]*Block, error) {
+	data, err := readCodecFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file chainFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("Archiv %s: %w", path, err)
+	}
+	if file.Version != chainFileVersion {
+		return nil, fmt.Errorf("%w: %s hat Version %d", ErrUnsupportedChainFile, path, file.Version)
+	}
+	if len(file.Blocks) == 0 {
+		return nil, fmt.Errorf("Archiv %s enthält keine Blöcke", path)
+	}
+	return file.Blocks, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPruneKeepsHead(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chain.log")
+	bc, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := bc.AddBlock([]float64{float64(i), float64(i + 1), float64(i + 2)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	head := bc.HeadHash()
+
+	archive := filepath.Join(dir, "archive.json")
+	if pruned, err := bc.Prune(2, archive); err != nil || pruned != 9 {
+		t.Fatalf("Prune returned %d, %v, want 9 blocks archived", pruned, err)
+	}
+	if bc.HistoryStart() != 9 || bc.HeadHash() != head {
+		t.Fatalf("pruned chain holds blocks from %d with head %s", bc.HistoryStart(), bc.HeadHash())
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks pruned later join the same archive
+	if _, err := bc.AddBlock([]float64{4, 5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Prune(1, archive); err != nil {
+		t.Fatal(err)
+	}
+	if bc.HistoryStart() != 11 || bc.Length() != 1 {
+		t.Fatalf("chain pruned twice holds %d blocks from %d", bc.Length(), bc.HistoryStart())
+	}
+	head = bc.HeadHash()
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if resumed.HistoryStart() != 11 || resumed.HeadHash() != head {
+		t.Fatalf("reopened chain holds blocks from %d with head %s, want 11 and %s", resumed.HistoryStart(), resumed.HeadHash(), head)
+	}
+	if err := resumed.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPruneKeepsCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chain.log")
+	bc, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := bc.AddBlock([]float64{float64(i), float64(i + 1), float64(i + 2)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := bc.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "archive.json")
+	if pruned, err := bc.Prune(2, archive); err != nil || pruned != 9 {
+		t.Fatalf("Prune returned %d, %v, want 9 blocks archived", pruned, err)
+	}
+	after, err := bc.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Blocks != before.Blocks || after.Values != before.Values || after.CumulativeHash != before.CumulativeHash {
+		t.Fatalf("checkpoint after pruning is %+v, want %+v", after, before)
+	}
+
+	// blocks pruned later still count
+	if _, err := bc.AddBlock([]float64{4, 5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Prune(1, archive); err != nil {
+		t.Fatal(err)
+	}
+	after, err = bc.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Blocks != before.Blocks+1 || after.Values != before.Values+3 {
+		t.Fatalf("checkpoint after pruning twice is %+v, from %+v", after, before)
+	}
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	reopened, err := resumed.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.CumulativeHash != after.CumulativeHash || reopened.Values != after.Values {
+		t.Fatalf("checkpoint after reopening is %+v, want %+v", reopened, after)
+	}
+}
+
+func TestPruneKeepsAggregateStats(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chain.log")
+	bc, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := bc.AddBlock([]float64{float64(i), float64(i + 1), float64(i + 2)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := bc.AggregateStats()
+	if stats.Values != 30 || stats.Blocks != 10 {
+		t.Fatalf("AggregateStats before pruning are %v, want 30 values in 10 blocks", stats)
+	}
+
+	archive := filepath.Join(dir, "archive.json")
+	if pruned, err := bc.Prune(2, archive); err != nil || pruned != 9 {
+		t.Fatalf("Prune returned %d, %v, want 9 blocks archived", pruned, err)
+	}
+	if got := bc.AggregateStats(); !sameStats(got, stats) {
+		t.Fatalf("AggregateStats after pruning are %v, want %v", got, stats)
+	}
+
+	// blocks pruned later add to the archived totals
+	if _, err := bc.AddBlock([]float64{4, 5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Prune(1, archive); err != nil {
+		t.Fatal(err)
+	}
+	stats = bc.AggregateStats()
+	if stats.Values != 33 || stats.Blocks != 11 {
+		t.Fatalf("AggregateStats after pruning twice are %v, want 33 values in 11 blocks", stats)
+	}
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if got := resumed.AggregateStats(); !sameStats(got, stats) {
+		t.Fatalf("AggregateStats after reopening are %v, want %v", got, stats)
+	}
+}
+
+func TestLoadArchivedBlock(t *testing.T) {
+	bc := newFilledChain(t)
+	archive := filepath.Join(t.TempDir(), "archive.json")
+	if _, err := bc.Prune(3, archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("pruned chain does not validate: %v", err)
+	}
+	base := bc.HistoryStart()
+	if _, err := bc.BlockByIndex(base - 1); !errors.Is(err, ErrBlockArchived) {
+		t.Fatalf("lookup of an archived block returned %v, want ErrBlockArchived", err)
+	}
+
+	// every archived block comes back and links to the next one
+	next, err := bc.BlockByIndex(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index := base - 1; index >= 0; index-- {
+		block, err := bc.LoadArchivedBlock(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Index != index || calculateHash(block) != next.PrevHash {
+			t.Fatalf("archived block %d does not link to block %d", block.Index, next.Index)
+		}
+		next = block
+	}
+	for _, index := range []int{-1, base} {
+		if _, err := bc.LoadArchivedBlock(index); !errors.Is(err, ErrBlockNotFound) {
+			t.Fatalf("LoadArchivedBlock(%d) returned %v, want ErrBlockNotFound", index, err)
+		}
+	}
+
+	// a block edited in the archive is refused
+	blocks, err := readArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks[1].Values[0]++
+	data, err := json.Marshal(chainFile{Version: chainFileVersion, Blocks: blocks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archive, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var invalid *ValidationError
+	if _, err := bc.LoadArchivedBlock(1); !errors.As(err, &invalid) || invalid.Index != 1 {
+		t.Fatalf("LoadArchivedBlock of an edited block returned %v, want a ValidationError for block 1", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// auditTailLines is the number of log lines a report shows
+const auditTailLines = 20
+
+// auditFileTail is how much of the end of a log file loadAuditTail reads
+const auditFileTail = 64 << 10
+
+// AuditTail keeps the last lines written to it. The program's log is its
+// audit log: confirmed and undone operations, configuration reloads,
+// bounds changes, vacuums and replays are all logged, and auditLog keeps
+// the latest of them for reports.
+type AuditTail struct {
+	mu      sync.Mutex
+	size    int
+	lines   []string
+	partial []byte
+}
+
+// NewAuditTail returns an AuditTail keeping size lines
+func NewAuditTail(size int) *AuditTail {
+	return &AuditTail{size: size}
+}
+
+// auditLog receives everything the program logs, see main
+var auditLog = NewAuditTail(auditTailLines)
+
+// Write adds the complete lines of p; the rest is kept until its line is
+// completed by a later write
+func (a *AuditTail) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.partial = append(a.partial, p...)
+	for {
+		end := bytes.IndexByte(a.partial, '\n')
+		if end < 0 {
+			break
+		}
+		if line := strings.TrimRight(string(a.partial[:end]), "\r"); line != "" {
+			a.lines = append(a.lines, line)
+		}
+		a.partial = a.partial[end+1:]
+	}
+	if over := len(a.lines) - a.size; over > 0 {
+		a.lines = append([]string(nil), a.lines[over:]...)
+	}
+	return len(p), nil
+}
+
+// Lines returns the lines kept, oldest first
+func (a *AuditTail) Lines() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.lines...)
+}
+
+// loadAuditTail adds the last lines of the log file at path to auditLog,
+// for a report written by another process than the one that logged
+func loadAuditTail(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	offset := max(0, info.Size()-auditFileTail)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		// the first line read is cut off
+		_, data, _ = bytes.Cut(data, []byte("\n"))
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	_, err = auditLog.Write(data)
+	return err
+}
@@ -0,0 +1,52 @@
+package main
+
+// AddValues buffers values and adds a block whenever as many values as
+// set with WithMaxValuesPerBlock have been collected. Values short of a
+// full block stay buffered until the next call or Flush. If the chain
+// rejects a block, its values are dropped, the remaining values stay
+// buffered and the error is returned.
+func (bc *Blockchain) AddValues(values []float64) error {
+	bc.bufferMu.Lock()
+	defer bc.bufferMu.Unlock()
+
+	if bc.maxValuesPerBlock == 0 {
+		if len(values) == 0 {
+			return nil
+		}
+		_, err := bc.AddBlock(values)
+		return err
+	}
+
+	bc.buffer = append(bc.buffer, values...)
+	for len(bc.buffer) >= bc.maxValuesPerBlock {
+		block := bc.buffer[:bc.maxValuesPerBlock:bc.maxValuesPerBlock]
+		bc.buffer = bc.buffer[bc.maxValuesPerBlock:]
+		if _, err := bc.AddBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush adds the values buffered by AddValues as a final, possibly short,
+// block. It does nothing if the buffer is empty.
+func (bc *Blockchain) Flush() error {
+	bc.bufferMu.Lock()
+	defer bc.bufferMu.Unlock()
+
+	if len(bc.buffer) == 0 {
+		return nil
+	}
+	block := bc.buffer
+	bc.buffer = nil
+	_, err := bc.AddBlock(block)
+	return err
+}
+
+// Buffered returns the number of values AddValues holds that are not in a
+// block yet
+func (bc *Blockchain) Buffered() int {
+	bc.bufferMu.Lock()
+	defer bc.bufferMu.Unlock()
+	return len(bc.buffer)
+}
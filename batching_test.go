@@ -0,0 +1,111 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// blockValues returns the values of every block after genesis
+func blockValues(bc *Blockchain) [][]float64 {
+	var values [][]float64
+	for _, block := range bc.Blocks()[1:] {
+		values = append(values, block.Values)
+	}
+	return values
+}
+
+func TestAddValuesCutsBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		calls    [][]float64
+		blocks   [][]float64
+		buffered int
+	}{
+		{
+			name:     "short of a block",
+			max:      3,
+			calls:    [][]float64{{1, 2}},
+			buffered: 2,
+		},
+		{
+			name:     "exactly a block",
+			max:      3,
+			calls:    [][]float64{{1, 2, 3}},
+			blocks:   [][]float64{{1, 2, 3}},
+			buffered: 0,
+		},
+		{
+			name:     "across calls",
+			max:      3,
+			calls:    [][]float64{{1, 2}, {3, 4, 5, 6, 7}, nil},
+			blocks:   [][]float64{{1, 2, 3}, {4, 5, 6}},
+			buffered: 1,
+		},
+		{
+			name:   "without a block size",
+			calls:  [][]float64{{1, 2}, nil, {3}},
+			blocks: [][]float64{{1, 2}, {3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, err := NewBlockchain(WithMaxValuesPerBlock(tt.max))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, values := range tt.calls {
+				if err := bc.AddValues(values); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := blockValues(bc); !slices.EqualFunc(got, tt.blocks, slices.Equal) {
+				t.Fatalf("blocks are %v, want %v", got, tt.blocks)
+			}
+			if bc.Buffered() != tt.buffered {
+				t.Fatalf("%d values buffered, want %d", bc.Buffered(), tt.buffered)
+			}
+
+			// Flush cuts the rest into a short block, and a second Flush
+			// adds nothing
+			want := len(tt.blocks)
+			if tt.buffered > 0 {
+				want++
+			}
+			for range 2 {
+				if err := bc.Flush(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := blockValues(bc); len(got) != want || bc.Buffered() != 0 {
+				t.Fatalf("after Flush the blocks are %v with %d values buffered", got, bc.Buffered())
+			}
+		})
+	}
+}
+
+func TestAddValuesRejectedBlock(t *testing.T) {
+	bc, err := NewBlockchain(WithMaxValuesPerBlock(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetBounds(&ValueBounds{Min: 0, Max: 5, Policy: BoundsReject}); err != nil {
+		t.Fatal(err)
+	}
+	// the rejected block's values are dropped, the rest stay buffered
+	if err := bc.AddValues([]float64{1, 10, 3, 4, 5}); err == nil {
+		t.Fatal("block outside the bounds accepted")
+	}
+	if bc.Buffered() != 3 {
+		t.Fatalf("%d values buffered after the rejected block, want 3", bc.Buffered())
+	}
+	if err := bc.AddValues(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := blockValues(bc); !slices.EqualFunc(got, [][]float64{{3, 4}, {5}}, slices.Equal) {
+		t.Fatalf("blocks are %v", got)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+)
+
+// logMagic starts every block log; its last byte is the format version
+const logMagic = "BDSL\x01"
+
+// maxLogRecord bounds the size of one record, so a corrupt length cannot
+// make recovery allocate without limit
+const maxLogRecord = 256 << 20
+
+// logTable is the CRC-32 table the records are checksummed with
+var logTable = crc32.MakeTable(crc32.Castagnoli)
+
+// blockLog is an append-only file of blocks. Each record is the length
+// and CRC-32C of its payload, 4 bytes big endian each, followed by the
+// payload: the block encoded with gob on its own, so that every record
+// can be decoded without the ones before it. The first record is the
+// chainState encoded as JSON; it is written when the log is created or
+// rewritten, which is how Prune and Compact change it. The block records
+// are compressed with the codec the state names, if any. index is its
+// index file, nil if it could not be written.
+type blockLog struct {
+	file  *os.File
+	path  string
+	codec string
+	index *logIndex
+}
+
+// append writes block as one record and syncs the file, so the block is
+// on disk before it becomes part of the chain. It returns the offset the
+// record starts at, which truncate takes to remove it again. A record
+// that fails to be written is truncated away, so the next one does not
+// follow a torn record.
+func (l *blockLog) append(block *Block) (int64, error) {
+	if l.file == nil {
+		return 0, errors.New("Blockprotokoll ist geschlossen")
+	}
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(block); err != nil {
+		return 0, err
+	}
+	if l.codec == "" {
+		return l.appendRecord(payload.Bytes())
+	}
+	data, err := compressRecord(l.codec, payload.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return l.appendRecord(data)
+}
+
+// appendRecord writes payload as one record, see append
+func (l *blockLog) appendRecord(payload []byte) (int64, error) {
+	record := frameRecord(payload)
+	offset, err := l.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := l.file.Write(record); err != nil {
+		if undo := l.truncate(offset); undo != nil {
+			log.Printf("Blockprotokoll %s: unvollständiger Eintrag konnte nicht entfernt werden: %v", l.path, undo)
+		}
+		return 0, err
+	}
+	return offset, l.file.Sync()
+}
+
+// truncate cuts the log back to size, removing the records appended from
+// there on
+func (l *blockLog) truncate(size int64) error {
+	if err := l.file.Truncate(size); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// openBlockLog creates the log at path for a new chain and writes the
+// chain's state and blocks to it. An existing file is replaced.
+func openBlockLog(path string, state *chainState, blocks []*Block) (*blockLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l := &blockLog{file: file, path: path, codec: state.Codec}
+	if _, err := file.WriteString(logMagic); err != nil {
+		file.Close()
+		return nil, err
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		_, err = l.appendRecord(data)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	for _, block := range blocks {
+		if _, err := l.append(block); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// rewrite replaces the log with one holding state and blocks. The new log
+// is written next to the old one and renamed over it, so a failure leaves
+// the old log in place.
+func (l *blockLog) rewrite(state *chainState, blocks []*Block) error {
+	if l.file == nil {
+		return errors.New("Blockprotokoll ist geschlossen")
+	}
+	tmp := l.path + ".tmp"
+	next, err := openBlockLog(tmp, state, blocks)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		next.file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	l.file.Close()
+	l.file, l.codec = next.file, next.codec
+	l.resetIndex(blocks)
+	if len(blocks) > 0 {
+		l.recordHead(blocks[len(blocks)-1], false)
+	}
+	return nil
+}
+
+// RecoverFromLog restores a chain from the block log at path and keeps
+// appending new blocks to it. A record cut short by a crash at the end of
+// the log is truncated away; a damaged record before the end is an error.
+// The chain is validated like one loaded with LoadBlockchainFromFile, and
+// compared with the last known good head of the log, see Recovery.
+// Settings such as limits and rules are not logged; they come from the
+// configuration.
+func RecoverFromLog(path string) (*Blockchain, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, recordErr := readHeadRecord(path)
+	entries, indexSize, indexErr := readLogIndex(path + logIndexSuffix)
+	state, blocks, valid, err := readBlockLog(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Blockprotokoll %s: %w", path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	torn := info.Size() - valid
+	if len(blocks) == 0 {
+		// nothing but a torn start: begin a new chain
+		file.Close()
+		bc := newBlockchain(ChainConfig{})
+		if bc.log, err = openBlockLog(path, bc.state(), []*Block{bc.head}); err != nil {
+			return nil, err
+		}
+		bc.log.resetIndex([]*Block{bc.head})
+		bc.setRecovery(newRecoveryReport(bc, path, 0, torn, record, recordErr))
+		bc.log.recordHead(bc.head, false)
+		return bc, nil
+	}
+
+	bc, used, err := chainFromIndexedStorage(&memoryStorage{blocks: blocks, state: state}, entries)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Blockprotokoll %s: %w", path, err)
+	}
+
+	if torn > 0 {
+		log.Printf("Blockprotokoll %s: unvollständigen Eintrag am Ende entfernt (%d Bytes)", path, torn)
+		err = file.Truncate(valid)
+	}
+	if err == nil {
+		_, err = file.Seek(valid, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	bc.log = &blockLog{file: file, path: path, codec: bc.codec}
+	bc.log.resumeIndex(blocks, used, indexSize, indexErr)
+	bc.setRecovery(newRecoveryReport(bc, path, len(blocks), torn, record, recordErr))
+	bc.log.recordHead(bc.head, false)
+	return bc, nil
+}
+
+// chainFromStorage returns the validated chain held by storage, such as
+// the blocks read back from a block log, with the state stored with them.
+// Neither a log nor a Storage records forks; without a stored state the
+// chain is named by its genesis.
+func chainFromStorage(storage Storage) (*Blockchain, error) {
+	bc, _, err := chainFromIndexedStorage(storage, nil)
+	return bc, err
+}
+
+// chainFromIndexedStorage is chainFromStorage taking the ID and hash
+// indexes from the entries of an index file, see reindexFrom, and
+// returning how many of them it took
+func chainFromIndexedStorage(storage Storage, entries []indexEntry) (*Blockchain, int, error) {
+	bc := newBlockchain(ChainConfig{})
+	bc.storage = storage
+	if rewriter, ok := backendStorage(storage).(storageRewriter); ok {
+		state, err := rewriter.loadState()
+		if err != nil {
+			return nil, 0, err
+		}
+		bc.setState(state)
+	}
+	used, err := bc.reindexFrom(entries)
+	if err != nil {
+		return nil, 0, err
+	}
+	if bc.held > 1 {
+		bc.valueKind = bc.head.Kind
+	}
+	if bc.name == "" && bc.genesis != nil {
+		bc.name = bc.genesis.Name
+	}
+	if err := bc.Validate(); err != nil {
+		return nil, 0, err
+	}
+	return bc, used, nil
+}
+
+// readBlockLog decodes the complete records of a log and returns the
+// state and blocks they hold and the length of the file they take up. The
+// state is nil for a log without records.
+func readBlockLog(file *os.File) (*chainState, []*Block, int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	size := info.Size()
+	r := bufio.NewReader(file)
+
+	magic := make([]byte, len(logMagic))
+	n, err := io.ReadFull(r, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil, 0, err
+	}
+	prefix := logMagic[:len(logMagic)-1]
+	if string(magic[:min(n, len(prefix))]) != prefix[:min(n, len(prefix))] {
+		return nil, nil, 0, errors.New("keine Blockprotokoll-Datei")
+	}
+	if n < len(logMagic) {
+		return nil, nil, 0, nil
+	}
+	if version := magic[len(prefix)]; version != logMagic[len(prefix)] {
+		return nil, nil, 0, fmt.Errorf("Blockprotokoll hat die unbekannte Version %d", version)
+	}
+
+	var state *chainState
+	var blocks []*Block
+	offset := int64(len(logMagic))
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return state, blocks, offset, nil
+		} else if err != nil {
+			return nil, nil, 0, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		end := offset + 8 + length
+		if end > size {
+			// the last record was not written completely
+			return state, blocks, offset, nil
+		}
+		if length > maxLogRecord {
+			return nil, nil, 0, fmt.Errorf("Eintrag bei Byte %d ist zu groß (%d Bytes)", offset, length)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, nil, 0, err
+		}
+		if crc32.Checksum(payload, logTable) != binary.BigEndian.Uint32(header[4:8]) {
+			if end == size {
+				return state, blocks, offset, nil
+			}
+			return nil, nil, 0, fmt.Errorf("Eintrag bei Byte %d ist beschädigt", offset)
+		}
+
+		if offset == int64(len(logMagic)) {
+			state = &chainState{}
+			if err := json.Unmarshal(payload, state); err != nil {
+				return nil, nil, 0, fmt.Errorf("Zustand bei Byte %d: %w", offset, err)
+			}
+		} else {
+			if state != nil && state.Codec != "" {
+				if payload, err = decompressRecord(state.Codec, payload); err != nil {
+					return nil, nil, 0, fmt.Errorf("Eintrag bei Byte %d: %w", offset, err)
+				}
+			}
+			block := &Block{}
+			if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(block); err != nil {
+				return nil, nil, 0, fmt.Errorf("Eintrag bei Byte %d: %w", offset, err)
+			}
+			blocks = append(blocks, block)
+		}
+		offset = end
+	}
+}
+
+// Close closes the block log of a chain created with WithPersistence or
+// RecoverFromLog, or the storage of one created with WithStorage;
+// blocks cannot be added afterwards. It does nothing for other chains.
+func (bc *Blockchain) Close() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.log == nil || bc.log.file == nil {
+		return bc.closeStorage()
+	}
+	bc.log.recordHead(bc.head, true)
+	err := bc.log.file.Close()
+	bc.log.file = nil
+	if indexErr := bc.log.closeIndex(); err == nil {
+		err = indexErr
+	}
+	return err
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithPersistenceResumesLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	bc, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := bc.AddBlock([]float64{1, 2, float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	head := bc.HeadHash()
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if resumed.Length() != 4 || resumed.HeadHash() != head {
+		t.Fatalf("resumed %d blocks with head %s, want 4 with %s", resumed.Length(), resumed.HeadHash(), head)
+	}
+	if _, err := resumed.AddBlock([]float64{4, 5}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverFromLogTruncatesTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	bc, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := bc.AddBlock([]float64{float64(i), 10, 20}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	complete, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{30, 40, 50}); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+
+	// cut the last record in the middle, as a crash while writing it would
+	full, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := complete.Size() + (full.Size()-complete.Size())/2
+	if err := os.Truncate(path, torn); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := RecoverFromLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	if recovered.Length() != 4 {
+		t.Fatalf("recovered %d blocks, want the 4 complete ones", recovered.Length())
+	}
+	if err := recovered.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != complete.Size() {
+		t.Fatalf("log not truncated to the last complete record: %v, %v", info.Size(), err)
+	}
+}
+
+func TestWithPersistenceRejectsStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	if _, err := NewBlockchain(WithPersistence(path), WithStorage(NewMemoryStorage())); err == nil {
+		t.Fatal("persistence together with a storage was accepted")
+	}
+}
+
+// failingStorage is a Storage whose appends fail, for the rollback of the
+// log
+type failingStorage struct {
+	Storage
+}
+
+func (failingStorage) AppendBlock(*Block) error {
+	return errors.New("storage unavailable")
+}
+
+func TestAddBlockTruncatesLogWhenStorageFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	bc, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bc.storage = failingStorage{bc.storage}
+	if _, err := bc.AddBlock([]float64{4, 5, 6}); err == nil {
+		t.Fatal("AddBlock succeeded although the storage failed")
+	}
+	if after, err := os.Stat(path); err != nil || after.Size() != before.Size() {
+		t.Fatalf("log is %d bytes after the failed append, want %d: %v", after.Size(), before.Size(), err)
+	}
+	bc.storage = bc.storage.(failingStorage).Storage
+	head := bc.HeadHash()
+	bc.Close()
+
+	recovered, err := RecoverFromLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	if recovered.Length() != 2 || recovered.HeadHash() != head {
+		t.Fatalf("recovered %d blocks with head %s, want 2 with %s", recovered.Length(), recovered.HeadHash(), head)
+	}
+}
+
+func TestPruneRestoresStorageWhenLogFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chain.log")
+	bc, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := bc.AddBlock([]float64{float64(i), 1, 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	head, length := bc.HeadHash(), bc.Length()
+
+	// a closed log cannot be rewritten
+	file := bc.log.file
+	bc.log.file = nil
+	if _, err := bc.Prune(1, filepath.Join(dir, "archive.json")); err == nil {
+		t.Fatal("Prune succeeded although the log failed")
+	}
+	bc.log.file = file
+	if bc.Length() != length || bc.HeadHash() != head {
+		t.Fatalf("chain holds %d blocks with head %s after the failed rewrite, want %d with %s", bc.Length(), bc.HeadHash(), length, head)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplaceTailRestoresStorageWhenLogFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	bc, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := bc.AddBlock([]float64{float64(i), 1, 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other, err := bc.Fork("other", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := other.AddBlock([]float64{float64(i), 7, 8}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tail := other.Blocks()[2:]
+	head, length := bc.HeadHash(), bc.Length()
+
+	// a closed log cannot be rewritten
+	file := bc.log.file
+	bc.log.file = nil
+	if err := bc.ReplaceTail(2, tail); err == nil {
+		t.Fatal("ReplaceTail succeeded although the log failed")
+	}
+	bc.log.file = file
+	if bc.Length() != length || bc.HeadHash() != head {
+		t.Fatalf("chain holds %d blocks with head %s after the failed rewrite, want %d with %s", bc.Length(), bc.HeadHash(), length, head)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeIndexedLog writes a block log at path holding a chain of n blocks
+// after the genesis and returns the blocks
+func writeIndexedLog(t *testing.T, path string, n int) []*Block {
+	t.Helper()
+	bc, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := bc.AddBlock([]float64{float64(i), 1, 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocks := bc.Blocks()
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return blocks
+}
+
+// checkLogIndex checks that the index file of the log at path indexes
+// blocks
+func checkLogIndex(t *testing.T, path string, blocks []*Block) {
+	t.Helper()
+	entries, _, err := readLogIndex(path + logIndexSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(blocks) {
+		t.Fatalf("index file has %d entries, want %d", len(entries), len(blocks))
+	}
+	for i, entry := range entries {
+		if want := (indexEntry{Index: blocks[i].Index, ID: blocks[i].ID, Hash: blocks[i].Hash}); entry != want {
+			t.Fatalf("entry %d of the index file is %+v, want %+v", i, entry, want)
+		}
+	}
+}
+
+func TestLogIndexAppended(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	blocks := writeIndexedLog(t, path, 5)
+	checkLogIndex(t, path, blocks)
+
+	// the recovered chain keeps appending to the index file
+	logged := captureLog(t)
+	bc, err := RecoverFromLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logged.String(), "Indexdatei") {
+		t.Fatalf("recovery with a matching index file logged:\n%s", logged)
+	}
+	if _, err := bc.AddBlock([]float64{9}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Prune(3, filepath.Join(t.TempDir(), "archive.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{10}); err != nil {
+		t.Fatal(err)
+	}
+	// the rewritten log has a rewritten index file
+	checkLogIndex(t, path, bc.Blocks())
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverPrefersLogIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	blocks := writeIndexedLog(t, path, 200)
+
+	// block 1 is not among the sampled entries, so a hash given for it
+	// in the index file is taken as it is
+	entries, _, err := readLogIndex(path + logIndexSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries[1].Hash = strings.Repeat("ab", 32)
+	data := []byte(indexMagic)
+	for _, entry := range entries {
+		data = append(data, indexRecord(&Block{Index: entry.Index, ID: entry.ID, Hash: entry.Hash})...)
+	}
+	if err := os.WriteFile(path+logIndexSuffix, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	bc, err := RecoverFromLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	block, err := bc.BlockByHashPrefix(entries[1].Hash[:8])
+	if err != nil || block.Index != 1 {
+		t.Fatalf("hash prefix of the index file found %v, %v, want block 1", block, err)
+	}
+	if block, err := bc.BlockByID(blocks[150].ID); err != nil || block.Index != 150 {
+		t.Fatalf("ID of block 150 found %v, %v", block, err)
+	}
+}
+
+func TestRecoverRebuildsLogIndex(t *testing.T) {
+	for name, damage := range map[string]func(path string) error{
+		"missing": os.Remove,
+		"corrupt": func(path string) error {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			// the payload of the second record
+			data[len(indexMagic)+8+len(indexRecord(&Block{}))+12] ^= 0xff
+			return os.WriteFile(path, data, 0o600)
+		},
+		"another log": func(path string) error {
+			return os.WriteFile(path, append([]byte(indexMagic), indexRecord(&Block{Index: 0, ID: "other", Hash: "other"})...), 0o600)
+		},
+	} {
+		path := filepath.Join(t.TempDir(), "chain.log")
+		blocks := writeIndexedLog(t, path, 5)
+		if err := damage(path + logIndexSuffix); err != nil {
+			t.Fatal(err)
+		}
+		logged := captureLog(t)
+		bc, err := RecoverFromLog(path)
+		if err != nil {
+			t.Fatalf("%s index file: %v", name, err)
+		}
+		if !strings.Contains(logged.String(), "Indexdatei") {
+			t.Fatalf("recovery with a %s index file did not log the rebuild", name)
+		}
+		if block, err := bc.BlockByID(blocks[3].ID); err != nil || block.Index != 3 {
+			t.Fatalf("ID of block 3 with a %s index file found %v, %v", name, block, err)
+		}
+		bc.Close()
+		checkLogIndex(t, path, blocks)
+	}
+}
+
+func TestRecoverCompletesLogIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	blocks := writeIndexedLog(t, path, 5)
+	// the last two records were lost, one of them cut in the middle
+	info, err := os.Stat(path + logIndexSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := int64(len(indexRecord(blocks[len(blocks)-1])))
+	if err := os.Truncate(path+logIndexSuffix, info.Size()-record-record/2); err != nil {
+		t.Fatal(err)
+	}
+	logged := captureLog(t)
+	bc, err := RecoverFromLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logged.String(), "Indexdatei") {
+		t.Fatalf("recovery with an index file behind the log rebuilt it:\n%s", logged)
+	}
+	bc.Close()
+	checkLogIndex(t, path, blocks)
+}
+
+// benchLogBlocks is the length of the chain of the block log benchmarks
+const benchLogBlocks = 200000
+
+// writeBenchmarkLog writes a block log holding benchLogBlocks blocks
+// with its index file to a temporary directory of b and returns its path
+// and the blocks
+func writeBenchmarkLog(b *testing.B) (string, []*Block) {
+	b.Helper()
+	bc, err := NewBlockchain()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := bc.AddBlocksBulk(bulkRows(benchLogBlocks, 4), 0); err != nil {
+		b.Fatal(err)
+	}
+	path := filepath.Join(b.TempDir(), "chain.log")
+	blocks := bc.Blocks()
+	l, err := openBlockLog(path, bc.state(), blocks)
+	if err != nil {
+		b.Fatal(err)
+	}
+	l.resetIndex(blocks)
+	l.closeIndex()
+	l.file.Close()
+	return path, blocks
+}
+
+// BenchmarkRecoverFromLog loads a chain of benchLogBlocks blocks from its
+// log, with the indexes from the index file and rebuilt from the blocks
+// as before there were index files
+func BenchmarkRecoverFromLog(b *testing.B) {
+	path, blocks := writeBenchmarkLog(b)
+	b.ResetTimer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, rebuild := range []bool{false, true} {
+		name := "index"
+		if rebuild {
+			name = "rebuild"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if rebuild {
+					b.StopTimer()
+					os.Remove(path + logIndexSuffix)
+					b.StartTimer()
+				}
+				bc, err := RecoverFromLog(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				if bc.Length() != len(blocks) {
+					b.Fatalf("recovered %d blocks, want %d", bc.Length(), len(blocks))
+				}
+				bc.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// BenchmarkSaveLogIndex saves the indexes of a chain of benchLogBlocks
+// blocks after a block was added: appended to the index file, and written
+// wholesale as saving them with the chain did
+func BenchmarkSaveLogIndex(b *testing.B) {
+	_, blocks := writeBenchmarkLog(b)
+	path := filepath.Join(b.TempDir(), "rewritten"+logIndexSuffix)
+	index, err := createLogIndex(path, blocks)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer index.file.Close()
+	b.Run("append", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := index.file.Write(indexRecord(blocks[i%len(blocks)])); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("rewrite", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rewritten, err := createLogIndex(path, blocks)
+			if err != nil {
+				b.Fatal(err)
+			}
+			rewritten.file.Close()
+		}
+	})
+}
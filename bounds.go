@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// BoundsPolicy decides what happens to values outside the chain's bounds
+type BoundsPolicy string
+
+const (
+	// BoundsReject rejects the whole block
+	BoundsReject BoundsPolicy = "reject"
+	// BoundsDrop removes the offending values from the block
+	BoundsDrop BoundsPolicy = "drop"
+	// BoundsClamp moves the offending values onto the nearest bound
+	BoundsClamp BoundsPolicy = "clamp"
+)
+
+// ErrOutOfBounds is matched by every *BoundsError
+var ErrOutOfBounds = errors.New("Wert außerhalb der zulässigen Grenzen")
+
+// ValueBounds are the hard limits a chain's values must lie within.
+// Min and Max are inclusive.
+type ValueBounds struct {
+	Min    float64
+	Max    float64
+	Policy BoundsPolicy
+}
+
+func (b ValueBounds) String() string {
+	return fmt.Sprintf("[%g, %g] %s", b.Min, b.Max, b.Policy)
+}
+
+// Validate checks that the bounds are usable
+func (b ValueBounds) Validate() error {
+	if b.Min > b.Max {
+		return fmt.Errorf("Untere Grenze %g liegt über der oberen Grenze %g", b.Min, b.Max)
+	}
+	switch b.Policy {
+	case BoundsReject, BoundsDrop, BoundsClamp:
+		return nil
+	default:
+		return fmt.Errorf("Unbekannte Grenzen-Richtlinie: %s", b.Policy)
+	}
+}
+
+// BoundsError reports the first value that violated the bounds
+type BoundsError struct {
+	Position int
+	Value    float64
+	Bounds   ValueBounds
+}
+
+func (e *BoundsError) Error() string {
+	return fmt.Sprintf("Wert %g an Position %d liegt außerhalb von [%g, %g]", e.Value, e.Position, e.Bounds.Min, e.Bounds.Max)
+}
+
+func (e *BoundsError) Is(target error) bool {
+	return target == ErrOutOfBounds
+}
+
+// apply enforces the bounds on values. It never modifies values and
+// returns the values to store plus the number of violations.
+func (b ValueBounds) apply(values []float64) ([]float64, int, error) {
+	violations := 0
+	var result []float64
+	for i, value := range values {
+		if b.contains(value) {
+			if result != nil {
+				result = append(result, value)
+			}
+			continue
+		}
+
+		violations++
+		switch b.Policy {
+		case BoundsReject:
+			return nil, violations, &BoundsError{Position: i, Value: value, Bounds: b}
+		case BoundsDrop:
+			if result == nil {
+				result = append(make([]float64, 0, len(values)), values[:i]...)
+			}
+		case BoundsClamp:
+			if result == nil {
+				result = append(make([]float64, 0, len(values)), values[:i]...)
+			}
+			result = append(result, min(max(value, b.Min), b.Max))
+		}
+	}
+
+	if result == nil {
+		return values, violations, nil
+	}
+	if len(result) == 0 {
+		return nil, violations, fmt.Errorf("Alle %d Werte liegen außerhalb von [%g, %g]", len(values), b.Min, b.Max)
+	}
+	return result, violations, nil
+}
+
+// contains reports whether value lies within the bounds
+func (b ValueBounds) contains(value float64) bool {
+	return value >= b.Min && value <= b.Max
+}
+
+// keepOrigins returns the origins of the values a drop policy kept
+func (b ValueBounds) keepOrigins(values []float64, origins []Origin) []Origin {
+	var kept []Origin
+	for i, value := range values {
+		if b.contains(value) {
+			kept = append(kept, origins[i])
+		}
+	}
+	return kept
+}
+
+// keepTimes returns the times of the values a drop policy kept
+func (b ValueBounds) keepTimes(values []float64, times []time.Time) []time.Time {
+	kept := []time.Time{}
+	for i, value := range values {
+		if b.contains(value) {
+			kept = append(kept, times[i])
+		}
+	}
+	return kept
+}
+
+// SetBounds installs hard value bounds for new blocks; nil removes them
+func (bc *Blockchain) SetBounds(bounds *ValueBounds) error {
+	if bounds != nil {
+		if err := bounds.Validate(); err != nil {
+			return err
+		}
+		copied := *bounds
+		bounds = &copied
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	log.Printf("Wertgrenzen geändert: %s -> %s", formatBounds(bc.bounds), formatBounds(bounds))
+	bc.bounds = bounds
+	return nil
+}
+
+// Bounds returns the current value bounds, or nil if none are set
+func (bc *Blockchain) Bounds() *ValueBounds {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if bc.bounds == nil {
+		return nil
+	}
+	copied := *bc.bounds
+	return &copied
+}
+
+// BoundsViolations returns the number of values that violated the bounds
+func (bc *Blockchain) BoundsViolations() int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.boundsViolations
+}
+
+// recordBoundsViolations counts the values of a new block that violated
+// bounds and records the bounds on the block's metadata when they changed
+// anything
+func (bc *Blockchain) recordBoundsViolations(bounds *ValueBounds, violations int, metadata map[string]string) map[string]string {
+	if violations == 0 {
+		return metadata
+	}
+	bc.boundsViolations += violations
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata["bounds"] = bounds.String()
+	metadata["bounds_violations"] = strconv.Itoa(violations)
+	return metadata
+}
+
+func formatBounds(bounds *ValueBounds) string {
+	if bounds == nil {
+		return "keine"
+	}
+	return bounds.String()
+}
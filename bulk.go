@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// statsSettings are the settings of a chain the values of a new float
+// block are bounded and summarized with
+type statsSettings struct {
+	bounds           *ValueBounds
+	outliers         OutlierConfig
+	histogramBuckets int
+	bins             *HistogramBins
+	contextWindow    int
+	contextMaxValues int
+	encoding         ValueEncoding
+}
+
+// statsSettings returns the current settings. Called with bc.mu held.
+func (bc *Blockchain) statsSettings() statsSettings {
+	return statsSettings{
+		bounds:           bc.bounds,
+		outliers:         bc.outliers,
+		histogramBuckets: bc.histogramBuckets,
+		bins:             bc.bins,
+		contextWindow:    bc.contextWindow,
+		contextMaxValues: bc.contextMaxValues,
+		encoding:         bc.valueEncoding,
+	}
+}
+
+// preparedBlock is a float block whose values were bounded and whose
+// stats were computed before it is committed. Everything that depends on
+// the chain, such as the index, the timestamp and the hash, is set at the
+// commit.
+type preparedBlock struct {
+	block *Block
+	// bounds are the bounds the values were checked against, or nil
+	bounds     *ValueBounds
+	violations int
+	// stuck is the longest run of equal values, for the quality score
+	stuck int
+	// statsTime is how long the stats took, for Metrics
+	statsTime time.Duration
+	// err is the error of the bounds; block is nil then
+	err error
+}
+
+// prepare bounds values and computes the stats that depend on nothing but
+// them. It does not need bc.mu and may run concurrently.
+func (s statsSettings) prepare(values []float64) *preparedBlock {
+	prepared := &preparedBlock{bounds: s.bounds}
+	// the stats must match the values as they are decoded
+	values = s.encoding.roundValues(values)
+	if s.bounds != nil {
+		values, prepared.violations, prepared.err = s.bounds.apply(values)
+		if prepared.err != nil {
+			return prepared
+		}
+	}
+
+	block := &Block{Values: values, Kind: KindFloat, MerkleRoot: merkleRoot(values)}
+	prepared.stuck = longestRun(block.Values)
+	start := time.Now()
+	calculateBlockStats(block, s.outliers, s.histogramBuckets)
+	if s.contextWindow > 0 {
+		runStatsStage(block, StageOutlierContext, func() {
+			lower, upper := block.OutlierBounds()
+			block.OutlierContexts = captureOutlierContexts(block.Values, lower, upper, s.contextWindow, s.contextMaxValues)
+		})
+	}
+	if s.bins != nil {
+		runStatsStage(block, StageHistogram, func() { block.Binned = binValues(*s.bins, block.Values) })
+	}
+	prepared.statsTime = time.Since(start)
+	prepared.block = block
+	return prepared
+}
+
+// AddBlocksBulk adds every row as its own block like AddBlock, computing
+// the stats of the rows on workers goroutines and committing the blocks
+// strictly in the order of rows. workers below 1 means one per CPU. The
+// rows are summarized with the settings at the start of the call; blocks
+// added by other goroutines meanwhile may end up between them. It stops at
+// the first row that is rejected and returns a RowError counting rows from
+// 1; the rows before it remain added.
+func (bc *Blockchain) AddBlocksBulk(rows [][]float64, workers int) error {
+	return bc.AddBlocksBulkFrom("", rows, workers)
+}
+
+// AddBlocksBulkFrom is AddBlocksBulk for rows read from source, such as
+// the name of a file: with SetTrackOrigins every value records source and
+// its row, counting from 1. An empty source records no origins.
+func (bc *Blockchain) AddBlocksBulkFrom(source string, rows [][]float64, workers int) error {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	bc.mu.RLock()
+	kind, settings, track := bc.valueKind, bc.statsSettings(), bc.trackOrigins && source != ""
+	bc.mu.RUnlock()
+	if kind != KindFloat {
+		return fmt.Errorf("%w: %s-Werte für eine Blockchain vom Typ %s", ErrValueKindMismatch, KindFloat, kind)
+	}
+
+	// results[i] receives row i once it is prepared. At most window rows
+	// are prepared ahead of the commits, which bounds the memory held.
+	window := 2 * workers
+	results := make([]chan *preparedBlock, len(rows))
+	for i := range results {
+		results[i] = make(chan *preparedBlock, 1)
+	}
+	slots := make(chan struct{}, window)
+	jobs := make(chan int)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(done)
+
+	go func() {
+		defer close(jobs)
+		for i := range rows {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] <- settings.prepare(rows[i])
+			}
+		}()
+	}
+
+	for i, row := range rows {
+		prepared := <-results[i]
+		<-slots
+		p := blockPayload{values: row}
+		if track {
+			p.origins = importOrigins(source, i+1, len(row))
+		}
+		bc.mu.Lock()
+		_, err := bc.commitBlock(p, prepared)
+		bc.mu.Unlock()
+		if err != nil {
+			return RowError{Line: i + 1, Err: err}
+		}
+	}
+	return nil
+}
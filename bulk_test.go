@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAddBlocksBulkMatchesAddBlock(t *testing.T) {
+	rows := bulkRows(50, 20)
+	sequential, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if _, err := sequential.AddBlock(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bulk, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bulk.AddBlocksBulk(rows, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	want, got := sequential.Blocks(), bulk.Blocks()
+	if len(got) != len(want) {
+		t.Fatalf("bulk import added %d blocks, want %d", len(got)-1, len(want)-1)
+	}
+	for i := 1; i < len(want); i++ {
+		if got[i].Values[0] != want[i].Values[0] || got[i].Mean != want[i].Mean || got[i].Median != want[i].Median || got[i].TwoSDUpper != want[i].TwoSDUpper || len(got[i].Outliers) != len(want[i].Outliers) {
+			t.Fatalf("block %d of the bulk import differs from the sequential one", i)
+		}
+	}
+	if err := checkLinked(got); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddBlocksBulkStopsAtRejectedRow(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := bulkRows(10, 5)
+	rows[6][2] = math.NaN()
+	err = bc.AddBlocksBulk(rows, 3)
+	var rowErr RowError
+	if !errors.As(err, &rowErr) || rowErr.Line != 7 {
+		t.Fatalf("AddBlocksBulk returned %v, want an error for row 7", err)
+	}
+	if bc.Length() != 7 {
+		t.Fatalf("chain holds %d blocks, want genesis and the 6 rows before the rejected one", bc.Length())
+	}
+}
+
+func TestAddBlocksBulkWithGenerator(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the generator's blocks hold 5 values, the imported rows 7
+	generator := NewGenerator(NewDefaultPipeline(bc).ForSource("generator"), GeneratorConfig{
+		Interval:       time.Millisecond,
+		ValuesPerBlock: 5,
+	})
+	if err := generator.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rows := bulkRows(500, 7)
+	err = bc.AddBlocksBulk(rows, 4)
+	generator.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := bc.Blocks()
+	if err := checkLinked(blocks); err != nil {
+		t.Fatal(err)
+	}
+	next := 0
+	for _, block := range blocks[1:] {
+		if len(block.Values) != 7 {
+			continue
+		}
+		if block.Values[0] != float64(next) {
+			t.Fatalf("block %d holds row %v, want row %d", block.Index, block.Values[0], next)
+		}
+		next++
+	}
+	if next != len(rows) {
+		t.Fatalf("found %d imported rows in the chain, want %d", next, len(rows))
+	}
+	if stats := generator.Stats(); stats.Failed > 0 {
+		t.Fatalf("the generator failed %d batches", stats.Failed)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// benchmarkRows returns the rows of the import benchmarks, 10k rows of 1k
+// values, made on the first call only
+var benchmarkRows = sync.OnceValue(func() [][]float64 { return bulkRows(10000, 1000) })
+
+func BenchmarkImportSequential(b *testing.B) {
+	rows := benchmarkRows()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bc, err := NewBlockchain()
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, row := range rows {
+			if _, err := bc.AddBlock(row); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkImportBulk(b *testing.B) {
+	rows := benchmarkRows()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bc, err := NewBlockchain()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := bc.AddBlocksBulk(rows, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Version is the version of the program; release builds set it with
+// -ldflags "-X main.Version=..."
+var Version = "dev"
+
+// BlockFormatVersion is the version of the block layout and hash payload
+const BlockFormatVersion = 1
+
+// Capabilities describes what this build and chain support. Difficulty
+// is the proof of work of new blocks, 0 without; Auth is set by GET
+// /capabilities when the API requires tokens, see WithTokenUsage.
+type Capabilities struct {
+	Version            string      `json:"version"`
+	BlockFormatVersion int         `json:"block_format_version"`
+	OutlierMethods     []string    `json:"outlier_methods"`
+	ReportFormats      []string    `json:"report_formats"`
+	Codecs             []string    `json:"codecs"`
+	Downsamplers       []string    `json:"downsamplers"`
+	IDSchemes          []string    `json:"id_schemes"`
+	ValueKinds         []string    `json:"value_kinds"`
+	ValueEncodings     []string    `json:"value_encodings"`
+	BoundsPolicies     []string    `json:"bounds_policies"`
+	TimestampPolicies  []string    `json:"timestamp_policies"`
+	RuleFields         []string    `json:"rule_fields"`
+	Limits             BlockLimits `json:"limits"`
+	ValueKind          ValueKind   `json:"value_kind"`
+	Difficulty         int         `json:"difficulty"`
+	Auth               bool        `json:"auth"`
+}
+
+// Capabilities returns the features of this build plus the limits and
+// value kind configured on bc
+func (bc *Blockchain) Capabilities() Capabilities {
+	return Capabilities{
+		Version:            Version,
+		BlockFormatVersion: BlockFormatVersion,
+		OutlierMethods:     []string{OutlierSD, OutlierIQR},
+		ReportFormats:      []string{"html"},
+		Codecs:             SupportedCodecs(),
+		Downsamplers:       SupportedDownsamplers(),
+		IDSchemes:          []string{string(IDSchemeULID), string(IDSchemeUUIDv7)},
+		ValueKinds:         []string{string(KindFloat), string(KindInt)},
+		ValueEncodings:     []string{string(EncodingNone), string(EncodingFloat32), string(EncodingDelta)},
+		BoundsPolicies:     []string{string(BoundsReject), string(BoundsDrop), string(BoundsClamp)},
+		TimestampPolicies:  []string{string(TimestampAllow), string(TimestampClamp), string(TimestampReject)},
+		RuleFields:         RuleFields(),
+		Limits:             bc.Limits(),
+		ValueKind:          bc.ValueKind(),
+		Difficulty:         bc.Difficulty(),
+	}
+}
+
+// writeCapabilities writes caps to w as indented JSON
+func writeCapabilities(w io.Writer, caps Capabilities) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(caps)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// ErrChainMismatch is returned for a chain file that belongs to another
+// chain than the one expected
+var ErrChainMismatch = errors.New("Blockchain-Datei gehört zu einer anderen Blockchain")
+
+// Metadata keys of the genesis block that hold its ChainConfig
+const (
+	genesisNameKey        = "chain_name"
+	genesisDescriptionKey = "chain_description"
+	genesisCreatedByKey   = "chain_created_by"
+)
+
+// ChainConfig identifies a new chain, see WithChainConfig
+type ChainConfig struct {
+	// Name is also the Name of the chain until it is forked
+	Name        string
+	Description string
+	// CreatedBy names who or what created the chain, e.g. a user or host
+	CreatedBy string
+}
+
+// ChainInfo describes the genesis block of a chain
+type ChainInfo struct {
+	Name        string    `json:"name,omitempty"`
+	Description string    `json:"description,omitempty"`
+	CreatedBy   string    `json:"created_by,omitempty"`
+	GenesisHash string    `json:"genesis_hash"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// String formats the info for the menu
+func (info ChainInfo) String() string {
+	var b strings.Builder
+	name := info.Name
+	if name == "" {
+		name = "(ohne Namen)"
+	}
+	fmt.Fprintf(&b, "Blockchain: %s\n", name)
+	if info.Description != "" {
+		fmt.Fprintf(&b, "Beschreibung: %s\n", info.Description)
+	}
+	if info.CreatedBy != "" {
+		fmt.Fprintf(&b, "Erstellt von: %s\n", info.CreatedBy)
+	}
+	if info.GenesisHash == "" {
+		b.WriteString("Genesis: nicht verfügbar")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Erstellt am: %s\n", info.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Genesis: %s", formatHash(info.GenesisHash))
+	return b.String()
+}
+
+// metadata returns the genesis Metadata that holds cfg, nil if cfg is
+// empty
+func (cfg ChainConfig) metadata() map[string]string {
+	var metadata map[string]string
+	for key, value := range map[string]string{
+		genesisNameKey:        cfg.Name,
+		genesisDescriptionKey: cfg.Description,
+		genesisCreatedByKey:   cfg.CreatedBy,
+	} {
+		if value == "" {
+			continue
+		}
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		metadata[key] = value
+	}
+	return metadata
+}
+
+// Info returns the ChainConfig and hash of the chain's genesis block and
+// when it was created. The info of a pruned chain was saved with it; a
+// chain bootstrapped from a checkpoint has none until Backfill reaches its
+// genesis. Chains from before ChainConfig have a genesis without a name.
+func (bc *Blockchain) Info() ChainInfo {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	if bc.genesis == nil {
+		return ChainInfo{}
+	}
+	return *bc.genesis
+}
+
+// matches reports whether info describes the same genesis as other
+func (info ChainInfo) matches(other ChainInfo) bool {
+	return info.Name == other.Name && info.Description == other.Description &&
+		info.CreatedBy == other.CreatedBy && info.GenesisHash == other.GenesisHash &&
+		info.CreatedAt.Equal(other.CreatedAt)
+}
+
+// genesisInfo reads the ChainInfo of a genesis block
+func genesisInfo(genesis *Block) *ChainInfo {
+	return &ChainInfo{
+		Name:        genesis.Metadata[genesisNameKey],
+		Description: genesis.Metadata[genesisDescriptionKey],
+		CreatedBy:   genesis.Metadata[genesisCreatedByKey],
+		GenesisHash: genesis.Hash,
+		CreatedAt:   genesis.Timestamp,
+	}
+}
+
+// checkChainName returns ErrChainMismatch unless the genesis of bc is
+// named name; an empty name matches every chain
+func checkChainName(bc *Blockchain, name string) error {
+	if name == "" {
+		return nil
+	}
+	info := bc.Info()
+	if info.GenesisHash == "" {
+		return fmt.Errorf("%w: Genesis ist nicht verfügbar, erwartet %q", ErrChainMismatch, name)
+	}
+	if info.Name != name {
+		return fmt.Errorf("%w: %q statt %q", ErrChainMismatch, info.Name, name)
+	}
+	return nil
+}
+
+// chainCreator returns the CreatedBy of chains created by main, the user
+// and host running it as user@host, or what of them is known
+func chainCreator() string {
+	var name string
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	switch {
+	case err != nil || host == "":
+		return name
+	case name == "":
+		return host
+	}
+	return name + "@" + host
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestChainConfigIsCoveredByGenesisHash(t *testing.T) {
+	a, err := NewBlockchain(WithChainConfig(ChainConfig{Name: "lab-a", Description: "Sensor A", CreatedBy: "test"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBlockchain(WithChainConfig(ChainConfig{Name: "lab-b"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoA, infoB := a.Info(), b.Info()
+	if infoA.GenesisHash == infoB.GenesisHash {
+		t.Fatal("chains of different names share a genesis hash")
+	}
+	if infoA.Name != "lab-a" || infoA.Description != "Sensor A" || infoA.CreatedBy != "test" || a.Name() != "lab-a" {
+		t.Fatalf("Info = %+v, Name = %q", infoA, a.Name())
+	}
+	genesis, err := a.BlockByIndex(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calculateHash(genesis) != infoA.GenesisHash {
+		t.Fatal("genesis hash does not cover the chain config")
+	}
+	if err := a.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadChecksChainName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.json")
+	bc, err := NewBlockchain(WithChainConfig(ChainConfig{Name: "lab-a"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadNamedBlockchain(path, "lab-b"); !errors.Is(err, ErrChainMismatch) {
+		t.Fatalf("loading under another name: got %v, want ErrChainMismatch", err)
+	}
+	loaded, err := LoadNamedBlockchain(path, "lab-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Info().matches(bc.Info()) {
+		t.Fatalf("loaded Info %+v, want %+v", loaded.Info(), bc.Info())
+	}
+}
+
+func TestWithSignerSignsGenesis(t *testing.T) {
+	_, priv, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBlockchain(WithSigner(priv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := bc.AddBlock([]float64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, _ := bc.BlockByIndex(0)
+	if len(genesis.Signature) == 0 || len(block.Signature) == 0 {
+		t.Fatal("genesis or new block is not signed")
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBlockchain(WithSigner(priv[:10])); err == nil {
+		t.Fatal("short key accepted")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrHistoryUnavailable is returned for blocks before the checkpoint a
+// chain was bootstrapped from, until they are backfilled
+var ErrHistoryUnavailable = errors.New("Verlauf vor dem Checkpoint ist nicht verfügbar")
+
+// ErrInvalidCheckpoint is returned for checkpoints with a bad signature
+// and for backfilled history that does not link into the chain
+var ErrInvalidCheckpoint = errors.New("Ungültiger Checkpoint")
+
+// Checkpoint summarizes a chain up to its head block. CumulativeHash
+// chains the hashes of all blocks up to Index, so two chains with the
+// same checkpoint share the same history.
+type Checkpoint struct {
+	Index          int       `json:"index"`
+	HeadID         string    `json:"head_id"`
+	HeadHash       string    `json:"head_hash"`
+	Timestamp      time.Time `json:"timestamp"`
+	CumulativeHash string    `json:"cumulative_hash"`
+	Blocks         int       `json:"blocks"`
+	Values         int       `json:"values"`
+	Sum            float64   `json:"sum"`
+	Signature      []byte    `json:"signature,omitempty"`
+}
+
+// Mean returns the mean of all values up to the checkpoint
+func (cp *Checkpoint) Mean() float64 {
+	if cp.Values == 0 {
+		return 0
+	}
+	return cp.Sum / float64(cp.Values)
+}
+
+// signedBytes is the canonical form covered by the signature
+func (cp *Checkpoint) signedBytes() []byte {
+	return []byte(fmt.Sprintf("%d|%s|%s|%d|%s|%d|%d|%v", cp.Index, cp.HeadID, cp.HeadHash, cp.Timestamp.UnixNano(), cp.CumulativeHash, cp.Blocks, cp.Values, cp.Sum))
+}
+
+// Sign signs the checkpoint with key
+func (cp *Checkpoint) Sign(key ed25519.PrivateKey) {
+	cp.Signature = ed25519.Sign(key, cp.signedBytes())
+}
+
+// Verify checks the signature of the checkpoint against key
+func (cp *Checkpoint) Verify(key ed25519.PublicKey) error {
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, cp.signedBytes(), cp.Signature) {
+		return fmt.Errorf("%w: Signatur von Block %d passt nicht", ErrInvalidCheckpoint, cp.Index)
+	}
+	return nil
+}
+
+// cumulate extends a cumulative hash by one block hash
+func cumulate(cumulative, hash string) string {
+	sum := sha256.Sum256([]byte(cumulative + hash))
+	return hex.EncodeToString(sum[:])
+}
+
+// Checkpoint returns an unsigned checkpoint of the current head. Chains
+// bootstrapped from a checkpoint extend its cumulative hash and totals,
+// so backfilling is not needed. The error is that of reading the blocks.
+func (bc *Blockchain) Checkpoint() (*Checkpoint, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.checkpointThrough(bc.held - 1)
+}
+
+// checkpointThrough returns an unsigned checkpoint of the block at slot
+// end. Called with bc.mu held.
+func (bc *Blockchain) checkpointThrough(end int) (*Checkpoint, error) {
+	cp := &Checkpoint{}
+	start := 0
+	if bc.checkpoint != nil {
+		// continue from the checkpoint, backfilled history may not reach
+		// back to genesis
+		*cp = *bc.checkpoint
+		cp.Signature = nil
+		start = bc.checkpoint.Index - bc.base + 1
+	}
+	blocks, err := bc.blocks(start, end+1)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		cp.CumulativeHash = cumulate(cp.CumulativeHash, block.Hash)
+		cp.Blocks++
+		cp.Values += block.ValueCount()
+		cp.Sum += block.Mean * float64(block.ValueCount())
+	}
+	head, err := bc.block(end)
+	if err != nil {
+		return nil, err
+	}
+	cp.Index, cp.HeadID, cp.HeadHash, cp.Timestamp = head.Index, head.ID, head.Hash, head.Timestamp
+	return cp, nil
+}
+
+// NewBlockchainFromCheckpoint starts a chain from a checkpoint signed by
+// trusted instead of from genesis. The checkpoint becomes the first block;
+// new blocks link to its head hash, and older blocks can be added later
+// with Backfill. Until then they are reported as ErrHistoryUnavailable.
+func NewBlockchainFromCheckpoint(cp *Checkpoint, trusted ed25519.PublicKey) (*Blockchain, error) {
+	if err := cp.Verify(trusted); err != nil {
+		return nil, err
+	}
+
+	bc := newBlockchain(ChainConfig{})
+	stub := &Block{
+		Index:     cp.Index,
+		ID:        cp.HeadID,
+		Timestamp: cp.Timestamp,
+		Hash:      cp.HeadHash,
+		Status:    StatusOK,
+		Kind:      KindFloat,
+		Metadata:  map[string]string{"checkpoint": "true"},
+	}
+	copied := *cp
+	bc.stub = true
+	bc.checkpoint = &copied
+	bc.genesis = nil
+	bc.holdBlocks([]*Block{stub})
+	return bc, nil
+}
+
+// Backfill adds history in front of a bootstrapped chain. blocks must be
+// consecutive and end right before the oldest block held, or at the
+// checkpoint itself while only the checkpoint record is held, and their
+// hashes must link into it.
+func (bc *Blockchain) Backfill(blocks []*Block) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.archive != nil {
+		return fmt.Errorf("%w: Blöcke bis %d liegen bereits in %s", ErrBlockArchived, bc.archive.LastIndex, bc.archive.Path)
+	}
+	if bc.expired != nil {
+		return fmt.Errorf("%w: Blöcke bis %d", ErrBlockExpired, bc.expired.LastIndex)
+	}
+	first, err := bc.block(0)
+	if err != nil {
+		return err
+	}
+	end, link := bc.base-1, first.PrevHash
+	if bc.stub {
+		end, link = bc.base, first.Hash
+	}
+	if blocks[len(blocks)-1].Index != end {
+		return fmt.Errorf("%w: Nachgereichter Verlauf endet bei Block %d statt %d", ErrInvalidCheckpoint, blocks[len(blocks)-1].Index, end)
+	}
+	for i, block := range blocks {
+		if i > 0 && block.Index != blocks[i-1].Index+1 {
+			return fmt.Errorf("%w: Lücke vor Block %d", ErrInvalidCheckpoint, block.Index)
+		}
+		next := link
+		if i+1 < len(blocks) {
+			next = blocks[i+1].PrevHash
+		}
+		if calculateHash(block) != next {
+			return fmt.Errorf("%w: Hash von Block %d passt nicht", ErrInvalidCheckpoint, block.Index)
+		}
+	}
+
+	history := make([]*Block, len(blocks))
+	for i, block := range blocks {
+		history[i] = copyBlock(block)
+	}
+	// the record of the checkpoint is replaced by its block, which has
+	// the same index
+	state := bc.state()
+	state.Stub = false
+	return bc.rewriteChain(0, 0, history, state)
+}
+
+// reindex rebuilds the ID and hash indexes, the memory estimate, the
+// aggregate stats, the head and, if the chain holds its genesis, its info
+// from the storage after blocks were changed. Called with bc.mu held.
+func (bc *Blockchain) reindex() error {
+	_, err := bc.reindexFrom(nil)
+	return err
+}
+
+// reindexFrom is reindex taking the ID and hash indexes of the first
+// blocks from entries of an index file, if matchIndex accepts them. It
+// returns how many entries it took, -1 if they do not match.
+func (bc *Blockchain) reindexFrom(entries []indexEntry) (int, error) {
+	bc.held = bc.storage.Count()
+	latest, err := bc.storage.LatestBlock()
+	if err != nil {
+		return 0, err
+	}
+	bc.base = latest.Index - bc.held + 1
+	blocks, err := bc.allBlocks()
+	if err != nil {
+		return 0, err
+	}
+	bc.head = blocks[len(blocks)-1]
+	bc.idIndex = make(map[string]int, len(blocks))
+	bc.hashIndex = map[string][]hashEntry{}
+	used := -1
+	if matchIndex(entries, blocks) {
+		used = len(entries)
+		for pos, entry := range entries {
+			bc.idIndex[entry.ID] = pos
+			bc.indexHash(entry.Hash, pos)
+		}
+	}
+	bc.memUsage = 0
+	bc.totals = chainTotals{}
+	for pos, block := range blocks {
+		if pos >= used {
+			bc.idIndex[block.ID] = pos
+			bc.indexHash(block.Hash, pos)
+		}
+		bc.memUsage += estimateBlockSize(block)
+		bc.totals.add(block)
+	}
+	if bc.base == 0 && !bc.stub {
+		bc.genesis = genesisInfo(blocks[0])
+	}
+	return used, nil
+}
+
+// position returns the slot of the block with the given index. Called
+// with bc.mu held.
+func (bc *Blockchain) position(index int) (int, error) {
+	switch {
+	case index >= 0 && bc.archive != nil && index <= bc.archive.LastIndex:
+		return 0, fmt.Errorf("%w: Block %d liegt in %s", ErrBlockArchived, index, bc.archive.Path)
+	case index >= 0 && bc.expired != nil && index <= bc.expired.LastIndex:
+		return 0, fmt.Errorf("%w: Block %d", ErrBlockExpired, index)
+	case index >= 0 && index < bc.base:
+		return 0, fmt.Errorf("%w: Block %d", ErrHistoryUnavailable, index)
+	case index < 0 || index-bc.base >= bc.held:
+		return 0, fmt.Errorf("%w: Index %d", ErrBlockNotFound, index)
+	}
+	return index - bc.base, nil
+}
+
+// HistoryStart returns the index of the oldest block the chain holds
+func (bc *Blockchain) HistoryStart() int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.base
+}
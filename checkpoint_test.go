@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+)
+
+func TestBootstrapFromCheckpoint(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := newFilledChain(t)
+	cp, err := source.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Sign(private)
+	head := source.LatestBlock()
+
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBlockchainFromCheckpoint(cp, other); !errors.Is(err, ErrInvalidCheckpoint) {
+		t.Fatalf("checkpoint under another key returned %v", err)
+	}
+	forged := *cp
+	forged.Sum++
+	if _, err := NewBlockchainFromCheckpoint(&forged, public); !errors.Is(err, ErrInvalidCheckpoint) {
+		t.Fatalf("altered checkpoint returned %v", err)
+	}
+
+	bc, err := NewBlockchainFromCheckpoint(cp, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bc.HistoryStart() != head.Index {
+		t.Fatalf("history starts at %d, want %d", bc.HistoryStart(), head.Index)
+	}
+	if _, err := bc.BlockByIndex(1); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Fatalf("block before the checkpoint returned %v", err)
+	}
+	block, err := bc.AddBlock([]float64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Index != head.Index+1 || block.PrevHash != head.Hash {
+		t.Fatalf("first block after the checkpoint is %d linked to %s", block.Index, block.PrevHash)
+	}
+
+	// the totals continue from the checkpoint
+	next, err := bc.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Blocks != cp.Blocks+1 || next.Values != cp.Values+3 || next.CumulativeHash != cumulate(cp.CumulativeHash, block.Hash) {
+		t.Fatalf("checkpoint after one block is %+v, from %+v", next, cp)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// history can be backfilled in parts, each linking in front of the
+	// oldest block held
+	history := source.Blocks()
+	if err := bc.Backfill(history[3:]); err != nil {
+		t.Fatal(err)
+	}
+	if bc.HistoryStart() != 3 {
+		t.Fatalf("history starts at %d after backfilling from 3", bc.HistoryStart())
+	}
+	if err := bc.Backfill(history[:2]); !errors.Is(err, ErrInvalidCheckpoint) {
+		t.Fatalf("history with a gap returned %v", err)
+	}
+	tampered := append([]*Block(nil), history[:3]...)
+	tampered[1] = copyBlock(tampered[1])
+	tampered[1].Values[0]++
+	if err := bc.Backfill(tampered); !errors.Is(err, ErrInvalidCheckpoint) {
+		t.Fatalf("tampered history returned %v", err)
+	}
+	if err := bc.Backfill(history[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if bc.HistoryStart() != 0 {
+		t.Fatalf("history starts at %d after the backfill", bc.HistoryStart())
+	}
+	if first, err := bc.BlockByIndex(1); err != nil || first.Hash != history[1].Hash {
+		t.Fatalf("backfilled block 1 is %v, %v", first, err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
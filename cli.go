@@ -0,0 +1,530 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// cliUsage lists the commands of the interactive loop
+const cliUsage = `Befehle:
+  show latest|all|outliers|<Index>  Blöcke anzeigen
+  import <Datei> [Format]           Datei importieren, Platzhalter erlaubt
+  export <Datei> [Format]           Blockchain exportieren
+  stats                             Statistik über alle Blöcke
+  info                              Name und Genesis der Blockchain
+  storage stats                     Dauer der Speicherzugriffe seit dem Start
+  prune <Anzahl> <Archiv>           Alte Blöcke archivieren, nach Vorschau
+  redact <von> <bis>                Werte von Blöcken verwerfen, nach Vorschau
+  undo                              Letzten bestätigten Vorgang zurücknehmen
+  menu                              Menü anzeigen
+  help                              Diese Hilfe
+  quit                              Programm beenden
+Oder die Nummer einer Aktion aus dem Menü.`
+
+// cli is the interactive command loop. It reads one line at a time, which
+// is either the number of a menu action, prompting for what the action
+// needs, or a command with its arguments, such as "import data.csv csv".
+type cli struct {
+	bc        *Blockchain
+	pipeline  *Pipeline
+	generator *Generator
+	presets   *PresetStore
+	server    *APIServer
+	// notifier is the webhook of main, nil without -webhook
+	notifier *WebhookNotifier
+	// confirmer guards prune and redact, shared with the HTTP API
+	confirmer *Confirmer
+	in        LineReader
+	out       io.Writer
+}
+
+// RunCLI runs the interactive command loop on bc, reading commands from in
+// and writing to out until quit or the end of in. Blocks are added
+// through a new default pipeline. The generator is not started and the
+// HTTP API only runs while the loop does, if started from it.
+func RunCLI(bc *Blockchain, in io.Reader, out io.Writer) {
+	pipeline := NewDefaultPipeline(bc)
+	presets, _ := LoadPresets("")
+	confirmer := NewConfirmer(bc, defaultUndoWindow, realClock{})
+	server := NewAPIServer(bc, pipeline, WithConfirmer(confirmer))
+	c := &cli{
+		bc:        bc,
+		pipeline:  pipeline,
+		generator: NewGenerator(pipeline.ForSource("generator"), GeneratorConfig{}),
+		presets:   presets,
+		server:    server,
+		confirmer: confirmer,
+		in:        NewPlainLineReader(in, out),
+		out:       out,
+	}
+	c.run()
+	if server.Addr() != "" {
+		server.Stop()
+	}
+}
+
+// run reads lines until quit or the end of the input. Blank lines are
+// ignored; the menu is shown again after every other line.
+func (c *cli) run() {
+	showMenu := true
+	for {
+		if showMenu {
+			c.printMenu()
+		}
+		line, err := promptString(c.in, "")
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			showMenu = false
+			continue
+		}
+		showMenu = true
+
+		var quit bool
+		if choice, err := strconv.Atoi(fields[0]); err == nil {
+			quit = c.shortcut(choice, fields[1:])
+		} else {
+			quit = c.command(strings.ToLower(fields[0]), fields[1:])
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// shortcutCommands are the menu actions that also take their input as
+// arguments, as in "4 data.csv csv"
+var shortcutCommands = map[int]string{4: "import", 11: "export"}
+
+// shortcut runs the menu action choice. With arguments the action runs as
+// its command if it has one. It reports whether to quit.
+func (c *cli) shortcut(choice int, args []string) bool {
+	if len(args) == 0 {
+		return c.menu(choice)
+	}
+	if name, ok := shortcutCommands[choice]; ok {
+		return c.command(name, args)
+	}
+	fmt.Fprintf(c.out, "Aktion %d erwartet keine Argumente\n", choice)
+	return false
+}
+
+// command runs the command name with args and reports whether to quit
+func (c *cli) command(name string, args []string) bool {
+	switch {
+	case name == "quit" || name == "exit":
+		return true
+	case name == "help":
+		fmt.Fprintln(c.out, cliUsage)
+	case name == "menu":
+	case name == "stats" && len(args) == 0:
+		fmt.Fprintln(c.out, c.bc.AggregateStats())
+	case name == "info" && len(args) == 0:
+		fmt.Fprintln(c.out, c.bc.Info())
+	case name == "show" && len(args) == 1:
+		c.show(args[0])
+	case name == "storage" && len(args) == 1 && strings.EqualFold(args[0], "stats"):
+		stats, err := c.bc.StorageStats()
+		if err != nil {
+			fmt.Fprintln(c.out, err)
+			break
+		}
+		fmt.Fprintln(c.out, stats)
+	case name == "prune" && len(args) == 2:
+		keepLast, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Fprintf(c.out, "Ungültige Anzahl: %s\n", args[0])
+			break
+		}
+		c.confirm(c.confirmer.PreviewPrune(keepLast, args[1]))
+	case name == "redact" && len(args) == 2:
+		from, err1 := strconv.Atoi(args[0])
+		to, err2 := strconv.Atoi(args[1])
+		if err1 != nil || err2 != nil {
+			fmt.Fprintf(c.out, "Ungültiger Bereich: %s bis %s\n", args[0], args[1])
+			break
+		}
+		c.confirm(c.confirmer.PreviewRedact(from, to))
+	case name == "undo" && len(args) == 0:
+		c.undo()
+	case name == "import" && (len(args) == 1 || len(args) == 2):
+		var opts ImportOptions
+		if len(args) == 2 {
+			opts.Format = strings.ToLower(args[1])
+		}
+		report, err := c.pipeline.ImportGlob(args[0], opts)
+		if err != nil {
+			fmt.Fprintln(c.out, "Fehler beim Einlesen der externen Datenquelle:", err)
+			break
+		}
+		report.Print(c.out)
+	case name == "export" && (len(args) == 1 || len(args) == 2):
+		format := formatFromPath(args[0])
+		if len(args) == 2 {
+			format = strings.ToLower(args[1])
+		}
+		if err := writeExportFile(c.bc, args[0], format, nil); err != nil {
+			fmt.Fprintln(c.out, "Fehler beim Exportieren:", err)
+			break
+		}
+		fmt.Fprintln(c.out, "Blockchain exportiert:", args[0])
+	default:
+		switch name {
+		case "stats", "info", "show", "import", "export", "storage", "prune", "redact", "undo":
+			fmt.Fprintf(c.out, "Falsche Argumente für %s\n", name)
+		default:
+			fmt.Fprintf(c.out, "Unbekannter Befehl: %s\n", name)
+		}
+		fmt.Fprintln(c.out, cliUsage)
+	}
+	return false
+}
+
+// show implements "show latest", "show all", "show outliers" and
+// "show <index>"
+func (c *cli) show(what string) {
+	switch strings.ToLower(what) {
+	case "latest":
+		printBlock(c.out, c.bc.LatestBlock())
+	case "all":
+		printBlockchain(c.out, c.bc.Blocks())
+	case "outliers":
+		printOutlierBlocks(c.out, c.bc.Blocks())
+	default:
+		index, err := strconv.Atoi(what)
+		if err != nil {
+			fmt.Fprintf(c.out, "Ungültiger Block: %s (latest, all, outliers oder ein Index)\n", what)
+			return
+		}
+		block, err := c.bc.BlockByIndex(index)
+		if err != nil {
+			fmt.Fprintln(c.out, "Fehler:", err)
+			return
+		}
+		printBlock(c.out, block)
+	}
+}
+
+// printMenu lists the numbered menu actions
+func (c *cli) printMenu() {
+	fmt.Fprintln(c.out, "Wählen Sie eine Aktion:")
+	fmt.Fprintln(c.out, "1. Aktuelle Werte ausgeben")
+	fmt.Fprintln(c.out, "2. Blockchain anzeigen")
+	fmt.Fprintln(c.out, "3. Blöcke mit Ausreißern ausgeben")
+	fmt.Fprintln(c.out, "4. Daten aus externe Quelle einlesen und hinzufügen")
+	fmt.Fprintln(c.out, "5. Programm beenden")
+	fmt.Fprintln(c.out, "6. Bericht erstellen")
+	fmt.Fprintln(c.out, "7. Werte manuell eingeben")
+	fmt.Fprintln(c.out, "8. Zahlenformat festlegen")
+	fmt.Fprintln(c.out, "9. Importvorlagen verwalten")
+	fmt.Fprintln(c.out, "10. Blöcke nach Referenz suchen")
+	fmt.Fprintln(c.out, "11. Blockchain exportieren")
+	if c.generator.Paused() {
+		fmt.Fprintln(c.out, "12. Generator fortsetzen")
+	} else {
+		fmt.Fprintln(c.out, "12. Generator anhalten")
+	}
+	fmt.Fprintln(c.out, "13. Statistik über alle Blöcke")
+	fmt.Fprintln(c.out, "14. Blöcke nach Anmerkung suchen")
+	if addr := c.server.Addr(); addr != "" {
+		fmt.Fprintf(c.out, "15. HTTP-API beenden (läuft auf %s)\n", addr)
+	} else {
+		fmt.Fprintln(c.out, "15. HTTP-API starten")
+	}
+	fmt.Fprintln(c.out, "16. Wertquelle des Generators wählen")
+	fmt.Fprintln(c.out, "17. Verteilung des letzten Blocks anzeigen")
+	fmt.Fprintln(c.out, "18. Trend der letzten Blöcke anzeigen")
+	fmt.Fprintln(c.out, "19. Neue Blöcke live verfolgen")
+	fmt.Fprintln(c.out, "20. Eingangswarteschlange anzeigen")
+	fmt.Fprintln(c.out, "21. Blöcke mit den meisten Ausreißern anzeigen")
+	fmt.Fprintln(c.out, "22. Anomale Blöcke anzeigen")
+	if r := c.bc.Recovery(); r != nil && r.Degraded {
+		fmt.Fprintln(c.out, "23. Wiederherstellungsbericht anzeigen (Blockchain beeinträchtigt)")
+	} else if r != nil {
+		fmt.Fprintln(c.out, "23. Wiederherstellungsbericht anzeigen")
+	}
+	if _, _, ok := c.bc.vacuumStatus(); ok {
+		fmt.Fprintln(c.out, "24. Datenbank verdichten")
+	}
+	if c.notifier != nil && c.notifier.DeadLetters() != nil {
+		fmt.Fprintf(c.out, "25. Unzustellbare Benachrichtigungen (%d)\n", c.notifier.DeadLetters().Len())
+	}
+	fmt.Fprintln(c.out, "Oder einen Befehl wie show latest eingeben, help zeigt alle.")
+}
+
+// menu runs the numbered menu action choice, prompting for its input, and
+// reports whether to quit
+func (c *cli) menu(choice int) bool {
+	switch choice {
+	case 1:
+		printBlock(c.out, c.bc.LatestBlock())
+	case 2:
+		printBlockchain(c.out, c.bc.Blocks())
+	case 3:
+		printOutlierBlocks(c.out, c.bc.Blocks())
+	case 4:
+		pattern, err := promptString(c.in, "Geben Sie den Dateipfad der externen Datenquelle ein (Platzhalter wie data/2024-*.csv erlaubt):")
+		if err != nil {
+			return true
+		}
+		preset, err := promptString(c.in, "Importvorlage (leer für keine):")
+		if err != nil {
+			return true
+		}
+
+		var report *ImportReport
+		if preset != "" {
+			report, err = c.pipeline.ImportPreset(c.presets, preset, pattern)
+		} else {
+			var opts ImportOptions
+			if opts.Format, err = promptString(c.in, "Geben Sie das Datenformat ein (csv, tsv, json oder xlsx, leer für Dateiendung):"); err != nil {
+				return true
+			}
+			if opts.Format != "json" && opts.Format != "xlsx" {
+				if opts.Header, opts.ColumnNames, err = promptHeader(c.in); err != nil {
+					return true
+				}
+			}
+			answer, err := promptString(c.in, "Beim ersten Fehler abbrechen? (j/n)")
+			if err != nil {
+				return true
+			}
+			opts.FailFast = strings.EqualFold(answer, "j")
+			report, err = c.pipeline.ImportGlob(pattern, opts)
+		}
+		if err != nil {
+			fmt.Fprintln(c.out, "Fehler beim Einlesen der externen Datenquelle:", err)
+			return false
+		}
+		report.Print(c.out)
+
+	case 5:
+		return true
+
+	case 6:
+		path, err := promptString(c.in, "Geben Sie den Dateipfad für den Bericht ein:")
+		if err != nil {
+			return true
+		}
+
+		if err := writeReportFile(c.bc, path, "html"); err != nil {
+			fmt.Fprintln(c.out, "Fehler beim Erstellen des Berichts:", err)
+			return false
+		}
+		fmt.Fprintln(c.out, "Bericht geschrieben:", path)
+
+	case 7:
+		line, err := promptString(c.in, "Geben Sie die Werte ein (getrennt durch Leerzeichen oder Semikolon):")
+		if err != nil {
+			return true
+		}
+		values, err := parseNumberList(line)
+		if err != nil {
+			fmt.Fprintln(c.out, "Ungültige Eingabe:", err)
+			return false
+		}
+		text, err := promptString(c.in, "Anmerkung (leer für keine):")
+		if err != nil {
+			return true
+		}
+		batch := &Batch{Source: "manual", Values: values, Text: text, Lane: LaneInteractive}
+		if err := c.pipeline.Submit(batch); err != nil {
+			fmt.Fprintln(c.out, "Fehler beim Hinzufügen des Blocks:", err)
+			return false
+		}
+		fmt.Fprintln(c.out, "Block hinzugefügt")
+
+	case 8:
+		locale, err := promptString(c.in, "Zahlenformat (auto, de oder en):")
+		if err != nil {
+			return true
+		}
+		if err := SetNumberLocale(NumberLocale(locale)); err != nil {
+			fmt.Fprintln(c.out, err)
+		}
+
+	case 9:
+		if err := managePresets(c.out, c.presets, c.in); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, errInterrupted) {
+				return true
+			}
+			fmt.Fprintln(c.out, err)
+		}
+
+	case 10:
+		refType, err := promptString(c.in, "Referenztyp (leer für alle):")
+		if err != nil {
+			return true
+		}
+		query, err := promptString(c.in, "Suchbegriff (z. B. JIRA-123):")
+		if err != nil {
+			return true
+		}
+		blocks := c.bc.BlocksReferencing(refType, query)
+		if len(blocks) == 0 {
+			fmt.Fprintln(c.out, "Keine Blöcke gefunden")
+		}
+		for _, block := range blocks {
+			fmt.Fprintf(c.out, "Block %d (%s)\n", block.Index, block.ID)
+			printReferences(c.out, block)
+		}
+
+	case 11:
+		format, err := promptString(c.in, "Exportformat (csv, json, ndjson oder gob):")
+		if err != nil {
+			return true
+		}
+		format = strings.ToLower(format)
+		var within *TimeRange
+		if format == "ndjson" {
+			text, err := promptString(c.in, "Zeitfenster Start/Ende in RFC 3339 (leer für alle Blöcke):")
+			if err != nil {
+				return true
+			}
+			if text != "" {
+				r, err := ParseTimeRange(text)
+				if err != nil {
+					fmt.Fprintln(c.out, "Fehler:", err)
+					return false
+				}
+				within = &r
+			}
+		}
+		path, err := promptString(c.in, "Geben Sie den Dateipfad für den Export ein:")
+		if err != nil {
+			return true
+		}
+		if err := writeExportFile(c.bc, path, format, within); err != nil {
+			fmt.Fprintln(c.out, "Fehler beim Exportieren:", err)
+			return false
+		}
+		fmt.Fprintln(c.out, "Blockchain exportiert:", path)
+
+	case 12:
+		if c.generator.Paused() {
+			c.generator.Resume()
+			fmt.Fprintln(c.out, "Generator fortgesetzt")
+		} else {
+			c.generator.Pause()
+			fmt.Fprintln(c.out, "Generator angehalten")
+		}
+
+	case 13:
+		fmt.Fprintln(c.out, c.bc.AggregateStats())
+
+	case 14:
+		query, err := promptString(c.in, "Suchbegriff:")
+		if err != nil {
+			return true
+		}
+		blocks := c.bc.SearchText(query)
+		if len(blocks) == 0 {
+			fmt.Fprintln(c.out, "Keine Blöcke gefunden")
+		}
+		for _, block := range blocks {
+			fmt.Fprintf(c.out, "Block %d (%s): %s\n", block.Index, block.ID, block.Text)
+		}
+	case 15:
+		if c.server.Addr() != "" {
+			if err := c.server.Stop(); err != nil {
+				fmt.Fprintln(c.out, "Fehler beim Beenden der HTTP-API:", err)
+			} else {
+				fmt.Fprintln(c.out, "HTTP-API beendet")
+			}
+			break
+		}
+		addr, err := promptString(c.in, "Adresse (leer für :8080):")
+		if err != nil {
+			return true
+		}
+		if addr == "" {
+			addr = ":8080"
+		}
+		if err := c.server.Start(addr); err != nil {
+			fmt.Fprintln(c.out, "Fehler beim Starten der HTTP-API:", err)
+		} else {
+			fmt.Fprintln(c.out, "HTTP-API auf", c.server.Addr())
+		}
+	case 16:
+		spec, err := promptString(c.in, "Wertquelle (uniform, normal:mean=10,stddev=2,spikes=0.01 oder replay:datei.csv):")
+		if err != nil {
+			return true
+		}
+		source, err := ParseValueSource(spec)
+		if err != nil {
+			fmt.Fprintln(c.out, "Fehler:", err)
+			break
+		}
+		c.generator.SetSource(source)
+		fmt.Fprintln(c.out, "Wertquelle gesetzt:", spec)
+	case 17:
+		fmt.Fprint(c.out, c.bc.LatestBlock().SummaryString())
+	case 18:
+		window, err := promptInt(c.in, "Anzahl Blöcke (z. B. 20):")
+		if err != nil {
+			return true
+		}
+		fmt.Fprintln(c.out, c.bc.Trend(window))
+	case 19:
+		answer, err := promptString(c.in, "Nur Blöcke mit Ausreißern? (j/n)")
+		if err != nil {
+			return true
+		}
+		if err := watchBlocks(c.out, c.bc, strings.EqualFold(answer, "j"), c.in); err != nil {
+			return true
+		}
+	case 20:
+		if queue := c.pipeline.Queue(); queue != nil {
+			fmt.Fprintln(c.out, queue.Stats())
+		} else {
+			fmt.Fprintln(c.out, "Keine Eingangswarteschlange")
+		}
+	case 21:
+		n, err := promptInt(c.in, "Wie viele Blöcke?")
+		if err != nil {
+			return true
+		}
+		blocks := c.bc.TopByOutliers(n)
+		if len(blocks) == 0 {
+			fmt.Fprintln(c.out, "Keine Blöcke mit Ausreißern")
+		}
+		printBlockSummaries(c.out, blocks)
+	case 22:
+		blocks := c.bc.AnomalousBlocks()
+		if len(blocks) == 0 {
+			fmt.Fprintln(c.out, "Keine anomalen Blöcke")
+		}
+		for _, block := range blocks {
+			fmt.Fprintf(c.out, "Block %d (%s): Mittelwert %.2f, %.1f SD von den vorigen Blöcken entfernt\n",
+				block.Index, block.Timestamp.Format("2006-01-02 15:04:05"), block.Mean, block.AnomalyScore)
+		}
+
+	case 23:
+		if err := c.printRecovery(); err != nil {
+			return true
+		}
+
+	case 24:
+		fmt.Fprintln(c.out, "Verdichtung läuft, neue Blöcke werden bis dahin abgelehnt …")
+		result, err := c.bc.VacuumStorage()
+		if err != nil {
+			fmt.Fprintln(c.out, "Fehler beim Verdichten:", err)
+			return false
+		}
+		fmt.Fprintln(c.out, result)
+
+	case 25:
+		if err := c.printDeadLetters(); err != nil {
+			return true
+		}
+
+	default:
+		fmt.Fprintln(c.out, "Ungültige Auswahl!")
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package main
+
+import "time"
+
+// Clock is the source of time for components that need a fake clock in tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// replayClock is a Clock that returns the time it is set to, as when the
+// blocks of a chain are replayed with their timestamps
+type replayClock struct {
+	now time.Time
+}
+
+func (c *replayClock) Now() time.Time { return c.now }
+
+func (c *replayClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// codecMagic starts every file written through NewCodecWriter
+const codecMagic = "BDSC"
+
+// Codec compresses and decompresses streams for files written by the package
+type Codec interface {
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(gzipCodec{})
+	RegisterCodec(zstdCodec{})
+}
+
+// RegisterCodec makes a codec available by its name
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// CodecByName returns the registered codec with the given name
+func CodecByName(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("Unbekannter Codec: %s (unterstützt: %s)", name, strings.Join(supportedCodecsLocked(), ", "))
+	}
+	return c, nil
+}
+
+// SupportedCodecs returns the names of all registered codecs, sorted
+func SupportedCodecs() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return supportedCodecsLocked()
+}
+
+func supportedCodecsLocked() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCodecWriter writes a header naming the codec to w and returns a writer
+// compressing everything written to it. Closing it does not close w.
+func NewCodecWriter(w io.Writer, codecName string) (io.WriteCloser, error) {
+	c, err := CodecByName(codecName)
+	if err != nil {
+		return nil, err
+	}
+	if len(codecName) > 255 {
+		return nil, fmt.Errorf("Codec-Name zu lang: %s", codecName)
+	}
+
+	header := append([]byte(codecMagic), byte(len(codecName)))
+	header = append(header, codecName...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return c.NewWriter(w)
+}
+
+// NewCodecReader reads the header written by NewCodecWriter from r and
+// returns a reader decompressing the rest with the recorded codec.
+func NewCodecReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(codecMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("Codec-Header nicht lesbar: %w", err)
+	}
+	if string(header[:len(codecMagic)]) != codecMagic {
+		return nil, fmt.Errorf("Kein gültiger Codec-Header")
+	}
+
+	name := make([]byte, header[len(codecMagic)])
+	if _, err := io.ReadFull(br, name); err != nil {
+		return nil, fmt.Errorf("Codec-Header nicht lesbar: %w", err)
+	}
+
+	c, err := CodecByName(string(name))
+	if err != nil {
+		return nil, err
+	}
+	return c.NewReader(br)
+}
+
+// openCodecStream returns the content of r, decompressed if it starts
+// with the header written by NewCodecWriter and as it is otherwise, so
+// readers take files written with any codec or none
+func openCodecStream(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(codecMagic)); string(magic) != codecMagic {
+		return io.NopCloser(br), nil
+	}
+	return NewCodecReader(br)
+}
+
+// readCodecFile returns the content of the file at path, see
+// openCodecStream
+func readCodecFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r, err := openCodecStream(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// writeCodecFile replaces the file at path atomically with data,
+// compressed with codec through NewCodecWriter unless codec is ""
+func writeCodecFile(path string, data []byte, codec string) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	err = writeThroughCodec(file, codec, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// compressRecord compresses data with the named codec, without the
+// header of NewCodecWriter; the block log names its codec once in its
+// state record
+func compressRecord(codec string, data []byte) ([]byte, error) {
+	c, err := CodecByName(codec)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressRecord reverses compressRecord
+func decompressRecord(codec string, data []byte) ([]byte, error) {
+	c, err := CodecByName(codec)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// SetCodec compresses the files the chain writes from now on with the
+// named codec, see SupportedCodecs; "" writes them uncompressed. It
+// applies to SaveToFile, the archive written by Prune, exports and the
+// block log, which is rewritten with the codec at once. Each file names
+// its codec, so files written with another codec or none stay readable.
+func (bc *Blockchain) SetCodec(name string) error {
+	if name != "" {
+		if _, err := CodecByName(name); err != nil {
+			return err
+		}
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if name == bc.codec {
+		return nil
+	}
+	if bc.log != nil && bc.log.file != nil {
+		blocks, err := bc.allBlocks()
+		if err != nil {
+			return err
+		}
+		state := bc.state()
+		state.Codec = name
+		if err := bc.log.rewrite(state, blocks); err != nil {
+			return fmt.Errorf("Blockprotokoll konnte nicht neu geschrieben werden: %w", err)
+		}
+	}
+	bc.codec = name
+	return nil
+}
+
+// Codec returns the codec set with SetCodec, "" if there is none
+func (bc *Blockchain) Codec() string {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.codec
+}
+
+// gzipCodec compresses with compress/gzip
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// zstdCodec compresses with zstd
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return "zstd" }
+
+func (zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
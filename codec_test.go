@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testCodecs are the codecs files are written with in the tests, "" for
+// none
+var testCodecs = append([]string{""}, SupportedCodecs()...)
+
+func TestCodecRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("Blockdaten 1.5;2.5;3.5\n"), 100)
+	for _, codec := range testCodecs {
+		var buf bytes.Buffer
+		err := writeThroughCodec(&buf, codec, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("%q: %v", codec, err)
+		}
+		if compressed := bytes.HasPrefix(buf.Bytes(), []byte(codecMagic)); compressed != (codec != "") {
+			t.Fatalf("%q: header written is %v", codec, compressed)
+		}
+
+		r, err := openCodecStream(&buf)
+		if err != nil {
+			t.Fatalf("%q: %v", codec, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%q: read back %d bytes, %v, want %d", codec, len(got), err, len(data))
+		}
+
+		if codec == "" {
+			continue
+		}
+		record, err := compressRecord(codec, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := decompressRecord(codec, record); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%q: record read back as %d bytes, %v", codec, len(got), err)
+		}
+	}
+}
+
+func TestCodecReaderRejectsUnknownCodec(t *testing.T) {
+	data := append([]byte(codecMagic), 3)
+	data = append(data, "lz4..."...)
+	_, err := openCodecStream(bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), strings.Join(SupportedCodecs(), ", ")) {
+		t.Fatalf("error is %v, want one listing the supported codecs", err)
+	}
+
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetCodec("lz4"); err == nil {
+		t.Fatal("SetCodec accepted an unknown codec")
+	}
+	if err := (&RuntimeConfig{Codec: "lz4"}).Validate(); err == nil {
+		t.Fatal("configuration with an unknown codec is valid")
+	}
+}
+
+func TestSaveToFileWithCodec(t *testing.T) {
+	dir := t.TempDir()
+	for _, codec := range testCodecs {
+		bc := newFilledChain(t)
+		if err := bc.SetCodec(codec); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "chain-"+codec+".json")
+		if err := bc.SaveToFile(path); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compressed := bytes.HasPrefix(data, []byte(codecMagic)); compressed != (codec != "") {
+			t.Fatalf("%q: file compressed is %v", codec, compressed)
+		}
+
+		loaded, err := LoadBlockchainFromFile(path)
+		if err != nil {
+			t.Fatalf("%q: %v", codec, err)
+		}
+		if loaded.Length() != bc.Length() || loaded.HeadHash() != bc.HeadHash() {
+			t.Fatalf("%q: loaded %d blocks with head %s, want %d with %s", codec, loaded.Length(), loaded.HeadHash(), bc.Length(), bc.HeadHash())
+		}
+	}
+}
+
+func TestLoadDirectoryWithMixedCodecs(t *testing.T) {
+	dir := t.TempDir()
+	bc := newFilledChain(t)
+	for i, codec := range testCodecs {
+		if err := bc.SetCodec(codec); err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.SaveToFile(filepath.Join(dir, "snapshot-"+string(rune('a'+i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(testCodecs) {
+		t.Fatalf("directory holds %d files, want %d", len(entries), len(testCodecs))
+	}
+	for _, entry := range entries {
+		loaded, err := LoadBlockchainFromFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatalf("%s: %v", entry.Name(), err)
+		}
+		if loaded.HeadHash() != bc.HeadHash() {
+			t.Fatalf("%s: head is %s, want %s", entry.Name(), loaded.HeadHash(), bc.HeadHash())
+		}
+		if err := loaded.Validate(); err != nil {
+			t.Fatalf("%s: %v", entry.Name(), err)
+		}
+	}
+}
+
+func TestBlockLogWithCodec(t *testing.T) {
+	for _, codec := range SupportedCodecs() {
+		path := filepath.Join(t.TempDir(), "chain.log")
+		bc, err := NewBlockchain(WithPersistence(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+			t.Fatal(err)
+		}
+		// the blocks logged so far are rewritten with the codec
+		if err := bc.SetCodec(codec); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bc.AddBlock([]float64{4, 5, 6}); err != nil {
+			t.Fatal(err)
+		}
+		head := bc.HeadHash()
+		if err := bc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, blocks, _, err := readBlockLog(file)
+		file.Close()
+		if err != nil || state == nil || state.Codec != codec || len(blocks) != 3 {
+			t.Fatalf("%s: log holds state %+v and %d blocks, %v", codec, state, len(blocks), err)
+		}
+
+		resumed, err := NewBlockchain(WithPersistence(path))
+		if err != nil {
+			t.Fatalf("%s: %v", codec, err)
+		}
+		if resumed.HeadHash() != head || resumed.Codec() != codec {
+			t.Fatalf("%s: resumed head %s with codec %q, want %s", codec, resumed.HeadHash(), resumed.Codec(), head)
+		}
+		if _, err := resumed.AddBlock([]float64{7, 8}); err != nil {
+			t.Fatal(err)
+		}
+		resumed.Close()
+	}
+}
+
+func TestPruneArchiveWithCodec(t *testing.T) {
+	dir := t.TempDir()
+	bc := newFilledChain(t)
+	if err := bc.SetCodec("zstd"); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "archive.json")
+	if _, err := bc.Prune(2, archive); err != nil {
+		t.Fatal(err)
+	}
+	// the archive is continued with another codec
+	if err := bc.SetCodec("gzip"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Prune(1, archive); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(codecMagic+"\x04gzip")) {
+		t.Fatal("archive is not compressed with gzip")
+	}
+	block, err := bc.LoadArchivedBlock(1)
+	if err != nil || block.Index != 1 {
+		t.Fatalf("LoadArchivedBlock returned %v, %v", block, err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportWithCodec(t *testing.T) {
+	bc, err := LoadDemoChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, format := range []string{"csv", "json", "ndjson"} {
+		plain := filepath.Join(dir, "plain."+format)
+		if err := writeExportFile(bc, plain, format, nil); err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, codec := range SupportedCodecs() {
+			if err := bc.SetCodec(codec); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, codec+"."+format)
+			if err := writeExportFile(bc, path, format, nil); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readCodecFile(path)
+			if err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("%s export with %s reads back as %d bytes, %v, want the %d of the plain export", format, codec, len(got), err, len(want))
+			}
+		}
+		if err := bc.SetCodec(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestValidateExportWithCodec(t *testing.T) {
+	for _, codec := range SupportedCodecs() {
+		for _, format := range validateExportFormats {
+			bc, err := LoadDemoChain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := bc.SetCodec(codec); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "export."+format)
+			if err := writeExportFile(bc, path, format, nil); err != nil {
+				t.Fatal(err)
+			}
+			v, err := ValidateExportFile(path, "", false)
+			if err != nil {
+				t.Fatalf("%s %s: %v", codec, format, err)
+			}
+			if !v.OK() {
+				t.Fatalf("%s %s: export is not consistent: %+v", codec, format, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// runCommand runs a non-interactive subcommand and returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "report":
+		return runReportCommand(args[1:])
+	case "version":
+		return runVersionCommand()
+	case "compare":
+		return runCompareCommand(args[1:])
+	case "import":
+		return runImportCommand(args[1:])
+	case "preset":
+		return runPresetCommand(args[1:])
+	case "analyze":
+		return runAnalyzeCommand(args[1:])
+	case "selftest":
+		return runSelfTestCommand()
+	case "rebuild":
+		return runRebuildCommand(args[1:])
+	case "demo":
+		return runDemoCommand(args[1:])
+	case "verify-export":
+		return runVerifyExportCommand(args[1:])
+	case "validate-export":
+		return runValidateExportCommand(args[1:])
+	case "vacuum":
+		return runVacuumCommand(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unbekannter Befehl: %s\n", args[0])
+		return 2
+	}
+}
+
+// runReportCommand implements "report --out report.html [--log datei]"
+func runReportCommand(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	out := fs.String("out", "report.html", "Ausgabedatei")
+	format := fs.String("format", "html", "Berichtsformat")
+	logPath := fs.String("log", "", "Protokolldatei, deren letzte Einträge der Bericht zeigt")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *logPath != "" {
+		if err := loadAuditTail(*logPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	bc, err := loadDefaultChain(ChainConfig{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := writeReportFile(bc, *out, *format); err != nil {
+		fmt.Fprintln(os.Stderr, "Fehler beim Erstellen des Berichts:", err)
+		return 1
+	}
+	fmt.Println("Bericht geschrieben:", *out)
+	return 0
+}
+
+// runCompareCommand implements "compare --window1 start/end --window2 start/end"
+func runCompareCommand(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	window1 := fs.String("window1", "", "erstes Zeitfenster, Start/Ende in RFC 3339")
+	window2 := fs.String("window2", "", "zweites Zeitfenster, Start/Ende in RFC 3339")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	a, err := ParseTimeRange(*window1)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	b, err := ParseTimeRange(*window2)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	bc, err := loadDefaultChain(ChainConfig{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	result, err := bc.CompareWindows(a, b)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Fehler beim Vergleichen:", err)
+		return 1
+	}
+	fmt.Print(formatComparison(result))
+	return 0
+}
+
+// runAnalyzeCommand implements "analyze outlier-clusters [--from n] [--to n]".
+// --to -1 stands for the head block.
+func runAnalyzeCommand(args []string) int {
+	if len(args) == 0 || args[0] != "outlier-clusters" {
+		fmt.Fprintln(os.Stderr, "Aufruf: analyze outlier-clusters [--from Index] [--to Index] [--gap Abstand] [--min-count Anzahl]")
+		return 2
+	}
+	fs := flag.NewFlagSet("analyze outlier-clusters", flag.ContinueOnError)
+	from := fs.Int("from", 0, "erster Block")
+	to := fs.Int("to", -1, "letzter Block, -1 für den neuesten")
+	var opts OutlierClusterOptions
+	fs.Float64Var(&opts.Gap, "gap", 0, "Mindestabstand zwischen zwei Clustern, 0 für automatisch")
+	fs.IntVar(&opts.MinCount, "min-count", 0, "Mindestanzahl Ausreißer je Cluster, 0 für 2")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	bc, err := loadDefaultChain(ChainConfig{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *to < 0 {
+		*to = bc.LatestBlock().Index
+	}
+	clusters, err := bc.OutlierClusters(*from, *to, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Fehler bei der Analyse:", err)
+		return 1
+	}
+	fmt.Print(formatOutlierClusters(clusters))
+	return 0
+}
+
+// runRebuildCommand implements "rebuild [--now] [--out file]": it
+// recomputes the stats of the saved chain with the settings of the
+// configuration file and writes the rebuilt chain to out, leaving the
+// saved chain as it is
+func runRebuildCommand(args []string) int {
+	fs := flag.NewFlagSet("rebuild", flag.ContinueOnError)
+	out := fs.String("out", "blockchain.rebuilt.json", "Ausgabedatei der neu berechneten Blockchain")
+	now := fs.Bool("now", false, "Blöcke mit der aktuellen Zeit statt ihrer ursprünglichen versehen")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *out == defaultChainFile {
+		fmt.Fprintf(os.Stderr, "Die gespeicherte Blockchain %s wird nicht überschrieben\n", defaultChainFile)
+		return 2
+	}
+
+	bc, err := loadDefaultChain(ChainConfig{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if path := configFile(); path != "" {
+		if _, err := NewConfigReloader(bc, path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintln(os.Stderr, "Konfiguration konnte nicht geladen werden:", err)
+			return 1
+		}
+	}
+	rebuilt, err := bc.Rebuild(RebuildOptions{StampNow: *now})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Fehler beim Neuberechnen:", err)
+		return 1
+	}
+	if err := rebuilt.SaveToFile(*out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%d Blöcke neu berechnet: %s\n", rebuilt.Length()-1, *out)
+	return 0
+}
+
+// runImportCommand implements "import [--preset name] pattern". The rows
+// are checked against a fresh chain and the import report is printed.
+func runImportCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	preset := fs.String("preset", "", "Name der Importvorlage")
+	format := fs.String("format", "", "Datenformat ohne Vorlage (csv, tsv, json oder xlsx)")
+	header := fs.Bool("header", false, "CSV-Dateien ohne Vorlage haben eine Kopfzeile")
+	names := fs.String("column-names", "", "Spalten ohne Vorlage nach Name, z. B. temp,pressure")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Aufruf: import [--preset Name] Datei")
+		return 2
+	}
+
+	pipeline := NewDefaultPipeline(newBlockchain(ChainConfig{}))
+	var report *ImportReport
+	var err error
+	if *preset != "" {
+		var store *PresetStore
+		if store, err = LoadPresets(presetsFile()); err == nil {
+			report, err = pipeline.ImportPreset(store, *preset, fs.Arg(0))
+		}
+	} else {
+		opts := ImportOptions{Format: *format, Header: *header, ColumnNames: parseColumnNames(*names)}
+		report, err = pipeline.ImportGlob(fs.Arg(0), opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Fehler beim Import:", err)
+		return 1
+	}
+	report.Print(os.Stdout)
+	if report.Failed() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runPresetCommand implements "preset list", "preset delete name" and
+// "preset save [options] name"
+func runPresetCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Aufruf: preset list | save [Optionen] Name | delete Name")
+		return 2
+	}
+	store, err := LoadPresets(presetsFile())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		for _, name := range store.Names() {
+			fmt.Println(name)
+		}
+		return 0
+	case "delete":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "Aufruf: preset delete Name")
+			return 2
+		}
+		err = store.Delete(args[1])
+	case "save":
+		fs := flag.NewFlagSet("preset save", flag.ContinueOnError)
+		var opts ImportOptions
+		fs.StringVar(&opts.Format, "format", "", "Datenformat (csv, tsv, json oder xlsx)")
+		fs.StringVar(&opts.Delimiter, "delimiter", "", "CSV-Trennzeichen")
+		columns := fs.String("columns", "", "Spalten, z. B. 1,3")
+		fs.BoolVar(&opts.Header, "header", false, "CSV-Dateien haben eine Kopfzeile")
+		names := fs.String("column-names", "", "Spalten nach Name, z. B. temp,pressure")
+		locale := fs.String("locale", "", "Zahlenformat (auto, de oder en)")
+		fs.StringVar(&opts.Unit, "unit", "", "Einheit")
+		fs.BoolVar(&opts.FailFast, "fail-fast", false, "beim ersten Fehler abbrechen")
+		fs.BoolVar(&opts.Timed, "timed", false, "CSV-Zeilen aus Zeitpunkt und Wert")
+		fs.IntVar(&opts.SamplesPerBlock, "samples-per-block", 0, "Werte je Block bei -timed, 0 für einen Block je Datei")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "Aufruf: preset save [Optionen] Name")
+			return 2
+		}
+		opts.Locale = NumberLocale(*locale)
+		opts.ColumnNames = parseColumnNames(*names)
+		if opts.Columns, err = parseColumns(*columns); err == nil {
+			err = store.Save(fs.Arg(0), opts)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unbekannter Befehl: preset %s\n", args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// runVersionCommand prints the capabilities of this build
+func runVersionCommand() int {
+	if err := writeCapabilities(os.Stdout, newBlockchain(ChainConfig{}).Capabilities()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// writeReportFile writes the chain report to the file at path
+func writeReportFile(bc *Blockchain, path, format string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := bc.GenerateReport(file, format); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// mainFlags are the flags of the program when it is run without a
+// subcommand. importPath or validatePath run a batch job instead of the
+// menu.
+type mainFlags struct {
+	listen   string
+	metrics  bool
+	source   string
+	interval time.Duration
+	logPath  string
+	dbPath   string
+
+	// exportTTL is how long GET /export keeps a snapshot
+	exportTTL time.Duration
+	// undoWindow is how long a confirmed prune or redact can be undone
+	undoWindow time.Duration
+
+	// slowStorage logs calls to -db slower than it, 0 not at all; with it
+	// or -metrics the calls are timed
+	slowStorage time.Duration
+
+	webhook           string
+	webhookTokenFile  string
+	webhookSecretFile string
+	webhookRetries    int
+	// deadLetters keeps the notifications given up on, deadLetterCap of
+	// them at most
+	deadLetters   string
+	deadLetterCap int
+
+	chainName        string
+	chainDescription string
+
+	queueSize int
+	overflow  OverflowPolicy
+
+	importPath   string
+	format       string
+	out          string
+	validatePath string
+}
+
+// parseMainFlags parses the flags of the program, writing usage and
+// errors of the flags to output
+func parseMainFlags(name string, args []string, output io.Writer) (mainFlags, error) {
+	var f mainFlags
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&f.listen, "listen", "", "Adresse der HTTP-API, z. B. :8080")
+	fs.DurationVar(&f.exportTTL, "export-ttl", defaultExportTTL, "Wie lange GET /export einen Stand für fortgesetzte Downloads aufhebt")
+	fs.DurationVar(&f.undoWindow, "undo-window", defaultUndoWindow, "Wie lange ein bestätigtes Kürzen oder Schwärzen zurückgenommen werden kann, 0 macht es sofort endgültig")
+	fs.BoolVar(&f.metrics, "metrics", false, "GET /metrics der HTTP-API im Prometheus-Format anbieten")
+	fs.StringVar(&f.source, "source", "uniform", "Wertquelle des Generators: uniform, normal:mean=…,stddev=…,spikes=… oder replay:datei.csv")
+	fs.DurationVar(&f.interval, "interval", 5*time.Second, "Abstand der Blöcke des Generators")
+	fs.StringVar(&f.logPath, "log", "", "Blockprotokoll, in das jeder Block sofort geschrieben wird, statt beim Beenden zu speichern")
+	fs.StringVar(&f.dbPath, "db", "", "SQLite-Datenbank, in die jeder Block sofort geschrieben wird, statt beim Beenden zu speichern")
+	fs.DurationVar(&f.slowStorage, "slow-storage", 0, "Zugriffe auf -db protokollieren, die länger dauern, z. B. 100ms")
+	fs.StringVar(&f.webhook, "webhook", "", "URL, an die jeder Block mit Ausreißern oder einer Anomalie als JSON gesendet wird")
+	fs.StringVar(&f.webhookTokenFile, "webhook-token-file", "", "Datei mit dem Bearer-Token für -webhook")
+	fs.StringVar(&f.webhookSecretFile, "webhook-secret-file", "", "Datei mit dem Schlüssel, mit dem -webhook jede Benachrichtigung signiert")
+	fs.IntVar(&f.webhookRetries, "webhook-retries", 3, "Wiederholungen einer fehlgeschlagenen Zustellung an -webhook")
+	fs.StringVar(&f.deadLetters, "webhook-dead-letters", deadLetterFile(), "Datei für Benachrichtigungen an -webhook, die nach allen Wiederholungen nicht zugestellt wurden")
+	fs.IntVar(&f.deadLetterCap, "webhook-dead-letter-cap", defaultDeadLetterCap, "Höchstzahl aufbewahrter unzustellbarer Benachrichtigungen")
+	fs.StringVar(&f.chainName, "chain-name", "", "Name einer neuen Blockchain; eine gespeicherte muss so heißen")
+	fs.StringVar(&f.chainDescription, "chain-description", "", "Beschreibung einer neuen Blockchain")
+	fs.IntVar(&f.queueSize, "queue-size", 10, "Anzahl Batches, die in der Eingangswarteschlange auf die Blockchain warten können")
+	overflow := fs.String("overflow", string(OverflowBlock), "Regel bei voller Eingangswarteschlange: block, drop-newest oder drop-oldest")
+	fs.StringVar(&f.importPath, "import", "", "Datei ohne Menü in eine neue Blockchain importieren, die nach -out geschrieben wird")
+	fs.StringVar(&f.format, "format", "", "Datenformat von -import (csv, tsv, json oder xlsx), sonst nach Dateiendung")
+	fs.StringVar(&f.out, "out", "", "Ausgabedatei der mit -import erstellten Blockchain")
+	fs.StringVar(&f.validatePath, "validate", "", "Blockchain-Datei ohne Menü laden und prüfen")
+	if err := fs.Parse(args); err != nil {
+		return f, err
+	}
+	f.overflow = OverflowPolicy(*overflow)
+
+	switch {
+	case fs.NArg() > 0:
+		return f, fmt.Errorf("Unerwartetes Argument: %s", fs.Arg(0))
+	case f.importPath != "" && f.validatePath != "":
+		return f, errors.New("-import und -validate können nicht zusammen verwendet werden")
+	case f.importPath != "" && f.out == "":
+		return f, errors.New("-import braucht -out")
+	case f.importPath == "" && (f.out != "" || f.format != ""):
+		return f, errors.New("-out und -format gelten nur mit -import")
+	case f.exportTTL <= 0:
+		return f, fmt.Errorf("Ungültige Dauer für -export-ttl: %s", f.exportTTL)
+	case f.undoWindow < 0:
+		return f, fmt.Errorf("Ungültige Dauer für -undo-window: %s", f.undoWindow)
+	case f.metrics && f.listen == "":
+		return f, errors.New("-metrics braucht -listen")
+	case f.logPath != "" && f.dbPath != "":
+		return f, errors.New("-log und -db können nicht zusammen verwendet werden")
+	case f.slowStorage < 0:
+		return f, fmt.Errorf("Ungültige Dauer für -slow-storage: %s", f.slowStorage)
+	case f.slowStorage > 0 && f.dbPath == "":
+		return f, errors.New("-slow-storage braucht -db")
+	case f.webhook == "" && f.webhookTokenFile != "":
+		return f, errors.New("-webhook-token-file braucht -webhook")
+	case f.webhook == "" && f.webhookSecretFile != "":
+		return f, errors.New("-webhook-secret-file braucht -webhook")
+	case f.webhookRetries < 0:
+		return f, fmt.Errorf("Ungültige Anzahl Wiederholungen: %d", f.webhookRetries)
+	case f.deadLetterCap < 1:
+		return f, fmt.Errorf("Ungültige Höchstzahl unzustellbarer Benachrichtigungen: %d", f.deadLetterCap)
+	case f.importPath != "" && f.chainName != "":
+		return f, errors.New("-chain-name gilt nicht mit -import")
+	case f.queueSize < 1:
+		return f, fmt.Errorf("Ungültige Größe der Eingangswarteschlange: %d", f.queueSize)
+	}
+	if err := f.overflow.Validate(); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// batch reports whether the flags ask for a batch job instead of the menu
+func (f mainFlags) batch() bool {
+	return f.importPath != "" || f.validatePath != ""
+}
+
+// runBatch runs the batch job of the flags and returns the exit code
+func runBatch(f mainFlags, stdout, stderr io.Writer) int {
+	if f.validatePath != "" {
+		return runValidateFile(f.validatePath, f.chainName, stdout, stderr)
+	}
+	return runImportToFile(f.importPath, f.format, f.out, stdout, stderr)
+}
+
+// runImportToFile implements "-import data.csv -out chain.json": it
+// imports every row of the file into a new chain, prints a summary and
+// saves the chain to out. If any row fails, nothing is saved.
+func runImportToFile(path, format, out string, stdout, stderr io.Writer) int {
+	bc := newBlockchain(ChainConfig{})
+	report, err := NewDefaultPipeline(bc).ImportGlob(path, ImportOptions{Format: format})
+	if err != nil {
+		fmt.Fprintln(stderr, "Fehler beim Import:", err)
+		return 1
+	}
+	report.Print(stdout)
+	if report.Failed() > 0 {
+		fmt.Fprintf(stderr, "Import fehlgeschlagen, %s wurde nicht geschrieben\n", out)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "Blöcke mit Ausreißern: %d\n", len(bc.OutlierBlocks()))
+	fmt.Fprintln(stdout, bc.AggregateStats())
+	if err := bc.SaveToFile(out); err != nil {
+		fmt.Fprintln(stderr, "Fehler beim Speichern:", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, "Blockchain gespeichert:", out)
+	return 0
+}
+
+// runValidateFile implements "-validate chain.json", which with
+// "-chain-name name" also checks the name of the chain
+func runValidateFile(path, name string, stdout, stderr io.Writer) int {
+	bc, err := LoadNamedBlockchain(path, name)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s ist gültig: %d Blöcke\n", path, bc.Length())
+	return 0
+}
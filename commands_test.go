@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseBatchFlags(t *testing.T) {
+	tests := []struct {
+		args  []string
+		batch bool
+		err   string
+	}{
+		{nil, false, ""},
+		{[]string{"-import", "werte.csv", "-out", "kette.json"}, true, ""},
+		{[]string{"-import", "werte.txt", "-format", "csv", "-out", "kette.json"}, true, ""},
+		{[]string{"-validate", "kette.json", "-chain-name", "labor"}, true, ""},
+		{[]string{"-import", "werte.csv"}, false, "-import braucht -out"},
+		{[]string{"-import", "werte.csv", "-out", "a.json", "-validate", "b.json"}, false, "nicht zusammen verwendet"},
+		{[]string{"-out", "kette.json"}, false, "gelten nur mit -import"},
+		{[]string{"-format", "csv"}, false, "gelten nur mit -import"},
+		{[]string{"-import", "werte.csv", "-out", "a.json", "-chain-name", "labor"}, false, "-chain-name gilt nicht mit -import"},
+		{[]string{"werte.csv"}, false, "Unerwartetes Argument: werte.csv"},
+	}
+	for _, tt := range tests {
+		f, err := parseMainFlags("mutex", tt.args, io.Discard)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("%v returned %v, want %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || f.batch() != tt.batch {
+			t.Fatalf("%v parses to batch %v, %v", tt.args, f.batch(), err)
+		}
+	}
+}
+
+func TestBatchImportAndValidate(t *testing.T) {
+	dir := writeImportFiles(t, map[string]string{
+		"werte.csv":  "1,2,3\n10,10,10,10,10,10,10,10,10,90\n",
+		"kaputt.csv": "1,2\nzwei\n",
+	})
+	out := filepath.Join(dir, "kette.json")
+	run := func(args ...string) (int, string, string) {
+		f, err := parseMainFlags("mutex", args, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stdout, stderr bytes.Buffer
+		return runBatch(f, &stdout, &stderr), stdout.String(), stderr.String()
+	}
+
+	code, stdout, stderr := run("-import", filepath.Join(dir, "werte.csv"), "-out", out)
+	if code != 0 || !strings.Contains(stdout, "Blöcke mit Ausreißern: 1\n") || !strings.Contains(stdout, "Blockchain gespeichert: "+out) {
+		t.Fatalf("import exited with %d:\n%s%s", code, stdout, stderr)
+	}
+	code, stdout, stderr = run("-validate", out)
+	if code != 0 || stdout != out+" ist gültig: 3 Blöcke\n" {
+		t.Fatalf("validate exited with %d:\n%s%s", code, stdout, stderr)
+	}
+	if code, _, stderr = run("-validate", out, "-chain-name", "labor"); code != 1 || stderr == "" {
+		t.Fatalf("validate with another name exited with %d: %s", code, stderr)
+	}
+
+	// a failed row saves nothing
+	failed := filepath.Join(dir, "kaputt.json")
+	code, _, stderr = run("-import", filepath.Join(dir, "kaputt.csv"), "-out", failed)
+	if code != 1 || !strings.Contains(stderr, failed+" wurde nicht geschrieben") {
+		t.Fatalf("import of a bad row exited with %d: %s", code, stderr)
+	}
+	if _, err := os.Stat(failed); !os.IsNotExist(err) {
+		t.Fatalf("chain of a failed import was written: %v", err)
+	}
+	if code, _, _ = run("-validate", filepath.Join(dir, "fehlt.json")); code != 1 {
+		t.Fatalf("validate of a missing file exited with %d", code)
+	}
+}
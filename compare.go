@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrEmptyWindow is returned by CompareWindows for a window without values
+var ErrEmptyWindow = errors.New("Zeitfenster enthält keine Werte")
+
+// windowQuantiles are the percentiles reported for each window
+var windowQuantiles = []float64{5, 25, 50, 75, 95}
+
+// smallSample is the sample size below which results get a caveat
+const smallSample = 30
+
+// TimeRange is the half-open interval [Start, End)
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// ParseTimeRange parses "start/end" with both times in RFC 3339
+func ParseTimeRange(text string) (TimeRange, error) {
+	startText, endText, ok := strings.Cut(text, "/")
+	if !ok {
+		return TimeRange{}, fmt.Errorf("Ungültiges Zeitfenster %q, erwartet Start/Ende", text)
+	}
+	start, err := time.Parse(time.RFC3339, startText)
+	if err != nil {
+		return TimeRange{}, err
+	}
+	end, err := time.Parse(time.RFC3339, endText)
+	if err != nil {
+		return TimeRange{}, err
+	}
+	if !start.Before(end) {
+		return TimeRange{}, fmt.Errorf("Ungültiges Zeitfenster %q: Start liegt nicht vor dem Ende", text)
+	}
+	return TimeRange{Start: start, End: end}, nil
+}
+
+// Contains reports whether t lies in the range
+func (r TimeRange) Contains(t time.Time) bool {
+	return !t.Before(r.Start) && t.Before(r.End)
+}
+
+func (r TimeRange) String() string {
+	return r.Start.Format(time.RFC3339) + "/" + r.End.Format(time.RFC3339)
+}
+
+// EmptyWindowError names the window of a comparison that had no values
+type EmptyWindowError struct {
+	Window string
+	Range  TimeRange
+}
+
+func (e *EmptyWindowError) Error() string {
+	return fmt.Sprintf("%s: %v (%s)", ErrEmptyWindow, e.Window, e.Range)
+}
+
+func (e *EmptyWindowError) Is(target error) bool {
+	return target == ErrEmptyWindow
+}
+
+// WindowStats summarizes the values of the blocks in a time window
+type WindowStats struct {
+	Range     TimeRange          `json:"range"`
+	Blocks    int                `json:"blocks"`
+	Count     int                `json:"count"`
+	Mean      float64            `json:"mean"`
+	StdDev    float64            `json:"stddev"`
+	Quantiles map[string]float64 `json:"quantiles"`
+}
+
+// WindowComparison is the result of CompareWindows. P-values assume
+// independent values; Caveats lists reasons to distrust them.
+type WindowComparison struct {
+	A WindowStats `json:"a"`
+	B WindowStats `json:"b"`
+
+	// CohensD is the difference of the means in pooled standard deviations
+	CohensD float64 `json:"cohens_d"`
+	WelchT  float64 `json:"welch_t"`
+	WelchDF float64 `json:"welch_df"`
+	WelchP  float64 `json:"welch_p"`
+	// KS is the largest distance between the empirical distributions
+	KS  float64 `json:"ks"`
+	KSP float64 `json:"ks_p"`
+
+	Caveats []string `json:"caveats,omitempty"`
+}
+
+// CompareWindows compares the values in a with those in b: the values of
+// timed blocks measured in the window and all values of untimed blocks
+// timestamped in it, using Welch's t-test on the means and a two-sample
+// Kolmogorov-Smirnov test on the distributions. Sampled blocks contribute
+// their stored sample only.
+func (bc *Blockchain) CompareWindows(a, b TimeRange) (*WindowComparison, error) {
+	blocks := bc.snapshot()
+	valuesA, statsA := windowValues(blocks, a)
+	if len(valuesA) == 0 {
+		return nil, &EmptyWindowError{Window: "Fenster 1", Range: a}
+	}
+	valuesB, statsB := windowValues(blocks, b)
+	if len(valuesB) == 0 {
+		return nil, &EmptyWindowError{Window: "Fenster 2", Range: b}
+	}
+
+	result := &WindowComparison{A: statsA, B: statsB}
+	n1, n2 := float64(statsA.Count), float64(statsB.Count)
+	v1, v2 := sampleVariance(valuesA, statsA.Mean), sampleVariance(valuesB, statsB.Mean)
+
+	if pooled := math.Sqrt(((n1-1)*v1 + (n2-1)*v2) / (n1 + n2 - 2)); pooled > 0 {
+		result.CohensD = (statsB.Mean - statsA.Mean) / pooled
+	}
+	se := v1/n1 + v2/n2
+	switch {
+	case statsA.Count < 2 || statsB.Count < 2:
+		result.WelchT, result.WelchDF, result.WelchP = math.NaN(), math.NaN(), math.NaN()
+		result.Caveats = append(result.Caveats, "weniger als 2 Werte in einem Fenster, t-Test nicht definiert")
+	case se > 0:
+		result.WelchT = (statsB.Mean - statsA.Mean) / math.Sqrt(se)
+		result.WelchDF = se * se / (v1*v1/(n1*n1*(n1-1)) + v2*v2/(n2*n2*(n2-1)))
+		result.WelchP = studentTwoSided(result.WelchT, result.WelchDF)
+	default:
+		result.WelchT, result.WelchDF, result.WelchP = math.NaN(), math.NaN(), math.NaN()
+		result.Caveats = append(result.Caveats, "keine Streuung, t-Test nicht definiert")
+	}
+
+	result.KS = ksStatistic(valuesA, valuesB)
+	result.KSP = ksPValue(result.KS, n1, n2)
+
+	if statsA.Count < smallSample || statsB.Count < smallSample {
+		result.Caveats = append(result.Caveats, fmt.Sprintf("weniger als %d Werte in einem Fenster, p-Werte sind nur grobe Näherungen", smallSample))
+	}
+	return result, nil
+}
+
+// windowValues returns the sorted values in r and their stats
+func windowValues(blocks []*Block, r TimeRange) ([]float64, WindowStats) {
+	stats := WindowStats{Range: r}
+	var values []float64
+	for _, block := range blocks[1:] {
+		if !block.overlaps(r) {
+			continue
+		}
+		stats.Blocks++
+		values = append(values, block.valuesWithin(r)...)
+	}
+	if len(values) == 0 {
+		return nil, stats
+	}
+
+	sort.Float64s(values)
+	stats.Count = len(values)
+	stats.Mean = calculateMean(values)
+	stats.StdDev = math.Sqrt(sampleVariance(values, stats.Mean))
+	stats.Quantiles = map[string]float64{}
+	for _, p := range windowQuantiles {
+		stats.Quantiles[fmt.Sprintf("p%g", p)] = quantileSorted(values, p)
+	}
+	return values, stats
+}
+
+// sampleVariance is the unbiased variance; 0 for fewer than two values
+func sampleVariance(values []float64, mean float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	return calculateVariance(values, mean) * float64(len(values)) / float64(len(values)-1)
+}
+
+// quantileSorted interpolates linearly between closest ranks, like
+// Block.Percentile
+func quantileSorted(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lo, hi := int(math.Floor(rank)), int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// ksStatistic is the largest distance between the empirical distribution
+// functions of two sorted samples
+func ksStatistic(a, b []float64) float64 {
+	var i, j int
+	var d float64
+	for i < len(a) && j < len(b) {
+		x := min(a[i], b[j])
+		for i < len(a) && a[i] == x {
+			i++
+		}
+		for j < len(b) && b[j] == x {
+			j++
+		}
+		d = max(d, math.Abs(float64(i)/float64(len(a))-float64(j)/float64(len(b))))
+	}
+	return d
+}
+
+// ksPValue uses the asymptotic Kolmogorov distribution with Stephens'
+// small-sample correction
+func ksPValue(d, n1, n2 float64) float64 {
+	ne := math.Sqrt(n1 * n2 / (n1 + n2))
+	lambda := (ne + 0.12 + 0.11/ne) * d
+	if lambda < 1e-3 {
+		return 1
+	}
+	sum, sign := 0.0, 1.0
+	for k := 1.0; k <= 100; k++ {
+		term := sign * math.Exp(-2*k*k*lambda*lambda)
+		sum += term
+		if math.Abs(term) < 1e-12 {
+			break
+		}
+		sign = -sign
+	}
+	return min(max(2*sum, 0), 1)
+}
+
+// studentTwoSided returns P(|T| >= |t|) for Student's t with df degrees
+// of freedom
+func studentTwoSided(t, df float64) float64 {
+	return incompleteBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// incompleteBeta is the regularized incomplete beta function I_x(a, b),
+// evaluated with Lentz's continued fraction
+func incompleteBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a + b)
+	lb, _ := math.Lgamma(a)
+	lc, _ := math.Lgamma(b)
+	front := math.Exp(la - lb - lc + a*math.Log(x) + b*math.Log(1-x))
+	if x > (a+1)/(a+b+2) {
+		return 1 - front*betaFraction(b, a, 1-x)/b
+	}
+	return front * betaFraction(a, b, x) / a
+}
+
+func betaFraction(a, b, x float64) float64 {
+	const tiny = 1e-300
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	result := d
+	for m := 1.0; m <= 300; m++ {
+		for _, num := range []float64{
+			m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m)),
+			-(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1)),
+		} {
+			d = 1 + num*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + num/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			result *= d * c
+		}
+		if math.Abs(d*c-1) < 1e-14 {
+			break
+		}
+	}
+	return result
+}
+
+// formatComparison renders a comparison for the terminal
+func formatComparison(c *WindowComparison) string {
+	var sb strings.Builder
+	for _, w := range []WindowStats{c.A, c.B} {
+		fmt.Fprintf(&sb, "%s: %d Blöcke, %d Werte, Mittelwert %.4f, Standardabweichung %.4f\n", w.Range, w.Blocks, w.Count, w.Mean, w.StdDev)
+		for _, p := range windowQuantiles {
+			key := fmt.Sprintf("p%g", p)
+			fmt.Fprintf(&sb, "  %s: %.4f\n", key, w.Quantiles[key])
+		}
+	}
+	fmt.Fprintf(&sb, "Cohens d: %.4f\n", c.CohensD)
+	if math.IsNaN(c.WelchT) {
+		sb.WriteString("Welch-t: nicht definiert\n")
+	} else {
+		fmt.Fprintf(&sb, "Welch-t: %.4f (df %.1f), p = %.4g\n", c.WelchT, c.WelchDF, c.WelchP)
+	}
+	fmt.Fprintf(&sb, "KS: %.4f, p = %.4g\n", c.KS, c.KSP)
+	for _, caveat := range c.Caveats {
+		fmt.Fprintf(&sb, "Hinweis: %s\n", caveat)
+	}
+	return sb.String()
+}
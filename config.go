@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RuntimeConfig is the configuration file applied at startup and on every
+// reload. Omitted settings take their defaults.
+//
+// Reloadable: limits, bounds, rules, quality_weights, histogram,
+// histogram_buckets, sample_size, timestamp_policy, outliers, difficulty,
+// trend_flat_threshold, dedup_window, value_encoding, anomaly_window,
+// anomaly_threshold, verify_on_read, retention, tokens and quota_reset.
+// value_kind and id_scheme only apply at startup; a reload that changes
+// them keeps the old value and logs a warning.
+//
+// histogram configures the fixed bins of Block.Binned; histogram_buckets
+// is the bucket count of Block.Histogram, defaultHistogramBuckets if
+// omitted and off at 0. trend_flat_threshold is the flat slope of Trend,
+// defaultTrendFlatThreshold if omitted. anomaly_window and
+// anomaly_threshold configure SetAnomalyDetection; omitted they are
+// defaultAnomalyWindow and defaultAnomalyThreshold, and anomaly_window 0
+// turns the check off. retention is the RetentionPolicy of Compact, with
+// the durations full and stats such as "720h"; omitted it keeps every
+// block. tokens are the access tokens of the REST API, see APIToken;
+// quota_reset is the time of day, "HH:MM" in UTC, their daily quotas
+// start again, midnight if omitted. They apply to the TokenUsage given to
+// ApplyTokens.
+type RuntimeConfig struct {
+	Limits             *BlockLimits     `json:"limits,omitempty"`
+	Bounds             *ValueBounds     `json:"bounds,omitempty"`
+	Rules              []Rule           `json:"rules,omitempty"`
+	QualityWeights     *QualityWeights  `json:"quality_weights,omitempty"`
+	Histogram          *HistogramBins   `json:"histogram,omitempty"`
+	HistogramBuckets   *int             `json:"histogram_buckets,omitempty"`
+	SampleSize         int              `json:"sample_size,omitempty"`
+	TimestampPolicy    TimestampPolicy  `json:"timestamp_policy,omitempty"`
+	Outliers           *OutlierConfig   `json:"outliers,omitempty"`
+	Difficulty         int              `json:"difficulty,omitempty"`
+	TrendFlatThreshold *float64         `json:"trend_flat_threshold,omitempty"`
+	DedupWindow        int              `json:"dedup_window,omitempty"`
+	ValueEncoding      ValueEncoding    `json:"value_encoding,omitempty"`
+	AnomalyWindow      *int             `json:"anomaly_window,omitempty"`
+	AnomalyThreshold   *float64         `json:"anomaly_threshold,omitempty"`
+	VerifyOnRead       bool             `json:"verify_on_read,omitempty"`
+	Retention          *RetentionPolicy `json:"retention,omitempty"`
+	Tokens             []APIToken       `json:"tokens,omitempty"`
+	QuotaReset         string           `json:"quota_reset,omitempty"`
+	Codec              string           `json:"codec,omitempty"`
+	TrackOrigins       bool             `json:"track_origins,omitempty"`
+	ExportOrigins      bool             `json:"export_origins,omitempty"`
+
+	ValueKind ValueKind `json:"value_kind,omitempty"`
+	IDScheme  IDScheme  `json:"id_scheme,omitempty"`
+}
+
+// configFile returns the path of the configuration file in the user's home
+// directory, or "" if there is none
+func configFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".block_data_save.json")
+}
+
+// LoadRuntimeConfig reads and validates the configuration file at path.
+// Unknown keys are rejected so that typos do not go unnoticed.
+func LoadRuntimeConfig(path string) (*RuntimeConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var cfg RuntimeConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("Konfigurationsdatei %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("Konfigurationsdatei %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Validate checks every setting of the configuration
+func (c *RuntimeConfig) Validate() error {
+	if c.Bounds != nil {
+		if err := c.Bounds.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, rule := range c.Rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.QualityWeights != nil {
+		if err := c.QualityWeights.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Histogram != nil {
+		if err := c.Histogram.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.HistogramBuckets != nil && (*c.HistogramBuckets < 0 || *c.HistogramBuckets > maxHistogramBuckets) {
+		return fmt.Errorf("Ungültige Anzahl Histogrammklassen: %d (erlaubt 0 bis %d)", *c.HistogramBuckets, maxHistogramBuckets)
+	}
+	if c.SampleSize < 0 {
+		return fmt.Errorf("Ungültige Stichprobengröße: %d", c.SampleSize)
+	}
+	switch c.TimestampPolicy {
+	case "", TimestampAllow, TimestampClamp, TimestampReject:
+	default:
+		return fmt.Errorf("Unbekannte Zeitstempel-Richtlinie: %s", c.TimestampPolicy)
+	}
+	if c.Outliers != nil {
+		if err := c.Outliers.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Difficulty < 0 || c.Difficulty > maxDifficulty {
+		return fmt.Errorf("Ungültige Schwierigkeit: %d (erlaubt 0 bis %d)", c.Difficulty, maxDifficulty)
+	}
+	if t := c.TrendFlatThreshold; t != nil && (!(*t >= 0) || math.IsInf(*t, 0)) {
+		return fmt.Errorf("Ungültige Schwelle für gleichbleibenden Trend: %v", *t)
+	}
+	if c.DedupWindow < 0 {
+		return fmt.Errorf("Ungültiges Fenster für doppelte Blöcke: %d", c.DedupWindow)
+	}
+	if c.ValueEncoding != "" {
+		if err := c.ValueEncoding.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.AnomalyWindow != nil && *c.AnomalyWindow < 0 {
+		return fmt.Errorf("Ungültiges Fenster für Anomalien: %d", *c.AnomalyWindow)
+	}
+	if t := c.AnomalyThreshold; t != nil && (!(*t > 0) || math.IsInf(*t, 0)) {
+		return fmt.Errorf("Ungültige Schwelle für Anomalien: %v", *t)
+	}
+	if c.Retention != nil {
+		if err := c.Retention.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateTokens(c.Tokens, c.QuotaReset); err != nil {
+		return err
+	}
+	if c.Codec != "" {
+		if _, err := CodecByName(c.Codec); err != nil {
+			return err
+		}
+	}
+	switch c.ValueKind {
+	case "", KindFloat, KindInt:
+	default:
+		return fmt.Errorf("Unbekannter Werttyp: %s", c.ValueKind)
+	}
+	if c.IDScheme != "" {
+		if _, err := newBlockID(c.IDScheme, time.Now(), rand.Reader); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configSetting is one entry of the configuration and whether a reload may
+// change it
+type configSetting struct {
+	name       string
+	reloadable bool
+	value      func(*RuntimeConfig) any
+}
+
+var configSettings = []configSetting{
+	{"limits", true, func(c *RuntimeConfig) any { return c.Limits }},
+	{"bounds", true, func(c *RuntimeConfig) any { return c.Bounds }},
+	{"rules", true, func(c *RuntimeConfig) any { return c.Rules }},
+	{"quality_weights", true, func(c *RuntimeConfig) any { return c.QualityWeights }},
+	{"histogram", true, func(c *RuntimeConfig) any { return c.Histogram }},
+	{"histogram_buckets", true, func(c *RuntimeConfig) any { return c.HistogramBuckets }},
+	{"sample_size", true, func(c *RuntimeConfig) any { return c.SampleSize }},
+	{"timestamp_policy", true, func(c *RuntimeConfig) any { return c.TimestampPolicy }},
+	{"outliers", true, func(c *RuntimeConfig) any { return c.Outliers }},
+	{"difficulty", true, func(c *RuntimeConfig) any { return c.Difficulty }},
+	{"trend_flat_threshold", true, func(c *RuntimeConfig) any { return c.TrendFlatThreshold }},
+	{"dedup_window", true, func(c *RuntimeConfig) any { return c.DedupWindow }},
+	{"value_encoding", true, func(c *RuntimeConfig) any { return c.ValueEncoding }},
+	{"anomaly_window", true, func(c *RuntimeConfig) any { return c.AnomalyWindow }},
+	{"anomaly_threshold", true, func(c *RuntimeConfig) any { return c.AnomalyThreshold }},
+	{"verify_on_read", true, func(c *RuntimeConfig) any { return c.VerifyOnRead }},
+	{"retention", true, func(c *RuntimeConfig) any { return c.Retention }},
+	{"tokens", true, func(c *RuntimeConfig) any { return c.Tokens }},
+	{"quota_reset", true, func(c *RuntimeConfig) any { return c.QuotaReset }},
+	{"codec", true, func(c *RuntimeConfig) any { return c.Codec }},
+	{"track_origins", true, func(c *RuntimeConfig) any { return c.TrackOrigins }},
+	{"export_origins", true, func(c *RuntimeConfig) any { return c.ExportOrigins }},
+	{"value_kind", false, func(c *RuntimeConfig) any { return c.ValueKind }},
+	{"id_scheme", false, func(c *RuntimeConfig) any { return c.IDScheme }},
+}
+
+// ConfigChange is a setting whose value differs between two configurations
+type ConfigChange struct {
+	Setting string
+	Old     string
+	New     string
+}
+
+func (c ConfigChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Setting, c.Old, c.New)
+}
+
+// diffConfig returns the reloadable and the non-reloadable settings that
+// differ between old and new
+func diffConfig(old, new *RuntimeConfig) (reloadable, fixed []ConfigChange) {
+	for _, setting := range configSettings {
+		before, _ := json.Marshal(setting.value(old))
+		after, _ := json.Marshal(setting.value(new))
+		if bytes.Equal(before, after) {
+			continue
+		}
+		change := ConfigChange{Setting: setting.name, Old: string(before), New: string(after)}
+		if setting.reloadable {
+			reloadable = append(reloadable, change)
+		} else {
+			fixed = append(fixed, change)
+		}
+	}
+	return reloadable, fixed
+}
+
+// ConfigReloader applies a configuration file to a chain and re-applies it
+// on Reload or SIGHUP
+type ConfigReloader struct {
+	bc   *Blockchain
+	path string
+
+	mu      sync.Mutex
+	current *RuntimeConfig
+	usage   *TokenUsage
+}
+
+// NewConfigReloader loads the configuration at path and applies all of
+// it, including the settings that cannot be reloaded later
+func NewConfigReloader(bc *Blockchain, path string) (*ConfigReloader, error) {
+	cfg, err := LoadRuntimeConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ValueKind != "" {
+		if err := bc.SetValueKind(cfg.ValueKind); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.IDScheme != "" {
+		if err := bc.SetIDScheme(cfg.IDScheme); err != nil {
+			return nil, err
+		}
+	}
+	if err := bc.SetCodec(cfg.Codec); err != nil {
+		return nil, err
+	}
+	bc.applyConfig(cfg)
+	return &ConfigReloader{bc: bc, path: path, current: cfg}, nil
+}
+
+// Reload re-reads the configuration file and applies the reloadable
+// settings at once. An invalid file changes nothing. Changes to settings
+// that only apply at startup are ignored with a warning. Applied changes
+// are logged and returned.
+func (r *ConfigReloader) Reload() ([]ConfigChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := LoadRuntimeConfig(r.path)
+	if err != nil {
+		log.Println("Konfiguration nicht neu geladen:", err)
+		return nil, err
+	}
+	applied, fixed := diffConfig(r.current, cfg)
+	for _, change := range fixed {
+		log.Printf("Warnung: %s kann nur beim Start geändert werden, Änderung ignoriert (%s)", change.Setting, change)
+	}
+	cfg.ValueKind, cfg.IDScheme = r.current.ValueKind, r.current.IDScheme
+
+	// the only setting that can fail to apply, so it goes first
+	if err := r.bc.SetCodec(cfg.Codec); err != nil {
+		log.Println("Konfiguration nicht neu geladen:", err)
+		return nil, err
+	}
+	r.bc.applyConfig(cfg)
+	if r.usage != nil {
+		// validated with the rest of cfg
+		r.usage.Configure(cfg.Tokens, cfg.QuotaReset)
+	}
+	r.current = cfg
+	for _, change := range applied {
+		log.Println("Konfiguration geändert:", change)
+	}
+	return applied, nil
+}
+
+// ApplyTokens configures usage with the tokens and the quota reset of the
+// configuration in effect, and again on every reload
+func (r *ConfigReloader) ApplyTokens(usage *TokenUsage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.usage = usage
+	usage.Configure(r.current.Tokens, r.current.QuotaReset)
+}
+
+// Current returns a copy of the configuration in effect
+func (r *ConfigReloader) Current() RuntimeConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return *r.current
+}
+
+// WatchSignals reloads the configuration on every SIGHUP until ctx is done
+func (r *ConfigReloader) WatchSignals(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				r.Reload()
+			}
+		}
+	}()
+}
+
+// applyConfig installs the reloadable settings of a validated cfg in one
+// step, so concurrent appends see either the old or the new settings
+func (bc *Blockchain) applyConfig(cfg *RuntimeConfig) {
+	limits := DefaultBlockLimits
+	if cfg.Limits != nil {
+		limits = *cfg.Limits
+	}
+	weights := DefaultQualityWeights
+	if cfg.QualityWeights != nil {
+		weights = *cfg.QualityWeights
+	}
+	policy := cfg.TimestampPolicy
+	if policy == "" {
+		policy = TimestampAllow
+	}
+	var bounds *ValueBounds
+	if cfg.Bounds != nil {
+		copied := *cfg.Bounds
+		bounds = &copied
+	}
+	outliers := DefaultOutlierConfig
+	if cfg.Outliers != nil {
+		outliers = cfg.Outliers.normalized()
+	}
+	var bins *HistogramBins
+	if cfg.Histogram != nil {
+		copied := *cfg.Histogram
+		bins = &copied
+	}
+	buckets := defaultHistogramBuckets
+	if cfg.HistogramBuckets != nil {
+		buckets = *cfg.HistogramBuckets
+	}
+	flat := defaultTrendFlatThreshold
+	if cfg.TrendFlatThreshold != nil {
+		flat = *cfg.TrendFlatThreshold
+	}
+	encoding := cfg.ValueEncoding
+	if encoding == "" {
+		encoding = EncodingNone
+	}
+	anomalyWindow, anomalyThreshold := defaultAnomalyWindow, defaultAnomalyThreshold
+	if cfg.AnomalyWindow != nil {
+		anomalyWindow = *cfg.AnomalyWindow
+	}
+	if cfg.AnomalyThreshold != nil {
+		anomalyThreshold = *cfg.AnomalyThreshold
+	}
+	var retention RetentionPolicy
+	if cfg.Retention != nil {
+		retention = *cfg.Retention
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.limits = limits
+	bc.bounds = bounds
+	bc.rules = append([]Rule(nil), cfg.Rules...)
+	bc.qualityWeights = weights
+	bc.bins = bins
+	bc.histogramBuckets = buckets
+	bc.sampleSize = cfg.SampleSize
+	bc.timestampPolicy = policy
+	bc.outliers = outliers
+	bc.difficulty = cfg.Difficulty
+	bc.trendFlatThreshold = flat
+	bc.dedupWindow = cfg.DedupWindow
+	bc.valueEncoding = encoding
+	bc.anomalyWindow = anomalyWindow
+	bc.anomalyThreshold = anomalyThreshold
+	bc.verifyOnRead = cfg.VerifyOnRead
+	bc.trackOrigins = cfg.TrackOrigins
+	bc.exportOrigins = cfg.ExportOrigins
+	bc.retention = retention
+}
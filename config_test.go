@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(`{"value_kind": "float", "outliers": {"method": "sd", "sd_multiplier": 2}}`)
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader, err := NewConfigReloader(bc, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		config     string
+		err        bool
+		changes    []string
+		multiplier float64
+		difficulty int
+		maxText    int
+	}{
+		{
+			name:       "valid",
+			config:     `{"value_kind": "float", "outliers": {"method": "sd", "sd_multiplier": 3}, "difficulty": 1, "limits": {"max_text_bytes": 10, "max_metadata_keys": 4, "max_key_length": 8, "max_value_length": 8}}`,
+			changes:    []string{"limits", "outliers", "difficulty"},
+			multiplier: 3,
+			difficulty: 1,
+			maxText:    10,
+		},
+		{
+			name:       "invalid JSON",
+			config:     `{"difficulty": 2,`,
+			err:        true,
+			multiplier: 3,
+			difficulty: 1,
+			maxText:    10,
+		},
+		{
+			name:       "invalid setting",
+			config:     `{"value_kind": "float", "outliers": {"method": "sd", "sd_multiplier": -1}, "difficulty": 2}`,
+			err:        true,
+			multiplier: 3,
+			difficulty: 1,
+			maxText:    10,
+		},
+		{
+			name:       "unknown key",
+			config:     `{"difficulty": 2, "sigma": 4}`,
+			err:        true,
+			multiplier: 3,
+			difficulty: 1,
+			maxText:    10,
+		},
+		{
+			name:       "startup setting ignored",
+			config:     `{"value_kind": "int", "outliers": {"method": "sd", "sd_multiplier": 2.5}}`,
+			changes:    []string{"limits", "outliers", "difficulty"},
+			multiplier: 2.5,
+			maxText:    DefaultBlockLimits.MaxTextBytes,
+		},
+	}
+	for _, tt := range tests {
+		writeConfig(tt.config)
+		changes, err := reloader.Reload()
+		if (err != nil) != tt.err {
+			t.Fatalf("%s: reload returned %v", tt.name, err)
+		}
+		var settings []string
+		for _, change := range changes {
+			settings = append(settings, change.Setting)
+		}
+		if strings.Join(settings, ",") != strings.Join(tt.changes, ",") {
+			t.Fatalf("%s: reload changed %v, want %v", tt.name, settings, tt.changes)
+		}
+		if got := bc.OutlierConfig().SDMultiplier; got != tt.multiplier {
+			t.Fatalf("%s: sd_multiplier is %v, want %v", tt.name, got, tt.multiplier)
+		}
+		if got := bc.Difficulty(); got != tt.difficulty {
+			t.Fatalf("%s: difficulty is %d, want %d", tt.name, got, tt.difficulty)
+		}
+		if got := bc.Limits().MaxTextBytes; got != tt.maxText {
+			t.Fatalf("%s: max_text_bytes is %d, want %d", tt.name, got, tt.maxText)
+		}
+	}
+
+	// value_kind keeps the value it had at startup
+	if bc.ValueKind() != KindFloat || reloader.Current().ValueKind != KindFloat {
+		t.Fatalf("value kind is %s after a reload that changed it", bc.ValueKind())
+	}
+	if _, err := bc.AddBlock([]float64{1.5, 2.5}); err != nil {
+		t.Fatal(err)
+	}
+}
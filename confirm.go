@@ -0,0 +1,442 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultUndoWindow is how long a confirmed operation can be rolled
+	// back unless the Confirmer is given another window
+	defaultUndoWindow = 10 * time.Minute
+	// previewTTL is how long a preview waits for its confirmation
+	previewTTL = 5 * time.Minute
+)
+
+var (
+	// ErrPreviewNotFound is returned for the token of a preview that was
+	// never made, already confirmed or expired
+	ErrPreviewNotFound = errors.New("Vorschau nicht gefunden oder abgelaufen")
+	// ErrPreviewStale is returned for the confirmation of a preview of a
+	// chain that changed since, so the preview no longer shows what would
+	// happen
+	ErrPreviewStale = errors.New("Blockchain wurde seit der Vorschau geändert")
+	// ErrNothingToUndo is returned for a rollback without a confirmed
+	// operation of that token in its undo window
+	ErrNothingToUndo = errors.New("Kein Vorgang kann rückgängig gemacht werden")
+	// ErrUndoConflict is returned for a rollback after the blocks of the
+	// chain were changed by something other than appending
+	ErrUndoConflict = errors.New("Blockchain wurde seither geändert, der Vorgang kann nicht rückgängig gemacht werden")
+	// errNoChange is returned for a preview of an operation that would
+	// change nothing
+	errNoChange = errors.New("Der Vorgang würde nichts ändern")
+)
+
+// IndexRange is the blocks with an index from From to To, both included
+type IndexRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+func (r IndexRange) String() string {
+	if r.From == r.To {
+		return fmt.Sprintf("Block %d", r.From)
+	}
+	return fmt.Sprintf("Blöcke %d bis %d", r.From, r.To)
+}
+
+// Preview describes what a destructive operation will change before it
+// is confirmed with its Token, see Confirmer
+type Preview struct {
+	Token    string       `json:"token"`
+	Op       string       `json:"op"`
+	Summary  string       `json:"summary"`
+	Affected []IndexRange `json:"affected"`
+	Blocks   int          `json:"blocks"`
+	// HeadHash is the hash of the head now and ResultHeadHash the one it
+	// will have; Prune and Redact keep the hashes
+	HeadHash       string    `json:"head_hash"`
+	ResultHeadHash string    `json:"result_head_hash"`
+	Created        time.Time `json:"created"`
+	Expires        time.Time `json:"expires"`
+}
+
+// String renders the preview for the menu
+func (p Preview) String() string {
+	ranges := make([]string, len(p.Affected))
+	for i, r := range p.Affected {
+		ranges[i] = r.String()
+	}
+	return fmt.Sprintf("%s\n  Betroffen: %s (%d Blöcke)\n  Kopf danach: %s\n  Bestätigen mit %s bis %s",
+		p.Summary, strings.Join(ranges, ", "), p.Blocks, formatHash(p.ResultHeadHash), p.Token, p.Expires.Format("15:04:05"))
+}
+
+// Confirmation is a confirmed operation. Until UndoUntil it can be rolled
+// back with the token of its preview; once it passed, the operation is
+// final.
+type Confirmation struct {
+	Preview
+	ConfirmedAt time.Time `json:"confirmed_at"`
+	UndoUntil   time.Time `json:"undo_until"`
+}
+
+// stagedOp is an operation a preview was made of, of a chain rewritten
+// rewrites times. apply runs it with bc.mu held; archivePath is the
+// archive file it writes, if any.
+type stagedOp struct {
+	preview     Preview
+	rewrites    int
+	archivePath string
+	apply       func() error
+}
+
+// undoRecord is what rolling back a confirmed operation needs: the blocks
+// it changed or deleted and the state and archive file before it
+type undoRecord struct {
+	confirmation Confirmation
+	rewrites     int
+	blocks       []*Block
+	state        *chainState
+	// archivePath, if set, is restored to archive, or removed if archive
+	// is nil
+	archivePath string
+	archive     []byte
+}
+
+// Confirmer guards the destructive operations of a chain, Prune and
+// Redact: each gets a preview first, runs only when the token of the
+// preview is confirmed, and can be rolled back within the undo window
+// after. The last confirmed operation is kept for that; confirming
+// another one makes it final. Every confirmation, rollback and finalizing
+// is logged.
+type Confirmer struct {
+	bc     *Blockchain
+	window time.Duration
+	clock  Clock
+	// token returns the token of a new preview
+	token func() (string, error)
+
+	mu       sync.Mutex
+	previews map[string]*stagedOp
+	undo     *undoRecord
+}
+
+// NewConfirmer returns a Confirmer of bc whose operations can be rolled
+// back for window once confirmed; 0 makes them final at once
+func NewConfirmer(bc *Blockchain, window time.Duration, clock Clock) *Confirmer {
+	return &Confirmer{bc: bc, window: window, clock: clock, token: previewToken, previews: make(map[string]*stagedOp)}
+}
+
+// previewToken returns a random token
+func previewToken() (string, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+// PreviewPrune previews Prune(keepLast, archivePath)
+func (c *Confirmer) PreviewPrune(keepLast int, archivePath string) (Preview, error) {
+	bc := c.bc
+	return c.stage(func() (*stagedOp, error) {
+		cut, err := bc.pruneCut(keepLast, archivePath)
+		if err != nil {
+			return nil, err
+		}
+		if cut == 0 {
+			return nil, errNoChange
+		}
+		return &stagedOp{
+			preview: Preview{
+				Op:       "prune",
+				Summary:  fmt.Sprintf("%d Blöcke nach %s archivieren und aus der Blockchain löschen", cut, archivePath),
+				Affected: []IndexRange{{From: bc.base, To: bc.base + cut - 1}},
+				Blocks:   cut,
+			},
+			archivePath: archivePath,
+			apply: func() error {
+				_, err := bc.prune(keepLast, archivePath)
+				return err
+			},
+		}, nil
+	})
+}
+
+// PreviewRedact previews Redact(from, to)
+func (c *Confirmer) PreviewRedact(from, to int) (Preview, error) {
+	bc := c.bc
+	return c.stage(func() (*stagedOp, error) {
+		blocks, err := bc.redactable(from, to)
+		if err != nil {
+			return nil, err
+		}
+		if len(blocks) == 0 {
+			return nil, errNoChange
+		}
+		return &stagedOp{
+			preview: Preview{
+				Op:       "redact",
+				Summary:  fmt.Sprintf("Werte von %d Blöcken verwerfen, ihre Statistik bleibt", len(blocks)),
+				Affected: indexRanges(blocks),
+				Blocks:   len(blocks),
+			},
+			apply: func() error {
+				_, err := bc.redact(from, to)
+				return err
+			},
+		}, nil
+	})
+}
+
+// indexRanges returns the runs of consecutive indexes of blocks, which
+// are in order
+func indexRanges(blocks []*Block) []IndexRange {
+	var ranges []IndexRange
+	for _, block := range blocks {
+		if n := len(ranges); n > 0 && ranges[n-1].To == block.Index-1 {
+			ranges[n-1].To = block.Index
+			continue
+		}
+		ranges = append(ranges, IndexRange{From: block.Index, To: block.Index})
+	}
+	return ranges
+}
+
+// stage keeps the operation describe returns, run with c.bc.mu held for
+// reading, under a new token until its preview expires
+func (c *Confirmer) stage(describe func() (*stagedOp, error)) (Preview, error) {
+	token, err := c.token()
+	if err != nil {
+		return Preview{}, err
+	}
+	bc := c.bc
+	bc.mu.RLock()
+	op, err := describe()
+	if err == nil {
+		op.rewrites = bc.rewrites
+		op.preview.HeadHash = bc.head.Hash
+		op.preview.ResultHeadHash = bc.head.Hash
+	}
+	bc.mu.RUnlock()
+	if err != nil {
+		return Preview{}, err
+	}
+
+	now := c.clock.Now()
+	op.preview.Token = token
+	op.preview.Created, op.preview.Expires = now, now.Add(previewTTL)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for token, staged := range c.previews {
+		if !now.Before(staged.preview.Expires) {
+			delete(c.previews, token)
+		}
+	}
+	c.previews[op.preview.Token] = op
+	return op.preview, nil
+}
+
+// Confirm runs the operation previewed with token, if the chain is still
+// as the preview saw it. The operation before it becomes final.
+func (c *Confirmer) Confirm(token string) (Confirmation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	op := c.previews[token]
+	if op == nil || !now.Before(op.preview.Expires) {
+		delete(c.previews, token)
+		return Confirmation{}, fmt.Errorf("%w: %s", ErrPreviewNotFound, token)
+	}
+	delete(c.previews, token)
+
+	bc := c.bc
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.rewrites != op.rewrites || bc.head.Hash != op.preview.HeadHash {
+		return Confirmation{}, ErrPreviewStale
+	}
+	undo, err := c.record(op)
+	if err != nil {
+		return Confirmation{}, err
+	}
+	if err := op.apply(); err != nil {
+		return Confirmation{}, err
+	}
+	c.finalize("durch einen neuen Vorgang")
+
+	confirmation := Confirmation{Preview: op.preview, ConfirmedAt: now}
+	log.Printf("Vorgang %s bestätigt (%s): %s, %d Blöcke", op.preview.Op, token, op.preview.Summary, op.preview.Blocks)
+	if c.window <= 0 {
+		log.Printf("Vorgang %s (%s) ist endgültig", op.preview.Op, token)
+		return confirmation, nil
+	}
+	confirmation.UndoUntil = now.Add(c.window)
+	undo.confirmation = confirmation
+	undo.rewrites = bc.rewrites
+	c.undo = undo
+	go c.finalizeAfter(token, c.clock.After(c.window))
+	return confirmation, nil
+}
+
+// record returns what rolling back op needs. Called with c.mu and c.bc.mu
+// held.
+func (c *Confirmer) record(op *stagedOp) (*undoRecord, error) {
+	bc := c.bc
+	undo := &undoRecord{state: bc.state(), archivePath: op.archivePath}
+	for _, r := range op.preview.Affected {
+		blocks, err := bc.blocks(r.From-bc.base, r.To-bc.base+1)
+		if err != nil {
+			return nil, err
+		}
+		undo.blocks = append(undo.blocks, blocks...)
+	}
+	if op.archivePath != "" {
+		data, err := os.ReadFile(op.archivePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		undo.archive = data
+	}
+	return undo, nil
+}
+
+// Pending returns the confirmed operation that can still be rolled back,
+// nil if there is none
+func (c *Confirmer) Pending() *Confirmation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	if c.undo == nil {
+		return nil
+	}
+	confirmation := c.undo.confirmation
+	return &confirmation
+}
+
+// Rollback undoes the confirmed operation of token within its undo
+// window. Blocks appended since stay; if blocks were changed otherwise,
+// nothing is rolled back and ErrUndoConflict is returned.
+func (c *Confirmer) Rollback(token string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	undo := c.undo
+	if undo == nil || undo.confirmation.Token != token {
+		return fmt.Errorf("%w: %s", ErrNothingToUndo, token)
+	}
+
+	bc := c.bc
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.rewrites != undo.rewrites {
+		return ErrUndoConflict
+	}
+	if undo.archivePath != "" {
+		if err := restoreFile(undo.archivePath, undo.archive); err != nil {
+			return err
+		}
+	}
+	// the old blocks replace the redacted ones and come back in front of
+	// the pruned chain
+	if err := bc.rewriteChain(0, 0, undo.blocks, undo.state); err != nil {
+		return err
+	}
+	c.undo = nil
+	log.Printf("Vorgang %s (%s) rückgängig gemacht: %d Blöcke wiederhergestellt", undo.confirmation.Op, token, len(undo.blocks))
+	return nil
+}
+
+// restoreFile gives the file at path the content data, or removes it if
+// data is nil
+func restoreFile(path string, data []byte) error {
+	if data == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// expire makes the confirmed operation final once its undo window passed.
+// Called with c.mu held.
+func (c *Confirmer) expire() {
+	if c.undo != nil && !c.clock.Now().Before(c.undo.confirmation.UndoUntil) {
+		c.finalize("nach Ablauf der Frist")
+	}
+}
+
+// finalizeAfter makes the operation confirmed with token final once
+// passed fires, unless it was rolled back or made final before
+func (c *Confirmer) finalizeAfter(token string, passed <-chan time.Time) {
+	<-passed
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.undo != nil && c.undo.confirmation.Token == token {
+		c.finalize("nach Ablauf der Frist")
+	}
+}
+
+// finalize drops the undo record of the confirmed operation, which makes
+// it final. Called with c.mu held.
+func (c *Confirmer) finalize(why string) {
+	if c.undo == nil {
+		return
+	}
+	log.Printf("Vorgang %s (%s) ist %s endgültig", c.undo.confirmation.Op, c.undo.confirmation.Token, why)
+	c.undo = nil
+}
+
+// confirm shows the preview of the menu command prune or redact and runs
+// the operation once its token is typed
+func (c *cli) confirm(preview Preview, err error) {
+	if err != nil {
+		fmt.Fprintln(c.out, "Fehler:", err)
+		return
+	}
+	fmt.Fprintln(c.out, preview)
+	answer, err := promptString(c.in, "Zum Bestätigen das Token eingeben (leer bricht ab)")
+	if err != nil {
+		return
+	}
+	if answer = strings.TrimSpace(answer); answer != preview.Token {
+		fmt.Fprintln(c.out, "Abgebrochen, nichts wurde geändert")
+		return
+	}
+	confirmation, err := c.confirmer.Confirm(answer)
+	if err != nil {
+		fmt.Fprintln(c.out, "Fehler:", err)
+		return
+	}
+	if confirmation.UndoUntil.IsZero() {
+		fmt.Fprintln(c.out, "Bestätigt und endgültig")
+		return
+	}
+	fmt.Fprintf(c.out, "Bestätigt, mit undo bis %s zurückzunehmen\n", confirmation.UndoUntil.Format("15:04:05"))
+}
+
+// undo implements the menu command undo
+func (c *cli) undo() {
+	pending := c.confirmer.Pending()
+	if pending == nil {
+		fmt.Fprintln(c.out, ErrNothingToUndo)
+		return
+	}
+	if err := c.confirmer.Rollback(pending.Token); err != nil {
+		fmt.Fprintln(c.out, "Fehler:", err)
+		return
+	}
+	fmt.Fprintf(c.out, "Zurückgenommen: %s\n", pending.Summary)
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestConfirmer returns a Confirmer of bc with an undo window of ten
+// minutes on a test clock, whose tokens are t1, t2 and so on
+func newTestConfirmer(bc *Blockchain) (*Confirmer, *testClock) {
+	clock := &testClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	confirmer := NewConfirmer(bc, 10*time.Minute, clock)
+	var n int
+	confirmer.token = func() (string, error) {
+		n++
+		return fmt.Sprintf("t%d", n), nil
+	}
+	return confirmer, clock
+}
+
+func TestConfirmPruneRollback(t *testing.T) {
+	for _, sqlite := range []bool{false, true} {
+		t.Run(fmt.Sprintf("sqlite=%t", sqlite), func(t *testing.T) {
+			logged := captureLog(t)
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "archive.json")
+			bc := newFilledChain(t)
+			var before []byte
+			if sqlite {
+				// the chain is pruned once before, so the rollback has
+				// an archive to restore
+				var err error
+				if bc, err = openSQLiteChain(filepath.Join(dir, "chain.db"), ChainConfig{}); err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { bc.Close() })
+				for _, values := range bulkRows(8, 5) {
+					if _, err := bc.AddBlock(values); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if _, err := bc.Prune(6, archive); err != nil {
+					t.Fatal(err)
+				}
+				if before, err = os.ReadFile(archive); err != nil {
+					t.Fatal(err)
+				}
+			}
+			start, head := bc.HistoryStart(), bc.HeadHash()
+			held := blockHashes(bc.Blocks())
+
+			confirmer, _ := newTestConfirmer(bc)
+			preview, err := confirmer.PreviewPrune(2, archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cut := len(held) - 2
+			want := []IndexRange{{From: start, To: start + cut - 1}}
+			if preview.Op != "prune" || preview.Blocks != cut || !slices.Equal(preview.Affected, want) ||
+				preview.HeadHash != head || preview.ResultHeadHash != head {
+				t.Fatalf("preview is %+v, want %d blocks in %v", preview, cut, want)
+			}
+			if bc.HistoryStart() != start || len(bc.Blocks()) != len(held) {
+				t.Fatal("preview changed the chain")
+			}
+
+			confirmation, err := confirmer.Confirm(preview.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if confirmation.UndoUntil != confirmation.ConfirmedAt.Add(10*time.Minute) {
+				t.Fatalf("confirmation is %+v, want an undo window of ten minutes", confirmation)
+			}
+			if bc.HistoryStart() != start+cut || bc.HeadHash() != head {
+				t.Fatalf("confirmed prune holds blocks from %d with head %s", bc.HistoryStart(), bc.HeadHash())
+			}
+			if pending := confirmer.Pending(); pending == nil || pending.Token != preview.Token {
+				t.Fatalf("pending operation is %+v, want the prune", pending)
+			}
+			// an append does not keep the prune from being undone
+			if _, err := bc.AddBlock(chainTestValues[0]); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := confirmer.Rollback(preview.Token); err != nil {
+				t.Fatal(err)
+			}
+			if bc.HistoryStart() != start {
+				t.Fatalf("rolled back chain holds blocks from %d, want %d", bc.HistoryStart(), start)
+			}
+			if got := blockHashes(bc.Blocks()); !slices.Equal(got[:len(got)-1], held) {
+				t.Fatal("rolled back chain does not hold the pruned blocks and the appended one")
+			}
+			if err := bc.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(archive)
+			switch {
+			case before == nil && !errors.Is(err, os.ErrNotExist):
+				t.Fatalf("archive written by the prune is still there after the rollback: %v", err)
+			case before != nil && !bytes.Equal(data, before):
+				t.Fatal("archive is not restored to what it held before the prune")
+			}
+			if err := confirmer.Rollback(preview.Token); !errors.Is(err, ErrNothingToUndo) {
+				t.Fatalf("second rollback returned %v, want ErrNothingToUndo", err)
+			}
+			for _, want := range []string{"Vorgang prune bestätigt (t1)", "Vorgang prune (t1) rückgängig gemacht"} {
+				if !strings.Contains(logged.String(), want) {
+					t.Fatalf("log does not contain %q:\n%s", want, logged)
+				}
+			}
+		})
+	}
+}
+
+func TestConfirmRedactRollback(t *testing.T) {
+	captureLog(t)
+	bc := newFilledChain(t)
+	held := blockHashes(bc.Blocks())
+	confirmer, _ := newTestConfirmer(bc)
+	if _, err := bc.Redact(2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// block 2 has no values left, so the redaction is of two ranges
+	preview, err := confirmer.PreviewRedact(1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []IndexRange{{1, 1}, {3, 4}}; preview.Op != "redact" || preview.Blocks != 3 || !slices.Equal(preview.Affected, want) {
+		t.Fatalf("preview is %+v, want 3 blocks in %v", preview, want)
+	}
+	if _, err := confirmer.Confirm(preview.Token); err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range []int{1, 3, 4} {
+		if block, _ := bc.BlockByIndex(index); !block.ValuesDropped {
+			t.Fatalf("block %d keeps its values after the redaction", index)
+		}
+	}
+
+	if err := confirmer.Rollback(preview.Token); err != nil {
+		t.Fatal(err)
+	}
+	for i, index := range []int{1, 3, 4} {
+		block, _ := bc.BlockByIndex(index)
+		if block.ValuesDropped || !slices.Equal(block.Values, chainTestValues[[]int{0, 2, 3}[i]]) {
+			t.Fatalf("block %d is %+v after the rollback, want its values back", index, block)
+		}
+	}
+	if block, _ := bc.BlockByIndex(2); !block.ValuesDropped {
+		t.Fatal("rollback gave back the values of block 2, redacted before the preview")
+	}
+	if got := blockHashes(bc.Blocks()); !slices.Equal(got, held) {
+		t.Fatal("hashes changed through the redaction and its rollback")
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := confirmer.PreviewRedact(2, 2); !errors.Is(err, errNoChange) {
+		t.Fatalf("preview of a redacted block returned %v, want errNoChange", err)
+	}
+}
+
+func TestConfirmRejects(t *testing.T) {
+	logged := captureLog(t)
+	archive := filepath.Join(t.TempDir(), "archive.json")
+	bc := newFilledChain(t)
+	for _, values := range bulkRows(8, 5) {
+		if _, err := bc.AddBlock(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	confirmer, clock := newTestConfirmer(bc)
+
+	if _, err := confirmer.Confirm("unbekannt"); !errors.Is(err, ErrPreviewNotFound) {
+		t.Fatalf("confirmation of an unknown token returned %v, want ErrPreviewNotFound", err)
+	}
+	if _, err := confirmer.PreviewPrune(0, archive); err == nil {
+		t.Fatal("preview of a prune keeping no block was accepted")
+	}
+
+	// the chain changed since the preview
+	preview, err := confirmer.PreviewPrune(12, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock(chainTestValues[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := confirmer.Confirm(preview.Token); !errors.Is(err, ErrPreviewStale) {
+		t.Fatalf("confirmation of a stale preview returned %v, want ErrPreviewStale", err)
+	}
+	if bc.HistoryStart() != 0 {
+		t.Fatal("stale preview was run")
+	}
+
+	// the preview expired
+	preview, err = confirmer.PreviewPrune(12, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.now = clock.now.Add(previewTTL)
+	if _, err := confirmer.Confirm(preview.Token); !errors.Is(err, ErrPreviewNotFound) {
+		t.Fatalf("confirmation of an expired preview returned %v, want ErrPreviewNotFound", err)
+	}
+
+	// a token confirms once, and the undo window passes
+	if preview, err = confirmer.PreviewPrune(12, archive); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := confirmer.Confirm(preview.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := confirmer.Confirm(preview.Token); !errors.Is(err, ErrPreviewNotFound) {
+		t.Fatalf("second confirmation returned %v, want ErrPreviewNotFound", err)
+	}
+	clock.now = clock.now.Add(10 * time.Minute)
+	if pending := confirmer.Pending(); pending != nil {
+		t.Fatalf("operation %+v is pending after its undo window", pending)
+	}
+	if err := confirmer.Rollback(preview.Token); !errors.Is(err, ErrNothingToUndo) {
+		t.Fatalf("rollback after the undo window returned %v, want ErrNothingToUndo", err)
+	}
+	if !strings.Contains(logged.String(), "Vorgang prune ("+preview.Token+") ist nach Ablauf der Frist endgültig") {
+		t.Fatalf("log does not record the operation as final:\n%s", logged)
+	}
+
+	// a rewrite since the confirmation keeps it from being undone
+	if preview, err = confirmer.PreviewPrune(10, archive); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := confirmer.Confirm(preview.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Prune(8, archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := confirmer.Rollback(preview.Token); !errors.Is(err, ErrUndoConflict) {
+		t.Fatalf("rollback after a rewrite returned %v, want ErrUndoConflict", err)
+	}
+
+	// confirming another operation makes the one before final
+	first, _ := confirmer.PreviewPrune(6, archive)
+	if _, err := confirmer.Confirm(first.Token); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := confirmer.PreviewPrune(4, archive)
+	if _, err := confirmer.Confirm(second.Token); err != nil {
+		t.Fatal(err)
+	}
+	if err := confirmer.Rollback(first.Token); !errors.Is(err, ErrNothingToUndo) {
+		t.Fatalf("rollback of an operation followed by another returned %v, want ErrNothingToUndo", err)
+	}
+	if !strings.Contains(logged.String(), "Vorgang prune ("+first.Token+") ist durch einen neuen Vorgang endgültig") {
+		t.Fatalf("log does not record the operation as final:\n%s", logged)
+	}
+}
+
+func TestConfirmWithoutUndoWindow(t *testing.T) {
+	logged := captureLog(t)
+	bc := newFilledChain(t)
+	confirmer := NewConfirmer(bc, 0, realClock{})
+	preview, err := confirmer.PreviewPrune(2, filepath.Join(t.TempDir(), "archive.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmation, err := confirmer.Confirm(preview.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !confirmation.UndoUntil.IsZero() || confirmer.Pending() != nil {
+		t.Fatalf("confirmation is %+v, want it final", confirmation)
+	}
+	if !strings.Contains(logged.String(), "Vorgang prune ("+preview.Token+") ist endgültig") {
+		t.Fatalf("log does not record the operation as final:\n%s", logged)
+	}
+}
+
+func TestAPIOperations(t *testing.T) {
+	captureLog(t)
+	bc := newFilledChain(t)
+	confirmer, _ := newTestConfirmer(bc)
+	handler := NewAPIHandler(bc, NewDefaultPipeline(bc), WithConfirmer(confirmer), withAdminToken(t))
+	archive := filepath.Join(t.TempDir(), "archive.json")
+
+	var preview Preview
+	decodeResponse(t, serveAdmin(handler, "POST", "/operations/prune", `{"keep_last": 2, "archive": "`+archive+`"}`), http.StatusCreated, &preview)
+	if preview.Token != "t1" || preview.Blocks != 4 || preview.HeadHash != bc.HeadHash() {
+		t.Fatalf("POST /operations/prune returned %+v", preview)
+	}
+	if bc.HistoryStart() != 0 {
+		t.Fatal("preview pruned the chain")
+	}
+	expectAPIError(t, serveAdmin(handler, "GET", "/operations/pending", ""), http.StatusNotFound)
+
+	var confirmation Confirmation
+	decodeResponse(t, serveAdmin(handler, "POST", "/operations/t1/confirm", ""), http.StatusOK, &confirmation)
+	if confirmation.Token != "t1" || confirmation.UndoUntil.IsZero() || bc.HistoryStart() != 4 {
+		t.Fatalf("POST /operations/t1/confirm returned %+v", confirmation)
+	}
+	expectAPIError(t, serveAdmin(handler, "POST", "/operations/t1/confirm", ""), http.StatusNotFound)
+	decodeResponse(t, serveAdmin(handler, "GET", "/operations/pending", ""), http.StatusOK, &confirmation)
+	if confirmation.Op != "prune" {
+		t.Fatalf("GET /operations/pending returned %+v", confirmation)
+	}
+
+	var rolledBack map[string]string
+	decodeResponse(t, serveAdmin(handler, "POST", "/operations/t1/rollback", ""), http.StatusOK, &rolledBack)
+	if rolledBack["rolled_back"] != "t1" || bc.HistoryStart() != 0 {
+		t.Fatalf("POST /operations/t1/rollback returned %v, chain holds blocks from %d", rolledBack, bc.HistoryStart())
+	}
+	expectAPIError(t, serveAdmin(handler, "POST", "/operations/t1/rollback", ""), http.StatusNotFound)
+
+	decodeResponse(t, serveAdmin(handler, "POST", "/operations/prune", `{"keep_last": 3, "archive": "`+archive+`"}`), http.StatusCreated, &preview)
+	if preview.Token != "t2" || !slices.Equal(preview.Affected, []IndexRange{{0, 2}}) {
+		t.Fatalf("POST /operations/prune returned %+v", preview)
+	}
+	if _, err := bc.AddBlock(chainTestValues[0]); err != nil {
+		t.Fatal(err)
+	}
+	expectAPIError(t, serveAdmin(handler, "POST", "/operations/t2/confirm", ""), http.StatusConflict)
+
+	expectAPIError(t, serveAdmin(handler, "POST", "/operations/prune", `{"keep_last": 0, "archive": "`+archive+`"}`), http.StatusBadRequest)
+	expectAPIError(t, serveAdmin(handler, "POST", "/operations/prune", `{"keep": 2}`), http.StatusBadRequest)
+
+	decodeResponse(t, serveAdmin(handler, "POST", "/operations/redact", `{"from": 1, "to": 3}`), http.StatusCreated, &preview)
+	if preview.Op != "redact" || !slices.Equal(preview.Affected, []IndexRange{{1, 3}}) {
+		t.Fatalf("POST /operations/redact returned %+v", preview)
+	}
+	expectAPIError(t, serveAdmin(handler, "POST", "/operations/redact", `{"from": 1, "to": 99}`), http.StatusNotFound)
+}
+
+func TestCLIConfirm(t *testing.T) {
+	captureLog(t)
+	bc := newFilledChain(t)
+	confirmer, _ := newTestConfirmer(bc)
+	archive := filepath.Join(t.TempDir(), "archive.json")
+	var out bytes.Buffer
+	pipeline := NewDefaultPipeline(bc)
+	c := &cli{
+		bc:        bc,
+		pipeline:  pipeline,
+		generator: NewGenerator(pipeline.ForSource("generator"), GeneratorConfig{}),
+		server:    NewAPIServer(bc, pipeline, WithConfirmer(confirmer)),
+		confirmer: confirmer,
+		in:        NewPlainLineReader(strings.NewReader("redact 1 2\nfalsch\nprune 2 "+archive+"\nt2\nundo\nundo\n"), &out),
+		out:       &out,
+	}
+	c.presets, _ = LoadPresets("")
+	c.run()
+	for _, want := range []string{
+		"Werte von 2 Blöcken verwerfen",
+		"Abgebrochen, nichts wurde geändert",
+		"4 Blöcke nach " + archive + " archivieren",
+		"Betroffen: Blöcke 0 bis 3 (4 Blöcke)",
+		"Bestätigt, mit undo bis 12:10:00 zurückzunehmen",
+		"Zurückgenommen: 4 Blöcke nach",
+		ErrNothingToUndo.Error(),
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("menu output does not contain %q:\n%s", want, out.String())
+		}
+	}
+	if block, _ := bc.BlockByIndex(1); block.ValuesDropped || bc.HistoryStart() != 0 {
+		t.Fatal("chain changed through the cancelled redaction or the undone prune")
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDeadLetterCap is the number of undeliverable notifications a
+// dead letter store keeps unless told otherwise
+const defaultDeadLetterCap = 1000
+
+var (
+	// ErrDeadLetterNotFound is returned for an unknown dead letter ID
+	ErrDeadLetterNotFound = errors.New("Unzustellbare Benachrichtigung nicht gefunden")
+	// ErrNoDeadLetters is returned for dead letters of a notifier that
+	// keeps none, or of an API without a notifier
+	ErrNoDeadLetters = errors.New("Keine Ablage für unzustellbare Benachrichtigungen")
+)
+
+// DeadLetter is a notification given up after its last retry, kept to be
+// replayed
+type DeadLetter struct {
+	ID        int64           `json:"id"`
+	Target    string          `json:"target"`
+	Index     int             `json:"index"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	FailedAt  time.Time       `json:"failed_at"`
+}
+
+// DeadLetterStore keeps the notifications a WebhookNotifier gave up on in
+// a JSON file, oldest first. Once it holds its cap, the oldest is dropped
+// for each new one.
+type DeadLetterStore struct {
+	path string
+	cap  int
+
+	mu      sync.Mutex
+	letters []DeadLetter
+	nextID  int64
+}
+
+// deadLetterFile returns the default location of the dead letter file
+func deadLetterFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".block_data_save_deadletters.json")
+}
+
+// OpenDeadLetterStore reads the dead letters stored at path, keeping at
+// most cap of them. A missing file is an empty store; an empty path keeps
+// them in memory only.
+func OpenDeadLetterStore(path string, cap int) (*DeadLetterStore, error) {
+	if cap < 1 {
+		return nil, fmt.Errorf("Ungültige Größe der Ablage für unzustellbare Benachrichtigungen: %d", cap)
+	}
+	store := &DeadLetterStore{path: path, cap: cap, nextID: 1}
+	if path == "" {
+		return store, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store.letters); err != nil {
+		return nil, fmt.Errorf("Ablage %s: %w", path, err)
+	}
+	for _, letter := range store.letters {
+		store.nextID = max(store.nextID, letter.ID+1)
+	}
+	if n := len(store.letters) - cap; n > 0 {
+		store.letters = slices.Delete(store.letters, 0, n)
+	}
+	return store, nil
+}
+
+// List returns the dead letters, oldest first
+func (s *DeadLetterStore) List() []DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.letters)
+}
+
+// Len returns the number of dead letters
+func (s *DeadLetterStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.letters)
+}
+
+// add stores letter under a new ID, dropping the oldest if the store is
+// full
+func (s *DeadLetterStore) add(letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.letters
+	letter.ID = s.nextID
+	letters := append(slices.Clone(s.letters), letter)
+	if n := len(letters) - s.cap; n > 0 {
+		for _, dropped := range letters[:n] {
+			log.Printf("Ablage voll: unzustellbare Benachrichtigung %d für Block %d an %s verworfen", dropped.ID, dropped.Index, dropped.Target)
+		}
+		letters = letters[n:]
+	}
+	s.letters = letters
+	if err := s.write(); err != nil {
+		s.letters = previous
+		return err
+	}
+	s.nextID++
+	return nil
+}
+
+// get returns the dead letter with id
+func (s *DeadLetterStore) get(id int64) (DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.find(id); i >= 0 {
+		return s.letters[i], nil
+	}
+	return DeadLetter{}, fmt.Errorf("%w: %d", ErrDeadLetterNotFound, id)
+}
+
+// update replaces the dead letter with the ID of letter, or removes it if
+// remove is set; a letter dropped meanwhile is left out
+func (s *DeadLetterStore) update(letter DeadLetter, remove bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(letter.ID)
+	if i < 0 {
+		return nil
+	}
+	previous := s.letters
+	s.letters = slices.Clone(s.letters)
+	if remove {
+		s.letters = slices.Delete(s.letters, i, i+1)
+	} else {
+		s.letters[i] = letter
+	}
+	if err := s.write(); err != nil {
+		s.letters = previous
+		return err
+	}
+	return nil
+}
+
+// find returns the position of the dead letter with id, -1 if there is
+// none. Called with s.mu held.
+func (s *DeadLetterStore) find(id int64) int {
+	return slices.IndexFunc(s.letters, func(letter DeadLetter) bool { return letter.ID == id })
+}
+
+// write replaces the dead letter file. Called with s.mu held.
+func (s *DeadLetterStore) write() error {
+	if s.path == "" {
+		return nil
+	}
+	letters := s.letters
+	if letters == nil {
+		letters = []DeadLetter{}
+	}
+	// not indented, so the payloads are replayed byte for byte
+	data, err := json.Marshal(letters)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// ReplayResult lists the dead letters a replay delivered, which are
+// removed, and those that failed again, with their new attempt
+type ReplayResult struct {
+	Delivered []int64      `json:"delivered"`
+	Failed    []DeadLetter `json:"failed"`
+}
+
+func (r ReplayResult) String() string {
+	return fmt.Sprintf("Wiederholung: %d zugestellt, %d erneut fehlgeschlagen", len(r.Delivered), len(r.Failed))
+}
+
+// DeadLetters returns the dead letter store of the notifier, nil if it
+// keeps none
+func (n *WebhookNotifier) DeadLetters() *DeadLetterStore {
+	return n.cfg.DeadLetters
+}
+
+// ReplayDeadLetters sends the dead letters with ids once more, each to
+// its target with the token and secret of the notifier. A delivered one
+// is removed from the store; one that fails again keeps its place with
+// the attempt counted. Every replay is logged. Unknown ids fail before
+// anything is sent.
+func (n *WebhookNotifier) ReplayDeadLetters(ids []int64) (ReplayResult, error) {
+	store := n.cfg.DeadLetters
+	if store == nil {
+		return ReplayResult{}, ErrNoDeadLetters
+	}
+	letters := make([]DeadLetter, 0, len(ids))
+	for _, id := range ids {
+		letter, err := store.get(id)
+		if err != nil {
+			return ReplayResult{}, err
+		}
+		letters = append(letters, letter)
+	}
+	return n.replay(letters)
+}
+
+// ReplayTarget replays every dead letter for target, see
+// ReplayDeadLetters
+func (n *WebhookNotifier) ReplayTarget(target string) (ReplayResult, error) {
+	store := n.cfg.DeadLetters
+	if store == nil {
+		return ReplayResult{}, ErrNoDeadLetters
+	}
+	var letters []DeadLetter
+	for _, letter := range store.List() {
+		if letter.Target == target {
+			letters = append(letters, letter)
+		}
+	}
+	return n.replay(letters)
+}
+
+// replay sends letters once each, one replay at a time, so a letter is
+// not sent twice by replays running together
+func (n *WebhookNotifier) replay(letters []DeadLetter) (ReplayResult, error) {
+	n.replayMu.Lock()
+	defer n.replayMu.Unlock()
+	store := n.cfg.DeadLetters
+	result := ReplayResult{Delivered: []int64{}, Failed: []DeadLetter{}}
+	for _, letter := range letters {
+		// a replay just before may have delivered or retried it
+		letter, err := store.get(letter.ID)
+		if err != nil {
+			continue
+		}
+		if err = n.post(letter.Target, letter.Payload); err == nil {
+			log.Printf("Wiederholung: Benachrichtigung %d für Block %d an %s zugestellt", letter.ID, letter.Index, letter.Target)
+			result.Delivered = append(result.Delivered, letter.ID)
+			if err := store.update(letter, true); err != nil {
+				return result, err
+			}
+			continue
+		}
+		log.Printf("Wiederholung: Benachrichtigung %d für Block %d an %s erneut fehlgeschlagen: %v", letter.ID, letter.Index, letter.Target, err)
+		letter.Attempts++
+		letter.LastError = err.Error()
+		letter.FailedAt = time.Now()
+		result.Failed = append(result.Failed, letter)
+		if err := store.update(letter, false); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// printDeadLetters shows the dead letters in the menu and asks which to
+// replay
+func (c *cli) printDeadLetters() error {
+	if c.notifier == nil || c.notifier.DeadLetters() == nil {
+		fmt.Fprintln(c.out, ErrNoDeadLetters)
+		return nil
+	}
+	letters := c.notifier.DeadLetters().List()
+	if len(letters) == 0 {
+		fmt.Fprintln(c.out, "Keine unzustellbaren Benachrichtigungen")
+		return nil
+	}
+	for _, letter := range letters {
+		fmt.Fprintf(c.out, "%d. Block %d an %s, %d Versuche, zuletzt %s: %s\n",
+			letter.ID, letter.Index, letter.Target, letter.Attempts, letter.FailedAt.Format("2006-01-02 15:04:05"), letter.LastError)
+	}
+	answer, err := promptString(c.in, "Wiederholen: Nummern, alle oder eine Ziel-URL (leer für keine)")
+	if err != nil {
+		return err
+	}
+	var result ReplayResult
+	switch answer = strings.TrimSpace(answer); {
+	case answer == "":
+		return nil
+	case strings.EqualFold(answer, "alle"):
+		ids := make([]int64, len(letters))
+		for i, letter := range letters {
+			ids[i] = letter.ID
+		}
+		result, err = c.notifier.ReplayDeadLetters(ids)
+	case strings.Contains(answer, "://"):
+		result, err = c.notifier.ReplayTarget(answer)
+	default:
+		var ids []int64
+		for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' }) {
+			id, perr := strconv.ParseInt(field, 10, 64)
+			if perr != nil {
+				fmt.Fprintf(c.out, "Ungültige Nummer: %s\n", field)
+				return nil
+			}
+			ids = append(ids, id)
+		}
+		result, err = c.notifier.ReplayDeadLetters(ids)
+	}
+	if err != nil {
+		fmt.Fprintln(c.out, "Fehler bei der Wiederholung:", err)
+		return nil
+	}
+	fmt.Fprintln(c.out, result)
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyReceiver is a webhookReceiver that drops the connection of every
+// notification while down is set, as an endpoint that is down would
+type flakyReceiver struct {
+	webhookReceiver
+	down atomic.Bool
+}
+
+func (rv *flakyReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rv.down.Load() {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+	rv.webhookReceiver.ServeHTTP(w, r)
+}
+
+// failDeliveries starts a notifier on a new chain that posts to a down
+// receiver and keeps what it gives up on in a store at a new path, and
+// adds n blocks with outliers. It waits until all of them failed.
+func failDeliveries(t *testing.T, n int) (*WebhookNotifier, *flakyReceiver, *httptest.Server, string) {
+	t.Helper()
+	receiver := &flakyReceiver{}
+	receiver.down.Store(true)
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	path := filepath.Join(t.TempDir(), "deadletters.json")
+	store, err := OpenDeadLetterStore(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBlockchain(WithChainConfig(ChainConfig{Name: "alarm"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier, err := NewWebhookNotifier(bc, WebhookConfig{URL: server.URL, Secret: "schlüssel", Retries: 1, Backoff: time.Millisecond, DeadLetters: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(notifier.Close)
+	for i := 0; i < n; i++ {
+		if _, err := bc.AddBlock(chainTestValues[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for notifier.Stats().Failed < int64(n) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := notifier.Stats(); stats.Failed != int64(n) {
+		t.Fatalf("stats are %+v, want %d failed deliveries", stats, n)
+	}
+	return notifier, receiver, server, path
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	logged := captureLog(t)
+	notifier, receiver, server, path := failDeliveries(t, 2)
+	store := notifier.DeadLetters()
+	letters := store.List()
+	if len(letters) != 2 {
+		t.Fatalf("store holds %d dead letters, want 2", len(letters))
+	}
+	for i, letter := range letters {
+		var payload webhookPayload
+		if err := json.Unmarshal(letter.Payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if letter.ID != int64(i+1) || letter.Target != server.URL || letter.Attempts != 2 || letter.LastError == "" ||
+			letter.Index != i+1 || payload.Index != letter.Index || payload.Chain != "alarm" {
+			t.Fatalf("dead letter %d is %+v with payload %s", i, letter, letter.Payload)
+		}
+	}
+	reopened, err := OpenDeadLetterStore(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.List(); len(got) != 2 || got[1].ID != 2 || !bytes.Equal(got[1].Payload, letters[1].Payload) {
+		t.Fatalf("reopened store holds %+v", got)
+	}
+
+	// while the receiver is down a replay fails again and counts it
+	result, err := notifier.ReplayDeadLetters([]int64{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Delivered) != 0 || len(result.Failed) != 1 || result.Failed[0].Attempts != 3 || store.Len() != 2 {
+		t.Fatalf("replay to a down receiver returned %+v", result)
+	}
+	if _, err := notifier.ReplayDeadLetters([]int64{1, 99}); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("replay of an unknown dead letter returned %v, want ErrDeadLetterNotFound", err)
+	}
+
+	receiver.down.Store(false)
+	if result, err = notifier.ReplayDeadLetters([]int64{2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Delivered) != 1 || result.Delivered[0] != 2 || store.Len() != 1 {
+		t.Fatalf("replay of dead letter 2 returned %+v", result)
+	}
+	if result, err = notifier.ReplayTarget(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Delivered) != 1 || result.Delivered[0] != 1 || store.Len() != 0 {
+		t.Fatalf("replay of the target returned %+v, want the store empty", result)
+	}
+	if receiver.attempts() != 2 {
+		t.Fatalf("receiver got %d notifications, want the 2 replayed", receiver.attempts())
+	}
+	req, body := receiver.first(t)
+	if !bytes.Equal(body, letters[1].Payload) || req.Header.Get(webhookSignatureHeader) != signWebhookBody("schlüssel", body) {
+		t.Fatalf("replayed notification is %s, want the signed payload of dead letter 2", body)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "[]" {
+		t.Fatalf("store file holds %q after the replay, want an empty list", data)
+	}
+	for _, want := range []string{
+		"Wiederholung: Benachrichtigung 1 für Block 1 an " + server.URL + " erneut fehlgeschlagen",
+		"Wiederholung: Benachrichtigung 2 für Block 2 an " + server.URL + " zugestellt",
+		"Wiederholung: Benachrichtigung 1 für Block 1 an " + server.URL + " zugestellt",
+	} {
+		if !strings.Contains(logged.String(), want) {
+			t.Fatalf("log does not contain %q:\n%s", want, logged)
+		}
+	}
+}
+
+func TestDeadLetterCap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletters.json")
+	store, err := OpenDeadLetterStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureLog(t)
+	for i := 1; i <= 3; i++ {
+		if err := store.add(DeadLetter{Target: "http://example.com/hook", Index: i, Payload: json.RawMessage(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if letters := store.List(); len(letters) != 2 || letters[0].ID != 2 || letters[1].ID != 3 {
+		t.Fatalf("full store holds %+v, want dead letters 2 and 3", letters)
+	}
+
+	smaller, err := OpenDeadLetterStore(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if letters := smaller.List(); len(letters) != 1 || letters[0].ID != 3 {
+		t.Fatalf("store reopened with cap 1 holds %+v, want dead letter 3", letters)
+	}
+	if err := smaller.add(DeadLetter{Index: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if letters := smaller.List(); letters[0].ID != 4 {
+		t.Fatalf("dead letter added after reopening has ID %d, want 4", letters[0].ID)
+	}
+	if _, err := OpenDeadLetterStore(path, 0); err == nil {
+		t.Fatal("store without room was accepted")
+	}
+}
+
+func TestAPIDeadLetters(t *testing.T) {
+	captureLog(t)
+	notifier, receiver, server, _ := failDeliveries(t, 2)
+	bc, _ := newTestAPI(t)
+	handler := NewAPIHandler(bc, NewDefaultPipeline(bc), WithWebhookNotifier(notifier), withAdminToken(t))
+
+	var list struct {
+		Letters []DeadLetter `json:"letters"`
+	}
+	decodeResponse(t, serveAdmin(handler, "GET", "/deadletters", ""), http.StatusOK, &list)
+	if len(list.Letters) != 2 || list.Letters[0].Target != server.URL {
+		t.Fatalf("GET /deadletters returned %+v", list)
+	}
+	expectAPIError(t, serveAdmin(handler, "POST", "/deadletters/replay", `{}`), http.StatusBadRequest)
+	expectAPIError(t, serveAdmin(handler, "POST", "/deadletters/replay", `{"ids": [1], "target": "x"}`), http.StatusBadRequest)
+	expectAPIError(t, serveAdmin(handler, "POST", "/deadletters/replay", `{"ids": [99]}`), http.StatusNotFound)
+
+	receiver.down.Store(false)
+	var result ReplayResult
+	decodeResponse(t, serveAdmin(handler, "POST", "/deadletters/replay", `{"ids": [1]}`), http.StatusOK, &result)
+	if len(result.Delivered) != 1 || result.Delivered[0] != 1 {
+		t.Fatalf("replay of dead letter 1 returned %+v", result)
+	}
+	decodeResponse(t, serveAdmin(handler, "POST", "/deadletters/replay", `{"target": "`+server.URL+`"}`), http.StatusOK, &result)
+	if len(result.Delivered) != 1 || result.Delivered[0] != 2 {
+		t.Fatalf("replay of the target returned %+v", result)
+	}
+	decodeResponse(t, serveAdmin(handler, "GET", "/deadletters", ""), http.StatusOK, &list)
+	if len(list.Letters) != 0 {
+		t.Fatalf("store holds %+v after the replays, want none", list.Letters)
+	}
+
+	plain := NewAPIHandler(bc, NewDefaultPipeline(bc), withAdminToken(t))
+	expectAPIError(t, serveAdmin(plain, "GET", "/deadletters", ""), http.StatusNotFound)
+	expectAPIError(t, serveAdmin(plain, "POST", "/deadletters/replay", `{"ids": [1]}`), http.StatusNotFound)
+}
+
+func TestCLIDeadLetters(t *testing.T) {
+	captureLog(t)
+	notifier, receiver, _, _ := failDeliveries(t, 2)
+	receiver.down.Store(false)
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	pipeline := NewDefaultPipeline(bc)
+	c := &cli{
+		bc:        bc,
+		pipeline:  pipeline,
+		generator: NewGenerator(pipeline.ForSource("generator"), GeneratorConfig{}),
+		server:    NewAPIServer(bc, pipeline),
+		notifier:  notifier,
+		in:        NewPlainLineReader(strings.NewReader("25\n2\n25\nalle\n25\n"), &out),
+		out:       &out,
+	}
+	c.presets, _ = LoadPresets("")
+	c.run()
+	for _, want := range []string{
+		"25. Unzustellbare Benachrichtigungen (2)",
+		"2. Block 2 an ",
+		"Wiederholung: 1 zugestellt, 0 erneut fehlgeschlagen",
+		"25. Unzustellbare Benachrichtigungen (1)",
+		"25. Unzustellbare Benachrichtigungen (0)",
+		"Keine unzustellbaren Benachrichtigungen",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("menu output does not contain %q:\n%s", want, out.String())
+		}
+	}
+	if n := notifier.DeadLetters().Len(); n != 0 {
+		t.Fatalf("store holds %d dead letters after replaying all, want none", n)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDuplicateBlock is matched by every *DuplicateBlockError
+var ErrDuplicateBlock = errors.New("Doppelter Block")
+
+// DuplicateBlockError reports a batch whose values equal those of a recent
+// block, see SetDeduplication
+type DuplicateBlockError struct {
+	// Index is the block the batch repeats
+	Index       int
+	ContentHash string
+}
+
+func (e *DuplicateBlockError) Error() string {
+	return fmt.Sprintf("%v: gleiche Werte wie Block %d (%s)", ErrDuplicateBlock, e.Index, formatHash(e.ContentHash))
+}
+
+func (e *DuplicateBlockError) Is(target error) bool {
+	return target == ErrDuplicateBlock
+}
+
+// contentDomain starts the encoding of the values a content hash covers
+const contentDomain = "block_data_save/values"
+
+// contentHash returns the hex SHA-256 of the values of a new block, in
+// the order given, as they were submitted, and of their times if they have
+// any
+func contentHash(kind ValueKind, values []float64, intValues []int64, times []time.Time) string {
+	e := &hashEncoder{}
+	e.string(contentDomain)
+	e.string(string(kind))
+	e.floats(values)
+	e.ints(intValues)
+	if times != nil {
+		e.times(times)
+	}
+	sum := sha256.Sum256(e.buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// SetDeduplication makes AddBlock reject a batch with a
+// *DuplicateBlockError when its values equal those of one of the last
+// window blocks, as when a gateway sends a batch twice. Values are
+// compared in order, so the same values in another order are a new batch.
+// The comparison uses Block.ContentHash, which is saved with the chain,
+// so it continues after a restart. 0 turns it off, which is the default
+// unless the chain was created WithDeduplication.
+func (bc *Blockchain) SetDeduplication(window int) error {
+	if window < 0 {
+		return fmt.Errorf("Ungültiges Fenster für doppelte Blöcke: %d", window)
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.dedupWindow = window
+	return nil
+}
+
+// checkDuplicate returns a *DuplicateBlockError if one of the last
+// dedupWindow blocks has the content hash hash. Called with bc.mu held.
+func (bc *Blockchain) checkDuplicate(hash string) error {
+	window, err := bc.blocks(bc.held-bc.dedupWindow, bc.held)
+	if err != nil {
+		return err
+	}
+	for pos := len(window) - 1; pos >= 0; pos-- {
+		if block := window[pos]; block.ContentHash == hash {
+			return &DuplicateBlockError{Index: block.Index, ContentHash: hash}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithDeduplication(t *testing.T) {
+	bc, err := NewBlockchain(WithDeduplication(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := bc.AddBlock([]float64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = bc.AddBlock([]float64{1, 2, 3})
+	var dup *DuplicateBlockError
+	if !errors.As(err, &dup) || !errors.Is(err, ErrDuplicateBlock) || dup.Index != first.Index {
+		t.Fatalf("exact duplicate: got %v, want a DuplicateBlockError for block %d", err, first.Index)
+	}
+	// values are compared in order
+	if _, err := bc.AddBlock([]float64{3, 2, 1}); err != nil {
+		t.Fatalf("same values in another order: %v", err)
+	}
+	if _, err := bc.AddBlock([]float64{4, 5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	// first is no longer one of the last two blocks
+	if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatalf("duplicate outside the window: %v", err)
+	}
+}
+
+func TestDeduplicationIsOffByDefault(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := NewBlockchain(WithDeduplication(-1)); err == nil {
+		t.Fatal("negative window accepted")
+	}
+}
+
+func TestDeduplicationSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	bc, err := NewBlockchain(WithPersistence(path), WithDeduplication(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{7, 8, 9}); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+
+	resumed, err := NewBlockchain(WithPersistence(path), WithDeduplication(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if _, err := resumed.AddBlock([]float64{7, 8, 9}); !errors.Is(err, ErrDuplicateBlock) {
+		t.Fatalf("duplicate of a block from before the restart: got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Stats stage names used as keys of Block.StatsErrors
+const (
+	StageMean           = "mean"
+	StageMedian         = "median"
+	StageTwoSD          = "two_sd"
+	StageOutliers       = "outliers"
+	StageIntStats       = "int_stats"
+	StageOutlierContext = "outlier_context"
+	StageHistogram      = "histogram"
+	StageSummary        = "summary"
+	StageQuality        = "quality"
+	StageRules          = "rules"
+)
+
+// runStatsStage runs one stats computation for block. A panic is recorded
+// in block.StatsErrors under name instead of failing the append.
+func runStatsStage(block *Block, name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			recordStatsError(block, name, r)
+		}
+	}()
+	fn()
+}
+
+// recordStatsError notes that stage name panicked with r
+func recordStatsError(block *Block, name string, r any) {
+	if block.StatsErrors == nil {
+		block.StatsErrors = map[string]string{}
+	}
+	block.StatsErrors[name] = fmt.Sprint(r)
+}
+
+// statsFailed reports whether stage name of block panicked
+func statsFailed(block *Block, name string) bool {
+	_, failed := block.StatsErrors[name]
+	return failed
+}
+
+// markDegraded sets the status of a block whose stats are incomplete and
+// reports it. The block is appended with the stats that succeeded.
+func markDegraded(block *Block) {
+	if len(block.StatsErrors) == 0 {
+		return
+	}
+	block.Status = StatusDegraded
+	log.Printf("Warnung: Block %d unvollständig, fehlgeschlagen: %s", block.Index, formatStatsErrors(block.StatsErrors))
+}
+
+// formatStatsErrors renders stats errors sorted by stage
+func formatStatsErrors(errs map[string]string) string {
+	stages := make([]string, 0, len(errs))
+	for stage := range errs {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+
+	out := ""
+	for i, stage := range stages {
+		if i > 0 {
+			out += "; "
+		}
+		out += stage + ": " + errs[stage]
+	}
+	return out
+}
+
+// ValidationWarnings lists the degraded blocks of the chain, those whose
+// clock went backwards, see TimestampPolicy, and those whose text or
+// metadata exceed the current Limits, such as blocks of old files. They
+// pass Validate, since their hash covers the content they do have.
+func (bc *Blockchain) ValidationWarnings() []string {
+	limits := bc.Limits()
+	var warnings []string
+	for _, block := range bc.snapshot() {
+		if len(block.StatsErrors) > 0 {
+			warnings = append(warnings, fmt.Sprintf("Block %d unvollständig: %s", block.Index, formatStatsErrors(block.StatsErrors)))
+		}
+		if warning := timestampWarning(block); warning != "" {
+			warnings = append(warnings, warning)
+		}
+		if err := limits.Check(block.Text, block.Metadata); err != nil {
+			warnings = append(warnings, fmt.Sprintf("Block %d: %v", block.Index, err))
+		}
+	}
+	return warnings
+}
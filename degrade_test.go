@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// panickingRule installs a rule on bc whose field panics, so the rules
+// stage of every new block fails
+func panickingRule(t *testing.T, bc *Blockchain) {
+	t.Helper()
+	ruleFields["panics"] = func(*Block) float64 { panic("Feld nicht verfügbar") }
+	t.Cleanup(func() { delete(ruleFields, "panics") })
+	rule := Rule{Name: "kaputt", Status: StatusCritical, Conditions: []Condition{{Field: "panics", Op: ">", Value: 0}}}
+	if err := bc.SetRules([]Rule{rule}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunStatsStageRecordsPanic(t *testing.T) {
+	block := &Block{Values: []float64{1, 2, 3}}
+	runStatsStage(block, StageMedian, func() { panic("kaputt") })
+	runStatsStage(block, StageMean, func() { block.Mean = calculateMean(block.Values) })
+	if !statsFailed(block, StageMedian) || block.StatsErrors[StageMedian] != "kaputt" {
+		t.Fatalf("StatsErrors are %v, want the panic of the median stage", block.StatsErrors)
+	}
+	if statsFailed(block, StageMean) || block.Mean != 2 {
+		t.Fatalf("the mean stage after the panic left mean %v and errors %v", block.Mean, block.StatsErrors)
+	}
+	markDegraded(block)
+	if block.Status != StatusDegraded {
+		t.Fatalf("status is %q, want %q", block.Status, StatusDegraded)
+	}
+}
+
+func TestMarkDegradedKeepsCompleteBlocks(t *testing.T) {
+	block := &Block{Status: StatusWarn}
+	markDegraded(block)
+	if block.Status != StatusWarn {
+		t.Fatalf("a block without stats errors got status %q", block.Status)
+	}
+}
+
+func TestPanickingStageDegradesBlock(t *testing.T) {
+	bc := newFilledChain(t)
+	panickingRule(t, bc)
+
+	block, err := bc.AddBlock(chainTestValues[1])
+	if err != nil {
+		t.Fatalf("AddBlock failed on a panicking stage: %v", err)
+	}
+	if block.Status != StatusDegraded {
+		t.Fatalf("status is %q, want %q", block.Status, StatusDegraded)
+	}
+	if len(block.StatsErrors) != 1 || !strings.Contains(block.StatsErrors[StageRules], "Feld nicht verfügbar") {
+		t.Fatalf("StatsErrors are %v, want the panic of the rules stage only", block.StatsErrors)
+	}
+	// the stats that did not fail are kept
+	if block.Mean != 18 || block.Median != 10 || len(block.Outliers) != 1 || !block.HasOutliers {
+		t.Fatalf("block kept mean %v, median %v and outliers %v, want 18, 10 and [90]", block.Mean, block.Median, block.Outliers)
+	}
+
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("a degraded block fails Validate: %v", err)
+	}
+	warnings := bc.ValidationWarnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "Block 6") || !strings.Contains(warnings[0], StageRules) {
+		t.Fatalf("ValidationWarnings are %q, want one for block 6", warnings)
+	}
+}
+
+func TestDegradedBlockSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	bc, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	panickingRule(t, bc)
+	if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+
+	resumed, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if err := resumed.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if warnings := resumed.ValidationWarnings(); len(warnings) != 1 {
+		t.Fatalf("resumed chain has warnings %q, want one for the degraded block", warnings)
+	}
+}
+
+func TestPrintDegradedIntBlock(t *testing.T) {
+	// the int_stats stage of this block failed, so it has no IntStats
+	block := &Block{Index: 3, Kind: KindInt, IntValues: []int64{1, 2, 3}, Median: 2,
+		StatsErrors: map[string]string{StageIntStats: "kaputt"}, Status: StatusDegraded}
+	var out bytes.Buffer
+	printBlock(&out, block)
+	if !strings.Contains(out.String(), "Median: 2.00") || strings.Contains(out.String(), "Min/Max") {
+		t.Fatalf("degraded int block printed as:\n%s", out.String())
+	}
+}
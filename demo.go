@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// Shape of the demo chain. Changing any of them changes its head hash,
+// which the tests pin.
+const (
+	demoBlocks         = 100
+	demoValuesPerBlock = 24
+	demoInterval       = 15 * time.Minute
+	demoSeed           = 253
+)
+
+// demoStart is the time of the genesis of the demo chain
+var demoStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// LoadDemoChain returns a chain of demoBlocks blocks of temperature
+// readings that comes out the same on every run: the values and the
+// random bits of the block IDs are drawn from a seeded source, and the
+// blocks are stamped every demoInterval from demoStart. A few values are
+// spikes. It is meant for examples and as a fixture in tests.
+func LoadDemoChain() (*Blockchain, error) {
+	r := rand.New(rand.NewSource(demoSeed))
+	id, err := newBlockID(IDSchemeULID, demoStart, r)
+	if err != nil {
+		return nil, err
+	}
+	bc := newBlockchainAt(ChainConfig{Name: "demo", Description: "Temperaturmessungen"}, demoStart, id)
+	clock := &replayClock{now: demoStart}
+	bc.SetClock(clock)
+
+	source := NormalSource{Mean: 21, StdDev: 0.8, SpikeProbability: 0.01, Rand: r}
+	for i := 1; i <= demoBlocks; i++ {
+		clock.now = demoStart.Add(time.Duration(i) * demoInterval)
+		if id, err = newBlockID(IDSchemeULID, clock.now, r); err != nil {
+			return nil, err
+		}
+		payload := blockPayload{values: source.Next(demoValuesPerBlock), text: fmt.Sprintf("Messung %d", i), id: id}
+		if _, err := bc.addBlock(payload); err != nil {
+			return nil, fmt.Errorf("Block %d der Demo-Blockchain: %w", i, err)
+		}
+	}
+	return bc, nil
+}
+
+// runDemoCommand implements "demo --out demo.json": it writes the chain
+// of LoadDemoChain to a file the program can load
+func runDemoCommand(args []string) int {
+	fs := flag.NewFlagSet("demo", flag.ContinueOnError)
+	out := fs.String("out", "demo.json", "Ausgabedatei der Demo-Blockchain")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *out == defaultChainFile {
+		fmt.Fprintf(os.Stderr, "Die gespeicherte Blockchain %s wird nicht überschrieben\n", defaultChainFile)
+		return 2
+	}
+
+	bc, err := LoadDemoChain()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := bc.SaveToFile(*out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Demo-Blockchain mit %d Blöcken geschrieben: %s\n", bc.Length()-1, *out)
+	return 0
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// demoHeadHash is the head hash of LoadDemoChain. It only changes with the
+// demo data or an encoding of the hash, and then on purpose.
+const demoHeadHash = "5b252d9b70f1694fe3035671e74a435b6973353cc92c201e5ad958fc282f4d13"
+
+func TestDemoChainIsDeterministic(t *testing.T) {
+	bc, err := LoadDemoChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bc.Length() != demoBlocks+1 {
+		t.Fatalf("demo chain holds %d blocks, want %d", bc.Length(), demoBlocks+1)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if head := bc.HeadHash(); head != demoHeadHash {
+		t.Fatalf("demo chain has head %s, want %s", head, demoHeadHash)
+	}
+	again, err := LoadDemoChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameHashes(again.Blocks(), bc.Blocks()) {
+		t.Fatal("a second demo chain differs from the first")
+	}
+}
+
+func TestRunDemoCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "demo.json")
+	if code := runDemoCommand([]string{"-out", out}); code != 0 {
+		t.Fatalf("demo exited with %d", code)
+	}
+	bc, err := LoadBlockchainFromFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bc.HeadHash() != demoHeadHash {
+		t.Fatalf("saved demo chain has head %s, want %s", bc.HeadHash(), demoHeadHash)
+	}
+}
+
+func ExampleLoadDemoChain() {
+	bc, err := LoadDemoChain()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(bc.Name(), bc.Length()-1, "Blöcke")
+	fmt.Println("gültig:", bc.Validate() == nil)
+	fmt.Println("mit Ausreißern:", len(bc.OutlierBlocks()))
+	// Output:
+	// demo 100 Blöcke
+	// gültig: true
+	// mit Ausreißern: 88
+}
+
+func ExampleBlockchain_ExportCSV() {
+	bc, err := LoadDemoChain()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := bc.ExportCSV(&buf); err != nil {
+		fmt.Println(err)
+		return
+	}
+	header, _, _ := bytes.Cut(buf.Bytes(), []byte("\n"))
+	fmt.Println(string(header))
+	fmt.Println(bytes.Count(buf.Bytes(), []byte("\n")), "Zeilen")
+	// Output:
+	// Index,Timestamp,Mean,Median,TwoSDLower,TwoSDUpper,OutlierCount,Hash,PrevHash,Values,Text,Checksum
+	// 103 Zeilen
+}
+
+func ExampleNewAPIHandler() {
+	bc, err := LoadDemoChain()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	server := httptest.NewServer(NewAPIHandler(bc, NewDefaultPipeline(bc)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/blocks/latest")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Println(resp.Status, bytes.Contains(body, []byte(bc.HeadHash())))
+	// Output:
+	// 200 OK true
+}
+
+func ExampleBlockchain_Subscribe() {
+	bc, err := LoadDemoChain()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	events, unsubscribe := bc.Subscribe()
+	defer unsubscribe()
+
+	if _, err := bc.AddBlock([]float64{21.5, 21.7, 35}); err != nil {
+		fmt.Println(err)
+		return
+	}
+	event := <-events
+	fmt.Println("Block", event.Index, "angehängt")
+	// Output:
+	// Block 101 angehängt
+}
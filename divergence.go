@@ -0,0 +1,141 @@
+package main
+
+import "fmt"
+
+// CompareResult is where two chains diverge, as reported by CompareChains
+type CompareResult struct {
+	// CommonPrefix is the number of blocks from genesis the chains share
+	CommonPrefix int
+	// FirstDivergence is the index of the first block the chains do not
+	// share, whether they hold different blocks there or only one holds
+	// one; -1 if they are identical
+	FirstDivergence int
+	// APrefixOfB is set when a is a strict prefix of b, BPrefixOfA when
+	// b is one of a
+	APrefixOfB bool
+	BPrefixOfA bool
+}
+
+// Identical reports whether both chains hold the same blocks
+func (r CompareResult) Identical() bool {
+	return r.FirstDivergence < 0
+}
+
+func (r CompareResult) String() string {
+	switch {
+	case r.Identical():
+		return fmt.Sprintf("Blockchains sind gleich (%d Blöcke)", r.CommonPrefix)
+	case r.APrefixOfB:
+		return fmt.Sprintf("Erste Blockchain ist Anfang der zweiten, diese geht ab Block %d weiter", r.FirstDivergence)
+	case r.BPrefixOfA:
+		return fmt.Sprintf("Zweite Blockchain ist Anfang der ersten, diese geht ab Block %d weiter", r.FirstDivergence)
+	}
+	return fmt.Sprintf("Blockchains teilen %d Blöcke und gehen ab Block %d auseinander", r.CommonPrefix, r.FirstDivergence)
+}
+
+// CompareChains compares the hashes of the blocks of a and b index for
+// index. Since every hash covers the hash before it, the chains share all
+// blocks up to the last matching one. Pruned chains and chains
+// bootstrapped from a checkpoint are compared from the oldest index both
+// hold; if they differ there already, or hold no common index, the
+// divergence may lie before FirstDivergence and CommonPrefix is 0. Chains
+// created separately differ from genesis on, which records when it was
+// created; a shared history comes from a copy of a chain file or a Fork.
+func CompareChains(a, b *Blockchain) CompareResult {
+	blocksA, blocksB := a.snapshot(), b.snapshot()
+	startA, startB := blocksA[0].Index, blocksB[0].Index
+	endA, endB := blocksA[len(blocksA)-1].Index, blocksB[len(blocksB)-1].Index
+
+	result := CompareResult{FirstDivergence: -1}
+	index := max(startA, startB)
+	for ; index <= min(endA, endB); index++ {
+		if blocksA[index-startA].Hash != blocksB[index-startB].Hash {
+			break
+		}
+		result.CommonPrefix = index + 1
+	}
+	switch {
+	case index <= min(endA, endB) || result.CommonPrefix == 0:
+		result.FirstDivergence = index
+	case endA < endB:
+		result.FirstDivergence, result.APrefixOfB = endA+1, true
+	case endB < endA:
+		result.FirstDivergence, result.BPrefixOfA = endB+1, true
+	}
+	return result
+}
+
+// ReplaceTail replaces the blocks from index from on with blocks, as when
+// adopting the tail of another chain after CompareChains. blocks must
+// start at from, follow each other and link onto the block at from-1,
+// and each must pass the checks of Validate. If any check fails nothing
+// is changed. from may be the index after the head, which appends blocks.
+// A block log is rewritten to hold the new chain, and the storage replaces
+// its blocks from from on. Subscribers are sent an event for every
+// adopted block.
+func (bc *Blockchain) ReplaceTail(from int, blocks []*Block) error {
+	if len(blocks) == 0 {
+		return fmt.Errorf("Keine Blöcke ab Block %d", from)
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if from < 1 {
+		return fmt.Errorf("Ungültiger Beginn %d, Genesis kann nicht ersetzt werden", from)
+	}
+	prevPos, err := bc.position(from - 1)
+	if err != nil {
+		return err
+	}
+	keep := prevPos + 1
+
+	ids := make(map[string]bool, len(blocks))
+	prev, err := bc.block(prevPos)
+	if err != nil {
+		return err
+	}
+	link := prev.Hash
+	var size int64
+	for i, block := range blocks {
+		switch {
+		case block.Index != from+i:
+			return &ValidationError{Index: block.Index, Reason: fmt.Sprintf("Index %d erwartet", from+i)}
+		case block.PrevHash != link:
+			return &ValidationError{Index: block.Index, Reason: fmt.Sprintf("Vorgänger-Hash %s passt nicht zu Block %d", formatHash(block.PrevHash), block.Index-1)}
+		case block.Kind != bc.valueKind:
+			return &ValidationError{Index: block.Index, Reason: fmt.Sprintf("%v: Block vom Typ %s", ErrValueKindMismatch, block.Kind)}
+		}
+		if err := verifyBlock(block); err != nil {
+			return err
+		}
+		if pos, ok := bc.idIndex[block.ID]; (ok && pos < keep) || ids[block.ID] {
+			return &ValidationError{Index: block.Index, Reason: fmt.Sprintf("ID %s ist bereits vergeben", block.ID)}
+		}
+		ids[block.ID] = true
+		link = block.Hash
+		size += estimateBlockSize(block)
+	}
+	tail, err := bc.blocks(keep, bc.held)
+	if err != nil {
+		return err
+	}
+	for _, block := range tail {
+		size -= estimateBlockSize(block)
+	}
+	if bc.memLimits.Hard > 0 && bc.memUsage+size > bc.memLimits.Hard {
+		return ErrChainFull
+	}
+
+	replaced := make([]*Block, len(blocks))
+	for i, block := range blocks {
+		replaced[i] = copyBlock(block)
+	}
+	if err := bc.rewriteChain(from, bc.head.Index+1, replaced, nil); err != nil {
+		return err
+	}
+	for _, block := range replaced {
+		bc.publish(block)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompareChains(t *testing.T) {
+	a := newFilledChain(t)
+	b, err := a.Fork("b", a.LatestBlock().Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := a.LatestBlock().Index
+	if r := CompareChains(a, b); !r.Identical() || r.CommonPrefix != head+1 {
+		t.Fatalf("fork compares as %+v", r)
+	}
+
+	if _, err := b.AddBlock([]float64{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if r := CompareChains(a, b); !r.APrefixOfB || r.FirstDivergence != head+1 || r.CommonPrefix != head+1 {
+		t.Fatalf("longer fork compares as %+v", r)
+	}
+	if r := CompareChains(b, a); !r.BPrefixOfA || r.FirstDivergence != head+1 {
+		t.Fatalf("reversed comparison is %+v", r)
+	}
+
+	if _, err := a.AddBlock([]float64{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	r := CompareChains(a, b)
+	if r.Identical() || r.APrefixOfB || r.BPrefixOfA || r.FirstDivergence != head+1 || r.CommonPrefix != head+1 {
+		t.Fatalf("diverged chains compare as %+v", r)
+	}
+	if want := "Blockchains teilen 6 Blöcke und gehen ab Block 6 auseinander"; r.String() != want {
+		t.Fatalf("result reads %q, want %q", r, want)
+	}
+
+	// separate chains differ from genesis on
+	other := newFilledChain(t)
+	if r := CompareChains(a, other); r.CommonPrefix != 0 || r.FirstDivergence != 0 {
+		t.Fatalf("separate chains compare as %+v", r)
+	}
+}
+
+func TestReplaceTail(t *testing.T) {
+	a := newFilledChain(t)
+	b, err := a.Fork("b", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, values := range [][]float64{{7, 8}, {9}, {10, 11, 12}} {
+		if _, err := b.AddBlock(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	from := CompareChains(a, b).FirstDivergence
+	tail := b.Blocks()[from:]
+
+	broken := copyBlock(tail[1])
+	broken.Values[0]++
+	tests := []struct {
+		name   string
+		from   int
+		blocks []*Block
+		err    string
+	}{
+		{"nothing", from, nil, "Keine Blöcke"},
+		{"genesis", 0, tail, "Genesis kann nicht ersetzt werden"},
+		{"gap", from, []*Block{tail[0], tail[2]}, "Index 5 erwartet"},
+		{"wrong link", from + 1, tail[1:], "Vorgänger-Hash"},
+		{"tampered", from, []*Block{tail[0], broken, tail[2]}, "Block 5"},
+	}
+	head := a.LatestBlock()
+	for _, tt := range tests {
+		err := a.ReplaceTail(tt.from, tt.blocks)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%s: returned %v, want %q", tt.name, err, tt.err)
+		}
+		if a.LatestBlock().Hash != head.Hash {
+			t.Fatalf("%s: chain changed after a rejected tail", tt.name)
+		}
+	}
+	var invalid *ValidationError
+	if err := a.ReplaceTail(from, []*Block{tail[0], broken, tail[2]}); !errors.As(err, &invalid) || invalid.Index != broken.Index {
+		t.Fatalf("tampered block returned %v", err)
+	}
+
+	events, unsubscribe := a.Subscribe()
+	defer unsubscribe()
+	if err := a.ReplaceTail(from, tail); err != nil {
+		t.Fatal(err)
+	}
+	if r := CompareChains(a, b); !r.Identical() {
+		t.Fatalf("chain after adopting the tail compares as %+v", r)
+	}
+	if err := a.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range tail {
+		if event := <-events; event.Index != block.Index {
+			t.Fatalf("event for block %d, want %d", event.Index, block.Index)
+		}
+	}
+
+	// from after the head appends
+	next, err := b.AddBlock([]float64{13})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.ReplaceTail(next.Index, []*Block{next}); err != nil {
+		t.Fatal(err)
+	}
+	if a.LatestBlock().Hash != next.Hash {
+		t.Fatalf("head is %d after appending, want %d", a.LatestBlock().Index, next.Index)
+	}
+}
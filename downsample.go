@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Point is one sample of a series
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Downsampler reduces a series ordered by X to at most maxPoints points.
+// Series that already fit are returned unchanged.
+type Downsampler interface {
+	Name() string
+	Downsample(points []Point, maxPoints int) []Point
+}
+
+// Series is a downsampled series and the downsampler that produced it
+type Series struct {
+	Field       string  `json:"field"`
+	Downsampler string  `json:"downsampler"`
+	Points      []Point `json:"points"`
+}
+
+var (
+	downsamplersMu sync.RWMutex
+	downsamplers   = map[string]Downsampler{}
+)
+
+func init() {
+	RegisterDownsampler(meanDownsampler{})
+	RegisterDownsampler(minMaxDownsampler{})
+	RegisterDownsampler(lttbDownsampler{})
+	RegisterDownsampler(lastDownsampler{})
+}
+
+// RegisterDownsampler makes a downsampler available by its name
+func RegisterDownsampler(d Downsampler) {
+	downsamplersMu.Lock()
+	defer downsamplersMu.Unlock()
+	downsamplers[d.Name()] = d
+}
+
+// DownsamplerByName returns the registered downsampler with the given name
+func DownsamplerByName(name string) (Downsampler, error) {
+	downsamplersMu.RLock()
+	defer downsamplersMu.RUnlock()
+
+	d, ok := downsamplers[name]
+	if !ok {
+		return nil, fmt.Errorf("Unbekanntes Verdichtungsverfahren: %s (unterstützt: %s)", name, strings.Join(supportedDownsamplersLocked(), ", "))
+	}
+	return d, nil
+}
+
+// SupportedDownsamplers returns the names of all registered downsamplers,
+// sorted
+func SupportedDownsamplers() []string {
+	downsamplersMu.RLock()
+	defer downsamplersMu.RUnlock()
+	return supportedDownsamplersLocked()
+}
+
+func supportedDownsamplersLocked() []string {
+	names := make([]string, 0, len(downsamplers))
+	for name := range downsamplers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StatsSeries returns a block statistic, by rule field name, per block
+// index, reduced to maxPoints points with the named downsampler
+func (bc *Blockchain) StatsSeries(field, downsampler string, maxPoints int) (*Series, error) {
+	value, ok := ruleFields[field]
+	if !ok {
+		return nil, fmt.Errorf("Unbekanntes Feld %q (erlaubt: %s)", field, strings.Join(RuleFields(), ", "))
+	}
+	d, err := DownsamplerByName(downsampler)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []Point
+	for _, block := range bc.snapshot() {
+		if block.ValueCount() > 0 {
+			points = append(points, Point{X: float64(block.Index), Y: value(block)})
+		}
+	}
+	return &Series{Field: field, Downsampler: d.Name(), Points: d.Downsample(points, maxPoints)}, nil
+}
+
+// fits reports whether points need no downsampling
+func fits(points []Point, maxPoints int) bool {
+	return maxPoints <= 0 || len(points) <= maxPoints
+}
+
+// buckets splits points into n consecutive, nearly equal buckets
+func buckets(points []Point, n int) [][]Point {
+	out := make([][]Point, n)
+	for i := range out {
+		out[i] = points[i*len(points)/n : (i+1)*len(points)/n]
+	}
+	return out
+}
+
+// meanDownsampler replaces each bucket with its mean point
+type meanDownsampler struct{}
+
+func (meanDownsampler) Name() string { return "mean" }
+
+func (meanDownsampler) Downsample(points []Point, maxPoints int) []Point {
+	if fits(points, maxPoints) {
+		return append([]Point(nil), points...)
+	}
+	out := make([]Point, 0, maxPoints)
+	for _, bucket := range buckets(points, maxPoints) {
+		var sum Point
+		for _, p := range bucket {
+			sum.X += p.X
+			sum.Y += p.Y
+		}
+		n := float64(len(bucket))
+		out = append(out, Point{X: sum.X / n, Y: sum.Y / n})
+	}
+	return out
+}
+
+// minMaxDownsampler keeps the lowest and highest point of each bucket, so
+// the envelope and all extremes survive
+type minMaxDownsampler struct{}
+
+func (minMaxDownsampler) Name() string { return "minmax" }
+
+func (minMaxDownsampler) Downsample(points []Point, maxPoints int) []Point {
+	if fits(points, maxPoints) || maxPoints < 2 {
+		return meanDownsampler{}.Downsample(points, maxPoints)
+	}
+	out := make([]Point, 0, maxPoints)
+	for _, bucket := range buckets(points, maxPoints/2) {
+		lo, hi := bucket[0], bucket[0]
+		for _, p := range bucket[1:] {
+			if p.Y < lo.Y {
+				lo = p
+			}
+			if p.Y > hi.Y {
+				hi = p
+			}
+		}
+		if lo.X > hi.X {
+			lo, hi = hi, lo
+		}
+		out = append(out, lo)
+		if hi != lo {
+			out = append(out, hi)
+		}
+	}
+	return out
+}
+
+// lttbDownsampler implements Largest-Triangle-Three-Buckets, which keeps
+// the visual shape of a series
+type lttbDownsampler struct{}
+
+func (lttbDownsampler) Name() string { return "lttb" }
+
+func (lttbDownsampler) Downsample(points []Point, maxPoints int) []Point {
+	if fits(points, maxPoints) || maxPoints < 3 {
+		return meanDownsampler{}.Downsample(points, maxPoints)
+	}
+
+	out := make([]Point, 0, maxPoints)
+	out = append(out, points[0])
+	inner := buckets(points[1:len(points)-1], maxPoints-2)
+	for i, bucket := range inner {
+		next := points[len(points)-1]
+		if i+1 < len(inner) {
+			next = Point{}
+			for _, p := range inner[i+1] {
+				next.X += p.X
+				next.Y += p.Y
+			}
+			next.X /= float64(len(inner[i+1]))
+			next.Y /= float64(len(inner[i+1]))
+		}
+
+		prev := out[len(out)-1]
+		best, bestArea := bucket[0], -1.0
+		for _, p := range bucket {
+			area := math.Abs((prev.X-next.X)*(p.Y-prev.Y) - (prev.X-p.X)*(next.Y-prev.Y))
+			if area > bestArea {
+				best, bestArea = p, area
+			}
+		}
+		out = append(out, best)
+	}
+	return append(out, points[len(points)-1])
+}
+
+// lastDownsampler keeps the last point of each bucket
+type lastDownsampler struct{}
+
+func (lastDownsampler) Name() string { return "last" }
+
+func (lastDownsampler) Downsample(points []Point, maxPoints int) []Point {
+	if fits(points, maxPoints) {
+		return append([]Point(nil), points...)
+	}
+	out := make([]Point, 0, maxPoints)
+	for _, bucket := range buckets(points, maxPoints) {
+		out = append(out, bucket[len(bucket)-1])
+	}
+	return out
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// ValueEncoding selects how new float blocks store their values
+type ValueEncoding string
+
+const (
+	// EncodingNone keeps the values as float64 in Block.Values
+	EncodingNone ValueEncoding = "none"
+	// EncodingFloat32 rounds the values to float32 and stores 4 bytes
+	// each. The stats are computed from the rounded values.
+	EncodingFloat32 ValueEncoding = "float32"
+	// EncodingDelta scales the values by the smallest power of ten up to
+	// 10^maxDeltaScale that makes all of them integers and stores the
+	// differences between consecutive ones as varints. It is lossless;
+	// values no such scale fits are stored with 8 bytes each.
+	EncodingDelta ValueEncoding = "delta"
+)
+
+// maxDeltaScale is the largest number of decimal places EncodingDelta
+// scales values by
+const maxDeltaScale = 9
+
+// rawDeltaScale marks delta-encoded values that are stored unscaled
+const rawDeltaScale = 0xff
+
+// deltaScales are the powers of ten delta-encoded values are scaled by,
+// all exact as float64
+var deltaScales = [maxDeltaScale + 1]float64{1, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9}
+
+// errEncodedValues is returned for encoded values that cannot be decoded
+var errEncodedValues = errors.New("Kodierte Werte sind beschädigt")
+
+// SetValueEncoding sets how new float blocks store their values. Blocks
+// already added keep theirs, so a chain may mix encodings. Encoded blocks
+// keep Values empty and hold the encoded bytes in EncodedValues; GetValues
+// decodes them. KindInt blocks are not encoded.
+func (bc *Blockchain) SetValueEncoding(encoding ValueEncoding) error {
+	if err := encoding.Validate(); err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.valueEncoding = encoding
+	return nil
+}
+
+// Validate checks that the encoding is known
+func (e ValueEncoding) Validate() error {
+	switch e {
+	case EncodingNone, EncodingFloat32, EncodingDelta:
+		return nil
+	}
+	return fmt.Errorf("Unbekannte Wertkodierung: %s", e)
+}
+
+// GetValues returns the values of a float block, decoded if the block
+// stores them encoded, see SetValueEncoding. For an encoded block the
+// result is a new slice, or nil if the encoded bytes are damaged, which
+// Validate reports; otherwise it is Values itself.
+func (b *Block) GetValues() []float64 {
+	if b.ValueEncoding == "" {
+		return b.Values
+	}
+	values, err := decodeValues(b.ValueEncoding, b.EncodedValues)
+	if err != nil {
+		return nil
+	}
+	return values
+}
+
+// storedCount returns the number of float values the block stores, which
+// is fewer than ValueCount for sampled blocks
+func (b *Block) storedCount() int {
+	if b.ValueEncoding == "" {
+		return len(b.Values)
+	}
+	return encodedCount(b.ValueEncoding, b.EncodedValues)
+}
+
+// roundValues returns a copy of values as they will be decoded after
+// encoding them with e: rounded to float32 for EncodingFloat32, unchanged
+// otherwise
+func (e ValueEncoding) roundValues(values []float64) []float64 {
+	if e != EncodingFloat32 {
+		return slices.Clone(values)
+	}
+	rounded := make([]float64, len(values))
+	for i, v := range values {
+		rounded[i] = float64(float32(v))
+	}
+	return rounded
+}
+
+// encodeBlockValues moves the values of a new float block into
+// EncodedValues with e. The values must have been rounded with
+// roundValues before the stats were computed.
+func encodeBlockValues(block *Block, e ValueEncoding) {
+	if block.Kind != KindFloat {
+		return
+	}
+	switch e {
+	case EncodingFloat32:
+		block.EncodedValues = encodeFloat32(block.Values)
+	case EncodingDelta:
+		block.EncodedValues = encodeDelta(block.Values)
+	default:
+		return
+	}
+	block.ValueEncoding = e
+	block.Values = nil
+}
+
+func encodeFloat32(values []float64) []byte {
+	data := make([]byte, 0, 4*len(values))
+	for _, v := range values {
+		data = binary.BigEndian.AppendUint32(data, math.Float32bits(float32(v)))
+	}
+	return data
+}
+
+// encodeDelta writes the scale as one byte, followed by the zigzag varint
+// differences of the scaled values, the first to 0
+func encodeDelta(values []float64) []byte {
+	scale, scaled, ok := deltaScale(values)
+	if !ok {
+		data := make([]byte, 1, 1+8*len(values))
+		data[0] = rawDeltaScale
+		for _, v := range values {
+			data = binary.BigEndian.AppendUint64(data, math.Float64bits(v))
+		}
+		return data
+	}
+	data := []byte{byte(scale)}
+	var prev int64
+	for _, n := range scaled {
+		data = binary.AppendVarint(data, n-prev)
+		prev = n
+	}
+	return data
+}
+
+// deltaScale finds the smallest scale at which every value is an integer
+// that decodes to the very same float64
+func deltaScale(values []float64) (int, []int64, bool) {
+	scaled := make([]int64, len(values))
+next:
+	for scale, factor := range deltaScales {
+		for i, v := range values {
+			n := math.Round(v * factor)
+			if !(math.Abs(n) <= 1<<53) {
+				continue next
+			}
+			// compared bitwise, as -0 scales to 0
+			if scaled[i] = int64(n); math.Float64bits(float64(scaled[i])/factor) != math.Float64bits(v) {
+				continue next
+			}
+		}
+		return scale, scaled, true
+	}
+	return 0, nil, false
+}
+
+// decodeValues decodes the EncodedValues of a block
+func decodeValues(e ValueEncoding, data []byte) ([]float64, error) {
+	switch e {
+	case EncodingFloat32:
+		if len(data)%4 != 0 {
+			return nil, errEncodedValues
+		}
+		values := make([]float64, len(data)/4)
+		for i := range values {
+			values[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(data[4*i:])))
+		}
+		return values, nil
+	case EncodingDelta:
+		if len(data) == 0 {
+			return nil, errEncodedValues
+		}
+		scale, data := data[0], data[1:]
+		if scale == rawDeltaScale {
+			if len(data)%8 != 0 {
+				return nil, errEncodedValues
+			}
+			values := make([]float64, len(data)/8)
+			for i := range values {
+				values[i] = math.Float64frombits(binary.BigEndian.Uint64(data[8*i:]))
+			}
+			return values, nil
+		}
+		if int(scale) >= len(deltaScales) {
+			return nil, errEncodedValues
+		}
+		var values []float64
+		var n int64
+		for len(data) > 0 {
+			delta, size := binary.Varint(data)
+			if size <= 0 {
+				return nil, errEncodedValues
+			}
+			n += delta
+			values = append(values, float64(n)/deltaScales[scale])
+			data = data[size:]
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("%w: unbekannte Kodierung %s", errEncodedValues, e)
+}
+
+// encodedCount returns the number of values in the EncodedValues of a
+// block without decoding them
+func encodedCount(e ValueEncoding, data []byte) int {
+	switch {
+	case e == EncodingFloat32:
+		return len(data) / 4
+	case len(data) == 0:
+		return 0
+	case data[0] == rawDeltaScale:
+		return (len(data) - 1) / 8
+	}
+	// every varint ends with the one byte whose high bit is clear
+	count := 0
+	for _, b := range data[1:] {
+		if b < 0x80 {
+			count++
+		}
+	}
+	return count
+}
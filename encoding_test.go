@@ -0,0 +1,212 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// valueEncodings are the encodings every test runs with
+var valueEncodings = []ValueEncoding{EncodingNone, EncodingFloat32, EncodingDelta}
+
+// sensorRows returns n rows of size readings with 2 decimal places, like
+// the ones of a temperature sensor
+func sensorRows(n, size int) [][]float64 {
+	r := rand.New(rand.NewSource(286))
+	rows := make([][]float64, n)
+	for i := range rows {
+		row := make([]float64, size)
+		for j := range row {
+			row[j] = math.Round((21+0.8*r.NormFloat64())*100) / 100
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// sameBits reports whether a and b hold the very same float64 values
+func sameBits(a, b []float64) bool {
+	return slices.EqualFunc(a, b, func(x, y float64) bool { return math.Float64bits(x) == math.Float64bits(y) })
+}
+
+func TestValueEncodingRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]float64, 50)
+	for i := range random {
+		random[i] = r.NormFloat64() * 1e3
+	}
+	for _, tc := range []struct {
+		name   string
+		values []float64
+	}{
+		{"sensor", sensorRows(1, 100)[0]},
+		{"integers", []float64{3, 1, 4, 1, 5, 9, 2, 6}},
+		{"signs", []float64{math.Copysign(0, -1), 0, -1.5, 2.25, -1e-9}},
+		{"large", []float64{1 << 52, -(1 << 52), 1}},
+		{"full precision", random},
+	} {
+		for _, e := range valueEncodings {
+			t.Run(tc.name+"/"+string(e), func(t *testing.T) {
+				block := &Block{Kind: KindFloat, Values: e.roundValues(tc.values)}
+				encodeBlockValues(block, e)
+				got := block.GetValues()
+				if e == EncodingFloat32 {
+					for i, v := range tc.values {
+						if got[i] != float64(float32(v)) {
+							t.Fatalf("value %d decoded as %v, want %v rounded to float32", i, got[i], v)
+						}
+					}
+					return
+				}
+				// the other encodings are lossless, -0 included
+				if !sameBits(got, tc.values) {
+					t.Fatalf("values decoded as %v, want %v", got, tc.values)
+				}
+				if block.storedCount() != len(tc.values) {
+					t.Fatalf("block counts %d stored values, want %d", block.storedCount(), len(tc.values))
+				}
+			})
+		}
+	}
+}
+
+func TestValueEncodingStatsTolerance(t *testing.T) {
+	rows := sensorRows(50, 100)
+	// a spike gives every block an outlier
+	for _, row := range rows {
+		row[17] = 35
+	}
+	chains := make(map[ValueEncoding]*Blockchain)
+	for _, e := range valueEncodings {
+		bc, err := NewBlockchain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.SetValueEncoding(e); err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			if _, err := bc.AddBlock(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		chains[e] = bc
+	}
+
+	plain := chains[EncodingNone].Blocks()
+	for _, e := range []ValueEncoding{EncodingFloat32, EncodingDelta} {
+		// float32 keeps about 7 significant digits
+		tolerance := 1e-6
+		if e == EncodingDelta {
+			tolerance = 0
+		}
+		near := func(x, y float64) bool { return math.Abs(x-y) <= tolerance*math.Max(1, math.Abs(y)) }
+		for i, block := range chains[e].Blocks()[1:] {
+			want := plain[i+1]
+			if !near(block.Mean, want.Mean) || !near(block.Median, want.Median) ||
+				!near(block.TwoSDLower, want.TwoSDLower) || !near(block.TwoSDUpper, want.TwoSDUpper) {
+				t.Fatalf("%s block %d has mean %v, median %v and range [%v, %v], want %v, %v and [%v, %v]", e, block.Index,
+					block.Mean, block.Median, block.TwoSDLower, block.TwoSDUpper, want.Mean, want.Median, want.TwoSDLower, want.TwoSDUpper)
+			}
+			if block.OutlierCount() != want.OutlierCount() {
+				t.Fatalf("%s block %d has %d outliers, want %d", e, block.Index, block.OutlierCount(), want.OutlierCount())
+			}
+			if err := block.VerifyStats(); err != nil {
+				t.Fatalf("%s block %d: %v", e, block.Index, err)
+			}
+		}
+	}
+}
+
+func TestEncodedChainValidates(t *testing.T) {
+	for _, e := range []ValueEncoding{EncodingFloat32, EncodingDelta} {
+		t.Run(string(e), func(t *testing.T) {
+			bc, err := NewBlockchain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := bc.SetValueEncoding(e); err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range sensorRows(5, 20) {
+				if _, err := bc.AddBlock(row); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := bc.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), "chain.json")
+			if err := bc.SaveToFile(path); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := LoadBlockchainFromFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := loaded.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if !sameHashes(loaded.Blocks(), bc.Blocks()) {
+				t.Fatal("the loaded chain differs from the saved one")
+			}
+
+			// the hash covers the encoded bytes
+			expectInvalidAt(t, bc, tamper(t, bc, 3, func(b *Block) { b.EncodedValues[len(b.EncodedValues)-1] ^= 1 }))
+		})
+	}
+}
+
+// BenchmarkValueEncodingMemory builds a chain of 10k blocks of 100 sensor
+// readings with each encoding and reports its estimated size and the heap
+// it holds on to
+func BenchmarkValueEncodingMemory(b *testing.B) {
+	rows := sensorRows(10000, 100)
+	for _, e := range valueEncodings {
+		b.Run(string(e), func(b *testing.B) {
+			var estimated, heap float64
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				bc, err := NewBlockchain()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := bc.SetValueEncoding(e); err != nil {
+					b.Fatal(err)
+				}
+				if err := bc.AddBlocksBulk(rows, 0); err != nil {
+					b.Fatal(err)
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				estimated = float64(bc.MemoryUsage())
+				heap = float64(after.HeapAlloc) - float64(before.HeapAlloc)
+				runtime.KeepAlive(bc)
+			}
+			b.ReportMetric(estimated, "bytes/chain")
+			b.ReportMetric(heap, "heap-bytes/chain")
+		})
+	}
+}
+
+// BenchmarkGetValues measures the cost of decoding the values of a block
+// of 100 sensor readings
+func BenchmarkGetValues(b *testing.B) {
+	values := sensorRows(1, 100)[0]
+	for _, e := range valueEncodings {
+		b.Run(string(e), func(b *testing.B) {
+			block := &Block{Kind: KindFloat, Values: e.roundValues(values)}
+			encodeBlockValues(block, e)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				block.GetValues()
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is the number of events a subscriber may fall behind
+// before the oldest are dropped
+const subscriberBuffer = 64
+
+// BlockEvent announces a block appended to the chain
+type BlockEvent struct {
+	Index     int
+	Outliers  int
+	Timestamp time.Time
+	// Mean and Anomalous are those of the block
+	Mean      float64
+	Anomalous bool
+	// Dropped counts the events this subscriber lost so far because it
+	// fell more than subscriberBuffer events behind
+	Dropped int
+}
+
+// subscriber is a channel events are delivered to
+type subscriber struct {
+	events  chan BlockEvent
+	filter  func(*Block) bool
+	dropped int
+}
+
+// Subscribe returns a channel that receives an event for every block
+// appended from now on, and a function that ends the subscription and
+// closes the channel. The writer never waits for a subscriber: once
+// subscriberBuffer events are pending, the oldest is dropped and counted
+// in BlockEvent.Dropped.
+func (bc *Blockchain) Subscribe() (<-chan BlockEvent, func()) {
+	return bc.SubscribeFiltered(nil)
+}
+
+// SubscribeFiltered is Subscribe for the blocks filter accepts; nil
+// accepts all. filter is called while the block is appended, so it must
+// be quick, must not modify the block and must not call the chain.
+func (bc *Blockchain) SubscribeFiltered(filter func(*Block) bool) (<-chan BlockEvent, func()) {
+	sub := &subscriber{events: make(chan BlockEvent, subscriberBuffer), filter: filter}
+
+	bc.mu.Lock()
+	if bc.subscribers == nil {
+		bc.subscribers = map[*subscriber]struct{}{}
+	}
+	bc.subscribers[sub] = struct{}{}
+	bc.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			bc.mu.Lock()
+			defer bc.mu.Unlock()
+			delete(bc.subscribers, sub)
+			close(sub.events)
+		})
+	}
+	return sub.events, unsubscribe
+}
+
+// publish sends the event of a new block to every subscriber whose filter
+// accepts it. Called with bc.mu held.
+func (bc *Blockchain) publish(block *Block) {
+	for sub := range bc.subscribers {
+		if sub.filter != nil && !sub.filter(block) {
+			continue
+		}
+		event := BlockEvent{
+			Index:     block.Index,
+			Outliers:  block.OutlierCount(),
+			Timestamp: block.Timestamp,
+			Mean:      block.Mean,
+			Anomalous: block.Anomalous,
+			Dropped:   sub.dropped,
+		}
+		select {
+		case sub.events <- event:
+			continue
+		default:
+		}
+		// full: drop the oldest event. Only publish sends, so there is
+		// room afterwards even if the subscriber took it meanwhile.
+		select {
+		case <-sub.events:
+			sub.dropped++
+		default:
+		}
+		event.Dropped = sub.dropped
+		sub.events <- event
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	bc := newFilledChain(t)
+	events, unsubscribe := bc.Subscribe()
+	withOutliers, unsubscribeOutliers := bc.SubscribeFiltered(func(block *Block) bool { return block.HasOutliers })
+
+	// only blocks appended after subscribing are announced
+	for _, values := range chainTestValues {
+		if _, err := bc.AddBlock(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	head := bc.LatestBlock()
+	for i := range chainTestValues {
+		event := <-events
+		block, err := bc.BlockByIndex(head.Index - len(chainTestValues) + 1 + i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Index != block.Index || event.Outliers != block.OutlierCount() || !event.Timestamp.Equal(block.Timestamp) || event.Mean != block.Mean || event.Dropped != 0 {
+			t.Fatalf("event %+v does not announce block %d", event, block.Index)
+		}
+	}
+	// blocks 2 and 4 of chainTestValues have an outlier each
+	for _, offset := range []int{1, 3} {
+		if event := <-withOutliers; event.Index != head.Index-len(chainTestValues)+1+offset || event.Outliers != 1 {
+			t.Fatalf("filtered subscriber got %+v", event)
+		}
+	}
+	if len(withOutliers) != 0 {
+		t.Fatalf("filtered subscriber has %d more events", len(withOutliers))
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, open := <-events; open {
+		t.Fatal("channel is open after unsubscribing")
+	}
+	if _, err := bc.AddBlock([]float64{1}); err != nil {
+		t.Fatal(err)
+	}
+	unsubscribeOutliers()
+}
+
+func TestSubscriberFallingBehind(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, unsubscribe := bc.Subscribe()
+	defer unsubscribe()
+
+	// appending never waits for the subscriber
+	const extra = 5
+	for i := 0; i < subscriberBuffer+extra; i++ {
+		if _, err := bc.AddBlock([]float64{float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(events) != subscriberBuffer {
+		t.Fatalf("%d events pending, want %d", len(events), subscriberBuffer)
+	}
+	first := <-events
+	if first.Index != 1+extra {
+		t.Fatalf("oldest pending event is for block %d, want %d", first.Index, 1+extra)
+	}
+	var last BlockEvent
+	for len(events) > 0 {
+		last = <-events
+	}
+	if last.Index != subscriberBuffer+extra || last.Dropped != extra {
+		t.Fatalf("last event is %+v, want block %d with %d dropped", last, subscriberBuffer+extra, extra)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportColumns are the columns of ExportCSV its checksums cover, in the
+// order they are written
+var exportColumns = []string{"Index", "Timestamp", "Mean", "Median", "TwoSDLower", "TwoSDUpper", "OutlierCount", "Hash", "PrevHash", "Values", "Text"}
+
+// exportChecksumColumn is the last column of ExportCSV
+const exportChecksumColumn = "Checksum"
+
+// ExportCSV writes one row per block, in chain order, with the block's
+// stats, its values joined by semicolons, its annotation and a checksum
+// over all of these columns, see canonicalExportField. A last row starting
+// with exportHeadMarker references the first block and the head, so that
+// VerifyExportCSV finds rows that were edited, added or deleted.
+func (bc *Blockchain) ExportCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append(slices.Clone(exportColumns), exportChecksumColumn)); err != nil {
+		return err
+	}
+	blocks := bc.snapshot()
+	for _, block := range blocks {
+		record := []string{
+			strconv.Itoa(block.Index),
+			block.Timestamp.Format(time.RFC3339),
+			formatExportFloat(block.Mean),
+			exportMedian(block),
+			formatExportFloat(block.TwoSDLower),
+			formatExportFloat(block.TwoSDUpper),
+			strconv.Itoa(block.OutlierCount()),
+			block.Hash,
+			block.PrevHash,
+			exportValues(block),
+			block.Text,
+		}
+		checksum, err := exportRowChecksum(record, false)
+		if err != nil {
+			return fmt.Errorf("Block %d konnte nicht exportiert werden: %w", block.Index, err)
+		}
+		if err := cw.Write(append(record, checksum)); err != nil {
+			return err
+		}
+	}
+	if len(blocks) > 0 {
+		first, head := strconv.Itoa(blocks[0].Index), blocks[len(blocks)-1]
+		index := strconv.Itoa(head.Index)
+		if err := cw.Write([]string{exportHeadMarker, first, index, head.Hash, exportHeadChecksum(first, index, head.Hash)}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportJSON writes the full chain in the format of SaveToFile, so an
+// export can be loaded again with LoadBlockchainFromFile. ValueOrigins
+// are only written with SetExportOrigins.
+func (bc *Blockchain) ExportJSON(w io.Writer) error {
+	file, err := bc.chainFile()
+	if err != nil {
+		return err
+	}
+	bc.mu.RLock()
+	enabled := bc.exportOrigins
+	bc.mu.RUnlock()
+	if !enabled {
+		file.Blocks = withoutOrigins(file.Blocks)
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("Blockchain konnte nicht exportiert werden: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ExportNDJSON writes one line per block, in chain order, holding the
+// block as JSON with all its fields, as in SaveToFile, but ValueOrigins
+// only with SetExportOrigins. Only blocks with a value within are
+// written, whole, see CompareWindows; nil writes all. Blocks are encoded
+// one at a time, so the export needs no memory beyond a single block.
+func (bc *Blockchain) ExportNDJSON(w io.Writer, within *TimeRange) error {
+	return writeNDJSON(w, bc.exportSnapshot(), within)
+}
+
+// writeNDJSON writes blocks as ExportNDJSON does
+func writeNDJSON(w io.Writer, blocks []*Block, within *TimeRange) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, block := range blocks {
+		if within != nil && !block.overlaps(*within) {
+			continue
+		}
+		if err := enc.Encode(block); err != nil {
+			return fmt.Errorf("Block %d konnte nicht exportiert werden: %w", block.Index, err)
+		}
+	}
+	return bw.Flush()
+}
+
+// readNDJSON decodes the blocks of an export of ExportNDJSON one at a time
+// and calls fn with each and its record number, counting from 1, until fn
+// fails
+func readNDJSON(r io.Reader, fn func(record int, block *Block) error) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for record := 1; ; record++ {
+		block := &Block{}
+		if err := dec.Decode(block); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Datensatz %d: %w", record, err)
+		}
+		if err := fn(record, block); err != nil {
+			return err
+		}
+	}
+}
+
+// ExportGob writes the blocks, in chain order, as a stream of gob values
+// with the fields ExportNDJSON writes, encoded one at a time like its
+// lines
+func (bc *Blockchain) ExportGob(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := gob.NewEncoder(bw)
+	for _, block := range bc.exportSnapshot() {
+		if err := enc.Encode(block); err != nil {
+			return fmt.Errorf("Block %d konnte nicht exportiert werden: %w", block.Index, err)
+		}
+	}
+	return bw.Flush()
+}
+
+// formatExportFloat formats a stat without loss; NaN and the infinities
+// are written as NaN, +Inf and -Inf
+func formatExportFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// exportMedian formats the median of a block, as an integer if it is the
+// exact median of a KindInt block, which a float could not hold past 2^53
+func exportMedian(block *Block) string {
+	if block.Kind == KindInt && block.IntStats != nil && block.IntStats.MedianExact {
+		return strconv.FormatInt(block.IntStats.Median, 10)
+	}
+	return formatExportFloat(block.Median)
+}
+
+// exportValues joins the values of a block by semicolons
+func exportValues(block *Block) string {
+	var parts []string
+	if block.Kind == KindInt {
+		parts = make([]string, len(block.IntValues))
+		for i, v := range block.IntValues {
+			parts[i] = strconv.FormatInt(v, 10)
+		}
+	} else {
+		values := block.GetValues()
+		parts = make([]string, len(values))
+		for i, v := range values {
+			parts[i] = formatExportFloat(v)
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// writeExportFile exports the chain to the file at path in format, csv,
+// json, ndjson or gob, compressed with the codec set with SetCodec.
+// within limits an ndjson export to a time range; nil exports all blocks.
+func writeExportFile(bc *Blockchain, path, format string, within *TimeRange) error {
+	var export func(io.Writer) error
+	switch format {
+	case "csv":
+		export = bc.ExportCSV
+	case "json":
+		export = bc.ExportJSON
+	case "ndjson":
+		export = func(w io.Writer) error { return bc.ExportNDJSON(w, within) }
+	case "gob":
+		export = bc.ExportGob
+	default:
+		return fmt.Errorf("Ungültiges Exportformat: %s", format)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeThroughCodec(file, bc.Codec(), export); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportCSVRows(t *testing.T) {
+	bc := newFilledChain(t)
+	if err := bc.AddBlockWithText([]float64{0.1, 2.5e-7, 1e21}, `Lauf 7, "kalibriert"`); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := bc.ExportCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	reader := csv.NewReader(&buf)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := append(slices.Clone(exportColumns), exportChecksumColumn)
+	if !slices.Equal(records[0], header) {
+		t.Fatalf("header is %v, want %v", records[0], header)
+	}
+	blocks := bc.Blocks()
+	// one row per block and the head row
+	if len(records) != 1+len(blocks)+1 || records[len(records)-1][0] != exportHeadMarker {
+		t.Fatalf("export has %d records for %d blocks", len(records), len(blocks))
+	}
+	for i, block := range blocks {
+		row := records[1+i]
+		if row[0] != strconv.Itoa(block.Index) || row[7] != block.Hash || row[8] != block.PrevHash {
+			t.Fatalf("row %d is %v, want block %d", i, row, block.Index)
+		}
+		if mean, err := strconv.ParseFloat(row[2], 64); err != nil || mean != block.Mean && len(block.Values) > 0 {
+			t.Fatalf("row %d has mean %s, want %v", i, row[2], block.Mean)
+		}
+	}
+	last := records[len(records)-2]
+	if last[9] != "0.1;2.5e-07;1e+21" || last[10] != `Lauf 7, "kalibriert"` {
+		t.Fatalf("last block is exported as values %q and text %q", last[9], last[10])
+	}
+}
+
+func TestExportJSONLoads(t *testing.T) {
+	bc := newFilledChain(t)
+	bc.SetTrackOrigins(true)
+	dir := writeImportFiles(t, map[string]string{"werte.csv": "1,2,3\n"})
+	if _, err := bc.ImportFromFile(filepath.Join(dir, "werte.csv"), "csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, withOrigins := range []bool{false, true} {
+		bc.SetExportOrigins(withOrigins)
+		path := filepath.Join(t.TempDir(), "export.json")
+		var buf bytes.Buffer
+		if err := bc.ExportJSON(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(buf.String(), `"value_origins"`) != withOrigins {
+			t.Fatalf("export with origins %v holds %s", withOrigins, buf.String())
+		}
+
+		loaded, err := LoadBlockchainFromFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := loaded.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		want, got := bc.Blocks(), loaded.Blocks()
+		if len(got) != len(want) {
+			t.Fatalf("loaded %d blocks, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if got[i].Hash != want[i].Hash || !slices.Equal(got[i].Values, want[i].Values) {
+				t.Fatalf("loaded block %d differs: %+v", i, got[i])
+			}
+		}
+	}
+}
+
+func TestExportNDJSONWithin(t *testing.T) {
+	bc := newFilledChain(t)
+	measured := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	timed, err := bc.AddTimedValues([]Sample{{measured, 1}, {measured.Add(time.Hour), 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{3}); err != nil {
+		t.Fatal(err)
+	}
+	var all, untimed []int
+	for _, block := range bc.Blocks() {
+		all = append(all, block.Index)
+		if block.Index != timed.Index {
+			untimed = append(untimed, block.Index)
+		}
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		within *TimeRange
+		want   []int
+	}{
+		{"all", nil, all},
+		// timed blocks are selected by the times of their values, the
+		// others by their timestamp
+		{"measuring times", &TimeRange{Start: measured.Add(30 * time.Minute), End: measured.Add(2 * time.Hour)}, []int{timed.Index}},
+		{"end is exclusive", &TimeRange{Start: measured.Add(-time.Hour), End: measured}, nil},
+		{"timestamps", &TimeRange{Start: now.Add(-time.Hour), End: now.Add(time.Hour)}, untimed},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := bc.ExportNDJSON(&buf, tt.within); err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		err := readNDJSON(&buf, func(record int, block *Block) error {
+			got = append(got, block.Index)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Fatalf("%s: exported blocks %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportHeadMarker starts the last row of ExportCSV, which references the
+// blocks the export covers: the index of the first block, the index and
+// hash of the head and the checksum of the row
+const exportHeadMarker = "#head"
+
+// exportChecksumDigits is the number of significant digits floats are
+// compared with, as many as spreadsheets keep
+const exportChecksumDigits = 15
+
+// ErrExportUnverifiable is returned by VerifyExportCSV for files it cannot
+// check rows against: not an export of ExportCSV, without checksums or
+// without an intact head reference
+var ErrExportUnverifiable = errors.New("Export kann nicht geprüft werden")
+
+// ExportVerification is the result of VerifyExportCSV. Rows are given by
+// the line they start on in the file.
+type ExportVerification struct {
+	FirstIndex int
+	HeadIndex  int
+	HeadHash   string
+	// Rows counts the block rows of the file
+	Rows int
+	// Modified are rows whose fields no longer match their checksum
+	Modified []int
+	// Added are rows without a checksum, repeating the index of an
+	// earlier row or outside the blocks of the head reference
+	Added []int
+	// Deleted are the indexes of blocks the head reference covers that
+	// no row holds
+	Deleted []int
+}
+
+// OK reports whether the file holds the rows of the export unchanged
+func (v ExportVerification) OK() bool {
+	return len(v.Modified) == 0 && len(v.Added) == 0 && len(v.Deleted) == 0
+}
+
+// exportRowChecksum returns the checksum of a row of ExportCSV over the
+// canonical form of its fields in exportColumns order, see
+// canonicalExportField. decimalComma makes it read floats written with a
+// decimal comma.
+func exportRowChecksum(fields []string, decimalComma bool) (string, error) {
+	h := sha256.New()
+	for i, field := range fields {
+		canonical, err := canonicalExportField(exportColumns[i], field, decimalComma)
+		if err != nil {
+			return "", fmt.Errorf("Spalte %s: %w", exportColumns[i], err)
+		}
+		h.Write([]byte(canonical))
+		h.Write([]byte{0x1f})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8]), nil
+}
+
+// exportHeadChecksum returns the checksum of the head reference row
+func exportHeadChecksum(first, head, hash string) string {
+	sum := sha256.Sum256([]byte(exportHeadMarker + "\x1f" + first + "\x1f" + head + "\x1f" + strings.ToLower(hash)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// canonicalExportField returns field of column in the form its checksum
+// covers, so that a spreadsheet saving the file again does not change it:
+// integers and timestamps are compared by value, timestamps to the second
+// whatever their zone, floats to exportChecksumDigits significant digits,
+// hashes in lower case and text with \n line breaks. Surrounding spaces
+// are ignored except in Text.
+func canonicalExportField(column, field string, decimalComma bool) (string, error) {
+	if column != "Text" {
+		field = strings.TrimSpace(field)
+	}
+	switch column {
+	case "Index", "OutlierCount":
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(n), nil
+	case "Timestamp":
+		t, err := time.Parse(time.RFC3339, field)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(t.Unix(), 10), nil
+	case "Mean", "Median", "TwoSDLower", "TwoSDUpper":
+		return canonicalExportFloat(field, decimalComma)
+	case "Hash", "PrevHash":
+		return strings.ToLower(field), nil
+	case "Values":
+		if field == "" {
+			return "", nil
+		}
+		parts := strings.Split(field, ";")
+		for i, part := range parts {
+			v, err := canonicalExportFloat(strings.TrimSpace(part), decimalComma)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = v
+		}
+		return strings.Join(parts, ";"), nil
+	case "Text":
+		return strings.ReplaceAll(field, "\r\n", "\n"), nil
+	}
+	return field, nil
+}
+
+// canonicalExportFloat rounds a float to exportChecksumDigits significant
+// digits
+func canonicalExportFloat(field string, decimalComma bool) (string, error) {
+	if decimalComma {
+		field = strings.Replace(field, ",", ".", 1)
+	}
+	v, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return "", err
+	}
+	if v == 0 {
+		// -0 and 0 look alike in a spreadsheet
+		v = math.Abs(v)
+	}
+	return strconv.FormatFloat(v, 'g', exportChecksumDigits, 64), nil
+}
+
+// VerifyExportCSV checks a file written by ExportCSV against the checksums
+// of its rows and its head reference. It reads files saved again by a
+// spreadsheet as well: separated by semicolons with decimal commas, with
+// other quoting, line breaks or a byte order mark, and floats cut to
+// exportChecksumDigits digits. Columns are found by the header, so they
+// may be reordered. A file it cannot check is reported as
+// ErrExportUnverifiable.
+func VerifyExportCSV(r io.Reader) (ExportVerification, error) {
+	var v ExportVerification
+	buffered := bufio.NewReader(r)
+	if bom, err := buffered.Peek(3); err == nil && string(bom) == "\ufeff" {
+		buffered.Discard(3)
+	}
+	// the separator is sniffed from the header line
+	start, _ := buffered.Peek(4096)
+	if len(start) == 0 {
+		return v, fmt.Errorf("%w: Datei ist leer", ErrExportUnverifiable)
+	}
+	line, _, _ := bytes.Cut(start, []byte("\n"))
+	reader := csv.NewReader(buffered)
+	reader.FieldsPerRecord = -1
+	decimalComma := bytes.Count(line, []byte(";")) > bytes.Count(line, []byte(","))
+	if decimalComma {
+		reader.Comma = ';'
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return v, fmt.Errorf("%w: %v", ErrExportUnverifiable, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	positions := make([]int, len(exportColumns))
+	for i, name := range exportColumns {
+		pos, ok := columns[name]
+		if !ok {
+			return v, fmt.Errorf("%w: Spalte %s fehlt", ErrExportUnverifiable, name)
+		}
+		positions[i] = pos
+	}
+	checksumPos, ok := columns[exportChecksumColumn]
+	if !ok {
+		return v, fmt.Errorf("%w: Spalte %s fehlt", ErrExportUnverifiable, exportChecksumColumn)
+	}
+
+	type row struct {
+		line, index int
+		valid       bool
+	}
+	var rows []row
+	headFound := false
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return v, fmt.Errorf("%w: %v", ErrExportUnverifiable, err)
+		}
+		lineNo, _ := reader.FieldPos(0)
+		// spreadsheets pad short rows with empty cells
+		for len(record) > 0 && strings.TrimSpace(record[len(record)-1]) == "" {
+			record = record[:len(record)-1]
+		}
+		if len(record) == 0 {
+			continue
+		}
+		if strings.TrimSpace(record[0]) == exportHeadMarker {
+			if headFound || len(record) != 5 || exportHeadChecksum(strings.TrimSpace(record[1]), strings.TrimSpace(record[2]), strings.TrimSpace(record[3])) != strings.TrimSpace(record[4]) {
+				return v, fmt.Errorf("%w: Kopfverweis in Zeile %d ist beschädigt", ErrExportUnverifiable, lineNo)
+			}
+			v.FirstIndex, _ = strconv.Atoi(strings.TrimSpace(record[1]))
+			v.HeadIndex, _ = strconv.Atoi(strings.TrimSpace(record[2]))
+			v.HeadHash = strings.ToLower(strings.TrimSpace(record[3]))
+			headFound = true
+			continue
+		}
+
+		v.Rows++
+		fields := make([]string, len(exportColumns))
+		for i, pos := range positions {
+			if pos < len(record) {
+				fields[i] = record[pos]
+			}
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil {
+			index = -1
+		}
+		var checksum string
+		if checksumPos < len(record) {
+			checksum = strings.TrimSpace(record[checksumPos])
+		}
+		if checksum == "" {
+			v.Added = append(v.Added, lineNo)
+			continue
+		}
+		sum, err := exportRowChecksum(fields, decimalComma)
+		rows = append(rows, row{line: lineNo, index: index, valid: err == nil && sum == checksum})
+	}
+	if !headFound {
+		return v, fmt.Errorf("%w: Kopfverweis fehlt", ErrExportUnverifiable)
+	}
+
+	seen := make(map[int]bool, len(rows))
+	for _, row := range rows {
+		switch {
+		case !row.valid:
+			v.Modified = append(v.Modified, row.line)
+		case row.index < v.FirstIndex || row.index > v.HeadIndex || seen[row.index]:
+			v.Added = append(v.Added, row.line)
+			continue
+		}
+		if row.index >= 0 {
+			seen[row.index] = true
+		}
+	}
+	slices.Sort(v.Added)
+	for index := v.FirstIndex; index <= v.HeadIndex; index++ {
+		if !seen[index] {
+			v.Deleted = append(v.Deleted, index)
+		}
+	}
+	return v, nil
+}
+
+// runVerifyExportCommand implements "verify-export <file>": it reports
+// the rows of a CSV export that were modified, added or deleted and exits
+// with 1 if there are any
+func runVerifyExportCommand(args []string) int {
+	fs := flag.NewFlagSet("verify-export", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Aufruf: verify-export <datei>")
+		return 2
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+	r, err := openCodecStream(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer r.Close()
+	v, err := VerifyExportCSV(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("%d Zeilen, Blöcke %d bis %d, Kopf %s\n", v.Rows, v.FirstIndex, v.HeadIndex, formatHash(v.HeadHash))
+	if v.OK() {
+		fmt.Println("Export ist unverändert")
+		return 0
+	}
+	printExportFindings("Geänderte Zeilen", v.Modified)
+	printExportFindings("Hinzugefügte Zeilen", v.Added)
+	printExportFindings("Fehlende Blöcke", v.Deleted)
+	return 1
+}
+
+// printExportFindings prints a finding of verify-export, if any
+func printExportFindings(label string, numbers []int) {
+	if len(numbers) == 0 {
+		return
+	}
+	parts := make([]string, len(numbers))
+	for i, n := range numbers {
+		parts[i] = strconv.Itoa(n)
+	}
+	fmt.Printf("%s: %s\n", label, strings.Join(parts, ", "))
+}
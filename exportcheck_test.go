@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// demoExport returns the records of the CSV export of the demo chain
+func demoExport(t *testing.T) [][]string {
+	t.Helper()
+	bc, err := LoadDemoChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := bc.ExportCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	reader := csv.NewReader(&buf)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// verifyRecords writes records as CSV and verifies them
+func verifyRecords(t *testing.T, records [][]string) (ExportVerification, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	if err := cw.WriteAll(records); err != nil {
+		t.Fatal(err)
+	}
+	return VerifyExportCSV(&buf)
+}
+
+// expectFindings checks the rows VerifyExportCSV reports
+func expectFindings(t *testing.T, v ExportVerification, modified, added, deleted []int) {
+	t.Helper()
+	if !slices.Equal(v.Modified, modified) || !slices.Equal(v.Added, added) || !slices.Equal(v.Deleted, deleted) {
+		t.Fatalf("found modified %v, added %v and deleted %v, want %v, %v and %v", v.Modified, v.Added, v.Deleted, modified, added, deleted)
+	}
+}
+
+// spreadsheetResave writes records as a German spreadsheet saves them: a
+// byte order mark, semicolons, every field quoted, decimal commas, floats
+// cut to 15 digits, CRLF line breaks and short rows padded
+func spreadsheetResave(records [][]string) string {
+	resave := func(field string) string {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return field
+		}
+		return strings.Replace(strconv.FormatFloat(v, 'g', 15, 64), ".", ",", 1)
+	}
+	var sb strings.Builder
+	sb.WriteString("\ufeff")
+	width := len(records[0])
+	for i, record := range records {
+		fields := slices.Clone(record)
+		for len(fields) < width {
+			fields = append(fields, "")
+		}
+		for j, field := range fields {
+			switch name := records[0][j]; {
+			case i == 0 || fields[0] == exportHeadMarker:
+			case name == "Values":
+				parts := strings.Split(field, ";")
+				for k, part := range parts {
+					parts[k] = resave(part)
+				}
+				fields[j] = strings.Join(parts, ";")
+			case name == "Mean" || name == "Median" || name == "TwoSDLower" || name == "TwoSDUpper":
+				fields[j] = resave(field)
+			}
+			fields[j] = `"` + strings.ReplaceAll(fields[j], `"`, `""`) + `"`
+		}
+		sb.WriteString(strings.Join(fields, ";") + "\r\n")
+	}
+	return sb.String()
+}
+
+func TestVerifyExportUnchanged(t *testing.T) {
+	records := demoExport(t)
+	if header := records[0]; header[len(header)-1] != exportChecksumColumn {
+		t.Fatalf("export has header %v, want a last column %s", header, exportChecksumColumn)
+	}
+	v, err := verifyRecords(t, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.OK() || v.Rows != demoBlocks+1 || v.FirstIndex != 0 || v.HeadIndex != demoBlocks || v.HeadHash != demoHeadHash {
+		t.Fatalf("verification is %+v, want %d unchanged rows up to head %s", v, demoBlocks+1, demoHeadHash)
+	}
+}
+
+func TestExportIntMedianExact(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetValueKind(KindInt); err != nil {
+		t.Fatal(err)
+	}
+	// 2^53+1 is the first integer a float64 cannot hold
+	if err := bc.AddIntBlock([]int64{1 << 53, 1<<53 + 1, 1<<53 + 3}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := bc.ExportCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	reader := csv.NewReader(&buf)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	median := slices.Index(records[0], "Median")
+	if got := records[2][median]; got != "9007199254740993" {
+		t.Fatalf("median of the int block is exported as %s, want 9007199254740993", got)
+	}
+	if v, err := verifyRecords(t, records); err != nil || !v.OK() {
+		t.Fatalf("export with an int median does not verify: %+v, %v", v, err)
+	}
+}
+
+func TestVerifyExportDetectsEditedCell(t *testing.T) {
+	for _, column := range []string{"Mean", "Values", "Text", "Timestamp"} {
+		t.Run(column, func(t *testing.T) {
+			records := demoExport(t)
+			col := slices.Index(records[0], column)
+			// the record of block 42 is on line 44, after the header
+			row := records[43]
+			switch column {
+			case "Mean":
+				row[col] = "21.9"
+			case "Values":
+				row[col] = strings.Replace(row[col], ";", ";1", 1)
+			case "Text":
+				row[col] += "!"
+			case "Timestamp":
+				row[col] = strings.Replace(row[col], "2024", "2023", 1)
+			}
+			v, err := verifyRecords(t, records)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectFindings(t, v, []int{44}, nil, nil)
+		})
+	}
+}
+
+func TestVerifyExportDetectsAddedAndDeletedRows(t *testing.T) {
+	records := demoExport(t)
+	footer := records[len(records)-1]
+	rows := slices.Clone(records[:len(records)-1])
+	// block 10 is dropped, block 5 copied to the end and a row typed in
+	rows = slices.Delete(rows, 11, 12)
+	typed := slices.Clone(rows[20])
+	typed[len(typed)-1] = ""
+	rows = append(rows, slices.Clone(rows[6]), typed)
+	v, err := verifyRecords(t, append(rows, footer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFindings(t, v, nil, []int{102, 103}, []int{10})
+
+	// rows dropped from the end are found through the head reference
+	v, err = verifyRecords(t, append(slices.Clone(records[:len(records)-3]), footer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFindings(t, v, nil, nil, []int{demoBlocks - 1, demoBlocks})
+}
+
+func TestVerifyExportSpreadsheetResave(t *testing.T) {
+	records := demoExport(t)
+	v, err := VerifyExportCSV(strings.NewReader(spreadsheetResave(records)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.OK() || v.Rows != demoBlocks+1 {
+		t.Fatalf("an untouched file saved again reports %+v", v)
+	}
+
+	records[8][slices.Index(records[0], "Median")] = "30"
+	v, err = VerifyExportCSV(strings.NewReader(spreadsheetResave(records)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFindings(t, v, []int{9}, nil, nil)
+}
+
+func TestVerifyExportRejectsUnverifiable(t *testing.T) {
+	records := demoExport(t)
+	withoutChecksums := make([][]string, len(records)-1)
+	for i, record := range records[:len(records)-1] {
+		withoutChecksums[i] = record[:len(exportColumns)]
+	}
+	forgedHead := slices.Clone(records)
+	forgedHead[len(forgedHead)-1] = slices.Clone(records[len(records)-1])
+	forgedHead[len(forgedHead)-1][2] = "90"
+
+	for name, records := range map[string][][]string{
+		"without checksums":     withoutChecksums,
+		"without head":          records[:len(records)-1],
+		"with a forged head":    forgedHead,
+		"with a missing column": {{"Index", "Checksum"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := verifyRecords(t, records); !errors.Is(err, ErrExportUnverifiable) {
+				t.Fatalf("VerifyExportCSV returned %v, want ErrExportUnverifiable", err)
+			}
+		})
+	}
+}
+
+func TestRunVerifyExportCommand(t *testing.T) {
+	bc, err := LoadDemoChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "export.csv")
+	if err := writeExportFile(bc, path, "csv", nil); err != nil {
+		t.Fatal(err)
+	}
+	if code := runVerifyExportCommand([]string{path}); code != 0 {
+		t.Fatalf("verify-export of an untouched export exited with %d", code)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, bytes.Replace(data, []byte("Messung 7,"), []byte("Messung 77,"), 1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runVerifyExportCommand([]string{path}); code != 1 {
+		t.Fatalf("verify-export of an edited export exited with %d, want 1", code)
+	}
+	if code := runVerifyExportCommand(nil); code != 2 {
+		t.Fatalf("verify-export without a file exited with %d, want 2", code)
+	}
+}
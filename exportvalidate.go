@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/gob"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportFailure is an inconsistency ValidateExport found. Record counts
+// the records of the file from 1, the header of a CSV file not included.
+type ExportFailure struct {
+	Record int    `json:"record"`
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+func (f ExportFailure) String() string {
+	return fmt.Sprintf("Datensatz %d (Block %d): %s", f.Record, f.Index, f.Reason)
+}
+
+// ExportValidation is the result of ValidateExport
+type ExportValidation struct {
+	Format string `json:"format"`
+	// Records counts the records read
+	Records int `json:"records"`
+	// Failures counts the inconsistencies found, at most 1 unless
+	// ValidateExport was told to keep going
+	Failures int `json:"failures"`
+	// First is the first inconsistency, nil if there is none
+	First *ExportFailure `json:"first,omitempty"`
+}
+
+// OK reports whether the export is consistent
+func (v ExportValidation) OK() bool {
+	return v.Failures == 0
+}
+
+// exportValidator checks the records of an export one at a time against
+// the one before, so the export is never held in memory
+type exportValidator struct {
+	result    ExportValidation
+	keepGoing bool
+
+	prev *Block
+}
+
+// fail counts the failure of record for the block index and reports
+// whether to stop
+func (v *exportValidator) fail(record, index int, reason string) bool {
+	v.result.Failures++
+	if v.result.First == nil {
+		v.result.First = &ExportFailure{Record: record, Index: index, Reason: reason}
+	}
+	return !v.keepGoing
+}
+
+// link checks that block follows the block of the record before: by
+// index, by hash and not earlier, unless its timestamp is flagged as
+// going back, see TimestampAllow. It reports whether to stop.
+func (v *exportValidator) link(record int, block *Block, flagged bool) bool {
+	prev := v.prev
+	v.prev = block
+	if prev == nil {
+		return false
+	}
+	if block.Index != prev.Index+1 && v.fail(record, block.Index, fmt.Sprintf("Index folgt nicht auf %d", prev.Index)) {
+		return true
+	}
+	if block.PrevHash != prev.Hash && v.fail(record, block.Index, fmt.Sprintf("Vorgänger-Hash %s passt nicht zu Block %d", formatHash(block.PrevHash), prev.Index)) {
+		return true
+	}
+	if block.Timestamp.Before(prev.Timestamp) && !flagged {
+		return v.fail(record, block.Index, fmt.Sprintf("Zeitstempel %s liegt vor dem von Block %d", block.Timestamp.Format(time.RFC3339Nano), prev.Index))
+	}
+	return false
+}
+
+// block checks a JSON lines or gob record: its links, and its hash and
+// stats as Validate recomputes them. It reports whether to stop.
+func (v *exportValidator) block(record int, block *Block) bool {
+	v.result.Records = record
+	if v.link(record, block, block.Metadata["non_monotonic_timestamp"] == "true") {
+		return true
+	}
+	if err := verifyBlock(block); err != nil {
+		return v.fail(record, block.Index, err.Reason)
+	}
+	return false
+}
+
+// ValidateExport checks an export of the chain in format, ndjson, csv or
+// gob, reading one record at a time: that indexes follow each other, that
+// every block links to the hash of the one before and that timestamps do
+// not go back. Blocks of ndjson and gob exports are rehashed and their
+// stats recomputed as Validate does. Rows of csv exports lack fields the
+// hash covers, so they are checked against their checksums and their
+// mean and median against their values instead, and the head reference
+// must close the file. Timestamps flagged under TimestampAllow are not
+// in csv exports, so going back there is always reported.
+//
+// It stops at the first inconsistency unless keepGoing is set, which
+// counts all of them. A record that cannot be read ends the check as
+// inconsistent either way; the error is only for a file that cannot be
+// read from the start.
+func ValidateExport(r io.Reader, format string, keepGoing bool) (ExportValidation, error) {
+	v := &exportValidator{result: ExportValidation{Format: format}, keepGoing: keepGoing}
+	var err error
+	switch format {
+	case "ndjson", "jsonl":
+		v.result.Format = "ndjson"
+		err = v.ndjson(r)
+	case "gob":
+		err = v.gob(r)
+	case "csv":
+		err = v.csv(r)
+	default:
+		return v.result, fmt.Errorf("Ungültiges Exportformat: %s", format)
+	}
+	return v.result, err
+}
+
+// ndjson implements ValidateExport for ExportNDJSON
+func (v *exportValidator) ndjson(r io.Reader) error {
+	err := readNDJSON(r, func(record int, block *Block) error {
+		if v.block(record, block) {
+			return errStopStream
+		}
+		return nil
+	})
+	if err != nil && err != errStopStream {
+		// the error of readNDJSON names the record already
+		if inner := errors.Unwrap(err); inner != nil {
+			err = inner
+		}
+		v.fail(v.result.Records+1, v.nextIndex(), fmt.Sprintf("Datensatz nicht lesbar: %v", err))
+	}
+	return nil
+}
+
+// gob implements ValidateExport for ExportGob
+func (v *exportValidator) gob(r io.Reader) error {
+	dec := gob.NewDecoder(bufio.NewReader(r))
+	for record := 1; ; record++ {
+		block := &Block{}
+		if err := dec.Decode(block); err == io.EOF {
+			return nil
+		} else if err != nil {
+			v.fail(record, v.nextIndex(), fmt.Sprintf("Datensatz nicht lesbar: %v", err))
+			return nil
+		}
+		if v.block(record, block) {
+			return nil
+		}
+	}
+}
+
+// nextIndex returns the index the block after the last one read should
+// have, for records that cannot be read
+func (v *exportValidator) nextIndex() int {
+	if v.prev == nil {
+		return 0
+	}
+	return v.prev.Index + 1
+}
+
+// csv implements ValidateExport for ExportCSV
+func (v *exportValidator) csv(r io.Reader) error {
+	reader := csv.NewReader(bufio.NewReader(r))
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrExportUnverifiable, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimPrefix(name, "\ufeff")] = i
+	}
+	positions := make([]int, len(exportColumns)+1)
+	for i, name := range append(slices.Clone(exportColumns), exportChecksumColumn) {
+		pos, ok := columns[name]
+		if !ok {
+			return fmt.Errorf("%w: Spalte %s fehlt", ErrExportUnverifiable, name)
+		}
+		positions[i] = pos
+	}
+
+	var first *Block
+	for record := 1; ; record++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			v.fail(record, v.nextIndex(), "Kopfverweis fehlt, der Export ist unvollständig")
+			return nil
+		}
+		if err != nil {
+			v.fail(record, v.nextIndex(), fmt.Sprintf("Datensatz nicht lesbar: %v", err))
+			return nil
+		}
+		v.result.Records = record
+		if fields[0] == exportHeadMarker {
+			v.csvHead(record, fields, first)
+			if _, err := reader.Read(); err != io.EOF {
+				v.fail(record+1, v.nextIndex(), "Datensatz nach dem Kopfverweis")
+			}
+			return nil
+		}
+		row := make([]string, len(positions))
+		for i, pos := range positions {
+			if pos < len(fields) {
+				row[i] = fields[pos]
+			}
+		}
+		block, stop := v.csvRow(record, row)
+		if first == nil {
+			first = block
+		}
+		if stop {
+			return nil
+		}
+	}
+}
+
+// csvRow checks a block row of ExportCSV whose fields are in
+// exportColumns order followed by the checksum. It returns the block the
+// row describes and whether to stop.
+func (v *exportValidator) csvRow(record int, row []string) (*Block, bool) {
+	index, err := strconv.Atoi(row[0])
+	if err != nil {
+		return nil, v.fail(record, v.nextIndex(), fmt.Sprintf("Ungültiger Index: %s", row[0]))
+	}
+	checksum, err := exportRowChecksum(row[:len(exportColumns)], false)
+	if err != nil {
+		return nil, v.fail(record, index, err.Error())
+	}
+	if checksum != row[len(exportColumns)] && v.fail(record, index, "Prüfsumme passt nicht zur Zeile") {
+		return nil, true
+	}
+	timestamp, err := time.Parse(time.RFC3339, row[1])
+	if err != nil {
+		return nil, v.fail(record, index, fmt.Sprintf("Ungültiger Zeitstempel: %s", row[1]))
+	}
+	block := &Block{Index: index, Timestamp: timestamp, Hash: row[7], PrevHash: row[8]}
+	if v.link(record, block, false) {
+		return block, true
+	}
+	if index == 0 || row[9] == "" {
+		return block, false
+	}
+	parts := strings.Split(row[9], ";")
+	block.Values = make([]float64, len(parts))
+	for i, part := range parts {
+		if block.Values[i], err = strconv.ParseFloat(part, 64); err != nil {
+			return block, v.fail(record, index, fmt.Sprintf("Ungültiger Wert: %s", part))
+		}
+	}
+	calculateBlockStats(block, DefaultOutlierConfig, 0)
+	for i, column := range []string{"Mean", "Median"} {
+		stored, err := strconv.ParseFloat(row[2+i], 64)
+		computed := []float64{block.Mean, block.Median}[i]
+		if err != nil || !statsMatch(stored, computed) {
+			mismatch := &StatsMismatchError{Field: column, Stored: row[2+i], Computed: computed, Diff: stored - computed}
+			return block, v.fail(record, index, mismatch.Error())
+		}
+	}
+	return block, false
+}
+
+// csvHead checks the head reference of ExportCSV against the first and
+// the last block row
+func (v *exportValidator) csvHead(record int, fields []string, first *Block) {
+	if first == nil || v.prev == nil {
+		v.fail(record, 0, "Export enthält keinen Block")
+		return
+	}
+	switch {
+	case len(fields) != 5 || exportHeadChecksum(fields[1], fields[2], fields[3]) != fields[4]:
+		v.fail(record, v.prev.Index, "Kopfverweis ist beschädigt")
+	case fields[1] != strconv.Itoa(first.Index):
+		v.fail(record, first.Index, fmt.Sprintf("Export beginnt laut Kopfverweis bei Block %s", fields[1]))
+	case fields[2] != strconv.Itoa(v.prev.Index) || !strings.EqualFold(fields[3], v.prev.Hash):
+		v.fail(record, v.prev.Index, fmt.Sprintf("Kopf ist laut Kopfverweis Block %s mit Hash %s", fields[2], formatHash(fields[3])))
+	}
+}
+
+// ValidateExportFile is ValidateExport for the file at path, which may be
+// compressed with any codec. An empty format is inferred from the
+// extension.
+func ValidateExportFile(path, format string, keepGoing bool) (ExportValidation, error) {
+	if format == "" {
+		format = formatFromPath(path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return ExportValidation{}, err
+	}
+	defer file.Close()
+	r, err := openCodecStream(file)
+	if err != nil {
+		return ExportValidation{}, err
+	}
+	defer r.Close()
+	return ValidateExport(r, format, keepGoing)
+}
+
+// runValidateExportCommand implements "validate-export [--keep-going]
+// [--format f] <file>": it reports whether the export is consistent and
+// the record of the first inconsistency, exiting with 1 if there is one
+func runValidateExportCommand(args []string) int {
+	fs := flag.NewFlagSet("validate-export", flag.ContinueOnError)
+	keepGoing := fs.Bool("keep-going", false, "Nach der ersten Unstimmigkeit weiterprüfen und alle zählen")
+	format := fs.String("format", "", "Exportformat: ndjson, csv oder gob; ohne Angabe nach der Endung")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Aufruf: validate-export [--keep-going] [--format f] <datei>")
+		return 2
+	}
+
+	v, err := ValidateExportFile(fs.Arg(0), *format, *keepGoing)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%d Datensätze geprüft (%s)\n", v.Records, v.Format)
+	if v.OK() {
+		fmt.Println("Export ist konsistent")
+		return 0
+	}
+	fmt.Println("Erste Unstimmigkeit:", v.First)
+	if *keepGoing {
+		fmt.Printf("%d Unstimmigkeiten\n", v.Failures)
+	}
+	return 1
+}
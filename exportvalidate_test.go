@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validateExportFormats are the formats ValidateExport reads
+var validateExportFormats = []string{"ndjson", "csv", "gob"}
+
+// writeValidateExport exports the demo chain to a new file in format and
+// returns the chain and the path
+func writeValidateExport(t *testing.T, format string) (*Blockchain, string) {
+	t.Helper()
+	bc, err := LoadDemoChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "export."+format)
+	if err := writeExportFile(bc, path, format, nil); err != nil {
+		t.Fatal(err)
+	}
+	return bc, path
+}
+
+// writeBlocks writes blocks to path in format as the export would, for
+// blocks changed after they were added
+func writeBlocks(t *testing.T, path, format string, blocks []*Block) {
+	t.Helper()
+	var buf bytes.Buffer
+	switch format {
+	case "ndjson":
+		if err := writeNDJSON(&buf, blocks, nil); err != nil {
+			t.Fatal(err)
+		}
+	case "gob":
+		enc := gob.NewEncoder(&buf)
+		for _, block := range blocks {
+			if err := enc.Encode(block); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateExportValid(t *testing.T) {
+	for _, format := range validateExportFormats {
+		bc, path := writeValidateExport(t, format)
+		v, err := ValidateExportFile(path, "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records := len(bc.Blocks())
+		if format == "csv" {
+			// the head reference
+			records++
+		}
+		if !v.OK() || v.Records != records || v.Format != format {
+			t.Fatalf("%s export validated as %+v with first failure %v, want %d consistent records", format, v, v.First, records)
+		}
+	}
+}
+
+func TestValidateExportTampered(t *testing.T) {
+	for _, format := range []string{"ndjson", "gob"} {
+		bc, path := writeValidateExport(t, format)
+		blocks := bc.Blocks()
+		// a value changed, and a timestamp moved before its predecessor's
+		changed := *blocks[3]
+		changed.Values = append([]float64{changed.Values[0] + 1}, changed.Values[1:]...)
+		blocks[3] = &changed
+		moved := *blocks[6]
+		moved.Timestamp = blocks[5].Timestamp.Add(-time.Second)
+		blocks[6] = &moved
+		writeBlocks(t, path, format, blocks)
+
+		v, err := ValidateExportFile(path, format, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Failures != 1 || v.First == nil || v.First.Record != 4 || v.First.Index != 3 || v.Records != 4 {
+			t.Fatalf("tampered %s export validated as %+v with first failure %v, want record 4", format, v, v.First)
+		}
+		if !strings.Contains(v.First.Reason, "Hash") {
+			t.Fatalf("first failure of the tampered %s export is %v, want a hash mismatch", format, v.First)
+		}
+
+		// the moved block fails its timestamp and its hash
+		if v, err = ValidateExportFile(path, format, true); err != nil {
+			t.Fatal(err)
+		}
+		if v.Failures != 3 || v.First.Record != 4 || v.Records != len(blocks) {
+			t.Fatalf("tampered %s export validated with --keep-going as %+v, want 3 failures in %d records", format, v, len(blocks))
+		}
+	}
+
+	_, path := writeValidateExport(t, "csv")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(data), "\n")
+	// the mean of block 2 is changed, the checksum of its row is not
+	fields := strings.Split(lines[3], ",")
+	fields[2] = "1" + fields[2]
+	lines[3] = strings.Join(fields, ",")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	v, err := ValidateExportFile(path, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.First == nil || v.First.Record != 3 || v.First.Index != 2 || v.First.Reason != "Prüfsumme passt nicht zur Zeile" || v.Failures != 2 {
+		t.Fatalf("tampered csv export validated as %+v with first failure %v, want a checksum and a mean mismatch of record 3", v, v.First)
+	}
+}
+
+func TestValidateExportTruncated(t *testing.T) {
+	for _, format := range validateExportFormats {
+		bc, path := writeValidateExport(t, format)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data[:len(data)-20], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		v, err := ValidateExportFile(path, format, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the head reference of a csv export is cut, which follows the
+		// head, and the head of the others
+		head := len(bc.Blocks()) - 1
+		record := head + 1
+		if format == "csv" {
+			record++
+		}
+		if v.OK() || v.First.Record != record || v.First.Index != head {
+			t.Fatalf("truncated %s export validated as %+v with first failure %v, want record %d", format, v, v.First, record)
+		}
+	}
+}
+
+func TestValidateExportRejectsUnknownFormat(t *testing.T) {
+	if _, err := ValidateExport(strings.NewReader(""), "xlsx", false); err == nil {
+		t.Fatal("export in xlsx was validated")
+	}
+	if _, err := ValidateExport(strings.NewReader("a,b\n"), "csv", false); err == nil {
+		t.Fatal("csv file without the export columns was validated")
+	}
+}
+
+func TestRunValidateExportCommand(t *testing.T) {
+	_, path := writeValidateExport(t, "ndjson")
+	if code := runValidateExportCommand([]string{path}); code != 0 {
+		t.Fatalf("validate-export of a consistent export exited with %d", code)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runValidateExportCommand([]string{"--keep-going", path}); code != 1 {
+		t.Fatalf("validate-export of a truncated export exited with %d, want 1", code)
+	}
+	if code := runValidateExportCommand(nil); code != 2 {
+		t.Fatalf("validate-export without a file exited with %d, want 2", code)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+)
+
+// defaultChainName is the name of a chain created by NewBlockchain
+const defaultChainName = "main"
+
+// ForkOrigin records where a forked chain branched off
+type ForkOrigin struct {
+	Chain    string `json:"chain"`
+	Index    int    `json:"index"`
+	HeadHash string `json:"head_hash"`
+}
+
+// Name returns the name of the chain
+func (bc *Blockchain) Name() string {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	if bc.name == "" {
+		return defaultChainName
+	}
+	return bc.name
+}
+
+// ForkOrigin returns where the chain was forked from, or nil if it is not
+// a fork
+func (bc *Blockchain) ForkOrigin() *ForkOrigin {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	if bc.fork == nil {
+		return nil
+	}
+	origin := *bc.fork
+	return &origin
+}
+
+// Fork creates a new chain called name holding a copy of the blocks up to
+// and including upToIndex, with the same settings as bc. The blocks are
+// copied in full rather than shared, so both chains can evolve
+// independently from then on. Forks can be forked again.
+func (bc *Blockchain) Fork(name string, upToIndex int) (*Blockchain, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	source := bc.name
+	if source == "" {
+		source = defaultChainName
+	}
+	if name == "" || name == source {
+		return nil, fmt.Errorf("Ungültiger Name für die Abspaltung: %q", name)
+	}
+	end, err := bc.position(upToIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	fork := bc.withSettings()
+	fork.name = name
+	fork.stub = bc.stub
+	fork.checkpoint = bc.checkpoint
+	fork.archive = bc.archive
+	fork.expired = bc.expired
+	fork.genesis = bc.genesis
+	blocks, err := bc.blocks(0, end+1)
+	if err != nil {
+		return nil, err
+	}
+	fork.fork = &ForkOrigin{Chain: source, Index: upToIndex, HeadHash: blocks[end].Hash}
+	copied := make([]*Block, len(blocks))
+	for i, block := range blocks {
+		copied[i] = copyBlock(block)
+	}
+	fork.holdBlocks(copied)
+	fork.memWarned = fork.memLimits.Soft > 0 && fork.memUsage > fork.memLimits.Soft
+	return fork, nil
+}
+
+// withSettings returns an empty chain with the settings of bc, for Fork
+// and Rebuild to fill with blocks. Called with bc.mu held.
+func (bc *Blockchain) withSettings() *Blockchain {
+	return &Blockchain{
+		limits:             bc.limits,
+		bounds:             bc.bounds,
+		rules:              bc.rules,
+		valueKind:          bc.valueKind,
+		idScheme:           bc.idScheme,
+		idIndex:            map[string]int{},
+		hashIndex:          map[string][]hashEntry{},
+		clock:              bc.clock,
+		timestampPolicy:    bc.timestampPolicy,
+		memLimits:          bc.memLimits,
+		trackOrigins:       bc.trackOrigins,
+		sampleSize:         bc.sampleSize,
+		contextWindow:      bc.contextWindow,
+		contextMaxValues:   bc.contextMaxValues,
+		bins:               bc.bins,
+		histogramBuckets:   bc.histogramBuckets,
+		trendFlatThreshold: bc.trendFlatThreshold,
+		anomalyWindow:      bc.anomalyWindow,
+		anomalyThreshold:   bc.anomalyThreshold,
+		dedupWindow:        bc.dedupWindow,
+		valueEncoding:      bc.valueEncoding,
+		signer:             bc.signer,
+		verifyOnRead:       bc.verifyOnRead,
+		codec:              bc.codec,
+		exportOrigins:      bc.exportOrigins,
+		retention:          bc.retention,
+		qualityWeights:     bc.qualityWeights,
+		outliers:           bc.outliers,
+		difficulty:         bc.difficulty,
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestForkIsIndependent(t *testing.T) {
+	bc := newFilledChain(t)
+	if err := bc.SetSampleSize(2); err != nil {
+		t.Fatal(err)
+	}
+	head, err := bc.BlockByIndex(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork, err := bc.Fork("versuch", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin := fork.ForkOrigin()
+	if fork.Name() != "versuch" || origin == nil || *origin != (ForkOrigin{Chain: "main", Index: 2, HeadHash: head.Hash}) {
+		t.Fatalf("fork %s has origin %+v", fork.Name(), origin)
+	}
+	if bc.ForkOrigin() != nil {
+		t.Fatal("the primary chain is marked as a fork")
+	}
+	if fork.LatestBlock().Hash != head.Hash {
+		t.Fatalf("fork ends at block %d, want 2", fork.LatestBlock().Index)
+	}
+
+	// appends to either chain leave the other alone, and the fork keeps
+	// the settings of the chain it came from
+	primaryHead := bc.LatestBlock().Hash
+	block, err := fork.AddBlock([]float64{1, 2, 3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Index != 3 || block.PrevHash != head.Hash || !block.Sampled {
+		t.Fatalf("fork appended %+v", block)
+	}
+	if bc.LatestBlock().Hash != primaryHead {
+		t.Fatal("appending to the fork changed the primary chain")
+	}
+	if _, err := bc.AddBlock([]float64{5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	if fork.LatestBlock().Hash != block.Hash {
+		t.Fatal("appending to the primary chain changed the fork")
+	}
+
+	for _, chain := range []*Blockchain{bc, fork} {
+		if err := chain.Validate(); err != nil {
+			t.Fatalf("%s: %v", chain.Name(), err)
+		}
+	}
+
+	// forks can be forked again
+	again, err := fork.Fork("weiter", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origin := again.ForkOrigin(); origin.Chain != "versuch" || origin.Index != 3 {
+		t.Fatalf("fork of the fork has origin %+v", origin)
+	}
+}
+
+func TestForkRejected(t *testing.T) {
+	bc := newFilledChain(t)
+	for _, tt := range []struct {
+		name  string
+		index int
+	}{
+		{"", 1},
+		{"main", 1},
+		{"versuch", 99},
+		{"versuch", -1},
+	} {
+		if _, err := bc.Fork(tt.name, tt.index); err == nil {
+			t.Fatalf("fork %q at %d accepted", tt.name, tt.index)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sink receives the batches a Generator produces
+type Sink interface {
+	AddBlock(values []float64) error
+}
+
+// MetadataSink is a Sink that can also store metadata with a batch; a
+// Generator running a Profile uses it to tag blocks with their phase
+type MetadataSink interface {
+	Sink
+	AddBlockWithMetadata(values []float64, text string, metadata map[string]string) error
+}
+
+// GeneratorEventKind identifies a GeneratorEvent
+type GeneratorEventKind int
+
+const (
+	// BatchProduced is emitted after a batch was handed to the sink
+	BatchProduced GeneratorEventKind = iota
+	// SinkError is emitted when the sink rejected a batch
+	SinkError
+	// PhaseChanged is emitted when a Profile enters a phase
+	PhaseChanged
+	// ProfileFinished is emitted when a non-looping Profile has ended and
+	// the generator stopped producing batches
+	ProfileFinished
+)
+
+// GeneratorEvent describes something a Generator did
+type GeneratorEvent struct {
+	Kind   GeneratorEventKind
+	Time   time.Time
+	Values int
+	Err    error
+	// Phase is the current profile phase, if a Profile is running
+	Phase string
+}
+
+// GeneratorConfig configures a Generator. Zero fields get defaults.
+type GeneratorConfig struct {
+	Source         ValueSource
+	Interval       time.Duration
+	ValuesPerBlock int
+	Clock          Clock
+	// Profile, if set, replaces Source with a schedule of phases
+	Profile *Profile
+	// OnEvent, if set, is called synchronously for every event
+	OnEvent func(GeneratorEvent)
+}
+
+// ErrGeneratorRunning is returned by Start on a running Generator
+var ErrGeneratorRunning = errors.New("Generator läuft bereits")
+
+// Generator produces synthetic batches at a fixed interval and hands them
+// to a Sink. It is independent of Blockchain and can feed any Sink.
+type Generator struct {
+	sink Sink
+	cfg  GeneratorConfig
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	paused bool
+
+	// produced and failed count the batches the sink took and rejected
+	produced atomic.Int64
+	failed   atomic.Int64
+}
+
+// GeneratorStats counts the batches of a Generator since it was created
+type GeneratorStats struct {
+	Produced int64
+	Failed   int64
+}
+
+// NewGenerator creates a stopped Generator feeding sink
+func NewGenerator(sink Sink, cfg GeneratorConfig) *Generator {
+	if cfg.Source == nil {
+		cfg.Source = UniformSource{}
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if cfg.ValuesPerBlock <= 0 {
+		cfg.ValuesPerBlock = 100
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = realClock{}
+	}
+	return &Generator{sink: sink, cfg: cfg}
+}
+
+// Start begins generation in the background until ctx is cancelled or
+// Stop is called
+func (g *Generator) Start(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.cancel != nil {
+		return ErrGeneratorRunning
+	}
+	if g.cfg.Profile != nil {
+		if err := g.cfg.Profile.Validate(); err != nil {
+			return err
+		}
+	}
+	ctx, g.cancel = context.WithCancel(ctx)
+	g.done = make(chan struct{})
+	go g.run(ctx, g.done)
+	return nil
+}
+
+// Stop halts generation and waits for the current batch to finish
+func (g *Generator) Stop() {
+	g.mu.Lock()
+	cancel, done := g.cancel, g.done
+	g.cancel, g.done = nil, nil
+	g.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Pause suspends generation until Resume; ticks while paused produce
+// nothing. A running Profile keeps advancing through its phases.
+func (g *Generator) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = true
+}
+
+// Resume continues generation after Pause
+func (g *Generator) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = false
+}
+
+// SetSource replaces the source of the values from the next batch on. It
+// has no effect while a Profile is running.
+func (g *Generator) SetSource(source ValueSource) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cfg.Source = source
+}
+
+// Source returns the source of the values
+func (g *Generator) Source() ValueSource {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.cfg.Source
+}
+
+// Stats returns the batch counters of the generator
+func (g *Generator) Stats() GeneratorStats {
+	return GeneratorStats{Produced: g.produced.Load(), Failed: g.failed.Load()}
+}
+
+// Paused reports whether Pause was called without a following Resume
+func (g *Generator) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+func (g *Generator) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	var cursor *profileCursor
+	if g.cfg.Profile != nil {
+		cursor = &profileCursor{profile: g.cfg.Profile, start: g.cfg.Clock.Now()}
+		g.emit(GeneratorEvent{Kind: PhaseChanged, Time: cursor.start, Phase: cursor.phase().Name})
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-g.cfg.Clock.After(g.cfg.Interval):
+		}
+
+		now := g.cfg.Clock.Now()
+		if cursor == nil {
+			if g.Paused() {
+				continue
+			}
+			g.produce(g.Source().Next(g.cfg.ValuesPerBlock), "")
+			continue
+		}
+
+		changed, finished := cursor.advance(now)
+		if finished {
+			g.emit(GeneratorEvent{Kind: ProfileFinished, Time: now})
+			return
+		}
+		phase := cursor.phase()
+		if changed {
+			g.emit(GeneratorEvent{Kind: PhaseChanged, Time: now, Phase: phase.Name})
+		}
+		if g.Paused() {
+			continue
+		}
+		g.produce(phase.values(g.cfg.ValuesPerBlock, now.Sub(cursor.start), g.cfg.Profile.Rand), phase.Name)
+	}
+}
+
+// produce hands one batch to the sink, tagged with phase if it is set
+func (g *Generator) produce(values []float64, phase string) {
+	var err error
+	if sink, ok := g.sink.(MetadataSink); ok && phase != "" {
+		err = sink.AddBlockWithMetadata(values, "", map[string]string{"phase": phase})
+	} else {
+		err = g.sink.AddBlock(values)
+	}
+	if err != nil {
+		g.failed.Add(1)
+		g.emit(GeneratorEvent{Kind: SinkError, Time: g.cfg.Clock.Now(), Values: len(values), Err: err, Phase: phase})
+		return
+	}
+	g.produced.Add(1)
+	g.emit(GeneratorEvent{Kind: BatchProduced, Time: g.cfg.Clock.Now(), Values: len(values), Phase: phase})
+}
+
+func (g *Generator) emit(event GeneratorEvent) {
+	if g.cfg.OnEvent != nil {
+		g.cfg.OnEvent(event)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tickClock is a Clock whose timers fire only when the test calls tick
+type tickClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	timer chan tickTimer
+	// next is a timer settle took from timer before tick fires it
+	next *tickTimer
+}
+
+// tickTimer is a call of After waiting for tick
+type tickTimer struct {
+	d  time.Duration
+	ch chan time.Time
+}
+
+func newTickClock() *tickClock {
+	return &tickClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), timer: make(chan tickTimer, 16)}
+}
+
+func (c *tickClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *tickClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.timer <- tickTimer{d, ch}
+	return ch
+}
+
+// settle waits for the next call of After, so the work the last tick
+// started is done
+func (c *tickClock) settle(t *testing.T) {
+	t.Helper()
+	if c.next != nil {
+		return
+	}
+	select {
+	case timer := <-c.timer:
+		c.next = &timer
+	case <-time.After(5 * time.Second):
+		t.Fatal("generator does not wait for the clock")
+	}
+}
+
+// tick waits for the next call of After, moves the clock on by its
+// duration and fires it
+func (c *tickClock) tick(t *testing.T) {
+	t.Helper()
+	c.settle(t)
+	timer := *c.next
+	c.next = nil
+	c.mu.Lock()
+	c.now = c.now.Add(timer.d)
+	now := c.now
+	c.mu.Unlock()
+	timer.ch <- now
+}
+
+// discard drops the calls of After no tick has fired, as those of a
+// stopped generator
+func (c *tickClock) discard() {
+	c.next = nil
+	for {
+		select {
+		case <-c.timer:
+		default:
+			return
+		}
+	}
+}
+
+// eventLog collects the events of a Generator
+type eventLog struct {
+	mu     sync.Mutex
+	events []GeneratorEvent
+}
+
+func (l *eventLog) add(event GeneratorEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+// count returns the number of events of kind
+func (l *eventLog) count(kind GeneratorEventKind) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, event := range l.events {
+		if event.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// stopWithin calls Stop and fails the test if it does not return within
+// timeout
+func stopWithin(t *testing.T, g *Generator, timeout time.Duration) {
+	t.Helper()
+	stopped := make(chan struct{})
+	go func() {
+		g.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		t.Fatalf("Stop did not return within %v", timeout)
+	}
+}
+
+func TestGeneratorStopWithinInterval(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newTickClock()
+	var events eventLog
+	g := NewGenerator(NewDefaultPipeline(bc).ForSource("generator"), GeneratorConfig{Interval: time.Hour, ValuesPerBlock: 5, Clock: clock, OnEvent: events.add})
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(context.Background()); err != ErrGeneratorRunning {
+		t.Fatalf("second Start returned %v, want ErrGeneratorRunning", err)
+	}
+	clock.tick(t)
+	clock.tick(t)
+
+	// the generator waits for the third interval; Stop must not, and the
+	// clock never reaches its end
+	start := clock.Now()
+	stopWithin(t, g, time.Second)
+	if clock.Now() != start {
+		t.Fatal("Stop waited for the clock")
+	}
+	if produced := events.count(BatchProduced); produced != 2 || bc.Length() != 3 {
+		t.Fatalf("generator produced %d batches into %d blocks, want 2 into 3", produced, bc.Length())
+	}
+	stopWithin(t, g, time.Second)
+
+	// a stopped generator starts again
+	clock.discard()
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	clock.tick(t)
+	stopWithin(t, g, time.Second)
+	if bc.Length() != 4 {
+		t.Fatalf("restarted generator left %d blocks, want 4", bc.Length())
+	}
+}
+
+// blockingSink holds every AddBlock until release is closed
+type blockingSink struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s blockingSink) AddBlock([]float64) error {
+	s.entered <- struct{}{}
+	<-s.release
+	return nil
+}
+
+func TestGeneratorStopWaitsForBatch(t *testing.T) {
+	clock := newTickClock()
+	sink := blockingSink{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	g := NewGenerator(sink, GeneratorConfig{Interval: time.Second, Clock: clock})
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	clock.tick(t)
+	<-sink.entered
+
+	stopped := make(chan struct{})
+	go func() {
+		g.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned while the sink still took a batch")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(sink.release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return after the batch")
+	}
+	if stats := g.Stats(); stats.Produced != 1 {
+		t.Fatalf("generator counted %+v, want 1 batch produced", stats)
+	}
+}
+
+func TestGeneratorStopsWithContext(t *testing.T) {
+	clock := newTickClock()
+	ctx, cancel := context.WithCancel(context.Background())
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGenerator(NewDefaultPipeline(bc).ForSource("generator"), GeneratorConfig{Interval: time.Minute, Clock: clock})
+	if err := g.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	clock.tick(t)
+	cancel()
+	stopWithin(t, g, time.Second)
+	if bc.Length() != 2 {
+		t.Fatalf("chain holds %d blocks, want 2", bc.Length())
+	}
+}
+
+// recordingSink keeps the batches it is given and fails while err is set
+type recordingSink struct {
+	mu       sync.Mutex
+	batches  [][]float64
+	metadata []map[string]string
+	err      error
+}
+
+func (s *recordingSink) AddBlock(values []float64) error {
+	return s.AddBlockWithMetadata(values, "", nil)
+}
+
+func (s *recordingSink) AddBlockWithMetadata(values []float64, _ string, metadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, values)
+	s.metadata = append(s.metadata, metadata)
+	return nil
+}
+
+func (s *recordingSink) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.batches)
+}
+
+func TestGeneratorPauseResume(t *testing.T) {
+	clock := newTickClock()
+	sink := &recordingSink{}
+	g := NewGenerator(sink, GeneratorConfig{Interval: time.Second, ValuesPerBlock: 3, Clock: clock})
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop()
+
+	steps := []struct {
+		action  func()
+		ticks   int
+		paused  bool
+		batches int
+	}{
+		{nil, 2, false, 2},
+		{g.Pause, 3, true, 2},
+		{g.Pause, 1, true, 2},
+		{g.Resume, 1, false, 3},
+		{g.Resume, 2, false, 5},
+	}
+	for i, step := range steps {
+		if step.action != nil {
+			step.action()
+		}
+		for range step.ticks {
+			clock.tick(t)
+		}
+		clock.settle(t)
+		if g.Paused() != step.paused || sink.len() != step.batches {
+			t.Fatalf("step %d: paused %v with %d batches, want %v with %d", i, g.Paused(), sink.len(), step.paused, step.batches)
+		}
+	}
+	if stats := g.Stats(); stats.Produced != 5 || stats.Failed != 0 {
+		t.Fatalf("stats are %+v, want 5 produced", stats)
+	}
+}
+
+func TestGeneratorSink(t *testing.T) {
+	clock := newTickClock()
+	sink := &recordingSink{}
+	var events eventLog
+	g := NewGenerator(sink, GeneratorConfig{Source: NormalSource{Mean: 4}, Interval: time.Second, ValuesPerBlock: 3, Clock: clock, OnEvent: events.add})
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop()
+
+	clock.tick(t)
+	clock.settle(t)
+	sink.mu.Lock()
+	sink.err = errors.New("voll")
+	sink.mu.Unlock()
+	clock.tick(t)
+	clock.tick(t)
+	clock.settle(t)
+
+	if len(sink.batches) != 1 || !slices.Equal(sink.batches[0], []float64{4, 4, 4}) || sink.metadata[0] != nil {
+		t.Fatalf("sink got %v with metadata %v, want one batch of 3 fours", sink.batches, sink.metadata)
+	}
+	if stats := g.Stats(); stats.Produced != 1 || stats.Failed != 2 {
+		t.Fatalf("stats are %+v, want 1 produced and 2 failed", stats)
+	}
+	if events.count(BatchProduced) != 1 || events.count(SinkError) != 2 {
+		t.Fatalf("events are %+v", events.events)
+	}
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	for _, event := range events.events {
+		if event.Kind == SinkError && (event.Err == nil || event.Values != 3 || !event.Time.After(time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC))) {
+			t.Fatalf("sink error event is %+v", event)
+		}
+	}
+}
@@ -2,4 +2,10 @@ module mutex
 
 go 1.22.2
 
-require github.com/mattn/go-sqlite3 v1.14.22 // indirect
+require (
+	github.com/klauspost/compress v1.17.9
+	github.com/mattn/go-sqlite3 v1.14.22
+	golang.org/x/term v0.29.0
+)
+
+require golang.org/x/sys v0.30.0 // indirect
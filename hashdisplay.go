@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HashDisplayMode selects how hashes are shown in listings
+type HashDisplayMode string
+
+const (
+	// HashDisplayFull shows the full 64 character hex hash
+	HashDisplayFull HashDisplayMode = "full"
+	// HashDisplayPrefix shows the first Length hex characters and an ellipsis
+	HashDisplayPrefix HashDisplayMode = "prefix"
+	// HashDisplayBase32 shows the first Length characters of the hash in base32
+	HashDisplayBase32 HashDisplayMode = "base32"
+)
+
+// minHashPrefix is the shortest prefix BlockByHashPrefix accepts; it is
+// also the key length of the prefix index
+const minHashPrefix = 4
+
+// HashDisplay configures how hashes are rendered for humans. Hashes are
+// always stored and exported in full.
+type HashDisplay struct {
+	Mode   HashDisplayMode
+	Length int
+}
+
+var (
+	hashDisplayMu sync.RWMutex
+	hashDisplay   = HashDisplay{Mode: HashDisplayFull}
+)
+
+// SetHashDisplay changes how hashes are rendered in listings and logs
+func SetHashDisplay(display HashDisplay) error {
+	switch display.Mode {
+	case HashDisplayFull:
+	case HashDisplayPrefix, HashDisplayBase32:
+		if display.Length < minHashPrefix {
+			return fmt.Errorf("Hash-Anzeige braucht mindestens %d Zeichen", minHashPrefix)
+		}
+	default:
+		return fmt.Errorf("Unbekannte Hash-Anzeige: %s", display.Mode)
+	}
+
+	hashDisplayMu.Lock()
+	defer hashDisplayMu.Unlock()
+	hashDisplay = display
+	return nil
+}
+
+// formatHash renders hash according to the current HashDisplay
+func formatHash(hash string) string {
+	hashDisplayMu.RLock()
+	display := hashDisplay
+	hashDisplayMu.RUnlock()
+
+	switch display.Mode {
+	case HashDisplayPrefix:
+		if len(hash) > display.Length {
+			return hash[:display.Length] + "…"
+		}
+	case HashDisplayBase32:
+		raw, err := hex.DecodeString(hash)
+		if err != nil {
+			return hash
+		}
+		short := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+		if len(short) > display.Length {
+			return short[:display.Length] + "…"
+		}
+		return short
+	}
+	return hash
+}
+
+// AmbiguousPrefixError is returned when a hash prefix matches several blocks
+type AmbiguousPrefixError struct {
+	Prefix     string
+	Candidates []string
+}
+
+func (e *AmbiguousPrefixError) Error() string {
+	return fmt.Sprintf("Hash-Präfix %s ist mehrdeutig: %s", e.Prefix, strings.Join(e.Candidates, ", "))
+}
+
+// indexHash adds the hash of the block at pos to the prefix index.
+// Called with bc.mu held.
+func (bc *Blockchain) indexHash(hash string, pos int) {
+	if len(hash) < minHashPrefix {
+		return
+	}
+	key := hash[:minHashPrefix]
+	bc.hashIndex[key] = append(bc.hashIndex[key], hashEntry{hash: hash, pos: pos})
+}
+
+// hashEntry is one block in the prefix index
+type hashEntry struct {
+	hash string
+	pos  int
+}
+
+// BlockByHashPrefix returns a copy of the block whose hash starts with
+// prefix. The prefix must have at least four characters and match exactly
+// one block. Blocks are indexed by the hash they were appended with.
+func (bc *Blockchain) BlockByHashPrefix(prefix string) (*Block, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < minHashPrefix {
+		return nil, fmt.Errorf("Hash-Präfix %q ist kürzer als %d Zeichen", prefix, minHashPrefix)
+	}
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var matches []hashEntry
+	for _, entry := range bc.hashIndex[prefix[:minHashPrefix]] {
+		if strings.HasPrefix(entry.hash, prefix) {
+			matches = append(matches, entry)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: Hash-Präfix %s", ErrBlockNotFound, prefix)
+	case 1:
+		return bc.readBlock(matches[0].pos)
+	default:
+		candidates := make([]string, len(matches))
+		for i, m := range matches {
+			candidates[i] = m.hash
+		}
+		sort.Strings(candidates)
+		return nil, &AmbiguousPrefixError{Prefix: prefix, Candidates: candidates}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFormatHash(t *testing.T) {
+	t.Cleanup(func() { SetHashDisplay(HashDisplay{Mode: HashDisplayFull}) })
+	hash := strings.Repeat("ab", 32)
+	tests := []struct {
+		display HashDisplay
+		want    string
+	}{
+		{HashDisplay{Mode: HashDisplayFull}, hash},
+		{HashDisplay{Mode: HashDisplayPrefix, Length: 8}, "abababab…"},
+		{HashDisplay{Mode: HashDisplayPrefix, Length: 64}, hash},
+		{HashDisplay{Mode: HashDisplayBase32, Length: 6}, "VOV2XK…"},
+	}
+	for _, tt := range tests {
+		if err := SetHashDisplay(tt.display); err != nil {
+			t.Fatal(err)
+		}
+		if got := formatHash(hash); got != tt.want {
+			t.Fatalf("%s with %d characters shows %q, want %q", tt.display.Mode, tt.display.Length, got, tt.want)
+		}
+	}
+	// a value that is no hex hash is shown as it is
+	if got := formatHash("genesis"); got != "genesis" {
+		t.Fatalf("base32 display of a non-hex value is %q", got)
+	}
+
+	for _, display := range []HashDisplay{{Mode: HashDisplayPrefix, Length: 3}, {Mode: "emoji"}} {
+		if err := SetHashDisplay(display); err == nil {
+			t.Fatalf("display %+v accepted", display)
+		}
+	}
+}
+
+func TestBlockByHashPrefix(t *testing.T) {
+	bc := newFilledChain(t)
+	block := bc.LatestBlock()
+
+	for _, prefix := range []string{block.Hash, block.Hash[:6], strings.ToUpper(block.Hash[:10])} {
+		found, err := bc.BlockByHashPrefix(prefix)
+		if err != nil || found.Index != block.Index {
+			t.Fatalf("prefix %s found %v, %v, want block %d", prefix, found, err, block.Index)
+		}
+	}
+	if _, err := bc.BlockByHashPrefix(block.Hash[:3]); err == nil {
+		t.Fatal("prefix of 3 characters accepted")
+	}
+	if _, err := bc.BlockByHashPrefix(strings.Repeat("0", 63) + "x"); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("unknown prefix returned %v, want ErrBlockNotFound", err)
+	}
+
+	// a second hash with the same prefix makes the prefix ambiguous but
+	// not the full hash
+	other := block.Hash[:6] + strings.Repeat("f", 58)
+	bc.mu.Lock()
+	bc.indexHash(other, 1)
+	bc.mu.Unlock()
+	_, err := bc.BlockByHashPrefix(block.Hash[:6])
+	var ambiguous *AmbiguousPrefixError
+	if !errors.As(err, &ambiguous) || len(ambiguous.Candidates) != 2 {
+		t.Fatalf("shared prefix returned %v, want both candidates", err)
+	}
+	if found, err := bc.BlockByHashPrefix(block.Hash); err != nil || found.Index != block.Index {
+		t.Fatalf("full hash found %v, %v", found, err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"sort"
+	"time"
+)
+
+// currentHashVersion is the hash version of new blocks: the hash covers a
+// length-prefixed binary encoding of the fields, with floats as their
+// IEEE 754 bits and the value arrays hashed through valuesDigest, so it
+// still verifies once the retention policy dropped them
+const currentHashVersion = 1
+
+// hashDomain starts every canonical payload, so it cannot be mistaken for
+// another structure hashed with SHA-256
+const hashDomain = "block_data_save/block"
+
+// hashEncoder appends the canonical encoding of fields. Integers are
+// 8 bytes big endian, strings and lists are prefixed with their length
+// and optional sections with a presence byte.
+type hashEncoder struct {
+	buf []byte
+}
+
+func (e *hashEncoder) uint(v uint64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, v)
+}
+
+func (e *hashEncoder) int(v int64) {
+	e.uint(uint64(v))
+}
+
+func (e *hashEncoder) float(v float64) {
+	e.uint(math.Float64bits(v))
+}
+
+func (e *hashEncoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *hashEncoder) string(s string) {
+	e.uint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *hashEncoder) bytes(b []byte) {
+	e.uint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *hashEncoder) floats(values []float64) {
+	e.uint(uint64(len(values)))
+	for _, v := range values {
+		e.float(v)
+	}
+}
+
+func (e *hashEncoder) ints(values []int64) {
+	e.uint(uint64(len(values)))
+	for _, v := range values {
+		e.int(v)
+	}
+}
+
+func (e *hashEncoder) times(times []time.Time) {
+	e.uint(uint64(len(times)))
+	for _, t := range times {
+		e.int(t.UnixNano())
+	}
+}
+
+// canonicalHashPayload encodes the fields of a block covered by its hash,
+// except the proof of work
+func canonicalHashPayload(block *Block) []byte {
+	e := &hashEncoder{}
+	e.string(hashDomain)
+	e.uint(uint64(block.HashVersion))
+	e.int(int64(block.Index))
+	e.string(block.ID)
+	e.int(block.Timestamp.UnixNano())
+	e.string(string(block.Kind))
+	e.string(block.PrevHash)
+	e.float(block.Mean)
+	e.float(block.Median)
+	e.float(block.TwoSDLower)
+	e.float(block.TwoSDUpper)
+	e.bool(block.HasOutliers)
+	e.string(block.Text)
+
+	keys := make([]string, 0, len(block.Metadata))
+	for key := range block.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	e.uint(uint64(len(keys)))
+	for _, key := range keys {
+		e.string(key)
+		e.string(block.Metadata[key])
+	}
+
+	e.bool(block.IntStats != nil)
+	if s := block.IntStats; s != nil {
+		e.int(s.Min)
+		e.int(s.Max)
+		e.bool(s.Sum.Sign() < 0)
+		e.bytes(s.Sum.Bytes())
+		e.int(s.Median)
+		e.bool(s.MedianExact)
+	}
+	e.bool(block.Sampled)
+	if block.Sampled {
+		e.int(int64(block.OriginalCount))
+		e.float(block.SampleMin)
+		e.float(block.SampleMax)
+	}
+	e.bool(block.OutlierMethod != "")
+	if block.OutlierMethod != "" {
+		e.string(block.OutlierMethod)
+		e.float(block.LowerBound)
+		e.float(block.UpperBound)
+	}
+
+	percentiles := make([]string, 0, len(block.Percentiles))
+	for key := range block.Percentiles {
+		percentiles = append(percentiles, key)
+	}
+	sort.Strings(percentiles)
+	e.uint(uint64(len(percentiles)))
+	for _, key := range percentiles {
+		e.string(key)
+		e.float(block.Percentiles[key])
+	}
+	e.uint(uint64(len(block.Histogram)))
+	for _, count := range block.Histogram {
+		e.int(int64(count))
+	}
+	e.string(block.MerkleRoot)
+	e.string(string(block.ValueEncoding))
+	e.string(valuesDigest(block))
+	return e.buf
+}
+
+// valuesDigest is the hex SHA-256 of the value arrays of a block: its
+// values, outliers, times and encoded values, in the canonical encoding.
+// A block whose arrays were dropped keeps their digest in Dropped.
+func valuesDigest(block *Block) string {
+	if block.ValuesDropped && block.Dropped != nil {
+		return block.Dropped.Digest
+	}
+	e := &hashEncoder{}
+	e.string(hashDomain + "/values")
+	e.floats(block.Values)
+	e.ints(block.IntValues)
+	e.floats(block.Outliers)
+	e.ints(block.IntOutliers)
+	e.bool(len(block.ValueTimes) > 0)
+	if len(block.ValueTimes) > 0 {
+		e.times(block.ValueTimes)
+	}
+	e.bytes(block.EncodedValues)
+	sum := sha256.Sum256(e.buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalProofOfWork encodes the difficulty of a mined block, to be
+// followed by its nonce as 8 bytes big endian
+func canonicalProofOfWork(difficulty int) []byte {
+	e := &hashEncoder{}
+	e.bool(difficulty > 0)
+	if difficulty > 0 {
+		e.int(int64(difficulty))
+	}
+	return e.buf
+}
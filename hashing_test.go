@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// hashVector is a fixed block and its documented digest
+type hashVector struct {
+	name  string
+	block Block
+	hash  string
+}
+
+// hashVectors pin the hash encoding: every block must keep hashing to its
+// digest, so a change to the encoding fails the tests
+func hashVectors() []hashVector {
+	timestamp := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	float := Block{
+		Index:       1,
+		ID:          "01HN0000000000000000000000",
+		Timestamp:   timestamp,
+		Values:      []float64{1.5, 2.5, 100},
+		PrevHash:    "0f1e2d3c",
+		Mean:        34.666666666666664,
+		Median:      2.5,
+		TwoSDLower:  -58.5,
+		TwoSDUpper:  127.8,
+		Text:        "Messung",
+		Metadata:    map[string]string{"unit": "C", "source": "import"},
+		Kind:        KindFloat,
+		HashVersion: currentHashVersion,
+	}
+	mined := float
+	mined.Difficulty, mined.Nonce = 2, 165
+	summary := float
+	summary.Percentiles = map[string]float64{"p5": 1.6, "p25": 2, "p75": 51.25, "p95": 90.24999999999999, "p99": 98.05}
+	summary.Histogram = []int{2, 0, 0, 1}
+	summary.MerkleRoot = merkleRoot(summary.Values)
+	timed := summary
+	timed.ValueTimes = []time.Time{timestamp.Add(-3 * time.Second), timestamp.Add(-time.Second), timestamp.Add(-time.Second)}
+	encoded := summary
+	encodeBlockValues(&encoded, EncodingDelta)
+	integer := Block{
+		Index:       2,
+		ID:          "01HN0000000000000000000001",
+		Timestamp:   timestamp,
+		PrevHash:    "0f1e2d3c",
+		Kind:        KindInt,
+		IntValues:   []int64{-3, 7, 1 << 40},
+		IntStats:    &IntStats{Min: -3, Max: 1 << 40, Sum: big.NewInt(1<<40 + 4), Median: 7, MedianExact: true},
+		Mean:        366503875925.3333,
+		Median:      7,
+		HashVersion: currentHashVersion,
+	}
+
+	return []hashVector{
+		{"float", float, "adb3c4ad7d463a613ea865fc73a9b8f216d552fa9d4c33ec97fa84a841f3ee52"},
+		{"mined", mined, "00bdc79568b9c68a4fe986f470cc31e70896ba157264bbaf6cceeb02d4875b24"},
+		{"summary", summary, "610838d1bbeb2a9c48b549f4f4d12ba49e79d03b629494daf36b825a31c831e9"},
+		{"timed", timed, "e026d573695f6e6720e5b4f1d2a17af0df28f872888540df9482b4d2135b601e"},
+		{"encoded", encoded, "88157780a34a55f1f1bad2e4c443dbb0203c3daa68742f4d46ae4f56c31b6698"},
+		{"int", integer, "4df600aef67f5835db5fdda62e275f63a0ecd9b988b2357b7f1cb2e0114e0e02"},
+	}
+}
+
+func TestHashVectors(t *testing.T) {
+	for _, vector := range hashVectors() {
+		if hash := calculateHash(&vector.block); hash != vector.hash {
+			t.Errorf("%s block hashes to %s, want %s", vector.name, hash, vector.hash)
+		}
+	}
+}
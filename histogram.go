@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+)
+
+// ErrHistogramMismatch is returned by MergedHistogram when the blocks of a
+// range were binned differently or not at all
+var ErrHistogramMismatch = errors.New("Histogramme sind nicht kombinierbar")
+
+// HistogramBins configures the fixed bins stored on new blocks: Count
+// equal-width bins spanning [Min, Max)
+type HistogramBins struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+// Validate checks that the bins are usable
+func (b HistogramBins) Validate() error {
+	if b.Count <= 0 {
+		return fmt.Errorf("Ungültige Anzahl Klassen: %d", b.Count)
+	}
+	if math.IsNaN(b.Min) || math.IsNaN(b.Max) || math.IsInf(b.Min, 0) || math.IsInf(b.Max, 0) || b.Min >= b.Max {
+		return fmt.Errorf("Ungültiger Histogrammbereich: %v bis %v", b.Min, b.Max)
+	}
+	return nil
+}
+
+func (b HistogramBins) String() string {
+	return fmt.Sprintf("%d Klassen [%v, %v)", b.Count, b.Min, b.Max)
+}
+
+// BinnedValues is a fixed-bin histogram of a block's values. Histograms
+// with the same Bins merge by adding their counts.
+type BinnedValues struct {
+	Bins   HistogramBins `json:"bins"`
+	Counts []uint64      `json:"counts"`
+	// Under and Over count values below Min and at or above Max
+	Under uint64 `json:"under"`
+	Over  uint64 `json:"over"`
+}
+
+// SetHistogramBins makes new blocks store a histogram of their values
+// binned as bins, nil disables it. Blocks record the bins they were
+// created with, so ranges spanning a change refuse to merge.
+func (bc *Blockchain) SetHistogramBins(bins *HistogramBins) error {
+	if bins != nil {
+		if err := bins.Validate(); err != nil {
+			return err
+		}
+		copied := *bins
+		bins = &copied
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	log.Printf("Histogrammklassen geändert: %s -> %s", formatBins(bc.bins), formatBins(bins))
+	bc.bins = bins
+	return nil
+}
+
+// binValues returns the histogram of values over bins
+func binValues(bins HistogramBins, values []float64) *BinnedValues {
+	binned := &BinnedValues{Bins: bins, Counts: make([]uint64, bins.Count)}
+	width := (bins.Max - bins.Min) / float64(bins.Count)
+	for _, value := range values {
+		switch {
+		case value < bins.Min:
+			binned.Under++
+		case value >= bins.Max:
+			binned.Over++
+		default:
+			bin := min(int((value-bins.Min)/width), bins.Count-1)
+			binned.Counts[bin]++
+		}
+	}
+	return binned
+}
+
+// add merges other into h; both must have the same bins
+func (h *BinnedValues) add(other *BinnedValues) {
+	for i, count := range other.Counts {
+		h.Counts[i] += count
+	}
+	h.Under += other.Under
+	h.Over += other.Over
+}
+
+// MergedHistogram returns the combined histogram of the blocks from index
+// from to index to, inclusive, without touching their values. Genesis and
+// a checkpoint record are skipped. Every other block must have been
+// binned with the same bins.
+func (bc *Blockchain) MergedHistogram(from, to int) (*BinnedValues, error) {
+	if from > to {
+		return nil, fmt.Errorf("Ungültiger Bereich: %d bis %d", from, to)
+	}
+	bc.mu.RLock()
+	start, err := bc.position(from)
+	if err == nil {
+		_, err = bc.position(to)
+	}
+	if err != nil {
+		bc.mu.RUnlock()
+		return nil, err
+	}
+	if start == 0 && (bc.base == 0 || bc.stub) {
+		start, from = 1, from+1
+	}
+	blocks, err := bc.blocks(start, start+to-from+1)
+	bc.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var merged *BinnedValues
+	for _, block := range blocks {
+		if block.Binned == nil {
+			return nil, fmt.Errorf("%w: Block %d hat kein Histogramm", ErrHistogramMismatch, block.Index)
+		}
+		if merged == nil {
+			merged = &BinnedValues{Bins: block.Binned.Bins, Counts: make([]uint64, block.Binned.Bins.Count)}
+		} else if block.Binned.Bins != merged.Bins {
+			return nil, fmt.Errorf("%w: Block %d hat %s statt %s", ErrHistogramMismatch, block.Index, block.Binned.Bins, merged.Bins)
+		}
+		merged.add(block.Binned)
+	}
+	if merged == nil {
+		return nil, fmt.Errorf("%w: keine Blöcke im Bereich %d bis %d", ErrHistogramMismatch, from, to)
+	}
+	return merged, nil
+}
+
+func formatBins(bins *HistogramBins) string {
+	if bins == nil {
+		return "keine"
+	}
+	return bins.String()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// IDScheme selects how globally unique block IDs are generated
+type IDScheme string
+
+const (
+	// IDSchemeULID generates 26 character ULIDs
+	IDSchemeULID IDScheme = "ulid"
+	// IDSchemeUUIDv7 generates time-ordered RFC 9562 UUIDs
+	IDSchemeUUIDv7 IDScheme = "uuidv7"
+)
+
+// ErrBlockNotFound is returned by lookups that match no block
+var ErrBlockNotFound = errors.New("Block nicht gefunden")
+
+// crockford is the base32 alphabet used by ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newBlockID returns a new ID for a block created at t, with the random
+// bits read from entropy
+func newBlockID(scheme IDScheme, t time.Time, entropy io.Reader) (string, error) {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(t.UnixMilli())<<16)
+	if _, err := io.ReadFull(entropy, id[6:]); err != nil {
+		return "", err
+	}
+
+	switch scheme {
+	case IDSchemeULID:
+		return encodeULID(id), nil
+	case IDSchemeUUIDv7:
+		id[6] = id[6]&0x0f | 0x70
+		id[8] = id[8]&0x3f | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16]), nil
+	default:
+		return "", fmt.Errorf("Unbekanntes ID-Schema: %s", scheme)
+	}
+}
+
+// encodeULID renders 128 bits as 26 Crockford base32 characters
+func encodeULID(id [16]byte) string {
+	var out [26]byte
+	for i := range out {
+		// the 130 bit output has two leading zero bits
+		val := 0
+		for bit := i*5 - 2; bit < i*5+3; bit++ {
+			val <<= 1
+			if bit >= 0 {
+				val |= int(id[bit/8]>>(7-bit%8)) & 1
+			}
+		}
+		out[i] = crockford[val]
+	}
+	return string(out[:])
+}
+
+// SetIDScheme selects the scheme used for the IDs of new blocks
+func (bc *Blockchain) SetIDScheme(scheme IDScheme) error {
+	if _, err := newBlockID(scheme, time.Now(), rand.Reader); err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.idScheme = scheme
+	return nil
+}
+
+// BlockByID returns a copy of the block with the given ID
+func (bc *Blockchain) BlockByID(id string) (*Block, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	pos, ok := bc.idIndex[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: ID %s", ErrBlockNotFound, id)
+	}
+	return bc.readBlock(pos)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+var (
+	ulidPattern   = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	uuidv7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+)
+
+func TestBlockIDsAreUniqueUnderConcurrentAdds(t *testing.T) {
+	for _, tc := range []struct {
+		scheme  IDScheme
+		pattern *regexp.Regexp
+	}{
+		{IDSchemeULID, ulidPattern},
+		{IDSchemeUUIDv7, uuidv7Pattern},
+	} {
+		t.Run(string(tc.scheme), func(t *testing.T) {
+			bc, err := NewBlockchain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := bc.SetIDScheme(tc.scheme); err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 25; i++ {
+						if _, err := bc.AddBlock([]float64{float64(w), float64(i), 1}); err != nil {
+							t.Error(err)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			seen := map[string]bool{}
+			for _, block := range bc.snapshot()[1:] {
+				if !tc.pattern.MatchString(block.ID) {
+					t.Fatalf("block %d has ID %q, not a %s", block.Index, block.ID, tc.scheme)
+				}
+				if seen[block.ID] {
+					t.Fatalf("ID %s is used twice", block.ID)
+				}
+				seen[block.ID] = true
+
+				found, err := bc.BlockByID(block.ID)
+				if err != nil || found.Index != block.Index {
+					t.Fatalf("BlockByID(%s) returned %v, %v, want block %d", block.ID, found, err, block.Index)
+				}
+			}
+			if len(seen) != 200 {
+				t.Fatalf("chain holds %d IDs, want 200", len(seen))
+			}
+		})
+	}
+}
+
+func TestBlockByIDUnknown(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetIDScheme("serial"); err == nil {
+		t.Fatal("SetIDScheme accepted an unknown scheme")
+	}
+	if _, err := bc.BlockByID("01ARZ3NDEKTSV4RRFFQ69G5FAV"); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("BlockByID of an unknown ID returned %v, want ErrBlockNotFound", err)
+	}
+}
+
+func TestBlockIDsSurviveExport(t *testing.T) {
+	bc := newFilledChain(t)
+	path := filepath.Join(t.TempDir(), "chain.json")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.ExportJSON(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadBlockchainFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range bc.snapshot() {
+		found, err := loaded.BlockByID(block.ID)
+		if err != nil || found.Index != block.Index || found.Hash != block.Hash {
+			t.Fatalf("loaded chain returned %v, %v for the ID of block %d", found, err, block.Index)
+		}
+	}
+}
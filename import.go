@@ -0,0 +1,379 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// sourceRow is one row read from an import file. Line counts from 1: the
+// line of a CSV file or the position in a JSON file.
+type sourceRow struct {
+	Line   int
+	Values []float64
+	// Time is the time of the value of a timed CSV row
+	Time time.Time
+	Err  error
+	// Warnings name the cells of an xlsx row that were skipped
+	Warnings []string
+}
+
+// isBlankRecord reports whether every cell of a CSV record is blank
+func isBlankRecord(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// RowError is a row of an import file that did not become a block
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("Zeile %d: %v", e.Line, e.Err)
+}
+
+func (e RowError) Unwrap() error {
+	return e.Err
+}
+
+// RowErrors is returned by ImportFromFile when rows failed
+type RowErrors []RowError
+
+func (e RowErrors) Error() string {
+	switch len(e) {
+	case 0:
+		return "keine Zeilen fehlgeschlagen"
+	case 1:
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d Zeilen fehlgeschlagen, zuerst %v", len(e), e[0])
+}
+
+// FileImport is the outcome of importing one file
+type FileImport struct {
+	File       string
+	Rows       int
+	Blocks     int
+	Failures   int
+	FirstIndex int
+	LastIndex  int
+	// Samples counts the rows added to the timed blocks of a timed import
+	Samples int
+	// Empty lists the lines of rows without values, which are skipped
+	Empty []int
+	// Warnings name the cells of an xlsx file that were skipped, such as
+	// text and formulas
+	Warnings []string
+	// RowErrors lists the rows that could not be parsed or added
+	RowErrors RowErrors
+	// Err is set when the file could not be read
+	Err error
+}
+
+// ImportReport is the combined outcome of ImportGlob
+type ImportReport struct {
+	// Preset is the name of the preset the options came from, if any
+	Preset string
+	Files  []FileImport
+}
+
+// ImportOptions controls how files are imported
+type ImportOptions struct {
+	// Format is csv, tsv, json or xlsx; empty infers it from each file's
+	// extension
+	Format string `json:"format,omitempty"`
+	// Delimiter is the CSV field separator; empty detects , or ;, or is a
+	// tab for tsv
+	Delimiter string `json:"delimiter,omitempty"`
+	// Header skips the first CSV row, which names the columns
+	Header bool `json:"header,omitempty"`
+	// Columns selects columns by number, counted from 1; empty keeps all
+	Columns []int `json:"columns,omitempty"`
+	// ColumnNames selects CSV columns by their header name instead
+	ColumnNames []string `json:"column_names,omitempty"`
+	// Locale is the number format; empty uses the current locale
+	Locale NumberLocale `json:"locale,omitempty"`
+	// Unit, if set, is recorded in the metadata of every block
+	Unit     string `json:"unit,omitempty"`
+	FailFast bool   `json:"fail_fast,omitempty"`
+	// Timed reads CSV rows of a time, RFC 3339 or unix seconds, and a
+	// value. The samples of a file become timed blocks, see
+	// AddTimedValues, of SamplesPerBlock rows each; 0 puts all rows of a
+	// file in one block.
+	Timed           bool `json:"timed,omitempty"`
+	SamplesPerBlock int  `json:"samples_per_block,omitempty"`
+}
+
+// Validate checks the options before they are used or saved
+func (o ImportOptions) Validate() error {
+	switch o.Format {
+	case "", "csv", "tsv", "json", "xlsx":
+	default:
+		return fmt.Errorf("Ungültiges Dateiformat: %s", o.Format)
+	}
+	if utf8.RuneCountInString(o.Delimiter) > 1 || o.Delimiter == "\n" || o.Delimiter == "\"" {
+		return fmt.Errorf("Ungültiges Trennzeichen: %q", o.Delimiter)
+	}
+	for _, col := range o.Columns {
+		if col < 1 {
+			return fmt.Errorf("Ungültige Spalte: %d", col)
+		}
+	}
+	if len(o.ColumnNames) > 0 && !o.Header {
+		return fmt.Errorf("Spaltennamen setzen eine Kopfzeile voraus")
+	}
+	if len(o.ColumnNames) > 0 && len(o.Columns) > 0 {
+		return fmt.Errorf("Spalten können nur nach Nummer oder nach Name gewählt werden")
+	}
+	switch o.Locale {
+	case "", LocaleAuto, LocaleGerman, LocaleEnglish:
+	default:
+		return fmt.Errorf("Unbekanntes Zahlenformat: %s", o.Locale)
+	}
+	if o.Timed && (o.Format == "json" || o.Format == "xlsx") {
+		return fmt.Errorf("Zeitpunkte gibt es nur für CSV-Dateien")
+	}
+	if o.SamplesPerBlock < 0 || o.SamplesPerBlock > 0 && !o.Timed {
+		return fmt.Errorf("Ungültige Anzahl Werte je Block: %d", o.SamplesPerBlock)
+	}
+	return nil
+}
+
+// Blocks returns the number of blocks added over all files
+func (r *ImportReport) Blocks() int {
+	total := 0
+	for _, f := range r.Files {
+		total += f.Blocks
+	}
+	return total
+}
+
+// Failed returns the number of files that could not be imported completely
+func (r *ImportReport) Failed() int {
+	failed := 0
+	for _, f := range r.Files {
+		if f.Err != nil || f.Failures > 0 {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Print writes a human readable summary of the report to w
+func (r *ImportReport) Print(w io.Writer) {
+	for _, f := range r.Files {
+		switch {
+		case f.Err != nil && f.Blocks == 0:
+			fmt.Fprintf(w, "%s: Fehler: %v\n", f.File, f.Err)
+			continue
+		case f.Err != nil:
+			fmt.Fprintf(w, "%s: Blöcke %d..%d importiert, dann Fehler: %v\n", f.File, f.FirstIndex, f.LastIndex, f.Err)
+		case f.Samples > 0:
+			fmt.Fprintf(w, "%s: %d Werte in Blöcke %d..%d importiert, %d leere Zeilen übersprungen, %d fehlgeschlagen\n", f.File, f.Samples, f.FirstIndex, f.LastIndex, len(f.Empty), f.Failures)
+		case f.Blocks == 0:
+			fmt.Fprintf(w, "%s: %d Zeilen, keine Blöcke hinzugefügt, %d leere Zeilen übersprungen, %d fehlgeschlagen\n", f.File, f.Rows, len(f.Empty), f.Failures)
+		default:
+			fmt.Fprintf(w, "%s: %d Zeilen in Blöcke %d..%d importiert, %d leere Zeilen übersprungen, %d fehlgeschlagen\n", f.File, f.Blocks, f.FirstIndex, f.LastIndex, len(f.Empty), f.Failures)
+		}
+		if len(f.Empty) > 0 {
+			fmt.Fprintf(w, "  Leere Zeilen: %s\n", strings.Trim(fmt.Sprint(f.Empty), "[]"))
+		}
+		for _, warning := range f.Warnings {
+			fmt.Fprintf(w, "  Warnung: %s\n", warning)
+		}
+		for _, rowErr := range f.RowErrors {
+			fmt.Fprintf(w, "  %v\n", rowErr)
+		}
+	}
+	if r.Preset != "" {
+		fmt.Fprintf(w, "Vorlage: %s\n", r.Preset)
+	}
+	fmt.Fprintf(w, "%d Dateien, %d Blöcke hinzugefügt, %d Dateien mit Fehlern\n", len(r.Files), r.Blocks(), r.Failed())
+}
+
+// ImportGlob imports every file matching pattern in lexical order, one
+// block per row, tagging each block with its source file in metadata.
+// A failing file does not stop the remaining ones unless opts.FailFast
+// is set.
+func (p *Pipeline) ImportGlob(pattern string, opts ImportOptions) (*ImportReport, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("Keine Dateien gefunden für %s", pattern)
+	}
+	sort.Strings(files)
+
+	report := &ImportReport{}
+	for _, file := range files {
+		result := p.importFile(file, opts)
+		report.Files = append(report.Files, result)
+		if opts.FailFast && (result.Err != nil || result.Failures > 0) {
+			break
+		}
+	}
+	return report, nil
+}
+
+// importFile imports the rows of a single file
+func (p *Pipeline) importFile(file string, opts ImportOptions) FileImport {
+	result := FileImport{File: file}
+	format := opts.Format
+	if format == "" {
+		format = formatFromPath(file)
+	}
+	locale := opts.Locale
+	if locale == "" {
+		locale = currentNumberLocale()
+	}
+	var delimiter rune
+	if opts.Delimiter != "" {
+		delimiter, _ = utf8.DecodeRuneInString(opts.Delimiter)
+	}
+
+	name := filepath.Base(file)
+	submit := func(values []float64, times []time.Time, origins []Origin) error {
+		metadata := map[string]string{"source_file": name}
+		if opts.Unit != "" {
+			metadata["unit"] = opts.Unit
+		}
+		batch := &Batch{Source: "import", Values: values, Times: times, Origins: origins, Metadata: metadata}
+		if err := p.Submit(batch); err != nil {
+			return err
+		}
+		if result.Blocks == 0 {
+			result.FirstIndex = batch.Index
+		}
+		result.LastIndex = batch.Index
+		result.Blocks++
+		return nil
+	}
+	// a failed block of samples is reported at the line of its last row
+	var samples []Sample
+	var sampleOrigins []Origin
+	var stopped bool
+	fail := func(line int, err error) error {
+		result.Failures++
+		result.RowErrors = append(result.RowErrors, RowError{Line: line, Err: err})
+		if opts.FailFast {
+			stopped = true
+			return errStopStream
+		}
+		return nil
+	}
+	flush := func(line int) error {
+		sortSamples(samples, sampleOrigins)
+		values, times, err := splitSamples(samples)
+		origins := sampleOrigins
+		samples, sampleOrigins = samples[:0], nil
+		if err == nil {
+			err = submit(values, times, origins)
+		}
+		if err != nil {
+			return fail(line, err)
+		}
+		result.Samples += len(values)
+		return nil
+	}
+
+	csvOpts := CSVImportOptions{HasHeader: opts.Header, Columns: opts.ColumnNames, Delimiter: delimiter, Timed: opts.Timed}
+	lastLine := 0
+	result.Err = streamSourceRows(file, format, locale, csvOpts, opts.Columns, func(row sourceRow) error {
+		result.Rows++
+		result.Warnings = append(result.Warnings, row.Warnings...)
+		values, err := row.Values, row.Err
+		if err == nil && len(values) == 0 {
+			result.Empty = append(result.Empty, row.Line)
+			return nil
+		}
+		if err != nil {
+			return fail(row.Line, err)
+		}
+		if !opts.Timed {
+			if err := submit(values, nil, importOrigins(name, row.Line, len(values))); err != nil {
+				return fail(row.Line, err)
+			}
+			return nil
+		}
+		samples = append(samples, Sample{Time: row.Time, Value: values[0]})
+		sampleOrigins = append(sampleOrigins, Origin{Source: name, Row: row.Line})
+		lastLine = row.Line
+		if len(samples) == opts.SamplesPerBlock {
+			return flush(row.Line)
+		}
+		return nil
+	})
+	if result.Err == nil && !stopped && len(samples) > 0 {
+		flush(lastLine)
+	}
+	return result
+}
+
+// ImportFromFile adds every row of a csv, tsv, json or xlsx file to bc as its own
+// block, in the current number format. Empty rows are skipped. Rows that
+// are not numbers or are rejected are skipped too and returned as
+// RowErrors. If the file cannot be read to its end, the error is returned
+// with the number of blocks added before it.
+func (bc *Blockchain) ImportFromFile(path, format string) (added int, err error) {
+	pipeline := NewPipeline(ValidateStage{}, AppendStage{Chain: bc})
+	result := pipeline.importFile(path, ImportOptions{Format: format})
+	if result.Err != nil {
+		return result.Blocks, result.Err
+	}
+	if len(result.RowErrors) > 0 {
+		return result.Blocks, result.RowErrors
+	}
+	return result.Blocks, nil
+}
+
+// selectColumns keeps the given columns, counted from 1, of a row
+func selectColumns(row []float64, columns []int) ([]float64, error) {
+	selected := make([]float64, 0, len(columns))
+	for _, col := range columns {
+		if col > len(row) {
+			return nil, fmt.Errorf("keine Spalte %d", col)
+		}
+		selected = append(selected, row[col-1])
+	}
+	return selected, nil
+}
+
+// formatFromPath infers the import format from a file's extension
+func formatFromPath(path string) string {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if format == "tab" {
+		return "tsv"
+	}
+	return format
+}
+
+// sortSamples sorts samples by time, stably as splitSamples does, and
+// origins with them
+func sortSamples(samples []Sample, origins []Origin) {
+	order := make([]int, len(samples))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return samples[a].Time.Compare(samples[b].Time) })
+	sortedSamples, sortedOrigins := make([]Sample, len(samples)), make([]Origin, len(origins))
+	for i, j := range order {
+		sortedSamples[i], sortedOrigins[i] = samples[j], origins[j]
+	}
+	copy(samples, sortedSamples)
+	copy(origins, sortedOrigins)
+}
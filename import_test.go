@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeImportFiles writes files, by name, to a new directory and returns it
+func writeImportFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestImportGlob(t *testing.T) {
+	dir := writeImportFiles(t, map[string]string{
+		"b.csv":    "1.5,2.5\nviel\n ,\n3\n",
+		"a.csv":    "1,2,3\n4,5,6\n",
+		"c.json":   `[[7, 8], "x", [9]]`,
+		"d.tab":    "10\t11\n",
+		"notes.md": "1,2\n",
+	})
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewDefaultPipeline(bc).ImportGlob(filepath.Join(dir, "*.[cjt]*"), ImportOptions{Locale: LocaleEnglish, Unit: "°C"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		file     string
+		rows     int
+		blocks   int
+		failures int
+		first    int
+		last     int
+		empty    []int
+	}{
+		{"a.csv", 2, 2, 0, 1, 2, nil},
+		{"b.csv", 4, 2, 1, 3, 4, []int{3}},
+		{"c.json", 3, 2, 1, 5, 6, nil},
+		{"d.tab", 1, 1, 0, 7, 7, nil},
+	}
+	if len(report.Files) != len(want) {
+		t.Fatalf("report has %d files, want %d", len(report.Files), len(want))
+	}
+	for i, w := range want {
+		f := report.Files[i]
+		if filepath.Base(f.File) != w.file || f.Rows != w.rows || f.Blocks != w.blocks || f.Failures != w.failures ||
+			f.FirstIndex != w.first || f.LastIndex != w.last || !slices.Equal(f.Empty, w.empty) || f.Err != nil {
+			t.Fatalf("file %d is %+v, want %+v", i, f, w)
+		}
+	}
+	if report.Blocks() != 7 || report.Failed() != 2 {
+		t.Fatalf("report counts %d blocks and %d failed files", report.Blocks(), report.Failed())
+	}
+
+	// every block is tagged with the file it came from
+	for _, block := range bc.Blocks()[1:] {
+		file := report.Files[0].File
+		for _, f := range report.Files {
+			if block.Index >= f.FirstIndex && block.Index <= f.LastIndex {
+				file = f.File
+			}
+		}
+		if block.Metadata["source_file"] != filepath.Base(file) || block.Metadata["unit"] != "°C" {
+			t.Fatalf("block %d has metadata %v, want %s", block.Index, block.Metadata, filepath.Base(file))
+		}
+	}
+
+	var out strings.Builder
+	report.Print(&out)
+	for _, line := range []string{"b.csv: 2 Zeilen in Blöcke 3..4 importiert, 1 leere Zeilen übersprungen, 1 fehlgeschlagen", "Leere Zeilen: 3", "Zeile 2:", "4 Dateien, 7 Blöcke hinzugefügt, 2 Dateien mit Fehlern"} {
+		if !strings.Contains(out.String(), line) {
+			t.Fatalf("report does not contain %q:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestImportGlobFailFast(t *testing.T) {
+	dir := writeImportFiles(t, map[string]string{
+		"a.csv": "1\nx\n2\n",
+		"b.csv": "3\n",
+	})
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewDefaultPipeline(bc).ImportGlob(filepath.Join(dir, "*.csv"), ImportOptions{FailFast: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 1 || report.Files[0].Blocks != 1 || report.Files[0].Failures != 1 || bc.LatestBlock().Index != 1 {
+		t.Fatalf("fail-fast import reported %+v", report.Files)
+	}
+
+	if _, err := NewDefaultPipeline(bc).ImportGlob(filepath.Join(dir, "*.xml"), ImportOptions{}); err == nil {
+		t.Fatal("pattern without files accepted")
+	}
+	if _, err := NewDefaultPipeline(bc).ImportGlob(filepath.Join(dir, "*.csv"), ImportOptions{Format: "ods"}); err == nil {
+		t.Fatal("unknown format accepted")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+var (
+	// ErrQueueFull is returned for a batch rejected by OverflowDropNewest
+	ErrQueueFull = errors.New("Eingangswarteschlange ist voll")
+	// ErrBatchDropped is returned for a queued batch that
+	// OverflowDropOldest evicted to make room
+	ErrBatchDropped = errors.New("Batch wurde aus der Eingangswarteschlange verdrängt")
+	// ErrQueueClosed is returned for batches after IngestQueue.Close
+	ErrQueueClosed = errors.New("Eingangswarteschlange wurde geschlossen")
+)
+
+// OverflowPolicy selects what an IngestQueue does with a batch when it is
+// full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock makes the producer wait until there is room
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropNewest rejects the new batch with ErrQueueFull
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// OverflowDropOldest evicts the oldest queued batch to make room
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+)
+
+// Validate checks that the policy is known
+func (p OverflowPolicy) Validate() error {
+	switch p {
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest:
+		return nil
+	}
+	return fmt.Errorf("Unbekannte Überlaufregel: %s", p)
+}
+
+// IngestStats describes an IngestQueue
+type IngestStats struct {
+	Capacity int
+	Policy   OverflowPolicy
+	// Depth is the number of batches waiting, MaxDepth the most that ever
+	// did
+	Depth    int
+	MaxDepth int
+
+	// Accepted counts the batches taken into the queue and Blocked the
+	// times a producer had to wait for room first
+	Accepted int
+	Blocked  int
+	// DroppedNewest counts the batches rejected because the queue was
+	// full, DroppedOldest the queued ones evicted for newer ones
+	DroppedNewest int
+	DroppedOldest int
+
+	// Processed counts the batches that went through every stage of the
+	// pipeline, Failed those a stage rejected
+	Processed int
+	Failed    int
+}
+
+// Dropped returns the number of batches lost to the overflow policy
+func (s IngestStats) Dropped() int {
+	return s.DroppedNewest + s.DroppedOldest
+}
+
+func (s IngestStats) String() string {
+	return fmt.Sprintf("Eingangswarteschlange: %d von %d belegt (höchstens %d), Regel %s\n"+
+		"Angenommen: %d, davon %d nach Warten\n"+
+		"Verworfen: %d (%d neue, %d alte)\n"+
+		"Verarbeitet: %d, abgelehnt: %d",
+		s.Depth, s.Capacity, s.MaxDepth, s.Policy,
+		s.Accepted, s.Blocked,
+		s.Dropped(), s.DroppedNewest, s.DroppedOldest,
+		s.Processed, s.Failed)
+}
+
+// queuedBatch is a batch waiting in an IngestQueue. done is nil for a
+// producer that does not wait for the result.
+type queuedBatch struct {
+	batch *Batch
+	done  chan error
+}
+
+// IngestQueue bounds the batches waiting for a Pipeline. Once attached,
+// every batch submitted to the pipeline passes through it and a single
+// worker runs them through the stages in the order they were queued.
+// Pipeline.Submit still waits for the result of its batch; the sinks of
+// ForSource and ForLane only wait for room in the queue, so a slow chain
+// holds up the generator only under OverflowBlock.
+type IngestQueue struct {
+	pipeline *Pipeline
+	capacity int
+	policy   OverflowPolicy
+
+	mu      sync.Mutex
+	wake    *sync.Cond
+	items   []queuedBatch
+	stats   IngestStats
+	closed  bool
+	stopped chan struct{}
+}
+
+// NewIngestQueue creates a queue of capacity batches in front of p,
+// attaches it to p and starts its worker; Close stops it
+func NewIngestQueue(p *Pipeline, capacity int, policy OverflowPolicy) (*IngestQueue, error) {
+	if capacity < 1 {
+		return nil, fmt.Errorf("Ungültige Größe der Eingangswarteschlange: %d", capacity)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	q := &IngestQueue{
+		pipeline: p,
+		capacity: capacity,
+		policy:   policy,
+		stats:    IngestStats{Capacity: capacity, Policy: policy},
+		stopped:  make(chan struct{}),
+	}
+	q.wake = sync.NewCond(&q.mu)
+	p.mu.Lock()
+	p.queue = q
+	p.mu.Unlock()
+	go q.run()
+	return q, nil
+}
+
+// submit queues batch according to the overflow policy. If wait is set it
+// returns the result of the pipeline, otherwise once the batch is queued.
+func (q *IngestQueue) submit(batch *Batch, wait bool) error {
+	item := queuedBatch{batch: batch}
+	if wait {
+		item.done = make(chan error, 1)
+	}
+
+	q.mu.Lock()
+	if !q.closed && len(q.items) >= q.capacity {
+		switch q.policy {
+		case OverflowDropNewest:
+			q.stats.DroppedNewest++
+			q.mu.Unlock()
+			return ErrQueueFull
+		case OverflowDropOldest:
+			q.stats.DroppedOldest++
+			evicted := q.items[0]
+			q.items = q.items[1:]
+			if evicted.done != nil {
+				evicted.done <- ErrBatchDropped
+			}
+		default:
+			q.stats.Blocked++
+			for !q.closed && len(q.items) >= q.capacity {
+				q.wake.Wait()
+			}
+		}
+	}
+	if q.closed {
+		q.mu.Unlock()
+		return ErrQueueClosed
+	}
+	q.items = append(q.items, item)
+	q.stats.Accepted++
+	q.stats.MaxDepth = max(q.stats.MaxDepth, len(q.items))
+	q.wake.Broadcast()
+	q.mu.Unlock()
+
+	if !wait {
+		return nil
+	}
+	return <-item.done
+}
+
+// Stats returns the depth and counters of the queue
+func (q *IngestQueue) Stats() IngestStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Depth = len(q.items)
+	return stats
+}
+
+// Close stops taking batches and waits until the queued ones went through
+// the pipeline. Producers waiting for room get ErrQueueClosed.
+func (q *IngestQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.wake.Broadcast()
+	q.mu.Unlock()
+	<-q.stopped
+}
+
+func (q *IngestQueue) run() {
+	defer close(q.stopped)
+	for {
+		q.mu.Lock()
+		for !q.closed && len(q.items) == 0 {
+			q.wake.Wait()
+		}
+		if len(q.items) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		item := q.items[0]
+		q.items = q.items[1:]
+		// room for a producer waiting under OverflowBlock
+		q.wake.Broadcast()
+		q.mu.Unlock()
+
+		err := q.pipeline.process(item.batch)
+
+		q.mu.Lock()
+		if err != nil {
+			q.stats.Failed++
+		} else {
+			q.stats.Processed++
+		}
+		q.mu.Unlock()
+
+		switch {
+		case item.done != nil:
+			item.done <- err
+		case err != nil:
+			log.Printf("Batch von %s abgelehnt: %v", item.batch.Source, err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// heldQueue returns a queue of capacity 2 under policy in front of a
+// chain and the sink of its generator, with the worker held in the
+// append of a first batch until the returned release is called
+func heldQueue(t *testing.T, policy OverflowPolicy) (*Blockchain, *IngestQueue, Sink, func()) {
+	t.Helper()
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline := NewDefaultPipeline(bc)
+	q, err := NewIngestQueue(pipeline, 2, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := pipeline.ForSource("generator")
+
+	bc.mu.Lock()
+	if err := sink.AddBlock([]float64{0}); err != nil {
+		t.Fatal(err)
+	}
+	waitForQueue(t, q, func(s IngestStats) bool { return s.Accepted == 1 && s.Depth == 0 })
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			bc.mu.Unlock()
+		}
+	}
+	t.Cleanup(release)
+	return bc, q, sink, release
+}
+
+// waitForQueue waits until the stats of q satisfy ok
+func waitForQueue(t *testing.T, q *IngestQueue, ok func(IngestStats) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok(q.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue stats are %+v", q.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// firstValues returns the first value of each block of bc after genesis
+func firstValues(bc *Blockchain) []float64 {
+	var values []float64
+	for _, block := range bc.Blocks()[1:] {
+		values = append(values, block.Values[0])
+	}
+	return values
+}
+
+func TestIngestQueueBackpressure(t *testing.T) {
+	bc, q, sink, release := heldQueue(t, OverflowBlock)
+	for _, v := range []float64{1, 2} {
+		if err := sink.AddBlock([]float64{v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the producer of a third batch waits for room
+	returned := make(chan error, 1)
+	go func() { returned <- sink.AddBlock([]float64{3}) }()
+	waitForQueue(t, q, func(s IngestStats) bool { return s.Blocked == 1 })
+	select {
+	case err := <-returned:
+		t.Fatalf("producer returned %v with the queue full", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if stats := q.Stats(); stats.Depth != 2 || stats.MaxDepth != 2 || stats.Dropped() != 0 {
+		t.Fatalf("full queue has stats %+v", stats)
+	}
+
+	release()
+	if err := <-returned; err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+	if got := firstValues(bc); !slices.Equal(got, []float64{0, 1, 2, 3}) {
+		t.Fatalf("blocks hold %v, want 0 to 3 in order", got)
+	}
+	if stats := q.Stats(); stats.Accepted != 4 || stats.Processed != 4 || stats.Depth != 0 {
+		t.Fatalf("drained queue has stats %+v", stats)
+	}
+}
+
+func TestIngestQueueDropNewest(t *testing.T) {
+	bc, q, sink, release := heldQueue(t, OverflowDropNewest)
+	for _, v := range []float64{1, 2} {
+		if err := sink.AddBlock([]float64{v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.AddBlock([]float64{3}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("batch for a full queue returned %v, want ErrQueueFull", err)
+	}
+	release()
+	q.Close()
+	if got := firstValues(bc); !slices.Equal(got, []float64{0, 1, 2}) {
+		t.Fatalf("blocks hold %v, want 0 to 2", got)
+	}
+	if stats := q.Stats(); stats.DroppedNewest != 1 || stats.Processed != 3 {
+		t.Fatalf("queue has stats %+v, want 1 dropped and 3 processed", stats)
+	}
+}
+
+func TestIngestQueueDropOldest(t *testing.T) {
+	bc, q, sink, release := heldQueue(t, OverflowDropOldest)
+
+	// the batch of Submit waits in the queue and is evicted first
+	submitted := make(chan error, 1)
+	go func() { submitted <- q.pipeline.Submit(&Batch{Source: "manual", Values: []float64{1}}) }()
+	waitForQueue(t, q, func(s IngestStats) bool { return s.Depth == 1 })
+	for _, v := range []float64{2, 3} {
+		if err := sink.AddBlock([]float64{v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-submitted; !errors.Is(err, ErrBatchDropped) {
+		t.Fatalf("evicted batch returned %v, want ErrBatchDropped", err)
+	}
+	release()
+	q.Close()
+	if got := firstValues(bc); !slices.Equal(got, []float64{0, 2, 3}) {
+		t.Fatalf("blocks hold %v, want 0, 2 and 3", got)
+	}
+	if stats := q.Stats(); stats.DroppedOldest != 1 || stats.Processed != 3 {
+		t.Fatalf("queue has stats %+v, want 1 dropped and 3 processed", stats)
+	}
+}
+
+func TestIngestQueueCloseDrains(t *testing.T) {
+	bc, q, sink, release := heldQueue(t, OverflowBlock)
+	for _, v := range []float64{1, 2} {
+		if err := sink.AddBlock([]float64{v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocked := make(chan error, 1)
+	go func() { blocked <- sink.AddBlock([]float64{3}) }()
+	waitForQueue(t, q, func(s IngestStats) bool { return s.Blocked == 1 })
+
+	// Close turns the waiting producer away but processes what is queued
+	closed := make(chan struct{})
+	go func() {
+		q.Close()
+		close(closed)
+	}()
+	if err := <-blocked; !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("waiting producer returned %v, want ErrQueueClosed", err)
+	}
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the queued batches were appended")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	<-closed
+	if got := firstValues(bc); !slices.Equal(got, []float64{0, 1, 2}) {
+		t.Fatalf("blocks hold %v, want 0 to 2", got)
+	}
+	if err := sink.AddBlock([]float64{4}); !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("batch after Close returned %v, want ErrQueueClosed", err)
+	}
+}
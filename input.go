@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// maxHistory is the number of history entries kept in the history file
+const maxHistory = 500
+
+// LineReader reads lines of user input for the interactive menu
+type LineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+// NewLineReader returns a line editor with history and path completion
+// when in is a terminal, and a plain line reader otherwise. History is
+// persisted to historyPath unless it is empty.
+func NewLineReader(in *os.File, out io.Writer, historyPath string) LineReader {
+	if !term.IsTerminal(int(in.Fd())) {
+		return NewPlainLineReader(in, out)
+	}
+	return &terminalReader{
+		in:          in,
+		reader:      bufio.NewReader(in),
+		out:         out,
+		history:     loadHistory(historyPath),
+		historyPath: historyPath,
+	}
+}
+
+// historyFile returns the default location of the history file
+func historyFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".block_data_save_history")
+}
+
+// plainReader reads whole lines from any io.Reader
+type plainReader struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewPlainLineReader returns a LineReader without editing features, for
+// pipes and scripted input
+func NewPlainLineReader(in io.Reader, out io.Writer) LineReader {
+	return &plainReader{in: bufio.NewReader(in), out: out}
+}
+
+func (r *plainReader) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	line, err := r.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// promptString prints prompt on its own line and reads the answer
+func promptString(r LineReader, prompt string) (string, error) {
+	if prompt != "" {
+		prompt += "\n"
+	}
+	line, err := r.ReadLine(prompt)
+	return strings.TrimSpace(line), err
+}
+
+// promptInt reads a whole number, asking again until the input is one
+func promptInt(r LineReader, prompt string) (int, error) {
+	for {
+		line, err := promptString(r, prompt)
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.Atoi(line)
+		if err == nil {
+			return n, nil
+		}
+		prompt = "Bitte eine Zahl eingeben:"
+	}
+}
+
+// terminalReader is a small readline-style editor running the terminal in
+// raw mode while a line is edited
+type terminalReader struct {
+	in          *os.File
+	reader      *bufio.Reader
+	out         io.Writer
+	history     []string
+	historyPath string
+}
+
+// errInterrupted is returned by ReadLine when the user pressed Ctrl-C
+var errInterrupted = errors.New("Eingabe abgebrochen")
+
+func (r *terminalReader) ReadLine(prompt string) (string, error) {
+	// the editor redraws the last prompt line only
+	if i := strings.LastIndex(prompt, "\n"); i >= 0 {
+		fmt.Fprint(r.out, strings.ReplaceAll(prompt[:i+1], "\n", "\r\n"))
+		prompt = prompt[i+1:]
+	}
+
+	state, err := term.MakeRaw(int(r.in.Fd()))
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(int(r.in.Fd()), state)
+
+	ed := lineEditor{prompt: prompt, out: r.out, historyPos: len(r.history)}
+	ed.redraw()
+	for {
+		key, _, err := r.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch key {
+		case '\r', '\n':
+			fmt.Fprint(r.out, "\r\n")
+			line := string(ed.line)
+			r.addHistory(line)
+			return line, nil
+		case 3: // Ctrl-C
+			fmt.Fprint(r.out, "\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(ed.line) == 0 {
+				fmt.Fprint(r.out, "\r\n")
+				return "", io.EOF
+			}
+		case 127, 8: // Backspace
+			ed.backspace()
+		case '\t':
+			ed.complete()
+		case 27: // escape sequences for the arrow keys
+			if b, _ := r.reader.ReadByte(); b != '[' {
+				continue
+			}
+			b, _ := r.reader.ReadByte()
+			switch b {
+			case 'A':
+				ed.recall(r.history, -1)
+			case 'B':
+				ed.recall(r.history, 1)
+			case 'C':
+				ed.move(1)
+			case 'D':
+				ed.move(-1)
+			}
+		default:
+			if key >= ' ' {
+				ed.insert(key)
+			}
+		}
+	}
+}
+
+// addHistory records line in memory and appends it to the history file
+func (r *terminalReader) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || (len(r.history) > 0 && r.history[len(r.history)-1] == line) {
+		return
+	}
+	r.history = append(r.history, line)
+	if len(r.history) > maxHistory {
+		r.history = r.history[len(r.history)-maxHistory:]
+	}
+	if r.historyPath == "" {
+		return
+	}
+
+	file, err := os.OpenFile(r.historyPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
+
+// loadHistory reads the most recent entries of the history file
+func loadHistory(path string) []string {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+	return lines
+}
+
+// lineEditor holds the state of the line being edited
+type lineEditor struct {
+	prompt     string
+	out        io.Writer
+	line       []rune
+	pos        int
+	historyPos int
+}
+
+func (e *lineEditor) redraw() {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, string(e.line))
+	if back := len(e.line) - e.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func (e *lineEditor) insert(key rune) {
+	e.line = append(e.line[:e.pos], append([]rune{key}, e.line[e.pos:]...)...)
+	e.pos++
+	e.redraw()
+}
+
+func (e *lineEditor) backspace() {
+	if e.pos == 0 {
+		return
+	}
+	e.line = append(e.line[:e.pos-1], e.line[e.pos:]...)
+	e.pos--
+	e.redraw()
+}
+
+func (e *lineEditor) move(delta int) {
+	e.pos = min(max(e.pos+delta, 0), len(e.line))
+	e.redraw()
+}
+
+// recall replaces the line with an older (-1) or newer (+1) history entry
+func (e *lineEditor) recall(history []string, delta int) {
+	pos := e.historyPos + delta
+	if pos < 0 || pos > len(history) {
+		return
+	}
+	e.historyPos = pos
+	e.line = nil
+	if pos < len(history) {
+		e.line = []rune(history[pos])
+	}
+	e.pos = len(e.line)
+	e.redraw()
+}
+
+// complete expands the last word of the line as a file path
+func (e *lineEditor) complete() {
+	text := string(e.line[:e.pos])
+	start := strings.LastIndexAny(text, " \t") + 1
+	word := text[start:]
+
+	matches, _ := filepath.Glob(word + "*")
+	if len(matches) == 0 {
+		return
+	}
+
+	completion := matches[0]
+	for _, m := range matches[1:] {
+		completion = commonPrefix(completion, m)
+	}
+	if len(matches) == 1 {
+		if info, err := os.Stat(completion); err == nil && info.IsDir() {
+			completion += string(filepath.Separator)
+		}
+	} else if completion == word {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(matches, "  "))
+	}
+
+	rest := e.line[e.pos:]
+	e.line = append([]rune(text[:start]+completion), rest...)
+	e.pos = len(e.line) - len(rest)
+	e.redraw()
+}
+
+func commonPrefix(a, b string) string {
+	ra, rb := []rune(a), []rune(b)
+	n := 0
+	for n < len(ra) && n < len(rb) && ra[n] == rb[n] {
+		n++
+	}
+	return string(ra[:n])
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMenuRejectsBadInput(t *testing.T) {
+	dir := t.TempDir()
+	malformed := filepath.Join(dir, "kaputt.csv")
+	if err := os.WriteFile(malformed, []byte("1,2\n\"3,4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		added int
+	}{
+		{"NaN", "7\nNaN\n\n", "NaN an Position 0 ist keine endliche Zahl", 0},
+		{"Inf", "7\n1 Inf\n\n", "+Inf an Position 1 ist keine endliche Zahl", 0},
+		{"negative Inf", "7\n-Inf\n\n", "-Inf an Position 0 ist keine endliche Zahl", 0},
+		{"overflow", "7\n1e400\n", "Ungültige Eingabe", 0},
+		{"empty", "7\n\n\n", "Block enthält keine Werte", 0},
+		{"word", "7\n1;abc\n", "Ungültige Eingabe", 0},
+		{"menu out of range", "99\n", "Ungültige Auswahl!", 0},
+		{"block out of range", "show 999\n", "Block nicht gefunden: Index 999", 0},
+		{"negative block", "show -1\n", "Block nicht gefunden: Index -1", 0},
+		{"count not a number", "21\nviele\nNaN\n3\n", "Bitte eine Zahl eingeben:\nBitte eine Zahl eingeben:\nKeine Blöcke mit Ausreißern", 0},
+		{"malformed CSV", "4 " + malformed + " csv\n", "Zeile 2: extraneous or missing \" in quoted-field", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, err := NewBlockchain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the menu still answers after the bad input
+			var out bytes.Buffer
+			RunCLI(bc, strings.NewReader(tt.input+"info\n"), &out)
+			if !strings.Contains(out.String(), tt.want) {
+				t.Fatalf("output lacks %q:\n%s", tt.want, out.String())
+			}
+			if !strings.Contains(out.String(), bc.Info().String()) {
+				t.Fatalf("menu stopped after the bad input:\n%s", out.String())
+			}
+			if added := bc.Length() - 1; added != tt.added {
+				t.Fatalf("%d blocks added, want %d", added, tt.added)
+			}
+			if err := bc.Validate(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// ValueKind is the type of the values stored in a chain's blocks
+type ValueKind string
+
+const (
+	// KindFloat blocks store Values as float64
+	KindFloat ValueKind = "float"
+	// KindInt blocks store IntValues as int64 without float rounding
+	KindInt ValueKind = "int"
+)
+
+// ErrValueKindMismatch is returned when a block's values do not match the
+// value kind of its chain
+var ErrValueKindMismatch = errors.New("Werttyp passt nicht zur Blockchain")
+
+// IntStats are the statistics of an integer block that can be reported
+// exactly. Mean and the SD bounds stay on the Block as float64.
+type IntStats struct {
+	Min int64 `json:"min"`
+	Max int64 `json:"max"`
+	// Sum is exact and may exceed the int64 range
+	Sum *big.Int `json:"sum"`
+	// Median is only meaningful when MedianExact is set; otherwise the
+	// median lies halfway between two integers and Block.Median holds it
+	Median      int64 `json:"median"`
+	MedianExact bool  `json:"median_exact"`
+}
+
+// SetValueKind selects the value kind of the chain. It can only be changed
+// while the chain holds nothing but the genesis block, so a chain never
+// mixes kinds.
+func (bc *Blockchain) SetValueKind(kind ValueKind) error {
+	if kind != KindFloat && kind != KindInt {
+		return fmt.Errorf("Unbekannter Werttyp: %s", kind)
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if kind != bc.valueKind && bc.held > 1 {
+		return fmt.Errorf("%w: Blockchain enthält bereits Blöcke vom Typ %s", ErrValueKindMismatch, bc.valueKind)
+	}
+	bc.valueKind = kind
+	return nil
+}
+
+// ValueKind returns the value kind of the chain
+func (bc *Blockchain) ValueKind() ValueKind {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.valueKind
+}
+
+// AddIntBlock adds a block of integer values to a chain of KindInt. Like
+// AddBlock it refuses a block without values.
+func (bc *Blockchain) AddIntBlock(values []int64) error {
+	// nil would read as a float payload and fail with a kind mismatch
+	if values == nil {
+		values = []int64{}
+	}
+	_, err := bc.addBlock(blockPayload{intValues: values})
+	return err
+}
+
+// ValueCount returns the number of values the block was created with.
+// For sampled blocks this is more than the values actually stored, and
+// blocks whose values were dropped store none.
+func (b *Block) ValueCount() int {
+	if b.ValuesDropped && b.Dropped != nil {
+		return b.Dropped.Count
+	}
+	if b.Kind == KindInt {
+		return len(b.IntValues)
+	}
+	if b.Sampled {
+		return b.OriginalCount
+	}
+	return b.storedCount()
+}
+
+// OutlierCount returns the number of outliers detected in the block
+func (b *Block) OutlierCount() int {
+	if b.ValuesDropped && b.Dropped != nil {
+		return b.Dropped.Outliers
+	}
+	if b.Kind == KindInt {
+		return len(b.IntOutliers)
+	}
+	return len(b.Outliers)
+}
+
+// calculateIntStats calculates the statistics of an integer block
+func calculateIntStats(block *Block, outliers OutlierConfig) {
+	values := block.IntValues
+	if len(values) == 0 {
+		block.IntStats = &IntStats{Sum: new(big.Int)}
+		block.Mean, block.Median = math.NaN(), math.NaN()
+		block.TwoSDLower, block.TwoSDUpper = math.NaN(), math.NaN()
+		return
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	sum := new(big.Int)
+	for _, v := range values {
+		sum.Add(sum, big.NewInt(v))
+	}
+	n := big.NewInt(int64(len(values)))
+	mean, _ := new(big.Rat).SetFrac(sum, n).Float64()
+
+	stats := &IntStats{Min: sorted[0], Max: sorted[len(sorted)-1], Sum: sum}
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		stats.Median, stats.MedianExact = sorted[mid], true
+		block.Median = float64(sorted[mid])
+	} else {
+		pair := new(big.Int).Add(big.NewInt(sorted[mid-1]), big.NewInt(sorted[mid]))
+		half, rem := new(big.Int).QuoRem(pair, big.NewInt(2), new(big.Int))
+		if rem.Sign() == 0 {
+			stats.Median, stats.MedianExact = half.Int64(), true
+		}
+		block.Median, _ = new(big.Rat).SetFrac(pair, big.NewInt(2)).Float64()
+	}
+
+	variance := 0.0
+	for _, v := range values {
+		diff := float64(v) - mean
+		variance += diff * diff
+	}
+	stdDev := math.Sqrt(variance / float64(len(values)))
+
+	block.Mean = mean
+	block.TwoSDLower = mean - 2*stdDev
+	block.TwoSDUpper = mean + 2*stdDev
+	block.IntStats = stats
+	floats := make([]float64, len(values))
+	for i, v := range values {
+		floats[i] = float64(v)
+	}
+	lower, upper := applyOutlierConfig(block, outliers, floats)
+	block.IntOutliers = nil
+	for _, v := range values {
+		if float64(v) < lower || float64(v) > upper {
+			block.IntOutliers = append(block.IntOutliers, v)
+		}
+	}
+}
+
+// formatInts renders integers separated by spaces
+func formatInts(values []int64) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// Blocks are immutable once they have been appended to the chain: AddBlock
+// builds a block completely before publishing it and never touches it again,
+// so a *Block obtained from the chain can be read without holding the lock.
+// Operations that change blocks already appended, such as SetReferences,
+// store changed copies in their place. Inside the package snapshot shares
+// the blocks a memory storage holds; every exported method returns copies,
+// read from the storage or made with copyBlock, so callers may modify what
+// they get without affecting the chain.
+
+// Iterator walks a snapshot of the chain taken when it was created
+type Iterator struct {
+	blocks []*Block
+	check  readCheck
+	pos    int
+}
+
+// snapshot returns the blocks of the chain as read under the read lock;
+// appends after that never change them. A storage that fails to be read
+// is logged, and snapshot returns the blocks read before the error.
+func (bc *Blockchain) snapshot() []*Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	blocks, err := bc.allBlocks()
+	logReadError(err)
+	return blocks
+}
+
+// Iterate calls fn with a copy of every block in a snapshot of the chain,
+// in order, until fn returns false. Blocks added while iterating are not
+// visited.
+func (bc *Blockchain) Iterate(fn func(*Block) bool) {
+	blocks, check := bc.readSnapshot()
+	for pos, block := range blocks {
+		check.logged(pos, block)
+		if !fn(copyBlock(block)) {
+			return
+		}
+	}
+}
+
+// Length returns the number of blocks the chain holds, including genesis
+// or the checkpoint record
+func (bc *Blockchain) Length() int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.held
+}
+
+// LatestBlock returns a copy of the head block, which the chain keeps in
+// memory
+func (bc *Blockchain) LatestBlock() *Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	bc.readCheck().logged(bc.held-1, bc.head)
+	return copyBlock(bc.head)
+}
+
+// HeadHash returns the hash of the head block, which the next block will
+// link to
+func (bc *Blockchain) HeadHash() string {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.head.Hash
+}
+
+// BlockByIndex returns a copy of the block with the given index
+func (bc *Blockchain) BlockByIndex(index int) (*Block, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	pos, err := bc.position(index)
+	if err != nil {
+		return nil, err
+	}
+	return bc.readBlock(pos)
+}
+
+// readBlock returns a copy of the block in slot pos read from the storage,
+// checked if SetVerifyOnRead asks for it. Called with bc.mu held.
+func (bc *Blockchain) readBlock(pos int) (*Block, error) {
+	block, err := bc.storage.GetBlock(bc.base + pos)
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.readCheck().verify(pos, block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Blocks returns copies of all blocks, in order
+func (bc *Blockchain) Blocks() []*Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.readBlocks(0, bc.held)
+}
+
+// readBlocks returns copies of the blocks in the slots [from, to) read
+// from the storage, each checked as readCheck logs. A storage that fails
+// to be read is logged, and readBlocks returns the blocks read before the
+// error. Called with bc.mu held.
+func (bc *Blockchain) readBlocks(from, to int) []*Block {
+	check := bc.readCheck()
+	blocks := make([]*Block, 0, max(to-from, 0))
+	err := bc.storage.Iterate(bc.base+from, bc.base+to, func(block *Block) error {
+		check.logged(from+len(blocks), block)
+		blocks = append(blocks, block)
+		return nil
+	})
+	logReadError(err)
+	return blocks
+}
+
+// BlockRange returns copies of up to limit blocks starting at position
+// offset of the blocks the chain holds, and the number of blocks it holds
+func (bc *Blockchain) BlockRange(offset, limit int) ([]*Block, int) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	total := bc.held
+	start := min(offset, total)
+	return bc.readBlocks(start, start+min(limit, total-start)), total
+}
+
+// OutlierBlocks returns copies of the blocks with outliers, in order
+func (bc *Blockchain) OutlierBlocks() []*Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	held, err := bc.allBlocks()
+	logReadError(err)
+	var blocks []*Block
+	for _, block := range held {
+		if block.HasOutliers {
+			blocks = append(blocks, copyBlock(block))
+		}
+	}
+	return blocks
+}
+
+// Iterator returns a cursor over a snapshot of the chain
+func (bc *Blockchain) Iterator() *Iterator {
+	blocks, check := bc.readSnapshot()
+	return &Iterator{blocks: blocks, check: check}
+}
+
+// Next returns a copy of the next block, or false once the snapshot is
+// exhausted
+func (it *Iterator) Next() (*Block, bool) {
+	if it.pos >= len(it.blocks) {
+		return nil, false
+	}
+	block := it.blocks[it.pos]
+	it.check.logged(it.pos, block)
+	it.pos++
+	return copyBlock(block), true
+}
+
+// Len returns the number of blocks in the snapshot
+func (it *Iterator) Len() int {
+	return len(it.blocks)
+}
+
+// copyBlock returns a copy of block that shares no mutable state with it
+func copyBlock(block *Block) *Block {
+	copied := *block
+	copied.Values = slices.Clone(block.Values)
+	copied.EncodedValues = slices.Clone(block.EncodedValues)
+	copied.Outliers = slices.Clone(block.Outliers)
+	copied.Metadata = maps.Clone(block.Metadata)
+	copied.Percentiles = maps.Clone(block.Percentiles)
+	copied.Histogram = slices.Clone(block.Histogram)
+	copied.ValueOrigins = slices.Clone(block.ValueOrigins)
+	copied.ValueTimes = slices.Clone(block.ValueTimes)
+	copied.IntValues = slices.Clone(block.IntValues)
+	copied.IntOutliers = slices.Clone(block.IntOutliers)
+	copied.References = slices.Clone(block.References)
+	copied.StatsErrors = maps.Clone(block.StatsErrors)
+	copied.Signature = slices.Clone(block.Signature)
+	copied.SignerPublicKey = slices.Clone(block.SignerPublicKey)
+	if block.IntStats != nil {
+		stats := *block.IntStats
+		if stats.Sum != nil {
+			stats.Sum = new(big.Int).Set(stats.Sum)
+		}
+		copied.IntStats = &stats
+	}
+	if block.OutlierContexts != nil {
+		copied.OutlierContexts = make([]OutlierContext, len(block.OutlierContexts))
+		for i, context := range block.OutlierContexts {
+			context.Before = slices.Clone(context.Before)
+			context.After = slices.Clone(context.After)
+			copied.OutlierContexts[i] = context
+		}
+	}
+	if block.Quality != nil {
+		quality := *block.Quality
+		quality.Penalties = maps.Clone(block.Quality.Penalties)
+		copied.Quality = &quality
+	}
+	if block.Binned != nil {
+		binned := *block.Binned
+		binned.Counts = slices.Clone(block.Binned.Counts)
+		copied.Binned = &binned
+	}
+	if block.Dropped != nil {
+		dropped := *block.Dropped
+		copied.Dropped = &dropped
+	}
+	if block.Extensions != nil {
+		copied.Extensions = make(map[string]json.RawMessage, len(block.Extensions))
+		for name, raw := range block.Extensions {
+			copied.Extensions[name] = slices.Clone(raw)
+		}
+	}
+	return &copied
+}
+
+// SearchText returns copies of the blocks whose annotation contains
+// substr, ignoring case. An empty substr matches every annotated block.
+func (bc *Blockchain) SearchText(substr string) []*Block {
+	substr = strings.ToLower(substr)
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	held, err := bc.allBlocks()
+	logReadError(err)
+	var blocks []*Block
+	for _, block := range held {
+		if block.Text != "" && strings.Contains(strings.ToLower(block.Text), substr) {
+			blocks = append(blocks, copyBlock(block))
+		}
+	}
+	return blocks
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrentBlocks is how many blocks the writer of the concurrency tests
+// appends while the readers run
+const concurrentBlocks = 200
+
+// appendConcurrently adds concurrentBlocks blocks to bc from a goroutine
+// and returns a channel closed once it is done. The test fails if an
+// append does.
+func appendConcurrently(t *testing.T, bc *Blockchain) <-chan struct{} {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < concurrentBlocks; i++ {
+			if _, err := bc.AddBlock([]float64{float64(i), 1, 2, 3}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// checkLinked reports the first block of blocks that does not follow the
+// one before it
+func checkLinked(blocks []*Block) error {
+	for i := 1; i < len(blocks); i++ {
+		if blocks[i].Index != blocks[i-1].Index+1 || blocks[i].PrevHash != blocks[i-1].Hash {
+			return fmt.Errorf("block %d does not follow block %d", blocks[i].Index, blocks[i-1].Index)
+		}
+		if calculateHash(blocks[i]) != blocks[i].Hash {
+			return fmt.Errorf("block %d does not match its hash", blocks[i].Index)
+		}
+	}
+	return nil
+}
+
+func TestIterateWhileAppending(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := appendConcurrently(t, bc)
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		var visited []*Block
+		bc.Iterate(func(block *Block) bool {
+			visited = append(visited, block)
+			return true
+		})
+		if err := checkLinked(visited); err != nil {
+			t.Fatalf("Iterate: %v", err)
+		}
+
+		it := bc.Iterator()
+		n := it.Len()
+		var walked []*Block
+		for block, ok := it.Next(); ok; block, ok = it.Next() {
+			walked = append(walked, block)
+		}
+		if len(walked) != n || it.Len() != n {
+			t.Fatalf("Iterator returned %d blocks with Len %d, then %d, want a stable snapshot", len(walked), n, it.Len())
+		}
+		if err := checkLinked(walked); err != nil {
+			t.Fatalf("Iterator: %v", err)
+		}
+		// a copy can be changed without affecting the chain
+		for _, block := range walked {
+			block.Text = "changed"
+		}
+	}
+
+	if bc.Length() != concurrentBlocks+1 {
+		t.Fatalf("chain holds %d blocks, want %d", bc.Length(), concurrentBlocks+1)
+	}
+	bc.Iterate(func(block *Block) bool {
+		if block.Text == "changed" {
+			t.Fatalf("block %d was changed through a copy returned by Iterator", block.Index)
+		}
+		return true
+	})
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIterateStops(t *testing.T) {
+	bc := newFilledChain(t)
+	calls := 0
+	bc.Iterate(func(*Block) bool {
+		calls++
+		return calls < 2
+	})
+	if calls != 2 {
+		t.Fatalf("Iterate called fn %d times after it returned false, want 2", calls)
+	}
+}
+
+func TestAccessorsWhileAppending(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := appendConcurrently(t, bc)
+
+	var wg sync.WaitGroup
+	readers := []func() error{
+		func() error {
+			latest := bc.LatestBlock()
+			if calculateHash(latest) != latest.Hash {
+				return fmt.Errorf("LatestBlock returned block %d not matching its hash", latest.Index)
+			}
+			return nil
+		},
+		func() error {
+			n := bc.Length()
+			if n < 1 || n > concurrentBlocks+1 {
+				return fmt.Errorf("Length is %d", n)
+			}
+			block, err := bc.BlockByIndex(n - 1)
+			if err != nil {
+				return fmt.Errorf("BlockByIndex(%d) of %d blocks: %w", n-1, n, err)
+			}
+			if block.Index != n-1 {
+				return fmt.Errorf("BlockByIndex(%d) returned block %d", n-1, block.Index)
+			}
+			return nil
+		},
+		func() error {
+			blocks := bc.Blocks()
+			if err := checkLinked(blocks); err != nil {
+				return fmt.Errorf("Blocks: %w", err)
+			}
+			// a copy can be changed without affecting the chain
+			blocks[len(blocks)-1].Values = nil
+			return nil
+		},
+	}
+	for _, read := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if err := read(); err != nil {
+					t.Error(err)
+					return
+				}
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+
+	if bc.Length() != concurrentBlocks+1 || bc.LatestBlock().Index != concurrentBlocks {
+		t.Fatalf("chain holds %d blocks with head %d, want %d with head %d", bc.Length(), bc.LatestBlock().Index, concurrentBlocks+1, concurrentBlocks)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddBlockCopiesValues(t *testing.T) {
+	for _, encoding := range []ValueEncoding{EncodingNone, EncodingFloat32} {
+		t.Run(string(encoding), func(t *testing.T) {
+			bc, err := NewBlockchain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := bc.SetValueEncoding(encoding); err != nil {
+				t.Fatal(err)
+			}
+			values := []float64{1, 2, 3}
+			rows := [][]float64{{4, 5, 6}}
+			if _, err := bc.AddBlock(values); err != nil {
+				t.Fatal(err)
+			}
+			if err := bc.AddBlocksBulk(rows, 1); err != nil {
+				t.Fatal(err)
+			}
+			// the caller may reuse its slices once the blocks are added
+			values[0], rows[0][0] = 100, 100
+			if err := bc.Validate(); err != nil {
+				t.Fatalf("changing the added slices changed the chain: %v", err)
+			}
+		})
+	}
+}
+
+var (
+	bigIntType = reflect.TypeOf(big.Int{})
+	timeType   = reflect.TypeOf(time.Time{})
+)
+
+// fillValue sets v and everything it refers to to values other than the
+// zero value: slices get two elements, maps one entry and pointers a
+// target
+func fillValue(v reflect.Value, seed int) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(seed + 1))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(seed + 1))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(seed) + 0.5)
+	case reflect.String:
+		v.SetString(fmt.Sprintf("s%d", seed))
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+		for i := 0; i < 2; i++ {
+			fillValue(v.Index(i), seed+i)
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key := reflect.New(v.Type().Key()).Elem()
+		elem := reflect.New(v.Type().Elem()).Elem()
+		fillValue(key, seed)
+		fillValue(elem, seed)
+		v.SetMapIndex(key, elem)
+	case reflect.Pointer:
+		if v.Type().Elem() == bigIntType {
+			v.Set(reflect.ValueOf(big.NewInt(int64(seed + 1))))
+			return
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		fillValue(v.Elem(), seed)
+	case reflect.Struct:
+		if v.Type() == timeType {
+			v.Set(reflect.ValueOf(time.Date(2024, 5, 1, 12, seed%60, 0, 0, time.UTC)))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			fillValue(v.Field(i), seed+i)
+		}
+	default:
+		panic("fillValue: unsupported kind " + v.Kind().String())
+	}
+}
+
+// mutateValue changes v in place, and everything it refers to through
+// slices, maps and pointers, so that any of it shared with another value
+// changes there too
+func mutateValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(!v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(v.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(v.Uint() + 1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(v.Float() + 1)
+	case reflect.String:
+		v.SetString(v.String() + "x")
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			mutateValue(v.Index(i))
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			mutateValue(elem)
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		if n, ok := v.Interface().(*big.Int); ok {
+			n.Add(n, big.NewInt(1))
+			return
+		}
+		mutateValue(v.Elem())
+	case reflect.Struct:
+		if v.Type() == timeType {
+			v.Set(reflect.ValueOf(v.Interface().(time.Time).Add(time.Hour)))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			mutateValue(v.Field(i))
+		}
+	default:
+		panic("mutateValue: unsupported kind " + v.Kind().String())
+	}
+}
+
+func TestCopyBlockSharesNothing(t *testing.T) {
+	var block, want Block
+	fillValue(reflect.ValueOf(&block).Elem(), 0)
+	fillValue(reflect.ValueOf(&want).Elem(), 0)
+	for i := 0; i < reflect.TypeOf(block).NumField(); i++ {
+		if field := reflect.ValueOf(block).Field(i); field.IsZero() {
+			t.Fatalf("field %s of the filled block is not set", reflect.TypeOf(block).Field(i).Name)
+		}
+	}
+
+	copied := copyBlock(&block)
+	if !reflect.DeepEqual(copied, &want) {
+		t.Fatal("copyBlock does not copy every field")
+	}
+	mutateValue(reflect.ValueOf(copied).Elem())
+	if reflect.DeepEqual(copied, &want) {
+		t.Fatal("mutateValue did not change the copy")
+	}
+	if !reflect.DeepEqual(&block, &want) {
+		t.Fatal("changing a copy made by copyBlock changed the original block")
+	}
+}
+
+func TestReturnedBlocksAreCopies(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetSigner(priv); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetOutlierContext(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetHistogramBins(&HistogramBins{Count: 4, Min: 0, Max: 100}); err != nil {
+		t.Fatal(err)
+	}
+	bc.SetTrackOrigins(true)
+	fillChain(t, bc)
+	if err := bc.AddBlockWithMetadata([]float64{1, 2, 3}, "Messung", map[string]string{"quelle": "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddDerivedBlock([]float64{10, 90}, []Origin{{BlockIndex: 2, Position: 0}, {BlockIndex: 2, Position: 9}}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := bc.AddTimedValues([]Sample{{start, 1}, {start.Add(time.Second), 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetReferences(2, []Reference{{Type: "ticket", URI: "https://example.com/1"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := bc.Blocks()
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	head := bc.LatestBlock()
+	for _, tc := range []struct {
+		name string
+		get  func() []*Block
+	}{
+		{"Blocks", bc.Blocks},
+		{"BlockByIndex", func() []*Block { block, _ := bc.BlockByIndex(2); return []*Block{block} }},
+		{"BlockByID", func() []*Block { block, _ := bc.BlockByID(head.ID); return []*Block{block} }},
+		{"LatestBlock", func() []*Block { return []*Block{bc.LatestBlock()} }},
+		{"BlockRange", func() []*Block { blocks, _ := bc.BlockRange(0, 100); return blocks }},
+		{"OutlierBlocks", bc.OutlierBlocks},
+		{"SearchText", func() []*Block { return bc.SearchText("") }},
+		{"Iterator", func() []*Block {
+			var blocks []*Block
+			for it := bc.Iterator(); ; {
+				block, ok := it.Next()
+				if !ok {
+					return blocks
+				}
+				blocks = append(blocks, block)
+			}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			blocks := tc.get()
+			if len(blocks) == 0 || blocks[0] == nil {
+				t.Fatalf("%s returned no blocks", tc.name)
+			}
+			for _, block := range blocks {
+				mutateValue(reflect.ValueOf(block).Elem())
+			}
+			if got := bc.Blocks(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("changing the blocks %s returned changed the chain", tc.name)
+			}
+			if err := bc.Validate(); err != nil {
+				t.Fatalf("changing the blocks %s returned broke the chain: %v", tc.name, err)
+			}
+		})
+	}
+}
+
+func TestSearchText(t *testing.T) {
+	bc := newFilledChain(t)
+	for _, text := range []string{"Kalibrierung Sensor A", "Lauf 7", "", "kalibrierung sensor b"} {
+		if err := bc.AddBlockWithText([]float64{1, 2, 3}, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"KALIBRIERUNG", []string{"Kalibrierung Sensor A", "kalibrierung sensor b"}},
+		{"lauf", []string{"Lauf 7"}},
+		{"", []string{"Kalibrierung Sensor A", "Lauf 7", "kalibrierung sensor b"}},
+		{"druck", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, block := range bc.SearchText(tt.query) {
+			got = append(got, block.Text)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("search for %q found %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	// the annotation is covered by the hash
+	index := tamper(t, bc, bc.LatestBlock().Index-1, func(block *Block) { block.Text = "Lauf 8" })
+	expectInvalidAt(t, bc, index)
+}
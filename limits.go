@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrLimitExceeded is matched by every *LimitError
+var ErrLimitExceeded = errors.New("Blockgrenze überschritten")
+
+// BlockLimits bounds the size of the text and metadata stored on a block.
+// A limit of 0 disables the corresponding check.
+type BlockLimits struct {
+	MaxTextBytes    int `json:"max_text_bytes"`
+	MaxMetadataKeys int `json:"max_metadata_keys"`
+	MaxKeyLength    int `json:"max_key_length"`
+	MaxValueLength  int `json:"max_value_length"`
+}
+
+// DefaultBlockLimits are the limits of a new Blockchain
+var DefaultBlockLimits = BlockLimits{
+	MaxTextBytes:    64 * 1024,
+	MaxMetadataKeys: 64,
+	MaxKeyLength:    128,
+	MaxValueLength:  1024,
+}
+
+// LimitError reports which limit a block payload exceeded
+type LimitError struct {
+	Field  string
+	Limit  int
+	Actual int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s überschreitet die Grenze: %d > %d", e.Field, e.Actual, e.Limit)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// Check returns a *LimitError for the first limit text or metadata exceeds
+func (l BlockLimits) Check(text string, metadata map[string]string) error {
+	if l.MaxTextBytes > 0 && len(text) > l.MaxTextBytes {
+		return &LimitError{Field: "Text", Limit: l.MaxTextBytes, Actual: len(text)}
+	}
+	if l.MaxMetadataKeys > 0 && len(metadata) > l.MaxMetadataKeys {
+		return &LimitError{Field: "Metadaten-Schlüssel", Limit: l.MaxMetadataKeys, Actual: len(metadata)}
+	}
+	for key, value := range metadata {
+		if l.MaxKeyLength > 0 && len(key) > l.MaxKeyLength {
+			return &LimitError{Field: fmt.Sprintf("Metadaten-Schlüssel %.32q", key), Limit: l.MaxKeyLength, Actual: len(key)}
+		}
+		if l.MaxValueLength > 0 && len(value) > l.MaxValueLength {
+			return &LimitError{Field: fmt.Sprintf("Metadaten-Wert %q", key), Limit: l.MaxValueLength, Actual: len(value)}
+		}
+	}
+	return nil
+}
+
+// SetLimits replaces the limits applied to new blocks
+func (bc *Blockchain) SetLimits(limits BlockLimits) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.limits = limits
+}
+
+// Limits returns the limits applied to new blocks
+func (bc *Blockchain) Limits() BlockLimits {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.limits
+}
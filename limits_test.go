@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadOversizedLegacyBlock(t *testing.T) {
+	// an old version wrote the block without limits
+	old, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.SetLimits(BlockLimits{})
+	text := strings.Repeat("x", DefaultBlockLimits.MaxTextBytes+1)
+	if err := old.AddBlockWithText([]float64{1, 2, 3}, text); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.AddBlock([]float64{4, 5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "alt.json")
+	if err := old.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	bc, err := LoadBlockchainFromFile(path)
+	if err != nil {
+		t.Fatalf("oversized legacy block was refused: %v", err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("Block 1: Text überschreitet die Grenze: %d > %d", len(text), DefaultBlockLimits.MaxTextBytes)
+	if warnings := bc.ValidationWarnings(); len(warnings) != 1 || warnings[0] != want {
+		t.Fatalf("warnings are %q, want %q", warnings, want)
+	}
+	// new blocks are held to the limits
+	if err := bc.AddBlockWithText([]float64{7}, text); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("oversized new block returned %v, want ErrLimitExceeded", err)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NumberLocale selects the decimal and thousands separators used when
+// parsing numbers from imports and manual input
+type NumberLocale string
+
+const (
+	// LocaleAuto infers the separators per column and rejects columns it
+	// cannot decide
+	LocaleAuto NumberLocale = "auto"
+	// LocaleGerman uses a decimal comma and points between thousands
+	LocaleGerman NumberLocale = "de"
+	// LocaleEnglish uses a decimal point and commas between thousands
+	LocaleEnglish NumberLocale = "en"
+)
+
+var (
+	numberLocaleMu sync.RWMutex
+	numberLocale   = LocaleAuto
+)
+
+// SetNumberLocale changes how imported and entered numbers are parsed
+func SetNumberLocale(locale NumberLocale) error {
+	switch locale {
+	case LocaleAuto, LocaleGerman, LocaleEnglish:
+	default:
+		return fmt.Errorf("Unbekanntes Zahlenformat: %s", locale)
+	}
+
+	numberLocaleMu.Lock()
+	defer numberLocaleMu.Unlock()
+	numberLocale = locale
+	return nil
+}
+
+// currentNumberLocale returns the locale set with SetNumberLocale
+func currentNumberLocale() NumberLocale {
+	numberLocaleMu.RLock()
+	defer numberLocaleMu.RUnlock()
+	return numberLocale
+}
+
+// NumberFormatError lists the rows of a column whose numbers could not be
+// parsed or whose format is ambiguous. Rows are counted from 1.
+type NumberFormatError struct {
+	Column int
+	Rows   []int
+	Reason string
+}
+
+func (e *NumberFormatError) Error() string {
+	rows := make([]string, len(e.Rows))
+	for i, row := range e.Rows {
+		rows[i] = strconv.Itoa(row)
+	}
+	return fmt.Sprintf("Spalte %d: %s in Zeile %s", e.Column, e.Reason, strings.Join(rows, ", "))
+}
+
+// parseNumberRecords converts text records to numbers, deciding the
+// locale per column when locale is LocaleAuto
+func parseNumberRecords(records [][]string, locale NumberLocale) ([][]float64, error) {
+	locales, err := recordLocales(records, locale)
+	if err != nil {
+		return nil, err
+	}
+	columns := len(locales)
+
+	data := make([][]float64, len(records))
+	failed := make([][]int, columns)
+	for i, record := range records {
+		for col, text := range record {
+			value, err := parseLocaleNumber(text, locales[col])
+			if err != nil {
+				failed[col] = append(failed[col], i+1)
+				continue
+			}
+			data[i] = append(data[i], value)
+		}
+	}
+	for col, rows := range failed {
+		if len(rows) > 0 {
+			return nil, &NumberFormatError{Column: col + 1, Rows: rows, Reason: fmt.Sprintf("keine gültige Zahl im Format %s", locales[col])}
+		}
+	}
+	return data, nil
+}
+
+// recordLocales returns the locale of every column of records, inferring
+// it per column when locale is LocaleAuto
+func recordLocales(records [][]string, locale NumberLocale) ([]NumberLocale, error) {
+	columns := 0
+	for _, record := range records {
+		columns = max(columns, len(record))
+	}
+
+	locales := make([]NumberLocale, columns)
+	for col := range locales {
+		locales[col] = locale
+		if locale != LocaleAuto {
+			continue
+		}
+		resolved, err := inferColumnLocale(records, col)
+		if err != nil {
+			return nil, err
+		}
+		locales[col] = resolved
+	}
+	return locales, nil
+}
+
+// extendLocales returns locales extended to every column of record. The
+// locales of columns not seen before are inferred from record alone.
+func extendLocales(locales []NumberLocale, record []string, locale NumberLocale) ([]NumberLocale, error) {
+	if len(record) <= len(locales) {
+		return locales, nil
+	}
+	extra, err := recordLocales([][]string{record}, locale)
+	var formatErr *NumberFormatError
+	if errors.As(err, &formatErr) {
+		return locales, fmt.Errorf("Spalte %d: %s", formatErr.Column, formatErr.Reason)
+	}
+	if err != nil {
+		return locales, err
+	}
+	return append(locales, extra[len(locales):]...), nil
+}
+
+// parseNumberRecord converts one record with the given column locales,
+// failing on the first cell that is blank or not a number. label names a
+// column, counted from 0, in errors.
+func parseNumberRecord(record []string, locales []NumberLocale, label func(col int) string) ([]float64, error) {
+	values := make([]float64, 0, len(record))
+	for col, text := range record {
+		if strings.TrimSpace(text) == "" {
+			return nil, fmt.Errorf("Spalte %s ist leer", label(col))
+		}
+		value, err := parseLocaleNumber(text, locales[col])
+		if err != nil {
+			return nil, fmt.Errorf("Spalte %s: %q ist keine gültige Zahl im Format %s", label(col), text, locales[col])
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// parseNumberList parses a line of numbers separated by spaces or
+// semicolons using the current locale
+func parseNumberList(line string) ([]float64, error) {
+	fields := strings.FieldsFunc(line, func(r rune) bool {
+		return r == ';' || r == ' ' || r == '\t'
+	})
+	records := make([][]string, len(fields))
+	for i, field := range fields {
+		records[i] = []string{field}
+	}
+
+	data, err := parseNumberRecords(records, currentNumberLocale())
+	if err != nil {
+		return nil, err
+	}
+	values := make([]float64, len(data))
+	for i, row := range data {
+		values[i] = row[0]
+	}
+	return values, nil
+}
+
+// numberEvidence is what a single number reveals about its locale
+type numberEvidence int
+
+const (
+	// evidenceNone: no separators, parses the same in every locale
+	evidenceNone numberEvidence = iota
+	// evidenceHint: a single separator that can only be decimal
+	evidenceHint
+	// evidenceDefinite: both separators, or a repeated thousands separator
+	evidenceDefinite
+	// evidenceAmbiguous: a single separator followed by three digits
+	evidenceAmbiguous
+)
+
+// classifyNumber returns what text reveals about its locale
+func classifyNumber(text string) (NumberLocale, numberEvidence) {
+	text = strings.TrimLeft(strings.TrimSpace(text), "+-")
+	points, commas := strings.Count(text, "."), strings.Count(text, ",")
+
+	switch {
+	case points == 0 && commas == 0:
+		return "", evidenceNone
+	case points > 0 && commas > 0:
+		if strings.LastIndex(text, ",") > strings.LastIndex(text, ".") {
+			return LocaleGerman, evidenceDefinite
+		}
+		return LocaleEnglish, evidenceDefinite
+	case points > 1:
+		return LocaleGerman, evidenceDefinite
+	case commas > 1:
+		return LocaleEnglish, evidenceDefinite
+	}
+
+	sep := "."
+	decimal := LocaleEnglish
+	if commas == 1 {
+		sep, decimal = ",", LocaleGerman
+	}
+	before, after, _ := strings.Cut(text, sep)
+	if len(after) == 3 && isDigits(after) && len(before) >= 1 && len(before) <= 3 && isDigits(before) && before[0] != '0' {
+		return "", evidenceAmbiguous
+	}
+	return decimal, evidenceHint
+}
+
+// inferColumnLocale decides the locale of column col. Numbers like
+// "1.234" are only accepted when another number in the column has both
+// separators or a repeated separator; a plain decimal like "1.5" is not
+// enough to decide them.
+func inferColumnLocale(records [][]string, col int) (NumberLocale, error) {
+	definite := map[NumberLocale][]int{}
+	hints := map[NumberLocale][]int{}
+	var ambiguous []int
+	for i, record := range records {
+		if col >= len(record) {
+			continue
+		}
+		locale, evidence := classifyNumber(record[col])
+		switch evidence {
+		case evidenceDefinite:
+			definite[locale] = append(definite[locale], i+1)
+		case evidenceHint:
+			hints[locale] = append(hints[locale], i+1)
+		case evidenceAmbiguous:
+			ambiguous = append(ambiguous, i+1)
+		}
+	}
+
+	if locale, err := pickLocale(definite, col); err != nil || locale != "" {
+		return locale, err
+	}
+	if len(ambiguous) > 0 {
+		return "", &NumberFormatError{Column: col + 1, Rows: ambiguous, Reason: "mehrdeutiges Zahlenformat, bitte Zahlenformat angeben"}
+	}
+	if locale, err := pickLocale(hints, col); err != nil || locale != "" {
+		return locale, err
+	}
+	return LocaleEnglish, nil
+}
+
+// pickLocale returns the only locale with evidence, "" if there is none,
+// and an error listing the minority's rows if both have some
+func pickLocale(evidence map[NumberLocale][]int, col int) (NumberLocale, error) {
+	de, en := evidence[LocaleGerman], evidence[LocaleEnglish]
+	switch {
+	case len(de) > 0 && len(en) > 0:
+		rows := en
+		if len(de) < len(en) {
+			rows = de
+		}
+		return "", &NumberFormatError{Column: col + 1, Rows: rows, Reason: "widersprüchliche Zahlenformate"}
+	case len(de) > 0:
+		return LocaleGerman, nil
+	case len(en) > 0:
+		return LocaleEnglish, nil
+	default:
+		return "", nil
+	}
+}
+
+// parseLocaleNumber parses text with the separators of locale. Thousands
+// separators must group exactly three digits.
+func parseLocaleNumber(text string, locale NumberLocale) (float64, error) {
+	decimal, thousands := ".", ","
+	if locale == LocaleGerman {
+		decimal, thousands = ",", "."
+	}
+
+	text = strings.TrimSpace(text)
+	whole, frac, hasFrac := strings.Cut(text, decimal)
+	if strings.Contains(frac, thousands) {
+		return 0, fmt.Errorf("Ungültige Zahl: %s", text)
+	}
+	if strings.Contains(whole, thousands) {
+		sign := ""
+		if whole != "" && (whole[0] == '-' || whole[0] == '+') {
+			sign, whole = whole[:1], whole[1:]
+		}
+		groups := strings.Split(whole, thousands)
+		for i, group := range groups {
+			if !isDigits(group) || (i == 0 && len(group) > 3) || (i > 0 && len(group) != 3) {
+				return 0, fmt.Errorf("Ungültige Zahl: %s", text)
+			}
+		}
+		whole = sign + strings.Join(groups, "")
+	}
+
+	normalized := whole
+	if hasFrac {
+		normalized += "." + frac
+	}
+	return strconv.ParseFloat(normalized, 64)
+}
+
+func isDigits(text string) bool {
+	if text == "" {
+		return false
+	}
+	for _, r := range text {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
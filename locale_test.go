@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseLocaleNumber(t *testing.T) {
+	tests := []struct {
+		text   string
+		locale NumberLocale
+		want   float64
+		ok     bool
+	}{
+		{"1,5", LocaleGerman, 1.5, true},
+		{"1.234,5", LocaleGerman, 1234.5, true},
+		{"-1.234.567", LocaleGerman, -1234567, true},
+		{"1.5", LocaleEnglish, 1.5, true},
+		{"1,234.5", LocaleEnglish, 1234.5, true},
+		{" 42 ", LocaleEnglish, 42, true},
+		{"1.5", LocaleGerman, 0, false},
+		{"12,34.5", LocaleEnglish, 0, false},
+		{"1,5,0", LocaleGerman, 0, false},
+		{"1.234,5", LocaleEnglish, 0, false},
+		{"abc", LocaleEnglish, 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseLocaleNumber(tt.text, tt.locale)
+		if (err == nil) != tt.ok || tt.ok && got != tt.want {
+			t.Fatalf("%q in %s is %v, %v, want %v", tt.text, tt.locale, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseNumberRecordsAuto(t *testing.T) {
+	tests := []struct {
+		name    string
+		records [][]string
+		want    []float64
+		rows    []int
+	}{
+		{"decimal comma", [][]string{{"1,5"}, {"2"}}, []float64{1.5, 2}, nil},
+		{"thousands point decided by another row", [][]string{{"1.234"}, {"1.234,5"}}, []float64{1234, 1234.5}, nil},
+		{"thousands comma decided by another row", [][]string{{"1,234"}, {"2,345,678"}}, []float64{1234, 2345678}, nil},
+		{"ambiguous", [][]string{{"1.234"}, {"7"}, {"2.500"}}, nil, []int{1, 3}},
+		{"contradicting", [][]string{{"1,5"}, {"2,5"}, {"3.5"}}, nil, []int{3}},
+		{"plain numbers", [][]string{{"1"}, {"-2"}}, []float64{1, -2}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := parseNumberRecords(tt.records, LocaleAuto)
+			if tt.rows != nil {
+				var formatErr *NumberFormatError
+				if !errors.As(err, &formatErr) || formatErr.Column != 1 || !slices.Equal(formatErr.Rows, tt.rows) {
+					t.Fatalf("error is %v, want rows %v", err, tt.rows)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []float64
+			for _, row := range data {
+				got = append(got, row...)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("parsed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNumberList(t *testing.T) {
+	t.Cleanup(func() { SetNumberLocale(LocaleAuto) })
+	if err := SetNumberLocale(LocaleGerman); err != nil {
+		t.Fatal(err)
+	}
+	values, err := parseNumberList("1,5; 2 1.000")
+	if err != nil || !slices.Equal(values, []float64{1.5, 2, 1000}) {
+		t.Fatalf("German list is %v, %v", values, err)
+	}
+	if err := SetNumberLocale(LocaleEnglish); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseNumberList("1,5"); err == nil {
+		t.Fatal("decimal comma accepted in English")
+	}
+	if err := SetNumberLocale("fr"); err == nil {
+		t.Fatal("unknown locale accepted")
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+)
+
+// indexMagic starts the index file of a block log, followed by its format
+// version
+const indexMagic = "BDSI\x01"
+
+// logIndexSuffix is appended to the path of a block log for its index file
+const logIndexSuffix = ".idx"
+
+// indexSampleSize is how many entries of an index file recovery compares
+// with the blocks of the log before taking the indexes from it
+const indexSampleSize = 64
+
+// errIndexMismatch is returned for an index file that does not describe
+// the blocks of its log
+var errIndexMismatch = errors.New("Indexdatei passt nicht zum Blockprotokoll")
+
+// logIndex is the index file of a block log: for every block of the log,
+// in order, a record framed as in the log holding the index, the ID and
+// the hash of the block. It is appended to with every block and written
+// anew only when the log is rewritten, so the ID and hash indexes of the
+// chain are never saved wholesale. Recovery takes them from the file
+// instead of rebuilding them, and rebuilds the file from the chain if it
+// is missing, damaged or does not match.
+//
+// Appends are not synced: a lost tail is indexed again from the log.
+type logIndex struct {
+	file *os.File
+	path string
+}
+
+// indexEntry is one record of an index file
+type indexEntry struct {
+	Index int
+	ID    string
+	Hash  string
+}
+
+// frameRecord returns payload as a record of a block log or index file:
+// its length and CRC-32C, 4 bytes big endian each, followed by payload
+func frameRecord(payload []byte) []byte {
+	record := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, logTable))
+	return append(record, payload...)
+}
+
+// indexRecord returns the record of block in an index file
+func indexRecord(block *Block) []byte {
+	payload := binary.AppendUvarint(nil, uint64(block.Index))
+	payload = binary.AppendUvarint(payload, uint64(len(block.ID)))
+	payload = append(payload, block.ID...)
+	payload = binary.AppendUvarint(payload, uint64(len(block.Hash)))
+	payload = append(payload, block.Hash...)
+	return frameRecord(payload)
+}
+
+// decodeIndexEntry decodes the payload of an index record
+func decodeIndexEntry(payload []byte) (indexEntry, bool) {
+	index, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return indexEntry{}, false
+	}
+	payload = payload[n:]
+	var fields [2]string
+	for i := range fields {
+		length, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < length {
+			return indexEntry{}, false
+		}
+		fields[i] = string(payload[n : n+int(length)])
+		payload = payload[n+int(length):]
+	}
+	return indexEntry{Index: int(index), ID: fields[0], Hash: fields[1]}, len(payload) == 0
+}
+
+// readLogIndex reads the index file at path and returns its entries and
+// the length of the file they take up. A record cut short at the end, by
+// a crash while it was appended, ends the entries; a damaged record is an
+// error.
+func readLogIndex(path string) ([]indexEntry, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !bytes.HasPrefix(data, []byte(indexMagic)) {
+		return nil, 0, errors.New("keine Indexdatei")
+	}
+	var entries []indexEntry
+	offset := len(indexMagic)
+	for len(data)-offset >= 8 {
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		end := offset + 8 + length
+		if length > maxLogRecord || end > len(data) {
+			break
+		}
+		payload := data[offset+8 : end]
+		if crc32.Checksum(payload, logTable) != binary.BigEndian.Uint32(data[offset+4:offset+8]) {
+			if end == len(data) {
+				break
+			}
+			return nil, 0, fmt.Errorf("Eintrag bei Byte %d ist beschädigt", offset)
+		}
+		entry, ok := decodeIndexEntry(payload)
+		if !ok {
+			return nil, 0, fmt.Errorf("Eintrag bei Byte %d ist ungültig", offset)
+		}
+		entries = append(entries, entry)
+		offset = end
+	}
+	return entries, int64(offset), nil
+}
+
+// matchIndex reports whether entries index the first blocks: there must
+// be no more entries than blocks, and indexSampleSize entries spread from
+// the first to the last must name the index, ID and hash of the block at
+// their position
+func matchIndex(entries []indexEntry, blocks []*Block) bool {
+	if len(entries) > len(blocks) {
+		return false
+	}
+	if len(entries) == 0 {
+		return true
+	}
+	for i := 0; i < indexSampleSize; i++ {
+		pos := i * (len(entries) - 1) / (indexSampleSize - 1)
+		entry, block := entries[pos], blocks[pos]
+		if entry.Index != block.Index || entry.ID != block.ID || entry.Hash != block.Hash {
+			return false
+		}
+	}
+	return true
+}
+
+// createLogIndex writes the index file of blocks at path, replacing an
+// existing one only once it is complete, and opens it for appending
+func createLogIndex(path string, blocks []*Block) (*logIndex, error) {
+	var data bytes.Buffer
+	data.WriteString(indexMagic)
+	for _, block := range blocks {
+		data.Write(indexRecord(block))
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data.Bytes(), 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return openLogIndex(path, int64(data.Len()), nil)
+}
+
+// openLogIndex opens the index file at path for appending, cut back to
+// size, and appends the records of blocks
+func openLogIndex(path string, size int64, blocks []*Block) (*logIndex, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(size); err == nil {
+		_, err = file.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	for _, block := range blocks {
+		if _, err := file.Write(indexRecord(block)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &logIndex{file: file, path: path}, nil
+}
+
+// resetIndex writes the index file of the log anew for blocks, the
+// blocks the log holds. A failure is logged and leaves the log without an
+// index file, which the next recovery rebuilds.
+func (l *blockLog) resetIndex(blocks []*Block) {
+	l.closeIndex()
+	index, err := createLogIndex(l.path+logIndexSuffix, blocks)
+	if err != nil {
+		l.dropIndex(err)
+		return
+	}
+	l.index = index
+}
+
+// resumeIndex opens the index file of a recovered log for appending. Its
+// entries, read from size bytes, were checked by matchIndex if used is
+// not negative; the blocks after them are appended. Otherwise, or if
+// readErr is set, the file is rebuilt from blocks.
+func (l *blockLog) resumeIndex(blocks []*Block, used int, size int64, readErr error) {
+	path := l.path + logIndexSuffix
+	switch {
+	case readErr != nil && errors.Is(readErr, os.ErrNotExist):
+		log.Printf("Indexdatei %s fehlt und wird aus dem Blockprotokoll aufgebaut", path)
+	case readErr != nil:
+		log.Printf("Indexdatei %s wird aus dem Blockprotokoll neu aufgebaut: %v", path, readErr)
+	case used < 0:
+		log.Printf("Indexdatei %s wird aus dem Blockprotokoll neu aufgebaut: %v", path, errIndexMismatch)
+	default:
+		index, err := openLogIndex(path, size, blocks[used:])
+		if err == nil {
+			l.index = index
+			return
+		}
+		log.Printf("Indexdatei %s wird aus dem Blockprotokoll neu aufgebaut: %v", path, err)
+	}
+	l.resetIndex(blocks)
+}
+
+// appendIndex appends the record of block, just added to the log, to the
+// index file. A failure is logged and removes the index file, which the
+// next recovery rebuilds.
+func (l *blockLog) appendIndex(block *Block) {
+	if l.index == nil {
+		return
+	}
+	if _, err := l.index.file.Write(indexRecord(block)); err != nil {
+		l.dropIndex(err)
+	}
+}
+
+// dropIndex logs err and removes the index file, so that a stale file is
+// not taken for the indexes of the log
+func (l *blockLog) dropIndex(err error) {
+	log.Printf("Indexdatei von %s nicht fortgeschrieben, sie wird beim nächsten Start neu aufgebaut: %v", l.path, err)
+	l.closeIndex()
+	os.Remove(l.path + logIndexSuffix)
+}
+
+// closeIndex closes the index file, if it is open
+func (l *blockLog) closeIndex() error {
+	if l.index == nil {
+		return nil
+	}
+	err := l.index.file.Close()
+	l.index = nil
+	return err
+}
@@ -1,46 +1,285 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/sha256"
-	"encoding/csv"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log"
+	"maps"
 	"math"
-	"math/rand"
 	"os"
+	"slices"
 	"sort"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 // Block struct
 type Block struct {
-	Index      int
-	Timestamp  time.Time
-	Values     []float64
-	Hash       string
-	PrevHash   string
-	Mean       float64
-	Median     float64
-	TwoSDLower float64
-	TwoSDUpper float64
-	Outliers   []float64
-	Text       string
+	Index      int       `json:"index"`
+	ID         string    `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Values     []float64 `json:"values"`
+	Hash       string    `json:"hash"`
+	PrevHash   string    `json:"prev_hash"`
+	Mean       float64   `json:"mean"`
+	Median     float64   `json:"median"`
+	TwoSDLower float64   `json:"two_sd_lower"`
+	TwoSDUpper float64   `json:"two_sd_upper"`
+	Outliers   []float64 `json:"outliers"`
+	// MerkleRoot is the root of the Merkle tree over Values, see
+	// MerkleProof; blocks of a KindInt chain have none
+	MerkleRoot string `json:"merkle_root,omitempty"`
+	// HasOutliers is set when the block's stats found outliers
+	HasOutliers bool `json:"has_outliers"`
+	// OutlierMethod is set when the outliers were not found with the 2-SD
+	// rule, e.g. "sd:3" or "iqr"; LowerBound and UpperBound then hold the
+	// bounds that were used
+	OutlierMethod string  `json:"outlier_method,omitempty"`
+	LowerBound    float64 `json:"lower_bound,omitempty"`
+	UpperBound    float64 `json:"upper_bound,omitempty"`
+	// Difficulty is the number of leading zero hex digits the block was
+	// mined to, by incrementing Nonce; both are 0 for blocks not mined
+	Difficulty int    `json:"difficulty,omitempty"`
+	Nonce      uint64 `json:"nonce,omitempty"`
+	// HashVersion selects the encoding of the hash input, see
+	// currentHashVersion
+	HashVersion int               `json:"hash_version"`
+	Text        string            `json:"text,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+
+	// ValueOrigins, parallel to Values, names the block and position or
+	// the import row each value came from, see TraceValue. Blocks only
+	// keep them with SetTrackOrigins, and exports only write them with
+	// SetExportOrigins. They are not covered by the hash.
+	ValueOrigins []Origin `json:"value_origins,omitempty"`
+
+	// ValueTimes holds the time each value was measured, parallel to
+	// Values and oldest first, for blocks added with AddTimedValues.
+	ValueTimes []time.Time `json:"value_times,omitempty"`
+
+	// ValueEncoding is set for blocks that store their values encoded in
+	// EncodedValues, see SetValueEncoding; Values is empty then. Use
+	// GetValues to read the values of a block of either kind.
+	ValueEncoding ValueEncoding `json:"value_encoding,omitempty"`
+	EncodedValues []byte        `json:"encoded_values,omitempty"`
+
+	// Kind is KindInt for blocks of a KindInt chain, which keep their
+	// values in IntValues instead of Values
+	Kind        ValueKind `json:"kind"`
+	IntValues   []int64   `json:"int_values,omitempty"`
+	IntOutliers []int64   `json:"int_outliers,omitempty"`
+	IntStats    *IntStats `json:"int_stats,omitempty"`
+
+	// Percentiles and Histogram summarize the distribution of all values,
+	// computed before sampling. Percentiles are keyed "p5" to "p99";
+	// Histogram counts equal-width buckets from the smallest to the largest
+	// value.
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+	Histogram   []int              `json:"histogram,omitempty"`
+
+	// Sampled blocks store a reservoir sample of their values; the stats
+	// and outliers were computed from all OriginalCount values
+	Sampled       bool    `json:"sampled,omitempty"`
+	OriginalCount int     `json:"original_count,omitempty"`
+	SampleMin     float64 `json:"sample_min,omitempty"`
+	SampleMax     float64 `json:"sample_max,omitempty"`
+
+	// ContentHash identifies the values the block was submitted with, in
+	// their order, see SetDeduplication; it is not covered by the hash
+	ContentHash string `json:"content_hash,omitempty"`
+
+	// OutlierContexts holds the values around each outlier when enabled
+	// with SetOutlierContext; it is not covered by the hash
+	OutlierContexts []OutlierContext `json:"outlier_contexts,omitempty"`
+
+	// Binned is the histogram enabled with SetHistogramBins, computed from
+	// all values before sampling; it is not covered by the hash
+	Binned *BinnedValues `json:"binned,omitempty"`
+
+	// Quality is scored from the block's signals when it is created, with
+	// the weights in effect then; it is not covered by the hash
+	Quality *QualityScore `json:"quality,omitempty"`
+
+	// AnomalyScore is the distance of Mean from the baseline of the blocks
+	// before, in baseline SDs, and Anomalous is set above the threshold,
+	// see SetAnomalyDetection; they are not covered by the hash
+	AnomalyScore float64 `json:"anomaly_score,omitempty"`
+	Anomalous    bool    `json:"anomalous,omitempty"`
+
+	// References link the block to external records; they can be changed
+	// after creation and are not covered by the hash
+	References []Reference `json:"references,omitempty"`
+
+	// StatsErrors maps the stats stages that panicked to their error; the
+	// block then has status StatusDegraded. It is not covered by the hash.
+	StatsErrors map[string]string `json:"stats_errors,omitempty"`
+
+	// Status is assigned by the rule engine and not covered by the hash
+	Status string `json:"status,omitempty"`
+
+	// Signature is the Ed25519 signature of Hash by SignerPublicKey, set
+	// for chains with a signer, see SetSigner
+	Signature       []byte `json:"signature,omitempty"`
+	SignerPublicKey []byte `json:"signer_public_key,omitempty"`
+
+	// ValuesDropped is set once the retention policy dropped the value
+	// arrays of the block, whose stats remain; Dropped then summarizes
+	// them, see SetRetentionPolicy
+	ValuesDropped bool           `json:"values_dropped,omitempty"`
+	Dropped       *DroppedValues `json:"dropped,omitempty"`
+
+	// Extensions holds the fields of a block read from JSON that this
+	// version does not know, keyed by name, so that writing the block
+	// again keeps them; they are not covered by the hash
+	Extensions map[string]json.RawMessage `json:"-"`
 }
 
 // Blockchain struct
 type Blockchain struct {
-	chain []*Block
-	mu    sync.Mutex
+	// storage holds the blocks, see Storage; held is the number of blocks
+	// and head the newest one, kept so appends need not read the storage
+	storage Storage
+	held    int
+	head    *Block
+
+	mu        sync.RWMutex
+	limits    BlockLimits
+	bounds    *ValueBounds
+	rules     []Rule
+	valueKind ValueKind
+
+	idScheme  IDScheme
+	idIndex   map[string]int
+	hashIndex map[string][]hashEntry
+
+	clock           Clock
+	timestampPolicy TimestampPolicy
+	nonMonotonic    int
+
+	memLimits MemoryLimits
+	memUsage  int64
+	memWarned bool
+
+	boundsViolations int
+
+	// log, if set, receives every new block before it is appended
+	log *blockLog
+	// rewrites counts the changes of blocks already appended, so that a
+	// Confirmer can tell whether the chain changed other than by appends
+	rewrites int
+	// recovery is the report of RecoverFromLog until it is acknowledged
+	recovery *RecoveryReport
+	// counters are read by Metrics without bc.mu
+	counters chainCounters
+	// subscribers receive an event for every block appended
+	subscribers map[*subscriber]struct{}
+
+	// totals aggregates the values of all blocks for AggregateStats
+	totals chainTotals
+
+	outliers   OutlierConfig
+	difficulty int
+
+	trackOrigins  bool
+	exportOrigins bool
+	sampleSize    int
+
+	contextWindow    int
+	contextMaxValues int
+
+	bins             *HistogramBins
+	histogramBuckets int
+
+	trendFlatThreshold float64
+
+	// anomalyWindow is the number of recent blocks new ones are compared
+	// with, 0 if they are not
+	anomalyWindow    int
+	anomalyThreshold float64
+
+	// valueEncoding is how new float blocks store their values
+	valueEncoding ValueEncoding
+
+	// signer signs every new block if set
+	signer ed25519.PrivateKey
+
+	// verifyOnRead rehashes blocks as they are read, see SetVerifyOnRead
+	verifyOnRead bool
+
+	// codec compresses the files the chain writes, see SetCodec
+	codec string
+
+	// dedupWindow is the number of recent blocks a new batch must differ
+	// from, 0 if duplicates are allowed
+	dedupWindow int
+
+	qualityWeights QualityWeights
+
+	// base is the index of the oldest block held; it is above 0 for
+	// chains bootstrapped from a checkpoint, whose first block is the
+	// checkpoint record while stub is set
+	base       int
+	stub       bool
+	checkpoint *Checkpoint
+	// archive is set once Prune moved the blocks before base to a file
+	archive *archiveRef
+	// retention is the policy Compact applies; expired is set once it
+	// deleted blocks
+	retention RetentionPolicy
+	expired   *expiredRef
+
+	name string
+	fork *ForkOrigin
+	// genesis describes the genesis block, nil while it is not known
+	genesis *ChainInfo
+
+	// buffer holds the values AddValues has not cut into a block yet
+	bufferMu          sync.Mutex
+	buffer            []float64
+	maxValuesPerBlock int
 }
 
-// NewBlockchain creates a new Blockchain
-func NewBlockchain() *Blockchain {
+// NewBlockchain creates a new Blockchain configured by opts. Without
+// options the chain is kept in memory only; the error is for the options
+// that resume a chain, such as WithPersistence.
+func NewBlockchain(opts ...Option) (*Blockchain, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o.open()
+}
+
+// newBlockchain creates a chain holding a new genesis block with the
+// default settings, which the constructors build on. The genesis carries
+// cfg in its Metadata, so it is covered by the genesis hash and chains of
+// different names never share a genesis.
+func newBlockchain(cfg ChainConfig) *Blockchain {
+	now := time.Now()
+	id, err := newBlockID(IDSchemeULID, now, rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return newBlockchainAt(cfg, now, id)
+}
+
+// newBlockchainAt is newBlockchain with a genesis created at now with the
+// given ID, for chains that must come out the same on every run
+func newBlockchainAt(cfg ChainConfig, now time.Time, id string) *Blockchain {
 	genesisBlock := &Block{
 		Index:      0,
-		Timestamp:  time.Now(),
+		ID:         id,
+		Timestamp:  now,
 		Values:     nil,
 		Hash:       "",
 		PrevHash:   "",
@@ -50,91 +289,298 @@ func NewBlockchain() *Blockchain {
 		TwoSDUpper: 0.0,
 		Outliers:   nil,
 		Text:       "",
+		Status:     StatusOK,
+		Kind:       KindFloat,
+
+		HashVersion: currentHashVersion,
 	}
+	genesisBlock.Metadata = cfg.metadata()
 	genesisBlock.Hash = calculateHash(genesisBlock)
 
-	return &Blockchain{
-		chain: []*Block{genesisBlock},
+	bc := &Blockchain{
+		storage:   &memoryStorage{blocks: []*Block{genesisBlock}},
+		held:      1,
+		head:      genesisBlock,
+		limits:    DefaultBlockLimits,
+		valueKind: KindFloat,
+		idScheme:  IDSchemeULID,
+		idIndex:   map[string]int{id: 0},
+		memUsage:  estimateBlockSize(genesisBlock),
+
+		clock:           realClock{},
+		timestampPolicy: TimestampAllow,
+		qualityWeights:  DefaultQualityWeights,
+		outliers:        DefaultOutlierConfig,
+		hashIndex:       map[string][]hashEntry{},
+
+		histogramBuckets:   defaultHistogramBuckets,
+		trendFlatThreshold: defaultTrendFlatThreshold,
+		anomalyWindow:      defaultAnomalyWindow,
+		anomalyThreshold:   defaultAnomalyThreshold,
+	}
+	bc.indexHash(genesisBlock.Hash, 0)
+	bc.genesis = genesisInfo(genesisBlock)
+	bc.name = cfg.Name
+	return bc
+}
+
+// AddBlock adds a new block to the blockchain and returns a copy of it.
+// values must hold at least one value and only finite ones; otherwise an
+// *InvalidValuesError is returned.
+func (bc *Blockchain) AddBlock(values []float64) (*Block, error) {
+	block, err := bc.addBlock(blockPayload{values: values})
+	if err != nil {
+		return nil, err
 	}
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return copyBlock(block), nil
+}
+
+// AddBlockWithText adds a new block annotated with text. The text is
+// covered by the hash.
+func (bc *Blockchain) AddBlockWithText(values []float64, text string) error {
+	_, err := bc.addBlock(blockPayload{values: values, text: text})
+	return err
+}
+
+// AddBlockWithMetadata adds a new block carrying a text annotation and
+// metadata, rejecting payloads that exceed the chain's limits
+func (bc *Blockchain) AddBlockWithMetadata(values []float64, text string, metadata map[string]string) error {
+	_, err := bc.addBlock(blockPayload{values: values, text: text, metadata: metadata})
+	return err
 }
 
-// AddBlock adds a new block to the blockchain
-func (bc *Blockchain) AddBlock(values []float64) {
+// blockPayload is the caller-supplied content of a new block. Exactly one
+// of values and intValues is used, depending on the chain's value kind.
+type blockPayload struct {
+	values    []float64
+	intValues []int64
+	text      string
+	metadata  map[string]string
+	origins   []Origin
+	// times, if set, are sorted, see splitSamples, and parallel to values
+	times []time.Time
+	// references were validated by the caller
+	references []Reference
+	// expectedHead, if set, must be the hash of the head block
+	expectedHead string
+	// id, if set, is the ID of the block instead of a new one
+	id string
+}
+
+// addBlock appends a block built from the given payload and returns it
+func (bc *Blockchain) addBlock(p blockPayload) (*Block, error) {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
+	return bc.commitBlock(p, nil)
+}
 
-	prevBlock := bc.chain[len(bc.chain)-1]
-	newBlock := &Block{
-		Index:      prevBlock.Index + 1,
-		Timestamp:  time.Now(),
-		Values:     values,
-		Hash:       "",
-		PrevHash:   prevBlock.Hash,
-		Mean:       0.0,
-		Median:     0.0,
-		TwoSDLower: 0.0,
-		TwoSDUpper: 0.0,
-		Outliers:   nil,
+// commitBlock appends a block built from the given payload. The values of
+// a float block are bounded and their stats computed with the current
+// settings unless prepared already holds them. Called with bc.mu held.
+func (bc *Blockchain) commitBlock(p blockPayload, prepared *preparedBlock) (*Block, error) {
+	kind := KindFloat
+	if p.intValues != nil {
+		kind = KindInt
+	}
+	if kind != bc.valueKind {
+		return nil, fmt.Errorf("%w: %s-Werte für eine Blockchain vom Typ %s", ErrValueKindMismatch, kind, bc.valueKind)
+	}
+	if kind == KindInt && len(p.intValues) == 0 {
+		return nil, &InvalidValuesError{Position: -1}
+	}
+	if kind == KindFloat {
+		if err := checkValues(p.values); err != nil {
+			return nil, err
+		}
+	}
+	if err := bc.checkHead(p.expectedHead); err != nil {
+		return nil, err
+	}
+	if err := bc.limits.Check(p.text, p.metadata); err != nil {
+		return nil, err
+	}
+	if p.times != nil && len(p.times) != len(p.values) {
+		return nil, fmt.Errorf("Anzahl der Zeitpunkte (%d) passt nicht zu den Werten (%d)", len(p.times), len(p.values))
+	}
+	content := contentHash(kind, p.values, p.intValues, p.times)
+	if bc.dedupWindow > 0 {
+		if err := bc.checkDuplicate(content); err != nil {
+			return nil, err
+		}
 	}
-	bc.calculateBlockStats(newBlock)
-	bc.markBlocksWithOutliers()
-	newBlock.Hash = calculateHash(newBlock)
-	bc.chain = append(bc.chain, newBlock)
-}
 
-// calculateBlockStats calculates statistics for the values in a block
-func (bc *Blockchain) calculateBlockStats(block *Block) {
-	var wg sync.WaitGroup
-	wg.Add(4)
+	prevBlock := bc.head
+	timestamp, metadata, err := bc.applyTimestampPolicy(bc.clock.Now(), prevBlock.Timestamp, maps.Clone(p.metadata))
+	if err != nil {
+		return nil, err
+	}
 
-	go func() {
-		defer wg.Done()
-		block.Mean = calculateMean(block.Values)
-	}()
+	origins, times := p.origins, p.times
+	if kind == KindFloat {
+		if prepared == nil {
+			prepared = bc.statsSettings().prepare(p.values)
+		}
+		metadata = bc.recordBoundsViolations(prepared.bounds, prepared.violations, metadata)
+		if prepared.err != nil {
+			return nil, prepared.err
+		}
+		if origins != nil && len(prepared.block.Values) != len(p.values) {
+			origins = prepared.bounds.keepOrigins(p.values, origins)
+		}
+		if times != nil && len(prepared.block.Values) != len(p.values) {
+			times = prepared.bounds.keepTimes(p.values, times)
+		}
+	}
 
-	go func() {
-		defer wg.Done()
-		block.Median = calculateMedian(block.Values)
-	}()
+	id := p.id
+	if id == "" {
+		if id, err = newBlockID(bc.idScheme, timestamp, rand.Reader); err != nil {
+			return nil, err
+		}
+	}
 
-	go func() {
-		defer wg.Done()
-		block.TwoSDLower, block.TwoSDUpper = calculateTwoSDRange(block.Values)
-	}()
+	newBlock := &Block{Kind: kind}
+	var stuck int
+	var statsTime time.Duration
+	if kind == KindFloat {
+		// the values and their stats were set by prepare
+		newBlock, stuck, statsTime = prepared.block, prepared.stuck, prepared.statsTime
+	}
+	newBlock.Index = prevBlock.Index + 1
+	newBlock.ID = id
+	newBlock.Timestamp = timestamp
+	newBlock.PrevHash = prevBlock.Hash
+	newBlock.Text = p.text
+	newBlock.Metadata = metadata
+	newBlock.HashVersion = currentHashVersion
+	newBlock.ContentHash = content
+	if bc.trackOrigins && origins != nil {
+		newBlock.ValueOrigins = append([]Origin(nil), origins...)
+	}
+	if times != nil {
+		newBlock.ValueTimes = append([]time.Time{}, times...)
+	}
+	if kind == KindInt {
+		newBlock.IntValues = slices.Clone(p.intValues)
+		stuck = longestRun(newBlock.IntValues)
+		start := time.Now()
+		runStatsStage(newBlock, StageIntStats, func() { calculateIntStats(newBlock, bc.outliers) })
+		floats := make([]float64, len(newBlock.IntValues))
+		for i, v := range newBlock.IntValues {
+			floats[i] = float64(v)
+		}
+		runStatsStage(newBlock, StageSummary, func() { summarizeValues(newBlock, floats, bc.histogramBuckets) })
+		if bc.bins != nil {
+			runStatsStage(newBlock, StageHistogram, func() { newBlock.Binned = binValues(*bc.bins, floats) })
+		}
+		statsTime = time.Since(start)
+	} else {
+		sampleBlock(newBlock, bc.sampleSize)
+	}
+	runStatsStage(newBlock, StageQuality, func() { newBlock.Quality = scoreQuality(newBlock, stuck, bc.qualityWeights) })
+	if err := bc.scoreAnomaly(newBlock); err != nil {
+		return nil, err
+	}
+	newBlock.References = append([]Reference(nil), p.references...)
+	newBlock.Status = StatusOK
+	runStatsStage(newBlock, StageRules, func() { newBlock.Status = evaluateRules(bc.rules, newBlock) })
+	markDegraded(newBlock)
+	encodeBlockValues(newBlock, bc.valueEncoding)
+	if err := bc.reserveMemory(estimateBlockSize(newBlock)); err != nil {
+		return nil, err
+	}
+	newBlock.HasOutliers = newBlock.OutlierCount() > 0
+	newBlock.Difficulty = bc.difficulty
+	mineBlock(newBlock)
+	if bc.signer != nil {
+		signBlock(newBlock, bc.signer)
+	}
+	// the log is written first; if the storage then fails, the record is
+	// truncated away, so a restart does not bring back a block the chain
+	// never held
+	var logged int64
+	if bc.log != nil {
+		if logged, err = bc.log.append(newBlock); err != nil {
+			bc.memUsage -= estimateBlockSize(newBlock)
+			return nil, fmt.Errorf("Block konnte nicht protokolliert werden: %w", err)
+		}
+	}
+	if err := bc.storage.AppendBlock(newBlock); err != nil {
+		bc.memUsage -= estimateBlockSize(newBlock)
+		if bc.log != nil {
+			if undo := bc.log.truncate(logged); undo != nil {
+				log.Printf("Block %d konnte nicht aus dem Protokoll entfernt werden: %v", newBlock.Index, undo)
+			}
+		}
+		return nil, fmt.Errorf("Block konnte nicht gespeichert werden: %w", err)
+	}
+	if bc.log != nil {
+		bc.log.recordHead(newBlock, false)
+		bc.log.appendIndex(newBlock)
+	}
+	bc.idIndex[id] = bc.held
+	bc.indexHash(newBlock.Hash, bc.held)
+	bc.head = newBlock
+	bc.held++
+	bc.totals.add(newBlock)
+	bc.counters.record(statsTime)
+	bc.publish(newBlock)
+	return newBlock, nil
+}
 
+// calculateBlockStats calculates statistics for the values in a block in
+// dependency order: mean and median concurrently, then the 2-SD range
+// around the mean, then the outliers outside the bounds of the outlier
+// method. Stages whose input failed are skipped. The percentiles and the
+// histogram of buckets buckets depend on nothing else. Blocks of at least
+// parallelStatsMin values take calculateLargeBlockStats instead.
+func calculateBlockStats(block *Block, outliers OutlierConfig, buckets int) {
+	if len(block.Values) >= parallelStatsMin {
+		calculateLargeBlockStats(block, outliers, buckets)
+		return
+	}
+	runStatsStage(block, StageSummary, func() { summarizeValues(block, block.Values, buckets) })
+
+	// the median sorts its own copy and may run alongside the mean
+	var medianFailure any
+	var wg sync.WaitGroup
+	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		block.Outliers = calculateOutliers(block.Values, block.TwoSDLower, block.TwoSDUpper)
+		defer func() { medianFailure = recover() }()
+		block.Median = calculateMedian(block.Values)
 	}()
-
+	runStatsStage(block, StageMean, func() { block.Mean = calculateMean(block.Values) })
 	wg.Wait()
+	if medianFailure != nil {
+		recordStatsError(block, StageMedian, medianFailure)
+	}
+
+	if statsFailed(block, StageMean) {
+		return
+	}
+	runStatsStage(block, StageTwoSD, func() {
+		block.TwoSDLower, block.TwoSDUpper = calculateTwoSDRange(block.Values, block.Mean)
+	})
+	if statsFailed(block, StageTwoSD) {
+		return
+	}
+	runStatsStage(block, StageOutliers, func() {
+		lower, upper := applyOutlierConfig(block, outliers, block.Values)
+		block.Outliers = calculateOutliers(block.Values, lower, upper)
+	})
 }
 
 // calculateHash calculates the hash for a block
 func calculateHash(block *Block) string {
-	blockData := fmt.Sprintf("%d%d%v%s%f%f%f%f%v", block.Index, block.Timestamp.Unix(), block.Values, block.PrevHash, block.Mean, block.Median, block.TwoSDLower, block.TwoSDUpper, block.Outliers)
-	hash := sha256.Sum256([]byte(blockData))
-	return hex.EncodeToString(hash[:])
-}
-
-// generateValues generates random values every 5 seconds and adds them to the blockchain
-func generateValuesAndAddToBlockchain(bc *Blockchain) {
-	valuesChan := make(chan []float64, 10)
-
-	go func() {
-		for {
-			time.Sleep(5 * time.Second)
-			var values []float64
-			for i := 0; i < 100; i++ {
-				value := rand.Float64()
-				values = append(values, value)
-			}
-			valuesChan <- values
-		}
-	}()
-	for values := range valuesChan {
-		bc.AddBlock(values)
+	data := append(canonicalHashPayload(block), canonicalProofOfWork(block.Difficulty)...)
+	if block.Difficulty > 0 {
+		data = binary.BigEndian.AppendUint64(data, block.Nonce)
 	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
 }
 
 func calculateMean(values []float64) float64 {
@@ -144,16 +590,29 @@ func calculateMean(values []float64) float64 {
 	}
 	return sum / float64(len(values))
 }
+
+// calculateMedian returns the median of values, or NaN if there are none.
+// It sorts a copy, so values keep their insertion order.
 func calculateMedian(values []float64) float64 {
-	sort.Float64s(values)
 	n := len(values)
+	if n == 0 {
+		return math.NaN()
+	}
+	sorted := slices.Clone(values)
+	sort.Float64s(sorted)
+	return medianSorted(sorted)
+}
+
+// medianSorted returns the median of values sorted already, which must
+// not be empty
+func medianSorted(sorted []float64) float64 {
+	n := len(sorted)
 	if n%2 == 0 {
-		return (values[n/2-1] + values[n/2]) / 2.0
+		return (sorted[n/2-1] + sorted[n/2]) / 2.0
 	}
-	return values[n/2]
+	return sorted[n/2]
 }
-func calculateTwoSDRange(values []float64) (lowerBound, upperBound float64) {
-	mean := calculateMean(values)
+func calculateTwoSDRange(values []float64, mean float64) (lowerBound, upperBound float64) {
 	variance := calculateVariance(values, mean)
 	stdDev := math.Sqrt(variance)
 
@@ -177,142 +636,328 @@ func calculateVariance(values []float64, mean float64) float64 {
 	}
 	return sumSquaredDiff / float64(len(values))
 }
-func (bc *Blockchain) markBlocksWithOutliers() {
-	for _, block := range bc.chain {
-		if len(block.Outliers) > 0 {
-			block.Hash = "OUTLIER_BLOCK_HASH"
-		}
+
+// readDataFromExternalSource reads all rows of a CSV, TSV, JSON or xlsx
+// file; an empty format is inferred from the extension. Rows with cells
+// that are not numbers carry their error instead of failing the whole
+// file. The cells of an xlsx file that were skipped, such as text and
+// formulas, are returned as warnings. Large files are better read with
+// streamSourceRows.
+func readDataFromExternalSource(filePath string, format string, locale NumberLocale, csvOpts CSVImportOptions) ([]sourceRow, []string, error) {
+	var rows []sourceRow
+	var warnings []string
+	err := streamSourceRows(filePath, format, locale, csvOpts, nil, func(row sourceRow) error {
+		rows = append(rows, row)
+		warnings = append(warnings, row.Warnings...)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	return rows, warnings, nil
 }
 
-func readDataFromExternalSource(filePath string, format string) ([][]float64, error) {
-	var data [][]float64
-
-	// Öffne die Datei
-	file, err := os.Open(filePath)
+// main function
+func main() {
+	log.SetOutput(io.MultiWriter(os.Stderr, auditLog))
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+	flags, err := parseMainFlags(os.Args[0], os.Args[1:], os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	case flags.batch():
+		os.Exit(runBatch(flags, os.Stdout, os.Stderr))
+	}
+	source, err := ParseValueSource(flags.source)
 	if err != nil {
-		return nil, err
+		log.Fatalln("Ungültige Wertquelle:", err)
 	}
-	defer file.Close()
 
-	// Lese Daten je nach Dateiformat ein
-	switch format {
-	case "csv":
-		// CSV-Datei einlesen
-		reader := csv.NewReader(file)
-		records, err := reader.ReadAll()
-		if err != nil {
-			return nil, err
+	chainFile := defaultChainFile
+	chain := ChainConfig{Name: flags.chainName, Description: flags.chainDescription, CreatedBy: chainCreator()}
+	var bc *Blockchain
+	switch {
+	case flags.logPath != "":
+		chainFile = flags.logPath
+		bc, err = NewBlockchain(WithPersistence(flags.logPath), WithChainConfig(chain))
+	case flags.dbPath != "":
+		chainFile = flags.dbPath
+		var opts []Option
+		if flags.metrics || flags.slowStorage > 0 {
+			opts = append(opts, WithStorageTiming(flags.slowStorage))
 		}
+		bc, err = openSQLiteChain(flags.dbPath, chain, opts...)
+	default:
+		bc, err = loadDefaultChain(chain)
+	}
+	if err != nil {
+		log.Fatalln("Blockchain konnte nicht geladen werden:", err)
+	}
+	if blocks := bc.Length(); blocks > 1 {
+		fmt.Printf("Blockchain aus %s geladen: %d Blöcke\n", chainFile, blocks)
+	}
+	// ctx ends the background work when the menu is left
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		// Konvertiere die eingelesenen Daten in float64
-		for _, row := range records {
-			var floatRow []float64
-			for _, valueStr := range row {
-				value, err := strconv.ParseFloat(valueStr, 64)
-				if err != nil {
-					return nil, err
-				}
-				floatRow = append(floatRow, value)
+	usage, err := OpenTokenUsage(usageFile(), realClock{})
+	if err != nil {
+		log.Println("Token-Nutzung konnte nicht geladen werden:", err)
+		usage, _ = OpenTokenUsage("", realClock{})
+	}
+	usage.StartFlusher(ctx, defaultUsageFlushInterval)
+	if path := configFile(); path != "" {
+		reloader, err := NewConfigReloader(bc, path)
+		switch {
+		case err == nil:
+			reloader.ApplyTokens(usage)
+			reloader.WatchSignals(ctx)
+		case !errors.Is(err, os.ErrNotExist):
+			log.Println("Konfiguration konnte nicht geladen werden:", err)
+		}
+	}
+	bc.StartCompactor(ctx, defaultCompactInterval)
+
+	var notifier *WebhookNotifier
+	if flags.webhook != "" {
+		cfg := WebhookConfig{URL: flags.webhook, Retries: flags.webhookRetries}
+		if flags.webhookTokenFile != "" {
+			if cfg.Token, err = readWebhookFile(flags.webhookTokenFile); err != nil {
+				log.Fatalln("Webhook-Token konnte nicht gelesen werden:", err)
+			}
+		}
+		if flags.webhookSecretFile != "" {
+			if cfg.Secret, err = readWebhookFile(flags.webhookSecretFile); err != nil {
+				log.Fatalln("Webhook-Schlüssel konnte nicht gelesen werden:", err)
 			}
-			data = append(data, floatRow)
 		}
+		if cfg.DeadLetters, err = OpenDeadLetterStore(flags.deadLetters, flags.deadLetterCap); err != nil {
+			log.Fatalln("Ablage für unzustellbare Benachrichtigungen konnte nicht geöffnet werden:", err)
+		}
+		if notifier, err = NewWebhookNotifier(bc, cfg); err != nil {
+			log.Fatalln("Webhook konnte nicht eingerichtet werden:", err)
+		}
+	}
 
-	case "json":
-		// JSON-Datei einlesen
-		decoder := json.NewDecoder(file)
-		err := decoder.Decode(&data)
-		if err != nil {
-			return nil, err
+	pipeline := NewDefaultPipeline(bc)
+	queue, err := NewIngestQueue(pipeline, flags.queueSize, flags.overflow)
+	if err != nil {
+		log.Fatalln("Eingangswarteschlange konnte nicht erstellt werden:", err)
+	}
+	generator := NewGenerator(pipeline.ForSource("generator"), GeneratorConfig{
+		Source:   source,
+		Interval: flags.interval,
+		OnEvent:  logGeneratorErrors,
+	})
+	generator.Start(ctx)
+
+	confirmer := NewConfirmer(bc, flags.undoWindow, realClock{})
+	server := NewAPIServer(bc, pipeline, WithExportTTL(flags.exportTTL), WithWebhookNotifier(notifier), WithConfirmer(confirmer), WithTokenUsage(usage))
+	if flags.metrics {
+		server.ServeMetrics(NewMetricsHandler(bc, queue, generator))
+	}
+	if flags.listen != "" {
+		if err := server.Start(flags.listen); err != nil {
+			log.Fatalln("HTTP-Server konnte nicht gestartet werden:", err)
 		}
+		fmt.Println("HTTP-API auf", server.Addr())
+		if !usage.required() {
+			log.Println("WARNUNG: keine Zugriffstokens eingerichtet, die HTTP-API auf", server.Addr(), "liest und schreibt Blöcke für jeden ohne Anmeldung; Verwaltungsanfragen werden abgelehnt")
+		}
+	}
 
-	default:
-		return nil, fmt.Errorf("Ungültiges Dateiformat: %s", format)
+	presets, err := LoadPresets(presetsFile())
+	if err != nil {
+		log.Println("Importvorlagen konnten nicht geladen werden:", err)
+		presets, _ = LoadPresets("")
+	}
+
+	menu := &cli{
+		bc:        bc,
+		pipeline:  pipeline,
+		generator: generator,
+		presets:   presets,
+		server:    server,
+		notifier:  notifier,
+		confirmer: confirmer,
+		in:        NewLineReader(os.Stdin, os.Stdout, historyFile()),
+		out:       os.Stdout,
 	}
+	menu.run()
 
-	return data, nil
+	// Stop waits for a batch in flight, so the saved chain is complete
+	cancel()
+	generator.Stop()
+	if err := server.Stop(); err != nil {
+		log.Println("HTTP-Server konnte nicht beendet werden:", err)
+	}
+	if err := usage.Flush(); err != nil {
+		log.Println("Token-Nutzung nicht gespeichert:", err)
+	}
+	// the batches still queued are added before saving
+	queue.Close()
+	if notifier != nil {
+		// the notifications of the last blocks are sent before exiting
+		notifier.Close()
+		fmt.Println(notifier.Stats())
+	}
+	if flags.logPath != "" {
+		if err := bc.Close(); err != nil {
+			log.Println("Blockprotokoll konnte nicht geschlossen werden:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Blockchain protokolliert:", flags.logPath)
+		return
+	}
+	if flags.dbPath != "" {
+		if err := bc.Close(); err != nil {
+			log.Println("Datenbank konnte nicht geschlossen werden:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Blockchain in der Datenbank:", flags.dbPath)
+		return
+	}
+	if err := bc.SaveToFile(defaultChainFile); err != nil {
+		log.Println("Blockchain konnte nicht gespeichert werden:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Blockchain gespeichert:", defaultChainFile)
 }
 
-// main function
-func main() {
-	bc := NewBlockchain()
-
-	go generateValuesAndAddToBlockchain(bc)
-
-	var choice int
-	for {
-		fmt.Println("Wählen Sie eine Aktion:")
-		fmt.Println("1. Aktuelle Werte ausgeben")
-		fmt.Println("2. Blockchain anzeigen")
-		fmt.Println("3. Blöcke mit Ausreißern ausgeben")
-		fmt.Println("4. Daten aus externe Quelle einlesen und hinzufügen")
-		fmt.Println("5. Programm beenden")
-		fmt.Scanln(&choice)
-
-		switch choice {
-		case 1:
-			printBlock(bc.chain[len(bc.chain)-1])
-		case 2:
-			printBlockchain(bc.chain)
-		case 3:
-			printOutlierBlocks(bc.chain)
-		case 4:
-			var filePath, format string
-			fmt.Println("Geben Sie den Dateipfad der externen Datenquelle ein:")
-			fmt.Scanln(&filePath)
-			fmt.Println("Geben Sie das Datenformat ein (csv oder json):")
-			fmt.Scanln(&format)
-
-			// Daten aus externer Quelle einlesen (ohne die data-Variable zu verwenden)
-			_, err := readDataFromExternalSource(filePath, format)
-			if err != nil {
-				fmt.Println("Fehler beim Einlesen der externen Datenquelle:", err)
-				continue
+// watchBlocks prints an event for every new block, or every new block
+// with outliers, until Enter is pressed
+func watchBlocks(w io.Writer, bc *Blockchain, outliersOnly bool, in LineReader) error {
+	var filter func(*Block) bool
+	if outliersOnly {
+		filter = func(b *Block) bool { return b.HasOutliers }
+	}
+	events, unsubscribe := bc.SubscribeFiltered(filter)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range events {
+			fmt.Fprintf(w, "%s Block %d: %d Ausreißer", event.Timestamp.Format("15:04:05"), event.Index, event.Outliers)
+			if event.Dropped > 0 {
+				fmt.Fprintf(w, " (%d Ereignisse verpasst)", event.Dropped)
 			}
+			fmt.Fprintln(w)
+		}
+	}()
 
-		case 5:
-			return
+	_, err := promptString(in, "Neue Blöcke werden angezeigt, Enter beendet:")
+	unsubscribe()
+	<-done
+	return err
+}
 
-		default:
-			fmt.Println("Ungültige Auswahl!")
-		}
+// logGeneratorErrors logs batches the generator could not add
+func logGeneratorErrors(event GeneratorEvent) {
+	if event.Kind == SinkError {
+		log.Println("Fehler beim Hinzufügen des Blocks:", event.Err)
 	}
 }
 
 // printBlock prints the values and metadata of a block
-func printBlock(block *Block) {
-	fmt.Println("Block Meta-Daten:")
-	fmt.Printf("Index: %d\n", block.Index)
-	fmt.Printf("Zeitstempel: %v\n", block.Timestamp)
-	fmt.Printf("Hash: %s\n", block.Hash)
-	fmt.Printf("Vorgänger-Hash: %s\n", block.PrevHash)
-	fmt.Printf("Mittelwert: %.2f\n", block.Mean)
-	fmt.Printf("Median: %.2f\n", block.Median)
-	fmt.Printf("2-SD Bereich: %.2f - %.2f\n", block.TwoSDLower, block.TwoSDUpper)
-	fmt.Println("Ausreißer:")
+func printBlock(w io.Writer, block *Block) {
+	fmt.Fprintln(w, "Block Meta-Daten:")
+	fmt.Fprintf(w, "Index: %d\n", block.Index)
+	fmt.Fprintf(w, "ID: %s\n", block.ID)
+	fmt.Fprintf(w, "Status: %s\n", statusColor(block.Status))
+	if len(block.StatsErrors) > 0 {
+		fmt.Fprintf(w, "Fehlgeschlagene Statistik: %s\n", formatStatsErrors(block.StatsErrors))
+	}
+	if block.Quality != nil {
+		fmt.Fprintf(w, "Qualität: %.1f\n", block.Quality.Score)
+	}
+	if block.Anomalous {
+		fmt.Fprintf(w, "Anomalie: Mittelwert %.1f SD von den vorigen Blöcken entfernt\n", block.AnomalyScore)
+	}
+	fmt.Fprintf(w, "Zeitstempel: %v\n", block.Timestamp)
+	if block.Text != "" {
+		fmt.Fprintf(w, "Anmerkung: %s\n", block.Text)
+	}
+	fmt.Fprintf(w, "Hash: %s\n", formatHash(block.Hash))
+	fmt.Fprintf(w, "Vorgänger-Hash: %s\n", formatHash(block.PrevHash))
+	if block.Signature != nil {
+		fmt.Fprintf(w, "Signiert von: %s\n", formatHash(formatPublicKey(block.SignerPublicKey)))
+	}
+	if block.Difficulty > 0 {
+		fmt.Fprintf(w, "Nonce: %d (Schwierigkeit %d)\n", block.Nonce, block.Difficulty)
+	}
+	fmt.Fprintf(w, "Mittelwert: %.2f\n", block.Mean)
+	if block.Kind == KindInt && block.IntStats != nil && block.IntStats.MedianExact {
+		fmt.Fprintf(w, "Median: %d\n", block.IntStats.Median)
+	} else {
+		fmt.Fprintf(w, "Median: %.2f\n", block.Median)
+	}
+	fmt.Fprintf(w, "2-SD Bereich: %.2f - %.2f\n", block.TwoSDLower, block.TwoSDUpper)
+	if len(block.Percentiles) > 0 {
+		fmt.Fprintf(w, "Perzentile: p5 %.2f, p25 %.2f, p75 %.2f, p95 %.2f, p99 %.2f\n", block.Percentiles["p5"], block.Percentiles["p25"], block.Percentiles["p75"], block.Percentiles["p95"], block.Percentiles["p99"])
+	}
+	if block.OutlierMethod != "" {
+		fmt.Fprintf(w, "Ausreißergrenzen (%s): %.2f - %.2f\n", block.OutlierMethod, block.LowerBound, block.UpperBound)
+	}
+	if block.ValuesDropped && block.Dropped != nil {
+		fmt.Fprintf(w, "Werte verworfen: %d Werte, %d Ausreißer (Min %.2f, Max %.2f)\n", block.Dropped.Count, block.Dropped.Outliers, block.Dropped.Min, block.Dropped.Max)
+	}
+	if block.Kind == KindInt {
+		// a degraded block has no IntStats if their stage failed
+		if block.IntStats != nil {
+			fmt.Fprintf(w, "Min/Max: %d - %d\n", block.IntStats.Min, block.IntStats.Max)
+			fmt.Fprintf(w, "Summe: %s\n", block.IntStats.Sum)
+		}
+		fmt.Fprintln(w, "Ausreißer:")
+		fmt.Fprintln(w, formatInts(block.IntOutliers))
+		fmt.Fprintln(w, "Werte im aktuellen Block:")
+		fmt.Fprintln(w, formatInts(block.IntValues))
+		return
+	}
+	if block.Sampled {
+		fmt.Fprintf(w, "Stichprobe: %d von %d Werten (Min %.2f, Max %.2f)\n", block.storedCount(), block.OriginalCount, block.SampleMin, block.SampleMax)
+	}
+	fmt.Fprintln(w, "Ausreißer:")
 	for _, outlier := range block.Outliers {
-		fmt.Printf("%.2f ", outlier)
+		fmt.Fprintf(w, "%.2f ", outlier)
+	}
+	fmt.Fprintln(w)
+	for _, context := range block.OutlierContexts {
+		fmt.Fprintf(w, "  Position %d: %s\n", context.Position, context)
 	}
-	fmt.Println("\nWerte im aktuellen Block:")
-	for _, value := range block.Values {
-		fmt.Printf("%.2f ", value)
+	printReferences(w, block)
+	fmt.Fprintln(w, "Werte im aktuellen Block:")
+	for _, value := range block.GetValues() {
+		fmt.Fprintf(w, "%.2f ", value)
 	}
-	fmt.Println()
+	fmt.Fprintln(w)
 }
 
 // printBlockchain prints all blocks in the blockchain
-func printBlockchain(chain []*Block) {
-	fmt.Println("Blockchain:")
+func printBlockchain(w io.Writer, chain []*Block) {
+	fmt.Fprintln(w, "Blockchain:")
 	for _, block := range chain {
-		printBlock(block)
+		printBlock(w, block)
 	}
 }
 
-func printOutlierBlocks(chain []*Block) {
-	fmt.Println("Blöcke mit Ausreißern:")
+func printOutlierBlocks(w io.Writer, chain []*Block) {
+	fmt.Fprintln(w, "Blöcke mit Ausreißern:")
 	for _, block := range chain {
-		if len(block.Outliers) > 0 {
-			printBlock(block)
+		if block.HasOutliers {
+			printBlock(w, block)
 		}
 	}
 }
+
+// printBlockSummaries prints one line per block: index, timestamp,
+// number of outliers and mean
+func printBlockSummaries(w io.Writer, blocks []*Block) {
+	for _, block := range blocks {
+		fmt.Fprintf(w, "Block %d (%s): %d Ausreißer, Mittelwert %.2f\n",
+			block.Index, block.Timestamp.Format("2006-01-02 15:04:05"), block.OutlierCount(), block.Mean)
+	}
+}
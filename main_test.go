@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// chainTestValues are the batches of the test chains of newFilledChain;
+// the last value of every other batch is an outlier
+var chainTestValues = [][]float64{
+	{1, 2, 3, 4, 5},
+	{10, 10, 10, 10, 10, 10, 10, 10, 10, 90},
+	{5, 6, 7, 8, 9},
+	{20, 20, 20, 20, 20, 20, 20, 20, 20, 200},
+	{2, 4, 6, 8, 10},
+}
+
+// fillChain adds chainTestValues to bc
+func fillChain(t *testing.T, bc *Blockchain) {
+	t.Helper()
+	for _, values := range chainTestValues {
+		if _, err := bc.AddBlock(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newFilledChain returns a new chain holding chainTestValues
+func newFilledChain(t *testing.T) *Blockchain {
+	t.Helper()
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillChain(t, bc)
+	return bc
+}
+
+// bulkRows returns n rows of size values each; the first value of row i is
+// i, so the rows can be told apart in the chain
+func bulkRows(n, size int) [][]float64 {
+	r := rand.New(rand.NewSource(1))
+	rows := make([][]float64, n)
+	for i := range rows {
+		row := make([]float64, size)
+		row[0] = float64(i)
+		for j := 1; j < size; j++ {
+			row[j] = r.NormFloat64()
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// blockHashes returns the hashes of blocks, in order
+func blockHashes(blocks []*Block) []string {
+	hashes := make([]string, len(blocks))
+	for i, block := range blocks {
+		hashes[i] = block.Hash
+	}
+	return hashes
+}
+
+// sameHashes reports whether a and b hold the same hashes in order
+func sameHashes(a, b []*Block) bool {
+	ha, hb := blockHashes(a), blockHashes(b)
+	if len(ha) != len(hb) {
+		return false
+	}
+	for i := range ha {
+		if ha[i] != hb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// testClock is a Clock the tests move forward by hand
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func (c *testClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// sameStats reports whether a and b agree up to rounding
+func sameStats(a, b ChainStats) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) <= 1e-9*math.Max(1, math.Abs(x)) }
+	return a.Blocks == b.Blocks && a.Values == b.Values && a.Outliers == b.Outliers &&
+		near(a.Mean, b.Mean) && near(a.Min, b.Min) && near(a.Max, b.Max) && near(a.StdDev, b.StdDev)
+}
+
+// captureLog collects what the log package writes until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+// near reports whether a and b agree up to rounding
+func near(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(a))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"unsafe"
+)
+
+// ErrChainFull is returned by AddBlock once the chain reached its hard
+// memory limit
+var ErrChainFull = errors.New("Blockchain hat ihre Speichergrenze erreicht")
+
+// blockOverhead approximates the fixed cost of a block: the struct itself,
+// its hash strings and its slot in the chain slice
+const blockOverhead = int64(unsafe.Sizeof(Block{})) + 2*64 + 8
+
+// MemoryLimits are thresholds for the estimated memory use of the chain.
+// A threshold of 0 is disabled.
+type MemoryLimits struct {
+	// Soft logs a warning and calls OnSoftLimit when first exceeded
+	Soft int64
+	// Hard makes AddBlock fail with ErrChainFull
+	Hard int64
+	// OnSoftLimit, if set, is called when usage crosses Soft
+	OnSoftLimit func(usage int64)
+}
+
+// estimateBlockSize returns the approximate number of bytes block occupies
+func estimateBlockSize(block *Block) int64 {
+	size := blockOverhead
+	size += int64(len(block.Values)+len(block.Outliers)+len(block.IntValues)+len(block.IntOutliers)) * 8
+	size += int64(len(block.EncodedValues) + len(block.Signature) + len(block.SignerPublicKey))
+	size += int64(len(block.ID) + len(block.Text) + len(block.OutlierMethod) + len(block.MerkleRoot) + len(block.ContentHash))
+	for key, value := range block.Metadata {
+		size += int64(len(key)+len(value)) + 32
+	}
+	size += int64(len(block.ValueOrigins)) * int64(unsafe.Sizeof(Origin{}))
+	size += int64(len(block.ValueTimes)) * int64(unsafe.Sizeof(time.Time{}))
+	size += int64(len(block.Percentiles))*32 + int64(len(block.Histogram))*8
+	if block.Binned != nil {
+		size += int64(unsafe.Sizeof(*block.Binned)) + int64(len(block.Binned.Counts))*8
+	}
+	for stage, msg := range block.StatsErrors {
+		size += int64(len(stage)+len(msg)) + 32
+	}
+	for _, ref := range block.References {
+		size += int64(unsafe.Sizeof(ref)) + int64(len(ref.Type)+len(ref.URI)+len(ref.Description))
+	}
+	for _, context := range block.OutlierContexts {
+		size += int64(unsafe.Sizeof(context)) + int64(len(context.Before)+len(context.After))*8
+	}
+	return size
+}
+
+// SetMemoryLimits replaces the memory thresholds of the chain
+func (bc *Blockchain) SetMemoryLimits(limits MemoryLimits) error {
+	if limits.Soft > 0 && limits.Hard > 0 && limits.Soft > limits.Hard {
+		return fmt.Errorf("Weiche Speichergrenze %d liegt über der harten Grenze %d", limits.Soft, limits.Hard)
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.memLimits = limits
+	bc.memWarned = limits.Soft > 0 && bc.memUsage > limits.Soft
+	return nil
+}
+
+// MemoryUsage returns the estimated memory use of the chain in bytes
+func (bc *Blockchain) MemoryUsage() int64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.memUsage
+}
+
+// reserveMemory checks that a block of the given size fits under the hard
+// limit and accounts for it. Called with bc.mu held.
+func (bc *Blockchain) reserveMemory(size int64) error {
+	if bc.memLimits.Hard > 0 && bc.memUsage+size > bc.memLimits.Hard {
+		return ErrChainFull
+	}
+
+	bc.memUsage += size
+	if bc.memLimits.Soft > 0 && bc.memUsage > bc.memLimits.Soft && !bc.memWarned {
+		bc.memWarned = true
+		log.Printf("Warnung: Speicherverbrauch der Blockchain (%d Bytes) über der weichen Grenze von %d Bytes", bc.memUsage, bc.memLimits.Soft)
+		if bc.memLimits.OnSoftLimit != nil {
+			bc.memLimits.OnSoftLimit(bc.memUsage)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMemoryLimits(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged := captureLog(t)
+	values := []float64{1, 2, 3, 4}
+	size := func() int64 {
+		t.Helper()
+		block, err := bc.AddBlock(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return estimateBlockSize(block)
+	}
+	start := bc.MemoryUsage()
+	first := size()
+	if got := bc.MemoryUsage(); got != start+first {
+		t.Fatalf("usage is %d after a block of %d on %d", got, first, start)
+	}
+
+	// the soft limit lies within the next block, the hard limit two
+	// blocks further
+	var crossed []int64
+	usage := bc.MemoryUsage()
+	limits := MemoryLimits{Soft: usage + first/2, Hard: usage + 3*first, OnSoftLimit: func(usage int64) { crossed = append(crossed, usage) }}
+	if err := bc.SetMemoryLimits(limits); err != nil {
+		t.Fatal(err)
+	}
+	size()
+	size()
+	if len(crossed) != 1 || crossed[0] != usage+first {
+		t.Fatalf("OnSoftLimit was called with %v, want once with %d", crossed, usage+first)
+	}
+	if strings.Count(logged.String(), "über der weichen Grenze") != 1 {
+		t.Fatalf("log holds %q, want one warning", logged)
+	}
+
+	size()
+	if _, err := bc.AddBlock(values); !errors.Is(err, ErrChainFull) {
+		t.Fatalf("block over the hard limit returned %v, want ErrChainFull", err)
+	}
+	if got := bc.MemoryUsage(); got != usage+3*first || bc.LatestBlock().Index != 4 {
+		t.Fatalf("rejected block left usage %d and block %d last", got, bc.LatestBlock().Index)
+	}
+
+	// without a hard limit appends go on without a second warning
+	limits.Hard = 0
+	if err := bc.SetMemoryLimits(limits); err != nil {
+		t.Fatal(err)
+	}
+	size()
+	if len(crossed) != 1 {
+		t.Fatalf("OnSoftLimit was called again: %v", crossed)
+	}
+	if err := bc.SetMemoryLimits(MemoryLimits{Soft: 10, Hard: 5}); err == nil {
+		t.Fatal("soft limit above the hard limit accepted")
+	}
+}
+
+func TestEstimateBlockSize(t *testing.T) {
+	small := &Block{Values: []float64{1}}
+	large := &Block{Values: make([]float64, 101), Text: "Messung", Metadata: map[string]string{"ort": "Halle 3"}}
+	if got, want := estimateBlockSize(large)-estimateBlockSize(small), int64(100*8+len("Messung")+len("ort")+len("Halle 3")+32); got != want {
+		t.Fatalf("larger block costs %d more bytes, want %d", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// merkleLeaf hashes the canonical encoding of a value: its IEEE 754 bits,
+// 8 bytes big endian
+func merkleLeaf(value float64) [sha256.Size]byte {
+	return sha256.Sum256(binary.BigEndian.AppendUint64(nil, math.Float64bits(value)))
+}
+
+// merkleNode hashes two child hashes
+func merkleNode(left, right [sha256.Size]byte) [sha256.Size]byte {
+	return sha256.Sum256(append(left[:], right[:]...))
+}
+
+// merkleLevels returns the levels of the Merkle tree over values, from
+// the leaves to the root. A level of odd length is paired up by repeating
+// its last hash. Leaves hash 8 bytes and nodes 64, so a node cannot pass
+// for a leaf.
+func merkleLevels(values []float64) [][][sha256.Size]byte {
+	level := make([][sha256.Size]byte, len(values))
+	for i, v := range values {
+		level[i] = merkleLeaf(v)
+	}
+	levels := [][][sha256.Size]byte{level}
+	for len(level) > 1 {
+		next := make([][sha256.Size]byte, (len(level)+1)/2)
+		for i := range next {
+			left := level[2*i]
+			right := left
+			if 2*i+1 < len(level) {
+				right = level[2*i+1]
+			}
+			next[i] = merkleNode(left, right)
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// merkleRoot returns the hex Merkle root of values, or "" for none
+func merkleRoot(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	levels := merkleLevels(values)
+	root := levels[len(levels)-1][0]
+	return hex.EncodeToString(root[:])
+}
+
+// MerkleProof returns the hex sibling hashes from value i up to the
+// root, with which VerifyMerkleProof checks that the value belongs to the
+// block without all its values. Where the last hash of a level has no
+// sibling, it is its own. Sampled blocks and blocks whose values were
+// dropped no longer hold the values their root was built from and have no
+// proofs.
+func (b *Block) MerkleProof(i int) ([]string, error) {
+	switch {
+	case b.MerkleRoot == "":
+		return nil, errors.New("Block hat keine Merkle-Wurzel")
+	case b.Sampled:
+		return nil, fmt.Errorf("Block %d enthält nur eine Stichprobe seiner Werte", b.Index)
+	case b.ValuesDropped:
+		return nil, fmt.Errorf("Die Werte von Block %d wurden verworfen", b.Index)
+	}
+	values := b.GetValues()
+	if i < 0 || i >= len(values) {
+		return nil, fmt.Errorf("Wert %d liegt außerhalb von Block %d (%d Werte)", i, b.Index, len(values))
+	}
+
+	levels := merkleLevels(values)
+	proof := make([]string, 0, len(levels)-1)
+	for _, level := range levels[:len(levels)-1] {
+		sibling := i ^ 1
+		if sibling >= len(level) {
+			sibling = i
+		}
+		proof = append(proof, hex.EncodeToString(level[sibling][:]))
+		i /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof reports whether value is the value at index of a
+// block with the given Merkle root, according to proof from MerkleProof.
+// Since a last hash without a sibling is paired with itself, the last
+// value of a block with an odd number of values also verifies at the
+// index after it; callers that know the number of values should check
+// index against it.
+func VerifyMerkleProof(root string, value float64, index int, proof []string) bool {
+	if index < 0 || index>>len(proof) != 0 {
+		return false
+	}
+	hash := merkleLeaf(value)
+	for _, text := range proof {
+		var sibling [sha256.Size]byte
+		if len(text) != 2*sha256.Size {
+			return false
+		}
+		if _, err := hex.Decode(sibling[:], []byte(text)); err != nil {
+			return false
+		}
+		if index%2 == 0 {
+			hash = merkleNode(hash, sibling)
+		} else {
+			hash = merkleNode(sibling, hash)
+		}
+		index /= 2
+	}
+	return hex.EncodeToString(hash[:]) == root
+}
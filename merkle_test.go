@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	a, b, c := merkleLeaf(1), merkleLeaf(2), merkleLeaf(3)
+	ab := merkleNode(a, b)
+	cc := merkleNode(c, c)
+	abcc := merkleNode(ab, cc)
+	tests := []struct {
+		values []float64
+		root   string
+	}{
+		{nil, ""},
+		{[]float64{1}, hex.EncodeToString(a[:])},
+		{[]float64{1, 2}, hex.EncodeToString(ab[:])},
+		// the last hash of an odd level is paired with itself
+		{[]float64{1, 2, 3}, hex.EncodeToString(abcc[:])},
+	}
+	for _, tt := range tests {
+		if root := merkleRoot(tt.values); root != tt.root {
+			t.Fatalf("root of %v is %s, want %s", tt.values, root, tt.root)
+		}
+	}
+	if merkleRoot([]float64{2, 1}) == merkleRoot([]float64{1, 2}) {
+		t.Fatal("the root does not depend on the order of the values")
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []float64{3, 1, 4, 1, 5, 9, 2}
+	block, err := bc.AddBlock(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.MerkleRoot != merkleRoot(values) {
+		t.Fatalf("block has root %s, want %s", block.MerkleRoot, merkleRoot(values))
+	}
+	for i, value := range values {
+		proof, err := block.MerkleProof(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(proof) != 3 || !VerifyMerkleProof(block.MerkleRoot, value, i, proof) {
+			t.Fatalf("proof %v of value %d does not verify", proof, i)
+		}
+		if VerifyMerkleProof(block.MerkleRoot, value+1, i, proof) {
+			t.Fatalf("proof of value %d verifies another value", i)
+		}
+		if VerifyMerkleProof(block.MerkleRoot, value, i+8, proof) || VerifyMerkleProof(block.MerkleRoot, value, -1, proof) {
+			t.Fatalf("proof of value %d verifies an index outside the tree", i)
+		}
+	}
+
+	proof, err := block.MerkleProof(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, broken := range [][]string{proof[:2], {proof[0], proof[1], proof[2][:10]}, {proof[0], proof[1], "zz" + proof[2][2:]}} {
+		if VerifyMerkleProof(block.MerkleRoot, values[0], 0, broken) {
+			t.Fatalf("broken proof %v verifies", broken)
+		}
+	}
+
+	for _, i := range []int{-1, len(values)} {
+		if _, err := block.MerkleProof(i); err == nil {
+			t.Fatalf("proof of value %d returned", i)
+		}
+	}
+	sampled := copyBlock(block)
+	sampled.Sampled = true
+	if _, err := sampled.MerkleProof(0); err == nil {
+		t.Fatal("proof of a sampled block returned")
+	}
+	genesis, err := bc.BlockByIndex(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := genesis.MerkleProof(0); err == nil {
+		t.Fatal("proof of a block without root returned")
+	}
+}
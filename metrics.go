@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// chainCounters count what AddBlock did since the chain was created. They
+// are updated with bc.mu held but read atomically, so Metrics does not
+// wait for them.
+type chainCounters struct {
+	added atomic.Int64
+	// statsNanos sums the time the stats of new blocks took
+	statsNanos atomic.Int64
+	lastStats  atomic.Int64
+}
+
+// record counts a block appended after its stats took statsTime
+func (c *chainCounters) record(statsTime time.Duration) {
+	c.added.Add(1)
+	c.statsNanos.Add(int64(statsTime))
+	c.lastStats.Store(int64(statsTime))
+}
+
+// ChainMetrics is a snapshot of the health of a chain, see Metrics
+type ChainMetrics struct {
+	// BlocksAdded counts the blocks appended since the chain was created
+	// or loaded
+	BlocksAdded int64
+	// Blocks is the number of blocks the chain holds, like Length
+	Blocks int
+	// BlocksLastMinute counts the blocks held with a timestamp of the last
+	// minute of the chain's clock
+	BlocksLastMinute int
+	// LastBlockAge is how long ago the head block was created
+	LastBlockAge time.Duration
+	// OutlierBlocks counts the blocks with outliers, including those
+	// Prune archived and Compact deleted, and OutlierBlockRatio is their
+	// share of the blocks with values, 0 without any
+	OutlierBlocks     int
+	OutlierBlockRatio float64
+	// StatsTime sums how long the stats of the BlocksAdded took, and
+	// LastStatsTime is that of the newest one
+	StatsTime     time.Duration
+	LastStatsTime time.Duration
+	// NonMonotonicTimestamps counts the new blocks whose clock reading was
+	// earlier than their predecessor's timestamp
+	NonMonotonicTimestamps int
+}
+
+// Metrics returns a snapshot of the chain's counters and of what it
+// holds. It reads the blocks of the last minute only.
+func (bc *Blockchain) Metrics() ChainMetrics {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	totals := bc.aggregateTotals()
+	now := bc.clock.Now()
+
+	metrics := ChainMetrics{
+		BlocksAdded:   bc.counters.added.Load(),
+		Blocks:        bc.held,
+		LastBlockAge:  max(now.Sub(bc.head.Timestamp), 0),
+		OutlierBlocks: totals.outlierBlocks,
+		StatsTime:     time.Duration(bc.counters.statsNanos.Load()),
+		LastStatsTime: time.Duration(bc.counters.lastStats.Load()),
+
+		NonMonotonicTimestamps: bc.nonMonotonic,
+	}
+	if totals.blocks > 0 {
+		metrics.OutlierBlockRatio = float64(totals.outlierBlocks) / float64(totals.blocks)
+	}
+	// timestamps increase unless the timestamp policy allows otherwise, so
+	// stop at the first older block rather than walking the chain
+	since := now.Add(-time.Minute)
+	logReadError(bc.walkBack(func(pos int, block *Block) bool {
+		if pos == 0 || !block.Timestamp.After(since) {
+			return false
+		}
+		metrics.BlocksLastMinute++
+		return true
+	}))
+	return metrics
+}
+
+// NewMetricsHandler serves GET /metrics: the Metrics of bc, its
+// StorageStats if its storage is timed and, if they are not nil, the stats
+// of queue and generator, in the Prometheus text exposition format
+func NewMetricsHandler(bc *Blockchain, queue *IngestQueue, generator *Generator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("Methode %s nicht erlaubt", r.Method))
+			return
+		}
+		var b bytes.Buffer
+		writeChainMetrics(&b, bc.Metrics())
+		if stats, err := bc.StorageStats(); err == nil {
+			writeStorageMetrics(&b, stats)
+		}
+		if queue != nil {
+			writeQueueMetrics(&b, queue.Stats())
+		}
+		if generator != nil {
+			stats := generator.Stats()
+			writeMetric(&b, "blockchain_generator_batches_total", "counter", "Batches des Generators", []sample{
+				{`result="produced"`, float64(stats.Produced)},
+				{`result="failed"`, float64(stats.Failed)},
+			})
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(b.Bytes())
+	})
+}
+
+// withMetrics serves GET /metrics with metrics and everything else with
+// api
+func withMetrics(api, metrics http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.Handle("/", api)
+	return mux
+}
+
+// sample is one line of a metric, with its labels as written between the
+// braces
+type sample struct {
+	labels string
+	value  float64
+}
+
+func writeChainMetrics(b *bytes.Buffer, m ChainMetrics) {
+	writeMetric(b, "blockchain_blocks_added_total", "counter", "Seit dem Start angehängte Blöcke", []sample{{"", float64(m.BlocksAdded)}})
+	writeMetric(b, "blockchain_blocks", "gauge", "Blöcke der Blockchain", []sample{{"", float64(m.Blocks)}})
+	writeMetric(b, "blockchain_blocks_last_minute", "gauge", "Blöcke der letzten Minute", []sample{{"", float64(m.BlocksLastMinute)}})
+	writeMetric(b, "blockchain_last_block_age_seconds", "gauge", "Alter des neuesten Blocks", []sample{{"", m.LastBlockAge.Seconds()}})
+	writeMetric(b, "blockchain_outlier_blocks", "gauge", "Blöcke mit Ausreißern", []sample{{"", float64(m.OutlierBlocks)}})
+	writeMetric(b, "blockchain_outlier_block_ratio", "gauge", "Anteil der Blöcke mit Ausreißern an den Blöcken mit Werten", []sample{{"", m.OutlierBlockRatio}})
+	writeMetric(b, "blockchain_stats_duration_seconds", "summary", "Dauer der Statistik neuer Blöcke", []sample{
+		{"_sum", m.StatsTime.Seconds()},
+		{"_count", float64(m.BlocksAdded)},
+	})
+	writeMetric(b, "blockchain_last_stats_duration_seconds", "gauge", "Dauer der Statistik des neuesten Blocks", []sample{{"", m.LastStatsTime.Seconds()}})
+	writeMetric(b, "blockchain_non_monotonic_timestamps_total", "counter", "Neue Blöcke mit Zeitstempel vor dem vorherigen Block", []sample{{"", float64(m.NonMonotonicTimestamps)}})
+}
+
+func writeQueueMetrics(b *bytes.Buffer, s IngestStats) {
+	writeMetric(b, "blockchain_ingest_queue_depth", "gauge", "Batches in der Eingangswarteschlange", []sample{{"", float64(s.Depth)}})
+	writeMetric(b, "blockchain_ingest_queue_capacity", "gauge", "Größe der Eingangswarteschlange", []sample{{"", float64(s.Capacity)}})
+	writeMetric(b, "blockchain_ingest_batches_total", "counter", "Batches der Eingangswarteschlange", []sample{
+		{`result="processed"`, float64(s.Processed)},
+		{`result="failed"`, float64(s.Failed)},
+		{`result="dropped_newest"`, float64(s.DroppedNewest)},
+		{`result="dropped_oldest"`, float64(s.DroppedOldest)},
+	})
+}
+
+// writeMetric writes the HELP and TYPE lines of a metric and its samples.
+// A sample whose labels start with "_" is a suffix of the name, as the
+// _sum and _count of a summary.
+func writeMetric(b *bytes.Buffer, name, kind, help string, samples []sample) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, s := range samples {
+		switch {
+		case s.labels == "":
+			b.WriteString(name)
+		case s.labels[0] == '_':
+			b.WriteString(name + s.labels)
+		default:
+			b.WriteString(name + "{" + s.labels + "}")
+		}
+		b.WriteString(" " + formatMetricValue(s.value) + "\n")
+	}
+}
+
+// formatMetricValue formats a sample value the way Prometheus parses it
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
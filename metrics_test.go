@@ -0,0 +1,28 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetricsCoverPrunedBlocks(t *testing.T) {
+	bc := newFilledChain(t)
+	before := bc.Metrics()
+	if before.OutlierBlocks != 2 || before.OutlierBlockRatio != 0.4 {
+		t.Fatalf("metrics count %d outlier blocks, ratio %v, want 2 and 0.4", before.OutlierBlocks, before.OutlierBlockRatio)
+	}
+
+	// blocks 2 and 4, the ones with outliers, go to the archive
+	if _, err := bc.Prune(1, filepath.Join(t.TempDir(), "archive.json")); err != nil {
+		t.Fatal(err)
+	}
+	after := bc.Metrics()
+	if after.Blocks != 1 || after.OutlierBlocks != 2 || after.OutlierBlockRatio != 0.4 {
+		t.Fatalf("metrics after pruning are %+v, want 1 block held and the outliers of all 5", after)
+	}
+	body := serve(NewMetricsHandler(bc, nil, nil), "GET", "/metrics", "").Body.String()
+	if !strings.Contains(body, "\nblockchain_outlier_blocks 2\n") || !strings.Contains(body, "\nblockchain_outlier_block_ratio 0.4\n") {
+		t.Fatalf("GET /metrics does not report the pruned outlier blocks:\n%s", body)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MicroBatch is a slice of readings delivered by an ingestion adapter.
+// Adapters with at-least-once delivery may send the same readings twice;
+// a position, either a sequence number or a time range, lets MicroBatcher
+// recognise them. Micro-batches without a position are never deduplicated.
+type MicroBatch struct {
+	Values []float64
+	// Seq is the sequence number of the first value; value i has Seq+i.
+	// 0 means the micro-batch carries no sequence number.
+	Seq uint64
+	// From and To bound the time range the values cover, assuming they are
+	// evenly spaced. Used only when Seq is 0 and both are set.
+	From, To time.Time
+}
+
+// OverlapPolicy selects what MicroBatcher does with readings it has seen
+type OverlapPolicy string
+
+const (
+	// OverlapDrop drops every micro-batch that overlaps an earlier one
+	OverlapDrop OverlapPolicy = "drop"
+	// OverlapKeepFirst keeps the first copy of each reading and drops only
+	// the overlapping readings of later micro-batches
+	OverlapKeepFirst OverlapPolicy = "keep-first"
+)
+
+// MicroBatcherConfig configures a MicroBatcher. Zero fields get defaults.
+type MicroBatcherConfig struct {
+	// Lookback is the number of recent micro-batches checked for overlap
+	Lookback int
+	// Policy defaults to OverlapKeepFirst
+	Policy OverlapPolicy
+	// Interval is how often Run cuts a block, one second by default
+	Interval time.Duration
+	Clock    Clock
+}
+
+// MicroBatchMetrics counts what a MicroBatcher has seen
+type MicroBatchMetrics struct {
+	Received      int
+	Dropped       int
+	DroppedValues int
+	Blocks        int
+}
+
+// span is the range of positions covered by a micro-batch: sequence
+// numbers [lo, hi) or Unix nanoseconds [lo, hi]
+type span struct {
+	timed  bool
+	lo, hi int64
+}
+
+// MicroBatcher buffers micro-batches from one source, drops overlapping
+// readings and cuts the buffer into a block submitted through a Pipeline.
+type MicroBatcher struct {
+	pipeline *Pipeline
+	source   string
+	cfg      MicroBatcherConfig
+
+	mu      sync.Mutex
+	pending []float64
+	batches int
+	seen    []span
+	metrics MicroBatchMetrics
+}
+
+// NewMicroBatcher creates a MicroBatcher submitting blocks for source
+func NewMicroBatcher(pipeline *Pipeline, source string, cfg MicroBatcherConfig) (*MicroBatcher, error) {
+	switch cfg.Policy {
+	case "":
+		cfg.Policy = OverlapKeepFirst
+	case OverlapDrop, OverlapKeepFirst:
+	default:
+		return nil, fmt.Errorf("Unbekannte Überlappungsregel: %s", cfg.Policy)
+	}
+	if cfg.Lookback < 0 {
+		return nil, fmt.Errorf("Ungültiges Rückblickfenster: %d", cfg.Lookback)
+	}
+	if cfg.Lookback == 0 {
+		cfg.Lookback = 64
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = realClock{}
+	}
+	return &MicroBatcher{pipeline: pipeline, source: source, cfg: cfg}, nil
+}
+
+// Add buffers the readings of mb that were not seen within the lookback
+func (m *MicroBatcher) Add(mb MicroBatch) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metrics.Received++
+
+	s, ok := spanOf(mb)
+	if !ok {
+		m.keep(mb.Values)
+		return
+	}
+
+	var kept []float64
+	for i, value := range mb.Values {
+		if !m.seenAt(s, pointOf(s, i, len(mb.Values))) {
+			kept = append(kept, value)
+		}
+	}
+	m.remember(s)
+
+	switch {
+	case len(kept) == len(mb.Values):
+		m.keep(mb.Values)
+	case len(kept) == 0 || m.cfg.Policy == OverlapDrop:
+		m.metrics.Dropped++
+		m.metrics.DroppedValues += len(mb.Values)
+	default:
+		m.metrics.DroppedValues += len(mb.Values) - len(kept)
+		m.keep(kept)
+	}
+}
+
+// Cut submits the buffered readings as one block. An empty buffer is not
+// an error and adds no block.
+func (m *MicroBatcher) Cut() error {
+	m.mu.Lock()
+	values, batches := m.pending, m.batches
+	m.pending, m.batches = nil, 0
+	m.mu.Unlock()
+
+	if len(values) == 0 {
+		return nil
+	}
+	err := m.pipeline.Submit(&Batch{
+		Source:   m.source,
+		Values:   values,
+		Metadata: map[string]string{"micro_batches": strconv.Itoa(batches)},
+	})
+	if err == nil {
+		m.mu.Lock()
+		m.metrics.Blocks++
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// Run cuts a block every interval until ctx is cancelled, then cuts what
+// is left. Errors are passed to onError if it is not nil.
+func (m *MicroBatcher) Run(ctx context.Context, onError func(error)) {
+	for {
+		select {
+		case <-ctx.Done():
+			if err := m.Cut(); err != nil && onError != nil {
+				onError(err)
+			}
+			return
+		case <-m.cfg.Clock.After(m.cfg.Interval):
+			if err := m.Cut(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Metrics returns a copy of the counters
+func (m *MicroBatcher) Metrics() MicroBatchMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.metrics
+}
+
+// keep appends values to the buffer. Called with m.mu held.
+func (m *MicroBatcher) keep(values []float64) {
+	if len(values) == 0 {
+		return
+	}
+	m.pending = append(m.pending, values...)
+	m.batches++
+}
+
+// seenAt reports whether point lies in a remembered span of the same
+// kind as s. Called with m.mu held.
+func (m *MicroBatcher) seenAt(s span, point int64) bool {
+	for _, seen := range m.seen {
+		if seen.timed != s.timed {
+			continue
+		}
+		if point >= seen.lo && (point < seen.hi || (seen.timed && point == seen.hi)) {
+			return true
+		}
+	}
+	return false
+}
+
+// remember records s, forgetting spans beyond the lookback. Called with
+// m.mu held.
+func (m *MicroBatcher) remember(s span) {
+	m.seen = append(m.seen, s)
+	if len(m.seen) > m.cfg.Lookback {
+		m.seen = m.seen[len(m.seen)-m.cfg.Lookback:]
+	}
+}
+
+// spanOf returns the positions covered by mb, if it carries any
+func spanOf(mb MicroBatch) (span, bool) {
+	switch {
+	case mb.Seq > 0:
+		lo := int64(mb.Seq)
+		return span{lo: lo, hi: lo + int64(len(mb.Values))}, true
+	case !mb.From.IsZero() && !mb.To.IsZero() && !mb.To.Before(mb.From):
+		return span{timed: true, lo: mb.From.UnixNano(), hi: mb.To.UnixNano()}, true
+	default:
+		return span{}, false
+	}
+}
+
+// pointOf returns the position of value i of n within s
+func pointOf(s span, i, n int) int64 {
+	if !s.timed {
+		return s.lo + int64(i)
+	}
+	if n == 1 {
+		return s.lo
+	}
+	return s.lo + (s.hi-s.lo)*int64(i)/int64(n-1)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMicroBatcherOverlap(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		cfg     MicroBatcherConfig
+		batches []MicroBatch
+		want    []float64
+		metrics MicroBatchMetrics
+	}{
+		{
+			name:    "keep first of overlapping sequence",
+			batches: []MicroBatch{{Values: []float64{1, 2, 3}, Seq: 1}, {Values: []float64{3, 4, 5}, Seq: 3}},
+			want:    []float64{1, 2, 3, 4, 5},
+			metrics: MicroBatchMetrics{Received: 2, DroppedValues: 1, Blocks: 1},
+		},
+		{
+			name:    "drop overlapping sequence",
+			cfg:     MicroBatcherConfig{Policy: OverlapDrop},
+			batches: []MicroBatch{{Values: []float64{1, 2, 3}, Seq: 1}, {Values: []float64{3, 4, 5}, Seq: 3}},
+			want:    []float64{1, 2, 3},
+			metrics: MicroBatchMetrics{Received: 2, Dropped: 1, DroppedValues: 3, Blocks: 1},
+		},
+		{
+			name:    "redelivered batch",
+			batches: []MicroBatch{{Values: []float64{1, 2}, Seq: 7}, {Values: []float64{1, 2}, Seq: 7}, {Values: []float64{3}, Seq: 9}},
+			want:    []float64{1, 2, 3},
+			metrics: MicroBatchMetrics{Received: 3, Dropped: 1, DroppedValues: 2, Blocks: 1},
+		},
+		{
+			name: "overlapping time ranges",
+			batches: []MicroBatch{
+				{Values: []float64{1, 2, 3}, From: start, To: start.Add(200 * time.Millisecond)},
+				{Values: []float64{3, 4, 5}, From: start.Add(200 * time.Millisecond), To: start.Add(400 * time.Millisecond)},
+			},
+			want:    []float64{1, 2, 3, 4, 5},
+			metrics: MicroBatchMetrics{Received: 2, DroppedValues: 1, Blocks: 1},
+		},
+		{
+			name:    "without positions",
+			batches: []MicroBatch{{Values: []float64{1, 2}}, {Values: []float64{1, 2}}},
+			want:    []float64{1, 2, 1, 2},
+			metrics: MicroBatchMetrics{Received: 2, Blocks: 1},
+		},
+		{
+			name:    "beyond the lookback",
+			cfg:     MicroBatcherConfig{Lookback: 1},
+			batches: []MicroBatch{{Values: []float64{1}, Seq: 1}, {Values: []float64{2}, Seq: 2}, {Values: []float64{1}, Seq: 1}},
+			want:    []float64{1, 2, 1},
+			metrics: MicroBatchMetrics{Received: 3, Blocks: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, err := NewBlockchain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := NewMicroBatcher(NewDefaultPipeline(bc), "sensor", tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, mb := range tt.batches {
+				m.Add(mb)
+			}
+			if err := m.Cut(); err != nil {
+				t.Fatal(err)
+			}
+			if got := bc.LatestBlock().Values; !slices.Equal(got, tt.want) {
+				t.Fatalf("block holds %v, want %v", got, tt.want)
+			}
+			if metrics := m.Metrics(); metrics != tt.metrics {
+				t.Fatalf("metrics are %+v, want %+v", metrics, tt.metrics)
+			}
+		})
+	}
+}
+
+func TestMicroBatcherCutsOnInterval(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newTickClock()
+	m, err := NewMicroBatcher(NewDefaultPipeline(bc), "sensor", MicroBatcherConfig{Interval: 100 * time.Millisecond, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx, func(err error) { t.Error(err) })
+		close(done)
+	}()
+
+	// each interval cuts the micro-batches added during it into one
+	// block; an interval without any adds none
+	steps := [][]MicroBatch{
+		{{Values: []float64{1, 2}, Seq: 1}, {Values: []float64{3}, Seq: 3}},
+		{{Values: []float64{4, 5, 6}, Seq: 4}},
+		nil,
+		{{Values: []float64{7}, Seq: 7}, {Values: []float64{8}, Seq: 8}, {Values: []float64{9}, Seq: 9}},
+	}
+	clock.settle(t)
+	for _, step := range steps {
+		for _, mb := range step {
+			m.Add(mb)
+		}
+		clock.tick(t)
+		clock.settle(t)
+	}
+	m.Add(MicroBatch{Values: []float64{10}, Seq: 10})
+	cancel()
+	<-done
+
+	want := []struct {
+		values  []float64
+		batches string
+	}{
+		{[]float64{1, 2, 3}, "2"},
+		{[]float64{4, 5, 6}, "1"},
+		{[]float64{7, 8, 9}, "3"},
+		{[]float64{10}, "1"},
+	}
+	blocks := bc.Blocks()[1:]
+	if len(blocks) != len(want) {
+		t.Fatalf("chain holds %d blocks after genesis, want %d", len(blocks), len(want))
+	}
+	for i, block := range blocks {
+		if !slices.Equal(block.Values, want[i].values) || block.Metadata["micro_batches"] != want[i].batches {
+			t.Fatalf("block %d holds %v from %s micro-batches, want %v from %s", block.Index, block.Values, block.Metadata["micro_batches"], want[i].values, want[i].batches)
+		}
+	}
+	if metrics := m.Metrics(); metrics.Blocks != 4 || metrics.Received != 7 {
+		t.Fatalf("metrics are %+v", metrics)
+	}
+}
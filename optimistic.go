@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrHeadMoved is matched by every *HeadMovedError
+var ErrHeadMoved = errors.New("Kopf der Blockchain hat sich geändert")
+
+// HeadMovedError is returned by AddBlockIfHead when another writer
+// appended since the caller read the head. Head is a copy of the current
+// head, to rebase onto or retry with.
+type HeadMovedError struct {
+	Expected string
+	Head     *Block
+}
+
+func (e *HeadMovedError) Error() string {
+	return fmt.Sprintf("%v: erwartet %s, aktuell Block %d mit %s", ErrHeadMoved, formatHash(e.Expected), e.Head.Index, formatHash(e.Head.Hash))
+}
+
+func (e *HeadMovedError) Is(target error) bool {
+	return target == ErrHeadMoved
+}
+
+// AddBlockIfHead adds a new block only if the head still has the hash
+// expected, as read with HeadHash. Otherwise it returns a *HeadMovedError
+// and adds nothing. AddBlock keeps appending unconditionally.
+func (bc *Blockchain) AddBlockIfHead(values []float64, expected string) error {
+	if expected == "" {
+		return fmt.Errorf("%w: kein erwarteter Hash angegeben", ErrHeadMoved)
+	}
+	_, err := bc.addBlock(blockPayload{values: values, expectedHead: expected})
+	return err
+}
+
+// checkHead implements the expectation of AddBlockIfHead. Called with
+// bc.mu held.
+func (bc *Blockchain) checkHead(expected string) error {
+	if expected != "" && bc.head.Hash != expected {
+		return &HeadMovedError{Expected: expected, Head: copyBlock(bc.head)}
+	}
+	return nil
+}
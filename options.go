@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Option configures a chain created by NewBlockchain
+type Option func(*options)
+
+// options collects what the Options passed to NewBlockchain set
+type options struct {
+	maxValuesPerBlock int
+	logPath           string
+	storage           Storage
+	chain             ChainConfig
+	dedupWindow       int
+	signer            ed25519.PrivateKey
+	storageTiming     bool
+	slowStorage       time.Duration
+}
+
+// WithPersistence enables append-only persistence: every block is written
+// to the block log at path as it is added, and the last known good head
+// to path.head after it. An existing log is resumed with RecoverFromLog;
+// a missing one whose head record remains gives a degraded chain, see
+// Recovery.
+func WithPersistence(path string) Option {
+	return func(o *options) { o.logPath = path }
+}
+
+// WithStorage keeps every block in storage as it is added, in place of a
+// block log. A storage that holds blocks already is resumed, see Storage.
+func WithStorage(storage Storage) Option {
+	return func(o *options) { o.storage = storage }
+}
+
+// WithChainConfig identifies a new chain. If cfg.Name is set, a resumed
+// log or storage must hold a chain of that name.
+func WithChainConfig(cfg ChainConfig) Option {
+	return func(o *options) { o.chain = cfg }
+}
+
+// WithMaxValuesPerBlock sets the number of values AddValues collects
+// before it cuts a block. 0, the default, makes every AddValues call a
+// block of its own.
+func WithMaxValuesPerBlock(n int) Option {
+	return func(o *options) { o.maxValuesPerBlock = n }
+}
+
+// WithDeduplication makes AddBlock reject batches that repeat one of the
+// last window blocks, see SetDeduplication. Without it duplicates are
+// accepted.
+func WithDeduplication(window int) Option {
+	return func(o *options) { o.dedupWindow = window }
+}
+
+// WithSigner signs every block with priv, see SetSigner; the genesis of a
+// new chain is signed too
+func WithSigner(priv ed25519.PrivateKey) Option {
+	return func(o *options) { o.signer = priv }
+}
+
+// newChain returns a new chain with the genesis the options describe
+func (o *options) newChain() *Blockchain {
+	bc := newBlockchain(o.chain)
+	if o.signer != nil {
+		signBlock(bc.head, o.signer)
+	}
+	return bc
+}
+
+// open creates the chain the options describe
+func (o *options) open() (*Blockchain, error) {
+	if o.maxValuesPerBlock < 0 {
+		return nil, fmt.Errorf("Ungültige Blockgröße: %d", o.maxValuesPerBlock)
+	}
+	if o.dedupWindow < 0 {
+		return nil, fmt.Errorf("Ungültiges Fenster für doppelte Blöcke: %d", o.dedupWindow)
+	}
+	if o.signer != nil && len(o.signer) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("Ungültiger Signaturschlüssel: %d Bytes", len(o.signer))
+	}
+	if o.logPath != "" && o.storage != nil {
+		return nil, errors.New("Blockprotokoll und Speicher können nicht zusammen verwendet werden")
+	}
+	if o.slowStorage < 0 {
+		return nil, fmt.Errorf("Ungültige Schwelle für langsame Speicherzugriffe: %s", o.slowStorage)
+	}
+	if o.storageTiming && o.storage == nil {
+		return nil, errors.New("Zeitmessung des Speichers braucht einen Speicher")
+	}
+	if o.storageTiming {
+		o.storage = newTimedStorage(o.storage, o.slowStorage)
+	}
+	bc := o.newChain()
+	if o.storage != nil {
+		var err error
+		if bc, err = openStorageChain(o.storage, o); err != nil {
+			return nil, fmt.Errorf("Speicher: %w", err)
+		}
+	}
+	if o.logPath != "" {
+		var err error
+		bc, err = RecoverFromLog(o.logPath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			bc = o.newChain()
+			if bc.log, err = openBlockLog(o.logPath, bc.state(), []*Block{bc.head}); err == nil {
+				bc.setRecovery(missingLogReport(bc, o.logPath))
+				bc.log.recordHead(bc.head, false)
+				bc.log.resetIndex([]*Block{bc.head})
+			}
+		case err == nil:
+			if err = checkChainName(bc, o.chain.Name); err != nil {
+				bc.Close()
+				err = fmt.Errorf("Blockprotokoll %s: %w", o.logPath, err)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	bc.maxValuesPerBlock = o.maxValuesPerBlock
+	bc.dedupWindow = o.dedupWindow
+	bc.signer = o.signer
+	return bc, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OutlierClusterOptions configures OutlierClusters
+type OutlierClusterOptions struct {
+	// Gap is the smallest distance between neighbouring outlier values that
+	// separates two clusters. 0 picks five times the median distance.
+	Gap float64
+	// MinCount drops clusters with fewer outliers, which are treated as
+	// isolated noise. 0 means 2.
+	MinCount int
+}
+
+// OutlierCluster is a group of outliers, from one or many blocks, that lie
+// close together in value space
+type OutlierCluster struct {
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Count  int       `json:"count"`
+	Blocks []int     `json:"blocks"`
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+}
+
+func (c OutlierCluster) String() string {
+	return fmt.Sprintf("%.2f bis %.2f: %d Ausreißer in %d Blöcken, %s bis %s",
+		c.Min, c.Max, c.Count, len(c.Blocks), c.First.Format(time.RFC3339), c.Last.Format(time.RFC3339))
+}
+
+// outlierPoint is one outlier value and the block it was found in
+type outlierPoint struct {
+	value float64
+	block *Block
+}
+
+// OutlierClusters clusters the outliers of the blocks from index from to
+// index to, inclusive, by the gaps between their values. Clusters are
+// returned in ascending value order.
+func (bc *Blockchain) OutlierClusters(from, to int, opts OutlierClusterOptions) ([]OutlierCluster, error) {
+	if from > to {
+		return nil, fmt.Errorf("Ungültiger Bereich: %d bis %d", from, to)
+	}
+	bc.mu.RLock()
+	start, err := bc.position(from)
+	if err == nil {
+		_, err = bc.position(to)
+	}
+	if err != nil {
+		bc.mu.RUnlock()
+		return nil, err
+	}
+	blocks, err := bc.blocks(start, start+to-from+1)
+	bc.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return clusterOutliers(blocks, opts), nil
+}
+
+// clusterOutliers implements OutlierClusters for a list of blocks
+func clusterOutliers(blocks []*Block, opts OutlierClusterOptions) []OutlierCluster {
+	var points []outlierPoint
+	for _, block := range blocks {
+		for _, value := range block.Outliers {
+			points = append(points, outlierPoint{value, block})
+		}
+		for _, value := range block.IntOutliers {
+			points = append(points, outlierPoint{float64(value), block})
+		}
+	}
+	if len(points) == 0 {
+		return nil
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].value < points[j].value })
+
+	gap := opts.Gap
+	if gap <= 0 {
+		gap = 5 * medianGap(points)
+	}
+	minCount := opts.MinCount
+	if minCount <= 0 {
+		minCount = 2
+	}
+
+	var clusters []OutlierCluster
+	begin := 0
+	for i := 1; i <= len(points); i++ {
+		if i < len(points) && points[i].value-points[i-1].value <= gap {
+			continue
+		}
+		if i-begin >= minCount {
+			clusters = append(clusters, newOutlierCluster(points[begin:i]))
+		}
+		begin = i
+	}
+	return clusters
+}
+
+// medianGap returns the median distance between neighbouring sorted
+// points, ignoring duplicates
+func medianGap(points []outlierPoint) float64 {
+	var gaps []float64
+	for i := 1; i < len(points); i++ {
+		if d := points[i].value - points[i-1].value; d > 0 {
+			gaps = append(gaps, d)
+		}
+	}
+	if len(gaps) == 0 {
+		return 0
+	}
+	sort.Float64s(gaps)
+	return gaps[len(gaps)/2]
+}
+
+func newOutlierCluster(points []outlierPoint) OutlierCluster {
+	cluster := OutlierCluster{
+		Min:   points[0].value,
+		Max:   points[len(points)-1].value,
+		Count: len(points),
+		First: points[0].block.Timestamp,
+		Last:  points[0].block.Timestamp,
+	}
+	seen := map[int]bool{}
+	for _, p := range points {
+		if !seen[p.block.Index] {
+			seen[p.block.Index] = true
+			cluster.Blocks = append(cluster.Blocks, p.block.Index)
+		}
+		if p.block.Timestamp.Before(cluster.First) {
+			cluster.First = p.block.Timestamp
+		}
+		if p.block.Timestamp.After(cluster.Last) {
+			cluster.Last = p.block.Timestamp
+		}
+	}
+	sort.Ints(cluster.Blocks)
+	return cluster
+}
+
+// formatOutlierClusters renders clusters for the command line
+func formatOutlierClusters(clusters []OutlierCluster) string {
+	if len(clusters) == 0 {
+		return "Keine Ausreißer-Cluster gefunden\n"
+	}
+	var b strings.Builder
+	for i, cluster := range clusters {
+		fmt.Fprintf(&b, "Cluster %d: %s\n", i+1, cluster)
+	}
+	return b.String()
+}
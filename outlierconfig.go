@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Outlier detection methods
+const (
+	// OutlierSD flags values more than SDMultiplier standard deviations
+	// from the mean
+	OutlierSD = "sd"
+	// OutlierIQR flags values more than 1.5 interquartile ranges below the
+	// first or above the third quartile
+	OutlierIQR = "iqr"
+)
+
+// OutlierConfig selects how the outliers of new blocks are detected
+type OutlierConfig struct {
+	Method string `json:"method"`
+	// SDMultiplier is used by OutlierSD; 0 means 2
+	SDMultiplier float64 `json:"sd_multiplier,omitempty"`
+}
+
+// DefaultOutlierConfig is the 2-SD rule every block used before outlier
+// detection became configurable
+var DefaultOutlierConfig = OutlierConfig{Method: OutlierSD, SDMultiplier: 2}
+
+// Validate checks the method and the multiplier
+func (c OutlierConfig) Validate() error {
+	switch c.Method {
+	case OutlierSD:
+		if c.SDMultiplier < 0 || math.IsNaN(c.SDMultiplier) || math.IsInf(c.SDMultiplier, 0) {
+			return fmt.Errorf("Ungültiger SD-Faktor: %v", c.SDMultiplier)
+		}
+	case OutlierIQR:
+		if c.SDMultiplier != 0 {
+			return fmt.Errorf("SD-Faktor gilt nur für die Methode %s", OutlierSD)
+		}
+	default:
+		return fmt.Errorf("Unbekannte Ausreißer-Methode: %s", c.Method)
+	}
+	return nil
+}
+
+// normalized fills in the default multiplier
+func (c OutlierConfig) normalized() OutlierConfig {
+	if c.Method == OutlierSD && c.SDMultiplier == 0 {
+		c.SDMultiplier = 2
+	}
+	return c
+}
+
+// String renders the config as stored on blocks, e.g. "sd:3" or "iqr"
+func (c OutlierConfig) String() string {
+	if c.Method == OutlierSD {
+		return OutlierSD + ":" + strconv.FormatFloat(c.normalized().SDMultiplier, 'g', -1, 64)
+	}
+	return c.Method
+}
+
+// parseOutlierMethod parses the outlier method stored on a block, the
+// inverse of String
+func parseOutlierMethod(method string) (OutlierConfig, error) {
+	name, multiplier, ok := strings.Cut(method, ":")
+	cfg := OutlierConfig{Method: name}
+	if ok {
+		k, err := strconv.ParseFloat(multiplier, 64)
+		if err != nil {
+			return OutlierConfig{}, fmt.Errorf("Ungültige Ausreißer-Methode: %s", method)
+		}
+		cfg.SDMultiplier = k
+	}
+	if err := cfg.Validate(); err != nil {
+		return OutlierConfig{}, err
+	}
+	return cfg.normalized(), nil
+}
+
+// isDefault reports whether c is the 2-SD rule, whose bounds are the
+// block's TwoSDLower and TwoSDUpper
+func (c OutlierConfig) isDefault() bool {
+	return c.normalized() == DefaultOutlierConfig
+}
+
+// bounds returns the fences outside which values are outliers. mean is
+// the mean of values.
+func (c OutlierConfig) bounds(values []float64, mean float64) (lower, upper float64) {
+	switch c.Method {
+	case OutlierIQR:
+		if len(values) == 0 {
+			return math.NaN(), math.NaN()
+		}
+		sorted := slices.Clone(values)
+		sort.Float64s(sorted)
+		return c.boundsOf(mean, 0, sorted)
+	default:
+		return c.boundsOf(mean, math.Sqrt(calculateVariance(values, mean)), nil)
+	}
+}
+
+// boundsOf returns the fences of values with the given mean and SD; the
+// IQR method needs the values sorted instead, which must not be empty
+func (c OutlierConfig) boundsOf(mean, sd float64, sorted []float64) (lower, upper float64) {
+	if c.Method == OutlierIQR {
+		q1, q3 := quantileSorted(sorted, 25), quantileSorted(sorted, 75)
+		iqr := q3 - q1
+		return q1 - 1.5*iqr, q3 + 1.5*iqr
+	}
+	k := c.normalized().SDMultiplier
+	return mean - k*sd, mean + k*sd
+}
+
+// SetOutlierConfig selects how the outliers of blocks added from now on
+// are detected. Existing blocks keep their outliers and hashes.
+func (bc *Blockchain) SetOutlierConfig(cfg OutlierConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.outliers = cfg.normalized()
+	return nil
+}
+
+// OutlierConfig returns the outlier detection used for new blocks
+func (bc *Blockchain) OutlierConfig() OutlierConfig {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.outliers
+}
+
+// OutlierBounds returns the bounds outside which values of the block are
+// outliers: the 2-SD range unless the block records another method
+func (b *Block) OutlierBounds() (lower, upper float64) {
+	if b.OutlierMethod == "" {
+		return b.TwoSDLower, b.TwoSDUpper
+	}
+	return b.LowerBound, b.UpperBound
+}
+
+// applyOutlierConfig sets the outlier method and bounds of a block whose
+// mean and 2-SD range are known and returns the bounds to use. The
+// default method leaves the block unchanged, so its hash payload is the
+// one of blocks from before outlier detection became configurable.
+func applyOutlierConfig(block *Block, cfg OutlierConfig, values []float64) (lower, upper float64) {
+	return applyOutlierBounds(block, cfg, func() (float64, float64) { return cfg.bounds(values, block.Mean) })
+}
+
+// applyOutlierBounds is applyOutlierConfig with the bounds of a method
+// other than the default computed by bounds
+func applyOutlierBounds(block *Block, cfg OutlierConfig, bounds func() (lower, upper float64)) (lower, upper float64) {
+	if cfg.isDefault() {
+		return block.TwoSDLower, block.TwoSDUpper
+	}
+	block.OutlierMethod = cfg.String()
+	block.LowerBound, block.UpperBound = bounds()
+	return block.LowerBound, block.UpperBound
+}
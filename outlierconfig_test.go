@@ -0,0 +1,73 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestOutlierMethods(t *testing.T) {
+	// mean 5, standard deviation 2, quartiles 4 and 5.5
+	values := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	tests := []struct {
+		cfg      OutlierConfig
+		method   string
+		outliers []float64
+		lower    float64
+		upper    float64
+	}{
+		{OutlierConfig{Method: OutlierSD}, "", []float64{}, 1, 9},
+		{OutlierConfig{Method: OutlierSD, SDMultiplier: 1}, "sd:1", []float64{2, 9}, 3, 7},
+		{OutlierConfig{Method: OutlierSD, SDMultiplier: 1.5}, "sd:1.5", []float64{9}, 2, 8},
+		{OutlierConfig{Method: OutlierIQR}, "iqr", []float64{9}, 1.75, 7.75},
+	}
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if err := bc.SetOutlierConfig(tt.cfg); err != nil {
+			t.Fatal(err)
+		}
+		block, err := bc.AddBlock(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.OutlierMethod != tt.method || !slices.Equal(block.Outliers, tt.outliers) {
+			t.Fatalf("%s: block records method %q with outliers %v, want %q with %v", tt.cfg, block.OutlierMethod, block.Outliers, tt.method, tt.outliers)
+		}
+		if lower, upper := block.OutlierBounds(); !near(lower, tt.lower) || !near(upper, tt.upper) {
+			t.Fatalf("%s: bounds are %v and %v, want %v and %v", tt.cfg, lower, upper, tt.lower, tt.upper)
+		}
+		if tt.method != "" {
+			parsed, err := parseOutlierMethod(tt.method)
+			if err != nil || parsed != tt.cfg.normalized() {
+				t.Fatalf("%s parses to %+v, %v", tt.method, parsed, err)
+			}
+		}
+	}
+
+	// changing the method leaves the blocks added before as they are
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := bc.BlockByIndex(2); !slices.Equal(block.Outliers, []float64{2, 9}) {
+		t.Fatalf("block 2 has outliers %v after the method changed", block.Outliers)
+	}
+}
+
+func TestOutlierConfigRejected(t *testing.T) {
+	for _, cfg := range []OutlierConfig{
+		{Method: "mad"},
+		{Method: OutlierSD, SDMultiplier: -1},
+		{Method: OutlierIQR, SDMultiplier: 2},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("config %+v accepted", cfg)
+		}
+	}
+	for _, method := range []string{"sd:x", "iqr:2", "mad"} {
+		if _, err := parseOutlierMethod(method); err == nil {
+			t.Fatalf("method %q parsed", method)
+		}
+	}
+}
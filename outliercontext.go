@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultOutlierContextValues bounds the context values stored per block
+const defaultOutlierContextValues = 1000
+
+// OutlierContext holds the values around one outlier of a block, by
+// position in the values the block was created with
+type OutlierContext struct {
+	Position int       `json:"position"`
+	Value    float64   `json:"value"`
+	Before   []float64 `json:"before"`
+	After    []float64 `json:"after"`
+}
+
+// SetOutlierContext makes new float blocks capture up to window values
+// before and after each outlier into OutlierContexts. At most maxValues
+// context values are stored per block, 0 uses a default of 1000; outliers
+// past that budget get no context. A window of 0 disables capturing.
+func (bc *Blockchain) SetOutlierContext(window, maxValues int) error {
+	if window < 0 || maxValues < 0 {
+		return fmt.Errorf("Ungültiges Kontextfenster: %d Werte, höchstens %d", window, maxValues)
+	}
+	if maxValues == 0 {
+		maxValues = defaultOutlierContextValues
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.contextWindow = window
+	bc.contextMaxValues = maxValues
+	return nil
+}
+
+// captureOutlierContexts returns the context windows of the values outside
+// [lower, upper], truncated at the edges of values and limited to
+// maxValues context values in total
+func captureOutlierContexts(values []float64, lower, upper float64, window, maxValues int) []OutlierContext {
+	var contexts []OutlierContext
+	budget := maxValues
+	for i, value := range values {
+		if value >= lower && value <= upper {
+			continue
+		}
+		start := max(i-window, 0)
+		end := min(i+window+1, len(values))
+		size := end - start - 1
+		if size > budget {
+			break
+		}
+		budget -= size
+		contexts = append(contexts, OutlierContext{
+			Position: i,
+			Value:    value,
+			Before:   append([]float64(nil), values[start:i]...),
+			After:    append([]float64(nil), values[i+1:end]...),
+		})
+	}
+	return contexts
+}
+
+// String renders the context as "before [value] after"
+func (c OutlierContext) String() string {
+	var sb strings.Builder
+	for _, v := range c.Before {
+		fmt.Fprintf(&sb, "%.2f ", v)
+	}
+	fmt.Fprintf(&sb, "[%.2f]", c.Value)
+	for _, v := range c.After {
+		fmt.Fprintf(&sb, " %.2f", v)
+	}
+	return sb.String()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestOutlierBlocksKeepTheirHash(t *testing.T) {
+	bc := newFilledChain(t)
+	blocks := bc.Blocks()
+	if err := checkLinked(blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	var flagged []int
+	for _, block := range blocks {
+		if block.HasOutliers != (len(block.Outliers) > 0) {
+			t.Fatalf("block %d has HasOutliers %v with outliers %v", block.Index, block.HasOutliers, block.Outliers)
+		}
+		if block.HasOutliers {
+			flagged = append(flagged, block.Index)
+		}
+	}
+	if want := []int{2, 4}; !slices.Equal(flagged, want) {
+		t.Fatalf("blocks %v are flagged, want %v", flagged, want)
+	}
+	var listed []int
+	for _, block := range bc.OutlierBlocks() {
+		listed = append(listed, block.Index)
+	}
+	if !slices.Equal(listed, flagged) {
+		t.Fatalf("OutlierBlocks returned %v, want %v", listed, flagged)
+	}
+}
+
+func TestOutlierFlagIsHashed(t *testing.T) {
+	block := &Block{Index: 1, Values: []float64{1, 2}, HashVersion: currentHashVersion}
+	plain := calculateHash(block)
+	block.HasOutliers = true
+	if calculateHash(block) == plain {
+		t.Fatal("HasOutliers is not covered by the hash")
+	}
+}
+
+func TestCaptureOutlierContexts(t *testing.T) {
+	values := []float64{90, 1, 2, 3, 80, 4, 5, 6, 70}
+	contexts := captureOutlierContexts(values, 0, 10, 2, 1000)
+	want := []OutlierContext{
+		{Position: 0, Value: 90, Before: nil, After: []float64{1, 2}},
+		{Position: 4, Value: 80, Before: []float64{2, 3}, After: []float64{4, 5}},
+		{Position: 8, Value: 70, Before: []float64{5, 6}, After: nil},
+	}
+	if len(contexts) != len(want) {
+		t.Fatalf("captured %v, want %v", contexts, want)
+	}
+	for i, c := range contexts {
+		if c.Position != want[i].Position || c.Value != want[i].Value ||
+			!slices.Equal(c.Before, want[i].Before) || !slices.Equal(c.After, want[i].After) {
+			t.Fatalf("context %d is %+v, want %+v", i, c, want[i])
+		}
+	}
+
+	// the first two windows take 2 and 4 values of a budget of 7
+	if contexts := captureOutlierContexts(values, 0, 10, 2, 7); len(contexts) != 2 {
+		t.Fatalf("captured %d contexts within a budget of 7 values, want 2", len(contexts))
+	}
+}
+
+func TestOutlierContextsAreNotHashed(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetOutlierContext(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	block, err := bc.AddBlock([]float64{10, 10, 10, 10, 10, 10, 10, 10, 10, 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.OutlierContexts) != 1 || !slices.Equal(block.OutlierContexts[0].Before, []float64{10}) || block.OutlierContexts[0].After != nil {
+		t.Fatalf("OutlierContexts are %+v, want 90 after a 10", block.OutlierContexts)
+	}
+	hash := calculateHash(block)
+	block.OutlierContexts = nil
+	if calculateHash(block) != hash {
+		t.Fatal("OutlierContexts are covered by the hash")
+	}
+	if err := bc.SetOutlierContext(-1, 0); err == nil {
+		t.Fatal("SetOutlierContext accepted a negative window")
+	}
+}
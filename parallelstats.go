@@ -0,0 +1,163 @@
+package main
+
+import (
+	"math"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// parallelStatsMin is the number of values from which calculateBlockStats
+// splits the work across goroutines; below it the overhead outweighs the
+// gain
+const parallelStatsMin = 1 << 15
+
+// statsChunkSize is the number of values each goroutine takes at a time.
+// The chunks depend on the number of values only, so the stats of a block
+// come out the same on every machine and VerifyStats recomputes them
+// exactly.
+const statsChunkSize = 1 << 13
+
+// calculateLargeBlockStats is calculateBlockStats for large blocks, with
+// the same stages. The values are sorted once, alongside the rest, for
+// the median, the percentiles and the IQR fences. The mean and the
+// variance come from a single pass over chunks of the values in
+// parallel, as do the outliers. They may differ from the single pass of
+// small blocks in the last bits.
+func calculateLargeBlockStats(block *Block, outliers OutlierConfig, buckets int) {
+	values := block.Values
+
+	var sorted []float64
+	var sortFailure any
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() { sortFailure = recover() }()
+		sorted = slices.Clone(values)
+		sort.Float64s(sorted)
+	}()
+	var m moments
+	runStatsStage(block, StageMean, func() {
+		m = chunkedMoments(values)
+		block.Mean = m.mean
+	})
+	wg.Wait()
+	if sortFailure != nil {
+		recordStatsError(block, StageSummary, sortFailure)
+		recordStatsError(block, StageMedian, sortFailure)
+	} else {
+		runStatsStage(block, StageSummary, func() { summarizeSorted(block, sorted, buckets) })
+		runStatsStage(block, StageMedian, func() { block.Median = medianSorted(sorted) })
+	}
+
+	if statsFailed(block, StageMean) {
+		return
+	}
+	sd := math.Sqrt(m.m2 / float64(m.n))
+	runStatsStage(block, StageTwoSD, func() {
+		block.TwoSDLower, block.TwoSDUpper = block.Mean-2*sd, block.Mean+2*sd
+	})
+	if statsFailed(block, StageTwoSD) {
+		return
+	}
+	runStatsStage(block, StageOutliers, func() {
+		lower, upper := applyOutlierBounds(block, outliers, func() (float64, float64) {
+			return outliers.boundsOf(block.Mean, sd, sorted)
+		})
+		block.Outliers = chunkedOutliers(values, lower, upper)
+	})
+}
+
+// moments are the count, mean and sum of squared differences from the
+// mean of some values
+type moments struct {
+	n    int
+	mean float64
+	m2   float64
+}
+
+// chunkedMoments computes the moments of each chunk of values in
+// parallel and merges them in order with the formula of Chan et al., which
+// keeps the variance as accurate as two passes over all values
+func chunkedMoments(values []float64) moments {
+	chunks := make([]moments, numChunks(len(values)))
+	sums := make([]float64, len(chunks))
+	forEachChunk(len(values), func(i, start, end int) {
+		chunk := values[start:end]
+		for _, v := range chunk {
+			sums[i] += v
+		}
+		mean := sums[i] / float64(len(chunk))
+		chunks[i] = moments{n: len(chunk), mean: mean, m2: calculateVariance(chunk, mean) * float64(len(chunk))}
+	})
+
+	var total moments
+	var sum float64
+	for i, c := range chunks {
+		sum += sums[i]
+		total.n += c.n
+	}
+	total.mean = sum / float64(total.n)
+	for _, c := range chunks {
+		d := c.mean - total.mean
+		total.m2 += c.m2 + d*d*float64(c.n)
+	}
+	return total
+}
+
+// chunkedOutliers is calculateOutliers over chunks of values in parallel
+func chunkedOutliers(values []float64, lower, upper float64) []float64 {
+	chunks := make([][]float64, numChunks(len(values)))
+	forEachChunk(len(values), func(i, start, end int) {
+		chunks[i] = calculateOutliers(values[start:end], lower, upper)
+	})
+	return slices.Concat(chunks...)
+}
+
+// numChunks returns the number of chunks of statsChunkSize that n values
+// take up
+func numChunks(n int) int {
+	return (n + statsChunkSize - 1) / statsChunkSize
+}
+
+// forEachChunk calls fn for every chunk of n values, with its number and
+// the range of values it covers, on up to GOMAXPROCS goroutines. A panic
+// of fn is raised again in the caller once all goroutines returned, so
+// runStatsStage records it.
+func forEachChunk(n int, fn func(i, start, end int)) {
+	chunks := numChunks(n)
+	var next atomic.Int64
+	var failure atomic.Value
+	var wg sync.WaitGroup
+	for range min(runtime.GOMAXPROCS(0), chunks) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					failure.CompareAndSwap(nil, panicValue{r})
+				}
+			}()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= chunks {
+					return
+				}
+				fn(i, i*statsChunkSize, min((i+1)*statsChunkSize, n))
+			}
+		}()
+	}
+	wg.Wait()
+	if r, ok := failure.Load().(panicValue); ok {
+		panic(r.value)
+	}
+}
+
+// panicValue wraps a recovered value for atomic.Value, which only holds
+// values of one concrete type
+type panicValue struct {
+	value any
+}
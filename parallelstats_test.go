@@ -0,0 +1,111 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// largeStatsValues returns n normal values with a spike every 997 values
+func largeStatsValues(n int) []float64 {
+	r := rand.New(rand.NewSource(295))
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = 50 + 5*r.NormFloat64()
+		if i%997 == 0 {
+			values[i] += 40
+		}
+	}
+	return values
+}
+
+// naiveBlockStats runs the stages of calculateBlockStats for small blocks
+// one after the other, whatever the number of values
+func naiveBlockStats(values []float64, outliers OutlierConfig) *Block {
+	block := &Block{Values: values}
+	summarizeValues(block, values, defaultHistogramBuckets)
+	block.Median = calculateMedian(values)
+	block.Mean = calculateMean(values)
+	block.TwoSDLower, block.TwoSDUpper = calculateTwoSDRange(values, block.Mean)
+	lower, upper := applyOutlierConfig(block, outliers, values)
+	block.Outliers = calculateOutliers(values, lower, upper)
+	return block
+}
+
+func TestLargeBlockStatsMatchNaive(t *testing.T) {
+	configs := []OutlierConfig{DefaultOutlierConfig, {Method: OutlierSD, SDMultiplier: 3}, {Method: OutlierIQR}}
+	// around the threshold, with a last chunk that is short, and large
+	for _, n := range []int{parallelStatsMin, parallelStatsMin + 1, 100000, 1000000} {
+		values := largeStatsValues(n)
+		for _, cfg := range configs {
+			t.Run(strconv.Itoa(n)+"/"+cfg.String(), func(t *testing.T) {
+				want := naiveBlockStats(values, cfg)
+				got := &Block{Values: values}
+				calculateBlockStats(got, cfg, defaultHistogramBuckets)
+				if len(got.StatsErrors) > 0 {
+					t.Fatalf("stats failed: %v", got.StatsErrors)
+				}
+
+				// the sums are merged from chunks and may differ in the
+				// last bits
+				near := func(x, y float64) bool { return math.Abs(x-y) <= 1e-12*math.Max(1, math.Abs(y)) }
+				if !near(got.Mean, want.Mean) || !near(got.TwoSDLower, want.TwoSDLower) || !near(got.TwoSDUpper, want.TwoSDUpper) {
+					t.Fatalf("mean %v and range [%v, %v], want %v and [%v, %v]", got.Mean, got.TwoSDLower, got.TwoSDUpper, want.Mean, want.TwoSDLower, want.TwoSDUpper)
+				}
+				// everything read from the sorted values is exact
+				if got.Median != want.Median || !maps.Equal(got.Percentiles, want.Percentiles) || !slices.Equal(got.Histogram, want.Histogram) {
+					t.Fatalf("median %v, percentiles %v and histogram %v, want %v, %v and %v", got.Median, got.Percentiles, got.Histogram, want.Median, want.Percentiles, want.Histogram)
+				}
+				if !slices.Equal(got.Outliers, want.Outliers) || got.OutlierMethod != want.OutlierMethod {
+					t.Fatalf("%d outliers by %s, want %d by %s", len(got.Outliers), got.OutlierMethod, len(want.Outliers), want.OutlierMethod)
+				}
+			})
+		}
+	}
+}
+
+func TestLargeBlockStatsAreReproducible(t *testing.T) {
+	values := largeStatsValues(200000)
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := bc.AddBlock(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// VerifyStats recomputes the chunked sums bit for bit
+	if err := block.VerifyStats(); err != nil {
+		t.Fatal(err)
+	}
+	again := &Block{Values: values}
+	calculateBlockStats(again, DefaultOutlierConfig, defaultHistogramBuckets)
+	if again.Mean != block.Mean || again.TwoSDUpper != block.TwoSDUpper {
+		t.Fatalf("a second run got mean %v and upper bound %v, want %v and %v", again.Mean, again.TwoSDUpper, block.Mean, block.TwoSDUpper)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkBlockStats compares calculateBlockStats with the naive stages
+// at 1k, 100k and 1M values; below parallelStatsMin both take the single
+// goroutine path
+func BenchmarkBlockStats(b *testing.B) {
+	for _, n := range []int{1000, 100000, 1000000} {
+		values := largeStatsValues(n)
+		b.Run("naive/n="+strconv.Itoa(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				naiveBlockStats(values, DefaultOutlierConfig)
+			}
+		})
+		b.Run("chunked/n="+strconv.Itoa(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				calculateBlockStats(&Block{Values: values}, DefaultOutlierConfig, defaultHistogramBuckets)
+			}
+		})
+	}
+}
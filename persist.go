@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultChainFile is the file main resumes from and saves to
+const defaultChainFile = "blockchain.json"
+
+// chainFileVersion is the version of the format written by SaveToFile
+const chainFileVersion = 1
+
+// ErrUnsupportedChainFile is returned for chain files of an unknown version
+var ErrUnsupportedChainFile = errors.New("Nicht unterstützte Version der Blockchain-Datei")
+
+// chainFile is the on-disk form of a chain. The first block's index is the
+// chain's history start; Stub marks it as the record of the checkpoint the
+// chain was bootstrapped from, Archive names the blocks it pruned and
+// Expired the newest block the retention policy deleted.
+// Genesis keeps the Info of a chain that no longer holds its genesis.
+type chainFile struct {
+	Version    int         `json:"version"`
+	Name       string      `json:"name,omitempty"`
+	Genesis    *ChainInfo  `json:"genesis,omitempty"`
+	ValueKind  ValueKind   `json:"value_kind"`
+	IDScheme   IDScheme    `json:"id_scheme"`
+	Fork       *ForkOrigin `json:"fork,omitempty"`
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+	Stub       bool        `json:"stub,omitempty"`
+	Archive    *archiveRef `json:"archive,omitempty"`
+	Expired    *expiredRef `json:"expired,omitempty"`
+	Blocks     []*Block    `json:"blocks"`
+}
+
+// blockFields has the fields of a Block without its JSON methods
+type blockFields Block
+
+// coreBlockFields are the fields every block in JSON must have, whatever
+// version wrote it; all others are optional. Keys no field of Block has
+// are kept in Extensions, so files of later versions decode and save
+// again without losing them.
+var coreBlockFields = []string{"index", "timestamp", "hash", "prev_hash"}
+
+// ErrMissingCoreField is returned for a block in JSON without one of the
+// coreBlockFields
+var ErrMissingCoreField = errors.New("Block ohne Pflichtfeld")
+
+// knownBlockFields are the JSON names of the fields of Block
+var knownBlockFields = func() map[string]bool {
+	known := make(map[string]bool)
+	t := reflect.TypeOf(Block{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+	return known
+}()
+
+// blockJSON encodes the stats of a block as jsonFloat, since blocks
+// without values have NaN stats, which JSON cannot represent. Values and
+// Outliers are always arrays, [] rather than null when there are none;
+// decoding turns [] back into nil. Extensions are written after the
+// fields of Block, see coreBlockFields.
+type blockJSON struct {
+	*blockFields
+	Values     []float64 `json:"values"`
+	Outliers   []float64 `json:"outliers"`
+	Mean       jsonFloat `json:"mean"`
+	Median     jsonFloat `json:"median"`
+	TwoSDLower jsonFloat `json:"two_sd_lower"`
+	TwoSDUpper jsonFloat `json:"two_sd_upper"`
+	LowerBound jsonFloat `json:"lower_bound,omitempty"`
+	UpperBound jsonFloat `json:"upper_bound,omitempty"`
+	// the score is infinite for a shift from a baseline without spread
+	AnomalyScore jsonFloat `json:"anomaly_score,omitempty"`
+}
+
+func (b Block) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(blockJSON{
+		blockFields: (*blockFields)(&b),
+		Values:      nonNil(b.Values),
+		Outliers:    nonNil(b.Outliers),
+		Mean:        jsonFloat(b.Mean),
+		Median:      jsonFloat(b.Median),
+		TwoSDLower:  jsonFloat(b.TwoSDLower),
+		TwoSDUpper:  jsonFloat(b.TwoSDUpper),
+		LowerBound:  jsonFloat(b.LowerBound),
+		UpperBound:  jsonFloat(b.UpperBound),
+
+		AnomalyScore: jsonFloat(b.AnomalyScore),
+	})
+	if err != nil || len(b.Extensions) == 0 {
+		return data, err
+	}
+	// the object is closed again after the extensions, sorted by name
+	names := make([]string, 0, len(b.Extensions))
+	for name := range b.Extensions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	data = data[:len(data)-1]
+	for _, name := range names {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		data = append(append(append(append(data, ','), key...), ':'), b.Extensions[name]...)
+	}
+	return append(data, '}'), nil
+}
+
+func (b *Block) UnmarshalJSON(data []byte) error {
+	aux := blockJSON{blockFields: (*blockFields)(b)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	b.Values, b.Outliers = nil, nil
+	if len(aux.Values) > 0 {
+		b.Values = aux.Values
+	}
+	if len(aux.Outliers) > 0 {
+		b.Outliers = aux.Outliers
+	}
+	b.Mean, b.Median = float64(aux.Mean), float64(aux.Median)
+	b.TwoSDLower, b.TwoSDUpper = float64(aux.TwoSDLower), float64(aux.TwoSDUpper)
+	b.LowerBound, b.UpperBound = float64(aux.LowerBound), float64(aux.UpperBound)
+	b.AnomalyScore = float64(aux.AnomalyScore)
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for _, name := range coreBlockFields {
+		if _, ok := fields[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrMissingCoreField, name)
+		}
+	}
+	b.Extensions = nil
+	for name, raw := range fields {
+		if knownBlockFields[name] {
+			continue
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			return err
+		}
+		if b.Extensions == nil {
+			b.Extensions = make(map[string]json.RawMessage)
+		}
+		b.Extensions[name] = compact.Bytes()
+	}
+	return nil
+}
+
+// UnknownField is a field of the blocks of a chain that this version does
+// not know, kept in their Extensions
+type UnknownField struct {
+	Name string `json:"name"`
+	// Blocks counts the blocks that have it
+	Blocks int `json:"blocks"`
+}
+
+func (f UnknownField) String() string {
+	if f.Blocks == 1 {
+		return fmt.Sprintf("%s (1 Block)", f.Name)
+	}
+	return fmt.Sprintf("%s (%d Blöcke)", f.Name, f.Blocks)
+}
+
+// UnknownFields returns the fields the blocks of the chain carry as
+// Extensions, sorted by name
+func (bc *Blockchain) UnknownFields() ([]UnknownField, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	blocks, err := bc.allBlocks()
+	if err != nil {
+		return nil, err
+	}
+	return unknownFields(blocks), nil
+}
+
+// unknownFields counts the Extensions of blocks by name
+func unknownFields(blocks []*Block) []UnknownField {
+	counts := make(map[string]int)
+	for _, block := range blocks {
+		for name := range block.Extensions {
+			counts[name]++
+		}
+	}
+	fields := make([]UnknownField, 0, len(counts))
+	for name, n := range counts {
+		fields = append(fields, UnknownField{Name: name, Blocks: n})
+	}
+	slices.SortFunc(fields, func(a, b UnknownField) int { return strings.Compare(a.Name, b.Name) })
+	return fields
+}
+
+// nonNil returns values, or an empty slice if it is nil
+func nonNil(values []float64) []float64 {
+	if values == nil {
+		return []float64{}
+	}
+	return values
+}
+
+// jsonFloat is a float64 that encodes NaN and the infinities as strings
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return json.Marshal(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return json.Marshal(v)
+}
+
+func (f *jsonFloat) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("Ungültige Zahl %q", s)
+		}
+		*f = jsonFloat(v)
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = jsonFloat(v)
+	return nil
+}
+
+// SaveToFile writes the chain, with all block fields, to path as JSON,
+// compressed with the codec set with SetCodec. The file is replaced
+// atomically. Settings such as limits and rules are not saved; they come
+// from the configuration.
+func (bc *Blockchain) SaveToFile(path string) error {
+	data, err := bc.marshalChainFile()
+	if err != nil {
+		return err
+	}
+	return writeCodecFile(path, data, bc.Codec())
+}
+
+// marshalChainFile encodes the chain in the format of SaveToFile
+func (bc *Blockchain) marshalChainFile() ([]byte, error) {
+	file, err := bc.chainFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return nil, fmt.Errorf("Blockchain konnte nicht gespeichert werden: %w", err)
+	}
+	return data, nil
+}
+
+// chainFile returns the chain as SaveToFile writes it
+func (bc *Blockchain) chainFile() (*chainFile, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	blocks, err := bc.allBlocks()
+	if err != nil {
+		return nil, err
+	}
+	return &chainFile{
+		Version:    chainFileVersion,
+		Name:       bc.name,
+		Genesis:    bc.genesis,
+		ValueKind:  bc.valueKind,
+		IDScheme:   bc.idScheme,
+		Fork:       bc.fork,
+		Checkpoint: bc.checkpoint,
+		Stub:       bc.stub,
+		Archive:    bc.archive,
+		Expired:    bc.expired,
+		Blocks:     blocks,
+	}, nil
+}
+
+// LoadBlockchainFromFile restores a chain written by SaveToFile with any
+// codec or none. The chain is validated; a file whose hashes or links do
+// not check out is refused, as is one whose saved Info does not match the
+// genesis block it holds.
+func LoadBlockchainFromFile(path string) (*Blockchain, error) {
+	data, err := readCodecFile(path)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := decodeChainFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("Blockchain-Datei %s: %w", path, err)
+	}
+	return bc, nil
+}
+
+// decodeChainFile restores a chain from data in the format of SaveToFile
+// as LoadBlockchainFromFile does
+func decodeChainFile(data []byte) (*Blockchain, error) {
+	file, err := parseChainFile(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Blocks) == 0 {
+		return nil, errors.New("Datei enthält keine Blöcke")
+	}
+
+	bc := newBlockchain(ChainConfig{})
+	bc.name = file.Name
+	bc.valueKind = file.ValueKind
+	bc.idScheme = file.IDScheme
+	bc.fork = file.Fork
+	bc.checkpoint = file.Checkpoint
+	bc.stub = file.Stub
+	bc.archive = file.Archive
+	bc.expired = file.Expired
+	bc.genesis = file.Genesis
+	bc.holdBlocks(file.Blocks)
+
+	if err := bc.Validate(); err != nil {
+		return nil, err
+	}
+	if file.Genesis != nil && !file.Genesis.matches(*bc.genesis) {
+		return nil, &ValidationError{Index: bc.base, Reason: "Angaben zur Blockchain passen nicht zum Genesis-Block"}
+	}
+	if unknown := unknownFields(file.Blocks); len(unknown) > 0 {
+		names := make([]string, len(unknown))
+		for i, field := range unknown {
+			names[i] = field.String()
+		}
+		log.Printf("Unbekannte Felder werden unverändert übernommen: %s", strings.Join(names, ", "))
+	}
+	return bc, nil
+}
+
+// parseChainFile decodes data in the format of SaveToFile without checking
+// the chain it holds
+func parseChainFile(data []byte) (*chainFile, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	if header.Version != chainFileVersion {
+		return nil, fmt.Errorf("%w: Version %d", ErrUnsupportedChainFile, header.Version)
+	}
+	var file chainFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// LoadNamedBlockchain restores a chain like LoadBlockchainFromFile and
+// returns ErrChainMismatch unless its genesis is named name, so a file of
+// another dataset is not resumed by mistake. An empty name accepts every
+// chain.
+func LoadNamedBlockchain(path, name string) (*Blockchain, error) {
+	bc, err := LoadBlockchainFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkChainName(bc, name); err != nil {
+		return nil, fmt.Errorf("Blockchain-Datei %s: %w", path, err)
+	}
+	return bc, nil
+}
+
+// loadDefaultChain resumes the chain saved in defaultChainFile, which must
+// be named cfg.Name if set, or starts a new one with cfg if there is none
+func loadDefaultChain(cfg ChainConfig) (*Blockchain, error) {
+	bc, err := LoadNamedBlockchain(defaultChainFile, cfg.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewBlockchain(WithChainConfig(cfg))
+	}
+	return bc, err
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeFutureChainFile saves a chain holding chainTestValues and adds
+// fields a later version might write to blocks 2 and 4, indented as
+// another writer would. It returns the path of the file.
+func writeFutureChainFile(t *testing.T) string {
+	t.Helper()
+	bc := newFilledChain(t)
+	path := filepath.Join(t.TempDir(), "future.json")
+	if err := bc.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	var file map[string]any
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	blocks := file["blocks"].([]any)
+	blocks[2].(map[string]any)["future_score"] = 0.93
+	blocks[2].(map[string]any)["series"] = map[string]any{"temperatur": []any{1.5, 2.5}, "einheit": "°C"}
+	blocks[4].(map[string]any)["future_score"] = 1
+	if data, err = json.MarshalIndent(file, "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// decodeJSONFile decodes the file at path into generic values
+func decodeJSONFile(t *testing.T, path string) any {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestLoadFutureChainFileKeepsUnknownFields(t *testing.T) {
+	logged := captureLog(t)
+	path := writeFutureChainFile(t)
+	bc, err := LoadBlockchainFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Unbekannte Felder werden unverändert übernommen: future_score (2 Blöcke), series (1 Block)"; !strings.Contains(logged.String(), want) {
+		t.Fatalf("log does not contain %q:\n%s", want, logged)
+	}
+	unknown, err := bc.UnknownFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []UnknownField{{"future_score", 2}, {"series", 1}}; !slices.Equal(unknown, want) {
+		t.Fatalf("unknown fields are %v, want %v", unknown, want)
+	}
+	block, err := bc.BlockByIndex(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(block.Extensions["series"]); got != `{"einheit":"°C","temperatur":[1.5,2.5]}` {
+		t.Fatalf("extension series of block 2 is %s", got)
+	}
+	// the block returned does not share its extensions with the chain
+	block.Extensions["series"][1] = 'X'
+	block.Extensions["future_score"] = json.RawMessage(`0`)
+	if again, err := bc.BlockByIndex(2); err != nil || string(again.Extensions["series"]) != `{"einheit":"°C","temperatur":[1.5,2.5]}` ||
+		string(again.Extensions["future_score"]) != "0.93" {
+		t.Fatalf("changing the extensions of a returned block changed the chain: %v, %v", again.Extensions, err)
+	}
+
+	resaved := filepath.Join(t.TempDir(), "resaved.json")
+	if err := bc.SaveToFile(resaved); err != nil {
+		t.Fatal(err)
+	}
+	if before, after := decodeJSONFile(t, path), decodeJSONFile(t, resaved); !reflect.DeepEqual(before, after) {
+		t.Fatalf("resaved file differs from the one loaded:\n%v\n%v", before, after)
+	}
+
+	// every format keeps the extensions, and the hashes do not cover them
+	for _, format := range roundTripBaseFormats {
+		if err := VerifyFormatRoundTrip(format, bc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ndjson bytes.Buffer
+	if err := bc.ExportNDJSON(&ndjson, nil); err != nil {
+		t.Fatal(err)
+	}
+	if line := strings.Split(ndjson.String(), "\n")[4]; !strings.Contains(line, `"future_score":1}`) {
+		t.Fatalf("ndjson line of block 4 is %s, want the extension last", line)
+	}
+}
+
+func TestExtensionsOutsideHash(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := bc.AddBlock(chainTestValues[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	extended := *block
+	extended.Extensions = map[string]json.RawMessage{"future_score": json.RawMessage(`0.5`)}
+	if calculateHash(&extended) != block.Hash {
+		t.Fatal("hash covers the extensions of a block")
+	}
+	if err := verifyBlock(&extended); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(&extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Block
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Extensions, extended.Extensions) || decoded.Hash != block.Hash {
+		t.Fatalf("block decoded as %+v, want the extension back", decoded)
+	}
+}
+
+func TestBlockWithoutCoreFieldRejected(t *testing.T) {
+	for _, name := range coreBlockFields {
+		fields := map[string]any{"index": 1, "timestamp": "2024-05-01T12:00:00Z", "hash": "ab", "prev_hash": "cd"}
+		delete(fields, name)
+		data, _ := json.Marshal(fields)
+		var block Block
+		if err := json.Unmarshal(data, &block); !errors.Is(err, ErrMissingCoreField) || !strings.Contains(err.Error(), name) {
+			t.Fatalf("block without %s decoded with %v, want ErrMissingCoreField", name, err)
+		}
+	}
+}
+
+func TestSaveAndLoadChain(t *testing.T) {
+	bc := newFilledChain(t)
+	if err := bc.AddBlockWithMetadata([]float64{0.5, 1.5}, "Messreihe 2", map[string]string{"sensor": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), defaultChainFile)
+	if err := bc.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadBlockchainFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.HeadHash() != bc.HeadHash() {
+		t.Fatalf("loaded head is %s, want %s", loaded.HeadHash(), bc.HeadHash())
+	}
+	want, got := bc.Blocks(), loaded.Blocks()
+	if len(got) != len(want) {
+		t.Fatalf("loaded %d blocks, want %d", len(got), len(want))
+	}
+	// the JSON of a block holds all its fields, NaN stats included
+	for i := range want {
+		wantJSON, err := json.Marshal(want[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotJSON, err := json.Marshal(got[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(gotJSON, wantJSON) {
+			t.Fatalf("block %d loaded as %s, want %s", i, gotJSON, wantJSON)
+		}
+	}
+	if _, err := loaded.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlockJSONArrays(t *testing.T) {
+	bc := newFilledChain(t)
+	genesis, err := bc.BlockByIndex(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := bc.AddBlock([]float64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []*Block{genesis, block} {
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatal(err)
+		}
+		if string(fields["outliers"]) != "[]" || b.Index == 0 && string(fields["values"]) != "[]" {
+			t.Fatalf("block %d is encoded with values %s and outliers %s", b.Index, fields["values"], fields["outliers"])
+		}
+
+		// [] and null both decode to nil
+		for _, empty := range []string{"[]", "null"} {
+			fields["outliers"] = json.RawMessage(empty)
+			if b.Index == 0 {
+				fields["values"] = json.RawMessage(empty)
+			}
+			data, err := json.Marshal(fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded Block
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Outliers != nil || b.Index == 0 && decoded.Values != nil {
+				t.Fatalf("block %d with %s decodes to values %#v and outliers %#v", b.Index, empty, decoded.Values, decoded.Outliers)
+			}
+			if hash := calculateHash(&decoded); hash != b.Hash {
+				t.Fatalf("block %d with %s hashes to %s, want %s", b.Index, empty, hash, b.Hash)
+			}
+		}
+	}
+}
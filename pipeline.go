@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Batch is a set of values travelling through a Pipeline towards the chain
+type Batch struct {
+	Source   string
+	Values   []float64
+	Text     string
+	Metadata map[string]string
+	// Times, if set, are the sorted times of Values, see AddTimedValues
+	Times []time.Time
+	// Origins, if set, are parallel to Values and name where each value
+	// came from, see SetTrackOrigins
+	Origins []Origin
+	// ExpectedHead, if set, is the hash the head must still have for the
+	// batch to be appended, see AddBlockIfHead
+	ExpectedHead string
+
+	// Lane is the priority of the batch when the pipeline appends through
+	// an AppendScheduler
+	Lane Lane
+	// Chain, if set by a RouteStage, is the chain the append stage adds
+	// the batch to instead of its own
+	Chain *Blockchain
+
+	// Index is set to the index of the new block by the append stage
+	Index int
+}
+
+// Stage is one step of a Pipeline. Returning an error rejects the batch
+// and skips all later stages.
+type Stage interface {
+	Name() string
+	Process(batch *Batch) error
+}
+
+// SplitStage is a Stage that passes on any number of batches for each one
+// it gets, such as CutStage. A Pipeline calls Split instead of Process
+// and runs the later stages once for every batch returned.
+type SplitStage interface {
+	Stage
+	Split(batch *Batch) ([]*Batch, error)
+	// Flush returns the batches the stage still holds
+	Flush() []*Batch
+}
+
+// StageMetrics counts what a stage has seen
+type StageMetrics struct {
+	Name     string
+	Accepted int
+	Rejected int
+	Duration time.Duration
+}
+
+// Pipeline feeds batches from every ingestion source through the same
+// ordered stages, so a policy configured once applies to all sources. A
+// full pipeline runs ValidateStage, BoundsStage, DedupStage,
+// RateLimitStage, RouteStage and CutStage before the append stage.
+type Pipeline struct {
+	stages []Stage
+
+	mu      sync.Mutex
+	metrics []StageMetrics
+	queue   *IngestQueue
+}
+
+// NewPipeline creates a pipeline running the given stages in order
+func NewPipeline(stages ...Stage) *Pipeline {
+	metrics := make([]StageMetrics, len(stages))
+	for i, stage := range stages {
+		metrics[i].Name = stage.Name()
+	}
+	return &Pipeline{stages: stages, metrics: metrics}
+}
+
+// NewDefaultPipeline creates the pipeline used by the program's own
+// ingestion sources: validation followed by appending to bc through an
+// AppendScheduler, so interactive batches are not starved by bulk ones.
+func NewDefaultPipeline(bc *Blockchain) *Pipeline {
+	return NewPipeline(ValidateStage{}, NewAppendScheduler(bc))
+}
+
+// Submit runs batch through every stage, stopping at the first rejection.
+// With an IngestQueue attached the batch waits its turn in the queue.
+func (p *Pipeline) Submit(batch *Batch) error {
+	if q := p.Queue(); q != nil {
+		return q.submit(batch, true)
+	}
+	return p.process(batch)
+}
+
+// Queue returns the IngestQueue attached to the pipeline, or nil
+func (p *Pipeline) Queue() *IngestQueue {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queue
+}
+
+// enqueue hands batch to the attached IngestQueue without waiting for the
+// stages, or runs them if there is none
+func (p *Pipeline) enqueue(batch *Batch) error {
+	if q := p.Queue(); q != nil {
+		return q.submit(batch, false)
+	}
+	return p.process(batch)
+}
+
+// Flush passes the batches every SplitStage still holds through the
+// stages after it, bypassing an attached IngestQueue
+func (p *Pipeline) Flush() error {
+	for i, stage := range p.stages {
+		splitter, ok := stage.(SplitStage)
+		if !ok {
+			continue
+		}
+		for _, batch := range splitter.Flush() {
+			if err := p.processFrom(i+1, batch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// process runs batch through the stages
+func (p *Pipeline) process(batch *Batch) error {
+	return p.processFrom(0, batch)
+}
+
+// processFrom runs batch through the stages from stage first on. The
+// batches a SplitStage returns run through the rest one after another,
+// and batch takes the index of the last one appended.
+func (p *Pipeline) processFrom(first int, batch *Batch) error {
+	for i := first; i < len(p.stages); i++ {
+		stage := p.stages[i]
+		splitter, split := stage.(SplitStage)
+		var out []*Batch
+		var err error
+		start := time.Now()
+		if split {
+			out, err = splitter.Split(batch)
+		} else {
+			err = stage.Process(batch)
+		}
+		elapsed := time.Since(start)
+
+		p.mu.Lock()
+		p.metrics[i].Duration += elapsed
+		if err != nil {
+			p.metrics[i].Rejected++
+		} else {
+			p.metrics[i].Accepted++
+		}
+		p.mu.Unlock()
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", stage.Name(), err)
+		}
+		if split {
+			for _, next := range out {
+				if err := p.processFrom(i+1, next); err != nil {
+					return err
+				}
+				batch.Index = next.Index
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// AddBlock submits values as a batch without a source
+func (p *Pipeline) AddBlock(values []float64) error {
+	return p.Submit(&Batch{Values: values})
+}
+
+// ForSource returns a Sink submitting bulk batches tagged with source.
+// With an IngestQueue attached the Sink returns once the batch is queued.
+func (p *Pipeline) ForSource(source string) Sink {
+	return p.ForLane(source, LaneBulk)
+}
+
+// ForLane returns a Sink submitting batches tagged with source in lane
+func (p *Pipeline) ForLane(source string, lane Lane) Sink {
+	return sourceSink{pipeline: p, source: source, lane: lane}
+}
+
+type sourceSink struct {
+	pipeline *Pipeline
+	source   string
+	lane     Lane
+}
+
+func (s sourceSink) AddBlock(values []float64) error {
+	return s.pipeline.enqueue(&Batch{Source: s.source, Values: values, Lane: s.lane})
+}
+
+func (s sourceSink) AddBlockWithMetadata(values []float64, text string, metadata map[string]string) error {
+	return s.pipeline.enqueue(&Batch{Source: s.source, Values: values, Text: text, Metadata: metadata, Lane: s.lane})
+}
+
+// Metrics returns a copy of the per-stage metrics in stage order
+func (p *Pipeline) Metrics() []StageMetrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]StageMetrics(nil), p.metrics...)
+}
+
+// ValidateStage rejects empty batches and non-finite values before any
+// later stage sees them
+type ValidateStage struct{}
+
+func (ValidateStage) Name() string { return "validate" }
+
+func (ValidateStage) Process(batch *Batch) error {
+	return checkValues(batch.Values)
+}
+
+// AppendStage adds the batch to a chain as a new block, to Batch.Chain if
+// set
+type AppendStage struct {
+	Chain *Blockchain
+}
+
+func (AppendStage) Name() string { return "append" }
+
+func (s AppendStage) Process(batch *Batch) error {
+	return appendBatch(s.Chain, batch)
+}
+
+// appendBatch adds batch to its routed chain, or else to chain, and sets
+// its index
+func appendBatch(chain *Blockchain, batch *Batch) error {
+	if batch.Chain != nil {
+		chain = batch.Chain
+	}
+	block, err := chain.addBlock(blockPayload{values: batch.Values, times: batch.Times, origins: batch.Origins, text: batch.Text, metadata: batch.Metadata, expectedHead: batch.ExpectedHead})
+	if err != nil {
+		return err
+	}
+	batch.Index = block.Index
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// recordStage records the order it sees batches in and rejects those
+// with a value of reject
+type recordStage struct {
+	name   string
+	seen   *[]string
+	reject float64
+}
+
+func (s recordStage) Name() string { return s.name }
+
+func (s recordStage) Process(batch *Batch) error {
+	*s.seen = append(*s.seen, s.name)
+	if slices.Contains(batch.Values, s.reject) {
+		return errors.New("abgelehnt")
+	}
+	return nil
+}
+
+func TestPipelineRunsStagesInOrder(t *testing.T) {
+	var seen []string
+	p := NewPipeline(recordStage{"a", &seen, -1}, recordStage{"b", &seen, 2}, recordStage{"c", &seen, -1})
+	if err := p.Submit(&Batch{Values: []float64{1}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(seen, want) {
+		t.Fatalf("stages ran as %v, want %v", seen, want)
+	}
+
+	// a rejection skips the later stages and names the stage
+	seen = nil
+	err := p.Submit(&Batch{Values: []float64{2}})
+	if err == nil || err.Error() != "b: abgelehnt" {
+		t.Fatalf("Submit returned %v, want the rejection of stage b", err)
+	}
+	if want := []string{"a", "b"}; !slices.Equal(seen, want) {
+		t.Fatalf("stages ran as %v after a rejection, want %v", seen, want)
+	}
+
+	metrics := p.Metrics()
+	for i, want := range []StageMetrics{{Name: "a", Accepted: 2}, {Name: "b", Accepted: 1, Rejected: 1}, {Name: "c", Accepted: 1}} {
+		got := metrics[i]
+		got.Duration = 0
+		if got != want {
+			t.Fatalf("metrics of stage %d are %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestPipelineSplitsAndFlushes(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut, err := NewCutStage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []string
+	p := NewPipeline(ValidateStage{}, cut, recordStage{"after", &seen, -1}, AppendStage{Chain: bc})
+
+	// four values make one block and leave one buffered
+	batch := &Batch{Values: []float64{1, 2, 3, 4}}
+	if err := p.Submit(batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1 || batch.Index != 1 || bc.Length() != 2 {
+		t.Fatalf("after 4 values the later stages ran %d times, index %d, %d blocks; want one block", len(seen), batch.Index, bc.Length())
+	}
+	// seven more make two blocks in one submission
+	batch = &Batch{Values: []float64{5, 6, 7, 8, 9, 10, 11}}
+	if err := p.Submit(batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 3 || batch.Index != 3 {
+		t.Fatalf("after 11 values the later stages ran %d times, last index %d; want 3 and 3", len(seen), batch.Index)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var cuts [][]float64
+	for _, block := range bc.Blocks()[1:] {
+		cuts = append(cuts, block.Values)
+	}
+	if want := [][]float64{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}, {10, 11}}; !slices.EqualFunc(cuts, want, slices.Equal) {
+		t.Fatalf("blocks hold %v, want %v", cuts, want)
+	}
+	if err := p.Flush(); err != nil || bc.Length() != 5 {
+		t.Fatalf("a second Flush returned %v with %d blocks, want no new block", err, bc.Length())
+	}
+}
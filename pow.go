@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// maxDifficulty bounds the difficulty; every further digit multiplies the
+// expected mining work by 16
+const maxDifficulty = 8
+
+// SetDifficulty makes new blocks proof of work: their nonce is
+// incremented until the hash starts with difficulty zero hex digits.
+// 0 turns mining off. Existing blocks keep the difficulty they were mined
+// at.
+func (bc *Blockchain) SetDifficulty(difficulty int) error {
+	if difficulty < 0 || difficulty > maxDifficulty {
+		return fmt.Errorf("Ungültige Schwierigkeit: %d (erlaubt 0 bis %d)", difficulty, maxDifficulty)
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.difficulty = difficulty
+	return nil
+}
+
+// Difficulty returns the difficulty new blocks are mined at
+func (bc *Blockchain) Difficulty() int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.difficulty
+}
+
+// mineBlock sets the hash of a new block, searching for a nonce if the
+// block has a difficulty. The payload is encoded once; only the nonce
+// changes. Called with bc.mu held, so appends wait while a block is mined.
+func mineBlock(block *Block) {
+	if block.Difficulty == 0 {
+		block.Nonce = 0
+		block.Hash = calculateHash(block)
+		return
+	}
+
+	prefix := append(canonicalHashPayload(block), canonicalProofOfWork(block.Difficulty)...)
+	data := make([]byte, 0, len(prefix)+8)
+	for nonce := uint64(0); ; nonce++ {
+		data = binary.BigEndian.AppendUint64(append(data[:0], prefix...), nonce)
+		sum := sha256.Sum256(data)
+		if hash := hex.EncodeToString(sum[:]); meetsDifficulty(hash, block.Difficulty) {
+			block.Nonce, block.Hash = nonce, hash
+			return
+		}
+	}
+}
+
+// meetsDifficulty reports whether hash starts with difficulty zero digits
+func meetsDifficulty(hash string, difficulty int) bool {
+	return len(hash) >= difficulty && strings.Count(hash[:difficulty], "0") == difficulty
+}
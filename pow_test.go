@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMiningMeetsDifficulty(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetDifficulty(2); err != nil {
+		t.Fatal(err)
+	}
+	for _, values := range chainTestValues {
+		block, err := bc.AddBlock(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(block.Hash, "00") || block.Difficulty != 2 || calculateHash(block) != block.Hash {
+			t.Fatalf("block %d has hash %s at difficulty %d, want a matching hash starting with 00", block.Index, block.Hash, block.Difficulty)
+		}
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a block whose nonce no longer meets its difficulty is caught
+	expectInvalidAt(t, bc, tamper(t, bc, 3, func(b *Block) {
+		for b.Nonce++; meetsDifficulty(calculateHash(b), b.Difficulty); b.Nonce++ {
+		}
+		b.Hash = calculateHash(b)
+	}))
+}
+
+func TestMiningOffKeepsNonceZero(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := bc.AddBlock([]float64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Difficulty != 0 || block.Nonce != 0 {
+		t.Fatalf("block without mining has difficulty %d and nonce %d", block.Difficulty, block.Nonce)
+	}
+	for _, difficulty := range []int{-1, maxDifficulty + 1} {
+		if err := bc.SetDifficulty(difficulty); err == nil {
+			t.Errorf("difficulty %d was accepted", difficulty)
+		}
+	}
+}
+
+// BenchmarkAddBlock measures the append throughput of a chain in memory
+// with blocks of 100 values
+func BenchmarkAddBlock(b *testing.B) {
+	rows := bulkRows(1000, 100)
+	bc, err := NewBlockchain()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := bc.AddBlock(rows[i%len(rows)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkMining shows how the cost of an append grows with the
+// difficulty: every digit multiplies it by 16 on average
+func BenchmarkMining(b *testing.B) {
+	rows := bulkRows(1000, 100)
+	for difficulty := 0; difficulty <= 4; difficulty++ {
+		b.Run("difficulty="+strconv.Itoa(difficulty), func(b *testing.B) {
+			bc, err := NewBlockchain()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := bc.SetDifficulty(difficulty); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := bc.AddBlock(rows[i%len(rows)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrPresetNotFound is returned for an unknown import preset name
+var ErrPresetNotFound = errors.New("Importvorlage nicht gefunden")
+
+// PresetStore keeps named ImportOptions in a JSON file
+type PresetStore struct {
+	path string
+
+	mu      sync.Mutex
+	presets map[string]ImportOptions
+}
+
+// presetsFile returns the default location of the presets file
+func presetsFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".block_data_save_presets.json")
+}
+
+// LoadPresets reads the presets stored at path. A missing file is an empty
+// store; an empty path keeps presets in memory only.
+func LoadPresets(path string) (*PresetStore, error) {
+	store := &PresetStore{path: path, presets: map[string]ImportOptions{}}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store.presets); err != nil {
+		return nil, fmt.Errorf("Vorlagendatei %s: %w", path, err)
+	}
+	for name, opts := range store.presets {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("Importvorlage %s: %w", name, err)
+		}
+	}
+	return store, nil
+}
+
+// Names returns the preset names in order
+func (s *PresetStore) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.presets))
+	for name := range s.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the options of the preset called name
+func (s *PresetStore) Get(name string) (ImportOptions, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	opts, ok := s.presets[name]
+	if !ok {
+		return ImportOptions{}, fmt.Errorf("%w: %s", ErrPresetNotFound, name)
+	}
+	return opts, nil
+}
+
+// Save validates opts and stores them as name, replacing an existing
+// preset of that name
+func (s *PresetStore) Save(name string, opts ImportOptions) error {
+	if name == "" {
+		return fmt.Errorf("Importvorlage ohne Namen")
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, existed := s.presets[name]
+	s.presets[name] = opts
+	if err := s.write(); err != nil {
+		if existed {
+			s.presets[name] = previous
+		} else {
+			delete(s.presets, name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes the preset called name
+func (s *PresetStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, ok := s.presets[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrPresetNotFound, name)
+	}
+	delete(s.presets, name)
+	if err := s.write(); err != nil {
+		s.presets[name] = previous
+		return err
+	}
+	return nil
+}
+
+// write replaces the presets file. Called with s.mu held.
+func (s *PresetStore) write() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.presets, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// managePresets lists, saves or deletes presets from the interactive menu
+func managePresets(w io.Writer, store *PresetStore, in LineReader) error {
+	action, err := promptString(in, "Aktion (liste, speichern, löschen):")
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "liste":
+		for _, name := range store.Names() {
+			opts, _ := store.Get(name)
+			data, _ := json.Marshal(opts)
+			fmt.Fprintf(w, "%s: %s\n", name, data)
+		}
+		return nil
+	case "löschen":
+		name, err := promptString(in, "Name der Vorlage:")
+		if err != nil {
+			return err
+		}
+		return store.Delete(name)
+	case "speichern":
+		name, err := promptString(in, "Name der Vorlage:")
+		if err != nil {
+			return err
+		}
+		var opts ImportOptions
+		answers := []struct {
+			prompt string
+			value  *string
+		}{
+			{"Datenformat (csv, tsv, json oder xlsx, leer für Dateiendung):", &opts.Format},
+			{"Trennzeichen (leer für automatisch):", &opts.Delimiter},
+			{"Zahlenformat (auto, de oder en, leer für aktuelles):", (*string)(&opts.Locale)},
+			{"Einheit (leer für keine):", &opts.Unit},
+		}
+		for _, answer := range answers {
+			if *answer.value, err = promptString(in, answer.prompt); err != nil {
+				return err
+			}
+		}
+		if opts.Header, opts.ColumnNames, err = promptHeader(in); err != nil {
+			return err
+		}
+		if len(opts.ColumnNames) == 0 {
+			columns, err := promptString(in, "Spalten, z. B. 1 3 (leer für alle):")
+			if err != nil {
+				return err
+			}
+			if opts.Columns, err = parseColumns(columns); err != nil {
+				return err
+			}
+		}
+		failFast, err := promptString(in, "Beim ersten Fehler abbrechen? (j/n)")
+		if err != nil {
+			return err
+		}
+		opts.FailFast = strings.EqualFold(failFast, "j")
+		return store.Save(name, opts)
+	default:
+		return fmt.Errorf("Unbekannte Aktion: %s", action)
+	}
+}
+
+// parseColumns parses column numbers separated by spaces or commas
+func parseColumns(text string) ([]int, error) {
+	var columns []int
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ' ' || r == ',' }) {
+		col, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("Ungültige Spalte: %s", field)
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// parseColumnNames parses column names separated by commas
+func parseColumnNames(text string) []string {
+	var names []string
+	for _, field := range strings.Split(text, ",") {
+		if name := strings.TrimSpace(field); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// promptHeader asks whether CSV files have a header row and, if so, which
+// named columns to import
+func promptHeader(in LineReader) (header bool, names []string, err error) {
+	answer, err := promptString(in, "CSV-Dateien mit Kopfzeile? (j/n)")
+	if err != nil || !strings.EqualFold(answer, "j") {
+		return false, nil, err
+	}
+	columns, err := promptString(in, "Spaltennamen, z. B. temp,pressure (leer für alle):")
+	if err != nil {
+		return false, nil, err
+	}
+	return true, parseColumnNames(columns), nil
+}
+
+// ImportPreset imports the files matching pattern with the options of the
+// preset called name and records the preset in the report
+func (p *Pipeline) ImportPreset(store *PresetStore, name, pattern string) (*ImportReport, error) {
+	opts, err := store.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	report, err := p.ImportGlob(pattern, opts)
+	if err != nil {
+		return nil, err
+	}
+	report.Preset = name
+	return report, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPresetStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "presets.json")
+	store, err := LoadPresets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labor := ImportOptions{Format: "csv", Delimiter: ";", Header: true, ColumnNames: []string{"temp"}, Locale: LocaleGerman, Unit: "°C"}
+	if err := store.Save("labor", labor); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("roh", ImportOptions{Columns: []int{2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("kaputt", ImportOptions{ColumnNames: []string{"temp"}}); err == nil {
+		t.Fatal("column names without a header accepted")
+	}
+	if err := store.Save("", labor); err == nil {
+		t.Fatal("preset without a name accepted")
+	}
+
+	// the presets survive a reload
+	reloaded, err := LoadPresets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := reloaded.Names(); !slices.Equal(names, []string{"labor", "roh"}) {
+		t.Fatalf("reloaded presets are %v", names)
+	}
+	opts, err := reloaded.Get("labor")
+	if err != nil || opts.Delimiter != ";" || opts.Locale != LocaleGerman || !slices.Equal(opts.ColumnNames, []string{"temp"}) {
+		t.Fatalf("reloaded preset labor is %+v, %v", opts, err)
+	}
+
+	if err := reloaded.Delete("roh"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Get("roh"); !errors.Is(err, ErrPresetNotFound) {
+		t.Fatalf("deleted preset returned %v", err)
+	}
+	if err := reloaded.Delete("roh"); !errors.Is(err, ErrPresetNotFound) {
+		t.Fatalf("deleting twice returned %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"x": {"format": "ods"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPresets(path); err == nil {
+		t.Fatal("invalid preset file loaded")
+	}
+}
+
+func TestImportPreset(t *testing.T) {
+	dir := writeImportFiles(t, map[string]string{"messung.csv": "zeit;temp\n1;1,5\n2;2,5\n"})
+	store, err := LoadPresets("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("labor", ImportOptions{Header: true, ColumnNames: []string{"temp"}, Locale: LocaleGerman, Unit: "°C"}); err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewDefaultPipeline(bc).ImportPreset(store, "labor", filepath.Join(dir, "*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := bc.Blocks()[1:]
+	if report.Preset != "labor" || len(blocks) != 2 || !slices.Equal(blocks[1].Values, []float64{2.5}) || blocks[1].Metadata["unit"] != "°C" {
+		t.Fatalf("import with preset labor added %v", blocks)
+	}
+	var out strings.Builder
+	report.Print(&out)
+	if !strings.Contains(out.String(), "Vorlage: labor") {
+		t.Fatalf("report does not name the preset:\n%s", out.String())
+	}
+	if _, err := NewDefaultPipeline(bc).ImportPreset(store, "fehlt", filepath.Join(dir, "*.csv")); !errors.Is(err, ErrPresetNotFound) {
+		t.Fatalf("unknown preset returned %v", err)
+	}
+}
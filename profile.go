@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// Phase is one step of a generation Profile
+type Phase struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"-"`
+	// Distribution is "uniform" (params min, max) or "normal" (params mean,
+	// stddev and stddev_rate, the growth of stddev per second of the phase)
+	Distribution string             `json:"distribution"`
+	Params       map[string]float64 `json:"params"`
+}
+
+// phaseJSON is Phase with its duration written as in "10m"
+type phaseJSON struct {
+	Name         string             `json:"name"`
+	Duration     string             `json:"duration"`
+	Distribution string             `json:"distribution"`
+	Params       map[string]float64 `json:"params"`
+}
+
+func (p *Phase) UnmarshalJSON(data []byte) error {
+	var raw phaseJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(raw.Duration)
+	if err != nil {
+		return fmt.Errorf("Phase %s: %w", raw.Name, err)
+	}
+	*p = Phase{Name: raw.Name, Duration: duration, Distribution: raw.Distribution, Params: raw.Params}
+	return nil
+}
+
+func (p Phase) MarshalJSON() ([]byte, error) {
+	return json.Marshal(phaseJSON{Name: p.Name, Duration: p.Duration.String(), Distribution: p.Distribution, Params: p.Params})
+}
+
+// Validate checks that the phase can produce values
+func (p Phase) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("Phase ohne Namen")
+	}
+	if p.Duration <= 0 {
+		return fmt.Errorf("Phase %s: ungültige Dauer %v", p.Name, p.Duration)
+	}
+	switch p.Distribution {
+	case "uniform":
+		if p.Params["max"] < p.Params["min"] {
+			return fmt.Errorf("Phase %s: max liegt unter min", p.Name)
+		}
+	case "normal":
+		if p.Params["stddev"] < 0 || p.Params["stddev_rate"] < 0 {
+			return fmt.Errorf("Phase %s: negative Standardabweichung", p.Name)
+		}
+	default:
+		return fmt.Errorf("Phase %s: unbekannte Verteilung %s", p.Name, p.Distribution)
+	}
+	return nil
+}
+
+// values returns n values of the phase, elapsed into the phase
+func (p Phase) values(n int, elapsed time.Duration, rng *rand.Rand) []float64 {
+	float := rand.Float64
+	norm := rand.NormFloat64
+	if rng != nil {
+		float, norm = rng.Float64, rng.NormFloat64
+	}
+
+	values := make([]float64, n)
+	for i := range values {
+		switch p.Distribution {
+		case "uniform":
+			lo, hi := p.Params["min"], p.Params["max"]
+			if lo == 0 && hi == 0 {
+				hi = 1
+			}
+			values[i] = lo + float()*(hi-lo)
+		case "normal":
+			stddev := p.Params["stddev"] + p.Params["stddev_rate"]*elapsed.Seconds()
+			values[i] = p.Params["mean"] + norm()*stddev
+		}
+	}
+	return values
+}
+
+// Profile is a schedule of phases a Generator steps through. Without
+// Loop the generator stops after the last phase.
+type Profile struct {
+	Phases []Phase `json:"phases"`
+	Loop   bool    `json:"loop"`
+	// Rand is used for the values; nil uses the global source
+	Rand *rand.Rand `json:"-"`
+}
+
+// Validate checks every phase of the profile
+func (p *Profile) Validate() error {
+	if len(p.Phases) == 0 {
+		return fmt.Errorf("Profil ohne Phasen")
+	}
+	for _, phase := range p.Phases {
+		if err := phase.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadProfile reads a JSON generation profile from path and validates it
+func LoadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var profile Profile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("Profildatei %s: %w", path, err)
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// profileCursor tracks the current phase of a running profile
+type profileCursor struct {
+	profile *Profile
+	index   int
+	start   time.Time
+}
+
+// advance moves to the phase active at now. It reports whether the phase
+// changed and whether the profile has finished.
+func (c *profileCursor) advance(now time.Time) (changed, finished bool) {
+	for now.Sub(c.start) >= c.profile.Phases[c.index].Duration {
+		c.start = c.start.Add(c.profile.Phases[c.index].Duration)
+		c.index++
+		changed = true
+		if c.index == len(c.profile.Phases) {
+			if !c.profile.Loop {
+				return changed, true
+			}
+			c.index = 0
+		}
+	}
+	return changed, false
+}
+
+// phase returns the current phase
+func (c *profileCursor) phase() Phase {
+	return c.profile.Phases[c.index]
+}
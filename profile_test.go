@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testProfile has a warm-up of 10 minutes, a load phase of 20 and a
+// cool-down of 5
+func testProfile(loop bool) *Profile {
+	return &Profile{
+		Phases: []Phase{
+			{Name: "warmup", Duration: 10 * time.Minute, Distribution: "uniform", Params: map[string]float64{"min": 0, "max": 1}},
+			{Name: "last", Duration: 20 * time.Minute, Distribution: "normal", Params: map[string]float64{"mean": 50, "stddev": 5}},
+			{Name: "abkuehlen", Duration: 5 * time.Minute, Distribution: "uniform", Params: map[string]float64{"min": 0, "max": 1}},
+		},
+		Loop: loop,
+	}
+}
+
+func TestProfileCursorAdvances(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		loop     bool
+		at       []time.Duration
+		phases   []string
+		changed  []bool
+		finished int
+	}{
+		{
+			name:    "through the phases",
+			at:      []time.Duration{time.Minute, 10 * time.Minute, 29 * time.Minute, 30 * time.Minute},
+			phases:  []string{"warmup", "last", "last", "abkuehlen"},
+			changed: []bool{false, true, false, true},
+		},
+		{
+			name:    "skipping a phase",
+			at:      []time.Duration{31 * time.Minute},
+			phases:  []string{"abkuehlen"},
+			changed: []bool{true},
+		},
+		{
+			name:    "wrapping around",
+			loop:    true,
+			at:      []time.Duration{34 * time.Minute, 35 * time.Minute, 46 * time.Minute, 70*time.Minute + 30*time.Second},
+			phases:  []string{"abkuehlen", "warmup", "last", "warmup"},
+			changed: []bool{true, true, true, true},
+		},
+		{
+			name:     "finishing without loop",
+			at:       []time.Duration{20 * time.Minute, 35 * time.Minute},
+			phases:   []string{"last"},
+			changed:  []bool{true},
+			finished: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := &profileCursor{profile: testProfile(tt.loop), start: start}
+			for i, at := range tt.at {
+				changed, finished := cursor.advance(start.Add(at))
+				if finished {
+					if i != tt.finished {
+						t.Fatalf("profile finished at %v", at)
+					}
+					return
+				}
+				if phase := cursor.phase().Name; phase != tt.phases[i] || changed != tt.changed[i] {
+					t.Fatalf("at %v the phase is %s, changed %v, want %s, %v", at, phase, changed, tt.phases[i], tt.changed[i])
+				}
+			}
+			if tt.finished > 0 {
+				t.Fatal("profile did not finish")
+			}
+		})
+	}
+}
+
+func TestProfileCursorKeepsPhaseStart(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cursor := &profileCursor{profile: testProfile(true), start: start}
+	// a late tick does not shift the phases that follow
+	cursor.advance(start.Add(12 * time.Minute))
+	if want := start.Add(10 * time.Minute); !cursor.start.Equal(want) {
+		t.Fatalf("load phase starts at %v, want %v", cursor.start, want)
+	}
+	cursor.advance(start.Add(36 * time.Minute))
+	if want := start.Add(35 * time.Minute); !cursor.start.Equal(want) || cursor.phase().Name != "warmup" {
+		t.Fatalf("second warm-up starts at %v in %s, want %v", cursor.start, cursor.phase().Name, want)
+	}
+}
+
+func TestGeneratorRunsProfile(t *testing.T) {
+	clock := newTickClock()
+	sink := &recordingSink{}
+	var events eventLog
+	g := NewGenerator(sink, GeneratorConfig{Interval: 10 * time.Minute, ValuesPerBlock: 2, Clock: clock, Profile: testProfile(false), OnEvent: events.add})
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop()
+
+	// ticks at 10, 20 and 30 minutes produce in last, last and abkuehlen;
+	// the tick at 40 minutes ends the profile
+	for range 3 {
+		clock.tick(t)
+	}
+	clock.settle(t)
+	clock.tick(t)
+	stopWithin(t, g, time.Second)
+
+	want := []string{"last", "last", "abkuehlen"}
+	if len(sink.metadata) != len(want) {
+		t.Fatalf("sink got %d batches, want %d", len(sink.metadata), len(want))
+	}
+	for i, phase := range want {
+		if sink.metadata[i]["phase"] != phase {
+			t.Fatalf("batch %d is tagged %v, want phase %s", i, sink.metadata[i], phase)
+		}
+	}
+	if events.count(PhaseChanged) != 3 || events.count(ProfileFinished) != 1 {
+		t.Fatalf("events are %+v, want 3 phase changes and the end", events.events)
+	}
+}
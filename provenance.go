@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Origin identifies where a value of a derived block came from: a position
+// in an earlier block or, when Source is set, a row of an imported file.
+type Origin struct {
+	BlockIndex int    `json:"block_index"`
+	Position   int    `json:"position"`
+	Source     string `json:"source"`
+	Row        int    `json:"row"`
+}
+
+// IsImport reports whether the origin is an import record
+func (o Origin) IsImport() bool {
+	return o.Source != ""
+}
+
+func (o Origin) String() string {
+	if o.IsImport() {
+		return fmt.Sprintf("%s Zeile %d", o.Source, o.Row)
+	}
+	return fmt.Sprintf("Block %d Position %d", o.BlockIndex, o.Position)
+}
+
+// importOrigins returns the origins of the n values of row of source
+func importOrigins(source string, row, n int) []Origin {
+	origins := make([]Origin, n)
+	for i := range origins {
+		origins[i] = Origin{Source: source, Row: row}
+	}
+	return origins
+}
+
+// SetTrackOrigins enables or disables storing value origins on new blocks.
+// Tracking is off by default because it keeps one Origin per value.
+// Imports record the file and row of each value, AddDerivedBlock and
+// MergeBlocks the block and position.
+func (bc *Blockchain) SetTrackOrigins(track bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.trackOrigins = track
+}
+
+// SetExportOrigins makes ExportJSON, ExportNDJSON, ExportGob and the API
+// export write the ValueOrigins of blocks. They are left out by default,
+// since they are not covered by the hash and may double an export's size.
+func (bc *Blockchain) SetExportOrigins(enabled bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.exportOrigins = enabled
+}
+
+// exportSnapshot returns a snapshot of the blocks as exports write them,
+// without their ValueOrigins unless SetExportOrigins enabled them
+func (bc *Blockchain) exportSnapshot() []*Block {
+	bc.mu.RLock()
+	enabled := bc.exportOrigins
+	bc.mu.RUnlock()
+	blocks := bc.snapshot()
+	if enabled {
+		return blocks
+	}
+	return withoutOrigins(blocks)
+}
+
+// withoutOrigins returns blocks with copies of those having ValueOrigins
+// in their place, without them; blocks itself is left as it is
+func withoutOrigins(blocks []*Block) []*Block {
+	out, copied := blocks, false
+	for i, block := range blocks {
+		if block.ValueOrigins == nil {
+			continue
+		}
+		if !copied {
+			out, copied = slices.Clone(blocks), true
+		}
+		stripped := *block
+		stripped.ValueOrigins = nil
+		out[i] = &stripped
+	}
+	return out
+}
+
+// MergeBlocks adds a block holding the values of the blocks with the
+// given indexes, in that order, such as those of several imports. With
+// SetTrackOrigins every value records the block and position it came
+// from. Sampled blocks, blocks of whole numbers and blocks whose values
+// were dropped cannot be merged.
+func (bc *Blockchain) MergeBlocks(indexes ...int) (*Block, error) {
+	if len(indexes) == 0 {
+		return nil, errors.New("Keine Blöcke zum Zusammenführen angegeben")
+	}
+	var values []float64
+	var origins []Origin
+	bc.mu.RLock()
+	for _, index := range indexes {
+		at, err := bc.position(index)
+		if err != nil {
+			bc.mu.RUnlock()
+			return nil, err
+		}
+		block, err := bc.block(at)
+		if err != nil {
+			bc.mu.RUnlock()
+			return nil, err
+		}
+		if block.ValuesDropped || block.Sampled || block.Kind == KindInt {
+			bc.mu.RUnlock()
+			return nil, fmt.Errorf("%w: Block %d enthält nicht mehr alle seine Werte als Kommazahlen", ErrHistoryUnavailable, index)
+		}
+		for pos, v := range block.GetValues() {
+			values = append(values, v)
+			origins = append(origins, Origin{BlockIndex: index, Position: pos})
+		}
+	}
+	bc.mu.RUnlock()
+	return bc.addBlock(blockPayload{values: values, origins: origins})
+}
+
+// AddDerivedBlock adds a block whose values were derived from earlier blocks
+// or import rows. origins must be parallel to values; they are only stored
+// when origin tracking is enabled.
+func (bc *Blockchain) AddDerivedBlock(values []float64, origins []Origin) error {
+	if len(origins) != len(values) {
+		return fmt.Errorf("Anzahl der Herkunftsangaben (%d) passt nicht zu den Werten (%d)", len(origins), len(values))
+	}
+
+	bc.mu.RLock()
+	next := bc.head.Index + 1
+	bc.mu.RUnlock()
+	for i, origin := range origins {
+		if !origin.IsImport() && (origin.BlockIndex < 0 || origin.BlockIndex >= next) {
+			return fmt.Errorf("Herkunft von Wert %d verweist auf ungültigen Block %d", i, origin.BlockIndex)
+		}
+	}
+
+	_, err := bc.addBlock(blockPayload{values: values, origins: origins})
+	return err
+}
+
+// TraceValue follows the origins of the value at pos in the given block back
+// to the block or import record it was first recorded in. The returned path
+// starts with the value's direct origin and ends at the root.
+func (bc *Blockchain) TraceValue(blockIndex, pos int) ([]Origin, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var path []Origin
+	for {
+		at, err := bc.position(blockIndex)
+		if err != nil {
+			return nil, err
+		}
+		block, err := bc.block(at)
+		if err != nil {
+			return nil, err
+		}
+		if pos < 0 || pos >= block.storedCount() {
+			return nil, fmt.Errorf("Block %d hat keinen Wert an Position %d", blockIndex, pos)
+		}
+		if block.ValueOrigins == nil {
+			if len(path) == 0 {
+				path = append(path, Origin{BlockIndex: blockIndex, Position: pos})
+			}
+			return path, nil
+		}
+
+		origin := block.ValueOrigins[pos]
+		path = append(path, origin)
+		if origin.IsImport() {
+			return path, nil
+		}
+		if origin.BlockIndex >= blockIndex {
+			return nil, fmt.Errorf("Herkunft in Block %d verweist nicht auf einen älteren Block", blockIndex)
+		}
+		blockIndex, pos = origin.BlockIndex, origin.Position
+	}
+}
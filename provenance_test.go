@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTraceValueThroughDerivedChain(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.SetTrackOrigins(true)
+	path := filepath.Join(t.TempDir(), "messung.json")
+	if err := os.WriteFile(path, []byte("[[1, 2], [3, 4, 5]]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if added, err := bc.ImportFromFile(path, "json"); err != nil || added != 2 {
+		t.Fatalf("ImportFromFile added %d blocks, %v", added, err)
+	}
+
+	// the first derivation merges the imports, the second takes two of
+	// the merged values
+	merged, err := bc.MergeBlocks(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddDerivedBlock([]float64{5, 1}, []Origin{{BlockIndex: merged.Index, Position: 4}, {BlockIndex: merged.Index, Position: 0}}); err != nil {
+		t.Fatal(err)
+	}
+	derived := bc.LatestBlock().Index
+
+	tests := []struct {
+		pos  int
+		want []Origin
+	}{
+		{0, []Origin{{BlockIndex: 3, Position: 4}, {BlockIndex: 2, Position: 2}, {Source: "messung.json", Row: 2}}},
+		{1, []Origin{{BlockIndex: 3, Position: 0}, {BlockIndex: 1, Position: 0}, {Source: "messung.json", Row: 1}}},
+	}
+	for _, tt := range tests {
+		path, err := bc.TraceValue(derived, tt.pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(path, tt.want) {
+			t.Fatalf("TraceValue(%d, %d) is %v, want %v", derived, tt.pos, path, tt.want)
+		}
+	}
+}
+
+func TestOriginsNeedTracking(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlocksBulkFrom("zeilen.csv", [][]float64{{1, 2}}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.MergeBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range bc.Blocks() {
+		if block.ValueOrigins != nil {
+			t.Fatalf("block %d has origins without tracking", block.Index)
+		}
+	}
+}
+
+func TestAddBlocksBulkFromRecordsRows(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.SetTrackOrigins(true)
+	if err := bc.AddBlocksBulkFrom("zeilen.csv", [][]float64{{1, 2}, {3}}, 2); err != nil {
+		t.Fatal(err)
+	}
+	path, err := bc.TraceValue(1, 1)
+	if err != nil || !reflect.DeepEqual(path, []Origin{{Source: "zeilen.csv", Row: 1}}) {
+		t.Fatalf("TraceValue(1, 1) is %v, %v", path, err)
+	}
+	if path, err = bc.TraceValue(2, 0); err != nil || path[0].Row != 2 {
+		t.Fatalf("TraceValue(2, 0) is %v, %v", path, err)
+	}
+}
+
+func TestExportOriginsOptIn(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.SetTrackOrigins(true)
+	if err := bc.AddBlocksBulkFrom("zeilen.csv", [][]float64{{1, 2}}, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// each export is read back to the origins of its last block
+	exports := map[string]func(*bytes.Buffer) ([]Origin, error){
+		"json": func(buf *bytes.Buffer) ([]Origin, error) {
+			if err := bc.ExportJSON(buf); err != nil {
+				return nil, err
+			}
+			var file chainFile
+			err := json.Unmarshal(buf.Bytes(), &file)
+			return file.Blocks[len(file.Blocks)-1].ValueOrigins, err
+		},
+		"ndjson": func(buf *bytes.Buffer) ([]Origin, error) {
+			if err := bc.ExportNDJSON(buf, nil); err != nil {
+				return nil, err
+			}
+			var origins []Origin
+			err := readNDJSON(buf, func(_ int, block *Block) error {
+				origins = block.ValueOrigins
+				return nil
+			})
+			return origins, err
+		},
+		"gob": func(buf *bytes.Buffer) ([]Origin, error) {
+			if err := bc.ExportGob(buf); err != nil {
+				return nil, err
+			}
+			var block Block
+			dec := gob.NewDecoder(buf)
+			for buf.Len() > 0 {
+				block = Block{}
+				if err := dec.Decode(&block); err != nil {
+					return nil, err
+				}
+			}
+			return block.ValueOrigins, nil
+		},
+	}
+	for _, enabled := range []bool{false, true} {
+		bc.SetExportOrigins(enabled)
+		for format, export := range exports {
+			origins, err := export(&bytes.Buffer{})
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			if written := origins != nil; written != enabled {
+				t.Fatalf("%s export with SetExportOrigins(%v) writes origins: %v", format, enabled, written)
+			}
+		}
+	}
+	if bc.LatestBlock().ValueOrigins == nil {
+		t.Fatal("export removed the origins from the chain")
+	}
+}
+
+func TestSortSamplesKeepsOrigins(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := []Sample{{Time: start.Add(2 * time.Second), Value: 3}, {Time: start, Value: 1}, {Time: start.Add(time.Second), Value: 2}}
+	origins := []Origin{{Source: "f", Row: 3}, {Source: "f", Row: 1}, {Source: "f", Row: 2}}
+	sortSamples(samples, origins)
+	for i := range samples {
+		if samples[i].Value != float64(i+1) || origins[i].Row != i+1 {
+			t.Fatalf("sorted samples %v with origins %v", samples, origins)
+		}
+	}
+}
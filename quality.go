@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Penalty names in a QualityScore breakdown
+const (
+	PenaltyOutliers = "outliers"
+	PenaltyStuck    = "stuck"
+	PenaltyBounds   = "bounds"
+	PenaltyClock    = "clock"
+)
+
+// QualityWeights are the maximum number of points each signal can take
+// off a block's quality score of 100
+type QualityWeights struct {
+	// Outliers is scaled by the fraction of outliers
+	Outliers float64 `json:"outliers"`
+	// Stuck is scaled by the longest run of repeated values
+	Stuck float64 `json:"stuck"`
+	// Bounds is scaled by the fraction of values that violated the bounds
+	Bounds float64 `json:"bounds"`
+	// Clock applies in full when the timestamp was clamped or out of order
+	Clock float64 `json:"clock"`
+}
+
+// DefaultQualityWeights are the weights of a new chain
+var DefaultQualityWeights = QualityWeights{Outliers: 40, Stuck: 30, Bounds: 20, Clock: 10}
+
+// Validate rejects negative weights
+func (w QualityWeights) Validate() error {
+	if w.Outliers < 0 || w.Stuck < 0 || w.Bounds < 0 || w.Clock < 0 {
+		return fmt.Errorf("Ungültige Qualitätsgewichte: %+v", w)
+	}
+	return nil
+}
+
+// QualityScore is a block's 0-100 quality score and the points each
+// signal took off it
+type QualityScore struct {
+	Score     float64            `json:"score"`
+	Penalties map[string]float64 `json:"penalties"`
+}
+
+// SetQualityWeights changes the weights used to score new blocks. Scores
+// already stored are left as they are.
+func (bc *Blockchain) SetQualityWeights(weights QualityWeights) error {
+	if err := weights.Validate(); err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.qualityWeights = weights
+	return nil
+}
+
+// scoreQuality computes the quality score of block after its stats. stuck
+// is the longest run of equal consecutive values in arrival order.
+func scoreQuality(block *Block, stuck int, weights QualityWeights) *QualityScore {
+	penalties := map[string]float64{}
+	if n := block.ValueCount(); n > 0 {
+		penalties[PenaltyOutliers] = weights.Outliers * float64(block.OutlierCount()) / float64(n)
+		if n > 1 {
+			penalties[PenaltyStuck] = weights.Stuck * float64(stuck-1) / float64(n-1)
+		}
+		if violations := metadataNumber(block, "bounds_violations"); violations > 0 {
+			penalties[PenaltyBounds] = weights.Bounds * violations / (violations + float64(n))
+		}
+	}
+	if _, clamped := block.Metadata["original_timestamp"]; clamped || block.Metadata["non_monotonic_timestamp"] == "true" {
+		penalties[PenaltyClock] = weights.Clock
+	}
+
+	score := 100.0
+	for _, points := range penalties {
+		score -= points
+	}
+	return &QualityScore{Score: max(score, 0), Penalties: penalties}
+}
+
+// longestRun returns the length of the longest run of equal consecutive
+// values
+func longestRun[T comparable](values []T) int {
+	longest, run := 0, 0
+	for i, value := range values {
+		if i > 0 && value == values[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+		longest = max(longest, run)
+	}
+	return longest
+}
+
+// BlocksByQuality returns copies of the scored blocks with a score in
+// [lo, hi], lowest score first
+func (bc *Blockchain) BlocksByQuality(lo, hi float64) []*Block {
+	var blocks []*Block
+	for _, block := range bc.snapshot() {
+		if block.Quality != nil && block.Quality.Score >= lo && block.Quality.Score <= hi {
+			blocks = append(blocks, copyBlock(block))
+		}
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].Quality.Score < blocks[j].Quality.Score
+	})
+	return blocks
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"testing"
+)
+
+func TestScoreQuality(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		name      string
+		block     Block
+		stuck     int
+		weights   QualityWeights
+		score     float64
+		penalties map[string]float64
+	}{
+		{
+			name:      "clean",
+			block:     Block{Values: values},
+			stuck:     1,
+			weights:   DefaultQualityWeights,
+			score:     100,
+			penalties: map[string]float64{PenaltyOutliers: 0, PenaltyStuck: 0},
+		},
+		{
+			name:      "outliers",
+			block:     Block{Values: values, Outliers: []float64{1, 10}},
+			stuck:     1,
+			weights:   DefaultQualityWeights,
+			score:     92,
+			penalties: map[string]float64{PenaltyOutliers: 8, PenaltyStuck: 0},
+		},
+		{
+			name:      "stuck sensor",
+			block:     Block{Values: []float64{5, 5, 5, 5, 5}},
+			stuck:     5,
+			weights:   DefaultQualityWeights,
+			score:     70,
+			penalties: map[string]float64{PenaltyOutliers: 0, PenaltyStuck: 30},
+		},
+		{
+			name:      "bounds violations",
+			block:     Block{Values: values, Metadata: map[string]string{"bounds_violations": "10"}},
+			stuck:     1,
+			weights:   DefaultQualityWeights,
+			score:     90,
+			penalties: map[string]float64{PenaltyOutliers: 0, PenaltyStuck: 0, PenaltyBounds: 10},
+		},
+		{
+			name:      "clamped timestamp",
+			block:     Block{Values: values, Metadata: map[string]string{"original_timestamp": "2024-01-01T00:00:00Z"}},
+			stuck:     1,
+			weights:   DefaultQualityWeights,
+			score:     90,
+			penalties: map[string]float64{PenaltyOutliers: 0, PenaltyStuck: 0, PenaltyClock: 10},
+		},
+		{
+			name:      "timestamp out of order",
+			block:     Block{Values: values, Metadata: map[string]string{"non_monotonic_timestamp": "true"}},
+			stuck:     1,
+			weights:   QualityWeights{Clock: 25},
+			score:     75,
+			penalties: map[string]float64{PenaltyOutliers: 0, PenaltyStuck: 0, PenaltyClock: 25},
+		},
+		{
+			name:      "single value",
+			block:     Block{Values: []float64{3}},
+			stuck:     1,
+			weights:   DefaultQualityWeights,
+			score:     100,
+			penalties: map[string]float64{PenaltyOutliers: 0},
+		},
+		{
+			name:      "no values",
+			block:     Block{},
+			weights:   DefaultQualityWeights,
+			score:     100,
+			penalties: map[string]float64{},
+		},
+		{
+			name:      "clamped at zero",
+			block:     Block{Values: []float64{5, 5, 5, 5, 5}, Outliers: []float64{5, 5, 5, 5, 5}, Metadata: map[string]string{"non_monotonic_timestamp": "true"}},
+			stuck:     5,
+			weights:   QualityWeights{Outliers: 60, Stuck: 50, Clock: 10},
+			score:     0,
+			penalties: map[string]float64{PenaltyOutliers: 60, PenaltyStuck: 50, PenaltyClock: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quality := scoreQuality(&tt.block, tt.stuck, tt.weights)
+			if math.Abs(quality.Score-tt.score) > 1e-9 {
+				t.Fatalf("score is %v, want %v", quality.Score, tt.score)
+			}
+			if !maps.EqualFunc(quality.Penalties, tt.penalties, func(a, b float64) bool { return math.Abs(a-b) <= 1e-9 }) {
+				t.Fatalf("penalties are %v, want %v", quality.Penalties, tt.penalties)
+			}
+		})
+	}
+}
+
+func TestLongestRun(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   int
+	}{
+		{nil, 0},
+		{[]float64{1}, 1},
+		{[]float64{1, 2, 3}, 1},
+		{[]float64{1, 1, 2, 2, 2, 1}, 3},
+		{[]float64{4, 4, 4, 4}, 4},
+	}
+	for _, tt := range tests {
+		if got := longestRun(tt.values); got != tt.want {
+			t.Fatalf("longestRun(%v) is %d, want %d", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestBlocksByQuality(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, values := range [][]float64{{1, 2, 3, 4}, {7, 7, 7, 7}, {1, 1, 2, 3}} {
+		if _, err := bc.AddBlock(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bc.SetQualityWeights(QualityWeights{Outliers: -1}); err == nil {
+		t.Fatal("negative weight accepted")
+	}
+
+	blocks := bc.BlocksByQuality(0, 99)
+	if len(blocks) != 2 || blocks[0].Index != 2 || blocks[1].Index != 3 {
+		t.Fatalf("blocks below 100 are %v, want 2 and 3, lowest score first", blocks)
+	}
+	if blocks[0].Quality.Score != 70 || math.Abs(blocks[1].Quality.Score-90) > 1e-9 {
+		t.Fatalf("scores are %v and %v, want 70 and 90", blocks[0].Quality.Score, blocks[1].Quality.Score)
+	}
+	// genesis is not scored
+	if all := bc.BlocksByQuality(0, 100); len(all) != 3 {
+		t.Fatalf("%d blocks scored, want 3", len(all))
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"container/heap"
+	"slices"
+)
+
+// FilterBlocks returns copies of the blocks for which pred returns true,
+// in order. pred sees the blocks of the chain itself, which it must not
+// modify, and is called without the lock.
+func (bc *Blockchain) FilterBlocks(pred func(*Block) bool) []*Block {
+	var blocks []*Block
+	for _, block := range bc.snapshot() {
+		if pred(block) {
+			blocks = append(blocks, copyBlock(block))
+		}
+	}
+	return blocks
+}
+
+// BlocksWithMeanBetween returns copies of the blocks with values whose
+// mean lies in [lo, hi]
+func (bc *Blockchain) BlocksWithMeanBetween(lo, hi float64) []*Block {
+	return bc.FilterBlocks(func(b *Block) bool {
+		return b.ValueCount() > 0 && b.Mean >= lo && b.Mean <= hi
+	})
+}
+
+// BlocksWithMoreOutliersThan returns copies of the blocks with more than
+// k outliers
+func (bc *Blockchain) BlocksWithMoreOutliersThan(k int) []*Block {
+	return bc.FilterBlocks(func(b *Block) bool {
+		return b.OutlierCount() > k
+	})
+}
+
+// TopByOutliers returns copies of the n blocks with the most outliers,
+// most first; of blocks with as many outliers the older comes first.
+// Blocks without outliers are left out, so fewer than n may be returned.
+// It keeps the n best blocks in a heap instead of sorting the chain.
+func (bc *Blockchain) TopByOutliers(n int) []*Block {
+	if n <= 0 {
+		return nil
+	}
+	top := &outlierHeap{}
+	for _, block := range bc.snapshot() {
+		if block.OutlierCount() == 0 {
+			continue
+		}
+		if top.Len() < n {
+			heap.Push(top, block)
+		} else if outlierRanksBefore(block, (*top)[0]) {
+			(*top)[0] = block
+			heap.Fix(top, 0)
+		}
+	}
+
+	blocks := make([]*Block, top.Len())
+	for i := len(blocks) - 1; i >= 0; i-- {
+		blocks[i] = copyBlock(heap.Pop(top).(*Block))
+	}
+	return blocks
+}
+
+// outlierRanksBefore reports whether a comes before b in TopByOutliers
+func outlierRanksBefore(a, b *Block) bool {
+	if a.OutlierCount() != b.OutlierCount() {
+		return a.OutlierCount() > b.OutlierCount()
+	}
+	return a.Index < b.Index
+}
+
+// outlierHeap keeps the lowest ranked block of TopByOutliers on top
+type outlierHeap []*Block
+
+func (h outlierHeap) Len() int           { return len(h) }
+func (h outlierHeap) Less(i, j int) bool { return outlierRanksBefore(h[j], h[i]) }
+func (h outlierHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *outlierHeap) Push(x any)        { *h = append(*h, x.(*Block)) }
+
+func (h *outlierHeap) Pop() any {
+	old := *h
+	block := old[len(old)-1]
+	*h = slices.Delete(old, len(old)-1, len(old))
+	return block
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// queryOutlierCounts are the outlier counts of the blocks of
+// newQueryChain, genesis left out
+var queryOutlierCounts = []int{0, 2, 5, 1, 2, 0, 4, 5, 3}
+
+// valuesWithOutliers returns 100 values alternating between 10.1 and 9.9
+// of which k, up to 5, are 10.5 and outliers
+func valuesWithOutliers(k int) []float64 {
+	values := make([]float64, 100)
+	for i := range values {
+		switch {
+		case i%20 == 5 && i/20 < k:
+			values[i] = 10.5
+		case i%2 == 0:
+			values[i] = 10.1
+		default:
+			values[i] = 9.9
+		}
+	}
+	return values
+}
+
+// newQueryChain returns a chain whose blocks have queryOutlierCounts
+// outliers
+func newQueryChain(t *testing.T) *Blockchain {
+	t.Helper()
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range queryOutlierCounts {
+		block, err := bc.AddBlock(valuesWithOutliers(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.OutlierCount() != k {
+			t.Fatalf("block %d has %d outliers, want %d", block.Index, block.OutlierCount(), k)
+		}
+	}
+	return bc
+}
+
+// topByOutliersSorted is the naive TopByOutliers: it sorts every block
+// with outliers
+func topByOutliersSorted(blocks []*Block, n int) []*Block {
+	blocks = slices.DeleteFunc(slices.Clone(blocks), func(b *Block) bool { return b.OutlierCount() == 0 })
+	slices.SortStableFunc(blocks, func(a, b *Block) int { return b.OutlierCount() - a.OutlierCount() })
+	return blocks[:min(n, len(blocks))]
+}
+
+// blockIndexes returns the indexes of blocks
+func blockIndexes(blocks []*Block) []int {
+	indexes := make([]int, len(blocks))
+	for i, block := range blocks {
+		indexes[i] = block.Index
+	}
+	return indexes
+}
+
+func TestTopByOutliers(t *testing.T) {
+	bc := newQueryChain(t)
+	for _, tc := range []struct {
+		n    int
+		want []int
+	}{
+		{0, nil},
+		{1, []int{3}},
+		// ties go to the older block
+		{3, []int{3, 8, 7}},
+		{5, []int{3, 8, 7, 9, 2}},
+		// blocks without outliers are never returned
+		{20, []int{3, 8, 7, 9, 2, 5, 4}},
+	} {
+		t.Run(strconv.Itoa(tc.n), func(t *testing.T) {
+			top := bc.TopByOutliers(tc.n)
+			if got := blockIndexes(top); !slices.Equal(got, tc.want) {
+				t.Fatalf("TopByOutliers(%d) returned blocks %v, want %v", tc.n, got, tc.want)
+			}
+			if naive := blockIndexes(topByOutliersSorted(bc.Blocks(), tc.n)); !slices.Equal(blockIndexes(top), naive) {
+				t.Fatalf("TopByOutliers(%d) returned blocks %v, sorting returns %v", tc.n, blockIndexes(top), naive)
+			}
+		})
+	}
+}
+
+func TestTopByOutliersReturnsCopies(t *testing.T) {
+	bc := newQueryChain(t)
+	top := bc.TopByOutliers(1)
+	top[0].Outliers[0] = 0
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("changing a returned block changed the chain: %v", err)
+	}
+}
+
+func TestFilterBlocks(t *testing.T) {
+	bc := newQueryChain(t)
+	if got, want := blockIndexes(bc.BlocksWithMoreOutliersThan(2)), []int{3, 7, 8, 9}; !slices.Equal(got, want) {
+		t.Fatalf("blocks with more than 2 outliers are %v, want %v", got, want)
+	}
+	if got := bc.BlocksWithMoreOutliersThan(5); len(got) != 0 {
+		t.Fatalf("blocks with more than 5 outliers are %v, want none", blockIndexes(got))
+	}
+	// only the blocks without outliers have a mean of 10; genesis has no
+	// values and is left out
+	if got, want := blockIndexes(bc.BlocksWithMeanBetween(9.999, 10.001)), []int{1, 6}; !slices.Equal(got, want) {
+		t.Fatalf("blocks with a mean of 10 are %v, want %v", got, want)
+	}
+	even := bc.FilterBlocks(func(b *Block) bool { return b.Index%2 == 0 })
+	if got, want := blockIndexes(even), []int{0, 2, 4, 6, 8}; !slices.Equal(got, want) {
+		t.Fatalf("blocks with an even index are %v, want %v", got, want)
+	}
+}
+
+// BenchmarkTopByOutliers compares the heap of TopByOutliers to sorting
+// the blocks of a chain of 10k blocks
+func BenchmarkTopByOutliers(b *testing.B) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := bc.AddBlocksBulk(bulkRows(10000, 100), 0); err != nil {
+		b.Fatal(err)
+	}
+	for _, n := range []int{10, 100, 1000} {
+		b.Run("heap/n="+strconv.Itoa(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bc.TopByOutliers(n)
+			}
+		})
+		b.Run("sort/n="+strconv.Itoa(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				top := topByOutliersSorted(bc.snapshot(), n)
+				for _, block := range top {
+					copyBlock(block)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// RebuildOptions configures Rebuild
+type RebuildOptions struct {
+	// StampNow gives the blocks the time of the rebuild instead of their
+	// original timestamps
+	StampNow bool
+	// Progress, if set, is called after every block with the number of
+	// blocks rebuilt and the number to rebuild
+	Progress func(done, total int)
+}
+
+// Rebuild returns a new chain with the settings of bc holding the same
+// blocks with their stats recomputed, as after a change to the outlier
+// method or a fix to a stat. Every block but genesis is added again from
+// its values, ID, text, metadata, value times, origins and references
+// with the current settings, so it is hashed, linked and, if the chain
+// has a signer, signed anew; genesis is kept as it is. The blocks keep
+// their timestamps unless opts.StampNow is set. The duplicate check and
+// the timestamp policy do not apply while rebuilding, as the blocks were
+// accepted before. bc is not changed.
+//
+// The blocks are replayed one by one from a snapshot; a chain kept in
+// memory is not copied first, so the rebuild needs no more memory than
+// the new chain. Rebuild needs the full history: chains that were pruned,
+// compacted or bootstrapped from a checkpoint, and sampled blocks, no
+// longer hold all values.
+func (bc *Blockchain) Rebuild(opts RebuildOptions) (*Blockchain, error) {
+	bc.mu.RLock()
+	if bc.base > 0 || bc.stub {
+		bc.mu.RUnlock()
+		return nil, fmt.Errorf("%w: die Blockchain beginnt bei Block %d", ErrHistoryUnavailable, bc.base)
+	}
+	blocks, err := bc.allBlocks()
+	rebuilt := bc.withSettings()
+	rebuilt.name = bc.name
+	bc.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	rebuilt.holdBlocks([]*Block{copyBlock(blocks[0])})
+	clock, policy, dedup := rebuilt.clock, rebuilt.timestampPolicy, rebuilt.dedupWindow
+	replay := &replayClock{}
+	if !opts.StampNow {
+		rebuilt.clock = replay
+	}
+	rebuilt.timestampPolicy, rebuilt.dedupWindow = TimestampAllow, 0
+
+	for pos, block := range blocks[1:] {
+		switch {
+		case block.ValuesDropped:
+			return nil, fmt.Errorf("%w: die Werte von Block %d wurden verworfen", ErrHistoryUnavailable, block.Index)
+		case block.Sampled:
+			return nil, fmt.Errorf("%w: Block %d enthält nur eine Stichprobe seiner Werte", ErrHistoryUnavailable, block.Index)
+		}
+		p := blockPayload{
+			text:       block.Text,
+			metadata:   maps.Clone(block.Metadata),
+			origins:    slices.Clone(block.ValueOrigins),
+			times:      slices.Clone(block.ValueTimes),
+			references: slices.Clone(block.References),
+			id:         block.ID,
+		}
+		if block.Kind == KindInt {
+			p.intValues = slices.Clone(block.IntValues)
+		} else {
+			p.values = slices.Clone(block.GetValues())
+		}
+		replay.now = block.Timestamp
+		if _, err := rebuilt.addBlock(p); err != nil {
+			return nil, fmt.Errorf("Block %d: %w", block.Index, err)
+		}
+		if opts.Progress != nil {
+			opts.Progress(pos+1, len(blocks)-1)
+		}
+	}
+
+	rebuilt.mu.Lock()
+	rebuilt.clock, rebuilt.timestampPolicy, rebuilt.dedupWindow = clock, policy, dedup
+	rebuilt.mu.Unlock()
+	return rebuilt, nil
+}
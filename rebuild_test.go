@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRebuildRecomputesStats(t *testing.T) {
+	bc := newFilledChain(t)
+	if err := bc.AddBlockWithText([]float64{2, 4, 4, 4, 5, 5, 7, 9}, "Lauf 7"); err != nil {
+		t.Fatal(err)
+	}
+	before := bc.Blocks()
+	if err := bc.SetOutlierConfig(OutlierConfig{Method: OutlierSD, SDMultiplier: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []int
+	rebuilt, err := bc.Rebuild(RebuildOptions{Progress: func(done, total int) {
+		if total != len(before)-1 {
+			t.Fatalf("progress reports %d blocks, want %d", total, len(before)-1)
+		}
+		progress = append(progress, done)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rebuilt.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(progress, []int{1, 2, 3, 4, 5, 6}) {
+		t.Fatalf("progress is %v", progress)
+	}
+	after := rebuilt.Blocks()
+	if len(after) != len(before) || after[0].Hash != before[0].Hash {
+		t.Fatalf("rebuilt chain has %d blocks from genesis %s", len(after), after[0].Hash)
+	}
+	for i := 1; i < len(before); i++ {
+		if after[i].ID != before[i].ID || !after[i].Timestamp.Equal(before[i].Timestamp) || after[i].Text != before[i].Text || !slices.Equal(after[i].Values, before[i].Values) {
+			t.Fatalf("block %d is rebuilt as %+v from %+v", i, after[i], before[i])
+		}
+	}
+	last := after[len(after)-1]
+	if last.OutlierMethod != "sd:1" || !slices.Equal(last.Outliers, []float64{2, 9}) || last.Hash == before[len(before)-1].Hash {
+		t.Fatalf("last block is rebuilt with method %q, outliers %v and hash %s", last.OutlierMethod, last.Outliers, last.Hash)
+	}
+
+	// bc is not changed
+	if head := bc.LatestBlock(); head.Hash != before[len(before)-1].Hash || len(head.Outliers) != 0 {
+		t.Fatalf("head of the original chain is %+v", head)
+	}
+}
+
+func TestRebuildStampNow(t *testing.T) {
+	bc := newFilledChain(t)
+	start := time.Now()
+	rebuilt, err := bc.Rebuild(RebuildOptions{StampNow: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range rebuilt.Blocks()[1:] {
+		if block.Timestamp.Before(start) {
+			t.Fatalf("block %d keeps timestamp %v", block.Index, block.Timestamp)
+		}
+	}
+	// the replay clock is only used while rebuilding
+	block, err := rebuilt.AddBlock([]float64{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Timestamp.Before(start) {
+		t.Fatalf("block after the rebuild has timestamp %v", block.Timestamp)
+	}
+}
+
+func TestRebuildNeedsFullHistory(t *testing.T) {
+	bc := newFilledChain(t)
+	if err := bc.SetSampleSize(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Rebuild(RebuildOptions{}); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Fatalf("rebuild of a sampled block returned %v", err)
+	}
+
+	cp, err := newFilledChain(t).Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Sign(priv)
+	bootstrapped, err := NewBlockchainFromCheckpoint(cp, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bootstrapped.Rebuild(RebuildOptions{}); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Fatalf("rebuild of a bootstrapped chain returned %v", err)
+	}
+}
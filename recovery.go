@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// headRecordSuffix names the file next to a block log that holds the
+// last known good head of the log
+const headRecordSuffix = ".head"
+
+// ErrNoRecovery is returned by AcknowledgeRecovery without a report
+var ErrNoRecovery = errors.New("Kein Wiederherstellungsbericht vorhanden")
+
+// HeadRef names a block by index and hash
+type HeadRef struct {
+	Index     int       `json:"index"`
+	Hash      string    `json:"hash"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// headRecord is the content of the head record of a block log: the head
+// block once it was synced to the log, and whether Close closed the log
+// after it
+type headRecord struct {
+	HeadRef
+	Clean bool `json:"clean"`
+}
+
+// RecoveryReport describes what RecoverFromLog restored after the chain
+// was last stopped, see Blockchain.Recovery
+type RecoveryReport struct {
+	Log         string    `json:"log"`
+	RecoveredAt time.Time `json:"recovered_at"`
+	// Clean is set when the log was closed by Close, not by a crash
+	Clean bool `json:"clean"`
+	// MissingLog is set when the log was gone while its head record was
+	// not; the chain then starts anew
+	MissingLog bool `json:"missing_log,omitempty"`
+	// Replayed counts the blocks read back from the log, and
+	// AfterKnownHead those newer than the last known good head, which
+	// were synced but not yet recorded
+	Replayed       int `json:"replayed"`
+	AfterKnownHead int `json:"after_known_head"`
+	// TornBytes is the length of the incomplete record cut from the end
+	// of the log, the block being written when the chain stopped
+	TornBytes int64   `json:"torn_bytes"`
+	Head      HeadRef `json:"head"`
+	// KnownHead is the last known good head, nil for a log without a head
+	// record
+	KnownHead *HeadRef `json:"known_head,omitempty"`
+	// HeadMatches is set when the chain holds the last known good head
+	HeadMatches bool `json:"head_matches"`
+	// Affected spans the timestamps of the blocks replayed after the known
+	// head, torn or lost, nil if there are none
+	Affected *TimeRange `json:"affected,omitempty"`
+	// Degraded is set when blocks are missing that neither a torn record
+	// nor the log explain; Problems says which
+	Degraded bool     `json:"degraded"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// String renders the report for the log and the menu
+func (r *RecoveryReport) String() string {
+	var sb strings.Builder
+	state := "nach einem Absturz"
+	if r.Clean {
+		state = "nach sauberem Beenden"
+	}
+	fmt.Fprintf(&sb, "Wiederherstellung von %s %s am %s\n", r.Log, state, r.RecoveredAt.Format(time.RFC3339))
+	fmt.Fprintf(&sb, "  Blöcke aus dem Protokoll: %d, davon nach dem letzten bekannten Kopf: %d\n", r.Replayed, r.AfterKnownHead)
+	if r.TornBytes > 0 {
+		fmt.Fprintf(&sb, "  Unvollständiger Eintrag verworfen: %d Bytes\n", r.TornBytes)
+	}
+	fmt.Fprintf(&sb, "  Kopf: Block %d (%s)\n", r.Head.Index, formatHash(r.Head.Hash))
+	if r.KnownHead != nil {
+		match := "stimmt überein"
+		if !r.HeadMatches {
+			match = "fehlt"
+		}
+		fmt.Fprintf(&sb, "  Letzter bekannter Kopf: Block %d (%s), %s\n", r.KnownHead.Index, formatHash(r.KnownHead.Hash), match)
+	}
+	if r.Affected != nil {
+		fmt.Fprintf(&sb, "  Betroffener Zeitraum: %s bis %s\n", r.Affected.Start.Format(time.RFC3339), r.Affected.End.Format(time.RFC3339))
+	}
+	for _, problem := range r.Problems {
+		fmt.Fprintf(&sb, "  %s\n", problem)
+	}
+	if r.Degraded {
+		sb.WriteString("  Die Blockchain ist beeinträchtigt\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// widen extends the affected range of r to cover the timestamps from
+// first to last
+func (r *RecoveryReport) widen(first, last time.Time) {
+	end := last.Add(time.Nanosecond)
+	if r.Affected == nil {
+		r.Affected = &TimeRange{Start: first, End: end}
+		return
+	}
+	if first.Before(r.Affected.Start) {
+		r.Affected.Start = first
+	}
+	if end.After(r.Affected.End) {
+		r.Affected.End = end
+	}
+}
+
+// readHeadRecord returns the head record of the block log at logPath, nil
+// if there is none
+func readHeadRecord(logPath string) (*headRecord, error) {
+	data, err := os.ReadFile(logPath + headRecordSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record headRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("Kopfvermerk %s: %w", logPath+headRecordSuffix, err)
+	}
+	return &record, nil
+}
+
+// recordHead replaces the head record of the log with head, which the
+// log holds synced. A failure is only logged: the block is safe in the
+// log, and a stale record is explained by the blocks after it.
+func (l *blockLog) recordHead(head *Block, clean bool) {
+	record := headRecord{HeadRef: HeadRef{Index: head.Index, Hash: head.Hash, Timestamp: head.Timestamp}, Clean: clean}
+	data, err := json.Marshal(record)
+	if err == nil {
+		path := l.path + headRecordSuffix
+		if err = os.WriteFile(path+".tmp", data, 0o600); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		log.Printf("Blockprotokoll %s: letzter bekannter Kopf konnte nicht vermerkt werden: %v", l.path, err)
+	}
+}
+
+// newRecoveryReport compares the chain recovered from the log at path,
+// after replayed blocks and torn bytes, with the head record of the log
+func newRecoveryReport(bc *Blockchain, path string, replayed int, torn int64, record *headRecord, recordErr error) *RecoveryReport {
+	head := bc.head
+	r := &RecoveryReport{
+		Log:         path,
+		RecoveredAt: bc.clock.Now(),
+		Replayed:    replayed,
+		TornBytes:   torn,
+		Head:        HeadRef{Index: head.Index, Hash: head.Hash, Timestamp: head.Timestamp},
+	}
+	if torn > 0 {
+		// the torn block was written after the head and before the crash
+		r.widen(head.Timestamp, r.RecoveredAt)
+	}
+	switch {
+	case recordErr != nil:
+		r.Problems = append(r.Problems, recordErr.Error())
+		return r
+	case record == nil:
+		r.Problems = append(r.Problems, "Kein Kopfvermerk vorhanden, der Kopf kann nicht geprüft werden")
+		return r
+	}
+	r.Clean = record.Clean && torn == 0
+	known := record.HeadRef
+	r.KnownHead = &known
+
+	if pos, err := bc.position(known.Index); err == nil {
+		block, err := bc.storage.GetBlock(bc.base + pos)
+		r.HeadMatches = err == nil && block.Hash == known.Hash
+	}
+	switch {
+	case !r.HeadMatches && torn > 0 && known.Index == head.Index+1:
+		// the log holds the known head only as the torn record
+		r.Problems = append(r.Problems, fmt.Sprintf("Der letzte bekannte Kopf, Block %d, war der unvollständige Eintrag", known.Index))
+		r.widen(head.Timestamp, known.Timestamp)
+		return r
+	case !r.HeadMatches:
+		r.Degraded = true
+		r.Problems = append(r.Problems, fmt.Sprintf("Der letzte bekannte Kopf, Block %d, fehlt in der Blockchain", known.Index))
+		r.widen(head.Timestamp, known.Timestamp)
+		return r
+	}
+	if r.AfterKnownHead = head.Index - known.Index; r.AfterKnownHead > 0 {
+		first, err := bc.storage.GetBlock(known.Index + 1)
+		if err == nil {
+			r.widen(first.Timestamp, head.Timestamp)
+		}
+	}
+	return r
+}
+
+// missingLogReport returns the report of a chain whose block log at path
+// is gone, nil if its head record is gone too and the chain is new
+func missingLogReport(bc *Blockchain, path string) *RecoveryReport {
+	record, err := readHeadRecord(path)
+	if record == nil && err == nil {
+		return nil
+	}
+	r := newRecoveryReport(bc, path, 0, 0, record, err)
+	r.MissingLog = true
+	r.Degraded = true
+	r.Problems = append([]string{"Das Blockprotokoll fehlt, die Blockchain beginnt neu"}, r.Problems...)
+	return r
+}
+
+// setRecovery keeps r as the recovery report of the chain and logs it
+// unless the log was closed cleanly
+func (bc *Blockchain) setRecovery(r *RecoveryReport) {
+	if r == nil {
+		return
+	}
+	bc.recovery = r
+	if !r.Clean || r.Degraded {
+		log.Println(r)
+	}
+}
+
+// Recovery returns the report of the recovery of a chain restored from a
+// block log, nil once AcknowledgeRecovery was called or for other chains
+func (bc *Blockchain) Recovery() *RecoveryReport {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	if bc.recovery == nil {
+		return nil
+	}
+	r := *bc.recovery
+	if r.KnownHead != nil {
+		known := *r.KnownHead
+		r.KnownHead = &known
+	}
+	if r.Affected != nil {
+		affected := *r.Affected
+		r.Affected = &affected
+	}
+	r.Problems = append([]string(nil), r.Problems...)
+	return &r
+}
+
+// Degraded reports whether the recovery report of the chain found blocks
+// missing and has not been acknowledged yet
+func (bc *Blockchain) Degraded() bool {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.recovery != nil && bc.recovery.Degraded
+}
+
+// AcknowledgeRecovery dismisses the recovery report, which clears
+// Degraded. It returns ErrNoRecovery if there is none.
+func (bc *Blockchain) AcknowledgeRecovery() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.recovery == nil {
+		return ErrNoRecovery
+	}
+	bc.recovery = nil
+	return nil
+}
+
+// printRecovery shows the recovery report in the menu and asks to
+// acknowledge it
+func (c *cli) printRecovery() error {
+	r := c.bc.Recovery()
+	if r == nil {
+		fmt.Fprintln(c.out, ErrNoRecovery)
+		return nil
+	}
+	fmt.Fprintln(c.out, r)
+	answer, err := promptString(c.in, "Bericht bestätigen? (j/n)")
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(answer, "j") {
+		if err := c.bc.AcknowledgeRecovery(); err != nil {
+			fmt.Fprintln(c.out, err)
+		} else {
+			fmt.Fprintln(c.out, "Bericht bestätigt")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newLoggedChain returns a chain logged to a new file with n blocks added
+func newLoggedChain(t *testing.T, n int) (*Blockchain, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "chain.log")
+	bc, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := bc.AddBlock([]float64{float64(i), 10, 20}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc, path
+}
+
+// crash stops bc as a crash would, closing its log without Close
+func crash(t *testing.T, bc *Blockchain) {
+	t.Helper()
+	if err := bc.log.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bc.log.file = nil
+}
+
+// recoverReport restores the chain logged at path and returns its report
+func recoverReport(t *testing.T, path string) (*Blockchain, *RecoveryReport) {
+	t.Helper()
+	bc, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.Close() })
+	r := bc.Recovery()
+	if r == nil {
+		t.Fatal("recovered chain has no recovery report")
+	}
+	return bc, r
+}
+
+func TestRecoveryAfterCleanShutdown(t *testing.T) {
+	bc, path := newLoggedChain(t, 3)
+	head := bc.LatestBlock()
+	if bc.Recovery() != nil {
+		t.Fatal("a new chain has a recovery report")
+	}
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, r := recoverReport(t, path)
+	if !r.Clean || r.Degraded || r.Replayed != 4 || r.AfterKnownHead != 0 || r.TornBytes != 0 || !r.HeadMatches || r.Affected != nil {
+		t.Fatalf("report after a clean shutdown is %+v", r)
+	}
+	if r.KnownHead == nil || r.KnownHead.Index != head.Index || r.KnownHead.Hash != head.Hash || r.Head.Hash != head.Hash {
+		t.Fatalf("known head is %+v, want block %d %s", r.KnownHead, head.Index, head.Hash)
+	}
+}
+
+func TestRecoveryAfterCrash(t *testing.T) {
+	bc, path := newLoggedChain(t, 3)
+	crash(t, bc)
+	_, r := recoverReport(t, path)
+	if r.Clean || r.Degraded || !r.HeadMatches || r.AfterKnownHead != 0 {
+		t.Fatalf("report after a crash between blocks is %+v, want unclean with the known head", r)
+	}
+}
+
+func TestRecoveryReplaysBlocksAfterKnownHead(t *testing.T) {
+	bc, path := newLoggedChain(t, 2)
+	record, err := os.ReadFile(path + headRecordSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// blocks 3 and 4 are synced while the head record stays at block 2,
+	// as when the chain crashes before recording them
+	for i := 0; i < 2; i++ {
+		if _, err := bc.AddBlock([]float64{30, 40, float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocks := bc.Blocks()
+	crash(t, bc)
+	if err := os.WriteFile(path+headRecordSuffix, record, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, r := recoverReport(t, path)
+	if r.Degraded || !r.HeadMatches || r.Replayed != 5 || r.AfterKnownHead != 2 || r.KnownHead.Index != 2 {
+		t.Fatalf("report is %+v, want 2 blocks replayed after known head 2", r)
+	}
+	if r.Affected == nil || !r.Affected.Start.Equal(blocks[3].Timestamp) || !r.Affected.Contains(blocks[4].Timestamp) {
+		t.Fatalf("affected range is %+v, want blocks 3 and 4", r.Affected)
+	}
+}
+
+func TestRecoveryAfterTornTail(t *testing.T) {
+	bc, path := newLoggedChain(t, 3)
+	head := bc.LatestBlock()
+	crash(t, bc)
+	// the record of a fourth block was cut short by the crash
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte{0, 0, 1, 0, 0xde, 0xad, 0xbe, 0xef, 1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	recovered, r := recoverReport(t, path)
+	if r.Clean || r.Degraded || r.TornBytes != 11 || r.Replayed != 4 || !r.HeadMatches {
+		t.Fatalf("report after a torn tail is %+v, want 11 torn bytes and the known head", r)
+	}
+	if r.Affected == nil || !r.Affected.Start.Equal(head.Timestamp) {
+		t.Fatalf("affected range is %+v, want it to start at the head", r.Affected)
+	}
+	if recovered.HeadHash() != head.Hash {
+		t.Fatalf("recovered head %s, want %s", recovered.HeadHash(), head.Hash)
+	}
+}
+
+func TestRecoveryLostBlocksDegrade(t *testing.T) {
+	bc, path := newLoggedChain(t, 2)
+	logged, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{7, 8, 9}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a complete record the head record covers is lost
+	if err := os.Truncate(path, logged.Size()); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, r := recoverReport(t, path)
+	if !r.Degraded || r.HeadMatches || r.TornBytes != 0 || r.Head.Index != 2 || r.KnownHead.Index != 3 || len(r.Problems) == 0 {
+		t.Fatalf("report after losing a block is %+v, want it degraded", r)
+	}
+	if !recovered.Degraded() {
+		t.Fatal("chain missing its known head is not degraded")
+	}
+}
+
+func TestRecoveryMissingLog(t *testing.T) {
+	bc, path := newLoggedChain(t, 3)
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, r := recoverReport(t, path)
+	if !r.MissingLog || !r.Degraded || r.HeadMatches || r.Replayed != 0 || r.KnownHead.Index != 3 {
+		t.Fatalf("report without the log is %+v, want it degraded", r)
+	}
+	if recovered.Length() != 1 {
+		t.Fatalf("chain without its log holds %d blocks, want a new one", recovered.Length())
+	}
+
+	// once both are gone the chain is simply new
+	recovered.Close()
+	for _, name := range []string{path, path + headRecordSuffix} {
+		if err := os.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fresh, err := NewBlockchain(WithPersistence(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+	if fresh.Recovery() != nil {
+		t.Fatalf("new chain has recovery report %+v", fresh.Recovery())
+	}
+}
+
+func TestAcknowledgeRecovery(t *testing.T) {
+	bc, path := newLoggedChain(t, 1)
+	bc.Close()
+	os.Remove(path)
+	recovered, _ := recoverReport(t, path)
+
+	if err := recovered.AcknowledgeRecovery(); err != nil {
+		t.Fatal(err)
+	}
+	if recovered.Recovery() != nil || recovered.Degraded() {
+		t.Fatal("acknowledged report is still there")
+	}
+	if err := recovered.AcknowledgeRecovery(); !errors.Is(err, ErrNoRecovery) {
+		t.Fatalf("second acknowledgement returned %v, want ErrNoRecovery", err)
+	}
+}
+
+func TestAPIRecovery(t *testing.T) {
+	bc, path := newLoggedChain(t, 2)
+	crash(t, bc)
+	recovered, _ := recoverReport(t, path)
+	handler := NewAPIHandler(recovered, NewDefaultPipeline(recovered), withAdminToken(t))
+
+	var r RecoveryReport
+	decodeResponse(t, serveAdmin(handler, "GET", "/recovery", ""), http.StatusOK, &r)
+	if r.Replayed != 3 || r.Clean || !r.HeadMatches || r.Log != path {
+		t.Fatalf("GET /recovery returned %+v", r)
+	}
+	decodeResponse(t, serveAdmin(handler, "POST", "/recovery/ack", ""), http.StatusOK, &r)
+	expectAPIError(t, serveAdmin(handler, "GET", "/recovery", ""), http.StatusNotFound)
+	expectAPIError(t, serveAdmin(handler, "POST", "/recovery/ack", ""), http.StatusNotFound)
+}
+
+func TestCLIRecovery(t *testing.T) {
+	bc, path := newLoggedChain(t, 2)
+	bc.Close()
+	os.Remove(path)
+	recovered, _ := recoverReport(t, path)
+
+	var out bytes.Buffer
+	RunCLI(recovered, strings.NewReader("23\nj\n23\n"), &out)
+	for _, want := range []string{"23. Wiederherstellungsbericht anzeigen (Blockchain beeinträchtigt)", "Das Blockprotokoll fehlt", "Bericht bestätigt", ErrNoRecovery.Error()} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("menu output does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
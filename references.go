@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidReference is matched by every error from Reference.Validate
+var ErrInvalidReference = errors.New("Ungültige Referenz")
+
+// Limits for references attached to a block
+const (
+	maxReferencesPerBlock   = 32
+	maxReferenceTypeLength  = 64
+	maxReferenceURILength   = 2048
+	maxReferenceDescription = 512
+)
+
+// referenceSchemes are the URI schemes a reference may use
+var referenceSchemes = map[string]bool{"http": true, "https": true, "s3": true, "urn": true}
+
+// Reference links a block to an external record such as a ticket, a lab
+// notebook entry or an S3 object
+type Reference struct {
+	// Type says what the URI points to, e.g. "ticket" or "notebook"
+	Type        string `json:"type"`
+	URI         string `json:"uri"`
+	Description string `json:"description,omitempty"`
+}
+
+// Validate checks the scheme and the length limits of the reference
+func (r Reference) Validate() error {
+	switch {
+	case r.Type == "":
+		return fmt.Errorf("%w: Typ fehlt", ErrInvalidReference)
+	case len(r.Type) > maxReferenceTypeLength:
+		return fmt.Errorf("%w: Typ länger als %d Zeichen", ErrInvalidReference, maxReferenceTypeLength)
+	case len(r.URI) > maxReferenceURILength:
+		return fmt.Errorf("%w: URI länger als %d Zeichen", ErrInvalidReference, maxReferenceURILength)
+	case len(r.Description) > maxReferenceDescription:
+		return fmt.Errorf("%w: Beschreibung länger als %d Zeichen", ErrInvalidReference, maxReferenceDescription)
+	}
+
+	u, err := url.Parse(r.URI)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidReference, err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if !referenceSchemes[scheme] {
+		return fmt.Errorf("%w: Schema %q von %q nicht erlaubt (erlaubt: http, https, s3, urn)", ErrInvalidReference, u.Scheme, r.URI)
+	}
+	if scheme == "urn" && u.Opaque == "" || scheme != "urn" && u.Host == "" {
+		return fmt.Errorf("%w: %q ist unvollständig", ErrInvalidReference, r.URI)
+	}
+	return nil
+}
+
+// Href returns the URI for use as a link target in the HTML report. Only
+// validated references reach a block, so the scheme is one of the allowed.
+func (r Reference) Href() template.URL {
+	return template.URL(r.URI)
+}
+
+func (r Reference) String() string {
+	if r.Description != "" {
+		return fmt.Sprintf("%s: %s (%s)", r.Type, r.URI, r.Description)
+	}
+	return fmt.Sprintf("%s: %s", r.Type, r.URI)
+}
+
+// validateReferences checks every reference and the per-block limit
+func validateReferences(refs []Reference) error {
+	if len(refs) > maxReferencesPerBlock {
+		return fmt.Errorf("%w: %d Referenzen, höchstens %d erlaubt", ErrInvalidReference, len(refs), maxReferencesPerBlock)
+	}
+	for _, ref := range refs {
+		if err := ref.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddBlockWithReferences adds a new block carrying references to external
+// records. References are not covered by the hash.
+func (bc *Blockchain) AddBlockWithReferences(values []float64, text string, metadata map[string]string, refs []Reference) error {
+	if err := validateReferences(refs); err != nil {
+		return err
+	}
+	_, err := bc.addBlock(blockPayload{values: values, text: text, metadata: metadata, references: refs})
+	return err
+}
+
+// SetReferences replaces the references of the block with the given
+// index. Since references are outside the hash, this does not affect the
+// chain's integrity.
+func (bc *Blockchain) SetReferences(index int, refs []Reference) error {
+	if err := validateReferences(refs); err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.updateReferences(index, func([]Reference) []Reference { return refs })
+}
+
+// AddReference appends ref to the references of the block with the given
+// index
+func (bc *Blockchain) AddReference(index int, ref Reference) error {
+	if err := ref.Validate(); err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.updateReferences(index, func(refs []Reference) []Reference { return append(refs, ref) })
+}
+
+// updateReferences replaces the references of a block with update's
+// result and keeps the memory estimate current. Called with bc.mu held.
+func (bc *Blockchain) updateReferences(index int, update func([]Reference) []Reference) error {
+	pos, err := bc.position(index)
+	if err != nil {
+		return err
+	}
+	block, err := bc.block(pos)
+	if err != nil {
+		return err
+	}
+	refs := update(append([]Reference(nil), block.References...))
+	if len(refs) > maxReferencesPerBlock {
+		return fmt.Errorf("%w: %d Referenzen, höchstens %d erlaubt", ErrInvalidReference, len(refs), maxReferencesPerBlock)
+	}
+	rewriter, ok := backendStorage(bc.storage).(storageRewriter)
+	if !ok {
+		return errStorageReadOnly
+	}
+	// a copy replaces the block, so snapshots holding it stay intact; the
+	// references are not covered by the hash, so the indexes still hold
+	updated := copyBlock(block)
+	updated.References = append([]Reference(nil), refs...)
+	if err := rewriter.rewrite(index, index, []*Block{updated}, nil); err != nil {
+		return fmt.Errorf("Speicher konnte nicht geändert werden: %w", err)
+	}
+	bc.rewrites++
+	bc.memUsage += estimateBlockSize(updated) - estimateBlockSize(block)
+	if pos == bc.held-1 {
+		bc.head = updated
+	}
+	return nil
+}
+
+// BlocksReferencing returns copies of the blocks with a reference of the
+// given type, or of any type when refType is empty, whose URI or
+// description contains query, ignoring case
+func (bc *Blockchain) BlocksReferencing(refType, query string) []*Block {
+	query = strings.ToLower(query)
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	held, err := bc.allBlocks()
+	logReadError(err)
+	var blocks []*Block
+	for _, block := range held {
+		for _, ref := range block.References {
+			if refType != "" && !strings.EqualFold(ref.Type, refType) {
+				continue
+			}
+			if strings.Contains(strings.ToLower(ref.URI), query) || strings.Contains(strings.ToLower(ref.Description), query) {
+				blocks = append(blocks, copyBlock(block))
+				break
+			}
+		}
+	}
+	return blocks
+}
+
+// printReferences prints the references of a block as plain URIs
+func printReferences(w io.Writer, block *Block) {
+	if len(block.References) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Referenzen:")
+	for _, ref := range block.References {
+		fmt.Fprintf(w, "  %s\n", ref)
+	}
+}
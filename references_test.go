@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReferenceValidate(t *testing.T) {
+	tests := []struct {
+		ref Reference
+		ok  bool
+	}{
+		{Reference{Type: "ticket", URI: "https://jira.example.com/LAB-42"}, true},
+		{Reference{Type: "rohdaten", URI: "s3://messungen/2024/lauf-7.csv"}, true},
+		{Reference{Type: "probe", URI: "urn:isbn:0451450523"}, true},
+		{Reference{Type: "", URI: "https://example.com"}, false},
+		{Reference{Type: "link", URI: "javascript:alert(1)"}, false},
+		{Reference{Type: "link", URI: "file:///etc/passwd"}, false},
+		{Reference{Type: "link", URI: "https://"}, false},
+		{Reference{Type: "link", URI: "urn:"}, false},
+		{Reference{Type: "link", URI: "https://example.com/" + strings.Repeat("a", maxReferenceURILength)}, false},
+		{Reference{Type: "link", URI: "https://example.com", Description: strings.Repeat("a", maxReferenceDescription+1)}, false},
+	}
+	for _, tt := range tests {
+		err := tt.ref.Validate()
+		if (err == nil) != tt.ok || err != nil && !errors.Is(err, ErrInvalidReference) {
+			t.Fatalf("reference %s: %v", tt.ref.URI, err)
+		}
+	}
+}
+
+func TestBlockReferences(t *testing.T) {
+	bc := newFilledChain(t)
+	ticket := Reference{Type: "ticket", URI: "https://jira.example.com/LAB-42", Description: "Kalibrierung"}
+	if err := bc.AddBlockWithReferences([]float64{1, 2}, "lauf 7", nil, []Reference{ticket}); err != nil {
+		t.Fatal(err)
+	}
+	head := bc.LatestBlock()
+	notebook := Reference{Type: "notebook", URI: "s3://labor/buch/7"}
+	if err := bc.AddReference(1, notebook); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddReference(1, Reference{Type: "notebook", URI: "ftp://labor/7"}); !errors.Is(err, ErrInvalidReference) {
+		t.Fatalf("reference with ftp returned %v", err)
+	}
+
+	// references stay outside the hash
+	if err := bc.SetReferences(head.Index, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetReferences(head.Index, []Reference{ticket, notebook}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if bc.LatestBlock().Hash != head.Hash || len(bc.LatestBlock().References) != 2 {
+		t.Fatalf("head after setting references is %+v", bc.LatestBlock())
+	}
+
+	tests := []struct {
+		refType, query string
+		want           []int
+	}{
+		{"", "LAB-42", []int{head.Index}},
+		{"ticket", "kalibrierung", []int{head.Index}},
+		{"NOTEBOOK", "labor", []int{1, head.Index}},
+		{"ticket", "buch", nil},
+	}
+	for _, tt := range tests {
+		var got []int
+		for _, block := range bc.BlocksReferencing(tt.refType, tt.query) {
+			got = append(got, block.Index)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Fatalf("blocks with %s references to %q are %v, want %v", tt.refType, tt.query, got, tt.want)
+		}
+	}
+
+	many := make([]Reference, maxReferencesPerBlock+1)
+	for i := range many {
+		many[i] = notebook
+	}
+	if err := bc.SetReferences(1, many); !errors.Is(err, ErrInvalidReference) {
+		t.Fatalf("%d references returned %v", len(many), err)
+	}
+	if err := bc.AddReference(99, notebook); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("reference to a missing block returned %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reportTopOutliers is the number of outlier blocks listed in a report
+const reportTopOutliers = 10
+
+// ReportTemplate is the template used by GenerateReport. Replace it before
+// calling GenerateReport to customize the layout; it receives a ReportData.
+var ReportTemplate = template.Must(template.New("report").Parse(defaultReportTemplate))
+
+// ReportData is the data passed to ReportTemplate
+type ReportData struct {
+	GeneratedAt   time.Time
+	BlockCount    int
+	ValueCount    int
+	OutlierBlocks int
+	OutlierCount  int
+	Head          *Block
+	MeansChart    template.HTML
+	TopOutliers   []*Block
+	MeanQuality   float64
+	LowQuality    []*Block
+	Referenced    []*Block
+	Clusters      []OutlierCluster
+	Ingestion     []IngestionSource
+
+	// ValidationError is why Validate failed, "" if the chain is valid;
+	// Warnings are those of ValidationWarnings
+	ValidationError string
+	Warnings        []string
+	// AuditTail holds the latest lines of the audit log, see AuditTail
+	AuditTail []string
+}
+
+// IngestionSource counts the blocks that came in one way, see
+// ingestionSource
+type IngestionSource struct {
+	Name   string
+	Blocks int
+	Values int
+}
+
+// GenerateReport writes a self-contained report of the chain to w, with
+// the result of Validate and the tail of auditLog.
+// Only the "html" format is supported.
+func (bc *Blockchain) GenerateReport(w io.Writer, format string) error {
+	if format != "html" {
+		return fmt.Errorf("Ungültiges Berichtsformat: %s", format)
+	}
+
+	bc.mu.RLock()
+	now := bc.clock.Now()
+	bc.mu.RUnlock()
+	data := buildReportData(bc.snapshot())
+	data.GeneratedAt = now
+	if err := bc.Validate(); err != nil {
+		data.ValidationError = err.Error()
+	}
+	data.Warnings = bc.ValidationWarnings()
+	data.AuditTail = auditLog.Lines()
+	return ReportTemplate.Execute(w, data)
+}
+
+// buildReportData collects the figures of a report taken from the blocks
+func buildReportData(chain []*Block) ReportData {
+	data := ReportData{
+		BlockCount: len(chain),
+		Head:       chain[len(chain)-1],
+		MeansChart: renderMeansChart(chain),
+	}
+
+	var outlierBlocks, scored []*Block
+	sources := map[string]int{}
+	for _, block := range chain {
+		if name := ingestionSource(block); name != "" {
+			i, ok := sources[name]
+			if !ok {
+				i = len(data.Ingestion)
+				sources[name] = i
+				data.Ingestion = append(data.Ingestion, IngestionSource{Name: name})
+			}
+			data.Ingestion[i].Blocks++
+			data.Ingestion[i].Values += block.ValueCount()
+		}
+		if block.Quality != nil && block.Index > 0 {
+			scored = append(scored, block)
+			data.MeanQuality += block.Quality.Score
+		}
+		if len(block.References) > 0 {
+			data.Referenced = append(data.Referenced, block)
+		}
+		data.ValueCount += block.ValueCount()
+		data.OutlierCount += block.OutlierCount()
+		if block.HasOutliers {
+			outlierBlocks = append(outlierBlocks, block)
+		}
+	}
+	data.OutlierBlocks = len(outlierBlocks)
+
+	sort.SliceStable(outlierBlocks, func(i, j int) bool {
+		return outlierBlocks[i].OutlierCount() > outlierBlocks[j].OutlierCount()
+	})
+	if len(outlierBlocks) > reportTopOutliers {
+		outlierBlocks = outlierBlocks[:reportTopOutliers]
+	}
+	data.TopOutliers = outlierBlocks
+	data.Clusters = clusterOutliers(chain, OutlierClusterOptions{})
+
+	if len(scored) > 0 {
+		data.MeanQuality /= float64(len(scored))
+		sort.SliceStable(scored, func(i, j int) bool {
+			return scored[i].Quality.Score < scored[j].Quality.Score
+		})
+		data.LowQuality = scored[:min(len(scored), reportTopOutliers)]
+	}
+
+	return data
+}
+
+// ingestionSource names how block came in: imported from a file,
+// derived from other blocks, added with measurement times or directly.
+// It is "" for the genesis block and checkpoint records.
+func ingestionSource(block *Block) string {
+	switch {
+	case block.Index == 0 || block.Metadata["checkpoint"] == "true":
+		return ""
+	case block.Metadata["source_file"] != "":
+		return "Import " + block.Metadata["source_file"]
+	case len(block.ValueOrigins) > 0:
+		return "Abgeleitet"
+	case len(block.ValueTimes) > 0:
+		return "Mit Messzeiten"
+	}
+	return "Direkt"
+}
+
+// renderMeansChart renders the block means as an inline SVG line chart
+func renderMeansChart(chain []*Block) template.HTML {
+	const width, height = 600.0, 200.0
+
+	var series []Point
+	for _, block := range chain {
+		if block.ValueCount() > 0 {
+			series = append(series, Point{X: float64(len(series)), Y: block.Mean})
+		}
+	}
+	if len(series) == 0 {
+		return template.HTML(`<p>Keine Daten vorhanden.</p>`)
+	}
+
+	// one point per pixel is enough; lttb keeps the shape of the curve
+	caption := ""
+	if len(series) > int(width) {
+		series = lttbDownsampler{}.Downsample(series, int(width))
+		caption = `<p><small>Verdichtet mit lttb</small></p>`
+	}
+	last := series[len(series)-1].X
+
+	lo, hi := series[0].Y, series[0].Y
+	for _, p := range series {
+		lo = min(lo, p.Y)
+		hi = max(hi, p.Y)
+	}
+	span := hi - lo
+	if span == 0 {
+		span = 1
+	}
+
+	var points strings.Builder
+	for _, p := range series {
+		x := 0.0
+		if last > 0 {
+			x = p.X / last * width
+		}
+		y := height - (p.Y-lo)/span*height
+		fmt.Fprintf(&points, "%.1f,%.1f ", x, y)
+	}
+
+	return template.HTML(fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f">`+
+			`<polyline fill="none" stroke="#1f77b4" stroke-width="1.5" points="%s"/></svg>%s`,
+		width, height, width, height, strings.TrimSpace(points.String()), caption))
+}
+
+const defaultReportTemplate = `<!DOCTYPE html>
+<html lang="de">
+<head>
+<meta charset="utf-8">
+<title>Blockchain-Bericht</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: right; }
+code { font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>Blockchain-Bericht</h1>
+<p>Erstellt: {{.GeneratedAt.Format "2006-01-02 15:04:05"}}</p>
+
+<h2>Prüfung</h2>
+{{if .ValidationError}}<p><strong>Ungültig:</strong> {{.ValidationError}}</p>
+{{else}}<p>Die Blockchain ist gültig.</p>
+{{end}}{{if .Warnings}}<ul>
+{{range .Warnings}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}
+<h2>Übersicht</h2>
+<table>
+<tr><th>Blöcke</th><td>{{.BlockCount}}</td></tr>
+<tr><th>Werte</th><td>{{.ValueCount}}</td></tr>
+<tr><th>Blöcke mit Ausreißern</th><td>{{.OutlierBlocks}}</td></tr>
+<tr><th>Ausreißer gesamt</th><td>{{.OutlierCount}}</td></tr>
+<tr><th>Mittlere Qualität</th><td>{{printf "%.1f" .MeanQuality}}</td></tr>
+<tr><th>Letzter Block</th><td>{{.Head.Index}} <code>{{.Head.Hash}}</code></td></tr>
+</table>
+
+<h2>Mittelwerte je Block</h2>
+{{.MeansChart}}
+
+<h2>Blöcke mit den meisten Ausreißern</h2>
+{{if .TopOutliers}}
+<table>
+<tr><th>Index</th><th>Zeitstempel</th><th>Ausreißer</th><th>Mittelwert</th><th>Qualität</th></tr>
+{{range .TopOutliers}}<tr><td>{{.Index}}</td><td>{{.Timestamp.Format "2006-01-02 15:04:05"}}</td><td>{{.OutlierCount}}</td><td>{{printf "%.2f" .Mean}}</td><td>{{with .Quality}}{{printf "%.1f" .Score}}{{end}}</td></tr>
+{{range .OutlierContexts}}<tr><td></td><td colspan="4">Position {{.Position}}: <code>{{.}}</code></td></tr>
+{{end}}{{end}}</table>
+{{else}}
+<p>Keine Ausreißer gefunden.</p>
+{{end}}
+
+{{if .Clusters}}
+<h2>Ausreißer-Cluster</h2>
+<table>
+<tr><th>Bereich</th><th>Ausreißer</th><th>Blöcke</th><th>Erstes Auftreten</th><th>Letztes Auftreten</th></tr>
+{{range .Clusters}}<tr><td>{{printf "%.2f" .Min}} bis {{printf "%.2f" .Max}}</td><td>{{.Count}}</td><td>{{len .Blocks}}</td><td>{{.First.Format "2006-01-02 15:04:05"}}</td><td>{{.Last.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .LowQuality}}
+<h2>Blöcke mit der niedrigsten Qualität</h2>
+<table>
+<tr><th>Index</th><th>Qualität</th><th>Abzüge</th></tr>
+{{range .LowQuality}}<tr><td>{{.Index}}</td><td>{{printf "%.1f" .Quality.Score}}</td><td>{{range $name, $points := .Quality.Penalties}}{{$name}} {{printf "%.1f" $points}} {{end}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .Ingestion}}
+<h2>Herkunft der Blöcke</h2>
+<table>
+<tr><th>Herkunft</th><th>Blöcke</th><th>Werte</th></tr>
+{{range .Ingestion}}<tr><td>{{.Name}}</td><td>{{.Blocks}}</td><td>{{.Values}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .Referenced}}
+<h2>Referenzen</h2>
+<table>
+<tr><th>Index</th><th>Typ</th><th>Referenz</th></tr>
+{{range .Referenced}}{{$index := .Index}}{{range .References}}<tr><td>{{$index}}</td><td>{{.Type}}</td><td><a href="{{.Href}}">{{.URI}}</a>{{with .Description}} {{.}}{{end}}</td></tr>
+{{end}}{{end}}</table>
+{{end}}
+
+<h2>Protokoll</h2>
+{{if .AuditTail}}<pre>{{range .AuditTail}}{{.}}
+{{end}}</pre>
+{{else}}<p>Keine Einträge.</p>
+{{end}}
+</body>
+</html>
+`
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// goldenReport is the report of goldenChain
+const goldenReport = "testdata/report.html"
+
+// withAuditLog replaces auditLog with one holding lines for the test
+func withAuditLog(t *testing.T, lines ...string) {
+	t.Helper()
+	saved := auditLog
+	auditLog = NewAuditTail(auditTailLines)
+	for _, line := range lines {
+		auditLog.Write([]byte(line + "\n"))
+	}
+	t.Cleanup(func() { auditLog = saved })
+}
+
+func TestGenerateReportGolden(t *testing.T) {
+	withAuditLog(t,
+		"2024/03/01 08:02:00 Wertgrenzen geändert: keine -> [0, 150]",
+		"2024/03/01 08:03:00 Vorgang prune bestätigt (abc123): 2 Blöcke archivieren, 2 Blöcke",
+	)
+	bc := goldenChain(t)
+	var buf bytes.Buffer
+	if err := bc.GenerateReport(&buf, "html"); err != nil {
+		t.Fatal(err)
+	}
+	if *updateGolden {
+		if err := os.WriteFile(goldenReport, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(goldenReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("report differs from %s; run the tests with -update-golden after checking it:\n%s", goldenReport, buf.String())
+	}
+}
+
+func TestReportValidationStatus(t *testing.T) {
+	withAuditLog(t)
+	bc := newFilledChain(t)
+	var buf bytes.Buffer
+	if err := bc.GenerateReport(&buf, "html"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Die Blockchain ist gültig.", "<p>Keine Einträge.</p>"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("report of a valid chain lacks %q", want)
+		}
+	}
+
+	tamper(t, bc, 2, func(b *Block) { b.Mean++ })
+	buf.Reset()
+	if err := bc.GenerateReport(&buf, "html"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<strong>Ungültig:</strong> "+template.HTMLEscapeString(bc.Validate().Error())) {
+		t.Fatalf("report of a tampered chain does not name the error:\n%s", buf.String())
+	}
+}
+
+func TestReportWarnings(t *testing.T) {
+	withAuditLog(t)
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := bc.LatestBlock().Timestamp
+	clock := &testClock{now: start.Add(time.Minute)}
+	bc.SetClock(clock)
+	if err := bc.SetTimestampPolicy(TimestampClamp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	clock.now = start
+	if _, err := bc.AddBlock([]float64{4, 5, 6}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := bc.GenerateReport(&buf, "html"); err != nil {
+		t.Fatal(err)
+	}
+	warnings := bc.ValidationWarnings()
+	if len(warnings) == 0 {
+		t.Fatal("clamped timestamp gives no warning")
+	}
+	for _, warning := range warnings {
+		if !strings.Contains(buf.String(), "<li>"+template.HTMLEscapeString(warning)+"</li>") {
+			t.Fatalf("report lacks warning %q", warning)
+		}
+	}
+}
+
+func TestReportIngestionBreakdown(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, values := range [][]float64{{4, 5}, {6, 7, 8}} {
+		if err := bc.AddBlockWithMetadata(values, "", map[string]string{"source_file": "a.csv"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bc.SetTrackOrigins(true)
+	if err := bc.AddDerivedBlock([]float64{1}, []Origin{{BlockIndex: 1, Position: 0}}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err := bc.AddTimedValues([]Sample{{Time: now, Value: 1}, {Time: now.Add(time.Second), Value: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buildReportData(bc.snapshot())
+	want := []IngestionSource{
+		{Name: "Direkt", Blocks: 1, Values: 3},
+		{Name: "Import a.csv", Blocks: 2, Values: 5},
+		{Name: "Abgeleitet", Blocks: 1, Values: 1},
+		{Name: "Mit Messzeiten", Blocks: 1, Values: 2},
+	}
+	if len(data.Ingestion) != len(want) {
+		t.Fatalf("ingestion breakdown is %+v, want %+v", data.Ingestion, want)
+	}
+	for i := range want {
+		if data.Ingestion[i] != want[i] {
+			t.Fatalf("ingestion breakdown is %+v, want %+v", data.Ingestion, want)
+		}
+	}
+}
+
+func TestAuditTailKeepsLastLines(t *testing.T) {
+	tail := NewAuditTail(2)
+	tail.Write([]byte("eins\nzwei\ndr"))
+	tail.Write([]byte("ei\n\nvier"))
+	if got := tail.Lines(); strings.Join(got, "|") != "zwei|drei" {
+		t.Fatalf("lines are %q, want zwei and drei", got)
+	}
+
+	withAuditLog(t)
+	path := filepath.Join(t.TempDir(), "server.log")
+	if err := os.WriteFile(path, []byte(strings.Repeat("alt\n", auditTailLines)+"neu"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadAuditTail(path); err != nil {
+		t.Fatal(err)
+	}
+	lines := auditLog.Lines()
+	if len(lines) != auditTailLines || lines[len(lines)-1] != "neu" {
+		t.Fatalf("loaded %d lines ending in %q, want %d ending in neu", len(lines), lines[len(lines)-1], auditTailLines)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrBlockExpired is returned for blocks the retention policy deleted
+var ErrBlockExpired = errors.New("Block wurde nach Ablauf der Aufbewahrungsfrist gelöscht")
+
+// defaultCompactInterval is how often main runs the compactor
+const defaultCompactInterval = time.Minute
+
+// RetentionPolicy sets how long blocks keep their values and how long
+// they are kept at all, both counted from the block's timestamp
+type RetentionPolicy struct {
+	// FullRetention is the age from which blocks lose their value arrays
+	// and keep only their stats; 0 keeps them
+	FullRetention time.Duration
+	// StatsRetention is the age from which blocks are deleted; 0 keeps
+	// them. It must not be shorter than FullRetention.
+	StatsRetention time.Duration
+}
+
+// Validate checks that the durations are not negative and in order
+func (p RetentionPolicy) Validate() error {
+	switch {
+	case p.FullRetention < 0 || p.StatsRetention < 0:
+		return fmt.Errorf("Ungültige Aufbewahrungsfrist: %v / %v", p.FullRetention, p.StatsRetention)
+	case p.FullRetention > 0 && p.StatsRetention > 0 && p.StatsRetention < p.FullRetention:
+		return fmt.Errorf("Blöcke können nicht nach %v gelöscht werden, bevor ihre Werte nach %v verworfen werden", p.StatsRetention, p.FullRetention)
+	}
+	return nil
+}
+
+// retentionJSON is RetentionPolicy in the configuration, with durations
+// such as "24h"
+type retentionJSON struct {
+	Full  string `json:"full,omitempty"`
+	Stats string `json:"stats,omitempty"`
+}
+
+func (p RetentionPolicy) MarshalJSON() ([]byte, error) {
+	var aux retentionJSON
+	if p.FullRetention > 0 {
+		aux.Full = p.FullRetention.String()
+	}
+	if p.StatsRetention > 0 {
+		aux.Stats = p.StatsRetention.String()
+	}
+	return json.Marshal(aux)
+}
+
+func (p *RetentionPolicy) UnmarshalJSON(data []byte) error {
+	var aux retentionJSON
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*p = RetentionPolicy{}
+	for _, field := range []struct {
+		text string
+		d    *time.Duration
+	}{{aux.Full, &p.FullRetention}, {aux.Stats, &p.StatsRetention}} {
+		if field.text == "" {
+			continue
+		}
+		d, err := time.ParseDuration(field.text)
+		if err != nil {
+			return fmt.Errorf("Ungültige Aufbewahrungsfrist %q", field.text)
+		}
+		*field.d = d
+	}
+	return nil
+}
+
+// DroppedValues stands in for the value arrays the retention policy
+// dropped from a block
+type DroppedValues struct {
+	// Digest is the valuesDigest of the arrays, which the hash covers
+	Digest string `json:"digest,omitempty"`
+	// Count and Outliers are the number of values and outliers
+	Count    int `json:"count"`
+	Outliers int `json:"outliers"`
+	// Mean, M2, Min and Max are the moments of the values that
+	// AggregateStats merges, see blockMoments
+	Mean float64 `json:"mean"`
+	M2   float64 `json:"m2"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+}
+
+// expiredRef records the newest block the retention policy deleted, which
+// the oldest block held links to, and the totals of all deleted blocks,
+// which AggregateStats still covers
+type expiredRef struct {
+	LastIndex int         `json:"last_index"`
+	LastHash  string      `json:"last_hash"`
+	Totals    chainTotals `json:"totals"`
+}
+
+// CompactionResult counts what a pass of Compact changed
+type CompactionResult struct {
+	// Dropped counts the blocks that lost their value arrays
+	Dropped int
+	// Deleted counts the blocks removed from the chain
+	Deleted int
+}
+
+// SetRetentionPolicy sets the policy Compact applies. The zero policy
+// keeps every block as it is.
+func (bc *Blockchain) SetRetentionPolicy(policy RetentionPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.retention = policy
+	return nil
+}
+
+// Compact applies the retention policy at the time of the chain's clock.
+// Blocks older than FullRetention lose their values, outliers, times,
+// origins and outlier contexts and are marked ValuesDropped; their stats
+// stay, and the digest of the arrays keeps their hash verifiable. The
+// oldest blocks, up to the first one younger than StatsRetention, are
+// deleted; the head block always stays, and so does the record of a
+// chain bootstrapped from a checkpoint. As with Prune, Validate checks
+// that the oldest block held links to the newest deleted one, Checkpoint
+// still covers the deleted blocks. Unlike with Prune, AggregateStats still
+// covers the deleted blocks, and blocks that merely lost their values
+// count in full. Once blocks after the archive were deleted, Prune cannot
+// continue it. The storage and the block log, if any, are changed alike,
+// so deleted blocks and dropped values do not come back on a restart.
+func (bc *Blockchain) Compact() (CompactionResult, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	var result CompactionResult
+	policy := bc.retention
+	if policy == (RetentionPolicy{}) {
+		return result, nil
+	}
+	now := bc.clock.Now()
+
+	blocks, err := bc.allBlocks()
+	if err != nil {
+		return result, err
+	}
+	cut := 0
+	if policy.StatsRetention > 0 && !bc.stub {
+		for cut < len(blocks)-1 && now.Sub(blocks[cut].Timestamp) >= policy.StatsRetention {
+			cut++
+		}
+	}
+	var dropped []*Block
+	if policy.FullRetention > 0 {
+		for pos, block := range blocks[cut:] {
+			if pos+cut == 0 && bc.stub || block.ValuesDropped || block.ValueCount() == 0 {
+				continue
+			}
+			if now.Sub(block.Timestamp) < policy.FullRetention {
+				continue
+			}
+			dropped = append(dropped, dropValues(block))
+		}
+	}
+	if cut == 0 && len(dropped) == 0 {
+		return result, nil
+	}
+
+	state := bc.state()
+	if cut > 0 {
+		last := blocks[cut-1]
+		if state.Checkpoint == nil || state.Checkpoint.Index < last.Index {
+			if state.Checkpoint, err = bc.checkpointThrough(cut - 1); err != nil {
+				return result, err
+			}
+		}
+		// the deleted blocks still count in AggregateStats
+		expired := &expiredRef{LastIndex: last.Index, LastHash: last.Hash}
+		if bc.expired != nil {
+			expired.Totals = bc.expired.Totals
+		}
+		for _, block := range blocks[:cut] {
+			expired.Totals.add(block)
+		}
+		state.Expired = expired
+	}
+	if err := bc.rewriteChain(bc.base, bc.base+cut, dropped, state); err != nil {
+		return result, err
+	}
+	result.Deleted, result.Dropped = cut, len(dropped)
+	return result, nil
+}
+
+// Redact drops the value arrays of the blocks with an index from from to
+// to, like Compact does for old blocks: the stats stay and the hashes
+// still verify. Blocks without values are skipped. It returns the number
+// of blocks redacted. The storage and the block log, if any, are changed
+// alike.
+func (bc *Blockchain) Redact(from, to int) (int, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.redact(from, to)
+}
+
+// redactable returns the blocks with an index from from to to that Redact
+// would change. Called with bc.mu held.
+func (bc *Blockchain) redactable(from, to int) ([]*Block, error) {
+	if from > to {
+		return nil, fmt.Errorf("Ungültiger Bereich: Block %d bis %d", from, to)
+	}
+	start, err := bc.position(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := bc.position(to)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := bc.blocks(start, end+1)
+	if err != nil {
+		return nil, err
+	}
+	var changed []*Block
+	for pos, block := range blocks {
+		if start+pos == 0 && bc.stub || block.ValuesDropped || block.ValueCount() == 0 {
+			continue
+		}
+		changed = append(changed, block)
+	}
+	return changed, nil
+}
+
+// redact implements Redact. Called with bc.mu held.
+func (bc *Blockchain) redact(from, to int) (int, error) {
+	blocks, err := bc.redactable(from, to)
+	if err != nil || len(blocks) == 0 {
+		return 0, err
+	}
+	redacted := make([]*Block, len(blocks))
+	for i, block := range blocks {
+		redacted[i] = dropValues(block)
+	}
+	if err := bc.rewriteChain(from, from, redacted, nil); err != nil {
+		return 0, err
+	}
+	return len(redacted), nil
+}
+
+// dropValues returns a copy of block without its value arrays
+func dropValues(block *Block) *Block {
+	_, mean, m2, lo, hi := blockMoments(block)
+	dropped := &DroppedValues{
+		Digest:   valuesDigest(block),
+		Count:    block.ValueCount(),
+		Outliers: block.OutlierCount(),
+		Mean:     mean,
+		M2:       m2,
+		Min:      lo,
+		Max:      hi,
+	}
+
+	compacted := copyBlock(block)
+	compacted.Values, compacted.IntValues = nil, nil
+	compacted.Outliers, compacted.IntOutliers = nil, nil
+	compacted.ValueTimes, compacted.EncodedValues = nil, nil
+	compacted.ValueOrigins, compacted.OutlierContexts = nil, nil
+	compacted.ValuesDropped = true
+	compacted.Dropped = dropped
+	return compacted
+}
+
+// StartCompactor runs Compact every interval of the chain's clock until
+// ctx is done, logging what each pass changed and its errors
+func (bc *Blockchain) StartCompactor(ctx context.Context, interval time.Duration) {
+	go func() {
+		for {
+			bc.mu.RLock()
+			clock := bc.clock
+			bc.mu.RUnlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-clock.After(interval):
+			}
+
+			result, err := bc.Compact()
+			switch {
+			case err != nil:
+				log.Println("Aufbewahrungsrichtlinie nicht angewendet:", err)
+			case result.Dropped > 0 || result.Deleted > 0:
+				log.Printf("Aufbewahrungsrichtlinie: Werte von %d Blöcken verworfen, %d Blöcke gelöscht", result.Dropped, result.Deleted)
+			}
+		}
+	}()
+}
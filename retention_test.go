@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// retentionTestPolicy drops values after 90 minutes and deletes blocks
+// after 150
+var retentionTestPolicy = RetentionPolicy{FullRetention: 90 * time.Minute, StatsRetention: 150 * time.Minute}
+
+// agedChain adds three blocks to bc an hour apart on a testClock, which
+// it returns set to the time of the last one
+func agedChain(t *testing.T, bc *Blockchain) *testClock {
+	t.Helper()
+	clock := &testClock{now: time.Now()}
+	bc.SetClock(clock)
+	if err := bc.SetRetentionPolicy(retentionTestPolicy); err != nil {
+		t.Fatal(err)
+	}
+	for i, values := range [][]float64{{1, 2, 3}, {4, 5, 6, 7}, {8, 9}} {
+		if i > 0 {
+			clock.now = clock.now.Add(time.Hour)
+		}
+		if _, err := bc.AddBlock(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return clock
+}
+
+func TestCompactMovesBlocksThroughTiers(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := agedChain(t, bc)
+	stats := bc.AggregateStats()
+
+	// half an hour after block 1, every block is younger than FullRetention
+	clock.now = clock.now.Add(-90 * time.Minute)
+	if result, err := bc.Compact(); err != nil || result != (CompactionResult{}) {
+		t.Fatalf("early Compact returned %+v, %v, want no change", result, err)
+	}
+
+	// block 1 is two hours old: its values are dropped
+	clock.now = clock.now.Add(90 * time.Minute)
+	result, err := bc.Compact()
+	if err != nil || result != (CompactionResult{Dropped: 1}) {
+		t.Fatalf("Compact returned %+v, %v, want block 1 dropped", result, err)
+	}
+	block, err := bc.BlockByIndex(1)
+	if err != nil || !block.ValuesDropped || block.Dropped.Count != 3 {
+		t.Fatalf("block 1 is %+v, %v, want its 3 values dropped", block, err)
+	}
+
+	// an hour later genesis and block 1 are deleted and block 2 dropped
+	clock.now = clock.now.Add(time.Hour)
+	result, err = bc.Compact()
+	if err != nil || result != (CompactionResult{Dropped: 1, Deleted: 2}) {
+		t.Fatalf("Compact returned %+v, %v, want 2 deleted and 1 dropped", result, err)
+	}
+	if bc.HistoryStart() != 2 || bc.Length() != 2 {
+		t.Fatalf("chain starts at %d with %d blocks, want 2 with 2", bc.HistoryStart(), bc.Length())
+	}
+	if _, err := bc.BlockByIndex(1); !errors.Is(err, ErrBlockExpired) {
+		t.Fatalf("BlockByIndex(1) returned %v, want ErrBlockExpired", err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := bc.AggregateStats(); !sameStats(got, stats) {
+		t.Fatalf("AggregateStats after compaction are %v, want %v", got, stats)
+	}
+
+	// the head always stays
+	clock.now = clock.now.Add(24 * time.Hour)
+	if result, err := bc.Compact(); err != nil || result.Deleted != 1 || bc.Length() != 1 {
+		t.Fatalf("Compact a day later returned %+v, %v with %d blocks left, want the head kept", result, err, bc.Length())
+	}
+	if got := bc.AggregateStats(); !sameStats(got, stats) {
+		t.Fatalf("AggregateStats after deleting all but the head are %v, want %v", got, stats)
+	}
+}
+
+// compactionStores are the ways a compacted chain is persisted. open
+// returns a function that opens the chain in dir, again on every call.
+var compactionStores = []struct {
+	name string
+	open func(t *testing.T, dir string) func() (*Blockchain, error)
+}{
+	{"log", func(t *testing.T, dir string) func() (*Blockchain, error) {
+		path := filepath.Join(dir, "chain.log")
+		return func() (*Blockchain, error) { return NewBlockchain(WithPersistence(path)) }
+	}},
+	{"sqlite", func(t *testing.T, dir string) func() (*Blockchain, error) {
+		path := filepath.Join(dir, "chain.db")
+		return func() (*Blockchain, error) { return openSQLiteChain(path, ChainConfig{}) }
+	}},
+}
+
+func TestCompactSurvivesRestart(t *testing.T) {
+	for _, store := range compactionStores {
+		t.Run(store.name, func(t *testing.T) {
+			open := store.open(t, t.TempDir())
+			bc, err := open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := agedChain(t, bc)
+			stats := bc.AggregateStats()
+			clock.now = clock.now.Add(time.Hour)
+			if _, err := bc.Compact(); err != nil {
+				t.Fatal(err)
+			}
+			head := bc.HeadHash()
+			bc.Close()
+
+			resumed, err := open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resumed.Close()
+			if resumed.HistoryStart() != 2 || resumed.Length() != 2 || resumed.HeadHash() != head {
+				t.Fatalf("resumed chain starts at %d with %d blocks, want the compacted 2 from 2", resumed.HistoryStart(), resumed.Length())
+			}
+			if _, err := resumed.BlockByIndex(1); !errors.Is(err, ErrBlockExpired) {
+				t.Fatalf("BlockByIndex(1) returned %v, want ErrBlockExpired", err)
+			}
+			block, err := resumed.BlockByIndex(2)
+			if err != nil || !block.ValuesDropped {
+				t.Fatalf("block 2 is %+v, %v, want its values dropped", block, err)
+			}
+			if got := resumed.AggregateStats(); !sameStats(got, stats) {
+				t.Fatalf("AggregateStats after the restart are %v, want %v", got, stats)
+			}
+			if _, err := resumed.AddBlock([]float64{10, 11}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrFormatRoundTrip is returned by VerifyFormatRoundTrip for a chain that
+// does not come back from a format as it went in
+var ErrFormatRoundTrip = errors.New("Format gibt die Blockchain nicht unverändert zurück")
+
+// ErrUnknownFormat is returned for a format VerifyFormatRoundTrip does not
+// know
+var ErrUnknownFormat = errors.New("Unbekanntes Format")
+
+// maxRoundTripDiffs is the number of differences an ErrFormatRoundTrip
+// error lists
+const maxRoundTripDiffs = 10
+
+// roundTripBaseFormats are the formats a chain can be written to and read
+// back from: json is the file of SaveToFile, ndjson the export of
+// ExportNDJSON, blocklog the log of WithPersistence and sqlite the
+// database of OpenSQLiteStorage
+var roundTripBaseFormats = []string{"blocklog", "json", "ndjson", "sqlite"}
+
+// RoundTripFormats returns the formats VerifyFormatRoundTrip checks: the
+// base formats, and json and ndjson compressed with every registered
+// codec, such as json+gzip
+func RoundTripFormats() []string {
+	formats := slices.Clone(roundTripBaseFormats)
+	for _, codec := range SupportedCodecs() {
+		formats = append(formats, "json+"+codec, "ndjson+"+codec)
+	}
+	return formats
+}
+
+// VerifyFormatRoundTrip writes chain in format, one of RoundTripFormats,
+// reads it back and compares the result with chain field by field: values
+// and stats bit for bit, timestamps to the nanosecond and hashes as they
+// are; NaN equals NaN, and empty and missing slices and maps are alike. A
+// difference is an ErrFormatRoundTrip error listing the first
+// maxRoundTripDiffs in diff form, - for chain and + for what came back. A
+// codec registered with RegisterCodec can be checked as json+name.
+func VerifyFormatRoundTrip(format string, chain *Blockchain) error {
+	base, codec, compressed := strings.Cut(format, "+")
+	if !slices.Contains(roundTripBaseFormats, base) || compressed && (base == "blocklog" || base == "sqlite") {
+		return fmt.Errorf("%w: %s (unterstützt: %s)", ErrUnknownFormat, format, strings.Join(RoundTripFormats(), ", "))
+	}
+	if compressed {
+		if _, err := CodecByName(codec); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrUnknownFormat, format, err)
+		}
+	}
+
+	file, err := chain.chainFile()
+	if err != nil {
+		return err
+	}
+	var diffs []string
+	switch base {
+	case "json":
+		diffs, err = roundTripJSON(file, codec)
+	case "ndjson":
+		diffs, err = roundTripNDJSON(file.Blocks, codec)
+	case "blocklog":
+		diffs, err = roundTripBlockLog(chain, file.Blocks)
+	case "sqlite":
+		diffs, err = roundTripSQLite(file.Blocks)
+	}
+	if err != nil {
+		return fmt.Errorf("Format %s: %w", format, err)
+	}
+	return roundTripResult(format, diffs)
+}
+
+// VerifyStorageRoundTrip appends the blocks of chain to storage, which
+// must be empty, and compares them with what GetBlock, LatestBlock and
+// Iterate return, as VerifyFormatRoundTrip does for a format. It checks an
+// implementation of Storage outside the package.
+func VerifyStorageRoundTrip(storage Storage, chain *Blockchain) error {
+	if n := storage.Count(); n != 0 {
+		return fmt.Errorf("Speicher ist nicht leer (%d Blöcke)", n)
+	}
+	file, err := chain.chainFile()
+	if err != nil {
+		return err
+	}
+	for _, block := range file.Blocks {
+		if err := storage.AppendBlock(block); err != nil {
+			return err
+		}
+	}
+	diffs, err := diffStorage(storage, file.Blocks)
+	if err != nil {
+		return err
+	}
+	return roundTripResult(fmt.Sprintf("Speicher %T", storage), diffs)
+}
+
+// roundTripResult returns nil without diffs and the ErrFormatRoundTrip
+// error listing them otherwise
+func roundTripResult(format string, diffs []string) error {
+	if len(diffs) == 0 {
+		return nil
+	}
+	shown := diffs[:min(len(diffs), maxRoundTripDiffs)]
+	msg := fmt.Sprintf("%v: %s, %d Abweichungen\n%s", ErrFormatRoundTrip, format, len(diffs), strings.Join(shown, "\n"))
+	if len(diffs) > len(shown) {
+		msg += fmt.Sprintf("\n… und %d weitere", len(diffs)-len(shown))
+	}
+	return &roundTripError{msg: msg}
+}
+
+// roundTripError is the error of VerifyFormatRoundTrip, which lists the
+// differences after ErrFormatRoundTrip
+type roundTripError struct {
+	msg string
+}
+
+func (e *roundTripError) Error() string { return e.msg }
+
+func (e *roundTripError) Unwrap() error { return ErrFormatRoundTrip }
+
+// roundTripJSON writes file as SaveToFile does and compares it with what
+// reads back. A file that comes back unchanged must load as well.
+func roundTripJSON(file *chainFile, codec string) ([]string, error) {
+	var buf bytes.Buffer
+	err := writeThroughCodec(&buf, codec, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(file)
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := readThroughCodec(&buf, codec, io.ReadAll)
+	if err != nil {
+		return nil, err
+	}
+	got, err := parseChainFile(data)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []string
+	wantChain, gotChain := *file, *got
+	wantChain.Blocks, gotChain.Blocks = nil, nil
+	diffValue("Blockchain", reflect.ValueOf(wantChain), reflect.ValueOf(gotChain), &diffs)
+	diffs = append(diffs, diffBlocks(file.Blocks, got.Blocks)...)
+	if len(diffs) == 0 {
+		_, err = decodeChainFile(data)
+	}
+	return diffs, err
+}
+
+// roundTripNDJSON writes blocks as ExportNDJSON does and compares them
+// with what reads back
+func roundTripNDJSON(blocks []*Block, codec string) ([]string, error) {
+	var buf bytes.Buffer
+	err := writeThroughCodec(&buf, codec, func(w io.Writer) error {
+		return writeNDJSON(w, blocks, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	got, err := readThroughCodec(&buf, codec, func(r io.Reader) ([]*Block, error) {
+		var got []*Block
+		err := readNDJSON(r, func(_ int, block *Block) error {
+			got = append(got, block)
+			return nil
+		})
+		return got, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diffBlocks(blocks, got), nil
+}
+
+// roundTripBlockLog writes blocks and the state of chain to a block log
+// in a temporary directory and compares them with what reads back. A log
+// that comes back unchanged must recover as well.
+func roundTripBlockLog(chain *Blockchain, blocks []*Block) ([]string, error) {
+	dir, err := os.MkdirTemp("", "block_data_save-roundtrip-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chain.log")
+
+	chain.mu.RLock()
+	state := chain.state()
+	chain.mu.RUnlock()
+	l, err := openBlockLog(path, state, blocks)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.file.Close(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	gotState, got, _, err := readBlockLog(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	var diffs []string
+	diffValue("Zustand", reflect.ValueOf(state), reflect.ValueOf(gotState), &diffs)
+	diffs = append(diffs, diffBlocks(blocks, got)...)
+	if len(diffs) == 0 {
+		recovered, err := RecoverFromLog(path)
+		if err != nil {
+			return nil, err
+		}
+		return nil, recovered.Close()
+	}
+	return diffs, nil
+}
+
+// roundTripSQLite stores blocks in a new database in a temporary
+// directory and compares them with what reads back after it is opened
+// again
+func roundTripSQLite(blocks []*Block) ([]string, error) {
+	dir, err := os.MkdirTemp("", "block_data_save-roundtrip-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chain.db")
+
+	storage, err := OpenSQLiteStorage(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		if err := storage.AppendBlock(block); err != nil {
+			storage.Close()
+			return nil, err
+		}
+	}
+	if err := storage.Close(); err != nil {
+		return nil, err
+	}
+	if storage, err = OpenSQLiteStorage(path); err != nil {
+		return nil, err
+	}
+	defer storage.Close()
+	return diffStorage(storage, blocks)
+}
+
+// diffStorage compares the blocks storage holds with blocks, read through
+// each of its methods
+func diffStorage(storage Storage, blocks []*Block) ([]string, error) {
+	var diffs []string
+	if n := storage.Count(); n != len(blocks) {
+		diffs = append(diffs, diffLine("Count", strconv.Itoa(len(blocks)), strconv.Itoa(n)))
+	}
+	var iterated []*Block
+	err := storage.Iterate(blocks[0].Index, blocks[len(blocks)-1].Index+1, func(block *Block) error {
+		iterated = append(iterated, block)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	diffs = append(diffs, diffBlocks(blocks, iterated)...)
+	for _, want := range blocks {
+		got, err := storage.GetBlock(want.Index)
+		if err != nil {
+			return nil, err
+		}
+		diffValue("GetBlock("+strconv.Itoa(want.Index)+")", reflect.ValueOf(want), reflect.ValueOf(got), &diffs)
+	}
+	latest, err := storage.LatestBlock()
+	if err != nil {
+		return nil, err
+	}
+	diffValue("LatestBlock", reflect.ValueOf(blocks[len(blocks)-1]), reflect.ValueOf(latest), &diffs)
+	return diffs, nil
+}
+
+// writeThroughCodec calls write with w, compressed with codec if set
+func writeThroughCodec(w io.Writer, codec string, write func(io.Writer) error) error {
+	if codec == "" {
+		return write(w)
+	}
+	cw, err := NewCodecWriter(w, codec)
+	if err != nil {
+		return err
+	}
+	if err := write(cw); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// readThroughCodec calls read with r, decompressed with codec if set
+func readThroughCodec[T any](r io.Reader, codec string, read func(io.Reader) (T, error)) (T, error) {
+	if codec == "" {
+		return read(r)
+	}
+	cr, err := NewCodecReader(r)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	defer cr.Close()
+	return read(cr)
+}
+
+// diffBlocks compares the blocks that came back from a format with the
+// blocks written, in order
+func diffBlocks(want, got []*Block) []string {
+	var diffs []string
+	if len(want) != len(got) {
+		diffs = append(diffs, diffLine("Anzahl der Blöcke", strconv.Itoa(len(want)), strconv.Itoa(len(got))))
+	}
+	for i := range min(len(want), len(got)) {
+		diffValue("Block "+strconv.Itoa(want[i].Index), reflect.ValueOf(want[i]), reflect.ValueOf(got[i]), &diffs)
+	}
+	return diffs
+}
+
+// diffValue appends the differences between want and got, of the same
+// type, at path to diffs
+func diffValue(path string, want, got reflect.Value, diffs *[]string) {
+	differ := func() {
+		*diffs = append(*diffs, diffLine(path, formatDiffValue(want), formatDiffValue(got)))
+	}
+	switch want.Kind() {
+	case reflect.Float32, reflect.Float64:
+		w, g := want.Float(), got.Float()
+		if !(math.IsNaN(w) && math.IsNaN(g)) && math.Float64bits(w) != math.Float64bits(g) {
+			differ()
+		}
+	case reflect.Pointer, reflect.Interface:
+		if want.IsNil() || got.IsNil() {
+			if want.IsNil() != got.IsNil() {
+				differ()
+			}
+			return
+		}
+		if want.Elem().Type() != got.Elem().Type() {
+			differ()
+			return
+		}
+		diffValue(path, want.Elem(), got.Elem(), diffs)
+	case reflect.Slice, reflect.Array:
+		if want.Len() != got.Len() {
+			*diffs = append(*diffs, diffLine(path+" Länge", strconv.Itoa(want.Len()), strconv.Itoa(got.Len())))
+			return
+		}
+		for i := 0; i < want.Len(); i++ {
+			diffValue(path+"["+strconv.Itoa(i)+"]", want.Index(i), got.Index(i), diffs)
+		}
+	case reflect.Map:
+		keys := want.MapKeys()
+		for _, key := range got.MapKeys() {
+			if !want.MapIndex(key).IsValid() {
+				keys = append(keys, key)
+			}
+		}
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(fmt.Sprint(a), fmt.Sprint(b)) })
+		for _, key := range keys {
+			diffValue(fmt.Sprintf("%s[%v]", path, key), want.MapIndex(key), got.MapIndex(key), diffs)
+		}
+	case reflect.Struct:
+		if t, ok := want.Interface().(time.Time); ok {
+			if !t.Equal(got.Interface().(time.Time)) {
+				differ()
+			}
+			return
+		}
+		for i := 0; i < want.NumField(); i++ {
+			if field := want.Type().Field(i); field.IsExported() {
+				diffValue(path+"."+field.Name, want.Field(i), got.Field(i), diffs)
+			}
+		}
+	case reflect.Invalid:
+		// a map key on one side only
+		if got.IsValid() {
+			differ()
+		}
+	default:
+		if !got.IsValid() || !want.Equal(got) {
+			differ()
+		}
+	}
+}
+
+// diffLine renders a difference at path in diff form
+func diffLine(path, want, got string) string {
+	return path + ":\n\t- " + want + "\n\t+ " + got
+}
+
+// formatDiffValue renders a value of a difference
+func formatDiffValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return "(fehlt)"
+	}
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return "nil"
+		}
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%+v", v.Interface())
+}
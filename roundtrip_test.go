@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "write the golden files in testdata again")
+
+// goldenDir holds a chain in every format the program reads and writes,
+// see goldenChain
+const goldenDir = "testdata/formats"
+
+// goldenChain returns the chain the fixtures in goldenDir hold: blocks with
+// metadata and text, one stored delta encoded and one float32 encoded
+// with an outlier
+func goldenChain(t *testing.T) *Blockchain {
+	t.Helper()
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	r := rand.New(rand.NewSource(232))
+	id, err := newBlockID(IDSchemeULID, start, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := newBlockchainAt(ChainConfig{Name: "golden", Description: "Formate"}, start, id)
+	clock := &replayClock{now: start}
+	bc.SetClock(clock)
+
+	payloads := []blockPayload{
+		{values: []float64{20.5, 21.25, 19.75}, text: "Messung 1", metadata: map[string]string{"raum": "Labor", "sensor": "t-1"}},
+		{values: []float64{-3, 0, 1e-9, 123456.789}, text: "Messung 2\nmit Umbruch"},
+		{values: []float64{1.5, 2.25, 3.125, 4}, text: "Messung 3"},
+		{values: []float64{10, 10.5, 9.5, 10.25, 9.75, 10, 10.5, 9.5, 10.25, 55}, text: "Messung 4"},
+	}
+	encodings := []ValueEncoding{EncodingNone, EncodingNone, EncodingDelta, EncodingFloat32}
+	for i, payload := range payloads {
+		clock.now = start.Add(time.Duration(i+1) * time.Minute)
+		if payload.id, err = newBlockID(IDSchemeULID, clock.now, r); err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.SetValueEncoding(encodings[i]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bc.addBlock(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bc.LatestBlock().HasOutliers {
+		t.Fatal("the last block of the golden chain has no outlier")
+	}
+	return bc
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// writeGoldenFixtures writes the fixtures of goldenDir from bc
+func writeGoldenFixtures(t *testing.T, bc *Blockchain) {
+	t.Helper()
+	if err := os.MkdirAll(goldenDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := bc.chainFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ndjson bytes.Buffer
+	if err := bc.ExportNDJSON(&ndjson, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"chain.json":    mustMarshal(t, file),
+		"blocks.ndjson": ndjson.Bytes(),
+	} {
+		if err := os.WriteFile(filepath.Join(goldenDir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bc.mu.RLock()
+	state := bc.state()
+	bc.mu.RUnlock()
+	l, err := openBlockLog(filepath.Join(goldenDir, "blocklog.log"), state, file.Blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.file.Close()
+}
+
+// expectNoDiffs fails with the differences found, if any
+func expectNoDiffs(t *testing.T, name string, diffs []string) {
+	t.Helper()
+	if len(diffs) > 0 {
+		t.Fatalf("%s differs from the golden chain:\n%s", name, strings.Join(diffs, "\n"))
+	}
+}
+
+// recoverGoldenLog restores the chain of a log fixture from a copy, as
+// RecoverFromLog keeps appending to the log it reads
+func recoverGoldenLog(t *testing.T, name string) *Blockchain {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(goldenDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	bc, err := RecoverFromLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.Close() })
+	return bc
+}
+
+func TestGoldenFixtures(t *testing.T) {
+	golden := goldenChain(t)
+	if *updateGolden {
+		writeGoldenFixtures(t, golden)
+	}
+	want, err := golden.chainFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden.mu.RLock()
+	wantState := golden.state()
+	golden.mu.RUnlock()
+
+	t.Run("chain.json", func(t *testing.T) {
+		bc, err := LoadBlockchainFromFile(filepath.Join(goldenDir, "chain.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := bc.chainFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var diffs []string
+		diffValue("Blockchain", reflect.ValueOf(*want), reflect.ValueOf(*got), &diffs)
+		expectNoDiffs(t, "chain.json", diffs)
+	})
+	t.Run("blocks.ndjson", func(t *testing.T) {
+		file, err := os.Open(filepath.Join(goldenDir, "blocks.ndjson"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		var got []*Block
+		err = readNDJSON(file, func(_ int, block *Block) error {
+			got = append(got, block)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectNoDiffs(t, "blocks.ndjson", diffBlocks(want.Blocks, got))
+	})
+	t.Run("blocklog.log", func(t *testing.T) {
+		bc := recoverGoldenLog(t, "blocklog.log")
+		bc.mu.RLock()
+		got := bc.state()
+		bc.mu.RUnlock()
+		var diffs []string
+		diffValue("Zustand", reflect.ValueOf(wantState), reflect.ValueOf(got), &diffs)
+		expectNoDiffs(t, "blocklog.log", append(diffs, diffBlocks(want.Blocks, bc.Blocks())...))
+	})
+}
+
+func TestVerifyFormatRoundTrip(t *testing.T) {
+	demo, err := LoadDemoChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	formats := RoundTripFormats()
+	for _, want := range []string{"json", "ndjson", "blocklog", "sqlite", "json+gzip", "ndjson+zstd"} {
+		if !slices.Contains(formats, want) {
+			t.Fatalf("RoundTripFormats returned %v, missing %s", formats, want)
+		}
+	}
+	for name, bc := range map[string]*Blockchain{"golden": goldenChain(t), "demo": demo} {
+		for _, format := range formats {
+			t.Run(name+"/"+format, func(t *testing.T) {
+				if err := VerifyFormatRoundTrip(format, bc); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+
+	for _, format := range []string{"xml", "blocklog+gzip", "sqlite+zstd", "json+unbekannt", ""} {
+		if err := VerifyFormatRoundTrip(format, demo); !errors.Is(err, ErrUnknownFormat) {
+			t.Fatalf("VerifyFormatRoundTrip(%q) returned %v, want ErrUnknownFormat", format, err)
+		}
+	}
+}
+
+// typoCodec is gzip that adds an exclamation mark after every "Messung"
+// it reads back
+type typoCodec struct {
+	gzipCodec
+}
+
+func (typoCodec) Name() string { return "tippfehler" }
+
+func (c typoCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := c.gzipCodec.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(bytes.ReplaceAll(data, []byte("Messung"), []byte("Messung!")))), nil
+}
+
+// registerTypoCodec registers typoCodec for the rest of the test
+func registerTypoCodec(t *testing.T) {
+	t.Helper()
+	RegisterCodec(typoCodec{})
+	t.Cleanup(func() {
+		codecsMu.Lock()
+		defer codecsMu.Unlock()
+		delete(codecs, typoCodec{}.Name())
+	})
+}
+
+func TestVerifyFormatRoundTripReportsDiff(t *testing.T) {
+	registerTypoCodec(t)
+	bc := goldenChain(t)
+	for _, format := range []string{"json+tippfehler", "ndjson+tippfehler"} {
+		t.Run(format, func(t *testing.T) {
+			err := VerifyFormatRoundTrip(format, bc)
+			if !errors.Is(err, ErrFormatRoundTrip) {
+				t.Fatalf("VerifyFormatRoundTrip returned %v, want ErrFormatRoundTrip", err)
+			}
+			for _, line := range []string{"Block 1.Text:", `- "Messung 1"`, `+ "Messung! 1"`, "4 Abweichungen"} {
+				if !strings.Contains(err.Error(), line) {
+					t.Fatalf("error does not contain %q:\n%v", line, err)
+				}
+			}
+		})
+	}
+
+	demo, err := LoadDemoChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyFormatRoundTrip("ndjson+tippfehler", demo)
+	more := fmt.Sprintf("… und %d weitere", demoBlocks-maxRoundTripDiffs)
+	if !errors.Is(err, ErrFormatRoundTrip) || !strings.Contains(err.Error(), more) || strings.Count(err.Error(), "\t- ") != maxRoundTripDiffs {
+		t.Fatalf("error for %d changed blocks lists other than the first %d: %v", demoBlocks, maxRoundTripDiffs, err)
+	}
+}
+
+// roundingStorage is a Storage that rounds the means of the blocks it
+// returns from GetBlock
+type roundingStorage struct {
+	Storage
+}
+
+func (s roundingStorage) GetBlock(index int) (*Block, error) {
+	block, err := s.Storage.GetBlock(index)
+	if err == nil {
+		block.Mean = math.Round(block.Mean)
+	}
+	return block, err
+}
+
+func TestVerifyStorageRoundTrip(t *testing.T) {
+	bc := goldenChain(t)
+	if err := VerifyStorageRoundTrip(NewMemoryStorage(), bc); err != nil {
+		t.Fatal(err)
+	}
+
+	err := VerifyStorageRoundTrip(roundingStorage{NewMemoryStorage()}, bc)
+	if !errors.Is(err, ErrFormatRoundTrip) || !strings.Contains(err.Error(), "GetBlock(1).Mean:\n\t- 20.5\n\t+ 21") {
+		t.Fatalf("VerifyStorageRoundTrip of a storage rounding means returned %v, want the diff of block 1", err)
+	}
+
+	full := NewMemoryStorage()
+	full.AppendBlock(bc.LatestBlock())
+	if err := VerifyStorageRoundTrip(full, bc); err == nil || errors.Is(err, ErrFormatRoundTrip) {
+		t.Fatalf("VerifyStorageRoundTrip of a storage holding blocks returned %v, want it refused", err)
+	}
+}
+
+func TestDiffValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		want, got any
+		diffs     int
+	}{
+		{"NaN equals NaN", []float64{1, math.NaN()}, []float64{1, math.NaN()}, 0},
+		{"negative zero differs", []float64{0}, []float64{math.Copysign(0, -1)}, 1},
+		{"empty and nil slices", Block{Values: []float64{}}, Block{}, 0},
+		{"nil and empty maps", Block{}, Block{Metadata: map[string]string{}}, 0},
+		{"instants in other zones", Block{Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}, Block{Timestamp: time.Date(2024, 1, 1, 13, 0, 0, 0, time.FixedZone("MEZ", 3600))}, 0},
+		{"map keys on one side", map[string]string{"a": "1", "b": "2"}, map[string]string{"b": "2", "c": "3"}, 2},
+		{"pointer to nil", &Block{}, (*Block)(nil), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var diffs []string
+			diffValue("v", reflect.ValueOf(tt.want), reflect.ValueOf(tt.got), &diffs)
+			if len(diffs) != tt.diffs {
+				t.Fatalf("found %d differences, want %d: %v", len(diffs), tt.diffs, diffs)
+			}
+		})
+	}
+}
+
+func TestAPIFormats(t *testing.T) {
+	registerTypoCodec(t)
+	bc := goldenChain(t)
+	handler := NewAPIHandler(bc, NewDefaultPipeline(bc))
+
+	var list struct {
+		Formats []string `json:"formats"`
+	}
+	decodeResponse(t, serve(handler, "GET", "/formats", ""), http.StatusOK, &list)
+	if !slices.Equal(list.Formats, RoundTripFormats()) {
+		t.Fatalf("GET /formats lists %v, want %v", list.Formats, RoundTripFormats())
+	}
+
+	var check formatCheck
+	decodeResponse(t, serve(handler, "GET", "/formats/json+zstd/verify", ""), http.StatusOK, &check)
+	if check.Format != "json+zstd" || !check.OK {
+		t.Fatalf("round trip is %+v, want json+zstd passed", check)
+	}
+	expectAPIError(t, serve(handler, "GET", "/formats/xml/verify", ""), http.StatusNotFound)
+	expectAPIError(t, serve(handler, "GET", "/formats/ndjson+tippfehler/verify", ""), http.StatusUnprocessableEntity)
+}
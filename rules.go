@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Block statuses assigned by the rule engine. StatusDegraded is assigned
+// instead when a stats computation failed.
+const (
+	StatusOK       = "OK"
+	StatusWarn     = "WARN"
+	StatusCritical = "CRITICAL"
+	StatusDegraded = "DEGRADED"
+)
+
+// ruleFields maps the field names usable in rule conditions to their value
+var ruleFields = map[string]func(*Block) float64{
+	"values":   func(b *Block) float64 { return float64(b.ValueCount()) },
+	"outliers": func(b *Block) float64 { return float64(b.OutlierCount()) },
+	"outlier_fraction": func(b *Block) float64 {
+		if b.ValueCount() == 0 {
+			return 0
+		}
+		return float64(b.OutlierCount()) / float64(b.ValueCount())
+	},
+	"mean":              func(b *Block) float64 { return b.Mean },
+	"median":            func(b *Block) float64 { return b.Median },
+	"lower":             func(b *Block) float64 { lower, _ := b.OutlierBounds(); return lower },
+	"upper":             func(b *Block) float64 { _, upper := b.OutlierBounds(); return upper },
+	"bounds_violations": func(b *Block) float64 { return metadataNumber(b, "bounds_violations") },
+	"quality": func(b *Block) float64 {
+		if b.Quality == nil {
+			return 100
+		}
+		return b.Quality.Score
+	},
+}
+
+// Condition compares a block field against a constant
+type Condition struct {
+	Field string  `json:"field"`
+	Op    string  `json:"op"`
+	Value float64 `json:"value"`
+}
+
+// Rule assigns Status to a block when all of its conditions hold
+type Rule struct {
+	Name       string      `json:"name"`
+	Conditions []Condition `json:"conditions"`
+	Status     string      `json:"status"`
+}
+
+// Validate checks field names, operators and the status of the rule
+func (r Rule) Validate() error {
+	switch r.Status {
+	case StatusOK, StatusWarn, StatusCritical:
+	default:
+		return fmt.Errorf("Regel %q: ungültiger Status %q", r.Name, r.Status)
+	}
+	for _, c := range r.Conditions {
+		if _, ok := ruleFields[c.Field]; !ok {
+			return fmt.Errorf("Regel %q: unbekanntes Feld %q (erlaubt: %s)", r.Name, c.Field, strings.Join(RuleFields(), ", "))
+		}
+		switch c.Op {
+		case "<", "<=", ">", ">=", "==", "!=":
+		default:
+			return fmt.Errorf("Regel %q: unbekannter Operator %q", r.Name, c.Op)
+		}
+	}
+	return nil
+}
+
+func (c Condition) matches(block *Block) bool {
+	v := ruleFields[c.Field](block)
+	switch c.Op {
+	case "<":
+		return v < c.Value
+	case "<=":
+		return v <= c.Value
+	case ">":
+		return v > c.Value
+	case ">=":
+		return v >= c.Value
+	case "==":
+		return v == c.Value
+	default:
+		return v != c.Value
+	}
+}
+
+func (r Rule) matches(block *Block) bool {
+	for _, c := range r.Conditions {
+		if !c.matches(block) {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluateRules returns the status of the first matching rule, or OK
+func evaluateRules(rules []Rule, block *Block) string {
+	for _, rule := range rules {
+		if rule.matches(block) {
+			return rule.Status
+		}
+	}
+	return StatusOK
+}
+
+// LoadRules reads a JSON list of rules from path and validates them
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("Regeldatei %s: %w", path, err)
+	}
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// SetRules replaces the ordered rules that label new blocks
+func (bc *Blockchain) SetRules(rules []Rule) error {
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.rules = append([]Rule(nil), rules...)
+	return nil
+}
+
+// BlocksWithStatus returns copies of the blocks labeled with status
+func (bc *Blockchain) BlocksWithStatus(status string) []*Block {
+	var blocks []*Block
+	for _, block := range bc.snapshot() {
+		if block.Status == status {
+			blocks = append(blocks, copyBlock(block))
+		}
+	}
+	return blocks
+}
+
+// StatusCounts returns how many blocks carry each status
+func (bc *Blockchain) StatusCounts() map[string]int {
+	counts := map[string]int{}
+	for _, block := range bc.snapshot() {
+		counts[block.Status]++
+	}
+	return counts
+}
+
+// statusColor wraps status in the ANSI color used for terminal listings
+func statusColor(status string) string {
+	switch status {
+	case StatusOK:
+		return "\033[32m" + status + "\033[0m"
+	case StatusWarn:
+		return "\033[33m" + status + "\033[0m"
+	case StatusCritical:
+		return "\033[31m" + status + "\033[0m"
+	case StatusDegraded:
+		return "\033[35m" + status + "\033[0m"
+	default:
+		return status
+	}
+}
+
+// metadataNumber returns a numeric metadata value of block, or 0
+func metadataNumber(block *Block, key string) float64 {
+	v, err := strconv.ParseFloat(block.Metadata[key], 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// RuleFields returns the field names usable in rule conditions
+func RuleFields() []string {
+	fields := make([]string, 0, len(ruleFields))
+	for field := range ruleFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
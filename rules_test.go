@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRules mark blocks with an outlier critical if their mean is over
+// 50, and blocks with an outlier or fewer than 4 values a warning
+var testRules = []Rule{
+	{Name: "hoch", Status: StatusCritical, Conditions: []Condition{{Field: "outliers", Op: ">=", Value: 1}, {Field: "mean", Op: ">", Value: 50}}},
+	{Name: "ausreisser", Status: StatusWarn, Conditions: []Condition{{Field: "outliers", Op: ">", Value: 0}}},
+	{Name: "klein", Status: StatusWarn, Conditions: []Condition{{Field: "values", Op: "<", Value: 4}}},
+}
+
+func TestRulesLabelBlocks(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetRules(testRules); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		values []float64
+		status string
+	}{
+		{"no rule fires", []float64{1, 2, 3, 4, 5}, StatusOK},
+		{"first rule wins", []float64{100, 100, 100, 100, 100, 100, 100, 100, 100, 1000}, StatusCritical},
+		{"all conditions needed", []float64{1, 1, 1, 1, 1, 1, 1, 1, 1, 50}, StatusWarn},
+		{"later rule fires", []float64{1, 2, 3}, StatusWarn},
+	}
+	for _, tt := range tests {
+		block, err := bc.AddBlock(tt.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Status != tt.status {
+			t.Fatalf("%s: status is %q, want %q", tt.name, block.Status, tt.status)
+		}
+		// the status is recorded on the block the chain holds
+		stored, err := bc.BlockByIndex(block.Index)
+		if err != nil || stored.Status != tt.status {
+			t.Fatalf("%s: chain holds block %d with status %q, %v", tt.name, block.Index, stored.Status, err)
+		}
+		if len(stored.StatsErrors) != 0 {
+			t.Fatalf("%s: StatsErrors are %v", tt.name, stored.StatsErrors)
+		}
+	}
+
+	counts := bc.StatusCounts()
+	if counts[StatusOK] != 2 || counts[StatusWarn] != 2 || counts[StatusCritical] != 1 {
+		t.Fatalf("status counts are %v, want 2 OK including genesis, 2 WARN and 1 CRITICAL", counts)
+	}
+	if critical := bc.BlocksWithStatus(StatusCritical); len(critical) != 1 || critical[0].Index != 2 {
+		t.Fatalf("critical blocks are %v, want block 2", critical)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailingRuleRecordedInStatsErrors(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	panickingRule(t, bc)
+	block, err := bc.AddBlock([]float64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := bc.BlockByIndex(block.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != StatusDegraded || !strings.Contains(stored.StatsErrors[StageRules], "Feld nicht verfügbar") {
+		t.Fatalf("block has status %q and StatsErrors %v, want the rule failure", stored.Status, stored.StatsErrors)
+	}
+
+	// the next block is labeled by working rules again
+	if err := bc.SetRules(testRules); err != nil {
+		t.Fatal(err)
+	}
+	if block, err = bc.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if block.Status != StatusWarn || block.StatsErrors != nil {
+		t.Fatalf("block after the fix has status %q and StatsErrors %v", block.Status, block.StatsErrors)
+	}
+}
+
+func TestInvalidRulesRejected(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		want string
+	}{
+		{Rule{Name: "a", Status: "BAD"}, "ungültiger Status"},
+		{Rule{Name: "b", Status: StatusWarn, Conditions: []Condition{{Field: "temperatur", Op: ">", Value: 1}}}, "unbekanntes Feld"},
+		{Rule{Name: "c", Status: StatusWarn, Conditions: []Condition{{Field: "mean", Op: "=>", Value: 1}}}, "unbekannter Operator"},
+		{Rule{Name: "d", Status: StatusDegraded}, "ungültiger Status"},
+	}
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if err := bc.SetRules([]Rule{tt.rule}); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("rule %s: error is %v, want %q", tt.rule.Name, err, tt.want)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`[{"name": "x", "status": "WARN", "conditions": [{"field": "mean", "op": "~", "value": 1}]}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRules(path); err == nil {
+		t.Fatal("LoadRules accepted an unknown operator")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"time"
+)
+
+// SetSampleSize enables reservoir sampling: float blocks with more than k
+// values keep exact stats but store only a uniform random sample of k
+// values, plus their exact outliers, minimum and maximum. 0 disables it.
+func (bc *Blockchain) SetSampleSize(k int) error {
+	if k < 0 {
+		return fmt.Errorf("Ungültige Stichprobengröße: %d", k)
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.sampleSize = k
+	return nil
+}
+
+// sampleBlock replaces the values of block with a reservoir sample of k
+// values once its stats have been computed. Called with bc.mu held.
+func sampleBlock(block *Block, k int) {
+	n := len(block.Values)
+	if k <= 0 || n <= k {
+		return
+	}
+
+	block.Sampled = true
+	block.OriginalCount = n
+	block.SampleMin = slices.Min(block.Values)
+	block.SampleMax = slices.Max(block.Values)
+
+	// algorithm R over positions, so origins stay parallel to values
+	picked := make([]int, k)
+	for i := range picked {
+		picked[i] = i
+	}
+	for i := k; i < n; i++ {
+		if j := rand.Intn(i + 1); j < k {
+			picked[j] = i
+		}
+	}
+	sort.Ints(picked)
+
+	values := make([]float64, k)
+	for i, pos := range picked {
+		values[i] = block.Values[pos]
+	}
+	block.Values = values
+
+	if block.ValueOrigins != nil {
+		origins := make([]Origin, k)
+		for i, pos := range picked {
+			origins[i] = block.ValueOrigins[pos]
+		}
+		block.ValueOrigins = origins
+	}
+	if block.ValueTimes != nil {
+		times := make([]time.Time, k)
+		for i, pos := range picked {
+			times[i] = block.ValueTimes[pos]
+		}
+		block.ValueTimes = times
+	}
+}
+
+// Percentile returns the p-th percentile (0-100) of the block's stored
+// values using linear interpolation between closest ranks. For sampled
+// blocks the result is estimated from the reservoir; with a sample of k
+// values the rank of the estimate has a standard error of about
+// sqrt(p/100*(1-p/100)/k) of the original count, see PercentileErrorBound.
+func (b *Block) Percentile(p float64) (float64, error) {
+	if p < 0 || p > 100 {
+		return 0, fmt.Errorf("Ungültiges Perzentil: %g", p)
+	}
+	values := b.GetValues()
+	if len(values) == 0 {
+		return 0, fmt.Errorf("Block %d enthält keine Werte", b.Index)
+	}
+
+	sorted := slices.Clone(values)
+	sort.Float64s(sorted)
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo)), nil
+}
+
+// PercentileErrorBound returns the standard error, as a fraction of the
+// original value count, of the rank of a percentile estimated from a
+// sample of k values. It is 0 for blocks that are not sampled.
+func (b *Block) PercentileErrorBound(p float64) float64 {
+	if !b.Sampled {
+		return 0
+	}
+	q := p / 100
+	return math.Sqrt(q * (1 - q) / float64(b.storedCount()))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestSampleBlock(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetSampleSize(10); err != nil {
+		t.Fatal(err)
+	}
+	values := make([]float64, 1000)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	values[500] = 1e6
+	block, err := bc.AddBlock(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !block.Sampled || block.OriginalCount != 1000 || len(block.Values) != 10 {
+		t.Fatalf("block keeps %d of %d values, sampled %v, want 10 of 1000", len(block.Values), block.OriginalCount, block.Sampled)
+	}
+	if block.SampleMin != 0 || block.SampleMax != 1e6 {
+		t.Fatalf("sample keeps min %v and max %v, want 0 and 1e6", block.SampleMin, block.SampleMax)
+	}
+	// the sample is a subset in the original order
+	if !slices.IsSorted(slices.DeleteFunc(slices.Clone(block.Values), func(v float64) bool { return v == 1e6 })) {
+		t.Fatalf("sample %v is out of order", block.Values)
+	}
+	// stats and outliers stay exact
+	mean := (999*1000/2 - 500 + 1e6) / 1000.0
+	if !near(block.Mean, mean) || !slices.Contains(block.Outliers, 1e6) {
+		t.Fatalf("mean is %v with outliers %v, want %v and the exact outlier", block.Mean, block.Outliers, mean)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if bound := block.PercentileErrorBound(50); !near(bound, math.Sqrt(0.25/10)) {
+		t.Fatalf("error bound of the median is %v", bound)
+	}
+
+	// blocks up to the sample size are stored as they are
+	small, err := bc.AddBlock([]float64{3, 1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small.Sampled || !slices.Equal(small.Values, []float64{3, 1, 2}) || small.PercentileErrorBound(50) != 0 {
+		t.Fatalf("small block is %+v", small)
+	}
+	if err := bc.SetSampleSize(-1); err == nil {
+		t.Fatal("negative sample size accepted")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	block := &Block{Values: []float64{4, 1, 3, 2}}
+	for _, tt := range []struct {
+		p, want float64
+	}{
+		{0, 1},
+		{50, 2.5},
+		{100, 4},
+		{25, 1.75},
+	} {
+		if got, err := block.Percentile(tt.p); err != nil || !near(got, tt.want) {
+			t.Fatalf("percentile %v is %v, %v, want %v", tt.p, got, err, tt.want)
+		}
+	}
+	if _, err := block.Percentile(101); err == nil {
+		t.Fatal("percentile 101 accepted")
+	}
+	if _, err := (&Block{}).Percentile(50); err == nil {
+		t.Fatal("percentile of an empty block accepted")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrSchedulerClosed is returned for appends after AppendScheduler.Close
+var ErrSchedulerClosed = errors.New("Anhängen wurde beendet")
+
+// Lane is the priority class of an append
+type Lane int
+
+const (
+	// LaneBulk is for imports and the generator
+	LaneBulk Lane = iota
+	// LaneInteractive is for operator actions, which are served first
+	LaneInteractive
+)
+
+func (l Lane) String() string {
+	if l == LaneInteractive {
+		return "interactive"
+	}
+	return "bulk"
+}
+
+// interactiveBurst is the number of interactive appends served in a row
+// before one waiting bulk append gets its turn
+const interactiveBurst = 8
+
+// LaneMetrics describes one lane of an AppendScheduler
+type LaneMetrics struct {
+	Lane     Lane
+	Depth    int
+	Appended int
+	MaxWait  time.Duration
+}
+
+// appendRequest is a batch waiting in a lane
+type appendRequest struct {
+	batch  *Batch
+	queued time.Time
+	done   chan error
+}
+
+// AppendScheduler is the append stage of a pipeline with two priority
+// lanes. A single worker appends one batch at a time, preferring the
+// interactive lane, so an interactive append waits for at most the bulk
+// append in progress and the interactive ones queued before it. Indexes
+// are assigned in the order the worker appends; each lane stays FIFO.
+type AppendScheduler struct {
+	chain *Blockchain
+
+	mu      sync.Mutex
+	wake    *sync.Cond
+	lanes   [2][]appendRequest
+	metrics [2]LaneMetrics
+	streak  int
+	closed  bool
+}
+
+// NewAppendScheduler creates a scheduler appending to bc and starts its
+// worker; Close stops it
+func NewAppendScheduler(bc *Blockchain) *AppendScheduler {
+	s := &AppendScheduler{chain: bc}
+	s.wake = sync.NewCond(&s.mu)
+	s.metrics[LaneBulk].Lane = LaneBulk
+	s.metrics[LaneInteractive].Lane = LaneInteractive
+	go s.run()
+	return s
+}
+
+func (*AppendScheduler) Name() string { return "append" }
+
+// Process queues batch in its lane and waits until it was appended
+func (s *AppendScheduler) Process(batch *Batch) error {
+	lane := batch.Lane
+	if lane != LaneInteractive {
+		lane = LaneBulk
+	}
+	req := appendRequest{batch: batch, queued: time.Now(), done: make(chan error, 1)}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrSchedulerClosed
+	}
+	s.lanes[lane] = append(s.lanes[lane], req)
+	s.wake.Signal()
+	s.mu.Unlock()
+
+	return <-req.done
+}
+
+// Metrics returns the depth and counters of both lanes
+func (s *AppendScheduler) Metrics() []LaneMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	metrics := s.metrics
+	for lane := range metrics {
+		metrics[lane].Depth = len(s.lanes[lane])
+	}
+	return metrics[:]
+}
+
+// Close stops the worker; queued batches are rejected with
+// ErrSchedulerClosed
+func (s *AppendScheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.wake.Broadcast()
+}
+
+func (s *AppendScheduler) run() {
+	for {
+		s.mu.Lock()
+		for !s.closed && len(s.lanes[LaneBulk])+len(s.lanes[LaneInteractive]) == 0 {
+			s.wake.Wait()
+		}
+		if s.closed {
+			pending := append(s.lanes[LaneInteractive], s.lanes[LaneBulk]...)
+			s.lanes = [2][]appendRequest{}
+			s.mu.Unlock()
+			for _, req := range pending {
+				req.done <- ErrSchedulerClosed
+			}
+			return
+		}
+		lane := s.next()
+		req := s.lanes[lane][0]
+		s.lanes[lane] = s.lanes[lane][1:]
+		wait := time.Since(req.queued)
+		s.metrics[lane].MaxWait = max(s.metrics[lane].MaxWait, wait)
+		s.mu.Unlock()
+
+		err := appendBatch(s.chain, req.batch)
+		if err == nil {
+			s.mu.Lock()
+			s.metrics[lane].Appended++
+			s.mu.Unlock()
+		}
+		req.done <- err
+	}
+}
+
+// next picks the lane to serve. Called with s.mu held and at least one
+// request queued.
+func (s *AppendScheduler) next() Lane {
+	interactive, bulk := len(s.lanes[LaneInteractive]) > 0, len(s.lanes[LaneBulk]) > 0
+	if interactive && (!bulk || s.streak < interactiveBurst) {
+		s.streak++
+		return LaneInteractive
+	}
+	s.streak = 0
+	return LaneBulk
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// waitForDepth waits until lane of s holds depth batches
+func waitForDepth(t *testing.T, s *AppendScheduler, lane Lane, depth int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Metrics()[lane].Depth != depth {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s lane holds %d batches, want %d", lane, s.Metrics()[lane].Depth, depth)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// enqueue puts a batch with text in lane of s as Process does, but
+// returns at once with the channel its result is sent on
+func enqueue(s *AppendScheduler, lane Lane, text string) chan error {
+	req := appendRequest{batch: &Batch{Values: []float64{1, 2}, Text: text, Lane: lane}, queued: time.Now(), done: make(chan error, 1)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lanes[lane] = append(s.lanes[lane], req)
+	s.wake.Signal()
+	return req.done
+}
+
+func TestAppendSchedulerLanes(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewAppendScheduler(bc)
+	defer s.Close()
+
+	// the worker is held in the append of b0 while both lanes fill up
+	bc.mu.Lock()
+	results := []chan error{enqueue(s, LaneBulk, "b0")}
+	waitForDepth(t, s, LaneBulk, 0)
+	var interactive, bulk []string
+	for i := range 2*interactiveBurst + 4 {
+		interactive = append(interactive, fmt.Sprintf("i%d", i))
+		results = append(results, enqueue(s, LaneInteractive, interactive[i]))
+	}
+	for i := 1; i <= 3; i++ {
+		bulk = append(bulk, fmt.Sprintf("b%d", i))
+		results = append(results, enqueue(s, LaneBulk, bulk[i-1]))
+	}
+	bc.mu.Unlock()
+	for _, result := range results {
+		if err := <-result; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a bulk batch gets its turn after every interactiveBurst interactive
+	// ones, and each lane keeps its order
+	want := []string{"b0"}
+	want = append(want, interactive[:interactiveBurst]...)
+	want = append(want, bulk[0])
+	want = append(want, interactive[interactiveBurst:2*interactiveBurst]...)
+	want = append(want, bulk[1])
+	want = append(want, interactive[2*interactiveBurst:]...)
+	want = append(want, bulk[2])
+	var got []string
+	for _, block := range bc.Blocks()[1:] {
+		got = append(got, block.Text)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("blocks were appended as %v, want %v", got, want)
+	}
+
+	metrics := s.Metrics()
+	if metrics[LaneBulk].Appended != 4 || metrics[LaneInteractive].Appended != len(interactive) {
+		t.Fatalf("lanes appended %d bulk and %d interactive batches, want 4 and %d", metrics[LaneBulk].Appended, metrics[LaneInteractive].Appended, len(interactive))
+	}
+	if metrics[LaneBulk].Depth != 0 || metrics[LaneInteractive].Depth != 0 {
+		t.Fatalf("lanes still hold batches: %+v", metrics)
+	}
+}
+
+func TestAppendSchedulerClose(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewAppendScheduler(bc)
+	if err := s.Process(&Batch{Values: []float64{1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the batch waiting behind the append in progress is rejected on Close
+	bc.mu.Lock()
+	held := enqueue(s, LaneInteractive, "gehalten")
+	waitForDepth(t, s, LaneInteractive, 0)
+	queued := enqueue(s, LaneBulk, "wartend")
+	s.Close()
+	bc.mu.Unlock()
+
+	if err := <-held; err != nil {
+		t.Fatalf("append in progress returned %v", err)
+	}
+	if err := <-queued; err != ErrSchedulerClosed {
+		t.Fatalf("queued append returned %v, want ErrSchedulerClosed", err)
+	}
+	if err := s.Process(&Batch{Values: []float64{4}}); err != ErrSchedulerClosed {
+		t.Fatalf("append after Close returned %v, want ErrSchedulerClosed", err)
+	}
+	if bc.Length() != 3 {
+		t.Fatalf("chain holds %d blocks, want 3", bc.Length())
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// selfTestBlocks is the number of blocks the self-test generates
+const selfTestBlocks = 3
+
+// selfTestCheck is one row of the self-test matrix
+type selfTestCheck struct {
+	name string
+	run  func(env *selfTestEnv) error
+}
+
+// selfTestEnv is the scratch state shared by the self-test checks
+type selfTestEnv struct {
+	dir      string
+	chain    *Blockchain
+	pipeline *Pipeline
+}
+
+// selfTestChecks returns the checks for every feature of this build
+func selfTestChecks() []selfTestCheck {
+	checks := []selfTestCheck{
+		{"Generator", selfTestGenerator},
+		{"Import csv", func(env *selfTestEnv) error { return selfTestImport(env, "csv", "1.5,2.5,3.5\n4,5,6\n") }},
+		{"Import json", func(env *selfTestEnv) error { return selfTestImport(env, "json", "[[1.5,2.5],[3,4]]") }},
+	}
+	for _, name := range SupportedCodecs() {
+		checks = append(checks, selfTestCheck{"Codec " + name, func(*selfTestEnv) error { return selfTestCodec(name) }})
+	}
+	return append(checks,
+		selfTestCheck{"Validierung", selfTestValidate},
+		selfTestCheck{"Bericht", selfTestReport},
+	)
+}
+
+// runSelfTestCommand implements "selftest": it exercises every feature on
+// a scratch chain in a temporary directory and prints a result matrix
+func runSelfTestCommand() int {
+	dir, err := os.MkdirTemp("", "block_data_save-selftest-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Temporäres Verzeichnis konnte nicht angelegt werden:", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	bc := newBlockchain(ChainConfig{})
+	env := &selfTestEnv{dir: dir, chain: bc, pipeline: NewDefaultPipeline(bc)}
+	if failed := runSelfTest(os.Stdout, env, selfTestChecks()); failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runSelfTest runs checks in order, prints one line per check and returns
+// the number of failures
+func runSelfTest(w io.Writer, env *selfTestEnv, checks []selfTestCheck) int {
+	failed := 0
+	for _, check := range checks {
+		start := time.Now()
+		err := check.run(env)
+		elapsed := time.Since(start).Round(time.Microsecond)
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "FEHLER  %-14s %10v  %v\n", check.name, elapsed, err)
+			continue
+		}
+		fmt.Fprintf(w, "OK      %-14s %10v\n", check.name, elapsed)
+	}
+	fmt.Fprintf(w, "%d von %d Prüfungen bestanden\n", len(checks)-failed, len(checks))
+	return failed
+}
+
+func selfTestGenerator(env *selfTestEnv) error {
+	produced := make(chan GeneratorEvent, selfTestBlocks)
+	generator := NewGenerator(env.pipeline.ForSource("selftest"), GeneratorConfig{
+		Interval:       time.Millisecond,
+		ValuesPerBlock: 10,
+		OnEvent: func(event GeneratorEvent) {
+			select {
+			case produced <- event:
+			default:
+			}
+		},
+	})
+	if err := generator.Start(context.Background()); err != nil {
+		return err
+	}
+	defer generator.Stop()
+
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < selfTestBlocks; i++ {
+		select {
+		case event := <-produced:
+			if event.Kind == SinkError {
+				return event.Err
+			}
+		case <-timeout:
+			return fmt.Errorf("nur %d von %d Blöcken erzeugt", i, selfTestBlocks)
+		}
+	}
+	return nil
+}
+
+func selfTestImport(env *selfTestEnv, format, content string) error {
+	path := filepath.Join(env.dir, "import."+format)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		return err
+	}
+	report, err := env.pipeline.ImportGlob(path, ImportOptions{FailFast: true})
+	if err != nil {
+		return err
+	}
+	if file := report.Files[0]; file.Err != nil {
+		return file.Err
+	} else if len(file.RowErrors) > 0 {
+		return file.RowErrors
+	}
+	if report.Blocks() != 2 {
+		return fmt.Errorf("%d statt 2 Blöcke importiert", report.Blocks())
+	}
+	return nil
+}
+
+func selfTestCodec(name string) error {
+	payload := bytes.Repeat([]byte("block_data_save "), 256)
+
+	var buf bytes.Buffer
+	w, err := NewCodecWriter(&buf, name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	r, err := NewCodecReader(&buf)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, payload) {
+		return fmt.Errorf("Daten nach dem Entpacken verändert")
+	}
+	return nil
+}
+
+// selfTestValidate validates the hashes and links of the scratch chain
+func selfTestValidate(env *selfTestEnv) error {
+	if err := env.chain.Validate(); err != nil {
+		return err
+	}
+	if blocks := env.chain.Length(); blocks < 1+selfTestBlocks {
+		return fmt.Errorf("nur %d Blöcke in der Kette", blocks)
+	}
+	return nil
+}
+
+func selfTestReport(env *selfTestEnv) error {
+	return writeReportFile(env.chain, filepath.Join(env.dir, "report.html"), "html")
+}
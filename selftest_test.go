@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// newSelfTestEnv returns a self-test environment in a temporary directory
+func newSelfTestEnv(t *testing.T) *selfTestEnv {
+	t.Helper()
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &selfTestEnv{dir: t.TempDir(), chain: bc, pipeline: NewDefaultPipeline(bc)}
+}
+
+func TestSelfTestPasses(t *testing.T) {
+	var out strings.Builder
+	checks := selfTestChecks()
+	if failed := runSelfTest(&out, newSelfTestEnv(t), checks); failed != 0 {
+		t.Fatalf("%d checks failed:\n%s", failed, out.String())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(checks)+1 {
+		t.Fatalf("self-test printed %d lines for %d checks:\n%s", len(lines), len(checks), out.String())
+	}
+	for i, check := range checks {
+		if !strings.HasPrefix(lines[i], "OK") || !strings.Contains(lines[i], check.name) {
+			t.Fatalf("line %d is %q, want %s passed", i, lines[i], check.name)
+		}
+	}
+}
+
+func TestSelfTestReportsFailures(t *testing.T) {
+	var out strings.Builder
+	checks := []selfTestCheck{
+		{"Import csv", func(env *selfTestEnv) error { return selfTestImport(env, "csv", "1,2\nkeine Zahl\n") }},
+		{"kaputt", func(*selfTestEnv) error { return errors.New("absichtlich") }},
+		{"Validierung", selfTestValidate},
+	}
+	if failed := runSelfTest(&out, newSelfTestEnv(t), checks); failed != 3 {
+		t.Fatalf("%d checks failed, want 3:\n%s", failed, out.String())
+	}
+	for _, want := range []string{"FEHLER  Import csv", "Zeile 2", "FEHLER  kaputt", "absichtlich", "nur 2 Blöcke in der Kette", "0 von 3 Prüfungen bestanden"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MetadataSourceSeq is the metadata key ingestion sources put the sequence
+// number of a batch under. It is stored on the block like all metadata.
+const MetadataSourceSeq = "source_seq"
+
+// maxOpenGaps bounds the missing ranges remembered per source for
+// telling late batches from duplicates
+const maxOpenGaps = 64
+
+// GapKind classifies a sequence number that was not the expected one
+type GapKind string
+
+const (
+	// GapMissing means sequence numbers were skipped
+	GapMissing GapKind = "missing"
+	// GapDuplicate means the sequence number was seen before
+	GapDuplicate GapKind = "duplicate"
+	// GapReordered means a skipped sequence number arrived late
+	GapReordered GapKind = "reordered"
+)
+
+// GapEvent records one unexpected sequence number of a source
+type GapEvent struct {
+	Chain    string
+	Source   string
+	Kind     GapKind
+	Expected uint64
+	Got      uint64
+	// Missing is the number of skipped sequence numbers for GapMissing
+	Missing uint64
+	Time    time.Time
+}
+
+func (e GapEvent) String() string {
+	switch e.Kind {
+	case GapMissing:
+		return fmt.Sprintf("%s/%s: %d Batches fehlen (erwartet %d, erhalten %d)", e.Chain, e.Source, e.Missing, e.Expected, e.Got)
+	case GapDuplicate:
+		return fmt.Sprintf("%s/%s: Batch %d doppelt (erwartet %d)", e.Chain, e.Source, e.Got, e.Expected)
+	default:
+		return fmt.Sprintf("%s/%s: Batch %d verspätet (erwartet %d)", e.Chain, e.Source, e.Got, e.Expected)
+	}
+}
+
+// SequenceMetrics counts the sequence numbers seen from one source
+type SequenceMetrics struct {
+	Source     string
+	Last       uint64
+	Batches    int
+	Gaps       int
+	Missing    uint64
+	Duplicates int
+	Reordered  int
+}
+
+// SequenceMonitorConfig configures a SequenceMonitor
+type SequenceMonitorConfig struct {
+	// Chain names the chain the monitored pipeline appends to
+	Chain string
+	// AlertThreshold is the number of missing sequence numbers in one gap
+	// that calls OnAlert; 0 disables alerts
+	AlertThreshold uint64
+	OnAlert        func(GapEvent)
+	// Clock timestamps events; nil uses the real clock
+	Clock Clock
+}
+
+// SequenceMonitor is a pipeline stage that checks the sequence numbers
+// batches carry under MetadataSourceSeq, per source. The first number of a
+// source is accepted as is, so a restart does not report a gap. Batches
+// are never rejected for their sequence number, only classified.
+type SequenceMonitor struct {
+	cfg SequenceMonitorConfig
+
+	mu      sync.Mutex
+	sources map[string]*sequenceState
+	events  []GapEvent
+}
+
+// seqRange is an inclusive range of missing sequence numbers
+type seqRange struct{ from, to uint64 }
+
+type sequenceState struct {
+	metrics SequenceMetrics
+	open    []seqRange
+}
+
+// NewSequenceMonitor creates a sequence monitor stage
+func NewSequenceMonitor(cfg SequenceMonitorConfig) *SequenceMonitor {
+	if cfg.Clock == nil {
+		cfg.Clock = realClock{}
+	}
+	return &SequenceMonitor{cfg: cfg, sources: map[string]*sequenceState{}}
+}
+
+func (m *SequenceMonitor) Name() string { return "sequence" }
+
+func (m *SequenceMonitor) Process(batch *Batch) error {
+	raw, ok := batch.Metadata[MetadataSourceSeq]
+	if !ok {
+		return nil
+	}
+	seq, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Ungültige Sequenznummer %q", raw)
+	}
+
+	m.mu.Lock()
+	event, ok := m.observe(batch.Source, seq)
+	m.mu.Unlock()
+
+	if ok && event.Kind == GapMissing && m.cfg.AlertThreshold > 0 && event.Missing >= m.cfg.AlertThreshold && m.cfg.OnAlert != nil {
+		m.cfg.OnAlert(event)
+	}
+	return nil
+}
+
+// observe classifies seq for source and records the event, if any. Called
+// with m.mu held.
+func (m *SequenceMonitor) observe(source string, seq uint64) (GapEvent, bool) {
+	state, seen := m.sources[source]
+	if !seen {
+		m.sources[source] = &sequenceState{metrics: SequenceMetrics{Source: source, Last: seq, Batches: 1}}
+		return GapEvent{}, false
+	}
+	state.metrics.Batches++
+
+	expected := state.metrics.Last + 1
+	if seq == expected {
+		state.metrics.Last = seq
+		return GapEvent{}, false
+	}
+
+	event := GapEvent{Chain: m.cfg.Chain, Source: source, Expected: expected, Got: seq, Time: m.cfg.Clock.Now()}
+	switch {
+	case seq > expected:
+		event.Kind = GapMissing
+		event.Missing = seq - expected
+		state.metrics.Gaps++
+		state.metrics.Missing += event.Missing
+		state.metrics.Last = seq
+		state.open = append(state.open, seqRange{expected, seq - 1})
+		if len(state.open) > maxOpenGaps {
+			state.open = state.open[1:]
+		}
+	case state.fill(seq):
+		event.Kind = GapReordered
+		state.metrics.Reordered++
+		state.metrics.Missing--
+	default:
+		event.Kind = GapDuplicate
+		state.metrics.Duplicates++
+	}
+	m.events = append(m.events, event)
+	return event, true
+}
+
+// fill removes seq from the open gaps and reports whether it was missing
+func (s *sequenceState) fill(seq uint64) bool {
+	for i, r := range s.open {
+		if seq < r.from || seq > r.to {
+			continue
+		}
+		var rest []seqRange
+		if seq > r.from {
+			rest = append(rest, seqRange{r.from, seq - 1})
+		}
+		if seq < r.to {
+			rest = append(rest, seqRange{seq + 1, r.to})
+		}
+		s.open = append(s.open[:i], append(rest, s.open[i+1:]...)...)
+		return true
+	}
+	return false
+}
+
+// Events returns a copy of the recorded gap events in arrival order
+func (m *SequenceMonitor) Events() []GapEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]GapEvent(nil), m.events...)
+}
+
+// Metrics returns the per-source metrics, sorted by source
+func (m *SequenceMonitor) Metrics() []SequenceMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics := make([]SequenceMetrics, 0, len(m.sources))
+	for _, state := range m.sources {
+		metrics = append(metrics, state.metrics)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Source < metrics[j].Source })
+	return metrics
+}
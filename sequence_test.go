@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// feedSequence submits a batch from source carrying each of seqs to m
+func feedSequence(t *testing.T, m *SequenceMonitor, source string, seqs ...uint64) {
+	t.Helper()
+	for _, seq := range seqs {
+		batch := &Batch{Source: source, Values: []float64{1}, Metadata: map[string]string{MetadataSourceSeq: strconv.FormatUint(seq, 10)}}
+		if err := m.Process(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSequenceMonitorClassifies(t *testing.T) {
+	clock := &testClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	m := NewSequenceMonitor(SequenceMonitorConfig{Chain: "sensoren", Clock: clock})
+
+	// 3 and 4 are skipped, 5 repeats, then 4 and 3 arrive late and 4
+	// once more
+	feedSequence(t, m, "a", 1, 2, 5, 5, 4, 3, 4, 6)
+	want := []GapEvent{
+		{Chain: "sensoren", Source: "a", Kind: GapMissing, Expected: 3, Got: 5, Missing: 2, Time: clock.now},
+		{Chain: "sensoren", Source: "a", Kind: GapDuplicate, Expected: 6, Got: 5, Time: clock.now},
+		{Chain: "sensoren", Source: "a", Kind: GapReordered, Expected: 6, Got: 4, Time: clock.now},
+		{Chain: "sensoren", Source: "a", Kind: GapReordered, Expected: 6, Got: 3, Time: clock.now},
+		{Chain: "sensoren", Source: "a", Kind: GapDuplicate, Expected: 6, Got: 4, Time: clock.now},
+	}
+	events := m.Events()
+	if len(events) != len(want) {
+		t.Fatalf("monitor recorded %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("event %d is %+v, want %+v", i, events[i], want[i])
+		}
+	}
+
+	metrics := m.Metrics()
+	wantMetrics := SequenceMetrics{Source: "a", Last: 6, Batches: 8, Gaps: 1, Missing: 0, Duplicates: 2, Reordered: 2}
+	if len(metrics) != 1 || metrics[0] != wantMetrics {
+		t.Fatalf("metrics are %+v, want %+v", metrics, wantMetrics)
+	}
+}
+
+func TestSequenceMonitorFirstBatchIsBaseline(t *testing.T) {
+	m := NewSequenceMonitor(SequenceMonitorConfig{AlertThreshold: 1, OnAlert: func(event GapEvent) {
+		t.Fatalf("alert for the first batches of a source: %v", event)
+	}})
+	// a restarted monitor first sees numbers long past 1, from each source
+	feedSequence(t, m, "a", 1000, 1001)
+	feedSequence(t, m, "b", 7, 8)
+	if events := m.Events(); len(events) != 0 {
+		t.Fatalf("monitor recorded %v, want nothing", events)
+	}
+	if metrics := m.Metrics(); len(metrics) != 2 || metrics[0].Last != 1001 || metrics[1].Last != 8 {
+		t.Fatalf("metrics are %+v, want sources a and b tracked on their own", metrics)
+	}
+}
+
+func TestSequenceMonitorAlerts(t *testing.T) {
+	var alerts []GapEvent
+	m := NewSequenceMonitor(SequenceMonitorConfig{AlertThreshold: 3, OnAlert: func(event GapEvent) { alerts = append(alerts, event) }})
+	// a gap of 2 stays below the threshold, one of 3 reaches it
+	feedSequence(t, m, "a", 1, 4, 8)
+	if len(alerts) != 1 || alerts[0].Missing != 3 || alerts[0].Got != 8 {
+		t.Fatalf("alerts are %v, want one for the 3 missing before 8", alerts)
+	}
+	if events := m.Events(); len(events) != 2 {
+		t.Fatalf("monitor recorded %d events, want both gaps", len(events))
+	}
+}
+
+func TestSequenceMonitorIgnoresUnnumbered(t *testing.T) {
+	m := NewSequenceMonitor(SequenceMonitorConfig{})
+	if err := m.Process(&Batch{Source: "a", Values: []float64{1}}); err != nil {
+		t.Fatal(err)
+	}
+	if metrics := m.Metrics(); len(metrics) != 0 {
+		t.Fatalf("a batch without a sequence number was tracked: %+v", metrics)
+	}
+	bad := &Batch{Source: "a", Values: []float64{1}, Metadata: map[string]string{MetadataSourceSeq: "-1"}}
+	if err := m.Process(bad); err == nil {
+		t.Fatal("an invalid sequence number was accepted")
+	}
+}
+
+func TestAPIPostSequence(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewSequenceMonitor(SequenceMonitorConfig{})
+	handler := NewAPIHandler(bc, NewPipeline(ValidateStage{}, m, AppendStage{Chain: bc}))
+	for _, seq := range []string{"1", "2", "4"} {
+		rec := serve(handler, "POST", "/blocks", `{"values": [1, 2], "seq": `+seq+`}`)
+		var block Block
+		decodeResponse(t, rec, http.StatusCreated, &block)
+		if block.Metadata[MetadataSourceSeq] != seq {
+			t.Fatalf("block has metadata %v, want sequence number %s", block.Metadata, seq)
+		}
+	}
+	events := m.Events()
+	if len(events) != 1 || events[0].Source != "http" || events[0].Kind != GapMissing || events[0].Got != 4 {
+		t.Fatalf("monitor recorded %v, want the gap before 4 from http", events)
+	}
+}
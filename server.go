@@ -0,0 +1,755 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Page sizes of the list endpoints
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// defaultSeriesPoints is the max_points of GET /stats/series without one
+const defaultSeriesPoints = 500
+
+// maxRequestBody bounds the body of POST /blocks
+const maxRequestBody = 8 << 20
+
+// blockPage is a page of a block list. Total counts all blocks of the list.
+type blockPage struct {
+	Total  int      `json:"total"`
+	Offset int      `json:"offset"`
+	Limit  int      `json:"limit"`
+	Blocks []*Block `json:"blocks"`
+}
+
+// newBlockRequest is the body of POST /blocks. Seq, if set, is the
+// sequence number of the batch at its source, see MetadataSourceSeq.
+// Text and Metadata are bounded by the BlockLimits of the chain.
+type newBlockRequest struct {
+	Values   []float64         `json:"values"`
+	Text     string            `json:"text"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Seq      *uint64           `json:"seq,omitempty"`
+}
+
+// replayRequest is the body of POST /deadletters/replay: the IDs of the
+// dead letters to replay, or the target whose dead letters to replay
+type replayRequest struct {
+	IDs    []int64 `json:"ids,omitempty"`
+	Target string  `json:"target,omitempty"`
+}
+
+// pruneRequest is the body of POST /operations/prune, see Prune
+type pruneRequest struct {
+	KeepLast int    `json:"keep_last"`
+	Archive  string `json:"archive"`
+}
+
+// redactRequest is the body of POST /operations/redact, see Redact
+type redactRequest struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// formatCheck is the body of a passed GET /formats/{format}/verify
+type formatCheck struct {
+	Format string `json:"format"`
+	OK     bool   `json:"ok"`
+}
+
+// vacuumStatus is the body of GET /storage/vacuum
+type vacuumStatus struct {
+	Running bool          `json:"running"`
+	Last    *VacuumResult `json:"last,omitempty"`
+}
+
+// apiError is the body of every error response
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// headMovedResponse is the body of a 412 to POST /blocks: the error and
+// the current head, to retry with
+type headMovedResponse struct {
+	Error string `json:"error"`
+	Head  *Block `json:"head"`
+}
+
+// compareResponse is the body of GET /compare. The Welch fields are null
+// where CompareWindows leaves them NaN, which JSON cannot hold.
+type compareResponse struct {
+	*WindowComparison
+	WelchT  *float64 `json:"welch_t"`
+	WelchDF *float64 `json:"welch_df"`
+	WelchP  *float64 `json:"welch_p"`
+}
+
+// APIOption configures the handler of NewAPIHandler
+type APIOption func(*apiOptions)
+
+// apiOptions collects what the APIOptions passed to NewAPIHandler set
+type apiOptions struct {
+	exportTTL time.Duration
+	clock     Clock
+	notifier  *WebhookNotifier
+	confirmer *Confirmer
+	usage     *TokenUsage
+}
+
+// WithExportTTL sets how long GET /export keeps a snapshot for resumed
+// downloads; the default is an hour
+func WithExportTTL(ttl time.Duration) APIOption {
+	return func(o *apiOptions) { o.exportTTL = ttl }
+}
+
+// WithWebhookNotifier serves the dead letters of notifier, see
+// ReplayDeadLetters
+func WithWebhookNotifier(notifier *WebhookNotifier) APIOption {
+	return func(o *apiOptions) { o.notifier = notifier }
+}
+
+// WithConfirmer previews, confirms and rolls back the operations of
+// /operations with confirmer, so they share it with the menu; by default
+// the handler has its own
+func WithConfirmer(confirmer *Confirmer) APIOption {
+	return func(o *apiOptions) { o.confirmer = confirmer }
+}
+
+// WithTokenUsage requires the tokens configured in usage once there are
+// any, counts what each token does and enforces its quota, see
+// TokenUsage; by default the API serves anyone but refuses the requests
+// needing ScopeAdmin
+func WithTokenUsage(usage *TokenUsage) APIOption {
+	return func(o *apiOptions) { o.usage = usage }
+}
+
+// WithAPIClock sets the clock the handler measures time with, for tests
+func WithAPIClock(clock Clock) APIOption {
+	return func(o *apiOptions) { o.clock = clock }
+}
+
+// NewAPIHandler serves the blocks of bc as JSON and appends blocks
+// through pipeline:
+//
+//	GET  /blocks?offset=0&limit=100    page of the blocks the chain holds
+//	GET  /blocks/{index}               block by index
+//	GET  /blocks/id/{id}               block by ID, see BlockByID
+//	GET  /blocks/latest                head block
+//	GET  /outliers?offset=0&limit=100  page of the blocks with outliers
+//	GET  /histogram?from=0&to=…        merged histogram, see MergedHistogram
+//	GET  /compare?window1=…&window2=…  two time windows, see CompareWindows
+//	GET  /stats/series?field=mean&downsampler=lttb&max_points=500
+//	                                   block statistic per index, see StatsSeries
+//	GET  /outliers/clusters?from=0&to=…&gap=0&min_count=0
+//	                                   outliers clustered by value, see OutlierClusters
+//	GET  /export?token=…               the chain as JSON lines, with Range
+//	GET  /capabilities                 features and limits, see Capabilities
+//	GET  /formats                      formats the round trip check covers
+//	GET  /recovery                     report of the recovery from the block log
+//	POST /recovery/ack                 acknowledge the report
+//	GET  /storage/vacuum               whether the storage is being vacuumed
+//	POST /storage/vacuum               vacuum the storage, see VacuumStorage
+//	GET  /storage/stats                latencies of the storage, see StorageStats
+//	GET  /formats/{format}/verify      round trip of the chain through format
+//	GET  /deadletters                  notifications the webhook gave up on
+//	POST /deadletters/replay           {"ids": [...]} or {"target": "..."}
+//	POST /operations/prune             preview {"keep_last": 100, "archive": "..."}
+//	POST /operations/redact            preview {"from": 1, "to": 10}
+//	POST /operations/{token}/confirm   run the previewed operation
+//	POST /operations/{token}/rollback  undo it within the undo window
+//	GET  /operations/pending           the operation that can be undone
+//	GET  /tokens/usage                 counters and quotas of the API tokens
+//	POST /blocks                       {"values": [...], "text": "...", "metadata": {...}, "seq": 1}
+//
+// Blocks are read through the locking accessors, so the handler is safe
+// alongside the generator and the menu. GET /blocks/latest and POST
+// /blocks send the hash of the head as ETag; POST /blocks with If-Match
+// appends only onto that head and answers 412 with the current one
+// otherwise, see AddBlockIfHead. With WithTokenUsage every request needs
+// a token once tokens are configured, and POST /blocks answers 429 when
+// the quota of its token is used up.
+func NewAPIHandler(bc *Blockchain, pipeline *Pipeline, opts ...APIOption) http.Handler {
+	o := apiOptions{exportTTL: defaultExportTTL, clock: realClock{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	exports := newExportStore(o.exportTTL, o.clock)
+	if o.confirmer == nil {
+		o.confirmer = NewConfirmer(bc, defaultUndoWindow, o.clock)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /blocks", func(w http.ResponseWriter, r *http.Request) {
+		offset, limit, err := pageParams(r)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		blocks, total := bc.BlockRange(offset, limit)
+		writeJSON(w, http.StatusOK, blockPage{Total: total, Offset: offset, Limit: limit, Blocks: blocks})
+	})
+	mux.HandleFunc("GET /blocks/latest", func(w http.ResponseWriter, r *http.Request) {
+		block := bc.LatestBlock()
+		w.Header().Set("ETag", headETag(block.Hash))
+		writeJSON(w, http.StatusOK, block)
+	})
+	mux.HandleFunc("GET /blocks/{index}", func(w http.ResponseWriter, r *http.Request) {
+		index, err := strconv.Atoi(r.PathValue("index"))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Ungültiger Blockindex: %s", r.PathValue("index")))
+			return
+		}
+		block, err := bc.BlockByIndex(index)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, block)
+	})
+	mux.HandleFunc("GET /blocks/id/{id}", func(w http.ResponseWriter, r *http.Request) {
+		block, err := bc.BlockByID(r.PathValue("id"))
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, block)
+	})
+	mux.HandleFunc("GET /outliers", func(w http.ResponseWriter, r *http.Request) {
+		offset, limit, err := pageParams(r)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		blocks := bc.OutlierBlocks()
+		total := len(blocks)
+		start := min(offset, total)
+		blocks = blocks[start : start+min(limit, total-start)]
+		writeJSON(w, http.StatusOK, blockPage{Total: total, Offset: offset, Limit: limit, Blocks: blocks})
+	})
+	mux.HandleFunc("GET /histogram", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := rangeParams(r, bc)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		merged, err := bc.MergedHistogram(from, to)
+		switch {
+		case errors.Is(err, ErrHistogramMismatch):
+			writeAPIError(w, http.StatusConflict, err)
+		case err != nil:
+			writeAPIError(w, http.StatusNotFound, err)
+		default:
+			writeJSON(w, http.StatusOK, merged)
+		}
+	})
+	mux.HandleFunc("GET /compare", func(w http.ResponseWriter, r *http.Request) {
+		a, err := ParseTimeRange(r.URL.Query().Get("window1"))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		b, err := ParseTimeRange(r.URL.Query().Get("window2"))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		result, err := bc.CompareWindows(a, b)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, err)
+			return
+		}
+		resp := compareResponse{WindowComparison: result}
+		if !math.IsNaN(result.WelchT) {
+			resp.WelchT, resp.WelchDF = &result.WelchT, &result.WelchDF
+		}
+		if !math.IsNaN(result.WelchP) {
+			resp.WelchP = &result.WelchP
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+	mux.HandleFunc("GET /stats/series", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		field, downsampler, maxPoints := query.Get("field"), query.Get("downsampler"), defaultSeriesPoints
+		if field == "" {
+			field = "mean"
+		}
+		if downsampler == "" {
+			downsampler = "lttb"
+		}
+		if s := query.Get("max_points"); s != "" {
+			var err error
+			if maxPoints, err = strconv.Atoi(s); err != nil || maxPoints < 0 {
+				writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Ungültige Punktzahl: %s (0 für alle)", s))
+				return
+			}
+		}
+		series, err := bc.StatsSeries(field, downsampler, maxPoints)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, series)
+	})
+	mux.HandleFunc("GET /outliers/clusters", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := rangeParams(r, bc)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		var opts OutlierClusterOptions
+		if s := r.URL.Query().Get("gap"); s != "" {
+			if opts.Gap, err = strconv.ParseFloat(s, 64); err != nil || opts.Gap < 0 || math.IsNaN(opts.Gap) {
+				writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Ungültiger Abstand: %s (0 für automatisch)", s))
+				return
+			}
+		}
+		if s := r.URL.Query().Get("min_count"); s != "" {
+			if opts.MinCount, err = strconv.Atoi(s); err != nil || opts.MinCount < 0 {
+				writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Ungültige Mindestanzahl: %s (0 für 2)", s))
+				return
+			}
+		}
+		clusters, err := bc.OutlierClusters(from, to, opts)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, err)
+			return
+		}
+		if clusters == nil {
+			clusters = []OutlierCluster{}
+		}
+		writeJSON(w, http.StatusOK, map[string][]OutlierCluster{"clusters": clusters})
+	})
+	mux.HandleFunc("GET /export", func(w http.ResponseWriter, r *http.Request) {
+		exports.serveExport(bc, w, r)
+	})
+	mux.HandleFunc("GET /recovery", func(w http.ResponseWriter, r *http.Request) {
+		report := bc.Recovery()
+		if report == nil {
+			writeAPIError(w, http.StatusNotFound, ErrNoRecovery)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+	mux.HandleFunc("POST /recovery/ack", func(w http.ResponseWriter, r *http.Request) {
+		report := bc.Recovery()
+		if err := bc.AcknowledgeRecovery(); err != nil {
+			writeAPIError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+	mux.HandleFunc("GET /storage/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := bc.StorageStats()
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, stats)
+	})
+	mux.HandleFunc("GET /storage/vacuum", func(w http.ResponseWriter, r *http.Request) {
+		running, last, ok := bc.vacuumStatus()
+		if !ok {
+			writeAPIError(w, http.StatusNotImplemented, ErrVacuumUnsupported)
+			return
+		}
+		writeJSON(w, http.StatusOK, vacuumStatus{Running: running, Last: last})
+	})
+	mux.HandleFunc("POST /storage/vacuum", func(w http.ResponseWriter, r *http.Request) {
+		result, err := bc.VacuumStorage()
+		switch {
+		case errors.Is(err, ErrVacuumUnsupported):
+			writeAPIError(w, http.StatusNotImplemented, err)
+		case errors.Is(err, ErrVacuumInProgress):
+			writeAPIError(w, http.StatusConflict, err)
+		case err != nil:
+			writeAPIError(w, http.StatusInternalServerError, err)
+		default:
+			writeJSON(w, http.StatusOK, result)
+		}
+	})
+	mux.HandleFunc("GET /capabilities", func(w http.ResponseWriter, r *http.Request) {
+		caps := bc.Capabilities()
+		caps.Auth = o.usage.required()
+		writeJSON(w, http.StatusOK, caps)
+	})
+	mux.HandleFunc("GET /formats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string][]string{"formats": RoundTripFormats()})
+	})
+	mux.HandleFunc("GET /formats/{format}/verify", func(w http.ResponseWriter, r *http.Request) {
+		format := r.PathValue("format")
+		err := VerifyFormatRoundTrip(format, bc)
+		switch {
+		case errors.Is(err, ErrUnknownFormat):
+			writeAPIError(w, http.StatusNotFound, err)
+		case errors.Is(err, ErrFormatRoundTrip):
+			writeAPIError(w, http.StatusUnprocessableEntity, err)
+		case err != nil:
+			writeAPIError(w, http.StatusInternalServerError, err)
+		default:
+			writeJSON(w, http.StatusOK, formatCheck{Format: format, OK: true})
+		}
+	})
+	mux.HandleFunc("GET /deadletters", func(w http.ResponseWriter, r *http.Request) {
+		if o.notifier == nil || o.notifier.DeadLetters() == nil {
+			writeAPIError(w, http.StatusNotFound, ErrNoDeadLetters)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string][]DeadLetter{"letters": o.notifier.DeadLetters().List()})
+	})
+	mux.HandleFunc("POST /deadletters/replay", func(w http.ResponseWriter, r *http.Request) {
+		if o.notifier == nil || o.notifier.DeadLetters() == nil {
+			writeAPIError(w, http.StatusNotFound, ErrNoDeadLetters)
+			return
+		}
+		var req replayRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Ungültige Anfrage: %w", err))
+			return
+		}
+		var result ReplayResult
+		var err error
+		switch {
+		case len(req.IDs) > 0 && req.Target == "":
+			result, err = o.notifier.ReplayDeadLetters(req.IDs)
+		case len(req.IDs) == 0 && req.Target != "":
+			result, err = o.notifier.ReplayTarget(req.Target)
+		default:
+			writeAPIError(w, http.StatusBadRequest, errors.New("Entweder ids oder target angeben"))
+			return
+		}
+		switch {
+		case errors.Is(err, ErrDeadLetterNotFound):
+			writeAPIError(w, http.StatusNotFound, err)
+		case err != nil:
+			writeAPIError(w, http.StatusInternalServerError, err)
+		default:
+			writeJSON(w, http.StatusOK, result)
+		}
+	})
+	mux.HandleFunc("POST /operations/prune", func(w http.ResponseWriter, r *http.Request) {
+		var req pruneRequest
+		if !decodeOperation(w, r, &req) {
+			return
+		}
+		preview, err := o.confirmer.PreviewPrune(req.KeepLast, req.Archive)
+		if err != nil {
+			writeAPIError(w, operationErrorStatus(err, http.StatusBadRequest), err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, preview)
+	})
+	mux.HandleFunc("POST /operations/redact", func(w http.ResponseWriter, r *http.Request) {
+		var req redactRequest
+		if !decodeOperation(w, r, &req) {
+			return
+		}
+		preview, err := o.confirmer.PreviewRedact(req.From, req.To)
+		if err != nil {
+			writeAPIError(w, operationErrorStatus(err, http.StatusBadRequest), err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, preview)
+	})
+	mux.HandleFunc("POST /operations/{token}/confirm", func(w http.ResponseWriter, r *http.Request) {
+		confirmation, err := o.confirmer.Confirm(r.PathValue("token"))
+		if err != nil {
+			writeAPIError(w, operationErrorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, confirmation)
+	})
+	mux.HandleFunc("POST /operations/{token}/rollback", func(w http.ResponseWriter, r *http.Request) {
+		if err := o.confirmer.Rollback(r.PathValue("token")); err != nil {
+			writeAPIError(w, operationErrorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"rolled_back": r.PathValue("token")})
+	})
+	mux.HandleFunc("GET /operations/pending", func(w http.ResponseWriter, r *http.Request) {
+		pending := o.confirmer.Pending()
+		if pending == nil {
+			writeAPIError(w, http.StatusNotFound, ErrNothingToUndo)
+			return
+		}
+		writeJSON(w, http.StatusOK, pending)
+	})
+	mux.HandleFunc("POST /blocks", func(w http.ResponseWriter, r *http.Request) {
+		var req newBlockRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("Anfrage ist größer als %d Bytes", tooLarge.Limit))
+				return
+			}
+			writeAPIError(w, decodeErrorStatus(err), fmt.Errorf("Ungültige Anfrage: %w", err))
+			return
+		}
+		if len(req.Values) == 0 {
+			writeAPIError(w, http.StatusUnprocessableEntity, errors.New("Keine Werte angegeben"))
+			return
+		}
+
+		batch := &Batch{Source: "http", Values: req.Values, Text: req.Text, Metadata: req.Metadata, Lane: LaneInteractive, ExpectedHead: ifMatchHead(r)}
+		if req.Seq != nil {
+			batch.Metadata = maps.Clone(req.Metadata)
+			if batch.Metadata == nil {
+				batch.Metadata = map[string]string{}
+			}
+			batch.Metadata[MetadataSourceSeq] = strconv.FormatUint(*req.Seq, 10)
+		}
+		name := tokenName(r)
+		if err := o.usage.reserve(name, 1); err != nil {
+			o.usage.writeQuotaError(w, err)
+			return
+		}
+		err := pipeline.Submit(batch)
+		if err != nil {
+			o.usage.settle(name, 1, 0, 0)
+			var moved *HeadMovedError
+			if errors.As(err, &moved) {
+				w.Header().Set("ETag", headETag(moved.Head.Hash))
+				writeJSON(w, http.StatusPreconditionFailed, headMovedResponse{Error: err.Error(), Head: moved.Head})
+				return
+			}
+			writeAPIError(w, appendErrorStatus(err), err)
+			return
+		}
+		o.usage.settle(name, 1, 1, len(req.Values))
+		block, err := bc.BlockByIndex(batch.Index)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Location", "/blocks/"+strconv.Itoa(block.Index))
+		w.Header().Set("ETag", headETag(block.Hash))
+		writeJSON(w, http.StatusCreated, block)
+	})
+	mux.HandleFunc("GET /tokens/usage", func(w http.ResponseWriter, r *http.Request) {
+		if o.usage == nil {
+			writeAPIError(w, http.StatusNotFound, ErrNoTokens)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string][]TokenReport{"tokens": o.usage.Report()})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("Unbekannter Pfad: %s %s", r.Method, r.URL.Path))
+	})
+	if o.usage != nil {
+		return o.usage.authorize(mux)
+	}
+	return refuseAdmin(mux)
+}
+
+// headETag returns hash as the ETag of the head
+func headETag(hash string) string {
+	return `"` + hash + `"`
+}
+
+// ifMatchHead returns the head hash the If-Match header of r expects,
+// quoted or not, or "" without one or for If-Match: *
+func ifMatchHead(r *http.Request) string {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "*" {
+		return ""
+	}
+	return strings.Trim(value, `"`)
+}
+
+// pageParams reads the offset and limit query parameters
+func pageParams(r *http.Request) (offset, limit int, err error) {
+	offset, limit = 0, defaultPageLimit
+	if s := r.URL.Query().Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("Ungültiger Offset: %s", s)
+		}
+	}
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("Ungültiges Limit: %s (erlaubt 1 bis %d)", s, maxPageLimit)
+		}
+	}
+	return offset, limit, nil
+}
+
+// rangeParams reads the from and to query parameters, block indexes that
+// default to the oldest block held and the head
+func rangeParams(r *http.Request, bc *Blockchain) (from, to int, err error) {
+	from, to = bc.HistoryStart(), bc.LatestBlock().Index
+	if s := r.URL.Query().Get("from"); s != "" {
+		if from, err = strconv.Atoi(s); err != nil {
+			return 0, 0, fmt.Errorf("Ungültiger Startindex: %s", s)
+		}
+	}
+	if s := r.URL.Query().Get("to"); s != "" {
+		if to, err = strconv.Atoi(s); err != nil {
+			return 0, 0, fmt.Errorf("Ungültiger Endindex: %s", s)
+		}
+	}
+	if from > to {
+		return 0, 0, fmt.Errorf("Ungültiger Bereich: %d bis %d", from, to)
+	}
+	return from, to, nil
+}
+
+// decodeErrorStatus maps an error decoding a request body to a status
+// code: 400 for a body that is not JSON, 422 for JSON that does not fit
+// the request, such as a string for a number or an unknown field
+func decodeErrorStatus(err error) int {
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return http.StatusBadRequest
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// appendErrorStatus maps the error of a rejected batch to a status code:
+// 413 for text or metadata over the BlockLimits
+func appendErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrLimitExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrChainFull), errors.Is(err, ErrSchedulerClosed),
+		errors.Is(err, ErrQueueFull), errors.Is(err, ErrBatchDropped), errors.Is(err, ErrQueueClosed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrVacuumInProgress):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrDuplicateBlock):
+		return http.StatusConflict
+	case errors.Is(err, ErrHeadMoved):
+		return http.StatusPreconditionFailed
+	default:
+		// validation, bounds and the value kind of the chain
+		return http.StatusUnprocessableEntity
+	}
+}
+
+// decodeOperation decodes the body of a preview request into req, or
+// answers 400 and returns false
+func decodeOperation(w http.ResponseWriter, r *http.Request, req any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Ungültige Anfrage: %w", err))
+		return false
+	}
+	return true
+}
+
+// operationErrorStatus maps the error of a preview, confirmation or
+// rollback to a status code, fallback for errors of no known kind
+func operationErrorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, ErrPreviewNotFound), errors.Is(err, ErrNothingToUndo), errors.Is(err, ErrBlockNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrPreviewStale), errors.Is(err, ErrUndoConflict):
+		return http.StatusConflict
+	case errors.Is(err, errStorageReadOnly):
+		return http.StatusNotImplemented
+	case errors.Is(err, errNoChange), errors.Is(err, ErrBlockArchived), errors.Is(err, ErrBlockExpired),
+		errors.Is(err, ErrHistoryUnavailable):
+		return http.StatusUnprocessableEntity
+	default:
+		return fallback
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(apiError{Error: err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+// APIServer runs the handler of NewAPIHandler on an address until it is
+// stopped. It can be started again after Stop.
+type APIServer struct {
+	handler http.Handler
+
+	mu     sync.Mutex
+	server *http.Server
+	addr   string
+}
+
+// NewAPIServer creates a stopped server for the API of bc
+func NewAPIServer(bc *Blockchain, pipeline *Pipeline, opts ...APIOption) *APIServer {
+	return &APIServer{handler: NewAPIHandler(bc, pipeline, opts...)}
+}
+
+// ServeMetrics makes the server answer GET /metrics with handler, see
+// NewMetricsHandler. It applies from the next Start.
+func (s *APIServer) ServeMetrics(handler http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = withMetrics(s.handler, handler)
+}
+
+// Start listens on addr, e.g. ":8080", and serves in the background
+func (s *APIServer) Start(addr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.server != nil {
+		return fmt.Errorf("HTTP-Server läuft bereits auf %s", s.addr)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: s.handler, ReadHeaderTimeout: 10 * time.Second}
+	s.server, s.addr = server, listener.Addr().String()
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Println("HTTP-Server beendet:", err)
+		}
+	}()
+	return nil
+}
+
+// Stop shuts the server down, waiting briefly for requests in flight
+func (s *APIServer) Stop() error {
+	s.mu.Lock()
+	server := s.server
+	s.server, s.addr = nil, ""
+	s.mu.Unlock()
+
+	if server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// Addr returns the address the server listens on, or "" when stopped
+func (s *APIServer) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addr
+}
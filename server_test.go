@@ -0,0 +1,564 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestAPI returns the API handler of a chain holding chainTestValues
+func newTestAPI(t *testing.T, opts ...Option) (*Blockchain, http.Handler) {
+	t.Helper()
+	bc, err := NewBlockchain(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillChain(t, bc)
+	return bc, NewAPIHandler(bc, NewDefaultPipeline(bc))
+}
+
+// serve sends a request with body, if not empty, to handler and returns
+// the recorded response
+func serve(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	var req *http.Request
+	if body == "" {
+		req = httptest.NewRequest(method, target, nil)
+	} else {
+		req = httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeResponse checks status and the JSON content type of rec and
+// decodes its body into v
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder, status int, v any) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status is %d, want %d: %s", rec.Code, status, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type is %q, want application/json", ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("body %q is not JSON: %v", rec.Body, err)
+	}
+}
+
+// expectAPIError checks that rec is an error response with status and a
+// message
+func expectAPIError(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	var body apiError
+	decodeResponse(t, rec, status, &body)
+	if body.Error == "" {
+		t.Fatalf("error response %s has no message", rec.Body)
+	}
+}
+
+func TestAPIBlocksPage(t *testing.T) {
+	bc, handler := newTestAPI(t)
+	var page blockPage
+	decodeResponse(t, serve(handler, "GET", "/blocks?offset=2&limit=3", ""), http.StatusOK, &page)
+	if page.Total != bc.Length() || page.Offset != 2 || page.Limit != 3 || len(page.Blocks) != 3 {
+		t.Fatalf("page is total %d, offset %d, limit %d with %d blocks, want %d, 2, 3 with 3", page.Total, page.Offset, page.Limit, len(page.Blocks), bc.Length())
+	}
+	if !sameHashes(page.Blocks, bc.Blocks()[2:5]) {
+		t.Fatalf("page holds %v, want blocks 2 to 4", blockHashes(page.Blocks))
+	}
+
+	decodeResponse(t, serve(handler, "GET", "/blocks?offset=100", ""), http.StatusOK, &page)
+	if page.Total != bc.Length() || len(page.Blocks) != 0 || page.Limit != defaultPageLimit {
+		t.Fatalf("page past the end holds %d blocks with limit %d, want none with %d", len(page.Blocks), page.Limit, defaultPageLimit)
+	}
+
+	for _, query := range []string{"offset=-1", "offset=x", "limit=0", "limit=1001"} {
+		expectAPIError(t, serve(handler, "GET", "/blocks?"+query, ""), http.StatusBadRequest)
+	}
+}
+
+func TestAPIBlockByIndex(t *testing.T) {
+	bc, handler := newTestAPI(t)
+	want, err := bc.BlockByIndex(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block Block
+	decodeResponse(t, serve(handler, "GET", "/blocks/3", ""), http.StatusOK, &block)
+	if block.Index != 3 || block.Hash != want.Hash || calculateHash(&block) != want.Hash {
+		t.Fatalf("GET /blocks/3 returned block %d with hash %s, want %s", block.Index, block.Hash, want.Hash)
+	}
+
+	decodeResponse(t, serve(handler, "GET", "/blocks/latest", ""), http.StatusOK, &block)
+	if block.Hash != bc.HeadHash() {
+		t.Fatalf("GET /blocks/latest returned %s, want the head %s", block.Hash, bc.HeadHash())
+	}
+
+	expectAPIError(t, serve(handler, "GET", "/blocks/99", ""), http.StatusNotFound)
+	expectAPIError(t, serve(handler, "GET", "/blocks/abc", ""), http.StatusBadRequest)
+}
+
+func TestAPIBlockByID(t *testing.T) {
+	bc, handler := newTestAPI(t)
+	want, err := bc.BlockByIndex(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block Block
+	decodeResponse(t, serve(handler, "GET", "/blocks/id/"+want.ID, ""), http.StatusOK, &block)
+	if block.Index != 4 || block.ID != want.ID || block.Hash != want.Hash {
+		t.Fatalf("GET /blocks/id/%s returned block %d with ID %s, want block 4", want.ID, block.Index, block.ID)
+	}
+	expectAPIError(t, serve(handler, "GET", "/blocks/id/01ARZ3NDEKTSV4RRFFQ69G5FAV", ""), http.StatusNotFound)
+}
+
+func TestAPIOutlierContexts(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetOutlierContext(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{10, 10, 10, 10, 90, 10, 10, 10, 10, 10}); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewAPIHandler(bc, NewDefaultPipeline(bc))
+
+	var block Block
+	decodeResponse(t, serve(handler, "GET", "/blocks/1", ""), http.StatusOK, &block)
+	if len(block.OutlierContexts) != 1 {
+		t.Fatalf("GET /blocks/1 returned contexts %+v, want one", block.OutlierContexts)
+	}
+	if c := block.OutlierContexts[0]; c.Position != 4 || c.Value != 90 || !slices.Equal(c.Before, []float64{10, 10}) || !slices.Equal(c.After, []float64{10, 10}) {
+		t.Fatalf("GET /blocks/1 returned context %+v, want two values either side of 90", c)
+	}
+}
+
+func TestAPIOutliers(t *testing.T) {
+	bc, handler := newTestAPI(t)
+	want := bc.OutlierBlocks()
+	if len(want) < 2 {
+		t.Fatalf("the test chain has %d outlier blocks, want at least 2", len(want))
+	}
+	var page blockPage
+	decodeResponse(t, serve(handler, "GET", "/outliers?limit=1", ""), http.StatusOK, &page)
+	if page.Total != len(want) || !sameHashes(page.Blocks, want[:1]) {
+		t.Fatalf("GET /outliers returned %v of %d, want %v of %d", blockHashes(page.Blocks), page.Total, blockHashes(want[:1]), len(want))
+	}
+	decodeResponse(t, serve(handler, "GET", "/outliers?offset=1", ""), http.StatusOK, &page)
+	if !sameHashes(page.Blocks, want[1:]) {
+		t.Fatalf("GET /outliers?offset=1 returned %v, want %v", blockHashes(page.Blocks), blockHashes(want[1:]))
+	}
+	for _, block := range page.Blocks {
+		if !block.HasOutliers || len(block.Outliers) == 0 {
+			t.Fatalf("block %d in /outliers has no outliers", block.Index)
+		}
+	}
+}
+
+func TestAPIHistogram(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bins := HistogramBins{Count: 10, Min: 0, Max: 100}
+	if err := bc.SetHistogramBins(&bins); err != nil {
+		t.Fatal(err)
+	}
+	fillChain(t, bc)
+	handler := NewAPIHandler(bc, NewDefaultPipeline(bc))
+
+	var all, middle []float64
+	for i, values := range chainTestValues {
+		all = append(all, values...)
+		if i >= 1 && i <= 3 {
+			middle = append(middle, values...)
+		}
+	}
+	for target, values := range map[string][]float64{"/histogram": all, "/histogram?from=2&to=4": middle} {
+		var got BinnedValues
+		decodeResponse(t, serve(handler, "GET", target, ""), http.StatusOK, &got)
+		want := binValues(bins, values)
+		if got.Bins != want.Bins || got.Under != want.Under || got.Over != want.Over || !slices.Equal(got.Counts, want.Counts) {
+			t.Fatalf("GET %s is %+v, want %+v over the raw values", target, got, *want)
+		}
+	}
+
+	// a range spanning the change of the bins refuses to merge
+	if err := bc.SetHistogramBins(&HistogramBins{Count: 5, Min: 0, Max: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{1}); err != nil {
+		t.Fatal(err)
+	}
+	expectAPIError(t, serve(handler, "GET", "/histogram", ""), http.StatusConflict)
+	if rec := serve(handler, "GET", "/histogram?to=5", ""); rec.Code != http.StatusOK {
+		t.Fatalf("GET /histogram?to=5 before the change answered %d: %s", rec.Code, rec.Body)
+	}
+	expectAPIError(t, serve(handler, "GET", "/histogram?from=4&to=2", ""), http.StatusBadRequest)
+	expectAPIError(t, serve(handler, "GET", "/histogram?from=x", ""), http.StatusBadRequest)
+	expectAPIError(t, serve(handler, "GET", "/histogram?to=99", ""), http.StatusNotFound)
+}
+
+func TestAPICompare(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addSamples := func(day string, values ...float64) {
+		t.Helper()
+		start, err := time.Parse(time.RFC3339, day+"T00:00:00Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples := make([]Sample, len(values))
+		for i, value := range values {
+			samples[i] = Sample{Time: start.Add(time.Duration(i) * time.Minute), Value: value}
+		}
+		if _, err := bc.AddTimedValues(samples); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addSamples("2026-01-01", 1, 2, 3, 4, 5)
+	addSamples("2026-01-08", 3, 4, 5, 6, 7)
+	addSamples("2026-02-01", 5, 5, 5)
+	addSamples("2026-02-08", 5, 5)
+	addSamples("2026-03-01", 4)
+	handler := NewAPIHandler(bc, NewDefaultPipeline(bc))
+	compare := func(window1, window2 string) *httptest.ResponseRecorder {
+		return serve(handler, "GET", "/compare?window1="+window1+"&window2="+window2, "")
+	}
+
+	// reference: scipy.stats.ttest_ind(a, b, equal_var=False) gives
+	// t = -2, for a - b, and p = 0.0805 for the values of the windows
+	var c WindowComparison
+	decodeResponse(t, compare("2026-01-01T00:00:00Z/2026-01-02T00:00:00Z", "2026-01-08T00:00:00Z/2026-01-09T00:00:00Z"), http.StatusOK, &c)
+	near := func(got, want float64) bool { return math.Abs(got-want) < 1e-3 }
+	if !near(c.WelchT, 2) || !near(c.WelchDF, 8) || !near(c.WelchP, 0.0805) || !near(c.KS, 0.4) || !near(c.CohensD, 2/math.Sqrt(2.5)) {
+		t.Fatalf("comparison is %+v, want t 2, df 8, p 0.0805, KS 0.4 and d 1.265", c)
+	}
+	if c.A.Count != 5 || c.B.Mean != 5 || c.B.Quantiles["p50"] != 5 || len(c.Caveats) == 0 {
+		t.Fatalf("windows are %+v and %+v with caveats %v, want 5 values, mean 5 and a small-sample caveat", c.A, c.B, c.Caveats)
+	}
+
+	// without spread or with a single value the t-test is undefined and
+	// its fields null
+	for _, windows := range [][2]string{
+		{"2026-02-01T00:00:00Z/2026-02-02T00:00:00Z", "2026-02-08T00:00:00Z/2026-02-09T00:00:00Z"},
+		{"2026-01-01T00:00:00Z/2026-01-02T00:00:00Z", "2026-03-01T00:00:00Z/2026-03-02T00:00:00Z"},
+	} {
+		var body map[string]any
+		decodeResponse(t, compare(windows[0], windows[1]), http.StatusOK, &body)
+		for _, field := range []string{"welch_t", "welch_df", "welch_p"} {
+			if v, ok := body[field]; !ok || v != nil {
+				t.Fatalf("%s for %v is %v, want null", field, windows, v)
+			}
+		}
+		if caveats, _ := body["caveats"].([]any); len(caveats) == 0 {
+			t.Fatalf("comparison of %v has no caveats", windows)
+		}
+	}
+
+	expectAPIError(t, compare("2026-01-01T00:00:00Z/2026-01-02T00:00:00Z", "2025-01-01T00:00:00Z/2025-01-02T00:00:00Z"), http.StatusNotFound)
+	expectAPIError(t, compare("2026-01-01T00:00:00Z", "2026-01-08T00:00:00Z/2026-01-09T00:00:00Z"), http.StatusBadRequest)
+	expectAPIError(t, serve(handler, "GET", "/compare", ""), http.StatusBadRequest)
+}
+
+func TestAPIStatsSeries(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a sine over 100 blocks with one spike
+	for i := 0; i < 100; i++ {
+		value := 10 * math.Sin(float64(i)/8)
+		if i == 50 {
+			value = 1000
+		}
+		if _, err := bc.AddBlock([]float64{value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := NewAPIHandler(bc, NewDefaultPipeline(bc))
+	series := func(query string) Series {
+		t.Helper()
+		var s Series
+		decodeResponse(t, serve(handler, "GET", "/stats/series?"+query, ""), http.StatusOK, &s)
+		return s
+	}
+	peak := func(points []Point) float64 {
+		top := math.Inf(-1)
+		for _, p := range points {
+			top = max(top, p.Y)
+		}
+		return top
+	}
+
+	full := series("max_points=0")
+	if full.Field != "mean" || full.Downsampler != "lttb" || len(full.Points) != 100 {
+		t.Fatalf("default series is %s by %s with %d points, want mean by lttb with all 100", full.Field, full.Downsampler, len(full.Points))
+	}
+	for _, name := range SupportedDownsamplers() {
+		s := series("field=mean&max_points=10&downsampler=" + name)
+		if s.Downsampler != name || len(s.Points) == 0 || len(s.Points) > 10 {
+			t.Fatalf("series by %s is by %s with %d points, want at most 10", name, s.Downsampler, len(s.Points))
+		}
+		switch name {
+		case "minmax":
+			if peak(s.Points) != 1000 {
+				t.Fatalf("minmax envelope lost the spike: %v", s.Points)
+			}
+		case "mean":
+			if top := peak(s.Points); top >= 1000 || top <= 10 {
+				t.Fatalf("mean buckets peak at %v, want the spike smoothed but not gone", top)
+			}
+		case "lttb":
+			if s.Points[0] != full.Points[0] || s.Points[len(s.Points)-1] != full.Points[99] || peak(s.Points) != 1000 {
+				t.Fatalf("lttb series %v does not keep the ends and the spike", s.Points)
+			}
+		case "last":
+			if s.Points[len(s.Points)-1] != full.Points[99] {
+				t.Fatalf("last series ends with %v, want %v", s.Points[len(s.Points)-1], full.Points[99])
+			}
+		}
+	}
+
+	for _, query := range []string{"downsampler=median", "field=temperature", "max_points=-1", "max_points=x"} {
+		expectAPIError(t, serve(handler, "GET", "/stats/series?"+query, ""), http.StatusBadRequest)
+	}
+}
+
+func TestAPIOutlierClusters(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one outlier per block, planted around 90 and around 500, and one
+	// isolated at 300
+	for _, outlier := range []float64{90, 500, 92, 300, 91, 502} {
+		values := []float64{10, 10, 10, 10, 10, 10, 10, 10, 10, outlier}
+		if _, err := bc.AddBlock(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := NewAPIHandler(bc, NewDefaultPipeline(bc))
+
+	var body map[string][]OutlierCluster
+	decodeResponse(t, serve(handler, "GET", "/outliers/clusters?gap=20", ""), http.StatusOK, &body)
+	clusters := body["clusters"]
+	if len(clusters) != 2 {
+		t.Fatalf("GET /outliers/clusters found %+v, want the clusters around 90 and 500", clusters)
+	}
+	low, high := clusters[0], clusters[1]
+	if low.Min != 90 || low.Max != 92 || low.Count != 3 || !slices.Equal(low.Blocks, []int{1, 3, 5}) {
+		t.Fatalf("low cluster is %+v, want 90 to 92 in blocks 1, 3 and 5", low)
+	}
+	if high.Min != 500 || high.Max != 502 || high.Count != 2 || !slices.Equal(high.Blocks, []int{2, 6}) {
+		t.Fatalf("high cluster is %+v, want 500 to 502 in blocks 2 and 6", high)
+	}
+	if first, _ := bc.BlockByIndex(2); !high.First.Equal(first.Timestamp) || high.Last.Before(high.First) {
+		t.Fatalf("high cluster spans %v to %v, want from the time of block 2", high.First, high.Last)
+	}
+
+	// a range holding one cluster, and one without enough outliers
+	decodeResponse(t, serve(handler, "GET", "/outliers/clusters?gap=20&from=1&to=3", ""), http.StatusOK, &body)
+	if len(body["clusters"]) != 1 || body["clusters"][0].Max != 92 {
+		t.Fatalf("clusters of blocks 1 to 3 are %+v, want the low one only", body["clusters"])
+	}
+	rec := serve(handler, "GET", "/outliers/clusters?to=1", "")
+	if decodeResponse(t, rec, http.StatusOK, &body); body["clusters"] == nil || len(body["clusters"]) != 0 {
+		t.Fatalf("clusters of block 1 are %s, want an empty list", rec.Body)
+	}
+
+	for _, query := range []string{"gap=-1", "gap=x", "min_count=-1", "from=5&to=2"} {
+		expectAPIError(t, serve(handler, "GET", "/outliers/clusters?"+query, ""), http.StatusBadRequest)
+	}
+	expectAPIError(t, serve(handler, "GET", "/outliers/clusters?to=99", ""), http.StatusNotFound)
+}
+
+func TestAPIPostBlock(t *testing.T) {
+	bc, handler := newTestAPI(t)
+	rec := serve(handler, "POST", "/blocks", `{"values": [1, 2.5, 4], "text": "Messung"}`)
+	var block Block
+	decodeResponse(t, rec, http.StatusCreated, &block)
+	if block.Index != bc.Length()-1 || block.Hash != bc.HeadHash() || block.Text != "Messung" || len(block.Values) != 3 {
+		t.Fatalf("POST /blocks returned %+v, want the new head", block)
+	}
+	if location := rec.Header().Get("Location"); location != "/blocks/"+strconv.Itoa(block.Index) {
+		t.Fatalf("Location is %q, want /blocks/%d", location, block.Index)
+	}
+
+	for name, body := range map[string]string{
+		"no values":     `{"values": []}`,
+		"missing":       `{"text": "leer"}`,
+		"not a number":  `{"values": [1, "zwei"]}`,
+		"unknown field": `{"values": [1], "unit": "C"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			expectAPIError(t, serve(handler, "POST", "/blocks", body), http.StatusUnprocessableEntity)
+		})
+	}
+	for name, body := range map[string]string{
+		"malformed": `{"values": [1,`,
+		"not JSON":  `values=1`,
+		"empty":     ``,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/blocks", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			expectAPIError(t, rec, http.StatusBadRequest)
+		})
+	}
+	t.Run("too large", func(t *testing.T) {
+		body := `{"values": [` + strings.Repeat("1,", maxRequestBody/2) + `1]}`
+		expectAPIError(t, serve(handler, "POST", "/blocks", body), http.StatusRequestEntityTooLarge)
+	})
+	t.Run("out of bounds", func(t *testing.T) {
+		if err := bc.SetBounds(&ValueBounds{Min: 0, Max: 10, Policy: BoundsReject}); err != nil {
+			t.Fatal(err)
+		}
+		defer bc.SetBounds(nil)
+		expectAPIError(t, serve(handler, "POST", "/blocks", `{"values": [5, 50]}`), http.StatusUnprocessableEntity)
+	})
+	if bc.Length() != len(chainTestValues)+2 {
+		t.Fatalf("chain holds %d blocks after the rejected posts, want %d", bc.Length(), len(chainTestValues)+2)
+	}
+}
+
+func TestAPIPostBlockLimits(t *testing.T) {
+	bc, handler := newTestAPI(t)
+	bc.SetLimits(BlockLimits{MaxTextBytes: 8, MaxMetadataKeys: 2, MaxKeyLength: 4, MaxValueLength: 6})
+	length := bc.Length()
+
+	// exactly at every limit
+	rec := serve(handler, "POST", "/blocks", `{"values": [1], "text": "12345678", "metadata": {"ort": "123456", "raum": "b"}}`)
+	var block Block
+	decodeResponse(t, rec, http.StatusCreated, &block)
+	if block.Text != "12345678" || block.Metadata["ort"] != "123456" || block.Metadata["raum"] != "b" {
+		t.Fatalf("POST /blocks at the limits stored %+v", block)
+	}
+	for name, body := range map[string]string{
+		"text":      `{"values": [1], "text": "123456789"}`,
+		"keys":      `{"values": [1], "metadata": {"a": "1", "b": "2", "c": "3"}}`,
+		"key":       `{"values": [1], "metadata": {"stadt": "1"}}`,
+		"value":     `{"values": [1], "metadata": {"ort": "1234567"}}`,
+		"with seq":  `{"values": [1], "metadata": {"a": "1", "b": "2"}, "seq": 1}`,
+		"no string": `{"values": [1], "metadata": {"ort": 1}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			status := http.StatusRequestEntityTooLarge
+			if name == "no string" {
+				status = http.StatusUnprocessableEntity
+			}
+			expectAPIError(t, serve(handler, "POST", "/blocks", body), status)
+		})
+	}
+	if bc.Length() != length+1 {
+		t.Fatalf("chain holds %d blocks after the rejected posts, want %d", bc.Length(), length+1)
+	}
+}
+
+func TestAPICapabilities(t *testing.T) {
+	bc, handler := newTestAPI(t)
+	bc.SetLimits(BlockLimits{MaxTextBytes: 8})
+	if err := bc.SetDifficulty(1); err != nil {
+		t.Fatal(err)
+	}
+	want, err := json.Marshal(bc.Capabilities())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var caps Capabilities
+	rec := serve(handler, "GET", "/capabilities", "")
+	decodeResponse(t, rec, http.StatusOK, &caps)
+	if got := strings.TrimSpace(rec.Body.String()); got != string(want) {
+		t.Fatalf("GET /capabilities is %s, want %s", got, want)
+	}
+	if caps.Difficulty != 1 || caps.Limits.MaxTextBytes != 8 || caps.Auth {
+		t.Fatalf("capabilities are %+v, want difficulty 1, the limits of the chain and no auth", caps)
+	}
+
+	// with tokens the document needs none and says they are required
+	_, _, tokenHandler := newTokenAPI(t, "")
+	decodeResponse(t, serve(tokenHandler, "GET", "/capabilities", ""), http.StatusOK, &caps)
+	if !caps.Auth {
+		t.Fatal("capabilities of an API with tokens have auth false")
+	}
+}
+
+func TestAPIPostIfMatch(t *testing.T) {
+	bc, handler := newTestAPI(t)
+	post := func(values, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/blocks", strings.NewReader(`{"values": `+values+`}`))
+		req.Header.Set("If-Match", ifMatch)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	// two writers read the head and post onto it at once, round by round
+	for round := 0; round < 20; round++ {
+		head := serve(handler, "GET", "/blocks/latest", "").Header().Get("ETag")
+		if head != headETag(bc.HeadHash()) {
+			t.Fatalf("ETag of GET /blocks/latest is %s, want the head %s", head, bc.HeadHash())
+		}
+		length := bc.Length()
+		var recs [2]*httptest.ResponseRecorder
+		var wg sync.WaitGroup
+		for i := range recs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				recs[i] = post("["+strconv.Itoa(i+1)+"]", head)
+			}()
+		}
+		wg.Wait()
+		if recs[0].Code == recs[1].Code {
+			t.Fatalf("round %d: writers answered %d and %d, want one 201 and one 412", round, recs[0].Code, recs[1].Code)
+		}
+		won, lost := recs[0], recs[1]
+		if won.Code != http.StatusCreated {
+			won, lost = lost, won
+		}
+		var block Block
+		decodeResponse(t, won, http.StatusCreated, &block)
+		var moved headMovedResponse
+		decodeResponse(t, lost, http.StatusPreconditionFailed, &moved)
+		if moved.Error == "" || moved.Head == nil || moved.Head.Hash != block.Hash || lost.Header().Get("ETag") != won.Header().Get("ETag") {
+			t.Fatalf("round %d: 412 is %s, want the block %d of the winner as head", round, lost.Body, block.Index)
+		}
+		if bc.Length() != length+1 {
+			t.Fatalf("round %d: chain grew by %d blocks, want 1", round, bc.Length()-length)
+		}
+	}
+
+	// unquoted, *, and no expectation append as before
+	for _, ifMatch := range []string{bc.HeadHash(), "*", ""} {
+		if rec := post("[1]", ifMatch); rec.Code != http.StatusCreated {
+			t.Fatalf("POST /blocks with If-Match %q answered %d: %s", ifMatch, rec.Code, rec.Body)
+		}
+	}
+	expectAPIError(t, post("[1]", headETag("0000")), http.StatusPreconditionFailed)
+}
+
+func TestAPIPostDuplicate(t *testing.T) {
+	_, handler := newTestAPI(t, WithDeduplication(10))
+	expectAPIError(t, serve(handler, "POST", "/blocks", `{"values": [2, 4, 6, 8, 10]}`), http.StatusConflict)
+}
+
+func TestAPIUnknownPath(t *testing.T) {
+	bc, handler := newTestAPI(t)
+	expectAPIError(t, serve(handler, "GET", "/chains", ""), http.StatusNotFound)
+	admin := NewAPIHandler(bc, NewDefaultPipeline(bc), withAdminToken(t))
+	expectAPIError(t, serveAdmin(admin, "DELETE", "/blocks/1", ""), http.StatusNotFound)
+}
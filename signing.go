@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// GenerateSigningKey creates a new Ed25519 key pair for SetSigner
+func GenerateSigningKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// EncodeSigningKey returns the hex seed of a private key, from which
+// ParseSigningKey restores it
+func EncodeSigningKey(priv ed25519.PrivateKey) string {
+	return hex.EncodeToString(priv.Seed())
+}
+
+// ParseSigningKey parses the hex seed written by EncodeSigningKey
+func ParseSigningKey(text string) (ed25519.PrivateKey, error) {
+	seed, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("Ungültiger Signaturschlüssel, erwartet %d Bytes hexadezimal", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParsePublicKey parses a hex public key, such as the SignerPublicKey of
+// a block printed by formatPublicKey
+func ParsePublicKey(text string) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Ungültiger öffentlicher Schlüssel, erwartet %d Bytes hexadezimal", ed25519.PublicKeySize)
+	}
+	return key, nil
+}
+
+// formatPublicKey returns the hex form of a public key
+func formatPublicKey(key []byte) string {
+	return hex.EncodeToString(key)
+}
+
+// SetSigner makes every new block carry an Ed25519 Signature of its hash
+// and the SignerPublicKey to check it with, proving which process added
+// it. Blocks already in the chain stay as they are. The key is not saved
+// with the chain, so blocks added to a chain loaded from a file are
+// unsigned until SetSigner is called again; the saved signatures are
+// kept and checked by Validate. nil turns signing off.
+func (bc *Blockchain) SetSigner(priv ed25519.PrivateKey) error {
+	if priv != nil && len(priv) != ed25519.PrivateKeySize {
+		return fmt.Errorf("Ungültiger Signaturschlüssel: %d Bytes", len(priv))
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.signer = priv
+	return nil
+}
+
+// signBlock signs the hash of a block with priv. The signature is not
+// covered by the hash.
+func signBlock(block *Block, priv ed25519.PrivateKey) {
+	block.SignerPublicKey = bytes.Clone(priv.Public().(ed25519.PublicKey))
+	block.Signature = ed25519.Sign(priv, []byte(block.Hash))
+}
+
+// verifySignature checks the signature of a block against the key it
+// names, returning "" if it holds or the block is unsigned
+func verifySignature(block *Block) string {
+	if block.Signature == nil && block.SignerPublicKey == nil {
+		return ""
+	}
+	if len(block.SignerPublicKey) != ed25519.PublicKeySize {
+		return fmt.Sprintf("Öffentlicher Schlüssel hat %d statt %d Bytes", len(block.SignerPublicKey), ed25519.PublicKeySize)
+	}
+	if !ed25519.Verify(block.SignerPublicKey, []byte(block.Hash), block.Signature) {
+		return fmt.Sprintf("Signatur passt nicht zu Hash %s", formatHash(block.Hash))
+	}
+	return ""
+}
+
+// VerifySignatures checks that every block of the chain is signed by pub
+// and that its signature matches its hash. Together with Validate, which
+// checks that the hashes match the content, this proves that the holder
+// of pub's private key added the blocks as they are. It returns a
+// *ValidationError for the first block that is unsigned, signed by
+// another key or whose signature does not hold. The record of a chain
+// bootstrapped from a checkpoint is not checked.
+func (bc *Blockchain) VerifySignatures(pub ed25519.PublicKey) error {
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("Ungültiger öffentlicher Schlüssel: %d Bytes", len(pub))
+	}
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	blocks, err := bc.allBlocks()
+	if err != nil {
+		return err
+	}
+	for pos, block := range blocks {
+		if pos == 0 && bc.stub {
+			continue
+		}
+		switch {
+		case block.Signature == nil:
+			return &ValidationError{Index: block.Index, Reason: "Block ist nicht signiert"}
+		case !bytes.Equal(block.SignerPublicKey, pub):
+			return &ValidationError{Index: block.Index, Reason: fmt.Sprintf("Block ist mit Schlüssel %s signiert", formatHash(formatPublicKey(block.SignerPublicKey)))}
+		case !ed25519.Verify(pub, []byte(block.Hash), block.Signature):
+			return &ValidationError{Index: block.Index, Reason: fmt.Sprintf("Signatur passt nicht zu Hash %s", formatHash(block.Hash))}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSigningKeyEncoding(t *testing.T) {
+	pub, priv, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseSigningKey(" " + EncodeSigningKey(priv) + "\n")
+	if err != nil || !bytes.Equal(parsed, priv) {
+		t.Fatalf("key parses to %x, %v", parsed, err)
+	}
+	if key, err := ParsePublicKey(formatPublicKey(pub)); err != nil || !bytes.Equal(key, pub) {
+		t.Fatalf("public key parses to %x, %v", key, err)
+	}
+	for _, text := range []string{"", "zz", EncodeSigningKey(priv)[2:]} {
+		if _, err := ParseSigningKey(text); err == nil {
+			t.Fatalf("signing key %q parsed", text)
+		}
+		if _, err := ParsePublicKey(text); err == nil {
+			t.Fatalf("public key %q parsed", text)
+		}
+	}
+}
+
+func TestVerifySignatures(t *testing.T) {
+	pub, priv, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, otherPriv, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBlockchain(WithSigner(priv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillChain(t, bc)
+	if err := bc.VerifySignatures(pub); err != nil {
+		t.Fatal(err)
+	}
+	var invalid *ValidationError
+	if err := bc.VerifySignatures(other); !errors.As(err, &invalid) || invalid.Index != 0 || !strings.Contains(err.Error(), "mit Schlüssel") {
+		t.Fatalf("signatures checked against another key returned %v", err)
+	}
+	if err := bc.VerifySignatures(pub[:8]); err == nil {
+		t.Fatal("short public key accepted")
+	}
+
+	// signatures are kept in the file but the key is not
+	path := filepath.Join(t.TempDir(), "kette.json")
+	if err := bc.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBlockchainFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.VerifySignatures(pub); err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := loaded.AddBlock([]float64{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.VerifySignatures(pub); !errors.As(err, &invalid) || invalid.Index != unsigned.Index {
+		t.Fatalf("unsigned block returned %v", err)
+	}
+	if err := loaded.SetSigner(otherPriv); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := loaded.AddBlock([]float64{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(signed.SignerPublicKey, other) {
+		t.Fatalf("block is signed by %x, want %x", signed.SignerPublicKey, other)
+	}
+	if err := loaded.SetSigner(priv[:10]); err == nil {
+		t.Fatal("short signing key accepted")
+	}
+
+	// a signature that does not hold fails Validate
+	index := tamper(t, bc, 2, func(block *Block) { block.Signature[0] ^= 1 })
+	expectInvalidAt(t, bc, index)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// ValueSource produces the values of generated blocks
+type ValueSource interface {
+	Next(n int) []float64
+}
+
+// UniformSource produces values uniformly distributed in [0, 1)
+type UniformSource struct {
+	// Rand is used for the values; nil uses the global source
+	Rand *rand.Rand
+}
+
+func (s UniformSource) Next(n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		if s.Rand != nil {
+			values[i] = s.Rand.Float64()
+		} else {
+			values[i] = rand.Float64()
+		}
+	}
+	return values
+}
+
+// defaultSpikeSize is the distance of spikes from the mean, in standard
+// deviations, when NormalSource.SpikeSize is 0
+const defaultSpikeSize = 6
+
+// NormalSource produces normally distributed values. Each value is
+// replaced by a spike with probability SpikeProbability, so blocks with
+// outliers occur.
+type NormalSource struct {
+	Mean   float64
+	StdDev float64
+	// SpikeProbability is the chance of a value being a spike, in [0, 1]
+	SpikeProbability float64
+	// SpikeSize is the distance of a spike from the mean in standard
+	// deviations, above or below at random; 0 means defaultSpikeSize
+	SpikeSize float64
+	// Rand is used for the values; nil uses the global source
+	Rand *rand.Rand
+}
+
+// Validate checks the standard deviation and the spike parameters
+func (s NormalSource) Validate() error {
+	if s.StdDev < 0 || math.IsNaN(s.StdDev) || math.IsInf(s.StdDev, 0) {
+		return fmt.Errorf("Ungültige Standardabweichung: %v", s.StdDev)
+	}
+	if math.IsNaN(s.Mean) || math.IsInf(s.Mean, 0) {
+		return fmt.Errorf("Ungültiger Mittelwert: %v", s.Mean)
+	}
+	if !(s.SpikeProbability >= 0 && s.SpikeProbability <= 1) {
+		return fmt.Errorf("Ungültige Spike-Wahrscheinlichkeit: %v (erlaubt 0 bis 1)", s.SpikeProbability)
+	}
+	if s.SpikeSize < 0 || math.IsNaN(s.SpikeSize) || math.IsInf(s.SpikeSize, 0) {
+		return fmt.Errorf("Ungültige Spike-Größe: %v", s.SpikeSize)
+	}
+	return nil
+}
+
+func (s NormalSource) Next(n int) []float64 {
+	float, norm := rand.Float64, rand.NormFloat64
+	if s.Rand != nil {
+		float, norm = s.Rand.Float64, s.Rand.NormFloat64
+	}
+	size := s.SpikeSize
+	if size == 0 {
+		size = defaultSpikeSize
+	}
+
+	values := make([]float64, n)
+	for i := range values {
+		if s.SpikeProbability > 0 && float() < s.SpikeProbability {
+			spike := size * s.StdDev
+			if float() < 0.5 {
+				spike = -spike
+			}
+			values[i] = s.Mean + spike
+			continue
+		}
+		values[i] = s.Mean + norm()*s.StdDev
+	}
+	return values
+}
+
+// ReplaySource replays the values of a file in order, starting over after
+// the last one. How fast they are replayed is set by the interval and the
+// values per block of the generator. It is not safe for concurrent use.
+type ReplaySource struct {
+	values []float64
+	pos    int
+}
+
+// NewReplaySource reads the values of every row of a csv or json file, in
+// the current number locale
+func NewReplaySource(path, format string) (*ReplaySource, error) {
+	source := &ReplaySource{}
+	err := StreamDataFromFile(path, format, func(row []float64) error {
+		source.values = append(source.values, row...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(source.values) == 0 {
+		return nil, fmt.Errorf("%s enthält keine Werte", path)
+	}
+	return source, nil
+}
+
+func (s *ReplaySource) Next(n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = s.values[s.pos]
+		s.pos = (s.pos + 1) % len(s.values)
+	}
+	return values
+}
+
+// ParseValueSource parses a source as given on the command line or in
+// the menu:
+//
+//	uniform
+//	normal:mean=10,stddev=2,spikes=0.01,spike_size=6
+//	replay:values.csv
+//
+// All parameters of normal are optional; stddev defaults to 1. The format
+// of a replayed file is taken from its extension.
+func ParseValueSource(spec string) (ValueSource, error) {
+	kind, params, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	switch kind {
+	case "uniform":
+		if params != "" {
+			return nil, fmt.Errorf("Quelle uniform hat keine Parameter")
+		}
+		return UniformSource{}, nil
+	case "normal":
+		source := NormalSource{StdDev: 1}
+		for _, param := range strings.Split(params, ",") {
+			if strings.TrimSpace(param) == "" {
+				continue
+			}
+			key, text, ok := strings.Cut(param, "=")
+			value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("Ungültiger Parameter: %s", param)
+			}
+			switch strings.TrimSpace(key) {
+			case "mean":
+				source.Mean = value
+			case "stddev":
+				source.StdDev = value
+			case "spikes":
+				source.SpikeProbability = value
+			case "spike_size":
+				source.SpikeSize = value
+			default:
+				return nil, fmt.Errorf("Unbekannter Parameter: %s", key)
+			}
+		}
+		if err := source.Validate(); err != nil {
+			return nil, err
+		}
+		return source, nil
+	case "replay":
+		if params == "" {
+			return nil, fmt.Errorf("Quelle replay braucht einen Dateipfad")
+		}
+		format := "csv"
+		if strings.HasSuffix(strings.ToLower(params), ".json") {
+			format = "json"
+		}
+		return NewReplaySource(params, format)
+	default:
+		return nil, fmt.Errorf("Unbekannte Wertquelle: %s (uniform, normal oder replay)", kind)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalSource(t *testing.T) {
+	source := NormalSource{Mean: 10, StdDev: 2, Rand: rand.New(rand.NewSource(270))}
+	values := source.Next(20000)
+	mean, sd := meanAndSD(values)
+	if math.Abs(mean-10) > 0.1 || math.Abs(sd-2) > 0.1 {
+		t.Fatalf("values have mean %v and standard deviation %v, want 10 and 2", mean, sd)
+	}
+
+	// with every value a spike, each lies spike_size deviations away
+	spiky := NormalSource{Mean: 10, StdDev: 2, SpikeProbability: 1, SpikeSize: 3, Rand: rand.New(rand.NewSource(270))}
+	for _, value := range spiky.Next(100) {
+		if value != 4 && value != 16 {
+			t.Fatalf("spike %v is not 3 deviations from the mean", value)
+		}
+	}
+	if values := (NormalSource{Mean: 1, SpikeProbability: 1}).Next(3); !slices.Equal(values, []float64{1, 1, 1}) {
+		t.Fatalf("spikes without deviation are %v", values)
+	}
+}
+
+func meanAndSD(values []float64) (float64, float64) {
+	var sum, squares float64
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+	for _, value := range values {
+		squares += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)))
+}
+
+func TestReplaySource(t *testing.T) {
+	dir := writeImportFiles(t, map[string]string{
+		"werte.csv":  "1,2\n3\n",
+		"werte.json": "[[4,5],[6]]",
+		"leer.csv":   "",
+	})
+	source, err := ParseValueSource("replay:" + filepath.Join(dir, "werte.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the values start over after the last one
+	if got := source.Next(4); !slices.Equal(got, []float64{1, 2, 3, 1}) {
+		t.Fatalf("first values are %v", got)
+	}
+	if got := source.Next(3); !slices.Equal(got, []float64{2, 3, 1}) {
+		t.Fatalf("next values are %v", got)
+	}
+
+	source, err = ParseValueSource("replay:" + filepath.Join(dir, "werte.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := source.Next(3); !slices.Equal(got, []float64{4, 5, 6}) {
+		t.Fatalf("values from json are %v", got)
+	}
+	if _, err := NewReplaySource(filepath.Join(dir, "leer.csv"), "csv"); err == nil || !strings.Contains(err.Error(), "enthält keine Werte") {
+		t.Fatalf("empty file returned %v", err)
+	}
+}
+
+func TestParseValueSource(t *testing.T) {
+	tests := []struct {
+		spec string
+		want ValueSource
+		err  string
+	}{
+		{"uniform", UniformSource{}, ""},
+		{"uniform:1", nil, "keine Parameter"},
+		{"normal", NormalSource{StdDev: 1}, ""},
+		{" normal:mean=10, stddev=2,spikes=0.01,spike_size=4 ", NormalSource{Mean: 10, StdDev: 2, SpikeProbability: 0.01, SpikeSize: 4}, ""},
+		{"normal:mean", nil, "Ungültiger Parameter: mean"},
+		{"normal:median=1", nil, "Unbekannter Parameter: median"},
+		{"normal:stddev=-1", nil, "Ungültige Standardabweichung"},
+		{"normal:spikes=1.5", nil, "Ungültige Spike-Wahrscheinlichkeit"},
+		{"normal:spike_size=NaN", nil, "Ungültige Spike-Größe"},
+		{"normal:mean=Inf", nil, "Ungültiger Mittelwert"},
+		{"replay", nil, "braucht einen Dateipfad"},
+		{"poisson", nil, "Unbekannte Wertquelle: poisson"},
+	}
+	for _, tt := range tests {
+		source, err := ParseValueSource(tt.spec)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("%q returned %v, want %q", tt.spec, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || source != tt.want {
+			t.Fatalf("%q parses to %+v, %v, want %+v", tt.spec, source, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchema creates the tables of an SQLiteStorage. data holds the
+// block encoded with gob, as in the block log; the other columns repeat
+// what the queries filter on, each with an index. The single row of
+// state holds the chainState as JSON.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS blocks (
+	idx       INTEGER PRIMARY KEY,
+	timestamp INTEGER NOT NULL,
+	mean      REAL,
+	outliers  INTEGER NOT NULL,
+	data      BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS blocks_timestamp ON blocks (timestamp);
+CREATE INDEX IF NOT EXISTS blocks_mean ON blocks (mean);
+CREATE INDEX IF NOT EXISTS blocks_outliers ON blocks (outliers);
+CREATE TABLE IF NOT EXISTS state (
+	id   INTEGER PRIMARY KEY CHECK (id = 0),
+	data BLOB NOT NULL
+);
+`
+
+// errStorageClosed is returned by an SQLiteStorage after Close
+var errStorageClosed = errors.New("Datenbank ist geschlossen")
+
+// SQLiteStorage is a Storage in an SQLite database. Besides the blocks
+// themselves it stores their index, timestamp, mean and number of
+// outliers in indexed columns, which BlocksBetween,
+// BlocksWithMeanBetween and BlocksWithMoreOutliersThan query without
+// decoding every block.
+type SQLiteStorage struct {
+	path string
+
+	// dbMu guards db, which Vacuum replaces; queries hold it while they
+	// start
+	dbMu sync.RWMutex
+	db   *sql.DB
+
+	// mu guards count, closed and the state of Vacuum; count is kept so
+	// Count needs no query
+	mu         sync.Mutex
+	count      int
+	closed     bool
+	vacuuming  bool
+	lastVacuum *VacuumResult
+}
+
+// OpenSQLiteStorage opens the database at path, creating it with the
+// table of the blocks if it does not exist
+func OpenSQLiteStorage(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &SQLiteStorage{db: db, path: path}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Datenbank %s: %w", path, err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM blocks`).Scan(&s.count); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Datenbank %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// openSQLiteChain returns the chain of the database at path, created with
+// cfg if the database is new, and opts, for main's -db
+func openSQLiteChain(path string, cfg ChainConfig, opts ...Option) (*Blockchain, error) {
+	storage, err := OpenSQLiteStorage(path)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := NewBlockchain(append([]Option{WithStorage(storage), WithChainConfig(cfg)}, opts...)...)
+	if err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("Datenbank %s: %w", path, err)
+	}
+	return bc, nil
+}
+
+// Path returns the path the database was opened at
+func (s *SQLiteStorage) Path() string {
+	return s.path
+}
+
+func (s *SQLiteStorage) AppendBlock(block *Block) error {
+	data, err := encodeStoredBlock(block)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var last sql.NullInt64
+	if err := tx.QueryRow(`SELECT MAX(idx) FROM blocks`).Scan(&last); err != nil {
+		return err
+	}
+	if last.Valid && int64(block.Index) != last.Int64+1 {
+		return fmt.Errorf("%w: Block %d folgt nicht auf Block %d", ErrChainInvalid, block.Index, last.Int64)
+	}
+	if err := insertBlock(tx, block, data, false); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.count++
+	return nil
+}
+
+func (s *SQLiteStorage) GetBlock(index int) (*Block, error) {
+	blocks, err := s.query(`SELECT data FROM blocks WHERE idx = ?`, index)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("%w: Index %d", ErrBlockNotFound, index)
+	}
+	return blocks[0], nil
+}
+
+func (s *SQLiteStorage) LatestBlock() (*Block, error) {
+	blocks, err := s.query(`SELECT data FROM blocks ORDER BY idx DESC LIMIT 1`)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("%w: Datenbank %s ist leer", ErrBlockNotFound, s.path)
+	}
+	return blocks[0], nil
+}
+
+func (s *SQLiteStorage) Iterate(from, to int, fn func(*Block) error) error {
+	rows, err := s.queryRows(`SELECT data FROM blocks WHERE idx >= ? AND idx < ? ORDER BY idx`, from, to)
+	if err != nil {
+		return s.checkClosed(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		block, err := scanBlock(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(block); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLiteStorage) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// BlocksBetween returns the blocks with a timestamp in [from, to], in
+// order
+func (s *SQLiteStorage) BlocksBetween(from, to time.Time) ([]*Block, error) {
+	return s.query(`SELECT data FROM blocks WHERE timestamp BETWEEN ? AND ? ORDER BY idx`, from.UnixNano(), to.UnixNano())
+}
+
+// BlocksWithMeanBetween returns the blocks with values whose mean lies in
+// [lo, hi], in order, like Blockchain.BlocksWithMeanBetween
+func (s *SQLiteStorage) BlocksWithMeanBetween(lo, hi float64) ([]*Block, error) {
+	return s.query(`SELECT data FROM blocks WHERE mean BETWEEN ? AND ? ORDER BY idx`, lo, hi)
+}
+
+// BlocksWithMoreOutliersThan returns the blocks with more than k outliers,
+// in order, like Blockchain.BlocksWithMoreOutliersThan
+func (s *SQLiteStorage) BlocksWithMoreOutliersThan(k int) ([]*Block, error) {
+	return s.query(`SELECT data FROM blocks WHERE outliers > ? ORDER BY idx`, k)
+}
+
+// Close closes the database; the storage cannot be used afterwards
+func (s *SQLiteStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.db.Close()
+}
+
+func (s *SQLiteStorage) rewrite(from, to int, blocks []*Block, state *chainState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM blocks WHERE idx >= ? AND idx < ?`, from, to); err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		data, err := encodeStoredBlock(block)
+		if err != nil {
+			return err
+		}
+		if err := insertBlock(tx, block, data, true); err != nil {
+			return err
+		}
+	}
+	var count int
+	var first, last sql.NullInt64
+	if err := tx.QueryRow(`SELECT COUNT(*), MIN(idx), MAX(idx) FROM blocks`).Scan(&count, &first, &last); err != nil {
+		return err
+	}
+	if count > 0 && last.Int64-first.Int64+1 != int64(count) {
+		return fmt.Errorf("%w: Lücke zwischen Block %d und %d", ErrChainInvalid, first.Int64, last.Int64)
+	}
+	if state != nil {
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO state (id, data) VALUES (0, ?)`, data); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.count = count
+	return nil
+}
+
+func (s *SQLiteStorage) loadState() (*chainState, error) {
+	var data []byte
+	s.dbMu.RLock()
+	err := s.db.QueryRow(`SELECT data FROM state WHERE id = 0`).Scan(&data)
+	s.dbMu.RUnlock()
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, s.checkClosed(err)
+	}
+	state := &chainState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("Zustand in der Datenbank: %w", err)
+	}
+	return state, nil
+}
+
+// query returns the blocks of a query that selects their data
+func (s *SQLiteStorage) query(query string, args ...any) ([]*Block, error) {
+	rows, err := s.queryRows(query, args...)
+	if err != nil {
+		return nil, s.checkClosed(err)
+	}
+	defer rows.Close()
+	var blocks []*Block
+	for rows.Next() {
+		block, err := scanBlock(rows)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, rows.Err()
+}
+
+// queryRows starts a query on the current database
+func (s *SQLiteStorage) queryRows(query string, args ...any) (*sql.Rows, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.db.Query(query, args...)
+}
+
+// checkWritable returns the error of a write to the storage while it is
+// closed or vacuumed. Called with s.mu held.
+func (s *SQLiteStorage) checkWritable() error {
+	switch {
+	case s.closed:
+		return errStorageClosed
+	case s.vacuuming:
+		return fmt.Errorf("%w: %s", ErrVacuumInProgress, s.path)
+	}
+	return nil
+}
+
+// checkClosed returns errStorageClosed for the error of a query on a
+// closed storage, and err otherwise
+func (s *SQLiteStorage) checkClosed(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStorageClosed
+	}
+	return err
+}
+
+// insertBlock inserts block with its encoded data, replacing the block of
+// the same index if replace is set
+func insertBlock(tx *sql.Tx, block *Block, data []byte, replace bool) error {
+	// NaN, the mean of a block without values, is stored as NULL, which
+	// no range matches
+	mean := sql.NullFloat64{Float64: block.Mean, Valid: !math.IsNaN(block.Mean)}
+	verb := "INSERT"
+	if replace {
+		verb = "INSERT OR REPLACE"
+	}
+	_, err := tx.Exec(verb+` INTO blocks (idx, timestamp, mean, outliers, data) VALUES (?, ?, ?, ?, ?)`,
+		block.Index, block.Timestamp.UnixNano(), mean, block.OutlierCount(), data)
+	return err
+}
+
+// encodeStoredBlock encodes block for the data column
+func encodeStoredBlock(block *Block) ([]byte, error) {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(block); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+// scanBlock decodes the block of the current row
+func scanBlock(rows *sql.Rows) (*Block, error) {
+	var data []byte
+	if err := rows.Scan(&data); err != nil {
+		return nil, err
+	}
+	block := &Block{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(block); err != nil {
+		return nil, fmt.Errorf("Block in der Datenbank: %w", err)
+	}
+	return block, nil
+}
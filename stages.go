@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The stages below apply a policy to the batches of one pipeline before
+// they reach a chain. The chain's own SetBounds and SetDeduplication stay
+// part of the append, which runs under the chain's lock for every writer,
+// including those that bypass pipelines.
+
+// BoundsStage enforces Bounds on the values of each batch, as SetBounds
+// does for a chain. Violations are recorded in the batch's metadata.
+type BoundsStage struct {
+	Bounds ValueBounds
+}
+
+// NewBoundsStage returns a BoundsStage after validating bounds
+func NewBoundsStage(bounds ValueBounds) (BoundsStage, error) {
+	if err := bounds.Validate(); err != nil {
+		return BoundsStage{}, err
+	}
+	return BoundsStage{Bounds: bounds}, nil
+}
+
+func (BoundsStage) Name() string { return "bounds" }
+
+func (s BoundsStage) Process(batch *Batch) error {
+	values, violations, err := s.Bounds.apply(batch.Values)
+	if err != nil || violations == 0 {
+		return err
+	}
+	if batch.Times != nil && len(values) != len(batch.Values) {
+		batch.Times = s.Bounds.keepTimes(batch.Values, batch.Times)
+	}
+	if batch.Origins != nil && len(values) != len(batch.Values) {
+		batch.Origins = s.Bounds.keepOrigins(batch.Values, batch.Origins)
+	}
+	batch.Values = values
+	batch.Metadata = maps.Clone(batch.Metadata)
+	if batch.Metadata == nil {
+		batch.Metadata = map[string]string{}
+	}
+	batch.Metadata["bounds"] = s.Bounds.String()
+	batch.Metadata["bounds_violations"] = strconv.Itoa(violations)
+	return nil
+}
+
+// DedupStage rejects a batch matching ErrDuplicateBlock when its values
+// equal those of one of the last window batches it passed on. Unlike
+// SetDeduplication it covers every chain the pipeline routes to, but
+// forgets the batches when the program ends.
+type DedupStage struct {
+	window int
+
+	mu     sync.Mutex
+	recent []string
+}
+
+// NewDedupStage creates a DedupStage remembering window batches
+func NewDedupStage(window int) (*DedupStage, error) {
+	if window < 1 {
+		return nil, fmt.Errorf("Ungültiges Fenster für doppelte Batches: %d", window)
+	}
+	return &DedupStage{window: window}, nil
+}
+
+func (*DedupStage) Name() string { return "dedup" }
+
+func (s *DedupStage) Process(batch *Batch) error {
+	hash := contentHash(KindFloat, batch.Values, nil, batch.Times)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seen := range s.recent {
+		if seen == hash {
+			return fmt.Errorf("%w: gleiche Werte wie ein vorheriger Batch (%s)", ErrDuplicateBlock, formatHash(hash))
+		}
+	}
+	if len(s.recent) == s.window {
+		s.recent = s.recent[1:]
+	}
+	s.recent = append(s.recent, hash)
+	return nil
+}
+
+// ErrRateLimited is returned by RateLimitStage for batches over its rate
+var ErrRateLimited = errors.New("Zu viele Batches")
+
+// RateLimitStage passes on at most rate batches per second on average,
+// with bursts of up to burst batches, and rejects the others with
+// ErrRateLimited
+type RateLimitStage struct {
+	rate  float64
+	burst float64
+	clock Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimitStage creates a RateLimitStage that starts with a full
+// burst; clock is the wall clock if nil
+func NewRateLimitStage(rate float64, burst int, clock Clock) (*RateLimitStage, error) {
+	if !(rate > 0) || burst < 1 {
+		return nil, fmt.Errorf("Ungültige Rate: %g Batches pro Sekunde, Spitze %d", rate, burst)
+	}
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &RateLimitStage{rate: rate, burst: float64(burst), clock: clock, tokens: float64(burst), last: clock.Now()}, nil
+}
+
+func (*RateLimitStage) Name() string { return "rate_limit" }
+
+func (s *RateLimitStage) Process(*Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	if elapsed := now.Sub(s.last); elapsed > 0 {
+		s.tokens = min(s.tokens+elapsed.Seconds()*s.rate, s.burst)
+	}
+	s.last = now
+	if s.tokens < 1 {
+		return fmt.Errorf("%w: höchstens %g pro Sekunde", ErrRateLimited, s.rate)
+	}
+	s.tokens--
+	return nil
+}
+
+// RouteStage sends the batches of each source in Routes to its chain by
+// setting Batch.Chain. Batches of other sources go to the chain of the
+// append stage.
+type RouteStage struct {
+	Routes map[string]*Blockchain
+}
+
+func (RouteStage) Name() string { return "route" }
+
+func (s RouteStage) Process(batch *Batch) error {
+	if chain, ok := s.Routes[batch.Source]; ok {
+		batch.Chain = chain
+	}
+	return nil
+}
+
+// CutStage collects the values of each source and cuts them into blocks
+// of exactly size values, like AddValues does for a chain; Flush cuts
+// the rest. Batches with a text, metadata, times or an expected head
+// describe one block and pass unchanged. Origins are kept as long as
+// every batch of a source has them.
+type CutStage struct {
+	size int
+
+	mu      sync.Mutex
+	sources []string
+	buffers map[string]*Batch
+}
+
+// NewCutStage creates a CutStage cutting blocks of size values
+func NewCutStage(size int) (*CutStage, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("Ungültige Blockgröße: %d", size)
+	}
+	return &CutStage{size: size, buffers: map[string]*Batch{}}, nil
+}
+
+func (*CutStage) Name() string { return "cut" }
+
+// Process cannot pass on several batches, so CutStage only runs as the
+// SplitStage of a Pipeline
+func (*CutStage) Process(*Batch) error {
+	return errors.New("CutStage läuft nur in einer Pipeline")
+}
+
+// Split buffers the values of batch and returns the blocks it completed
+func (s *CutStage) Split(batch *Batch) ([]*Batch, error) {
+	if batch.Text != "" || batch.Metadata != nil || batch.Times != nil || batch.ExpectedHead != "" {
+		return []*Batch{batch}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buffer, ok := s.buffers[batch.Source]
+	if !ok {
+		buffer = &Batch{Source: batch.Source}
+		s.buffers[batch.Source] = buffer
+		s.sources = append(s.sources, batch.Source)
+	}
+	buffer.Lane, buffer.Chain = batch.Lane, batch.Chain
+	if len(buffer.Origins) == len(buffer.Values) && len(batch.Origins) == len(batch.Values) {
+		buffer.Origins = append(buffer.Origins, batch.Origins...)
+	} else {
+		buffer.Origins = nil
+	}
+	buffer.Values = append(buffer.Values, batch.Values...)
+
+	var out []*Batch
+	for len(buffer.Values) >= s.size {
+		cut := *buffer
+		cut.Values = buffer.Values[:s.size:s.size]
+		buffer.Values = buffer.Values[s.size:]
+		if len(buffer.Origins) > 0 {
+			cut.Origins = buffer.Origins[:s.size:s.size]
+			buffer.Origins = buffer.Origins[s.size:]
+		}
+		out = append(out, &cut)
+	}
+	return out, nil
+}
+
+// Flush returns the values still buffered, one batch per source in the
+// order the sources were first seen
+func (s *CutStage) Flush() []*Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*Batch
+	for _, source := range s.sources {
+		if buffer := s.buffers[source]; len(buffer.Values) > 0 {
+			out = append(out, buffer)
+		}
+	}
+	s.sources, s.buffers = nil, map[string]*Batch{}
+	return out
+}
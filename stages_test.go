@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBoundsStage(t *testing.T) {
+	for _, tc := range []struct {
+		policy BoundsPolicy
+		want   []float64
+		err    error
+	}{
+		{BoundsClamp, []float64{0, 5, 10}, nil},
+		{BoundsDrop, []float64{5}, nil},
+		{BoundsReject, nil, ErrOutOfBounds},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			stage, err := NewBoundsStage(ValueBounds{Min: 0, Max: 10, Policy: tc.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			values := []float64{-1, 5, 11}
+			batch := &Batch{Values: values, Times: []time.Time{start, start.Add(time.Second), start.Add(2 * time.Second)}}
+			err = stage.Process(batch)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("Process returned %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(batch.Values, tc.want) || len(batch.Times) != len(tc.want) {
+				t.Fatalf("batch holds %v at %d times, want %v", batch.Values, len(batch.Times), tc.want)
+			}
+			if batch.Metadata["bounds_violations"] != "2" || values[0] != -1 {
+				t.Fatalf("metadata is %v and submitted values %v, want 2 violations recorded and the values untouched", batch.Metadata, values)
+			}
+		})
+	}
+	if _, err := NewBoundsStage(ValueBounds{Min: 1, Max: 0, Policy: BoundsClamp}); err == nil {
+		t.Fatal("NewBoundsStage accepted a minimum above the maximum")
+	}
+}
+
+func TestDedupStage(t *testing.T) {
+	stage, err := NewDedupStage(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tc := range []struct {
+		values    []float64
+		duplicate bool
+	}{
+		{[]float64{1, 2}, false},
+		{[]float64{1, 2}, true},
+		{[]float64{2, 1}, false},
+		{[]float64{3}, false},
+		// {1, 2} fell out of the window of two
+		{[]float64{1, 2}, false},
+		{[]float64{3}, true},
+	} {
+		err := stage.Process(&Batch{Values: tc.values})
+		if got := errors.Is(err, ErrDuplicateBlock); got != tc.duplicate || (err != nil && !got) {
+			t.Fatalf("batch %d %v returned %v, want duplicate %v", i, tc.values, err, tc.duplicate)
+		}
+	}
+	if _, err := NewDedupStage(0); err == nil {
+		t.Fatal("NewDedupStage accepted a window of 0")
+	}
+}
+
+func TestRateLimitStage(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	stage, err := NewRateLimitStage(2, 3, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admitted := func(n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			if err := stage.Process(&Batch{}); err == nil {
+				count++
+			} else if !errors.Is(err, ErrRateLimited) {
+				t.Fatal(err)
+			}
+		}
+		return count
+	}
+	if got := admitted(5); got != 3 {
+		t.Fatalf("%d of 5 batches passed at once, want the burst of 3", got)
+	}
+	clock.now = clock.now.Add(time.Second)
+	if got := admitted(5); got != 2 {
+		t.Fatalf("%d batches passed a second later, want 2", got)
+	}
+	// the bucket holds no more than the burst
+	clock.now = clock.now.Add(time.Minute)
+	if got := admitted(5); got != 3 {
+		t.Fatalf("%d batches passed a minute later, want 3", got)
+	}
+	if _, err := NewRateLimitStage(0, 1, clock); err == nil {
+		t.Fatal("NewRateLimitStage accepted a rate of 0")
+	}
+}
+
+func TestRouteStage(t *testing.T) {
+	primary, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sensors, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPipeline(RouteStage{Routes: map[string]*Blockchain{"sensor": sensors}}, AppendStage{Chain: primary})
+	for _, source := range []string{"sensor", "import", "sensor"} {
+		if err := p.Submit(&Batch{Source: source, Values: []float64{1, 2, 3}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if primary.Length() != 2 || sensors.Length() != 3 {
+		t.Fatalf("primary chain holds %d blocks and the sensor chain %d, want 2 and 3", primary.Length(), sensors.Length())
+	}
+}
+
+func TestCutStage(t *testing.T) {
+	stage, err := NewCutStage(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	split := func(batch *Batch) [][]float64 {
+		t.Helper()
+		out, err := stage.Split(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var values [][]float64
+		for _, b := range out {
+			values = append(values, b.Values)
+		}
+		return values
+	}
+
+	if got := split(&Batch{Source: "a", Values: []float64{1}}); len(got) != 0 {
+		t.Fatalf("one value was cut into %v", got)
+	}
+	// sources are buffered apart
+	if got := split(&Batch{Source: "b", Values: []float64{10, 11, 12}}); !slices.EqualFunc(got, [][]float64{{10, 11}}, slices.Equal) {
+		t.Fatalf("source b was cut into %v", got)
+	}
+	if got := split(&Batch{Source: "a", Values: []float64{2, 3, 4, 5}}); !slices.EqualFunc(got, [][]float64{{1, 2}, {3, 4}}, slices.Equal) {
+		t.Fatalf("source a was cut into %v", got)
+	}
+	// an annotated batch is one block of its own
+	if got := split(&Batch{Source: "a", Values: []float64{7, 8, 9}, Text: "Messung"}); !slices.EqualFunc(got, [][]float64{{7, 8, 9}}, slices.Equal) {
+		t.Fatalf("annotated batch was cut into %v", got)
+	}
+
+	flushed := stage.Flush()
+	if len(flushed) != 2 || flushed[0].Source != "a" || !slices.Equal(flushed[0].Values, []float64{5}) ||
+		flushed[1].Source != "b" || !slices.Equal(flushed[1].Values, []float64{12}) {
+		t.Fatalf("Flush returned %d batches, want the rest of a, then of b", len(flushed))
+	}
+	if flushed := stage.Flush(); len(flushed) != 0 {
+		t.Fatalf("second Flush returned %v, want nothing", flushed)
+	}
+	if err := stage.Process(&Batch{Values: []float64{1}}); err == nil {
+		t.Fatal("CutStage processed a batch outside a pipeline")
+	}
+}
+
+func TestCutStageKeepsOrigins(t *testing.T) {
+	stage, err := NewCutStage(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origins := importOrigins("a.csv", 1, 3)
+	out, err := stage.Split(&Batch{Source: "a", Values: []float64{1, 2, 3}, Origins: origins})
+	if err != nil || len(out) != 1 || !slices.Equal(out[0].Origins, origins[:2]) {
+		t.Fatalf("Split returned %+v, %v, want the origins of the first two values", out, err)
+	}
+	// a batch without origins ends them for its source
+	if out, err = stage.Split(&Batch{Source: "a", Values: []float64{4, 5, 6}}); err != nil || len(out) != 2 || out[0].Origins != nil {
+		t.Fatalf("Split returned %+v, %v, want two blocks without origins", out, err)
+	}
+}
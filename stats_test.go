@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// outlierDataset returns n values alternating between 10.1 and 9.9 with
+// 10.5 and 9.5, 5 SD of the others off their mean, at the given positions
+func outlierDataset(n, high, low int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		switch {
+		case i == high:
+			values[i] = 10.5
+		case i == low:
+			values[i] = 9.5
+		case i%2 == 0:
+			values[i] = 10.1
+		default:
+			values[i] = 9.9
+		}
+	}
+	return values
+}
+
+func TestCalculateBlockStatsFindsOutliers(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		n         int
+		high, low int
+	}{
+		{"small block", 100, 30, 71},
+		{"large block", parallelStatsMin + 100, 20000, 30001},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			values := outlierDataset(tc.n, tc.high, tc.low)
+			// every run sees the bounds the outliers depend on
+			for run := 0; run < 20; run++ {
+				block := &Block{Values: values}
+				calculateBlockStats(block, DefaultOutlierConfig, defaultHistogramBuckets)
+				if len(block.StatsErrors) > 0 {
+					t.Fatalf("stats failed: %v", block.StatsErrors)
+				}
+				if math.Abs(block.Mean-10) > 1e-9 {
+					t.Fatalf("mean is %v, want 10", block.Mean)
+				}
+				if !(block.TwoSDLower > 9.5 && block.TwoSDLower < 9.9 && block.TwoSDUpper > 10.1 && block.TwoSDUpper < 10.5) {
+					t.Fatalf("2-SD range is [%v, %v], want it between the outliers and the other values", block.TwoSDLower, block.TwoSDUpper)
+				}
+				if want := []float64{10.5, 9.5}; !slices.Equal(block.Outliers, want) {
+					t.Fatalf("run %d found outliers %v, want %v in value order", run, block.Outliers, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCalculateBlockStatsWithoutOutliers(t *testing.T) {
+	block := &Block{Values: []float64{3, 3, 3, 3}}
+	calculateBlockStats(block, DefaultOutlierConfig, defaultHistogramBuckets)
+	if block.Mean != 3 || block.Median != 3 || block.TwoSDLower != 3 || block.TwoSDUpper != 3 || len(block.Outliers) != 0 {
+		t.Fatalf("constant values got mean %v, median %v, range [%v, %v] and outliers %v", block.Mean, block.Median, block.TwoSDLower, block.TwoSDUpper, block.Outliers)
+	}
+}
+
+func TestBlockStatsKeepValueOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float64
+		median   float64
+		outliers []float64
+	}{
+		{"descending", []float64{5, 4, 3, 2, 1}, 3, nil},
+		{"unsorted", []float64{3, 1, 2, 4}, 2.5, nil},
+		{"outlier first", []float64{100, 1, 2, 1, 2, 1, 2, 1, 2, 1}, 1.5, []float64{100}},
+		{"outliers on both sides", outlierDataset(40, 35, 4), 10, []float64{9.5, 10.5}},
+		{"single value", []float64{7}, 7, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := slices.Clone(tt.values)
+			if median := calculateMedian(values); median != tt.median || !slices.Equal(values, tt.values) {
+				t.Fatalf("calculateMedian returned %v and left %v, want %v and %v", median, values, tt.median, tt.values)
+			}
+
+			block := &Block{Values: values}
+			calculateBlockStats(block, DefaultOutlierConfig, defaultHistogramBuckets)
+			if !slices.Equal(block.Values, tt.values) || block.Median != tt.median {
+				t.Fatalf("block holds %v with median %v, want %v with %v", block.Values, block.Median, tt.values, tt.median)
+			}
+			if !slices.Equal(block.Outliers, tt.outliers) {
+				t.Fatalf("outliers are %v, want %v", block.Outliers, tt.outliers)
+			}
+			// the outliers are exactly the values outside the final range
+			if want := calculateOutliers(tt.values, block.TwoSDLower, block.TwoSDUpper); !slices.Equal(block.Outliers, want) {
+				t.Fatalf("outliers are %v, but %v lie outside [%v, %v]", block.Outliers, want, block.TwoSDLower, block.TwoSDUpper)
+			}
+		})
+	}
+}
+
+func TestAddBlockVerifiesStats(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := bc.AddBlock(outlierDataset(100, 0, 99))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !block.HasOutliers || block.OutlierCount() != 2 {
+		t.Fatalf("block has %d outliers, want the 2 planted ones", block.OutlierCount())
+	}
+	if err := block.VerifyStats(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
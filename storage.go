@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"slices"
+	"sync"
+)
+
+// Storage keeps the blocks of a chain. Every chain reads its blocks from
+// its storage and appends new blocks to it; chains created without
+// WithStorage keep them in a storage in memory. A chain created with
+// WithStorage loads its state from the storage, so a persistent storage
+// survives a restart. Blocks are appended in order of their indexes, and
+// a storage holds exactly the blocks of its chain: Prune, Compact,
+// ReplaceTail and the other operations that change blocks already
+// appended change the storage too, which requires it to be one of the
+// storages of this package.
+type Storage interface {
+	// AppendBlock stores block after the newest one
+	AppendBlock(block *Block) error
+	// GetBlock returns the block with the given index, or an error
+	// matching ErrBlockNotFound
+	GetBlock(index int) (*Block, error)
+	// LatestBlock returns the newest block, or an error matching
+	// ErrBlockNotFound if there is none
+	LatestBlock() (*Block, error)
+	// Iterate calls fn with every block whose index is in [from, to), in
+	// order, and stops at the first error fn returns
+	Iterate(from, to int, fn func(*Block) error) error
+	// Count returns the number of blocks stored
+	Count() int
+}
+
+// storageRewriter is implemented by storages that can change blocks
+// already stored. rewrite deletes the blocks with an index in [from, to)
+// and stores blocks in place of those with the same index, and state if
+// it is not nil, at once: if it fails, the storage is unchanged.
+// loadState returns the state stored last, nil if there is none.
+type storageRewriter interface {
+	rewrite(from, to int, blocks []*Block, state *chainState) error
+	loadState() (*chainState, error)
+}
+
+// chainState is what a chain keeps besides its blocks: its name and
+// genesis, which it may no longer hold, and the references to the blocks
+// before the oldest one held. The block log and the storages of this
+// package keep it with the blocks, so it survives a restart.
+type chainState struct {
+	Name       string      `json:"name,omitempty"`
+	Genesis    *ChainInfo  `json:"genesis,omitempty"`
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+	Stub       bool        `json:"stub,omitempty"`
+	Archive    *archiveRef `json:"archive,omitempty"`
+	Expired    *expiredRef `json:"expired,omitempty"`
+	// Codec compresses the blocks of a block log, see SetCodec
+	Codec string `json:"codec,omitempty"`
+}
+
+// state returns the current state of the chain. Called with bc.mu held.
+func (bc *Blockchain) state() *chainState {
+	return &chainState{
+		Name:       bc.name,
+		Genesis:    bc.genesis,
+		Checkpoint: bc.checkpoint,
+		Stub:       bc.stub,
+		Archive:    bc.archive,
+		Expired:    bc.expired,
+		Codec:      bc.codec,
+	}
+}
+
+// setState gives the chain state; nil leaves it as it is. Called with
+// bc.mu held.
+func (bc *Blockchain) setState(state *chainState) {
+	if state == nil {
+		return
+	}
+	bc.name, bc.genesis, bc.checkpoint = state.Name, state.Genesis, state.Checkpoint
+	bc.stub, bc.archive, bc.expired = state.Stub, state.Archive, state.Expired
+	bc.codec = state.Codec
+}
+
+// errStorageReadOnly is returned for changes to the blocks of a chain
+// whose storage cannot rewrite them
+var errStorageReadOnly = errors.New("Speicher der Blockchain kann gespeicherte Blöcke nicht ändern")
+
+// walkBatch is the number of blocks walkBack reads from a storage at a
+// time
+const walkBatch = 256
+
+// memoryStorage keeps blocks in a slice. AppendBlock keeps the block it is
+// given, which must not be modified afterwards, so the chain holds each
+// block once; the other methods return copies, so what it holds cannot be
+// changed from outside. The slice is replaced rather than modified when
+// blocks are rewritten, so slices handed out by view stay intact.
+type memoryStorage struct {
+	mu     sync.RWMutex
+	blocks []*Block
+	state  *chainState
+}
+
+// NewMemoryStorage returns an empty Storage in memory, which keeps its
+// blocks as long as it is in use
+func NewMemoryStorage() Storage {
+	return &memoryStorage{}
+}
+
+func (s *memoryStorage) AppendBlock(block *Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.blocks); n > 0 && block.Index != s.blocks[n-1].Index+1 {
+		return fmt.Errorf("%w: Block %d folgt nicht auf Block %d", ErrChainInvalid, block.Index, s.blocks[n-1].Index)
+	}
+	s.blocks = append(s.blocks, block)
+	return nil
+}
+
+func (s *memoryStorage) GetBlock(index int) (*Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pos := s.position(index)
+	if pos < 0 || pos >= len(s.blocks) {
+		return nil, fmt.Errorf("%w: Index %d", ErrBlockNotFound, index)
+	}
+	return copyBlock(s.blocks[pos]), nil
+}
+
+func (s *memoryStorage) LatestBlock() (*Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.blocks) == 0 {
+		return nil, fmt.Errorf("%w: Speicher ist leer", ErrBlockNotFound)
+	}
+	return copyBlock(s.blocks[len(s.blocks)-1]), nil
+}
+
+func (s *memoryStorage) Iterate(from, to int, fn func(*Block) error) error {
+	s.mu.RLock()
+	blocks := s.view(s.position(from), s.position(to))
+	s.mu.RUnlock()
+
+	// fn runs without the lock, so it may use the storage itself
+	for _, block := range blocks {
+		if err := fn(copyBlock(block)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.blocks)
+}
+
+func (s *memoryStorage) rewrite(from, to int, blocks []*Block, state *chainState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byIndex := make(map[int]*Block, len(blocks))
+	for _, block := range blocks {
+		byIndex[block.Index] = block
+	}
+	rewritten := make([]*Block, 0, len(s.blocks)+len(blocks))
+	for _, block := range s.blocks {
+		if block.Index >= from && block.Index < to {
+			continue
+		}
+		if replaced, ok := byIndex[block.Index]; ok {
+			block = replaced
+			delete(byIndex, block.Index)
+		}
+		rewritten = append(rewritten, block)
+	}
+	for _, block := range byIndex {
+		rewritten = append(rewritten, block)
+	}
+	slices.SortFunc(rewritten, func(a, b *Block) int { return a.Index - b.Index })
+	for i := 1; i < len(rewritten); i++ {
+		if rewritten[i].Index != rewritten[i-1].Index+1 {
+			return fmt.Errorf("%w: Lücke vor Block %d", ErrChainInvalid, rewritten[i].Index)
+		}
+	}
+	s.blocks = rewritten
+	if state != nil {
+		s.state = state
+	}
+	return nil
+}
+
+func (s *memoryStorage) loadState() (*chainState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state, nil
+}
+
+// view returns the blocks in the slots [from, to) themselves, limited to
+// the slots there are. The slice is capped, so appends never change it.
+// Called with s.mu held.
+func (s *memoryStorage) view(from, to int) []*Block {
+	from, to = max(from, 0), min(to, len(s.blocks))
+	if from >= to {
+		return nil
+	}
+	return s.blocks[from:to:to]
+}
+
+// position returns the slot a block of the given index has or would have.
+// Called with s.mu held.
+func (s *memoryStorage) position(index int) int {
+	if len(s.blocks) == 0 {
+		return index
+	}
+	return index - s.blocks[0].Index
+}
+
+// blocks returns the blocks in the slots [from, to) of the chain. Those
+// of a memory storage are the ones it holds, which must not be modified;
+// other storages decode them. Called with bc.mu held.
+func (bc *Blockchain) blocks(from, to int) ([]*Block, error) {
+	from, to = max(from, 0), min(to, bc.held)
+	if from >= to {
+		return nil, nil
+	}
+	if mem, ok := bc.storage.(*memoryStorage); ok {
+		mem.mu.RLock()
+		defer mem.mu.RUnlock()
+		return mem.view(from, to), nil
+	}
+	blocks := make([]*Block, 0, to-from)
+	err := bc.storage.Iterate(bc.base+from, bc.base+to, func(block *Block) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err == nil && len(blocks) != to-from {
+		err = fmt.Errorf("%w: Speicher enthält %d statt %d Blöcke ab Block %d", ErrChainInvalid, len(blocks), to-from, bc.base+from)
+	}
+	return blocks, err
+}
+
+// block returns the block in slot pos, like blocks. The head is kept in
+// memory, so reading it needs no storage access. Called with bc.mu held.
+func (bc *Blockchain) block(pos int) (*Block, error) {
+	if pos == bc.held-1 {
+		return bc.head, nil
+	}
+	blocks, err := bc.blocks(pos, pos+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("%w: Index %d", ErrBlockNotFound, bc.base+pos)
+	}
+	return blocks[0], nil
+}
+
+// allBlocks returns every block of the chain, like blocks. Called with
+// bc.mu held.
+func (bc *Blockchain) allBlocks() ([]*Block, error) {
+	return bc.blocks(0, bc.held)
+}
+
+// walkBack calls fn with the blocks of the chain and their slots from the
+// head backwards until fn returns false, reading walkBatch blocks at a
+// time. Called with bc.mu held.
+func (bc *Blockchain) walkBack(fn func(pos int, block *Block) bool) error {
+	for end := bc.held; end > 0; {
+		start := max(end-walkBatch, 0)
+		blocks, err := bc.blocks(start, end)
+		if err != nil {
+			return err
+		}
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if !fn(start+i, blocks[i]) {
+				return nil
+			}
+		}
+		end = start
+	}
+	return nil
+}
+
+// logReadError logs the error of reading blocks for the methods that
+// cannot return it; they go on with the blocks read before the error
+func logReadError(err error) {
+	if err != nil {
+		log.Printf("Blöcke konnten nicht gelesen werden: %v", err)
+	}
+}
+
+// holdBlocks makes blocks the chain, kept in a new storage in memory, for
+// chains built from blocks read elsewhere. Called with bc.mu held.
+func (bc *Blockchain) holdBlocks(blocks []*Block) {
+	bc.storage = &memoryStorage{blocks: blocks}
+	// a storage in memory cannot fail to be read
+	if err := bc.reindex(); err != nil {
+		panic(err)
+	}
+}
+
+// rewriteChain deletes the blocks with an index in [from, to) from the
+// chain, stores blocks in place of those with the same index and gives
+// the chain state, then reindexes it. The storage is changed first; a
+// block log is then rewritten to hold the changed chain, and if that
+// fails the storage gets the old chain back, so both still hold it.
+// Called with bc.mu held.
+func (bc *Blockchain) rewriteChain(from, to int, blocks []*Block, state *chainState) error {
+	rewriter, ok := backendStorage(bc.storage).(storageRewriter)
+	if !ok {
+		return errStorageReadOnly
+	}
+	var old []*Block
+	oldState := bc.state()
+	if bc.log != nil {
+		var err error
+		if old, err = bc.allBlocks(); err != nil {
+			return err
+		}
+	}
+	if err := rewriter.rewrite(from, to, blocks, state); err != nil {
+		return fmt.Errorf("Speicher konnte nicht geändert werden: %w", err)
+	}
+	bc.rewrites++
+	bc.setState(state)
+	if err := bc.reindex(); err != nil || bc.log == nil {
+		return err
+	}
+
+	chain, err := bc.allBlocks()
+	if err == nil {
+		err = bc.log.rewrite(bc.state(), chain)
+	}
+	if err == nil {
+		return nil
+	}
+	if undo := rewriter.rewrite(math.MinInt, math.MaxInt, old, oldState); undo != nil {
+		log.Printf("Speicher konnte nicht zurückgesetzt werden: %v", undo)
+	}
+	bc.setState(oldState)
+	logReadError(bc.reindex())
+	return fmt.Errorf("Blockprotokoll konnte nicht neu geschrieben werden: %w", err)
+}
+
+// openStorageChain returns the chain held by storage, or a new chain
+// created with o whose genesis is appended to an empty storage. A stored
+// chain is validated like one loaded with LoadBlockchainFromFile and must
+// be named as o.chain if that is set. Its blocks stay in the storage; the
+// chain only keeps its indexes and the head.
+func openStorageChain(storage Storage, o *options) (*Blockchain, error) {
+	if storage.Count() == 0 {
+		bc := o.newChain()
+		if err := storage.AppendBlock(bc.head); err != nil {
+			return nil, err
+		}
+		bc.storage = storage
+		if err := bc.reindex(); err != nil {
+			return nil, err
+		}
+		return bc, nil
+	}
+
+	bc, err := chainFromStorage(storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkChainName(bc, o.chain.Name); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// closeStorage closes the storage of a chain if it has to be closed.
+// Called with bc.mu held.
+func (bc *Blockchain) closeStorage() error {
+	if closer, ok := backendStorage(bc.storage).(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// storageBackends are the storages the parity tests run against. open
+// returns a function that opens the backend's storage in dir, again on
+// every call, as a restart would.
+var storageBackends = []struct {
+	name string
+	open func(t *testing.T, dir string) func() Storage
+}{
+	{"memory", func(t *testing.T, dir string) func() Storage {
+		storage := NewMemoryStorage()
+		return func() Storage { return storage }
+	}},
+	{"sqlite", func(t *testing.T, dir string) func() Storage {
+		path := filepath.Join(dir, "chain.db")
+		return func() Storage {
+			storage, err := OpenSQLiteStorage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { storage.Close() })
+			return storage
+		}
+	}},
+}
+
+func TestStorageAppendAndRead(t *testing.T) {
+	source := newFilledChain(t)
+	blocks := source.Blocks()
+
+	for _, backend := range storageBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			storage := backend.open(t, t.TempDir())()
+			if _, err := storage.LatestBlock(); !errors.Is(err, ErrBlockNotFound) {
+				t.Fatalf("LatestBlock of an empty storage returned %v, want ErrBlockNotFound", err)
+			}
+			for _, block := range blocks {
+				if err := storage.AppendBlock(block); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := storage.AppendBlock(blocks[2]); !errors.Is(err, ErrChainInvalid) {
+				t.Fatalf("appending block 2 again returned %v, want ErrChainInvalid", err)
+			}
+
+			if storage.Count() != len(blocks) {
+				t.Fatalf("Count is %d, want %d", storage.Count(), len(blocks))
+			}
+			latest, err := storage.LatestBlock()
+			if err != nil || latest.Hash != blocks[len(blocks)-1].Hash {
+				t.Fatalf("LatestBlock returned %v, %v, want block %d", latest, err, len(blocks)-1)
+			}
+			for _, want := range blocks {
+				got, err := storage.GetBlock(want.Index)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Hash != want.Hash || calculateHash(got) != want.Hash {
+					t.Fatalf("block %d no longer matches its hash", want.Index)
+				}
+			}
+			if _, err := storage.GetBlock(len(blocks)); !errors.Is(err, ErrBlockNotFound) {
+				t.Fatalf("GetBlock past the head returned %v, want ErrBlockNotFound", err)
+			}
+
+			var iterated []*Block
+			err = storage.Iterate(1, 4, func(block *Block) error {
+				iterated = append(iterated, block)
+				return nil
+			})
+			if err != nil || !sameHashes(iterated, blocks[1:4]) {
+				t.Fatalf("Iterate(1, 4) returned %v, %v, want blocks 1 to 3", blockHashes(iterated), err)
+			}
+			stop := errors.New("stop")
+			calls := 0
+			err = storage.Iterate(0, len(blocks), func(*Block) error {
+				calls++
+				return stop
+			})
+			if !errors.Is(err, stop) || calls != 1 {
+				t.Fatalf("Iterate went on after an error: %d calls, %v", calls, err)
+			}
+
+			// what a storage returns cannot change what it holds
+			got, _ := storage.GetBlock(1)
+			got.Values[0] = -1
+			if again, _ := storage.GetBlock(1); again.GetValues()[0] == -1 {
+				t.Fatal("a block returned by GetBlock shares its values with the storage")
+			}
+		})
+	}
+}
+
+func TestStorageChainResumes(t *testing.T) {
+	for _, backend := range storageBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			open := backend.open(t, t.TempDir())
+			bc, err := NewBlockchain(WithStorage(open()), WithChainConfig(ChainConfig{Name: "parity"}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fillChain(t, bc)
+			blocks := bc.Blocks()
+			stats := bc.AggregateStats()
+			inRange := bc.BlocksWithMeanBetween(4, 15)
+			top := bc.TopByOutliers(2)
+			if len(top) != 2 || len(inRange) == 0 {
+				t.Fatalf("queries found %d top blocks and %d in range, the test values are off", len(top), len(inRange))
+			}
+			if err := bc.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			resumed, err := NewBlockchain(WithStorage(open()), WithChainConfig(ChainConfig{Name: "parity"}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resumed.Close()
+			if !sameHashes(resumed.Blocks(), blocks) {
+				t.Fatalf("resumed chain holds %v, want %v", blockHashes(resumed.Blocks()), blockHashes(blocks))
+			}
+			if err := resumed.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if got := resumed.AggregateStats(); got.Blocks != stats.Blocks || got.Values != stats.Values || got.Mean != stats.Mean {
+				t.Fatalf("resumed chain has stats %+v, want %+v", got, stats)
+			}
+			if got := resumed.BlocksWithMeanBetween(4, 15); !sameHashes(got, inRange) {
+				t.Fatalf("BlocksWithMeanBetween returned %v, want %v", blockHashes(got), blockHashes(inRange))
+			}
+			if got := resumed.TopByOutliers(2); !sameHashes(got, top) {
+				t.Fatalf("TopByOutliers returned %v, want %v", blockHashes(got), blockHashes(top))
+			}
+			for _, want := range blocks {
+				got, err := resumed.BlockByID(want.ID)
+				if err != nil || got.Hash != want.Hash {
+					t.Fatalf("BlockByID(%s) returned %v, %v", want.ID, got, err)
+				}
+			}
+			if _, err := NewBlockchain(WithStorage(open()), WithChainConfig(ChainConfig{Name: "other"})); err == nil {
+				t.Fatal("the storage was resumed under another name")
+			}
+		})
+	}
+}
+
+func TestStorageKeepsRewrites(t *testing.T) {
+	for _, backend := range storageBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			open := backend.open(t, t.TempDir())
+			bc, err := NewBlockchain(WithStorage(open()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fillChain(t, bc)
+			ref := Reference{Type: "ticket", URI: "https://example.com/42"}
+			if err := bc.SetReferences(2, []Reference{ref}); err != nil {
+				t.Fatal(err)
+			}
+
+			other, err := bc.Fork("other", 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, values := range chainTestValues[:2] {
+				if _, err := other.AddBlock(values); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tail := other.Blocks()[4:]
+			if err := bc.ReplaceTail(4, tail); err != nil {
+				t.Fatal(err)
+			}
+			want := bc.Blocks()
+			bc.Close()
+
+			resumed, err := NewBlockchain(WithStorage(open()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resumed.Close()
+			if !sameHashes(resumed.Blocks(), want) || !sameHashes(want[4:], tail) {
+				t.Fatalf("resumed chain holds %v, want %v", blockHashes(resumed.Blocks()), blockHashes(want))
+			}
+			block, err := resumed.BlockByIndex(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(block.References) != 1 || block.References[0] != ref {
+				t.Fatalf("block 2 has references %v, want %v", block.References, ref)
+			}
+		})
+	}
+}
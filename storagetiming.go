@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStorageTimingOff is returned for the storage stats of a chain
+// created without WithStorageTiming
+var ErrStorageTimingOff = errors.New("Zeitmessung des Speichers ist nicht eingeschaltet")
+
+// storageLatencyBounds are the upper bounds of the buckets of the storage
+// latency histograms; a last bucket counts the calls slower than all of
+// them
+var storageLatencyBounds = [...]time.Duration{
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second,
+}
+
+// the storage operations that are timed, in the order StorageStats lists
+// them
+const (
+	storageOpAppend = iota
+	storageOpGet
+	storageOpLatest
+	storageOpIterate
+	storageOps
+)
+
+var storageOpNames = [storageOps]string{"append", "get", "latest", "iterate"}
+
+// WithStorageTiming times every call to the storage of WithStorage:
+// StorageStats returns the latency histograms of the calls since the
+// chain was created, and a call slower than slow is logged with the block
+// it was for. 0 turns the log off. Without this option the storage is
+// called directly, so timing costs nothing.
+func WithStorageTiming(slow time.Duration) Option {
+	return func(o *options) {
+		o.storageTiming = true
+		o.slowStorage = slow
+	}
+}
+
+// latencyHistogram counts the calls of one operation per bucket of
+// storageLatencyBounds. It is updated atomically, so calls are not
+// serialized by it.
+type latencyHistogram struct {
+	buckets [len(storageLatencyBounds) + 1]atomic.Int64
+	count   atomic.Int64
+	slow    atomic.Int64
+	nanos   atomic.Int64
+	max     atomic.Int64
+}
+
+// observe counts a call that took d
+func (h *latencyHistogram) observe(d time.Duration, slow bool) {
+	i := 0
+	for i < len(storageLatencyBounds) && d > storageLatencyBounds[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+	h.count.Add(1)
+	h.nanos.Add(int64(d))
+	if slow {
+		h.slow.Add(1)
+	}
+	for {
+		old := h.max.Load()
+		if int64(d) <= old || h.max.CompareAndSwap(old, int64(d)) {
+			return
+		}
+	}
+}
+
+// StorageOpStats are the latencies of one storage operation, see
+// StorageStats. The percentiles are the upper bounds of the buckets they
+// fall in, at most Max.
+type StorageOpStats struct {
+	Op    string        `json:"op"`
+	Count int64         `json:"count"`
+	Slow  int64         `json:"slow"`
+	Total time.Duration `json:"total"`
+	Max   time.Duration `json:"max"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+	// Buckets counts the calls per bucket of Bounds, the last one those
+	// slower than all bounds
+	Buckets []int64 `json:"buckets"`
+}
+
+// StorageStats are the latencies of the storage calls of a chain since
+// Since, see WithStorageTiming
+type StorageStats struct {
+	Since  time.Time        `json:"since"`
+	Slow   time.Duration    `json:"slow"`
+	Bounds []time.Duration  `json:"bounds"`
+	Ops    []StorageOpStats `json:"ops"`
+}
+
+// snapshot returns the stats of the operation op
+func (h *latencyHistogram) snapshot(op string) StorageOpStats {
+	s := StorageOpStats{
+		Op:      op,
+		Count:   h.count.Load(),
+		Slow:    h.slow.Load(),
+		Total:   time.Duration(h.nanos.Load()),
+		Max:     time.Duration(h.max.Load()),
+		Buckets: make([]int64, len(h.buckets)),
+	}
+	var total int64
+	for i := range h.buckets {
+		s.Buckets[i] = h.buckets[i].Load()
+		total += s.Buckets[i]
+	}
+	s.P50 = s.percentile(total, 0.50)
+	s.P95 = s.percentile(total, 0.95)
+	s.P99 = s.percentile(total, 0.99)
+	return s
+}
+
+// percentile returns the upper bound of the bucket holding the call at
+// fraction q of the total calls counted in s.Buckets, at most s.Max
+func (s *StorageOpStats) percentile(total int64, q float64) time.Duration {
+	if total == 0 {
+		return 0
+	}
+	rank := int64(q*float64(total) + 0.5)
+	rank = max(rank, 1)
+	var seen int64
+	for i, n := range s.Buckets {
+		if seen += n; seen >= rank && i < len(storageLatencyBounds) {
+			return min(storageLatencyBounds[i], s.Max)
+		}
+	}
+	return s.Max
+}
+
+// String renders the stats for the storage stats command
+func (s StorageStats) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Speicherzugriffe seit %s", s.Since.Format(time.RFC3339))
+	if s.Slow > 0 {
+		fmt.Fprintf(&sb, ", langsam ab %s", s.Slow)
+	}
+	fmt.Fprintf(&sb, "\n%-8s %8s %8s %10s %10s %10s %10s\n", "Zugriff", "Anzahl", "Langsam", "p50", "p95", "p99", "Max")
+	for _, op := range s.Ops {
+		fmt.Fprintf(&sb, "%-8s %8d %8d %10s %10s %10s %10s\n", op.Op, op.Count, op.Slow,
+			op.P50.Round(time.Microsecond), op.P95.Round(time.Microsecond), op.P99.Round(time.Microsecond), op.Max.Round(time.Microsecond))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// timedStorage times the calls to the Storage it wraps, see
+// WithStorageTiming. The other interfaces of the storage are reached
+// through backendStorage.
+type timedStorage struct {
+	Storage
+	slow  time.Duration
+	since time.Time
+	clock Clock
+	ops   [storageOps]latencyHistogram
+}
+
+// newTimedStorage returns storage timed with slow as the threshold of the
+// slow log
+func newTimedStorage(storage Storage, slow time.Duration) *timedStorage {
+	return &timedStorage{Storage: storage, slow: slow, since: time.Now(), clock: realClock{}}
+}
+
+// backendStorage returns the storage a timedStorage wraps, and any other
+// storage as it is, for the type assertions of the optional interfaces
+func backendStorage(storage Storage) Storage {
+	if timed, ok := storage.(*timedStorage); ok {
+		return timed.Storage
+	}
+	return storage
+}
+
+// done counts a call of op that started at start and spent wait of it
+// outside the storage, and logs it if it was slow. what names the blocks
+// it was for.
+func (s *timedStorage) done(op int, start time.Time, wait time.Duration, what func() string) {
+	d := s.clock.Now().Sub(start) - wait
+	slow := s.slow > 0 && d > s.slow
+	s.ops[op].observe(d, slow)
+	if slow {
+		log.Printf("Langsamer Speicherzugriff: %s %s dauerte %s", storageOpNames[op], what(), d)
+	}
+}
+
+func (s *timedStorage) AppendBlock(block *Block) error {
+	start := s.clock.Now()
+	err := s.Storage.AppendBlock(block)
+	s.done(storageOpAppend, start, 0, func() string { return fmt.Sprintf("von Block %d", block.Index) })
+	return err
+}
+
+func (s *timedStorage) GetBlock(index int) (*Block, error) {
+	start := s.clock.Now()
+	block, err := s.Storage.GetBlock(index)
+	s.done(storageOpGet, start, 0, func() string { return fmt.Sprintf("von Block %d", index) })
+	return block, err
+}
+
+func (s *timedStorage) LatestBlock() (*Block, error) {
+	start := s.clock.Now()
+	block, err := s.Storage.LatestBlock()
+	s.done(storageOpLatest, start, 0, func() string {
+		if block == nil {
+			return "ohne Block"
+		}
+		return fmt.Sprintf("von Block %d", block.Index)
+	})
+	return block, err
+}
+
+// Iterate times the reads of the storage, not the time fn takes
+func (s *timedStorage) Iterate(from, to int, fn func(*Block) error) error {
+	var wait time.Duration
+	start := s.clock.Now()
+	err := s.Storage.Iterate(from, to, func(block *Block) error {
+		called := s.clock.Now()
+		err := fn(block)
+		wait += s.clock.Now().Sub(called)
+		return err
+	})
+	s.done(storageOpIterate, start, wait, func() string {
+		if to-from == 1 {
+			return fmt.Sprintf("von Block %d", from)
+		}
+		return fmt.Sprintf("der Blöcke %d bis %d", from, to-1)
+	})
+	return err
+}
+
+// stats returns the histograms of the calls so far
+func (s *timedStorage) stats() StorageStats {
+	stats := StorageStats{Since: s.since, Slow: s.slow, Bounds: storageLatencyBounds[:]}
+	for op := range s.ops {
+		stats.Ops = append(stats.Ops, s.ops[op].snapshot(storageOpNames[op]))
+	}
+	return stats
+}
+
+// StorageStats returns the latencies of the storage calls of a chain
+// created with WithStorageTiming, and ErrStorageTimingOff for others
+func (bc *Blockchain) StorageStats() (StorageStats, error) {
+	bc.mu.RLock()
+	timed, ok := bc.storage.(*timedStorage)
+	bc.mu.RUnlock()
+	if !ok {
+		return StorageStats{}, ErrStorageTimingOff
+	}
+	return timed.stats(), nil
+}
+
+// writeStorageMetrics writes stats as Prometheus histograms
+func writeStorageMetrics(b *bytes.Buffer, stats StorageStats) {
+	var latency, slow []sample
+	for _, op := range stats.Ops {
+		label := fmt.Sprintf(`op=%q`, op.Op)
+		var cumulative int64
+		for i, bound := range stats.Bounds {
+			cumulative += op.Buckets[i]
+			latency = append(latency, sample{fmt.Sprintf(`_bucket{%s,le="%s"}`, label, formatMetricValue(bound.Seconds())), float64(cumulative)})
+		}
+		latency = append(latency,
+			sample{fmt.Sprintf(`_bucket{%s,le="+Inf"}`, label), float64(op.Count)},
+			sample{"_sum{" + label + "}", op.Total.Seconds()},
+			sample{"_count{" + label + "}", float64(op.Count)},
+		)
+		slow = append(slow, sample{label, float64(op.Slow)})
+	}
+	writeMetric(b, "blockchain_storage_duration_seconds", "histogram", "Dauer der Speicherzugriffe", latency)
+	writeMetric(b, "blockchain_storage_slow_total", "counter", "Speicherzugriffe über der Schwelle für langsame Zugriffe", slow)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	for i := 0; i < 6; i++ {
+		h.observe(50*time.Microsecond, false)
+	}
+	h.observe(30*time.Millisecond, true)
+
+	s := h.snapshot("append")
+	wantBuckets := make([]int64, len(storageLatencyBounds)+1)
+	wantBuckets[0], wantBuckets[8] = 6, 1
+	if !slices.Equal(s.Buckets, wantBuckets) {
+		t.Fatalf("buckets are %v, want %v", s.Buckets, wantBuckets)
+	}
+	if s.Op != "append" || s.Count != 7 || s.Slow != 1 || s.Max != 30*time.Millisecond || s.Total != 30*time.Millisecond+300*time.Microsecond {
+		t.Fatalf("stats are %+v", s)
+	}
+	if s.P50 != 100*time.Microsecond || s.P95 != 30*time.Millisecond || s.P99 != 30*time.Millisecond {
+		t.Fatalf("percentiles are %s, %s, %s", s.P50, s.P95, s.P99)
+	}
+}
+
+func TestLatencyHistogramEmptyAndOverflow(t *testing.T) {
+	var empty latencyHistogram
+	if s := empty.snapshot("get"); s.Count != 0 || s.P50 != 0 || s.P99 != 0 {
+		t.Fatalf("stats of no calls are %+v", s)
+	}
+
+	// a call slower than every bound lands in the last bucket, and the
+	// percentiles falling there are the slowest call
+	var h latencyHistogram
+	h.observe(time.Microsecond, false)
+	h.observe(10*time.Second, false)
+	s := h.snapshot("iterate")
+	if s.Buckets[len(storageLatencyBounds)] != 1 || s.P99 != 10*time.Second || s.P50 != 100*time.Microsecond {
+		t.Fatalf("stats are %+v", s)
+	}
+}
+
+func TestStorageStatsString(t *testing.T) {
+	var h latencyHistogram
+	h.observe(2*time.Millisecond, true)
+	stats := StorageStats{
+		Since: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Slow:  time.Millisecond,
+		Ops:   []StorageOpStats{h.snapshot("append")},
+	}
+	got := stats.String()
+	for _, want := range []string{"seit 2026-01-02T03:04:05Z, langsam ab 1ms", "append", "2ms"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("stats render as\n%s\nwithout %q", got, want)
+		}
+	}
+}
+
+// slowStorage is a storage in memory whose calls take fast on clock, and
+// delay for the block with index slow
+type slowStorage struct {
+	Storage
+	clock       *testClock
+	fast, delay time.Duration
+	slow        int
+}
+
+// take moves the clock on by the time a call for the block index takes
+func (s *slowStorage) take(index int) {
+	if index == s.slow {
+		s.clock.now = s.clock.now.Add(s.delay)
+	} else {
+		s.clock.now = s.clock.now.Add(s.fast)
+	}
+}
+
+func (s *slowStorage) AppendBlock(block *Block) error {
+	s.take(block.Index)
+	return s.Storage.AppendBlock(block)
+}
+
+func (s *slowStorage) GetBlock(index int) (*Block, error) {
+	s.take(index)
+	return s.Storage.GetBlock(index)
+}
+
+func (s *slowStorage) Iterate(from, to int, fn func(*Block) error) error {
+	return s.Storage.Iterate(from, to, func(block *Block) error {
+		s.take(block.Index)
+		return fn(block)
+	})
+}
+
+// newSlowChain returns a chain on a timed slowStorage that takes 50µs a
+// call and 30ms for block 6, logging calls over 10ms. It adds five blocks
+// and clears the log, then adds the slow block 6.
+func newSlowChain(t *testing.T) (*Blockchain, *timedStorage, *bytes.Buffer) {
+	t.Helper()
+	logged := captureLog(t)
+	clock := &testClock{now: time.Now()}
+	slow := &slowStorage{Storage: NewMemoryStorage(), clock: clock, fast: 50 * time.Microsecond, delay: 30 * time.Millisecond, slow: 6}
+	timed := newTimedStorage(slow, 10*time.Millisecond)
+	timed.clock = clock
+	bc, err := NewBlockchain(WithStorage(timed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 6; i++ {
+		if i == 6 {
+			logged.Reset()
+		}
+		if _, err := bc.AddBlock([]float64{float64(i), 2, 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc, timed, logged
+}
+
+// opStats returns the stats of op in stats
+func opStats(t *testing.T, stats StorageStats, op string) StorageOpStats {
+	t.Helper()
+	for _, s := range stats.Ops {
+		if s.Op == op {
+			return s
+		}
+	}
+	t.Fatalf("storage stats have no %s", op)
+	return StorageOpStats{}
+}
+
+func TestStorageTimingSlowLog(t *testing.T) {
+	_, timed, logged := newSlowChain(t)
+	if _, err := timed.GetBlock(6); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := timed.GetBlock(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := timed.Iterate(5, 7, func(*Block) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := timed.Iterate(1, 5, func(*Block) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"Langsamer Speicherzugriff: append von Block 6 dauerte 30ms",
+		"Langsamer Speicherzugriff: get von Block 6 dauerte 30ms",
+		"Langsamer Speicherzugriff: iterate der Blöcke 5 bis 6 dauerte 30.05ms",
+	}, "\n")
+	if got := strings.TrimSpace(logged.String()); got != want {
+		t.Fatalf("slow log is\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStorageTimingHistogram(t *testing.T) {
+	bc, timed, _ := newSlowChain(t)
+	appends := opStats(t, mustStorageStats(t, bc), "append")
+	// the genesis and blocks 1 to 5 take 50µs, block 6 takes 30ms
+	wantBuckets := make([]int64, len(storageLatencyBounds)+1)
+	wantBuckets[0], wantBuckets[8] = 6, 1
+	if !slices.Equal(appends.Buckets, wantBuckets) {
+		t.Fatalf("append buckets are %v, want %v", appends.Buckets, wantBuckets)
+	}
+	if appends.Count != 7 || appends.Slow != 1 || appends.Max != 30*time.Millisecond || appends.Total != 30*time.Millisecond+300*time.Microsecond {
+		t.Fatalf("append stats are %+v", appends)
+	}
+	if appends.P50 != 100*time.Microsecond || appends.P95 != 30*time.Millisecond || appends.P99 != 30*time.Millisecond {
+		t.Fatalf("append percentiles are %s, %s, %s", appends.P50, appends.P95, appends.P99)
+	}
+	// opening the chain reads its head once, which slowStorage does not delay
+	if latest := opStats(t, mustStorageStats(t, bc), "latest"); latest.Count != 1 || latest.Buckets[0] != 1 || latest.P99 != 0 {
+		t.Fatalf("latest stats are %+v, want one instant call", latest)
+	}
+
+	// the time fn takes for each block is not the storage's
+	before := opStats(t, mustStorageStats(t, bc), "iterate")
+	err := timed.Iterate(1, 6, func(*Block) error {
+		timed.clock.(*testClock).now = timed.clock.Now().Add(time.Second)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := opStats(t, mustStorageStats(t, bc), "iterate")
+	if after.Count != before.Count+1 || after.Total-before.Total != 5*50*time.Microsecond || after.Slow != before.Slow {
+		t.Fatalf("iterate stats are %+v after %+v, want one call of 250µs", after, before)
+	}
+
+	metrics := serve(NewMetricsHandler(bc, nil, nil), "GET", "/metrics", "")
+	for _, want := range []string{
+		"# TYPE blockchain_storage_duration_seconds histogram",
+		`blockchain_storage_duration_seconds_bucket{op="append",le="0.0001"} 6`,
+		`blockchain_storage_duration_seconds_bucket{op="append",le="0.025"} 6`,
+		`blockchain_storage_duration_seconds_bucket{op="append",le="0.05"} 7`,
+		`blockchain_storage_duration_seconds_bucket{op="append",le="+Inf"} 7`,
+		`blockchain_storage_duration_seconds_sum{op="append"} 0.0303`,
+		`blockchain_storage_duration_seconds_count{op="append"} 7`,
+		`blockchain_storage_slow_total{op="append"} 1`,
+		`blockchain_storage_slow_total{op="get"} 0`,
+	} {
+		if !strings.Contains(metrics.Body.String(), want) {
+			t.Fatalf("metrics do not contain %q:\n%s", want, metrics.Body)
+		}
+	}
+}
+
+// mustStorageStats returns the storage stats of bc
+func mustStorageStats(t *testing.T, bc *Blockchain) StorageStats {
+	t.Helper()
+	stats, err := bc.StorageStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestStorageTimingOff(t *testing.T) {
+	plain, err := NewBlockchain(WithStorage(NewMemoryStorage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := plain.storage.(*timedStorage); ok {
+		t.Fatal("storage is timed without WithStorageTiming")
+	}
+	if _, err := plain.StorageStats(); !errors.Is(err, ErrStorageTimingOff) {
+		t.Fatalf("StorageStats without timing returned %v, want ErrStorageTimingOff", err)
+	}
+	if metrics := serve(NewMetricsHandler(plain, nil, nil), "GET", "/metrics", ""); strings.Contains(metrics.Body.String(), "blockchain_storage_") {
+		t.Fatal("metrics of an untimed storage contain storage latencies")
+	}
+	if _, err := NewBlockchain(WithStorageTiming(time.Second)); err == nil {
+		t.Fatal("storage timing without a storage was accepted")
+	}
+	if _, err := NewBlockchain(WithStorage(NewMemoryStorage()), WithStorageTiming(-time.Second)); err == nil {
+		t.Fatal("negative slow threshold was accepted")
+	}
+}
+
+func TestStorageTimingKeepsSQLiteOperations(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := openSQLiteChain(filepath.Join(dir, "chain.db"), ChainConfig{}, WithStorageTiming(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	for _, values := range bulkRows(30, 10) {
+		if _, err := bc.AddBlock(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// pruning rewrites and vacuuming compacts the timed storage
+	if _, err := bc.Prune(5, filepath.Join(dir, "archive.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.VacuumStorage(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if appends := opStats(t, mustStorageStats(t, bc), "append"); appends.Count != 31 || appends.Slow != 0 {
+		t.Fatalf("append stats are %+v, want 31 calls", appends)
+	}
+}
+
+func TestAPIStorageStats(t *testing.T) {
+	bc, _, _ := newSlowChain(t)
+	var stats StorageStats
+	decodeResponse(t, serve(NewAPIHandler(bc, NewDefaultPipeline(bc)), "GET", "/storage/stats", ""), http.StatusOK, &stats)
+	if appends := opStats(t, stats, "append"); appends.Count != 7 || appends.P99 != 30*time.Millisecond || stats.Slow != 10*time.Millisecond {
+		t.Fatalf("GET /storage/stats returned %+v", stats)
+	}
+	if len(stats.Bounds) != len(storageLatencyBounds) {
+		t.Fatalf("GET /storage/stats has %d bounds, want %d", len(stats.Bounds), len(storageLatencyBounds))
+	}
+
+	_, memory := newTestAPI(t)
+	expectAPIError(t, serve(memory, "GET", "/storage/stats", ""), http.StatusNotFound)
+}
+
+func TestCLIStorageStats(t *testing.T) {
+	bc, _, _ := newSlowChain(t)
+	var out bytes.Buffer
+	RunCLI(bc, strings.NewReader("storage stats\n"), &out)
+	for _, want := range []string{"langsam ab 10ms", "append", "30ms"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("storage stats output does not contain %q:\n%s", want, out.String())
+		}
+	}
+
+	plain, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	RunCLI(plain, strings.NewReader("storage stats\n"), &out)
+	if !strings.Contains(out.String(), ErrStorageTimingOff.Error()) {
+		t.Fatalf("storage stats without timing printed:\n%s", out.String())
+	}
+}
+
+func BenchmarkStorageTiming(b *testing.B) {
+	for _, timing := range []bool{false, true} {
+		opts := []Option{WithStorage(NewMemoryStorage())}
+		if timing {
+			opts = append(opts, WithStorageTiming(time.Second))
+		}
+		bc, err := NewBlockchain(opts...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := bc.AddBlocksBulk(bulkRows(1000, 10), 0); err != nil {
+			b.Fatal(err)
+		}
+		b.Run("BlockByIndex/timing="+strconv.FormatBool(timing), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := bc.BlockByIndex(1 + i%999); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// localeSampleRows is the number of CSV records read ahead to infer the
+// number format of each column when the locale is LocaleAuto
+const localeSampleRows = 1000
+
+// errStopStream ends a stream early without reporting an error
+var errStopStream = errors.New("stop stream")
+
+// CSVImportOptions says how the records of a CSV file are laid out
+type CSVImportOptions struct {
+	// HasHeader skips the first record, which names the columns
+	HasHeader bool
+	// Columns selects columns by their header name, ignoring case; empty
+	// keeps all columns. It requires HasHeader.
+	Columns []string
+	// Delimiter is the field separator; 0 detects , or ;
+	Delimiter rune
+	// Timed reads records of two cells, the time of a sample and its
+	// value, after column selection. The time is RFC 3339 or else unix
+	// seconds and is returned in sourceRow.Time.
+	Timed bool
+}
+
+// StreamOptions controls StreamFile
+type StreamOptions struct {
+	// Locale is the number format; empty uses the current locale
+	Locale NumberLocale
+	CSV    CSVImportOptions
+	// SkipBadRows skips rows that are not numbers instead of failing
+	SkipBadRows bool
+}
+
+// StreamDataFromFile calls handler with the values of every non-empty row
+// of a csv, tsv, json or xlsx file, reading one row at a time. It stops at
+// the first row that is not numbers or that handler fails, and returns a
+// RowError with its line.
+func StreamDataFromFile(path, format string, handler func(row []float64) error) error {
+	return StreamOptions{}.StreamFile(path, format, handler)
+}
+
+// StreamFile is StreamDataFromFile with options
+func (o StreamOptions) StreamFile(path, format string, handler func(row []float64) error) error {
+	locale := o.Locale
+	if locale == "" {
+		locale = currentNumberLocale()
+	}
+	return streamSourceRows(path, format, locale, o.CSV, nil, func(row sourceRow) error {
+		if row.Err != nil {
+			if o.SkipBadRows {
+				return nil
+			}
+			return RowError{Line: row.Line, Err: row.Err}
+		}
+		if len(row.Values) == 0 {
+			return nil
+		}
+		if err := handler(row.Values); err != nil {
+			return RowError{Line: row.Line, Err: err}
+		}
+		return nil
+	})
+}
+
+// streamSourceRows reads the rows of a file one at a time and calls fn
+// with each, including empty rows and rows carrying their parse error.
+// An empty format is inferred from the file's extension. columns, counted
+// from 1, selects columns by number for every format.
+// An error from fn ends the stream and is returned, except errStopStream.
+func streamSourceRows(path, format string, locale NumberLocale, csvOpts CSVImportOptions, columns []int, fn func(sourceRow) error) error {
+	if len(csvOpts.Columns) > 0 && !csvOpts.HasHeader {
+		return errors.New("Spaltennamen setzen eine Kopfzeile voraus")
+	}
+	if len(csvOpts.Columns) > 0 && len(columns) > 0 {
+		return errors.New("Spalten können nur nach Nummer oder nach Name gewählt werden")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if format == "" {
+		format = formatFromPath(path)
+	}
+	switch format {
+	case "csv", "tsv":
+		if format == "tsv" && csvOpts.Delimiter == 0 {
+			csvOpts.Delimiter = '\t'
+		}
+		err = streamCSV(file, locale, csvOpts, columns, fn)
+	case "xlsx":
+		if len(csvOpts.Columns) > 0 {
+			return errors.New("Spaltennamen gibt es nur für CSV-Dateien")
+		}
+		if csvOpts.Timed {
+			return errors.New("Zeitpunkte gibt es nur für CSV-Dateien")
+		}
+		err = streamXLSX(file, csvOpts.HasHeader, columns, fn)
+	case "json":
+		if len(csvOpts.Columns) > 0 {
+			return errors.New("Spaltennamen gibt es nur für CSV-Dateien")
+		}
+		if csvOpts.Timed {
+			return errors.New("Zeitpunkte gibt es nur für CSV-Dateien")
+		}
+		err = streamJSON(file, columns, fn)
+	default:
+		return fmt.Errorf("Ungültiges Dateiformat: %s", format)
+	}
+	if err == errStopStream {
+		return nil
+	}
+	return err
+}
+
+// streamCSV implements streamSourceRows for CSV. Columns are selected
+// before the cells are parsed, so unselected columns may hold text. With
+// LocaleAuto the column formats are inferred from the first
+// localeSampleRows records.
+func streamCSV(r io.Reader, locale NumberLocale, opts CSVImportOptions, columns []int, fn func(sourceRow) error) error {
+	buffered := bufio.NewReader(r)
+	reader := csv.NewReader(buffered)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = locale != LocaleAuto
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+	} else {
+		// ohne Trennzeichen Semikolon wenn die erste Zeile eines enthält
+		head, _ := buffered.Peek(4096)
+		firstLine, _, _ := strings.Cut(string(head), "\n")
+		if strings.Contains(firstLine, ";") {
+			reader.Comma = ';'
+		}
+	}
+
+	var header []string
+	if opts.HasHeader {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		header = append(header, record...)
+		if len(header) > 0 {
+			header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		}
+	}
+	selection, err := selectCSVColumns(header, opts.Columns, columns)
+	if err != nil {
+		return err
+	}
+
+	type csvRecord struct {
+		line   int
+		fields []string
+		err    error
+	}
+	read := func() (csvRecord, bool, error) {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return csvRecord{}, false, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return csvRecord{line: parseErr.Line, err: parseErr.Err}, true, nil
+		}
+		if err != nil {
+			return csvRecord{}, false, err
+		}
+		line, _ := reader.FieldPos(0)
+		if isBlankRecord(fields) {
+			return csvRecord{line: line}, true, nil
+		}
+		fields, err = selection.project(fields)
+		return csvRecord{line: line, fields: fields, err: err}, true, nil
+	}
+
+	var sample []csvRecord
+	if locale == LocaleAuto {
+		for len(sample) < localeSampleRows {
+			record, ok, err := read()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			sample = append(sample, record)
+		}
+	}
+	// the time cell of a timed record is not a number
+	numbers, label := func(fields []string) []string { return fields }, selection.label
+	if opts.Timed {
+		numbers = func(fields []string) []string { return fields[min(1, len(fields)):] }
+		label = func(col int) string { return selection.label(col + 1) }
+	}
+
+	var fields [][]string
+	for _, record := range sample {
+		fields = append(fields, numbers(record.fields))
+	}
+	locales, err := recordLocales(fields, locale)
+	var formatErr *NumberFormatError
+	if errors.As(err, &formatErr) {
+		// report the column by its label and the rows by their line
+		lines := make([]string, len(formatErr.Rows))
+		for i, row := range formatErr.Rows {
+			lines[i] = strconv.Itoa(sample[row-1].line)
+		}
+		return fmt.Errorf("Spalte %s: %s in Zeile %s", label(formatErr.Column-1), formatErr.Reason, strings.Join(lines, ", "))
+	}
+	if err != nil {
+		return err
+	}
+
+	emit := func(record csvRecord) error {
+		row := sourceRow{Line: record.line, Err: record.err}
+		if row.Err == nil && !isBlankRecord(record.fields) && opts.Timed {
+			if len(record.fields) != 2 {
+				row.Err = fmt.Errorf("%d Spalten, erwartet Zeitpunkt und Wert", len(record.fields))
+			} else {
+				row.Time, row.Err = parseSampleTime(record.fields[0])
+			}
+		}
+		if row.Err == nil && !isBlankRecord(record.fields) {
+			cells := numbers(record.fields)
+			if locales, row.Err = extendLocales(locales, cells, locale); row.Err == nil {
+				row.Values, row.Err = parseNumberRecord(cells, locales, label)
+			}
+		}
+		return fn(row)
+	}
+	for _, record := range sample {
+		if err := emit(record); err != nil {
+			return err
+		}
+	}
+	for {
+		record, ok, err := read()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if err := emit(record); err != nil {
+			return err
+		}
+	}
+}
+
+// csvSelection is the columns of a CSV file that are read
+type csvSelection struct {
+	// indexes are the selected columns, counted from 0; nil selects all
+	indexes []int
+	labels  []string
+}
+
+// selectCSVColumns resolves column names against header, or column
+// numbers counted from 1
+func selectCSVColumns(header, names []string, numbers []int) (csvSelection, error) {
+	var selection csvSelection
+	for _, number := range numbers {
+		selection.indexes = append(selection.indexes, number-1)
+		selection.labels = append(selection.labels, strconv.Itoa(number))
+	}
+	for _, name := range names {
+		index := slices.IndexFunc(header, func(column string) bool {
+			return strings.EqualFold(strings.TrimSpace(column), strings.TrimSpace(name))
+		})
+		if index < 0 {
+			return csvSelection{}, fmt.Errorf("Spalte %q fehlt in der Kopfzeile (vorhanden: %s)", name, strings.Join(header, ", "))
+		}
+		selection.indexes = append(selection.indexes, index)
+		selection.labels = append(selection.labels, strings.TrimSpace(header[index]))
+	}
+	return selection, nil
+}
+
+// project returns the selected cells of a record
+func (s csvSelection) project(fields []string) ([]string, error) {
+	if s.indexes == nil {
+		return fields, nil
+	}
+	selected := make([]string, len(s.indexes))
+	for i, index := range s.indexes {
+		if index >= len(fields) {
+			return nil, fmt.Errorf("keine Spalte %s", s.labels[i])
+		}
+		selected[i] = fields[index]
+	}
+	return selected, nil
+}
+
+// label names the selected column col, counted from 0, in messages
+func (s csvSelection) label(col int) string {
+	if s.indexes == nil {
+		return strconv.Itoa(col + 1)
+	}
+	return s.labels[col]
+}
+
+// streamJSON implements streamSourceRows for a JSON array of number
+// arrays. Line is the position of the row in the array.
+func streamJSON(r io.Reader, columns []int, fn func(sourceRow) error) error {
+	decoder := json.NewDecoder(r)
+	if token, err := decoder.Token(); err != nil {
+		return err
+	} else if token != json.Delim('[') {
+		return fmt.Errorf("JSON-Datei: Liste von Zeilen erwartet")
+	}
+
+	for line := 1; decoder.More(); line++ {
+		row := sourceRow{Line: line}
+		if err := decoder.Decode(&row.Values); err != nil {
+			// a value of the wrong type is consumed whole, so the next
+			// row can still be read
+			var typeErr *json.UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				return err
+			}
+			row.Values, row.Err = nil, fmt.Errorf("keine Liste von Zahlen")
+		}
+		if row.Err == nil && len(row.Values) > 0 && len(columns) > 0 {
+			row.Values, row.Err = selectColumns(row.Values, columns)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	_, err := decoder.Token()
+	return err
+}
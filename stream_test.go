@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStreamCSVColumns(t *testing.T) {
+	dir := writeImportFiles(t, map[string]string{
+		"messung.csv":   "\ufeffZeit, Temperatur ,Druck,Ort\n1,20.5,1013,Halle\n2,21,1012,Halle\n3,,1011,Hof\n",
+		"ohne.csv":      "1,2,3\n4,5,6\n",
+		"semikolon.csv": "Zeit;Temperatur\n1;20,5\n",
+	})
+	tests := []struct {
+		name string
+		file string
+		opts StreamOptions
+		rows [][]float64
+		err  string
+	}{
+		{
+			name: "by name ignoring case and spaces",
+			file: "messung.csv",
+			opts: StreamOptions{Locale: LocaleEnglish, CSV: CSVImportOptions{HasHeader: true, Columns: []string{"druck", "temperatur"}}},
+			err:  "Zeile 4: Spalte Temperatur ist leer",
+			rows: [][]float64{{1013, 20.5}, {1012, 21}},
+		},
+		{
+			name: "skipping bad rows",
+			file: "messung.csv",
+			opts: StreamOptions{Locale: LocaleEnglish, SkipBadRows: true, CSV: CSVImportOptions{HasHeader: true, Columns: []string{"Zeit", "Temperatur"}}},
+			rows: [][]float64{{1, 20.5}, {2, 21}},
+		},
+		{
+			name: "first column named through the byte order mark",
+			file: "messung.csv",
+			opts: StreamOptions{Locale: LocaleEnglish, CSV: CSVImportOptions{HasHeader: true, Columns: []string{"Zeit"}}},
+			rows: [][]float64{{1}, {2}, {3}},
+		},
+		{
+			name: "unknown column",
+			file: "messung.csv",
+			opts: StreamOptions{CSV: CSVImportOptions{HasHeader: true, Columns: []string{"Feuchte"}}},
+			err:  `Spalte "Feuchte" fehlt in der Kopfzeile (vorhanden: Zeit,  Temperatur , Druck, Ort)`,
+		},
+		{
+			name: "names without a header",
+			file: "ohne.csv",
+			opts: StreamOptions{CSV: CSVImportOptions{Columns: []string{"a"}}},
+			err:  "Spaltennamen setzen eine Kopfzeile voraus",
+		},
+		{
+			name: "without a header",
+			file: "ohne.csv",
+			opts: StreamOptions{Locale: LocaleEnglish},
+			rows: [][]float64{{1, 2, 3}, {4, 5, 6}},
+		},
+		{
+			name: "semicolons detected",
+			file: "semikolon.csv",
+			opts: StreamOptions{Locale: LocaleGerman, CSV: CSVImportOptions{HasHeader: true, Columns: []string{"Temperatur"}}},
+			rows: [][]float64{{20.5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rows [][]float64
+			err := tt.opts.StreamFile(filepath.Join(dir, tt.file), "", func(row []float64) error {
+				rows = append(rows, row)
+				return nil
+			})
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error is %v, want %q", err, tt.err)
+			}
+			if !slices.EqualFunc(rows, tt.rows, slices.Equal) {
+				t.Fatalf("rows are %v, want %v", rows, tt.rows)
+			}
+		})
+	}
+}
+
+func TestStreamStopsAtHandlerError(t *testing.T) {
+	dir := writeImportFiles(t, map[string]string{"werte.csv": "1\n2\n3\n"})
+	stop := errors.New("genug")
+	calls := 0
+	err := StreamDataFromFile(filepath.Join(dir, "werte.csv"), "csv", func([]float64) error {
+		calls++
+		if calls == 2 {
+			return stop
+		}
+		return nil
+	})
+	var rowErr RowError
+	if !errors.As(err, &rowErr) || rowErr.Line != 2 || !errors.Is(err, stop) || calls != 2 {
+		t.Fatalf("stream returned %v after %d rows, want the handler's error at line 2", err, calls)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// summaryPercentiles are the percentiles stored on every block, keyed as
+// "p5", "p25" and so on
+var summaryPercentiles = []float64{5, 25, 75, 95, 99}
+
+// defaultHistogramBuckets is the number of histogram buckets of a new
+// Blockchain
+const defaultHistogramBuckets = 10
+
+// maxHistogramBuckets bounds the buckets per block
+const maxHistogramBuckets = 1000
+
+// histogramBarWidth is the length of the longest bar of SummaryString
+const histogramBarWidth = 40
+
+// SetHistogramBuckets sets the number of equal-width buckets spanning the
+// minimum to the maximum of each new block's values that are counted in
+// Block.Histogram. 0 turns the histogram off.
+func (bc *Blockchain) SetHistogramBuckets(buckets int) error {
+	if buckets < 0 || buckets > maxHistogramBuckets {
+		return fmt.Errorf("Ungültige Anzahl Histogrammklassen: %d (erlaubt 0 bis %d)", buckets, maxHistogramBuckets)
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.histogramBuckets = buckets
+	return nil
+}
+
+// HistogramBuckets returns the number of histogram buckets of new blocks
+func (bc *Blockchain) HistogramBuckets() int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.histogramBuckets
+}
+
+// summarizeValues sets the percentiles and the histogram of a block from
+// all of its values
+func summarizeValues(block *Block, values []float64, buckets int) {
+	if len(values) == 0 {
+		return
+	}
+	sorted := slices.Clone(values)
+	sort.Float64s(sorted)
+	summarizeSorted(block, sorted, buckets)
+}
+
+// summarizeSorted is summarizeValues for values sorted already
+func summarizeSorted(block *Block, sorted []float64, buckets int) {
+	block.Percentiles = make(map[string]float64, len(summaryPercentiles))
+	for _, p := range summaryPercentiles {
+		block.Percentiles[percentileKey(p)] = quantileSorted(sorted, p)
+	}
+
+	if buckets == 0 {
+		return
+	}
+	lo, hi := sorted[0], sorted[len(sorted)-1]
+	block.Histogram = make([]int, buckets)
+	for _, v := range sorted {
+		bucket := 0
+		if hi > lo {
+			bucket = min(int((v-lo)/(hi-lo)*float64(buckets)), buckets-1)
+		}
+		block.Histogram[bucket]++
+	}
+}
+
+// percentileKey names percentile p in Block.Percentiles
+func percentileKey(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'g', -1, 64)
+}
+
+// valueRange returns the smallest and the largest value the block was
+// created with, which its histogram spans
+func (b *Block) valueRange() (lo, hi float64) {
+	switch {
+	case b.Kind == KindInt && b.IntStats != nil:
+		return float64(b.IntStats.Min), float64(b.IntStats.Max)
+	case b.Sampled:
+		return b.SampleMin, b.SampleMax
+	case b.storedCount() > 0:
+		values := b.GetValues()
+		return slices.Min(values), slices.Max(values)
+	}
+	return math.NaN(), math.NaN()
+}
+
+// SummaryString renders the distribution of the block for the terminal:
+// its percentiles and its histogram as bars of #
+func (b *Block) SummaryString() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Block %d: %d Werte, Mittelwert %.2f, Median %.2f\n", b.Index, b.ValueCount(), b.Mean, b.Median)
+	if len(b.Percentiles) == 0 {
+		sb.WriteString("Keine Verteilung gespeichert\n")
+		return sb.String()
+	}
+
+	parts := make([]string, len(summaryPercentiles))
+	for i, p := range summaryPercentiles {
+		key := percentileKey(p)
+		parts[i] = fmt.Sprintf("%s %.2f", key, b.Percentiles[key])
+	}
+	fmt.Fprintf(&sb, "Perzentile: %s\n", strings.Join(parts, ", "))
+
+	if len(b.Histogram) == 0 {
+		return sb.String()
+	}
+	lo, hi := b.valueRange()
+	width := (hi - lo) / float64(len(b.Histogram))
+	peak := slices.Max(b.Histogram)
+	for i, count := range b.Histogram {
+		bar := 0
+		if peak > 0 {
+			bar = (count*histogramBarWidth + peak - 1) / peak
+		}
+		closing := ")"
+		if i == len(b.Histogram)-1 {
+			closing = "]"
+		}
+		fmt.Fprintf(&sb, "[%10.2f, %10.2f%s %-*s %d\n", lo+float64(i)*width, lo+float64(i+1)*width, closing, histogramBarWidth, strings.Repeat("#", bar), count)
+	}
+	return sb.String()
+}
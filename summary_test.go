@@ -0,0 +1,85 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBlockSummary(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]float64, 101)
+	for i := range values {
+		values[len(values)-1-i] = float64(i)
+	}
+	block, err := bc.AddBlock(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"p5": 5, "p25": 25, "p75": 75, "p95": 95, "p99": 99}
+	if !maps.Equal(block.Percentiles, want) {
+		t.Fatalf("percentiles are %v, want %v", block.Percentiles, want)
+	}
+	// the maximum falls into the last bucket
+	if histogram := []int{10, 10, 10, 10, 10, 10, 10, 10, 10, 11}; !slices.Equal(block.Histogram, histogram) {
+		t.Fatalf("histogram is %v, want %v", block.Histogram, histogram)
+	}
+
+	summary := block.SummaryString()
+	for _, line := range []string{
+		"Block 1: 101 Werte, Mittelwert 50.00, Median 50.00\n",
+		"Perzentile: p5 5.00, p25 25.00, p75 75.00, p95 95.00, p99 99.00\n",
+		"[      0.00,      10.00) " + strings.Repeat("#", 37) + "    10\n",
+		"[     90.00,     100.00] " + strings.Repeat("#", histogramBarWidth) + " 11\n",
+	} {
+		if !strings.Contains(summary, line) {
+			t.Fatalf("summary lacks %q:\n%s", line, summary)
+		}
+	}
+	if genesis, _ := bc.BlockByIndex(0); !strings.Contains(genesis.SummaryString(), "Keine Verteilung gespeichert") {
+		t.Fatalf("summary of genesis is %q", genesis.SummaryString())
+	}
+
+	// equal values all count in the first bucket
+	if err := bc.SetHistogramBuckets(3); err != nil {
+		t.Fatal(err)
+	}
+	if block, err = bc.AddBlock([]float64{4, 4, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(block.Histogram, []int{3, 0, 0}) || block.Percentiles["p99"] != 4 {
+		t.Fatalf("equal values have histogram %v and percentiles %v", block.Histogram, block.Percentiles)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetHistogramBuckets(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, buckets := range []int{-1, maxHistogramBuckets + 1} {
+		if err := bc.SetHistogramBuckets(buckets); err == nil {
+			t.Fatalf("%d buckets accepted", buckets)
+		}
+	}
+	if bc.HistogramBuckets() != defaultHistogramBuckets {
+		t.Fatalf("buckets are %d after rejected settings", bc.HistogramBuckets())
+	}
+	if err := bc.SetHistogramBuckets(0); err != nil {
+		t.Fatal(err)
+	}
+	block, err := bc.AddBlock([]float64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Histogram != nil || len(block.Percentiles) != len(summaryPercentiles) {
+		t.Fatalf("block without histogram has %v and percentiles %v", block.Histogram, block.Percentiles)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample is a value with the time it was measured
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// AddTimedValues adds a block of values that carry their own measuring
+// times, stored in Block.ValueTimes and covered by the hash. The samples
+// are sorted by time, keeping the order of samples with the same time, so
+// the block holds them oldest first. The block's Timestamp is still the
+// time it was added. A chain may mix timed and untimed blocks; KindInt
+// chains take no timed values.
+func (bc *Blockchain) AddTimedValues(samples []Sample) (*Block, error) {
+	values, times, err := splitSamples(samples)
+	if err != nil {
+		return nil, err
+	}
+	block, err := bc.addBlock(blockPayload{values: values, times: times})
+	if err != nil {
+		return nil, err
+	}
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return copyBlock(block), nil
+}
+
+// splitSamples sorts a copy of samples by time and returns their values
+// and times as parallel slices. Samples without a time are rejected.
+func splitSamples(samples []Sample) ([]float64, []time.Time, error) {
+	sorted := slices.Clone(samples)
+	for i, sample := range sorted {
+		if sample.Time.IsZero() {
+			return nil, nil, fmt.Errorf("Wert %d hat keinen Zeitpunkt", i)
+		}
+		// the hash encodes the time in nanoseconds since 1970
+		if !time.Unix(0, sample.Time.UnixNano()).Equal(sample.Time) {
+			return nil, nil, fmt.Errorf("Zeitpunkt %v von Wert %d liegt außerhalb von 1678 bis 2262", sample.Time, i)
+		}
+	}
+	slices.SortStableFunc(sorted, func(a, b Sample) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	values := make([]float64, len(sorted))
+	times := make([]time.Time, len(sorted))
+	for i, sample := range sorted {
+		values[i], times[i] = sample.Value, sample.Time
+	}
+	return values, times, nil
+}
+
+// Samples returns the values of a timed block with their times, or nil for
+// a block without ValueTimes
+func (b *Block) Samples() []Sample {
+	if b.ValueTimes == nil {
+		return nil
+	}
+	values := b.GetValues()
+	samples := make([]Sample, len(values))
+	for i, v := range values {
+		samples[i] = Sample{Time: b.ValueTimes[i], Value: v}
+	}
+	return samples
+}
+
+// valuesWithin returns the values of the block that lie in r: those
+// measured in r for a timed block, all of them if the block's Timestamp
+// lies in r otherwise. Values of KindInt blocks are converted.
+func (b *Block) valuesWithin(r TimeRange) []float64 {
+	if b.ValueTimes != nil {
+		all := b.GetValues()
+		var values []float64
+		for i := range min(len(all), len(b.ValueTimes)) {
+			if r.Contains(b.ValueTimes[i]) {
+				values = append(values, all[i])
+			}
+		}
+		return values
+	}
+	if !r.Contains(b.Timestamp) {
+		return nil
+	}
+	values := slices.Clone(b.GetValues())
+	for _, v := range b.IntValues {
+		values = append(values, float64(v))
+	}
+	return values
+}
+
+// overlaps reports whether any value of the block lies in r, see
+// valuesWithin
+func (b *Block) overlaps(r TimeRange) bool {
+	if b.ValueTimes == nil {
+		return r.Contains(b.Timestamp)
+	}
+	return slices.ContainsFunc(b.ValueTimes, r.Contains)
+}
+
+// maxUnixSeconds bounds the unix seconds of a sample time, which must fit
+// in nanoseconds
+const maxUnixSeconds = math.MaxInt64 / 1e9
+
+// parseSampleTime parses the time cell of a timed CSV row: RFC 3339, or
+// else unix seconds, possibly with a fraction
+func parseSampleTime(cell string) (time.Time, error) {
+	cell = strings.TrimSpace(cell)
+	if t, err := time.Parse(time.RFC3339Nano, cell); err == nil {
+		return t, nil
+	}
+	seconds, err := strconv.ParseFloat(cell, 64)
+	if err != nil || !(math.Abs(seconds) < maxUnixSeconds) {
+		return time.Time{}, fmt.Errorf("ungültiger Zeitpunkt %q, erwartet RFC 3339 oder Unix-Sekunden", cell)
+	}
+	whole := int64(seconds)
+	return time.Unix(whole, int64((seconds-float64(whole))*1e9)).UTC(), nil
+}
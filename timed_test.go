@@ -0,0 +1,127 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAddTimedValues(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	block, err := bc.AddTimedValues([]Sample{
+		{start.Add(2 * time.Second), 3},
+		{start, 1},
+		{start.Add(time.Second), 2},
+		{start, 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sorted by time, samples with the same time keep their order
+	if !slices.Equal(block.Values, []float64{1, 4, 2, 3}) {
+		t.Fatalf("values are %v, want 1 4 2 3", block.Values)
+	}
+	wantTimes := []time.Time{start, start, start.Add(time.Second), start.Add(2 * time.Second)}
+	if !slices.EqualFunc(block.ValueTimes, wantTimes, time.Time.Equal) {
+		t.Fatalf("times are %v, want %v", block.ValueTimes, wantTimes)
+	}
+	if samples := block.Samples(); len(samples) != 4 || samples[3] != (Sample{start.Add(2 * time.Second), 3}) {
+		t.Fatalf("samples are %v", samples)
+	}
+
+	if _, err := bc.AddTimedValues([]Sample{{start, 1}, {time.Time{}, 2}}); err == nil {
+		t.Fatal("a sample without a time was accepted")
+	}
+	if _, err := bc.AddTimedValues([]Sample{{time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC), 1}}); err == nil {
+		t.Fatal("a time past 2262 was accepted")
+	}
+	if bc.Length() != 2 {
+		t.Fatalf("chain holds %d blocks, want 2", bc.Length())
+	}
+}
+
+func TestTimedBlockPersistsAndIsHashed(t *testing.T) {
+	bc := newFilledChain(t)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if _, err := bc.AddTimedValues([]Sample{{start, 20.5}, {start.Add(time.Minute), 21}}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), defaultChainFile)
+	if err := bc.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBlockchainFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	head := loaded.LatestBlock()
+	if !slices.EqualFunc(head.ValueTimes, []time.Time{start, start.Add(time.Minute)}, time.Time.Equal) {
+		t.Fatalf("loaded times are %v", head.ValueTimes)
+	}
+
+	// the hash covers the times
+	tampered := loaded.LatestBlock()
+	tampered.ValueTimes[1] = tampered.ValueTimes[1].Add(time.Second)
+	if calculateHash(tampered) == tampered.Hash {
+		t.Fatal("changing a time kept the hash")
+	}
+}
+
+func TestParseSampleTime(t *testing.T) {
+	want := time.Date(2026, 3, 1, 12, 0, 0, 500_000_000, time.UTC)
+	for _, cell := range []string{"2026-03-01T12:00:00.5Z", "2026-03-01T13:00:00.5+01:00", " 1772366400.5 "} {
+		got, err := parseSampleTime(cell)
+		if err != nil || !got.Equal(want) {
+			t.Fatalf("%q parses as %v, %v, want %v", cell, got, err, want)
+		}
+	}
+	for _, cell := range []string{"", "gestern", "1e300"} {
+		if _, err := parseSampleTime(cell); err == nil {
+			t.Fatalf("%q was parsed as a time", cell)
+		}
+	}
+}
+
+func TestImportTimedCSV(t *testing.T) {
+	dir := writeImportFiles(t, map[string]string{
+		"zeiten.csv": "2026-03-01T12:00:02Z,3\n1772366400,1\nbald,9\n2026-03-01T12:00:01Z,2\n1772366410,4\n",
+	})
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := ImportOptions{Locale: LocaleEnglish, Timed: true, SamplesPerBlock: 3}
+	report, err := NewDefaultPipeline(bc).ImportGlob(filepath.Join(dir, "*.csv"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := report.Files[0]
+	if f.Err != nil || f.Rows != 5 || f.Blocks != 2 || f.Samples != 4 || len(f.RowErrors) != 1 || f.RowErrors[0].Line != 3 {
+		t.Fatalf("file is %+v", f)
+	}
+	blocks := bc.Blocks()
+	if !slices.Equal(blocks[1].Values, []float64{1, 2, 3}) || !slices.Equal(blocks[2].Values, []float64{4}) {
+		t.Fatalf("blocks hold %v and %v, want 1 2 3 and 4", blocks[1].Values, blocks[2].Values)
+	}
+	if want := time.Unix(1772366410, 0); !blocks[2].ValueTimes[0].Equal(want) {
+		t.Fatalf("last sample is at %v, want %v", blocks[2].ValueTimes[0], want)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (ImportOptions{Format: "json", Timed: true}).Validate(); err == nil {
+		t.Fatal("timed JSON import was accepted")
+	}
+	if err := (ImportOptions{SamplesPerBlock: 2}).Validate(); err == nil {
+		t.Fatal("samples per block without timed rows were accepted")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TimestampPolicy decides how AddBlock treats a clock that went backwards
+type TimestampPolicy string
+
+const (
+	// TimestampAllow keeps the earlier timestamp but flags the block
+	TimestampAllow TimestampPolicy = "allow"
+	// TimestampClamp moves the timestamp to just after the previous block
+	// and records the original in the block's metadata
+	TimestampClamp TimestampPolicy = "clamp"
+	// TimestampReject rejects the block
+	TimestampReject TimestampPolicy = "reject"
+)
+
+// ErrNonMonotonicTimestamp is returned under TimestampReject when the clock
+// is behind the previous block
+var ErrNonMonotonicTimestamp = errors.New("Zeitstempel liegt vor dem vorherigen Block")
+
+// SetClock replaces the clock used to timestamp new blocks
+func (bc *Blockchain) SetClock(clock Clock) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.clock = clock
+}
+
+// SetTimestampPolicy selects how non-monotonic timestamps are handled
+func (bc *Blockchain) SetTimestampPolicy(policy TimestampPolicy) error {
+	switch policy {
+	case TimestampAllow, TimestampClamp, TimestampReject:
+	default:
+		return fmt.Errorf("Unbekannte Zeitstempel-Richtlinie: %s", policy)
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.timestampPolicy = policy
+	return nil
+}
+
+// NonMonotonicTimestamps returns how often a new block's clock reading was
+// earlier than its predecessor's timestamp
+func (bc *Blockchain) NonMonotonicTimestamps() int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.nonMonotonic
+}
+
+// applyTimestampPolicy returns the timestamp for a block read from the
+// clock as now, following prev. Called with bc.mu held.
+func (bc *Blockchain) applyTimestampPolicy(now, prev time.Time, metadata map[string]string) (time.Time, map[string]string, error) {
+	if !now.Before(prev) {
+		return now, metadata, nil
+	}
+
+	bc.nonMonotonic++
+	switch bc.timestampPolicy {
+	case TimestampReject:
+		return time.Time{}, metadata, fmt.Errorf("%w: %s < %s", ErrNonMonotonicTimestamp, now.Format(time.RFC3339Nano), prev.Format(time.RFC3339Nano))
+	case TimestampClamp:
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		metadata["original_timestamp"] = now.Format(time.RFC3339Nano)
+		return prev.Add(time.Nanosecond), metadata, nil
+	default:
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		metadata["non_monotonic_timestamp"] = "true"
+		return now, metadata, nil
+	}
+}
+
+// timestampWarning describes how the timestamp policy treated block, or
+// returns "" if its clock reading followed its predecessor
+func timestampWarning(block *Block) string {
+	if original, ok := block.Metadata["original_timestamp"]; ok {
+		return fmt.Sprintf("Block %d: Zeitstempel %s auf %s angehoben", block.Index, original, block.Timestamp.Format(time.RFC3339Nano))
+	}
+	if block.Metadata["non_monotonic_timestamp"] == "true" {
+		return fmt.Sprintf("Block %d: Zeitstempel liegt vor dem vorherigen Block", block.Index)
+	}
+	return ""
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenScope is what an API token may do: read serves GET requests, write
+// POST /blocks and admin everything, including /tokens/usage and the
+// requests that change more than the head of the chain. Without tokens
+// the API serves read and write to anyone but refuses admin, see
+// refuseAdmin.
+type TokenScope string
+
+const (
+	ScopeRead  TokenScope = "read"
+	ScopeWrite TokenScope = "write"
+	ScopeAdmin TokenScope = "admin"
+)
+
+// defaultUsageFlushInterval is how often StartFlusher writes the counters
+const defaultUsageFlushInterval = 30 * time.Second
+
+var (
+	// ErrTokenMissing is returned for a request without a bearer token
+	// while tokens are configured
+	ErrTokenMissing = errors.New("Zugriffstoken fehlt")
+	// ErrTokenUnknown is returned for a bearer token that is not configured
+	ErrTokenUnknown = errors.New("Unbekanntes Zugriffstoken")
+	// ErrTokenScope is returned for a request the token may not make
+	ErrTokenScope = errors.New("Zugriffstoken ohne Berechtigung")
+	// ErrQuotaExceeded is wrapped by QuotaError
+	ErrQuotaExceeded = errors.New("Tageskontingent erschöpft")
+	// ErrNoTokens is returned by GET /tokens/usage without token accounting
+	ErrNoTokens = errors.New("Keine Zugriffstokens eingerichtet")
+	// ErrAdminWithoutTokens is returned for a request needing ScopeAdmin
+	// while no tokens are configured
+	ErrAdminWithoutTokens = errors.New("Verwaltungsanfragen brauchen ein Zugriffstoken mit admin, es sind keine eingerichtet")
+)
+
+// APIToken is an access token of the REST API in the configuration file,
+// sent as "Authorization: Bearer <token>". Only the SHA-256 of the token
+// is configured, in hex as sha256sum prints it, so neither the file nor
+// the log of a reload reveals it. BlocksPerDay caps the blocks added with
+// the token per quota day; 0 is no cap.
+type APIToken struct {
+	Name         string       `json:"name"`
+	SHA256       string       `json:"sha256"`
+	Scopes       []TokenScope `json:"scopes"`
+	BlocksPerDay int          `json:"blocks_per_day,omitempty"`
+}
+
+// allows reports whether the token has scope, admin covering every scope
+func (t APIToken) allows(scope TokenScope) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeAdmin)
+}
+
+// validateTokens checks the tokens and the quota reset of a configuration
+func validateTokens(tokens []APIToken, quotaReset string) error {
+	if _, err := parseQuotaReset(quotaReset); err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(tokens))
+	hashes := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		if token.Name == "" {
+			return errors.New("Zugriffstoken ohne Namen")
+		}
+		if names[token.Name] {
+			return fmt.Errorf("Zugriffstoken %s ist doppelt", token.Name)
+		}
+		names[token.Name] = true
+		hash := strings.ToLower(token.SHA256)
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("Zugriffstoken %s: sha256 ist kein SHA-256 in Hex", token.Name)
+		}
+		if hashes[hash] {
+			return fmt.Errorf("Zugriffstoken %s: sha256 gehört schon zu einem anderen Token", token.Name)
+		}
+		hashes[hash] = true
+		if len(token.Scopes) == 0 {
+			return fmt.Errorf("Zugriffstoken %s ohne Berechtigung", token.Name)
+		}
+		for _, scope := range token.Scopes {
+			switch scope {
+			case ScopeRead, ScopeWrite, ScopeAdmin:
+			default:
+				return fmt.Errorf("Zugriffstoken %s: unbekannte Berechtigung %s", token.Name, scope)
+			}
+		}
+		if token.BlocksPerDay < 0 {
+			return fmt.Errorf("Zugriffstoken %s: ungültiges Kontingent %d", token.Name, token.BlocksPerDay)
+		}
+	}
+	return nil
+}
+
+// parseQuotaReset returns the time after midnight UTC of quota_reset,
+// "HH:MM"; empty is midnight
+func parseQuotaReset(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("Ungültige Zeit für das Zurücksetzen der Kontingente: %s (erwartet HH:MM)", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// QuotaError is the error of a request that would add more blocks than
+// the quota of its token leaves for the day
+type QuotaError struct {
+	Token    string
+	Limit    int
+	ResetsAt time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%v: %d Blöcke pro Tag für Token %s, zurückgesetzt um %s", ErrQuotaExceeded, e.Limit, e.Token, e.ResetsAt.UTC().Format(time.RFC3339))
+}
+
+func (e *QuotaError) Unwrap() error { return ErrQuotaExceeded }
+
+// TokenCounters counts what was done with a token since the usage file
+// was created
+type TokenCounters struct {
+	Requests       int64 `json:"requests"`
+	BlocksAdded    int64 `json:"blocks_added"`
+	ValuesIngested int64 `json:"values_ingested"`
+	BytesExported  int64 `json:"bytes_exported"`
+}
+
+// tokenRecord is what the usage file keeps of a token: its counters and
+// the blocks counted against the quota day starting at QuotaStart
+type tokenRecord struct {
+	TokenCounters
+	QuotaStart time.Time `json:"quota_start"`
+	QuotaUsed  int       `json:"quota_used"`
+}
+
+// TokenQuota is the quota of a token for the current day
+type TokenQuota struct {
+	BlocksPerDay int       `json:"blocks_per_day"`
+	Used         int       `json:"used"`
+	Remaining    int       `json:"remaining"`
+	ResetsAt     time.Time `json:"resets_at"`
+}
+
+// TokenReport is the usage of a configured token in GET /tokens/usage.
+// Quota is nil for a token without one.
+type TokenReport struct {
+	Name   string       `json:"name"`
+	Scopes []TokenScope `json:"scopes"`
+	TokenCounters
+	Quota *TokenQuota `json:"quota,omitempty"`
+}
+
+// TokenUsage authenticates the requests of the REST API by the tokens of
+// the configuration, counts their usage per token name and enforces
+// their quotas. The counters are kept in a JSON file, written by Flush,
+// so they survive restarts and renewed tokens of the same name. Quota
+// days start at quota_reset UTC.
+//
+// Without configured tokens the API is open and nothing is counted.
+type TokenUsage struct {
+	path  string
+	clock Clock
+
+	mu      sync.Mutex
+	tokens  map[string]APIToken
+	reset   time.Duration
+	records map[string]*tokenRecord
+	dirty   bool
+}
+
+// usageFile returns the default location of the token usage file
+func usageFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".block_data_save_usage.json")
+}
+
+// OpenTokenUsage reads the counters stored at path. A missing file starts
+// from zero; an empty path keeps the counters in memory only. No token is
+// configured until Configure.
+func OpenTokenUsage(path string, clock Clock) (*TokenUsage, error) {
+	u := &TokenUsage{path: path, clock: clock, tokens: map[string]APIToken{}, records: map[string]*tokenRecord{}}
+	if path == "" {
+		return u, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &u.records); err != nil {
+		return nil, fmt.Errorf("Nutzungsdatei %s: %w", path, err)
+	}
+	return u, nil
+}
+
+// Configure replaces the tokens and the quota reset, as the configuration
+// file sets them. Counters of tokens no longer configured are kept.
+func (u *TokenUsage) Configure(tokens []APIToken, quotaReset string) error {
+	if err := validateTokens(tokens, quotaReset); err != nil {
+		return err
+	}
+	reset, _ := parseQuotaReset(quotaReset)
+	byHash := make(map[string]APIToken, len(tokens))
+	for _, token := range tokens {
+		token.Scopes = slices.Clone(token.Scopes)
+		byHash[strings.ToLower(token.SHA256)] = token
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.tokens, u.reset = byHash, reset
+	return nil
+}
+
+// required reports whether tokens are configured, so that requests need
+// one
+func (u *TokenUsage) required() bool {
+	if u == nil {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.tokens) > 0
+}
+
+// authenticate returns the configured token of the Authorization header
+// and whether tokens are configured at all
+func (u *TokenUsage) authenticate(header string) (APIToken, bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.tokens) == 0 {
+		return APIToken{}, false, nil
+	}
+	secret, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || secret == "" {
+		return APIToken{}, true, ErrTokenMissing
+	}
+	sum := sha256.Sum256([]byte(secret))
+	token, ok := u.tokens[hex.EncodeToString(sum[:])]
+	if !ok {
+		return APIToken{}, true, ErrTokenUnknown
+	}
+	return token, true, nil
+}
+
+// record returns the record of the token name, created if needed, with
+// its quota day rolled over to the one at now. Called with u.mu held.
+func (u *TokenUsage) record(name string, now time.Time) *tokenRecord {
+	rec := u.records[name]
+	if rec == nil {
+		rec = &tokenRecord{}
+		u.records[name] = rec
+	}
+	if start := u.quotaStart(now); !rec.QuotaStart.Equal(start) {
+		rec.QuotaStart, rec.QuotaUsed = start, 0
+	}
+	return rec
+}
+
+// quotaStart returns the start of the quota day at now. Called with u.mu
+// held.
+func (u *TokenUsage) quotaStart(now time.Time) time.Time {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(u.reset)
+	if start.After(now) {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start
+}
+
+// update applies change to the counters of the token name
+func (u *TokenUsage) update(name string, change func(*tokenRecord)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	change(u.record(name, u.clock.Now()))
+	u.dirty = true
+}
+
+// reserve counts blocks against the quota of the token name, or returns a
+// QuotaError if they exceed what is left of it. A nil usage or an empty
+// name, for the open API, reserves nothing.
+func (u *TokenUsage) reserve(name string, blocks int) error {
+	if u == nil || name == "" {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var limit int
+	for _, token := range u.tokens {
+		if token.Name == name {
+			limit = token.BlocksPerDay
+		}
+	}
+	rec := u.record(name, u.clock.Now())
+	if limit > 0 && rec.QuotaUsed+blocks > limit {
+		return &QuotaError{Token: name, Limit: limit, ResetsAt: rec.QuotaStart.AddDate(0, 0, 1)}
+	}
+	rec.QuotaUsed += blocks
+	u.dirty = true
+	return nil
+}
+
+// settle ends a reservation of reserve: added of the reserved blocks were
+// added with values values, the rest are given back to the quota
+func (u *TokenUsage) settle(name string, reserved, added, values int) {
+	if u == nil || name == "" {
+		return
+	}
+	u.update(name, func(rec *tokenRecord) {
+		rec.QuotaUsed = max(0, rec.QuotaUsed-(reserved-added))
+		rec.BlocksAdded += int64(added)
+		rec.ValuesIngested += int64(values)
+	})
+}
+
+// Report returns the usage of the configured tokens, sorted by name
+func (u *TokenUsage) Report() []TokenReport {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := u.clock.Now()
+	reports := make([]TokenReport, 0, len(u.tokens))
+	for _, token := range u.tokens {
+		rec := u.record(token.Name, now)
+		report := TokenReport{Name: token.Name, Scopes: slices.Clone(token.Scopes), TokenCounters: rec.TokenCounters}
+		if token.BlocksPerDay > 0 {
+			report.Quota = &TokenQuota{
+				BlocksPerDay: token.BlocksPerDay,
+				Used:         rec.QuotaUsed,
+				Remaining:    max(0, token.BlocksPerDay-rec.QuotaUsed),
+				ResetsAt:     rec.QuotaStart.AddDate(0, 0, 1),
+			}
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Name < reports[j].Name })
+	return reports
+}
+
+// Flush writes the counters to the usage file if they changed since the
+// last Flush
+func (u *TokenUsage) Flush() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.dirty || u.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(u.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := u.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, u.path); err != nil {
+		return err
+	}
+	u.dirty = false
+	return nil
+}
+
+// StartFlusher flushes the counters every interval until ctx is done,
+// and once more then
+func (u *TokenUsage) StartFlusher(ctx context.Context, interval time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				if err := u.Flush(); err != nil {
+					log.Println("Token-Nutzung nicht gespeichert:", err)
+				}
+				return
+			case <-u.clock.After(interval):
+			}
+			if err := u.Flush(); err != nil {
+				log.Println("Token-Nutzung nicht gespeichert:", err)
+			}
+		}
+	}()
+}
+
+// tokenNameKey is the context key of the name of the token a request was
+// authenticated with
+type tokenNameKey struct{}
+
+// tokenName returns the name of the token r was authenticated with, ""
+// for the open API
+func tokenName(r *http.Request) string {
+	name, _ := r.Context().Value(tokenNameKey{}).(string)
+	return name
+}
+
+// requiredScope returns the scope a request needs: read for GET and HEAD,
+// write for POST /blocks and admin for /tokens and every other request
+func requiredScope(r *http.Request) TokenScope {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/tokens/"):
+		return ScopeAdmin
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return ScopeRead
+	case r.Method == http.MethodPost && r.URL.Path == "/blocks":
+		return ScopeWrite
+	default:
+		return ScopeAdmin
+	}
+}
+
+// refuseAdmin serves the requests of next that need no more than
+// ScopeWrite and answers the others with 403, for an API without tokens:
+// confirming operations, vacuuming the storage or replaying dead letters
+// must not be open to anyone who reaches the port
+func refuseAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requiredScope(r) == ScopeAdmin {
+			writeAPIError(w, http.StatusForbidden, ErrAdminWithoutTokens)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorize serves the requests next is given by their tokens: 401
+// without a configured token, 403 without the scope the request needs.
+// Authorized requests are counted, and so are the bytes of GET /export.
+// GET /capabilities needs no token, so clients can learn that they need
+// one. While no tokens are configured, requests are served as by
+// refuseAdmin.
+func (u *TokenUsage) authorize(next http.Handler) http.Handler {
+	open := refuseAdmin(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, required, err := u.authenticate(r.Header.Get("Authorization"))
+		if !required {
+			open.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodGet && r.URL.Path == "/capabilities" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="block_data_save"`)
+			writeAPIError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if scope := requiredScope(r); !token.allows(scope) {
+			writeAPIError(w, http.StatusForbidden, fmt.Errorf("%w: %s braucht %s", ErrTokenScope, token.Name, scope))
+			return
+		}
+		u.update(token.Name, func(rec *tokenRecord) { rec.Requests++ })
+
+		r = r.WithContext(context.WithValue(r.Context(), tokenNameKey{}, token.Name))
+		if r.URL.Path != "/export" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		counted := &countingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(counted, r)
+		u.update(token.Name, func(rec *tokenRecord) { rec.BytesExported += counted.n })
+	})
+}
+
+// writeQuotaError answers a request rejected by reserve with 429 and the
+// seconds until the quota resets
+func (u *TokenUsage) writeQuotaError(w http.ResponseWriter, err error) {
+	var quota *QuotaError
+	if errors.As(err, &quota) {
+		seconds := int(math.Ceil(quota.ResetsAt.Sub(u.clock.Now()).Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, seconds)))
+	}
+	writeAPIError(w, http.StatusTooManyRequests, err)
+}
+
+// countingResponseWriter counts the bytes of a response body
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer
+func (w *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tokenHash returns the sha256 of secret as the configuration holds it
+func tokenHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// testTokens are a team token with a quota of 2 blocks, one with a quota
+// of 3 that may read and an admin token without a quota
+var testTokens = []APIToken{
+	{Name: "team-a", SHA256: tokenHash("secret-a"), Scopes: []TokenScope{ScopeWrite}, BlocksPerDay: 2},
+	{Name: "team-b", SHA256: tokenHash("secret-b"), Scopes: []TokenScope{ScopeRead, ScopeWrite}, BlocksPerDay: 3},
+	{Name: "ops", SHA256: tokenHash("secret-ops"), Scopes: []TokenScope{ScopeAdmin}},
+}
+
+// newTokenAPI returns usage kept at path on a clock at 10:00 UTC, with
+// testTokens and quotas reset at 06:00, and the API of a chain holding
+// chainTestValues that requires them
+func newTokenAPI(t *testing.T, path string) (*TokenUsage, *testClock, http.Handler) {
+	t.Helper()
+	clock := &testClock{now: time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)}
+	usage, err := OpenTokenUsage(path, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := usage.Configure(testTokens, "06:00"); err != nil {
+		t.Fatal(err)
+	}
+	bc := newFilledChain(t)
+	return usage, clock, NewAPIHandler(bc, NewDefaultPipeline(bc), WithTokenUsage(usage), WithAPIClock(clock))
+}
+
+// serveToken is serve with secret as the bearer token, if not empty
+func serveToken(handler http.Handler, method, target, body, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// withAdminToken requires the admin token of testTokens, "secret-ops",
+// for the requests a test serves with serveAdmin
+func withAdminToken(t *testing.T) APIOption {
+	t.Helper()
+	usage, err := OpenTokenUsage("", realClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := usage.Configure(testTokens[2:], ""); err != nil {
+		t.Fatal(err)
+	}
+	return WithTokenUsage(usage)
+}
+
+// serveAdmin is serve with the admin token of withAdminToken
+func serveAdmin(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	return serveToken(handler, method, target, body, "secret-ops")
+}
+
+// tokenReport returns the report of the token name from GET /tokens/usage
+func tokenReport(t *testing.T, handler http.Handler, name string) TokenReport {
+	t.Helper()
+	var body map[string][]TokenReport
+	decodeResponse(t, serveToken(handler, "GET", "/tokens/usage", "", "secret-ops"), http.StatusOK, &body)
+	for _, report := range body["tokens"] {
+		if report.Name == name {
+			return report
+		}
+	}
+	t.Fatalf("GET /tokens/usage has no token %s: %v", name, body)
+	return TokenReport{}
+}
+
+func TestTokenQuotaEnforced(t *testing.T) {
+	_, clock, handler := newTokenAPI(t, "")
+	post := func(secret string) *httptest.ResponseRecorder {
+		return serveToken(handler, "POST", "/blocks", `{"values": [1, 2, 3]}`, secret)
+	}
+	for i := 0; i < 2; i++ {
+		if rec := post("secret-a"); rec.Code != http.StatusCreated {
+			t.Fatalf("block %d of team-a answered %d: %s", i+1, rec.Code, rec.Body)
+		}
+	}
+	rec := post("secret-a")
+	expectAPIError(t, rec, http.StatusTooManyRequests)
+	if got := rec.Header().Get("Retry-After"); got != "72000" {
+		t.Fatalf("Retry-After is %q, want the 20 hours until 06:00", got)
+	}
+	// the quota of team-a leaves that of team-b alone
+	for i := 0; i < 3; i++ {
+		if rec := post("secret-b"); rec.Code != http.StatusCreated {
+			t.Fatalf("block %d of team-b answered %d: %s", i+1, rec.Code, rec.Body)
+		}
+	}
+	expectAPIError(t, post("secret-b"), http.StatusTooManyRequests)
+	if rec := post("secret-ops"); rec.Code != http.StatusCreated {
+		t.Fatalf("block of the admin token without a quota answered %d: %s", rec.Code, rec.Body)
+	}
+
+	report := tokenReport(t, handler, "team-a")
+	want := TokenQuota{BlocksPerDay: 2, Used: 2, Remaining: 0, ResetsAt: time.Date(2026, 10, 15, 6, 0, 0, 0, time.UTC)}
+	if report.Quota == nil || *report.Quota != want {
+		t.Fatalf("quota of team-a is %+v, want %+v", report.Quota, want)
+	}
+
+	clock.now = want.ResetsAt
+	if rec := post("secret-a"); rec.Code != http.StatusCreated {
+		t.Fatalf("block of team-a after the reset answered %d: %s", rec.Code, rec.Body)
+	}
+	if report := tokenReport(t, handler, "team-a"); report.Quota.Used != 1 || report.BlocksAdded != 3 {
+		t.Fatalf("team-a after the reset is %+v with quota %+v, want 1 of 2 used and 3 blocks added", report, report.Quota)
+	}
+}
+
+func TestTokenAuthorization(t *testing.T) {
+	_, _, handler := newTokenAPI(t, "")
+	rec := serveToken(handler, "GET", "/blocks/latest", "", "")
+	expectAPIError(t, rec, http.StatusUnauthorized)
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal("401 without WWW-Authenticate")
+	}
+	expectAPIError(t, serveToken(handler, "GET", "/blocks/latest", "", "wrong"), http.StatusUnauthorized)
+	// team-a may only add blocks, team-b not see the usage
+	expectAPIError(t, serveToken(handler, "GET", "/blocks/latest", "", "secret-a"), http.StatusForbidden)
+	expectAPIError(t, serveToken(handler, "GET", "/tokens/usage", "", "secret-b"), http.StatusForbidden)
+	expectAPIError(t, serveToken(handler, "POST", "/operations/prune", `{"keep_last": 1}`, "secret-b"), http.StatusForbidden)
+	if rec := serveToken(handler, "GET", "/blocks/latest", "", "secret-b"); rec.Code != http.StatusOK {
+		t.Fatalf("GET /blocks/latest of team-b answered %d: %s", rec.Code, rec.Body)
+	}
+
+	// without tokens the API is open but for the admin requests
+	bc, open := newTestAPI(t)
+	usage, err := OpenTokenUsage("", realClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, handler := range []http.Handler{open, NewAPIHandler(bc, NewDefaultPipeline(bc), WithTokenUsage(usage))} {
+		if rec := serve(handler, "GET", "/blocks/latest", ""); rec.Code != http.StatusOK {
+			t.Fatalf("API without tokens answered GET /blocks/latest with %d", rec.Code)
+		}
+		if rec := serve(handler, "POST", "/blocks", `{"values": [1, 2]}`); rec.Code != http.StatusCreated {
+			t.Fatalf("API without tokens answered POST /blocks with %d: %s", rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestAdminRefusedWithoutTokens(t *testing.T) {
+	bc, open := newTestAPI(t)
+	usage, err := OpenTokenUsage("", realClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unconfigured := NewAPIHandler(bc, NewDefaultPipeline(bc), WithTokenUsage(usage))
+	requests := []struct{ method, target, body string }{
+		{"POST", "/operations/prune", `{"keep_last": 2, "archive": "archive.json"}`},
+		{"POST", "/operations/t1/confirm", ""},
+		{"POST", "/storage/vacuum", ""},
+		{"POST", "/deadletters/replay", `{"ids": [1]}`},
+		{"POST", "/recovery/ack", ""},
+		{"GET", "/tokens/usage", ""},
+	}
+	for _, handler := range []http.Handler{open, unconfigured} {
+		for _, req := range requests {
+			var body apiError
+			decodeResponse(t, serve(handler, req.method, req.target, req.body), http.StatusForbidden, &body)
+			if body.Error != ErrAdminWithoutTokens.Error() {
+				t.Fatalf("%s %s answered %q", req.method, req.target, body.Error)
+			}
+		}
+	}
+	if bc.HistoryStart() != 0 {
+		t.Fatal("refused request pruned the chain")
+	}
+
+	// tokens configured by a reload open the admin requests to their holders
+	if err := usage.Configure(testTokens, ""); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serveAdmin(unconfigured, "GET", "/tokens/usage", ""); rec.Code != http.StatusOK {
+		t.Fatalf("GET /tokens/usage with the admin token answered %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTokenUsagePersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	usage, clock, handler := newTokenAPI(t, path)
+	for _, values := range []string{"[1, 2, 3]", "[4, 5]", "[6]"} {
+		serveToken(handler, "POST", "/blocks", `{"values": `+values+`}`, "secret-a")
+	}
+	rec := serveToken(handler, "GET", "/export", "", "secret-b")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /export of team-b answered %d: %s", rec.Code, rec.Body)
+	}
+	exported := int64(rec.Body.Len())
+
+	a, b := tokenReport(t, handler, "team-a"), tokenReport(t, handler, "team-b")
+	wantA := TokenCounters{Requests: 3, BlocksAdded: 2, ValuesIngested: 5}
+	wantB := TokenCounters{Requests: 1, BytesExported: exported}
+	if a.TokenCounters != wantA || b.TokenCounters != wantB {
+		t.Fatalf("counters are %+v and %+v, want %+v and %+v", a.TokenCounters, b.TokenCounters, wantA, wantB)
+	}
+	if err := usage.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// after a restart the counters and the used quota are back
+	reopened, err := OpenTokenUsage(path, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Configure(testTokens, "06:00"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.reserve("team-a", 1); err == nil {
+		t.Fatal("quota of team-a was reset by the restart")
+	}
+	for _, report := range reopened.Report() {
+		switch report.Name {
+		case "team-a":
+			if report.TokenCounters != wantA {
+				t.Fatalf("counters of team-a after the restart are %+v, want %+v", report.TokenCounters, wantA)
+			}
+		case "team-b":
+			if report.TokenCounters != wantB {
+				t.Fatalf("counters of team-b after the restart are %+v, want %+v", report.TokenCounters, wantB)
+			}
+		}
+	}
+}
+
+func TestTokenQuotaReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	writeConfig := func(blocksPerDay int) {
+		t.Helper()
+		tokens := []APIToken{{Name: "team-a", SHA256: tokenHash("secret-a"), Scopes: []TokenScope{ScopeWrite}, BlocksPerDay: blocksPerDay}}
+		data, err := json.Marshal(RuntimeConfig{Tokens: tokens})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(1)
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader, err := NewConfigReloader(bc, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage, err := OpenTokenUsage("", realClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.ApplyTokens(usage)
+	handler := NewAPIHandler(bc, NewDefaultPipeline(bc), WithTokenUsage(usage))
+	post := func() *httptest.ResponseRecorder {
+		return serveToken(handler, "POST", "/blocks", `{"values": [1]}`, "secret-a")
+	}
+	if rec := post(); rec.Code != http.StatusCreated {
+		t.Fatalf("first block answered %d: %s", rec.Code, rec.Body)
+	}
+	expectAPIError(t, post(), http.StatusTooManyRequests)
+
+	writeConfig(5)
+	changes, err := reloader.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Setting != "tokens" || strings.Contains(changes[0].String(), "secret-a") {
+		t.Fatalf("reload changed %v, want the tokens without their secret", changes)
+	}
+	if rec := post(); rec.Code != http.StatusCreated {
+		t.Fatalf("block after raising the quota answered %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTokenConfigRejected(t *testing.T) {
+	valid := testTokens[0]
+	for name, tokens := range map[string][]APIToken{
+		"no hash":       {{Name: "a", Scopes: []TokenScope{ScopeRead}}},
+		"unknown scope": {{Name: "a", SHA256: valid.SHA256, Scopes: []TokenScope{"delete"}}},
+		"no scope":      {{Name: "a", SHA256: valid.SHA256}},
+		"same name":     {valid, {Name: valid.Name, SHA256: tokenHash("other"), Scopes: valid.Scopes}},
+		"same hash":     {valid, {Name: "other", SHA256: valid.SHA256, Scopes: valid.Scopes}},
+		"negative":      {{Name: "a", SHA256: valid.SHA256, Scopes: valid.Scopes, BlocksPerDay: -1}},
+	} {
+		cfg := RuntimeConfig{Tokens: tokens}
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("configuration with tokens of %s was accepted", name)
+		}
+	}
+	cfg := RuntimeConfig{Tokens: testTokens, QuotaReset: "25:00"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("quota_reset 25:00 was accepted")
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Directions of a TrendReport
+const (
+	TrendRising  = "steigend"
+	TrendFalling = "fallend"
+	TrendFlat    = "gleichbleibend"
+	// TrendUnknown is reported for fewer than two block means
+	TrendUnknown = "unbestimmt"
+)
+
+// defaultTrendFlatThreshold is the slope per block up to which a trend of
+// a new Blockchain counts as flat
+const defaultTrendFlatThreshold = 0.001
+
+// TrendReport is the least-squares line through the means of the most
+// recent blocks, with the block index as x. Slope, Intercept and RSquared
+// are NaN when Direction is TrendUnknown.
+type TrendReport struct {
+	// Blocks is the number of block means the line was fitted to
+	Blocks    int
+	Slope     float64
+	Intercept float64
+	// RSquared is the share of the variance of the means the line
+	// explains; 1 if the means do not vary
+	RSquared  float64
+	Direction string
+}
+
+func (r TrendReport) String() string {
+	if r.Direction == TrendUnknown {
+		return fmt.Sprintf("Letzte %d Blöcke: %s, mindestens 2 Blöcke mit Werten nötig", r.Blocks, r.Direction)
+	}
+	return fmt.Sprintf("Letzte %d Blöcke: %s, Steigung %.3g/Block, R² %.2f", r.Blocks, r.Direction, r.Slope, r.RSquared)
+}
+
+// SetTrendFlatThreshold sets the absolute slope per block up to which
+// Trend reports a flat trend
+func (bc *Blockchain) SetTrendFlatThreshold(threshold float64) error {
+	if !(threshold >= 0) || math.IsInf(threshold, 0) {
+		return fmt.Errorf("Ungültige Schwelle für gleichbleibenden Trend: %v", threshold)
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.trendFlatThreshold = threshold
+	return nil
+}
+
+// recentMeans returns the indexes and means of the last n blocks that hold
+// values, oldest first. Genesis, checkpoint records and blocks whose mean
+// failed are left out. It reads the last n blocks, and further back only
+// as many as were left out. Called with bc.mu held.
+func (bc *Blockchain) recentMeans(n int) (indexes []int, means []float64) {
+	for end := bc.held; len(means) < n && end > 0; {
+		start := max(end-(n-len(means)), 0)
+		blocks, err := bc.blocks(start, end)
+		if err != nil {
+			logReadError(err)
+			break
+		}
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if block := blocks[i]; block.ValueCount() > 0 && !math.IsNaN(block.Mean) {
+				indexes = append(indexes, block.Index)
+				means = append(means, block.Mean)
+			}
+		}
+		end = start
+	}
+	for i, j := 0, len(means)-1; i < j; i, j = i+1, j-1 {
+		indexes[i], indexes[j] = indexes[j], indexes[i]
+		means[i], means[j] = means[j], means[i]
+	}
+	return indexes, means
+}
+
+// MovingAverage returns the rolling mean of the block means over windows
+// of window consecutive blocks, oldest first: element i averages the
+// means of blocks i to i+window-1 of the blocks that hold values. A
+// window longer than those blocks is clamped to them, as in Trend, and
+// gives their overall mean. It returns nil for a window below 1.
+func (bc *Blockchain) MovingAverage(window int) []float64 {
+	if window < 1 {
+		return nil
+	}
+	bc.mu.RLock()
+	_, means := bc.recentMeans(bc.held)
+	bc.mu.RUnlock()
+	window = min(window, len(means))
+
+	averages := make([]float64, 0, len(means)-window+1)
+	sum := 0.0
+	for i, mean := range means {
+		sum += mean
+		if i >= window {
+			sum -= means[i-window]
+		}
+		if i >= window-1 {
+			averages = append(averages, sum/float64(window))
+		}
+	}
+	return averages
+}
+
+// Trend fits a line through the means of the last window blocks that hold
+// values, or of all of them if there are fewer. A slope within the flat
+// threshold, see SetTrendFlatThreshold, is TrendFlat. A window below 2
+// or fewer than two such blocks give TrendUnknown.
+func (bc *Blockchain) Trend(window int) TrendReport {
+	bc.mu.RLock()
+	threshold := bc.trendFlatThreshold
+	var indexes []int
+	var means []float64
+	if window >= 2 {
+		indexes, means = bc.recentMeans(window)
+	}
+	bc.mu.RUnlock()
+
+	report := TrendReport{Blocks: len(means), Slope: math.NaN(), Intercept: math.NaN(), RSquared: math.NaN(), Direction: TrendUnknown}
+	if len(means) < 2 {
+		return report
+	}
+
+	n := float64(len(means))
+	var meanX, meanY float64
+	for i, y := range means {
+		meanX += float64(indexes[i]) / n
+		meanY += y / n
+	}
+	var sxx, sxy, syy float64
+	for i, y := range means {
+		dx, dy := float64(indexes[i])-meanX, y-meanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+	report.Slope = sxy / sxx
+	report.Intercept = meanY - report.Slope*meanX
+	report.RSquared = 1
+	if syy > 0 {
+		report.RSquared = sxy * sxy / (sxx * syy)
+	}
+
+	switch {
+	case math.Abs(report.Slope) <= threshold:
+		report.Direction = TrendFlat
+	case report.Slope > 0:
+		report.Direction = TrendRising
+	default:
+		report.Direction = TrendFalling
+	}
+	return report
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// readingStorage records the indexes of the blocks read from it
+type readingStorage struct {
+	Storage
+
+	mu   sync.Mutex
+	read []int
+}
+
+func (s *readingStorage) GetBlock(index int) (*Block, error) {
+	s.mu.Lock()
+	s.read = append(s.read, index)
+	s.mu.Unlock()
+	return s.Storage.GetBlock(index)
+}
+
+func (s *readingStorage) Iterate(from, to int, fn func(*Block) error) error {
+	return s.Storage.Iterate(from, to, func(block *Block) error {
+		s.mu.Lock()
+		s.read = append(s.read, block.Index)
+		s.mu.Unlock()
+		return fn(block)
+	})
+}
+
+// meanChain returns a chain with one block of mean m for each of means
+func meanChain(t *testing.T, means []float64, opts ...Option) *Blockchain {
+	t.Helper()
+	bc, err := NewBlockchain(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range means {
+		if _, err := bc.AddBlock([]float64{m - 1, m, m + 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc
+}
+
+func TestTrendDirections(t *testing.T) {
+	tests := []struct {
+		name      string
+		means     []float64
+		window    int
+		direction string
+		slope     float64
+		blocks    int
+	}{
+		{"rising", []float64{1, 2, 3, 4, 5}, 5, TrendRising, 1, 5},
+		{"falling", []float64{10, 8, 6, 4}, 4, TrendFalling, -2, 4},
+		{"flat", []float64{3, 3, 3}, 3, TrendFlat, 0, 3},
+		{"last window only", []float64{9, 1, 1, 2, 3}, 3, TrendRising, 1, 3},
+		{"window beyond the chain", []float64{5, 3}, 20, TrendFalling, -2, 2},
+		{"window of one", []float64{1, 2, 3}, 1, TrendUnknown, math.NaN(), 0},
+		{"single block", []float64{4}, 10, TrendUnknown, math.NaN(), 1},
+		{"only genesis", nil, 10, TrendUnknown, math.NaN(), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := meanChain(t, tt.means).Trend(tt.window)
+			if report.Direction != tt.direction || report.Blocks != tt.blocks {
+				t.Fatalf("trend is %s over %d blocks, want %s over %d", report.Direction, report.Blocks, tt.direction, tt.blocks)
+			}
+			if math.IsNaN(tt.slope) != math.IsNaN(report.Slope) || !math.IsNaN(tt.slope) && !near(report.Slope, tt.slope) {
+				t.Fatalf("slope is %v, want %v", report.Slope, tt.slope)
+			}
+			if tt.direction != TrendUnknown && !near(report.RSquared, 1) {
+				t.Fatalf("R² of a straight line is %v", report.RSquared)
+			}
+		})
+	}
+}
+
+func TestTrendFlatThreshold(t *testing.T) {
+	bc := meanChain(t, []float64{1, 1.01, 1.02})
+	if report := bc.Trend(3); report.Direction != TrendRising {
+		t.Fatalf("trend with the default threshold is %s", report.Direction)
+	}
+	if err := bc.SetTrendFlatThreshold(0.05); err != nil {
+		t.Fatal(err)
+	}
+	if report := bc.Trend(3); report.Direction != TrendFlat {
+		t.Fatalf("trend below the threshold is %s", report.Direction)
+	}
+	for _, threshold := range []float64{-1, math.NaN(), math.Inf(1)} {
+		if err := bc.SetTrendFlatThreshold(threshold); err == nil {
+			t.Fatalf("threshold %v accepted", threshold)
+		}
+	}
+}
+
+func TestMovingAverage(t *testing.T) {
+	tests := []struct {
+		name   string
+		means  []float64
+		window int
+		want   []float64
+	}{
+		{"sliding window", []float64{100, 2, 4, 6}, 3, []float64{106.0 / 3, 4}},
+		{"window beyond the chain", []float64{2, 4}, 10, []float64{3}},
+		{"window of one", []float64{2, 4}, 1, []float64{2, 4}},
+		{"window of zero", []float64{2, 4}, 0, nil},
+		{"only genesis", nil, 3, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := meanChain(t, tt.means).MovingAverage(tt.window)
+			if (got == nil) != (tt.want == nil) || !slices.EqualFunc(got, tt.want, near) {
+				t.Fatalf("MovingAverage(%d) is %v, want %v", tt.window, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrendReadsLastWindow(t *testing.T) {
+	storage := &readingStorage{Storage: NewMemoryStorage()}
+	means := make([]float64, 50)
+	for i := range means {
+		means[i] = float64(i)
+	}
+	bc := meanChain(t, means, WithStorage(storage))
+
+	storage.mu.Lock()
+	storage.read = nil
+	storage.mu.Unlock()
+	bc.Trend(5)
+	storage.mu.Lock()
+	got := slices.Clone(storage.read)
+	storage.mu.Unlock()
+	slices.Sort(got)
+	if !slices.Equal(got, []int{46, 47, 48, 49, 50}) {
+		t.Fatalf("read blocks %v, want the last 5", got)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// vacuumSuffix names the copy Vacuum writes next to the database
+const vacuumSuffix = ".vacuum"
+
+// ErrVacuumInProgress is returned for a write to a storage while Vacuum
+// copies it, and for a second Vacuum meanwhile
+var ErrVacuumInProgress = errors.New("Datenbank wird gerade verdichtet")
+
+// ErrVacuumUnsupported is returned by VacuumStorage for a chain whose
+// storage has no file to give space back from
+var ErrVacuumUnsupported = errors.New("Speicher kann nicht verdichtet werden")
+
+// VacuumResult describes a Vacuum: the size of the database file before
+// and after, in bytes
+type VacuumResult struct {
+	Path     string        `json:"path"`
+	Before   int64         `json:"before"`
+	After    int64         `json:"after"`
+	Blocks   int           `json:"blocks"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+}
+
+// String renders the result for the menu and the vacuum command
+func (r VacuumResult) String() string {
+	return fmt.Sprintf("%s verdichtet: %d Blöcke, %d → %d Bytes (%s)", r.Path, r.Blocks, r.Before, r.After, r.Duration.Round(time.Millisecond))
+}
+
+// vacuumer is implemented by storages that can give back the space of
+// deleted blocks
+type vacuumer interface {
+	Vacuum() (VacuumResult, error)
+	VacuumStatus() (running bool, last *VacuumResult)
+}
+
+// Vacuum gives back the space of deleted blocks, such as those removed by
+// Prune or Compact: it copies the database with VACUUM INTO next to it,
+// checks the copy and renames it over the database. Reads continue
+// meanwhile; writes fail with ErrVacuumInProgress until it is done. If
+// anything fails, the database is left as it was. Every run is logged.
+func (s *SQLiteStorage) Vacuum() (VacuumResult, error) {
+	result := VacuumResult{Path: s.path, Started: time.Now()}
+	s.mu.Lock()
+	if err := s.checkWritable(); err != nil {
+		s.mu.Unlock()
+		return result, err
+	}
+	s.vacuuming = true
+	result.Blocks = s.count
+	s.mu.Unlock()
+
+	err := s.vacuum(&result)
+	s.mu.Lock()
+	s.vacuuming = false
+	if err == nil {
+		s.lastVacuum = &result
+	}
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("Verdichtung von %s fehlgeschlagen: %v", s.path, err)
+		return result, fmt.Errorf("Verdichtung von %s: %w", s.path, err)
+	}
+	log.Printf("Verdichtung von %s: %d Blöcke, %d → %d Bytes in %s", s.path, result.Blocks, result.Before, result.After, result.Duration)
+	return result, nil
+}
+
+// vacuum runs Vacuum while writes are refused
+func (s *SQLiteStorage) vacuum(result *VacuumResult) error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	result.Before = info.Size()
+
+	tmp := s.path + vacuumSuffix
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.dbMu.RLock()
+	_, err = s.db.Exec(`VACUUM INTO ?`, tmp)
+	s.dbMu.RUnlock()
+	if err == nil {
+		err = checkVacuumCopy(tmp, result.Blocks)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+	if s.closed {
+		os.Remove(tmp)
+		return errStorageClosed
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// queries started on the old database finish on it before it closes
+	db, err := sql.Open("sqlite3", s.path)
+	if err == nil {
+		err = db.Ping()
+	}
+	if err != nil {
+		return err
+	}
+	s.db.Close()
+	s.db = db
+
+	if info, err = os.Stat(s.path); err != nil {
+		return err
+	}
+	result.After = info.Size()
+	result.Duration = time.Since(result.Started)
+	return nil
+}
+
+// checkVacuumCopy checks that the copy at path is intact and holds blocks
+// blocks
+func checkVacuumCopy(path string, blocks int) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var check string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&check); err != nil {
+		return err
+	}
+	if check != "ok" {
+		return fmt.Errorf("Kopie ist beschädigt: %s", check)
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM blocks`).Scan(&count); err != nil {
+		return err
+	}
+	if count != blocks {
+		return fmt.Errorf("Kopie hat %d statt %d Blöcke", count, blocks)
+	}
+	return nil
+}
+
+// VacuumStatus reports whether Vacuum is running and the result of the
+// last one, nil before the first
+func (s *SQLiteStorage) VacuumStatus() (running bool, last *VacuumResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastVacuum != nil {
+		result := *s.lastVacuum
+		last = &result
+	}
+	return s.vacuuming, last
+}
+
+// VacuumStorage runs Vacuum on the storage of the chain, which keeps
+// serving reads meanwhile, and returns ErrVacuumUnsupported for storages
+// without it
+func (bc *Blockchain) VacuumStorage() (VacuumResult, error) {
+	bc.mu.RLock()
+	storage := bc.storage
+	bc.mu.RUnlock()
+	v, ok := backendStorage(storage).(vacuumer)
+	if !ok {
+		return VacuumResult{}, ErrVacuumUnsupported
+	}
+	return v.Vacuum()
+}
+
+// vacuumStatus returns the VacuumStatus of the storage of the chain, and
+// false for storages without Vacuum
+func (bc *Blockchain) vacuumStatus() (running bool, last *VacuumResult, ok bool) {
+	bc.mu.RLock()
+	v, ok := backendStorage(bc.storage).(vacuumer)
+	bc.mu.RUnlock()
+	if !ok {
+		return false, nil, false
+	}
+	running, last = v.VacuumStatus()
+	return running, last, true
+}
+
+// runVacuumCommand implements "vacuum <db>": it vacuums the database of
+// a chain that is not in use
+func runVacuumCommand(args []string) int {
+	fs := flag.NewFlagSet("vacuum", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Aufruf: vacuum <datenbank>")
+		return 2
+	}
+	if _, err := os.Stat(fs.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	storage, err := OpenSQLiteStorage(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer storage.Close()
+	result, err := storage.Vacuum()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(result)
+	return 0
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVacuumResultString(t *testing.T) {
+	result := VacuumResult{Path: "chain.db", Before: 4096, After: 1024, Blocks: 10, Duration: 1500 * time.Microsecond}
+	want := "chain.db verdichtet: 10 Blöcke, 4096 → 1024 Bytes (2ms)"
+	if got := result.String(); got != want {
+		t.Fatalf("result renders as %q, want %q", got, want)
+	}
+}
+
+// newPrunedSQLiteChain returns a chain in a new database that held n
+// blocks of 200 values and was pruned to the newest 10
+func newPrunedSQLiteChain(t *testing.T, n int) (*Blockchain, *SQLiteStorage) {
+	t.Helper()
+	dir := t.TempDir()
+	storage, err := OpenSQLiteStorage(filepath.Join(dir, "chain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBlockchain(WithStorage(storage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.Close() })
+	for _, values := range bulkRows(n, 200) {
+		if _, err := bc.AddBlock(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := bc.Prune(10, filepath.Join(dir, "archive.json")); err != nil {
+		t.Fatal(err)
+	}
+	return bc, storage
+}
+
+func TestVacuumShrinksPrunedChain(t *testing.T) {
+	bc, storage := newPrunedSQLiteChain(t, 300)
+	head := bc.HeadHash()
+	result, err := bc.VacuumStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blocks != 10 || result.After <= 0 || result.After*4 > result.Before {
+		t.Fatalf("vacuum result is %+v, want 10 blocks in a quarter of the size or less", result)
+	}
+	if info, err := os.Stat(storage.Path()); err != nil || info.Size() != result.After {
+		t.Fatalf("database has %d bytes after vacuum, result reports %d", info.Size(), result.After)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatalf("adding a block after vacuum: %v", err)
+	}
+	bc.Close()
+
+	reopened, err := openSQLiteChain(storage.Path(), ChainConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if err := reopened.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if prev := reopened.LatestBlock().PrevHash; reopened.Length() != 11 || prev != head {
+		t.Fatalf("reopened chain has %d blocks after head %s, want 11 after %s", reopened.Length(), prev, head)
+	}
+}
+
+func TestVacuumFailureLeavesDatabase(t *testing.T) {
+	bc, storage := newPrunedSQLiteChain(t, 20)
+	// a directory in the way of the copy makes the vacuum fail
+	if err := os.MkdirAll(filepath.Join(storage.Path()+vacuumSuffix, "blockiert"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(storage.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.VacuumStorage(); err == nil {
+		t.Fatal("vacuum with its copy blocked succeeded")
+	}
+	after, err := os.ReadFile(storage.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("failed vacuum changed the database")
+	}
+	if running, last := storage.VacuumStatus(); running || last != nil {
+		t.Fatalf("status after a failed vacuum is running %v with last %v", running, last)
+	}
+	if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatalf("adding a block after a failed vacuum: %v", err)
+	}
+}
+
+func TestVacuumRefusesWrites(t *testing.T) {
+	bc, storage := newPrunedSQLiteChain(t, 20)
+	storage.mu.Lock()
+	storage.vacuuming = true
+	storage.mu.Unlock()
+	length := bc.Length()
+	if _, err := bc.AddBlock([]float64{1, 2, 3}); !errors.Is(err, ErrVacuumInProgress) {
+		t.Fatalf("AddBlock during vacuum returned %v, want ErrVacuumInProgress", err)
+	}
+	if _, err := bc.VacuumStorage(); !errors.Is(err, ErrVacuumInProgress) {
+		t.Fatalf("second vacuum returned %v, want ErrVacuumInProgress", err)
+	}
+	if bc.Length() != length {
+		t.Fatalf("chain has %d blocks after a refused write, want %d", bc.Length(), length)
+	}
+
+	storage.mu.Lock()
+	storage.vacuuming = false
+	storage.mu.Unlock()
+	if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVacuumWhileReading(t *testing.T) {
+	bc, _ := newPrunedSQLiteChain(t, 100)
+	want := bc.LatestBlock()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if block, err := bc.BlockByIndex(want.Index); err != nil || block.Hash != want.Hash {
+					t.Errorf("read during vacuum returned %v", err)
+					return
+				}
+				if len(bc.Blocks()) != 10 {
+					t.Error("chain read during vacuum lost blocks")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := bc.VacuumStorage(); err != nil {
+			t.Error(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestAPIVacuum(t *testing.T) {
+	bc, _ := newPrunedSQLiteChain(t, 50)
+	handler := NewAPIHandler(bc, NewDefaultPipeline(bc), withAdminToken(t))
+
+	var status vacuumStatus
+	decodeResponse(t, serveAdmin(handler, "GET", "/storage/vacuum", ""), http.StatusOK, &status)
+	if status.Running || status.Last != nil {
+		t.Fatalf("status before vacuum is %+v", status)
+	}
+	var result VacuumResult
+	decodeResponse(t, serveAdmin(handler, "POST", "/storage/vacuum", ""), http.StatusOK, &result)
+	if result.After >= result.Before || result.Blocks != 10 {
+		t.Fatalf("POST /storage/vacuum returned %+v", result)
+	}
+	decodeResponse(t, serveAdmin(handler, "GET", "/storage/vacuum", ""), http.StatusOK, &status)
+	if status.Last == nil || status.Last.After != result.After {
+		t.Fatalf("status after vacuum is %+v, want the result", status)
+	}
+
+	inMemory, _ := newTestAPI(t)
+	memory := NewAPIHandler(inMemory, NewDefaultPipeline(inMemory), withAdminToken(t))
+	expectAPIError(t, serveAdmin(memory, "POST", "/storage/vacuum", ""), http.StatusNotImplemented)
+	expectAPIError(t, serveAdmin(memory, "GET", "/storage/vacuum", ""), http.StatusNotImplemented)
+}
+
+func TestRunVacuumCommand(t *testing.T) {
+	bc, storage := newPrunedSQLiteChain(t, 20)
+	bc.Close()
+	if code := runVacuumCommand([]string{storage.Path()}); code != 0 {
+		t.Fatalf("vacuum exited with %d", code)
+	}
+	if code := runVacuumCommand([]string{filepath.Join(t.TempDir(), "fehlt.db")}); code != 1 {
+		t.Fatalf("vacuum of a missing database exited with %d, want 1", code)
+	}
+	if code := runVacuumCommand(nil); code != 2 {
+		t.Fatalf("vacuum without a database exited with %d, want 2", code)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrChainInvalid is matched by every *ValidationError
+var ErrChainInvalid = errors.New("Blockchain ist inkonsistent")
+
+// ValidationError names the first block at which Validate failed
+type ValidationError struct {
+	Index  int
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v: Block %d: %s", ErrChainInvalid, e.Index, e.Reason)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrChainInvalid
+}
+
+// Validate recomputes the hash of every block and checks that it matches
+// the stored hash, that each block links to its predecessor's hash and
+// that indexes increase by one. Mined blocks must meet the difficulty
+// they record, signed blocks must carry a valid signature by the key they
+// name, and the stats of every block but genesis must match their values,
+// see VerifyStats. VerifySignatures checks who signed the blocks. It
+// returns a *ValidationError for the first inconsistency found.
+//
+// The record of a chain bootstrapped from a checkpoint carries no payload
+// and is not rehashed. The oldest block of a pruned chain must link to the
+// newest archived block, and that of a compacted chain to the newest
+// deleted block if it is newer.
+func (bc *Blockchain) Validate() error {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	blocks, err := bc.allBlocks()
+	if err != nil {
+		return err
+	}
+	first := blocks[0]
+	switch {
+	case bc.expired != nil && (bc.archive == nil || bc.expired.LastIndex > bc.archive.LastIndex):
+		if first.Index != bc.expired.LastIndex+1 {
+			return &ValidationError{Index: first.Index, Reason: fmt.Sprintf("Index folgt nicht auf den gelöschten Block %d", bc.expired.LastIndex)}
+		}
+		if first.PrevHash != bc.expired.LastHash {
+			return &ValidationError{Index: first.Index, Reason: fmt.Sprintf("Vorgänger-Hash %s passt nicht zum gelöschten Block %d", formatHash(first.PrevHash), bc.expired.LastIndex)}
+		}
+	case bc.archive != nil:
+		if first.Index != bc.archive.LastIndex+1 {
+			return &ValidationError{Index: first.Index, Reason: fmt.Sprintf("Index folgt nicht auf den archivierten Block %d", bc.archive.LastIndex)}
+		}
+		if first.PrevHash != bc.archive.LastHash {
+			return &ValidationError{Index: first.Index, Reason: fmt.Sprintf("Vorgänger-Hash %s passt nicht zum archivierten Block %d", formatHash(first.PrevHash), bc.archive.LastIndex)}
+		}
+	}
+	for pos, block := range blocks {
+		if pos > 0 {
+			prev := blocks[pos-1]
+			if block.Index != prev.Index+1 {
+				return &ValidationError{Index: block.Index, Reason: fmt.Sprintf("Index folgt nicht auf %d", prev.Index)}
+			}
+			if block.PrevHash != prev.Hash {
+				return &ValidationError{Index: block.Index, Reason: fmt.Sprintf("Vorgänger-Hash %s passt nicht zu Block %d", formatHash(block.PrevHash), prev.Index)}
+			}
+		}
+		if pos == 0 && bc.stub {
+			continue
+		}
+		if err := verifyBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyBlock checks a block on its own: its hash version, that its hash
+// matches its content and difficulty and, except for genesis, its stats.
+func verifyBlock(block *Block) *ValidationError {
+	if block.HashVersion != currentHashVersion {
+		return &ValidationError{Index: block.Index, Reason: fmt.Sprintf("Unbekannte Hash-Version %d", block.HashVersion)}
+	}
+	if block.ValuesDropped && block.Dropped == nil {
+		return &ValidationError{Index: block.Index, Reason: "Werte verworfen, aber ihre Statistik fehlt"}
+	}
+	if hash := calculateHash(block); hash != block.Hash {
+		return &ValidationError{Index: block.Index, Reason: fmt.Sprintf("Hash %s passt nicht zum Inhalt (%s)", formatHash(block.Hash), formatHash(hash))}
+	}
+	if !meetsDifficulty(block.Hash, block.Difficulty) {
+		return &ValidationError{Index: block.Index, Reason: fmt.Sprintf("Hash %s erfüllt Schwierigkeit %d nicht", formatHash(block.Hash), block.Difficulty)}
+	}
+	if reason := verifySignature(block); reason != "" {
+		return &ValidationError{Index: block.Index, Reason: reason}
+	}
+	if block.Index > 0 {
+		if err := block.VerifyStats(); err != nil {
+			return &ValidationError{Index: block.Index, Reason: err.Error()}
+		}
+	}
+	return nil
+}
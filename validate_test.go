@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tamper changes block index of bc in place, as an attacker with access
+// to the memory storage could, and returns the index the block has then
+func tamper(t *testing.T, bc *Blockchain, index int, change func(*Block)) int {
+	t.Helper()
+	storage := bc.storage.(*memoryStorage)
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	block := storage.blocks[storage.position(index)]
+	change(block)
+	return block.Index
+}
+
+// expectInvalidAt checks that Validate fails at block index
+func expectInvalidAt(t *testing.T, bc *Blockchain, index int) {
+	t.Helper()
+	err := bc.Validate()
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || !errors.Is(err, ErrChainInvalid) {
+		t.Fatalf("Validate returned %v, want a ValidationError", err)
+	}
+	if invalid.Index != index {
+		t.Fatalf("Validate failed at block %d, want %d: %v", invalid.Index, index, err)
+	}
+}
+
+func TestValidateAcceptsChain(t *testing.T) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("a new chain is invalid: %v", err)
+	}
+	fillChain(t, bc)
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateCatchesTampering(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		index  int
+		change func(*Block)
+	}{
+		{"value", 2, func(b *Block) { b.Values[0] += 1 }},
+		{"appended value", 3, func(b *Block) { b.Values = append(b.Values, 5) }},
+		{"text", 1, func(b *Block) { b.Text = "gefälscht" }},
+		{"outlier flag", 2, func(b *Block) { b.HasOutliers = !b.HasOutliers }},
+		{"hash", 4, func(b *Block) { b.Hash = strings.Repeat("0", 64) }},
+		{"link", 3, func(b *Block) { b.PrevHash = "0f1e2d3c" }},
+		{"index", 5, func(b *Block) { b.Index++ }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bc := newFilledChain(t)
+			expectInvalidAt(t, bc, tamper(t, bc, tc.index, tc.change))
+		})
+	}
+}
+
+func TestValidateCatchesRehashedBlock(t *testing.T) {
+	bc := newFilledChain(t)
+	// a block changed and hashed again no longer matches its stats
+	expectInvalidAt(t, bc, tamper(t, bc, 2, func(b *Block) {
+		b.Values[0] = 11
+		b.Hash = calculateHash(b)
+	}))
+}
+
+func TestTimestampPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy  TimestampPolicy
+		warning string
+	}{
+		{TimestampAllow, "liegt vor dem vorherigen Block"},
+		{TimestampClamp, "angehoben"},
+		{TimestampReject, ""},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			bc, err := NewBlockchain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := &testClock{now: time.Now()}
+			bc.SetClock(clock)
+			if err := bc.SetTimestampPolicy(tc.policy); err != nil {
+				t.Fatal(err)
+			}
+			prev, err := bc.AddBlock([]float64{1, 2, 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// NTP steps the clock back a minute
+			clock.now = clock.now.Add(-time.Minute)
+			block, err := bc.AddBlock([]float64{4, 5, 6})
+
+			switch tc.policy {
+			case TimestampReject:
+				if !errors.Is(err, ErrNonMonotonicTimestamp) {
+					t.Fatalf("AddBlock returned %v, want ErrNonMonotonicTimestamp", err)
+				}
+				if bc.Length() != 2 {
+					t.Fatalf("chain holds %d blocks after the rejection, want 2", bc.Length())
+				}
+			case TimestampClamp:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !block.Timestamp.Equal(prev.Timestamp.Add(time.Nanosecond)) {
+					t.Fatalf("clamped timestamp is %v, want 1ns after %v", block.Timestamp, prev.Timestamp)
+				}
+				if block.Metadata["original_timestamp"] != clock.now.Format(time.RFC3339Nano) {
+					t.Fatalf("metadata is %v, want the original reading", block.Metadata)
+				}
+			case TimestampAllow:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !block.Timestamp.Equal(clock.now) || block.Metadata["non_monotonic_timestamp"] != "true" {
+					t.Fatalf("block has timestamp %v and metadata %v, want the reading, flagged", block.Timestamp, block.Metadata)
+				}
+			}
+
+			if got := bc.NonMonotonicTimestamps(); got != 1 {
+				t.Fatalf("NonMonotonicTimestamps is %d, want 1", got)
+			}
+			if got := bc.Metrics().NonMonotonicTimestamps; got != 1 {
+				t.Fatalf("Metrics count %d non-monotonic timestamps, want 1", got)
+			}
+			if err := bc.Validate(); err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+			warnings := bc.ValidationWarnings()
+			if tc.warning == "" {
+				if len(warnings) != 0 {
+					t.Fatalf("ValidationWarnings are %q, want none", warnings)
+				}
+				return
+			}
+			if len(warnings) != 1 || !strings.Contains(warnings[0], "Block 2") || !strings.Contains(warnings[0], tc.warning) {
+				t.Fatalf("ValidationWarnings are %q, want one for block 2", warnings)
+			}
+		})
+	}
+}
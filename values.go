@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInvalidValues is matched by every *InvalidValuesError
+var ErrInvalidValues = errors.New("Ungültige Werte")
+
+// InvalidValuesError reports why the values of a new block were refused.
+// Position is -1 when the block has no values at all.
+type InvalidValuesError struct {
+	Position int
+	Value    float64
+}
+
+func (e *InvalidValuesError) Error() string {
+	if e.Position < 0 {
+		return fmt.Sprintf("%v: Block enthält keine Werte", ErrInvalidValues)
+	}
+	return fmt.Sprintf("%v: %v an Position %d ist keine endliche Zahl", ErrInvalidValues, e.Value, e.Position)
+}
+
+func (e *InvalidValuesError) Is(target error) bool {
+	return target == ErrInvalidValues
+}
+
+// checkValues accepts the values of a new float block: at least one, all
+// finite. Only the genesis block has no values.
+func checkValues(values []float64) error {
+	if len(values) == 0 {
+		return &InvalidValuesError{Position: -1}
+	}
+	for i, value := range values {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return &InvalidValuesError{Position: i, Value: value}
+		}
+	}
+	return nil
+}
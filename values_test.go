@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestAddBlockRejectsInvalidValues(t *testing.T) {
+	bc := newFilledChain(t)
+	head := bc.LatestBlock()
+	tests := []struct {
+		values   []float64
+		position int
+		message  string
+	}{
+		{nil, -1, "Ungültige Werte: Block enthält keine Werte"},
+		{[]float64{}, -1, "Ungültige Werte: Block enthält keine Werte"},
+		{[]float64{1, math.NaN()}, 1, "Ungültige Werte: NaN an Position 1 ist keine endliche Zahl"},
+		{[]float64{math.Inf(1)}, 0, "Ungültige Werte: +Inf an Position 0 ist keine endliche Zahl"},
+		{[]float64{1, 2, math.Inf(-1)}, 2, "Ungültige Werte: -Inf an Position 2 ist keine endliche Zahl"},
+	}
+	for _, tt := range tests {
+		_, err := bc.AddBlock(tt.values)
+		var invalid *InvalidValuesError
+		if !errors.As(err, &invalid) || invalid.Position != tt.position || !errors.Is(err, ErrInvalidValues) || err.Error() != tt.message {
+			t.Fatalf("values %v returned %v, want %q", tt.values, err, tt.message)
+		}
+	}
+	if bc.LatestBlock().Hash != head.Hash {
+		t.Fatal("a block with invalid values was added")
+	}
+
+	// the pipeline refuses them before any later stage
+	if err := NewDefaultPipeline(bc).Submit(&Batch{Values: []float64{math.NaN()}}); !errors.Is(err, ErrInvalidValues) {
+		t.Fatalf("pipeline returned %v for NaN", err)
+	}
+	if _, err := bc.AddBlock([]float64{-math.MaxFloat64, 0, math.SmallestNonzeroFloat64}); err != nil {
+		t.Fatalf("finite extremes returned %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrBlockCorrupted is returned for a block read from a chain with
+// SetVerifyOnRead whose content no longer matches its hash
+var ErrBlockCorrupted = errors.New("Block wurde im Speicher verändert")
+
+// SetVerifyOnRead makes LatestBlock, BlockByIndex, BlockByID, Blocks,
+// BlockRange, Iterate and Iterator recompute the hash of every block they
+// return. A block whose content no longer matches its hash, because
+// something modified the chain's own copy, is reported as
+// ErrBlockCorrupted naming its index: BlockByIndex and BlockByID return
+// the error, the others log it and still return the block. It costs one
+// hash per block read; the accessors return copies either way, so code
+// outside the package cannot cause it.
+func (bc *Blockchain) SetVerifyOnRead(enabled bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.verifyOnRead = enabled
+}
+
+// readCheck verifies blocks as they are read, see SetVerifyOnRead
+type readCheck struct {
+	enabled bool
+	// stub is set if slot 0 holds a checkpoint record, which has no
+	// content to hash
+	stub bool
+}
+
+// readCheck returns the check of the current settings. Called with bc.mu
+// held.
+func (bc *Blockchain) readCheck() readCheck {
+	return readCheck{enabled: bc.verifyOnRead, stub: bc.stub}
+}
+
+// readSnapshot returns the chain slice like snapshot, with the check of
+// its blocks
+func (bc *Blockchain) readSnapshot() ([]*Block, readCheck) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	blocks, err := bc.allBlocks()
+	logReadError(err)
+	return blocks, bc.readCheck()
+}
+
+// verify returns an ErrBlockCorrupted error if the block at slot pos no
+// longer matches its hash
+func (c readCheck) verify(pos int, block *Block) error {
+	if !c.enabled || pos == 0 && c.stub {
+		return nil
+	}
+	if hash := calculateHash(block); hash != block.Hash {
+		return fmt.Errorf("%w: Block %d hat Hash %s statt %s", ErrBlockCorrupted, block.Index, formatHash(hash), formatHash(block.Hash))
+	}
+	return nil
+}
+
+// logged logs what verify reports, for reads that cannot return an error
+func (c readCheck) logged(pos int, block *Block) {
+	if err := c.verify(pos, block); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestVerifyOnReadDetectsMutation(t *testing.T) {
+	bc := newFilledChain(t)
+	bc.SetVerifyOnRead(true)
+	if _, err := bc.BlockByIndex(3); err != nil {
+		t.Fatalf("an intact block failed to be read: %v", err)
+	}
+
+	// another component holding the chain's own copy changes a value
+	index := tamper(t, bc, 3, func(b *Block) { b.Values[0]++ })
+	block, err := bc.BlockByIndex(index)
+	if !errors.Is(err, ErrBlockCorrupted) || block != nil {
+		t.Fatalf("BlockByIndex returned %v, want ErrBlockCorrupted", err)
+	}
+	if !strings.Contains(err.Error(), "Block "+strconv.Itoa(index)) {
+		t.Fatalf("error %q does not name block %d", err, index)
+	}
+	id := bc.snapshot()[index].ID
+	if _, err := bc.BlockByID(id); !errors.Is(err, ErrBlockCorrupted) {
+		t.Fatalf("BlockByID returned %v, want ErrBlockCorrupted", err)
+	}
+
+	// the reads that cannot fail log it and still return every block
+	logged := captureLog(t)
+	if blocks := bc.Blocks(); len(blocks) != bc.Length() {
+		t.Fatalf("Blocks returned %d blocks, want %d", len(blocks), bc.Length())
+	}
+	count := 0
+	bc.Iterate(func(*Block) bool { count++; return true })
+	if lines := strings.Count(logged.String(), ErrBlockCorrupted.Error()); lines != 2 || count != bc.Length() {
+		t.Fatalf("Blocks and Iterate logged %d corrupted blocks and iterated %d, want 2 and %d:\n%s", lines, count, bc.Length(), logged)
+	}
+}
+
+func TestVerifyOnReadChecksHead(t *testing.T) {
+	bc := newFilledChain(t)
+	bc.SetVerifyOnRead(true)
+	logged := captureLog(t)
+	bc.LatestBlock()
+	if logged.Len() != 0 {
+		t.Fatalf("reading an intact head logged %q", logged)
+	}
+	bc.mu.Lock()
+	bc.head.Text = "verändert"
+	bc.mu.Unlock()
+	bc.LatestBlock()
+	if !strings.Contains(logged.String(), ErrBlockCorrupted.Error()) {
+		t.Fatalf("a changed head was not logged, log: %q", logged)
+	}
+}
+
+func TestVerifyOnReadOff(t *testing.T) {
+	bc := newFilledChain(t)
+	index := tamper(t, bc, 3, func(b *Block) { b.Values[0]++ })
+	if _, err := bc.BlockByIndex(index); err != nil {
+		t.Fatalf("BlockByIndex checked the hash without VerifyOnRead: %v", err)
+	}
+	expectInvalidAt(t, bc, index)
+}
+
+func TestAccessorsReturnDeepCopies(t *testing.T) {
+	bc := newFilledChain(t)
+	bc.SetVerifyOnRead(true)
+	block, err := bc.BlockByIndex(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Values[0]++
+	block.Outliers = append(block.Outliers, 99)
+	bc.Blocks()[3].Values[0]++
+	bc.LatestBlock().Values[0]++
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("changing returned blocks changed the chain: %v", err)
+	}
+	if _, err := bc.BlockByIndex(2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkVerifyOnRead shows the overhead of rehashing the blocks of 100
+// values that BlockByIndex and Blocks return
+func BenchmarkVerifyOnRead(b *testing.B) {
+	bc, err := NewBlockchain()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := bc.AddBlocksBulk(bulkRows(1000, 100), 0); err != nil {
+		b.Fatal(err)
+	}
+	for _, verify := range []bool{false, true} {
+		bc.SetVerifyOnRead(verify)
+		name := "verify=" + strconv.FormatBool(verify)
+		b.Run("BlockByIndex/"+name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := bc.BlockByIndex(1 + i%1000); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run("Blocks/"+name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bc.Blocks()
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// statsTolerance is the relative difference up to which a stored stat
+// still matches its recomputed value
+const statsTolerance = 1e-9
+
+// ErrStatsMismatch is matched by every *StatsMismatchError
+var ErrStatsMismatch = errors.New("Statistik passt nicht zu den Werten")
+
+// StatsMismatchError names the first stat of a block that differs from
+// the value recomputed from the block's values
+type StatsMismatchError struct {
+	Field    string
+	Stored   any
+	Computed any
+	// Diff is the absolute difference of numeric fields, NaN otherwise
+	Diff float64
+}
+
+func (e *StatsMismatchError) Error() string {
+	if math.IsNaN(e.Diff) {
+		return fmt.Sprintf("%s weicht ab: gespeichert %v, berechnet %v", e.Field, e.Stored, e.Computed)
+	}
+	return fmt.Sprintf("%s weicht ab: gespeichert %v, berechnet %v (Differenz %g)", e.Field, e.Stored, e.Computed, e.Diff)
+}
+
+func (e *StatsMismatchError) Is(target error) bool {
+	return target == ErrStatsMismatch
+}
+
+// VerifyStats recomputes the stats of the block from a copy of its values,
+// with the outlier method it records. It returns a *StatsMismatchError
+// for the first stored stat that differs by more than statsTolerance, or
+// an error matching ErrStatsMismatch for an unknown outlier method.
+// Stats whose stage failed when the block was added are not checked.
+// Sampled blocks no longer hold all their values and blocks whose values
+// were dropped hold none; both are accepted as they are.
+func (b *Block) VerifyStats() error {
+	if b.Sampled || b.ValuesDropped {
+		return nil
+	}
+	cfg := DefaultOutlierConfig
+	if b.OutlierMethod != "" {
+		var err error
+		if cfg, err = parseOutlierMethod(b.OutlierMethod); err != nil {
+			return fmt.Errorf("%w: %v", ErrStatsMismatch, err)
+		}
+	}
+
+	values := b.Values
+	if b.ValueEncoding != "" {
+		var err error
+		if values, err = decodeValues(b.ValueEncoding, b.EncodedValues); err != nil {
+			return fmt.Errorf("%w: %v", ErrStatsMismatch, err)
+		}
+	}
+	computed := &Block{Kind: b.Kind, Values: slices.Clone(values), IntValues: slices.Clone(b.IntValues)}
+	if b.Kind == KindInt {
+		if statsFailed(b, StageIntStats) {
+			return nil
+		}
+		calculateIntStats(computed, cfg)
+		floats := make([]float64, len(b.IntValues))
+		for i, v := range b.IntValues {
+			floats[i] = float64(v)
+		}
+		summarizeValues(computed, floats, len(b.Histogram))
+	} else {
+		calculateBlockStats(computed, cfg, len(b.Histogram))
+	}
+
+	skip := func(stages ...string) bool {
+		return slices.ContainsFunc(stages, func(stage string) bool { return statsFailed(b, stage) })
+	}
+	checks := []struct {
+		field            string
+		stored, computed float64
+		skip             bool
+	}{
+		{"Mean", b.Mean, computed.Mean, skip(StageMean)},
+		{"Median", b.Median, computed.Median, skip(StageMedian)},
+		{"TwoSDLower", b.TwoSDLower, computed.TwoSDLower, skip(StageMean, StageTwoSD)},
+		{"TwoSDUpper", b.TwoSDUpper, computed.TwoSDUpper, skip(StageMean, StageTwoSD)},
+		{"LowerBound", b.LowerBound, computed.LowerBound, skip(StageMean, StageTwoSD, StageOutliers)},
+		{"UpperBound", b.UpperBound, computed.UpperBound, skip(StageMean, StageTwoSD, StageOutliers)},
+	}
+	for _, check := range checks {
+		if !check.skip && !statsMatch(check.stored, check.computed) {
+			return &StatsMismatchError{Field: check.field, Stored: check.stored, Computed: check.computed, Diff: math.Abs(check.stored - check.computed)}
+		}
+	}
+
+	if b.Kind == KindInt {
+		stored, want := b.IntStats, computed.IntStats
+		switch {
+		case stored == nil:
+			return &StatsMismatchError{Field: "IntStats", Stored: nil, Computed: *want, Diff: math.NaN()}
+		case stored.Min != want.Min:
+			return &StatsMismatchError{Field: "IntStats.Min", Stored: stored.Min, Computed: want.Min, Diff: math.Abs(float64(stored.Min) - float64(want.Min))}
+		case stored.Max != want.Max:
+			return &StatsMismatchError{Field: "IntStats.Max", Stored: stored.Max, Computed: want.Max, Diff: math.Abs(float64(stored.Max) - float64(want.Max))}
+		case stored.Sum == nil || stored.Sum.Cmp(want.Sum) != 0:
+			return &StatsMismatchError{Field: "IntStats.Sum", Stored: stored.Sum, Computed: want.Sum, Diff: math.NaN()}
+		case stored.Median != want.Median || stored.MedianExact != want.MedianExact:
+			return &StatsMismatchError{Field: "IntStats.Median", Stored: stored.Median, Computed: want.Median, Diff: math.NaN()}
+		}
+		if !slices.Equal(b.IntOutliers, computed.IntOutliers) {
+			return &StatsMismatchError{Field: "IntOutliers", Stored: b.IntOutliers, Computed: computed.IntOutliers, Diff: math.NaN()}
+		}
+	} else if !skip(StageMean, StageTwoSD, StageOutliers) && !slices.Equal(b.Outliers, computed.Outliers) {
+		return &StatsMismatchError{Field: "Outliers", Stored: b.Outliers, Computed: computed.Outliers, Diff: math.NaN()}
+	}
+	if !skip(StageSummary) {
+		for _, p := range summaryPercentiles {
+			key := percentileKey(p)
+			stored, ok := b.Percentiles[key]
+			if !ok {
+				return &StatsMismatchError{Field: "Percentiles." + key, Stored: nil, Computed: computed.Percentiles[key], Diff: math.NaN()}
+			}
+			if !statsMatch(stored, computed.Percentiles[key]) {
+				return &StatsMismatchError{Field: "Percentiles." + key, Stored: stored, Computed: computed.Percentiles[key], Diff: math.Abs(stored - computed.Percentiles[key])}
+			}
+		}
+		if !slices.Equal(b.Histogram, computed.Histogram) {
+			return &StatsMismatchError{Field: "Histogram", Stored: b.Histogram, Computed: computed.Histogram, Diff: math.NaN()}
+		}
+	}
+	if b.Kind != KindInt {
+		if root := merkleRoot(values); root != b.MerkleRoot {
+			return &StatsMismatchError{Field: "MerkleRoot", Stored: b.MerkleRoot, Computed: root, Diff: math.NaN()}
+		}
+	}
+	if b.ValueTimes != nil && len(b.ValueTimes) != len(values) {
+		return &StatsMismatchError{Field: "ValueTimes", Stored: len(b.ValueTimes), Computed: len(values), Diff: math.NaN()}
+	}
+	if hasOutliers := b.OutlierCount() > 0; b.HasOutliers != hasOutliers {
+		return &StatsMismatchError{Field: "HasOutliers", Stored: b.HasOutliers, Computed: hasOutliers, Diff: math.NaN()}
+	}
+	return nil
+}
+
+// statsMatch reports whether a stored stat matches its recomputed value
+// within statsTolerance, relative to the larger of the two. NaN matches
+// NaN, as for blocks without values.
+func statsMatch(stored, computed float64) bool {
+	if math.IsNaN(stored) || math.IsNaN(computed) {
+		return math.IsNaN(stored) && math.IsNaN(computed)
+	}
+	if stored == computed {
+		return true
+	}
+	scale := math.Max(1, math.Max(math.Abs(stored), math.Abs(computed)))
+	return math.Abs(stored-computed) <= statsTolerance*scale
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestVerifyStatsMismatch(t *testing.T) {
+	bc := newFilledChain(t)
+	stored, err := bc.BlockByIndex(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stored.VerifyStats(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		field  string
+		change func(*Block)
+	}{
+		{"Mean", func(b *Block) { b.Mean += 0.5 }},
+		{"Median", func(b *Block) { b.Median = -b.Median - 1 }},
+		{"TwoSDUpper", func(b *Block) { b.TwoSDUpper *= 2 }},
+		{"Outliers", func(b *Block) { b.Outliers = append(b.Outliers, b.Values[0]) }},
+		{"Histogram", func(b *Block) { b.Histogram = append(b.Histogram, 1) }},
+		{"HasOutliers", func(b *Block) { b.HasOutliers = !b.HasOutliers }},
+	}
+	for _, tt := range tests {
+		block := copyBlock(stored)
+		tt.change(block)
+		err := block.VerifyStats()
+		var mismatch *StatsMismatchError
+		if !errors.As(err, &mismatch) || mismatch.Field != tt.field || !errors.Is(err, ErrStatsMismatch) {
+			t.Fatalf("changed %s returned %v", tt.field, err)
+		}
+	}
+
+	block := copyBlock(stored)
+	block.Mean += 0.5
+	err = block.VerifyStats()
+	var mismatch *StatsMismatchError
+	if !errors.As(err, &mismatch) || !near(mismatch.Diff, 0.5) {
+		t.Fatalf("mean off by 0.5 returned %v", err)
+	}
+
+	// within the tolerance, in stages that failed and in sampled blocks
+	// a stat may differ
+	block = copyBlock(stored)
+	block.Mean *= 1 + statsTolerance/10
+	if err := block.VerifyStats(); err != nil {
+		t.Fatalf("mean within the tolerance returned %v", err)
+	}
+	block = copyBlock(stored)
+	block.Median++
+	block.StatsErrors = map[string]string{StageMedian: "panic"}
+	if err := block.VerifyStats(); err != nil {
+		t.Fatalf("median of a failed stage returned %v", err)
+	}
+	block = copyBlock(stored)
+	block.Mean++
+	block.Sampled = true
+	if err := block.VerifyStats(); err != nil {
+		t.Fatalf("sampled block returned %v", err)
+	}
+
+	block = copyBlock(stored)
+	block.OutlierMethod = "mad"
+	if err := block.VerifyStats(); !errors.Is(err, ErrStatsMismatch) {
+		t.Fatalf("unknown outlier method returned %v", err)
+	}
+}
+
+func TestStatsMatch(t *testing.T) {
+	tests := []struct {
+		stored, computed float64
+		match            bool
+	}{
+		{1, 1, true},
+		{1, 1 + 1e-12, true},
+		{1, 1 + 1e-6, false},
+		{1e12, 1e12 + 1, true},
+		{0, 1e-10, true},
+		{math.NaN(), math.NaN(), true},
+		{math.NaN(), 0, false},
+		{0, math.NaN(), false},
+	}
+	for _, tt := range tests {
+		if got := statsMatch(tt.stored, tt.computed); got != tt.match {
+			t.Fatalf("statsMatch(%v, %v) = %v", tt.stored, tt.computed, got)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultWebhookTimeout bounds a single delivery
+	defaultWebhookTimeout = 10 * time.Second
+	// defaultWebhookBackoff is the wait before the first retry
+	defaultWebhookBackoff = time.Second
+)
+
+// webhookSignatureHeader carries the signature of a notification, see
+// WebhookConfig.Secret
+const webhookSignatureHeader = "X-Signature-256"
+
+// WebhookConfig configures a WebhookNotifier
+type WebhookConfig struct {
+	// URL receives a POST for every block with outliers or an anomaly
+	URL string
+	// Token, if set, is sent as "Authorization: Bearer <Token>"
+	Token string
+	// Secret, if set, signs every notification: the X-Signature-256
+	// header holds "sha256=" and the hex HMAC-SHA256 of the body under
+	// Secret, so the receiver can check where it came from
+	Secret string
+	// Timeout bounds each attempt; 0 is defaultWebhookTimeout
+	Timeout time.Duration
+	// Retries is how often a failed delivery is tried again. The first
+	// retry waits Backoff, 0 for defaultWebhookBackoff, and every further
+	// one twice as long as the one before.
+	Retries int
+	Backoff time.Duration
+	// DeadLetters, if set, keeps the notifications given up after the
+	// last retry, to be replayed with ReplayDeadLetters
+	DeadLetters *DeadLetterStore
+}
+
+// Validate checks the URL and that the durations and retries are not
+// negative
+func (c WebhookConfig) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Ungültige Webhook-URL: %q", c.URL)
+	}
+	if c.Timeout < 0 || c.Backoff < 0 || c.Retries < 0 {
+		return fmt.Errorf("Ungültige Webhook-Einstellungen: Timeout %v, %d Wiederholungen nach %v", c.Timeout, c.Retries, c.Backoff)
+	}
+	return nil
+}
+
+// webhookPayload is the JSON body of a notification. Mean is null for a
+// block without values.
+type webhookPayload struct {
+	Chain     string    `json:"chain"`
+	Index     int       `json:"index"`
+	Timestamp time.Time `json:"timestamp"`
+	Mean      *float64  `json:"mean"`
+	Outliers  int       `json:"outliers"`
+	Anomalous bool      `json:"anomalous"`
+}
+
+// WebhookStats counts the notifications of a WebhookNotifier
+type WebhookStats struct {
+	// Delivered counts the notifications the URL accepted with a 2xx
+	// status, Failed those given up after the last retry
+	Delivered int64
+	Failed    int64
+	// Dropped counts the notifications lost because deliveries fell more
+	// than subscriberBuffer blocks behind
+	Dropped int64
+}
+
+func (s WebhookStats) String() string {
+	return fmt.Sprintf("Webhook: %d zugestellt, %d fehlgeschlagen, %d verworfen", s.Delivered, s.Failed, s.Dropped)
+}
+
+// WebhookNotifier posts a JSON notification to a URL for every block
+// appended with outliers or marked Anomalous. It subscribes to the chain,
+// so AddBlock never waits for a delivery: notifications are sent one at a
+// time from their own goroutine, and once subscriberBuffer of them are
+// pending the oldest is dropped and counted.
+type WebhookNotifier struct {
+	cfg    WebhookConfig
+	chain  string
+	client *http.Client
+
+	unsubscribe func()
+	done        chan struct{}
+	// closing ends the waits between retries once Close was called
+	closing   chan struct{}
+	closeOnce sync.Once
+	// replayMu lets one replay of dead letters run at a time
+	replayMu sync.Mutex
+
+	delivered atomic.Int64
+	failed    atomic.Int64
+	dropped   atomic.Int64
+}
+
+// NewWebhookNotifier validates cfg and starts notifying about the blocks
+// appended to bc from now on
+func NewWebhookNotifier(bc *Blockchain, cfg WebhookConfig) (*WebhookNotifier, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultWebhookTimeout
+	}
+	if cfg.Backoff == 0 {
+		cfg.Backoff = defaultWebhookBackoff
+	}
+
+	events, unsubscribe := bc.SubscribeFiltered(func(b *Block) bool { return b.HasOutliers || b.Anomalous })
+	n := &WebhookNotifier{
+		cfg:         cfg,
+		chain:       bc.Name(),
+		client:      &http.Client{Timeout: cfg.Timeout},
+		unsubscribe: unsubscribe,
+		done:        make(chan struct{}),
+		closing:     make(chan struct{}),
+	}
+	go n.run(events)
+	return n, nil
+}
+
+// Stats returns the counters of the notifier
+func (n *WebhookNotifier) Stats() WebhookStats {
+	return WebhookStats{
+		Delivered: n.delivered.Load(),
+		Failed:    n.failed.Load(),
+		Dropped:   n.dropped.Load(),
+	}
+}
+
+// Close ends the subscription and waits until the notifications still
+// pending are delivered. Retries still due are tried at once rather than
+// after their backoff, so Close takes at most the timeout of each attempt
+// left.
+func (n *WebhookNotifier) Close() {
+	n.closeOnce.Do(func() {
+		n.unsubscribe()
+		close(n.closing)
+	})
+	<-n.done
+}
+
+// run delivers the events until the subscription ends
+func (n *WebhookNotifier) run(events <-chan BlockEvent) {
+	defer close(n.done)
+	for event := range events {
+		n.dropped.Store(int64(event.Dropped))
+		body, attempts, err := n.deliver(event)
+		if err != nil {
+			n.failed.Add(1)
+			log.Printf("Webhook für Block %d nicht zugestellt: %v", event.Index, err)
+			n.keepDeadLetter(event.Index, body, attempts, err)
+			continue
+		}
+		n.delivered.Add(1)
+	}
+}
+
+// keepDeadLetter stores a notification given up after attempts in the
+// dead letter store, if there is one
+func (n *WebhookNotifier) keepDeadLetter(index int, body []byte, attempts int, err error) {
+	if n.cfg.DeadLetters == nil || body == nil {
+		return
+	}
+	letter := DeadLetter{
+		Target:    n.cfg.URL,
+		Index:     index,
+		Payload:   body,
+		Attempts:  attempts,
+		LastError: err.Error(),
+		FailedAt:  time.Now(),
+	}
+	if err := n.cfg.DeadLetters.add(letter); err != nil {
+		log.Printf("Webhook für Block %d konnte nicht abgelegt werden: %v", index, err)
+	}
+}
+
+// deliver posts the notification of event, retrying as cfg allows, and
+// returns its body and the number of attempts
+func (n *WebhookNotifier) deliver(event BlockEvent) ([]byte, int, error) {
+	payload := webhookPayload{
+		Chain:     n.chain,
+		Index:     event.Index,
+		Timestamp: event.Timestamp,
+		Outliers:  event.Outliers,
+		Anomalous: event.Anomalous,
+	}
+	if !math.IsNaN(event.Mean) {
+		payload.Mean = &event.Mean
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	wait := n.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		err = n.post(n.cfg.URL, body)
+		var permanent *webhookStatusError
+		if err == nil || attempt == n.cfg.Retries || errors.As(err, &permanent) && !permanent.retryable() {
+			return body, attempt + 1, err
+		}
+		select {
+		case <-time.After(wait):
+		case <-n.closing:
+		}
+		wait *= 2
+	}
+}
+
+// post sends body to target once
+func (n *WebhookNotifier) post(target string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.cfg.Token)
+	}
+	if n.cfg.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, signWebhookBody(n.cfg.Secret, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &webhookStatusError{status: resp.StatusCode}
+	}
+	return nil
+}
+
+// webhookStatusError is the error of a delivery the URL answered with a
+// status other than 2xx
+type webhookStatusError struct {
+	status int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("Antwort %d %s", e.status, http.StatusText(e.status))
+}
+
+// retryable reports whether the status may go away on another attempt, as
+// with errors of the server and rate limits; other client errors will not
+func (e *webhookStatusError) retryable() bool {
+	return e.status >= 500 || e.status == http.StatusTooManyRequests || e.status == http.StatusRequestTimeout
+}
+
+// signWebhookBody returns the value of webhookSignatureHeader for body
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// readWebhookFile returns the token or secret in the file at path, for
+// main's -webhook-token-file and -webhook-secret-file, which keep them
+// out of the process list
+func readWebhookFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("Datei %s ist leer", path)
+	}
+	return value, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a test server answering the notifications it gets
+// with the statuses in order, then with 200
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	times    []time.Time
+}
+
+func (rv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rv.mu.Lock()
+	attempt := len(rv.requests)
+	rv.requests = append(rv.requests, r)
+	rv.bodies = append(rv.bodies, body)
+	rv.times = append(rv.times, time.Now())
+	status := http.StatusOK
+	if attempt < len(rv.statuses) {
+		status = rv.statuses[attempt]
+	}
+	rv.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+// attempts returns how many notifications the receiver got
+func (rv *webhookReceiver) attempts() int {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	return len(rv.requests)
+}
+
+// first returns the first notification the receiver got and its body
+func (rv *webhookReceiver) first(t *testing.T) (*http.Request, []byte) {
+	t.Helper()
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	if len(rv.requests) == 0 {
+		t.Fatal("receiver got no notification")
+	}
+	return rv.requests[0], rv.bodies[0]
+}
+
+// gaps returns the time between each notification and the one before
+func (rv *webhookReceiver) gaps() []time.Duration {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	var gaps []time.Duration
+	for i := 1; i < len(rv.times); i++ {
+		gaps = append(gaps, rv.times[i].Sub(rv.times[i-1]))
+	}
+	return gaps
+}
+
+// notifyOutlier starts a notifier for cfg on a new chain, adds a block
+// with outliers, closes the notifier and returns its stats
+func notifyOutlier(t *testing.T, cfg WebhookConfig) (*Blockchain, WebhookStats) {
+	t.Helper()
+	bc, err := NewBlockchain(WithChainConfig(ChainConfig{Name: "alarm"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier, err := NewWebhookNotifier(bc, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock(chainTestValues[1]); err != nil {
+		t.Fatal(err)
+	}
+	// the retries of a closed notifier no longer wait, so wait for the
+	// deliveries here to see the backoff
+	deadline := time.Now().Add(5 * time.Second)
+	for stats := notifier.Stats(); stats.Delivered+stats.Failed == 0 && time.Now().Before(deadline); stats = notifier.Stats() {
+		time.Sleep(time.Millisecond)
+	}
+	notifier.Close()
+	return bc, notifier.Stats()
+}
+
+func TestWebhookDelivers(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	bc, stats := notifyOutlier(t, WebhookConfig{URL: server.URL, Token: "geheim", Secret: "schlüssel"})
+	if stats != (WebhookStats{Delivered: 1}) {
+		t.Fatalf("stats are %+v, want one delivery", stats)
+	}
+	if receiver.attempts() != 1 {
+		t.Fatalf("receiver got %d notifications, want 1 for the outlier block only", receiver.attempts())
+	}
+	req, body := receiver.first(t)
+	if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("notification is %s with Content-Type %q, want a JSON POST", req.Method, req.Header.Get("Content-Type"))
+	}
+	if auth := req.Header.Get("Authorization"); auth != "Bearer geheim" {
+		t.Fatalf("Authorization is %q, want the bearer token", auth)
+	}
+	if sig := req.Header.Get(webhookSignatureHeader); sig != signWebhookBody("schlüssel", body) {
+		t.Fatalf("%s is %q, want the HMAC of the body", webhookSignatureHeader, sig)
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	block := bc.LatestBlock()
+	if payload.Chain != "alarm" || payload.Index != block.Index || payload.Outliers != len(block.Outliers) ||
+		payload.Mean == nil || *payload.Mean != block.Mean || !payload.Timestamp.Equal(block.Timestamp) {
+		t.Fatalf("payload is %s, want block %d of chain alarm", body, block.Index)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	notifyOutlier(t, WebhookConfig{URL: server.URL})
+	req, _ := receiver.first(t)
+	if req.Header.Get(webhookSignatureHeader) != "" || req.Header.Get("Authorization") != "" {
+		t.Fatal("a notifier without token and secret sent Authorization or a signature")
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusBadGateway}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	const backoff = 20 * time.Millisecond
+	_, stats := notifyOutlier(t, WebhookConfig{URL: server.URL, Retries: 3, Backoff: backoff})
+	if stats != (WebhookStats{Delivered: 1}) || receiver.attempts() != 4 {
+		t.Fatalf("stats are %+v after %d attempts, want one delivery after 4", stats, receiver.attempts())
+	}
+	for i, gap := range receiver.gaps() {
+		if want := backoff << i; gap < want {
+			t.Fatalf("retry %d came after %v, want at least %v", i+1, gap, want)
+		}
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		statuses []int
+		attempts int
+	}{
+		{"after the retries", []int{500, 500, 500}, 3},
+		{"on a client error", []int{400, 500, 500}, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			receiver := &webhookReceiver{statuses: tc.statuses}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			_, stats := notifyOutlier(t, WebhookConfig{URL: server.URL, Retries: 2, Backoff: time.Millisecond})
+			if stats != (WebhookStats{Failed: 1}) || receiver.attempts() != tc.attempts {
+				t.Fatalf("stats are %+v after %d attempts, want one failure after %d", stats, receiver.attempts(), tc.attempts)
+			}
+		})
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	start := time.Now()
+	_, stats := notifyOutlier(t, WebhookConfig{URL: server.URL, Timeout: 50 * time.Millisecond})
+	if stats != (WebhookStats{Failed: 1}) {
+		t.Fatalf("stats are %+v, want the delivery failed by its timeout", stats)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("delivery took %v, want it cut off after the timeout", elapsed)
+	}
+}
+
+func TestWebhookConfigValidate(t *testing.T) {
+	for _, cfg := range []WebhookConfig{
+		{URL: "ftp://example.com/hook"},
+		{URL: "http://"},
+		{URL: "https://example.com/hook", Retries: -1},
+		{URL: "https://example.com/hook", Timeout: -time.Second},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("config %+v was accepted", cfg)
+		}
+	}
+}
+
+func TestWebhookDoesNotBlockAppends(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+		<-release
+	}))
+	defer server.Close()
+
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier, err := NewWebhookNotifier(bc, WebhookConfig{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock(chainTestValues[1]); err != nil {
+		t.Fatal(err)
+	}
+	<-arrived
+
+	// with the first delivery hanging, subscriberBuffer notifications
+	// wait and the rest are dropped, but every append returns at once
+	const more = subscriberBuffer + 15
+	start := time.Now()
+	for range more {
+		if _, err := bc.AddBlock(chainTestValues[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("appends took %v while the receiver hung", elapsed)
+	}
+	close(release)
+	notifier.Close()
+
+	want := WebhookStats{Delivered: 1 + subscriberBuffer, Dropped: 15}
+	if stats := notifier.Stats(); stats != want {
+		t.Fatalf("stats are %+v, want %+v", stats, want)
+	}
+}
+
+func TestWebhookCloseDrains(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		receiver.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier, err := NewWebhookNotifier(bc, WebhookConfig{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 5 {
+		if _, err := bc.AddBlock(chainTestValues[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notifier.Close()
+	if stats := notifier.Stats(); stats != (WebhookStats{Delivered: 5}) || receiver.attempts() != 5 {
+		t.Fatalf("stats after Close are %+v with %d notifications, want all 5 delivered", stats, receiver.attempts())
+	}
+
+	// blocks appended after Close are not notified
+	if _, err := bc.AddBlock(chainTestValues[1]); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if receiver.attempts() != 5 {
+		t.Fatalf("receiver got %d notifications after Close", receiver.attempts())
+	}
+}
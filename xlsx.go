@@ -0,0 +1,253 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// xlsxWorkbook is the part of xl/workbook.xml that lists the sheets
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// xlsxRelationships is xl/_rels/workbook.xml.rels, which maps the sheets
+// of the workbook to their files
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxCell is a <c> element of a worksheet. Only the type matters for
+// text cells, so the shared strings are not read.
+type xlsxCell struct {
+	Ref     string  `xml:"r,attr"`
+	Type    string  `xml:"t,attr"`
+	Formula *string `xml:"f"`
+	Value   *string `xml:"v"`
+}
+
+// streamXLSX implements streamSourceRows for the first worksheet of an
+// Excel workbook. Line is the row number of the sheet. Only cells holding
+// a number are read: cells with text, a formula, a boolean or an error
+// are skipped and reported in sourceRow.Warnings. Rows before the first
+// number that hold cells but no number name the columns and are skipped
+// without a warning, as is the first row with hasHeader. columns, counted from 1
+// as A is, selects columns; a selected cell without a number fails its
+// row.
+func streamXLSX(file *os.File, hasHeader bool, columns []int, fn func(sourceRow) error) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return fmt.Errorf("Keine Excel-Datei: %w", err)
+	}
+	sheet, err := xlsxFirstSheet(archive)
+	if err != nil {
+		return err
+	}
+	r, err := sheet.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	decoder := xml.NewDecoder(r)
+	inHeader := true
+	line := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Arbeitsblatt %s: %w", sheet.Name, err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		line++
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "r" {
+				if n, err := strconv.Atoi(attr.Value); err == nil {
+					line = n
+				}
+			}
+		}
+		row, numbers, err := readXLSXRow(decoder, line, columns)
+		if err != nil {
+			return fmt.Errorf("Arbeitsblatt %s: %w", sheet.Name, err)
+		}
+		if inHeader && (hasHeader || numbers == 0 && len(row.Warnings) > 0) {
+			hasHeader = false
+			continue
+		}
+		if numbers > 0 {
+			inHeader = false
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+// readXLSXRow reads the cells of the row element just started and returns
+// the row with the number of numeric cells it holds
+func readXLSXRow(decoder *xml.Decoder, line int, columns []int) (sourceRow, int, error) {
+	row := sourceRow{Line: line}
+	cells := map[int]float64{}
+	var order []int
+	col := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return row, 0, err
+		}
+		if end, ok := token.(xml.EndElement); ok && end.Name.Local == "row" {
+			break
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "c" {
+			continue
+		}
+		var cell xlsxCell
+		if err := decoder.DecodeElement(&cell, &start); err != nil {
+			return row, 0, err
+		}
+		col++
+		if n, ok := xlsxColumn(cell.Ref); ok {
+			col = n
+		}
+		ref := cell.Ref
+		if ref == "" {
+			ref = xlsxColumnName(col) + strconv.Itoa(line)
+		}
+
+		switch {
+		case cell.Formula != nil:
+			row.Warnings = append(row.Warnings, fmt.Sprintf("Zelle %s: Formel übersprungen", ref))
+		case cell.Type == "s" || cell.Type == "str" || cell.Type == "inlineStr":
+			row.Warnings = append(row.Warnings, fmt.Sprintf("Zelle %s: Text übersprungen", ref))
+		case cell.Type == "b" || cell.Type == "e":
+			row.Warnings = append(row.Warnings, fmt.Sprintf("Zelle %s: kein Zahlenwert übersprungen", ref))
+		case cell.Value == nil || strings.TrimSpace(*cell.Value) == "":
+			// an empty cell that only carries a style
+		default:
+			// numbers are stored with a decimal point whatever the locale
+			value, err := parseLocaleNumber(strings.TrimSpace(*cell.Value), LocaleEnglish)
+			if err != nil {
+				row.Warnings = append(row.Warnings, fmt.Sprintf("Zelle %s: %v", ref, err))
+				continue
+			}
+			cells[col] = value
+			order = append(order, col)
+		}
+	}
+
+	if len(columns) == 0 {
+		for _, c := range order {
+			row.Values = append(row.Values, cells[c])
+		}
+		return row, len(order), nil
+	}
+	if len(order) == 0 {
+		return row, 0, nil
+	}
+	for _, c := range columns {
+		value, ok := cells[c]
+		if !ok {
+			row.Values, row.Err = nil, fmt.Errorf("keine Zahl in Spalte %s", xlsxColumnName(c))
+			break
+		}
+		row.Values = append(row.Values, value)
+	}
+	return row, len(order), nil
+}
+
+// xlsxFirstSheet finds the file of the first sheet listed by the workbook
+func xlsxFirstSheet(archive *zip.Reader) (*zip.File, error) {
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	var workbook xlsxWorkbook
+	if err := decodeXLSXPart(files, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, errors.New("Excel-Datei enthält kein Arbeitsblatt")
+	}
+	var rels xlsxRelationships
+	if err := decodeXLSXPart(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	first := workbook.Sheets[0]
+	for _, rel := range rels.Relationships {
+		if rel.ID != first.ID {
+			continue
+		}
+		// targets are relative to xl/ unless they start with /
+		name := strings.TrimPrefix(rel.Target, "/")
+		if !strings.HasPrefix(rel.Target, "/") {
+			name = path.Join("xl", rel.Target)
+		}
+		if sheet, ok := files[name]; ok {
+			return sheet, nil
+		}
+		return nil, fmt.Errorf("Arbeitsblatt %s fehlt in der Excel-Datei (%s)", first.Name, name)
+	}
+	return nil, fmt.Errorf("Arbeitsblatt %s fehlt in der Excel-Datei", first.Name)
+}
+
+// decodeXLSXPart decodes the XML file name of the archive into v
+func decodeXLSXPart(files map[string]*zip.File, name string, v any) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("Keine Excel-Datei: %s fehlt", name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := xml.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("Excel-Datei: %s: %w", name, err)
+	}
+	return nil
+}
+
+// xlsxColumn returns the column of a cell reference such as B3, counted
+// from 1
+func xlsxColumn(ref string) (int, bool) {
+	col := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+	}
+	return col, col > 0
+}
+
+// xlsxColumnName returns the letters of column col, counted from 1
+func xlsxColumnName(col int) string {
+	var name []byte
+	for ; col > 0; col = (col - 1) / 26 {
+		name = append([]byte{byte('A' + (col-1)%26)}, name...)
+	}
+	return string(name)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeXLSX writes a workbook whose first sheet holds the rows of sheet
+// and whose second sheet holds other, each row being the XML of its cells
+func writeXLSX(t *testing.T, sheet, other []string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "messung.xlsx")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	worksheet := func(rows []string) string {
+		var b strings.Builder
+		b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+		for i, row := range rows {
+			// the second row has no r attribute and counts on from the first
+			if i == 1 {
+				b.WriteString(`<row>` + row + `</row>`)
+				continue
+			}
+			b.WriteString(`<row r="` + strconv.Itoa(i+1) + `">` + row + `</row>`)
+		}
+		b.WriteString(`</sheetData></worksheet>`)
+		return b.String()
+	}
+	parts := []struct{ name, data string }{
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8"?><workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Messung" sheetId="1" r:id="rId2"/><sheet name="Notizen" sheetId="2" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8"?><Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="/xl/worksheets/sheet2.xml"/></Relationships>`},
+		{"xl/worksheets/sheet1.xml", worksheet(other)},
+		{"xl/worksheets/sheet2.xml", worksheet(sheet)},
+	}
+	archive := zip.NewWriter(file)
+	for _, part := range parts {
+		w, err := archive.Create(part.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(part.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// messySheet has a text header, a formula, text, a boolean, an error,
+// a styled empty cell and cells without a reference
+var messySheet = []string{
+	`<c r="A1" t="s"><v>0</v></c><c r="B1" t="inlineStr"><is><t>Temperatur</t></is></c>`,
+	`<c><v>1.5</v></c><c><v>2</v></c>`,
+	`<c r="A3"><v>3</v></c><c r="B3"><f>A3*2</f><v>6</v></c>`,
+	`<c r="A4"><v>4</v></c><c r="B4" t="str"><v>n/a</v></c><c r="C4" s="1"/>`,
+	`<c r="A5" t="b"><v>1</v></c><c r="B5"><v>5e1</v></c><c r="C5" t="e"><v>#DIV/0!</v></c>`,
+}
+
+func TestXLSXReadBack(t *testing.T) {
+	path := writeXLSX(t, messySheet, []string{`<c r="A1"><v>99</v></c>`})
+
+	// the format is inferred from the extension
+	rows, warnings, err := readDataFromExternalSource(path, "", LocaleEnglish, CSVImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sourceRow{
+		{Line: 2, Values: []float64{1.5, 2}},
+		{Line: 3, Values: []float64{3}},
+		{Line: 4, Values: []float64{4}},
+		{Line: 5, Values: []float64{50}},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("read %d rows, want %d: %+v", len(rows), len(want), rows)
+	}
+	for i, row := range rows {
+		if row.Line != want[i].Line || !slices.Equal(row.Values, want[i].Values) || row.Err != nil {
+			t.Fatalf("row %d is %+v, want %+v", i, row, want[i])
+		}
+	}
+	wantWarnings := []string{
+		"Zelle B3: Formel übersprungen",
+		"Zelle B4: Text übersprungen",
+		"Zelle A5: kein Zahlenwert übersprungen",
+		"Zelle C5: kein Zahlenwert übersprungen",
+	}
+	if !slices.Equal(warnings, wantWarnings) {
+		t.Fatalf("warnings are %q, want %q", warnings, wantWarnings)
+	}
+}
+
+func TestXLSXColumns(t *testing.T) {
+	path := writeXLSX(t, messySheet, nil)
+	var rows []sourceRow
+	err := streamSourceRows(path, "xlsx", LocaleEnglish, CSVImportOptions{}, []int{1}, func(row sourceRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// row 5 has no number in column A
+	if len(rows) != 4 || !slices.Equal(rows[0].Values, []float64{1.5}) || rows[3].Err == nil || !strings.Contains(rows[3].Err.Error(), "Spalte A") {
+		t.Fatalf("rows of column A are %+v", rows)
+	}
+}
+
+func TestXLSXRejected(t *testing.T) {
+	dir := t.TempDir()
+	notZip := filepath.Join(dir, "kaputt.xlsx")
+	if err := os.WriteFile(notZip, []byte("1,2,3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readDataFromExternalSource(notZip, "", LocaleEnglish, CSVImportOptions{}); err == nil || !strings.Contains(err.Error(), "Keine Excel-Datei") {
+		t.Fatalf("a file that is no zip gave %v", err)
+	}
+	unknown := filepath.Join(dir, "messung.ods")
+	if err := os.WriteFile(unknown, []byte("1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readDataFromExternalSource(unknown, "", LocaleEnglish, CSVImportOptions{}); err == nil {
+		t.Fatal("a file with an unknown extension was read")
+	}
+}